@@ -0,0 +1,85 @@
+package mdns
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// AddrOrder is the order of the A and AAAA records of a host name in
+// responses, as chosen by Config.AddrOrder.
+type AddrOrder int
+
+const (
+	// AddrZoneOrder keeps the address records in the order of the zone.
+	AddrZoneOrder AddrOrder = iota
+	// AddrIPv4First puts the A records before the AAAA records.
+	AddrIPv4First
+	// AddrIPv6First puts the AAAA records before the A records.
+	AddrIPv6First
+	// AddrRotate rotates the address records by one for each response, so
+	// that clients using the first address spread across the host's
+	// interfaces or replicas.
+	AddrRotate
+)
+
+// orderAddrs reorders the address records of each host name in recs as
+// Config.AddrOrder asks.  The address records keep the positions in recs
+// that they had, and the other records are left alone.
+func (s *Server) orderAddrs(recs []dns.RR) {
+	order := s.conf().AddrOrder
+	if order == AddrZoneOrder {
+		return
+	}
+	var groups map[string][]int // Positions of the addresses of each name
+	var names []string
+	for i, rr := range recs {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+		default:
+			continue
+		}
+		name := strings.ToLower(rr.Header().Name)
+		if groups == nil {
+			groups = make(map[string][]int)
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], i)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	var turn uint32
+	if order == AddrRotate {
+		turn = atomic.AddUint32(&s.rotation, 1) - 1
+	}
+	for _, name := range names {
+		positions := groups[name]
+		if len(positions) < 2 {
+			continue
+		}
+		addrs := make([]dns.RR, len(positions))
+		for i, pos := range positions {
+			addrs[i] = recs[pos]
+		}
+		switch order {
+		case AddrIPv4First, AddrIPv6First:
+			sort.SliceStable(addrs, func(i, j int) bool {
+				_, a4 := addrs[i].(*dns.A)
+				_, b4 := addrs[j].(*dns.A)
+				return a4 != b4 && a4 == (order == AddrIPv4First)
+			})
+		case AddrRotate:
+			n := int(turn % uint32(len(addrs)))
+			addrs = append(addrs[n:], addrs[:n]...)
+		}
+		for i, pos := range positions {
+			recs[pos] = addrs[i]
+		}
+	}
+}
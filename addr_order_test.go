@@ -0,0 +1,72 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_OrderAddrs(t *testing.T) {
+	a := func(ip string) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP(ip)}
+	}
+	aaaa := func(ip string) dns.RR {
+		return &dns.AAAA{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP(ip)}
+	}
+	srv := &dns.SRV{Hdr: dns.RR_Header{Name: "web._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET}, Target: "host.local."}
+	answers := func() []dns.RR {
+		return []dns.RR{srv, aaaa("fe80::1"), a("192.168.0.1"), a("10.0.0.1")}
+	}
+	addrs := func(recs []dns.RR) []string {
+		var out []string
+		for _, rr := range recs[1:] {
+			switch rr := rr.(type) {
+			case *dns.A:
+				out = append(out, rr.A.String())
+			case *dns.AAAA:
+				out = append(out, rr.AAAA.String())
+			}
+		}
+		return out
+	}
+	check := func(order AddrOrder, recs []dns.RR, want ...string) {
+		t.Helper()
+		if recs[0] != srv {
+			t.Errorf("%v: SRV record moved: %v", order, recs)
+		}
+		got := addrs(recs)
+		for i := range want {
+			if i >= len(got) || got[i] != want[i] {
+				t.Errorf("%v: addresses in order %v, want %v", order, got, want)
+				return
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		order AddrOrder
+		want  []string
+	}{
+		{AddrZoneOrder, []string{"fe80::1", "192.168.0.1", "10.0.0.1"}},
+		{AddrIPv4First, []string{"192.168.0.1", "10.0.0.1", "fe80::1"}},
+		{AddrIPv6First, []string{"fe80::1", "192.168.0.1", "10.0.0.1"}},
+	} {
+		s := &Server{config: &Config{AddrOrder: test.order}}
+		recs := answers()
+		s.orderAddrs(recs)
+		check(test.order, recs, test.want...)
+	}
+
+	s := &Server{config: &Config{AddrOrder: AddrRotate}}
+	for _, want := range [][]string{
+		{"fe80::1", "192.168.0.1", "10.0.0.1"},
+		{"192.168.0.1", "10.0.0.1", "fe80::1"},
+		{"10.0.0.1", "fe80::1", "192.168.0.1"},
+		{"fe80::1", "192.168.0.1", "10.0.0.1"},
+	} {
+		recs := answers()
+		s.orderAddrs(recs)
+		check(AddrRotate, recs, want...)
+	}
+}
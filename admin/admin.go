@@ -0,0 +1,199 @@
+// Package admin provides an opt-in HTTP API for managing the services that a
+// Server publishes while it runs, so that sidecars and scripts can register
+// and withdraw advertisements without linking Go code.
+//
+// The API has no authentication of its own.  Serve it on a loopback address
+// or a Unix socket, or wrap it in a handler that checks credentials.
+//
+//     set, _ := mdns.NewServiceSet()
+//     server, _ := mdns.NewServer(&mdns.Config{Zone: set})
+//     http.Handle("/mdns/", http.StripPrefix("/mdns", admin.New(server, set)))
+//
+// The handler serves these requests, with services encoded as JSON objects
+// like {"instance": "web", "service": "_http._tcp", "port": 80, "txt": ["path=/"]}:
+//
+//     GET    /services               list the published services
+//     POST   /services               publish a service
+//     GET    /services/<name>        get a service by instance name
+//     PUT    /services/<name>/txt    replace a service's TXT record, given as {"txt": [...]}
+//     DELETE /services/<name>        withdraw a service
+//
+// where <name> is the fully qualified instance name, such as
+// "web._http._tcp.local.".
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/micro/mdns"
+)
+
+// Handler serves the admin API for a server whose zone is a ServiceSet.
+type Handler struct {
+	server   *mdns.Server
+	services *mdns.ServiceSet
+}
+
+// New returns a handler that manages the services of server, which must be
+// serving the given set.
+func New(server *mdns.Server, services *mdns.ServiceSet) *Handler {
+	return &Handler{server: server, services: services}
+}
+
+// service is the JSON form of a published service.
+type service struct {
+	Name     string   `json:"name,omitempty"` // Set in responses only
+	Instance string   `json:"instance"`
+	Service  string   `json:"service"`
+	Domain   string   `json:"domain,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port"`
+	IPs      []net.IP `json:"ips,omitempty"`
+	TXT      []string `json:"txt"`
+}
+
+func toJSON(s *mdns.MDNSService) *service {
+	txt := s.TXT
+	if txt == nil {
+		txt = []string{}
+	}
+	return &service{
+		Name:     s.InstanceName(),
+		Instance: s.Instance,
+		Service:  s.Service,
+		Domain:   s.Domain,
+		Host:     s.HostName,
+		Port:     s.Port,
+		IPs:      s.IPs,
+		TXT:      txt,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case path == "services":
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w)
+		case http.MethodPost:
+			h.register(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case strings.HasPrefix(path, "services/") && strings.HasSuffix(path, "/txt"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "services/"), "/txt")
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w, "PUT")
+			return
+		}
+		h.updateTXT(w, r, name)
+	case strings.HasPrefix(path, "services/"):
+		name := strings.TrimPrefix(path, "services/")
+		switch r.Method {
+		case http.MethodGet:
+			h.get(w, name)
+		case http.MethodDelete:
+			h.withdraw(w, name)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) list(w http.ResponseWriter) {
+	services := []*service{}
+	for _, s := range h.services.Services() {
+		services = append(services, toJSON(s))
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+func (h *Handler) get(w http.ResponseWriter, name string) {
+	s := h.services.Get(name)
+	if s == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no service %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(s))
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var req service
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad request body: %v", err))
+		return
+	}
+	s, err := mdns.NewMDNSService(req.Instance, req.Service, req.Domain, req.Host, req.Port, req.IPs, req.TXT)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.services.Add(s); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	h.server.Announce(s)
+	w.Header().Set("Location", "services/"+s.InstanceName())
+	writeJSON(w, http.StatusCreated, toJSON(s))
+}
+
+func (h *Handler) updateTXT(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		TXT []string `json:"txt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad request body: %v", err))
+		return
+	}
+	old := h.services.Get(name)
+	if old == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no service %s", name))
+		return
+	}
+	// Services are replaced rather than modified, as the server may be
+	// reading the old one.
+	s := *old
+	s.TXT = req.TXT
+	if _, err := h.services.Replace(&s); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	h.server.Announce(&s)
+	writeJSON(w, http.StatusOK, toJSON(&s))
+}
+
+func (h *Handler) withdraw(w http.ResponseWriter, name string) {
+	s := h.services.Remove(name)
+	if s == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no service %s", name))
+		return
+	}
+	if err := h.server.Withdraw(s); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("removed, but failed to send goodbye: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+func TestHandler(t *testing.T) {
+	set, err := mdns.NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer server.Shutdown()
+	ts := httptest.NewServer(New(server, set))
+	defer ts.Close()
+
+	do := func(method, path, body string, wantCode int) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.StatusCode != wantCode {
+			t.Fatalf("%s %s: got status %d, want %d", method, path, resp.StatusCode, wantCode)
+		}
+		return resp
+	}
+
+	do("POST", "/services", `{"instance": "web", "service": "_foobar._tcp", "host": "testhost.", "ips": ["192.168.0.42"], "port": 80, "txt": ["v=1"]}`, http.StatusCreated).Body.Close()
+	do("POST", "/services", `{"instance": "web", "service": "_foobar._tcp", "host": "testhost.", "ips": ["192.168.0.42"], "port": 80}`, http.StatusConflict).Body.Close()
+
+	e, err := mdns.Resolve(context.Background(), "web._foobar._tcp.local.", mdns.WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e.Port != 80 || len(e.InfoFields) != 1 || e.InfoFields[0] != "v=1" {
+		t.Fatalf("bad entry: %+v", e)
+	}
+
+	resp := do("PUT", "/services/web._foobar._tcp.local./txt", `{"txt": ["v=2"]}`, http.StatusOK)
+	var s service
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(s.TXT) != 1 || s.TXT[0] != "v=2" {
+		t.Fatalf("bad service: %+v", s)
+	}
+	if got := set.Get("web._foobar._tcp.local.").TXT; len(got) != 1 || got[0] != "v=2" {
+		t.Fatalf("TXT not updated: %v", got)
+	}
+
+	do("DELETE", "/services/web._foobar._tcp.local.", "", http.StatusNoContent).Body.Close()
+	do("GET", "/services/web._foobar._tcp.local.", "", http.StatusNotFound).Body.Close()
+	if got := set.Services(); len(got) != 0 {
+		t.Fatalf("service not removed: %v", got)
+	}
+}
@@ -0,0 +1,330 @@
+// Package airplay discovers and advertises AirPlay receivers, which are
+// published under two service types: "_airplay._tcp", named after the
+// receiver, for AirPlay itself, and "_raop._tcp", named "<MAC>@<name>" such
+// as "AABBCCDDEEFF@Living Room", for the older Remote Audio Output Protocol
+// used for audio.
+//
+// Discover finds the receivers on the network, merging the instances of both
+// types that belong to one device.  A receiver implemented in Go is
+// advertised by adding its services to a server's ServiceSet:
+//
+//     r := &airplay.Receiver{
+//         Name:          "Living Room",
+//         DeviceID:      mac,
+//         Model:         "GoSpeaker1,1",
+//         Features:      airplay.AudioFeatures,
+//         SourceVersion: "366.0",
+//         AirPlayPort:   7000,
+//         RAOPPort:      7000,
+//     }
+//     services, err := r.Services("", nil)
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     set, _ := mdns.NewServiceSet(services...)
+//     server, err := mdns.NewServer(&mdns.Config{Zone: set})
+package airplay
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// Service types of AirPlay receivers.
+const (
+	AirPlayService = "_airplay._tcp"
+	RAOPService    = "_raop._tcp"
+)
+
+// AudioFeatures are the feature flags of an audio-only receiver: audio,
+// redundant audio, and the FairPlay-free authentication of the protocol's
+// open implementations.
+const AudioFeatures uint64 = 1<<9 | 1<<11 | 1<<14 | 1<<18 | 1<<19 | 1<<20 | 1<<22
+
+// Receiver is an AirPlay receiver.
+type Receiver struct {
+	Name          string           // The name shown to users
+	DeviceID      net.HardwareAddr // The MAC address identifying the device
+	Model         string           // Such as "AppleTV5,3"
+	Features      uint64           // Feature flags, from "features" or "ft"
+	SourceVersion string           // The AirPlay version, such as "366.0"
+	PublicKey     string           // The "pk" TXT key, in hex, if any
+	Password      bool             // Whether a password is required
+
+	// AirPlayPort and RAOPPort are the ports of the receiver's services.  A
+	// receiver found only under one type has a zero port for the other, and
+	// one advertised with a zero port is not advertised under that type.
+	AirPlayPort int
+	RAOPPort    int
+
+	// IP is the receiver's IPv4 address if known, else its IPv6 address,
+	// with the zone in Zone if it is link-local.  They are set for
+	// discovered receivers only.
+	IP   net.IP
+	Zone string
+}
+
+// RAOPInstance returns the RAOP instance name of a receiver, such as
+// "AABBCCDDEEFF@Living Room".
+func RAOPInstance(mac net.HardwareAddr, name string) string {
+	return strings.ToUpper(strings.Replace(mac.String(), ":", "", -1)) + "@" + name
+}
+
+// ParseRAOPInstance splits an RAOP instance name into the receiver's MAC
+// address and name.
+func ParseRAOPInstance(instance string) (net.HardwareAddr, string, error) {
+	i := strings.Index(instance, "@")
+	if i != 12 {
+		return nil, "", fmt.Errorf("airplay: bad RAOP instance name %q", instance)
+	}
+	hex := instance[:i]
+	var parts []string
+	for j := 0; j < len(hex); j += 2 {
+		parts = append(parts, hex[j:j+2])
+	}
+	mac, err := net.ParseMAC(strings.Join(parts, ":"))
+	if err != nil {
+		return nil, "", fmt.Errorf("airplay: bad RAOP instance name %q", instance)
+	}
+	return mac, instance[i+1:], nil
+}
+
+// formatFeatures encodes feature flags as in the TXT record, with the upper
+// 32 bits after a comma if any are set.
+func formatFeatures(f uint64) string {
+	if f>>32 == 0 {
+		return fmt.Sprintf("0x%X", f)
+	}
+	return fmt.Sprintf("0x%X,0x%X", uint32(f), f>>32)
+}
+
+// parseFeatures decodes feature flags from the TXT record.
+func parseFeatures(s string) (uint64, error) {
+	parts := strings.SplitN(s, ",", 2)
+	low, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(parts[0]), "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("airplay: bad features %q", s)
+	}
+	f := low
+	if len(parts) == 2 {
+		high, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(parts[1]), "0x"), 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("airplay: bad features %q", s)
+		}
+		f |= high << 32
+	}
+	return f, nil
+}
+
+// setAddress sets the receiver's address from an instance.
+func (r *Receiver) setAddress(entry *mdns.ServiceEntry) {
+	if entry.AddrV4 != nil {
+		r.IP, r.Zone = entry.AddrV4, ""
+	} else if r.IP == nil {
+		r.IP, r.Zone = entry.AddrV6, entry.Zone
+	}
+}
+
+// ParseAirPlay returns the receiver an "_airplay._tcp" instance advertises.
+// It fails if the instance has no valid "deviceid" TXT key.
+func ParseAirPlay(entry *mdns.ServiceEntry) (*Receiver, error) {
+	txt := entry.TXTMap()
+	mac, err := net.ParseMAC(txt["deviceid"])
+	if err != nil {
+		return nil, fmt.Errorf("airplay: %s has no valid deviceid", entry.Name)
+	}
+	r := &Receiver{
+		Name:          entry.Instance(),
+		DeviceID:      mac,
+		Model:         txt["model"],
+		SourceVersion: txt["srcvers"],
+		PublicKey:     txt["pk"],
+		Password:      txt["pw"] == "true" || txt["pw"] == "1",
+		AirPlayPort:   entry.Port,
+	}
+	if f, ok := txt["features"]; ok {
+		if r.Features, err = parseFeatures(f); err != nil {
+			return nil, err
+		}
+	}
+	r.setAddress(entry)
+	return r, nil
+}
+
+// ParseRAOP returns the receiver an "_raop._tcp" instance advertises.  It
+// fails if the instance name is not of the form "<MAC>@<name>".
+func ParseRAOP(entry *mdns.ServiceEntry) (*Receiver, error) {
+	mac, name, err := ParseRAOPInstance(entry.Instance())
+	if err != nil {
+		return nil, err
+	}
+	txt := entry.TXTMap()
+	r := &Receiver{
+		Name:          name,
+		DeviceID:      mac,
+		Model:         txt["am"],
+		SourceVersion: txt["vs"],
+		PublicKey:     txt["pk"],
+		Password:      txt["pw"] == "true",
+		RAOPPort:      entry.Port,
+	}
+	if f, ok := txt["ft"]; ok {
+		if r.Features, err = parseFeatures(f); err != nil {
+			return nil, err
+		}
+	}
+	r.setAddress(entry)
+	return r, nil
+}
+
+// merge adds what an instance of the other service type says about the
+// receiver.  AirPlay instances take precedence, being the newer protocol.
+func (r *Receiver) merge(o *Receiver) {
+	if o.AirPlayPort != 0 {
+		r.Name, r.AirPlayPort = o.Name, o.AirPlayPort
+	}
+	if o.RAOPPort != 0 {
+		r.RAOPPort = o.RAOPPort
+	}
+	if r.Model == "" || o.AirPlayPort != 0 && o.Model != "" {
+		r.Model = o.Model
+	}
+	if r.SourceVersion == "" || o.AirPlayPort != 0 && o.SourceVersion != "" {
+		r.SourceVersion = o.SourceVersion
+	}
+	if r.PublicKey == "" {
+		r.PublicKey = o.PublicKey
+	}
+	if r.Features == 0 || o.AirPlayPort != 0 && o.Features != 0 {
+		r.Features = o.Features
+	}
+	r.Password = r.Password || o.Password
+	if r.IP == nil || r.IP.To4() == nil && o.IP.To4() != nil {
+		r.IP, r.Zone = o.IP, o.Zone
+	}
+}
+
+// Discover looks for receivers under both service types, and returns them
+// sorted by name.  It returns when ctx is done or the timeout given in opts,
+// one second by default, elapses.  Instances that are not valid receivers
+// are skipped.
+func Discover(ctx context.Context, opts ...mdns.QueryOption) ([]*Receiver, error) {
+	found := make(chan *Receiver, 16)
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	for _, service := range []string{AirPlayService, RAOPService} {
+		parse := ParseAirPlay
+		if service == RAOPService {
+			parse = ParseRAOP
+		}
+		entries := make(chan *mdns.ServiceEntry, 16)
+		o := append(append([]mdns.QueryOption(nil), opts...), mdns.WithEntriesChannel(entries))
+		wg.Add(2)
+		go func(service string) {
+			defer wg.Done()
+			errs <- mdns.Lookup(ctx, service, o...)
+			close(entries)
+		}(service)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				if r, err := parse(entry); err == nil && r.IP != nil {
+					found <- r
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	byID := make(map[string]*Receiver)
+	for r := range found {
+		if old, ok := byID[r.DeviceID.String()]; ok {
+			old.merge(r)
+		} else {
+			byID[r.DeviceID.String()] = r
+		}
+	}
+	receivers := make([]*Receiver, 0, len(byID))
+	for _, r := range byID {
+		receivers = append(receivers, r)
+	}
+	sort.Slice(receivers, func(i, j int) bool { return receivers[i].Name < receivers[j].Name })
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			return receivers, err
+		}
+	}
+	return receivers, nil
+}
+
+// Services returns the services advertising the receiver, with the given
+// host name and addresses, which default as for mdns.NewMDNSService.  There
+// is one service for each of AirPlayPort and RAOPPort that is set.
+func (r *Receiver) Services(host string, ips []net.IP) ([]*mdns.MDNSService, error) {
+	if r.Name == "" || len(r.DeviceID) != 6 {
+		return nil, fmt.Errorf("airplay: a receiver needs a name and a 6-byte device ID")
+	}
+	if r.AirPlayPort == 0 && r.RAOPPort == 0 {
+		return nil, fmt.Errorf("airplay: a receiver needs an AirPlay or RAOP port")
+	}
+	features := formatFeatures(r.Features)
+	var services []*mdns.MDNSService
+	if r.AirPlayPort != 0 {
+		txt := []string{
+			"deviceid=" + strings.ToUpper(r.DeviceID.String()),
+			"features=" + features,
+			"flags=0x4",
+			"model=" + r.Model,
+			"srcvers=" + r.SourceVersion,
+		}
+		if r.PublicKey != "" {
+			txt = append(txt, "pk="+r.PublicKey)
+		}
+		if r.Password {
+			txt = append(txt, "pw=true")
+		}
+		svc, err := mdns.NewMDNSService(r.Name, AirPlayService, "", host, r.AirPlayPort, ips, txt)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	if r.RAOPPort != 0 {
+		txt := []string{
+			"txtvers=1",
+			"ch=2",
+			"cn=0,1",
+			"da=true",
+			"et=0,1",
+			"ft=" + features,
+			"md=0,1,2",
+			"pw=" + strconv.FormatBool(r.Password),
+			"sf=0x4",
+			"sr=44100",
+			"ss=16",
+			"tp=UDP",
+			"vn=65537",
+			"am=" + r.Model,
+			"vs=" + r.SourceVersion,
+		}
+		if r.PublicKey != "" {
+			txt = append(txt, "pk="+r.PublicKey)
+		}
+		svc, err := mdns.NewMDNSService(RAOPInstance(r.DeviceID, r.Name), RAOPService, "", host, r.RAOPPort, ips, txt)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
@@ -0,0 +1,89 @@
+package airplay
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+func TestRAOPInstance(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	instance := RAOPInstance(mac, "Living Room")
+	if instance != "AABBCCDDEEFF@Living Room" {
+		t.Fatalf("bad instance: %q", instance)
+	}
+	got, name, err := ParseRAOPInstance(instance)
+	if err != nil || got.String() != mac.String() || name != "Living Room" {
+		t.Errorf("ParseRAOPInstance(%q) = %v, %q, %v", instance, got, name, err)
+	}
+	for _, bad := range []string{"Living Room", "AABBCC@Living Room", "GGBBCCDDEEFF@Living Room"} {
+		if _, _, err := ParseRAOPInstance(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	for _, f := range []uint64{0, AudioFeatures, 0x5A7FFFF7 | 0x1E<<32} {
+		s := formatFeatures(f)
+		got, err := parseFeatures(s)
+		if err != nil || got != f {
+			t.Errorf("parseFeatures(%q) = %x, %v, want %x", s, got, err, f)
+		}
+	}
+	if s := formatFeatures(0x5A7FFFF7 | 0x1E<<32); s != "0x5A7FFFF7,0x1E" {
+		t.Errorf("bad features: %q", s)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	r := &Receiver{
+		Name:          "Test Speaker",
+		DeviceID:      mac,
+		Model:         "GoSpeaker1,1",
+		Features:      AudioFeatures,
+		SourceVersion: "366.0",
+		AirPlayPort:   7000,
+		RAOPPort:      5000,
+	}
+	services, err := r.Services("speaker.local.", []net.IP{net.IPv4(192, 168, 0, 42)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(services) != 2 || services[1].Instance != "AABBCCDDEEFF@Test Speaker" {
+		t.Fatalf("bad services: %+v", services)
+	}
+	set, err := mdns.NewServiceSet(services...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	receivers, err := Discover(context.Background(), mdns.WithTimeout(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var found *Receiver
+	for _, got := range receivers {
+		if got.DeviceID.String() == mac.String() {
+			found = got
+		}
+	}
+	if found == nil {
+		t.Fatalf("receiver not found: %+v", receivers)
+	}
+	if found.Name != "Test Speaker" || found.Model != "GoSpeaker1,1" || found.Features != AudioFeatures {
+		t.Errorf("bad receiver: %+v", found)
+	}
+	if found.AirPlayPort != 7000 || found.RAOPPort != 5000 || !found.IP.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Errorf("bad receiver address: %+v", found)
+	}
+}
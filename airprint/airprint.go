@@ -0,0 +1,216 @@
+// Package airprint builds the advertisement of an IPP printer that iOS and
+// macOS list in their print dialogs through AirPrint: an "_ipp._tcp" or
+// "_ipps._tcp" instance with the "_universal" subtype, and the TXT keys that
+// describe the printer's formats and capabilities.
+//
+// A print server advertises each printer it serves with
+//
+//     p := &airprint.Printer{
+//         Name:         "Office Printer",
+//         MakeAndModel: "Go Print Server",
+//         UUID:         "b4e2c9a6-3f7e-4c5b-9a1d-0e2f6c8d7a11",
+//         Location:     "2nd floor",
+//         Color:        true,
+//         Duplex:       true,
+//     }
+//     svc, err := p.Service("", nil)
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     server, err := mdns.NewServer(&mdns.Config{Zone: svc})
+//
+// The printer must also answer IPP Get-Printer-Attributes requests with
+// attributes matching the advertisement.
+package airprint
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/micro/mdns"
+)
+
+// Service types of IPP printers.
+const (
+	IPPService  = "_ipp._tcp"
+	IPPSService = "_ipps._tcp"
+)
+
+// UniversalSubtype is the subtype that AirPrint clients browse for.
+const UniversalSubtype = "_universal"
+
+// PrintSubtype is the subtype of printers that support IPP Everywhere.
+const PrintSubtype = "_print"
+
+// Defaults for the fields of a Printer.
+var (
+	DefaultPDLs = []string{"application/pdf", "image/urf", "image/jpeg", "image/pwg-raster"}
+	DefaultURF  = []string{"V1.4", "CP1", "W8", "SRGB24", "RS300", "DM1", "IS1"}
+)
+
+// Printer is a printer to advertise.
+type Printer struct {
+	// Name is the instance name shown to users.  Required.
+	Name string
+
+	// MakeAndModel is the printer's make and model, "ty".  Required.
+	MakeAndModel string
+
+	// UUID is the printer's UUID, "UUID", without the "urn:uuid:" prefix.
+	// Required.
+	UUID string
+
+	// Product is the PostScript product name, "product", without the
+	// parentheses.  Default MakeAndModel.
+	Product string
+
+	// ResourcePath is the path of the printer's IPP URI, "rp", default
+	// "ipp/print".
+	ResourcePath string
+
+	// AdminURL is the URL of the printer's configuration page, "adminurl".
+	AdminURL string
+
+	// Location is the printer's location, "note".
+	Location string
+
+	// PDLs are the document formats accepted, "pdl", default DefaultPDLs.
+	// AirPrint requires "image/urf".
+	PDLs []string
+
+	// URF are the Apple raster capabilities, "URF", default DefaultURF.
+	URF []string
+
+	// Color and Duplex are whether the printer prints in color and on both
+	// sides.
+	Color  bool
+	Duplex bool
+
+	// Kind are the kinds of media supported, "kind", such as "document" and
+	// "photo", default "document".
+	Kind []string
+
+	// PaperMax is the largest paper size, "PaperMax", such as "legal-A4",
+	// default "legal-A4".
+	PaperMax string
+
+	// TLS advertises the printer as "_ipps._tcp", for IPP over TLS.
+	TLS bool
+
+	// Port is the port of the IPP server, default 631.
+	Port int
+}
+
+// tf encodes a boolean TXT value.
+func tf(b bool) string {
+	if b {
+		return "T"
+	}
+	return "F"
+}
+
+// orDefault returns values, or def if it is empty.
+func orDefault(values, def []string) []string {
+	if len(values) == 0 {
+		return def
+	}
+	return values
+}
+
+// TXT returns the printer's TXT record.  It fails if a required field is
+// missing, if "image/urf" is not among the PDLs, or if a value is too long for
+// a TXT record.
+func (p *Printer) TXT() ([]string, error) {
+	if p.Name == "" || p.MakeAndModel == "" || p.UUID == "" {
+		return nil, fmt.Errorf("airprint: a printer needs a name, make and model, and UUID")
+	}
+	pdls := orDefault(p.PDLs, DefaultPDLs)
+	hasURF := false
+	for _, pdl := range pdls {
+		hasURF = hasURF || strings.EqualFold(pdl, "image/urf")
+	}
+	if !hasURF {
+		return nil, fmt.Errorf("airprint: AirPrint printers must accept image/urf")
+	}
+	product := p.Product
+	if product == "" {
+		product = p.MakeAndModel
+	}
+	rp := p.ResourcePath
+	if rp == "" {
+		rp = "ipp/print"
+	}
+	paperMax := p.PaperMax
+	if paperMax == "" {
+		paperMax = "legal-A4"
+	}
+
+	txt := []string{
+		"txtvers=1",
+		"qtotal=1",
+		"rp=" + strings.TrimPrefix(rp, "/"),
+		"ty=" + p.MakeAndModel,
+		"product=(" + product + ")",
+		"note=" + p.Location,
+		"pdl=" + strings.Join(pdls, ","),
+		"URF=" + strings.Join(orDefault(p.URF, DefaultURF), ","),
+		"UUID=" + p.UUID,
+		"Color=" + tf(p.Color),
+		"Duplex=" + tf(p.Duplex),
+		"kind=" + strings.Join(orDefault(p.Kind, []string{"document"}), ","),
+		"PaperMax=" + paperMax,
+		"printer-state=3",
+		"printer-type=" + printerType(p),
+		"Transparent=T",
+		"Binary=T",
+	}
+	if p.AdminURL != "" {
+		txt = append(txt, "adminurl="+p.AdminURL)
+	}
+	if p.TLS {
+		txt = append(txt, "TLS=1.2")
+	}
+	for _, s := range txt {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("airprint: TXT string %q is longer than 255 bytes", s)
+		}
+	}
+	return txt, nil
+}
+
+// printerType returns the CUPS printer-type bits of the printer.
+func printerType(p *Printer) string {
+	t := 0x4 | 0x40 | 0x80 // Black and white, copies, and collating
+	if p.Color {
+		t |= 0x8
+	}
+	if p.Duplex {
+		t |= 0x10
+	}
+	return "0x" + strconv.FormatInt(int64(t), 16)
+}
+
+// Service returns the service advertising the printer, with the given host
+// name and addresses, which default as for mdns.NewMDNSService.
+func (p *Printer) Service(host string, ips []net.IP) (*mdns.MDNSService, error) {
+	txt, err := p.TXT()
+	if err != nil {
+		return nil, err
+	}
+	service := IPPService
+	if p.TLS {
+		service = IPPSService
+	}
+	port := p.Port
+	if port == 0 {
+		port = 631
+	}
+	svc, err := mdns.NewMDNSService(p.Name, service, "", host, port, ips, txt)
+	if err != nil {
+		return nil, err
+	}
+	svc.Subtypes = []string{UniversalSubtype, PrintSubtype}
+	return svc, nil
+}
@@ -0,0 +1,88 @@
+package airprint
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+)
+
+func testPrinter() *Printer {
+	return &Printer{
+		Name:         "Office Printer",
+		MakeAndModel: "Go Print Server",
+		UUID:         "b4e2c9a6-3f7e-4c5b-9a1d-0e2f6c8d7a11",
+		Location:     "2nd floor",
+		AdminURL:     "http://printer.local/",
+		Color:        true,
+	}
+}
+
+func TestPrinter_TXT(t *testing.T) {
+	txt, err := testPrinter().TXT()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m := (&mdns.ServiceEntry{InfoFields: txt}).TXTMap()
+	want := map[string]string{
+		"rp":           "ipp/print",
+		"ty":           "Go Print Server",
+		"product":      "(Go Print Server)",
+		"note":         "2nd floor",
+		"pdl":          "application/pdf,image/urf,image/jpeg,image/pwg-raster",
+		"urf":          strings.Join(DefaultURF, ","),
+		"uuid":         "b4e2c9a6-3f7e-4c5b-9a1d-0e2f6c8d7a11",
+		"color":        "T",
+		"duplex":       "F",
+		"adminurl":     "http://printer.local/",
+		"printer-type": "0xcc",
+	}
+	for key, value := range want {
+		if m[key] != value {
+			t.Errorf("%s = %q, want %q", key, m[key], value)
+		}
+	}
+	if _, ok := m["tls"]; ok {
+		t.Errorf("TLS advertised without TLS")
+	}
+}
+
+func TestPrinter_TXT_Invalid(t *testing.T) {
+	p := testPrinter()
+	p.PDLs = []string{"application/pdf"}
+	if _, err := p.TXT(); err == nil {
+		t.Errorf("expected an error without image/urf")
+	}
+	p = testPrinter()
+	p.UUID = ""
+	if _, err := p.TXT(); err == nil {
+		t.Errorf("expected an error without a UUID")
+	}
+	p = testPrinter()
+	p.Location = strings.Repeat("x", 255)
+	if _, err := p.TXT(); err == nil {
+		t.Errorf("expected an error for a long location")
+	}
+}
+
+func TestPrinter_Service(t *testing.T) {
+	p := testPrinter()
+	p.TLS = true
+	svc, err := p.Service("printer.local.", []net.IP{net.IPv4(192, 168, 0, 42)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if svc.Service != IPPSService || svc.Port != 631 || !reflect.DeepEqual(svc.Subtypes, []string{UniversalSubtype, PrintSubtype}) {
+		t.Fatalf("bad service: %+v", svc)
+	}
+	recs := svc.Records(dns.Question{Name: "_universal._sub._ipps._tcp.local.", Qtype: dns.TypePTR})
+	if len(recs) == 0 {
+		t.Fatalf("no answer for the _universal subtype")
+	}
+	if ptr, ok := recs[0].(*dns.PTR); !ok || ptr.Ptr != "Office Printer._ipps._tcp.local." {
+		t.Errorf("bad PTR record: %v", recs[0])
+	}
+}
@@ -0,0 +1,63 @@
+package mdns
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxCachedAnswers bounds the questions whose answers are cached, so that
+// queries for many different names cannot grow the cache without limit.
+const maxCachedAnswers = 1024
+
+// answerKey identifies a question, without the unicast response bit.
+type answerKey struct {
+	name   string // nameKey of the name
+	qtype  uint16
+	qclass uint16
+}
+
+// answerCache caches a zone's answers to questions, for Config.CacheAnswers.
+type answerCache struct {
+	lock       sync.RWMutex
+	answers    map[answerKey][]dns.RR
+	generation uint64 // Incremented by invalidate
+}
+
+func newAnswerCache() *answerCache {
+	return &answerCache{answers: make(map[answerKey][]dns.RR)}
+}
+
+// records returns zone's answer to q, from the cache if it holds it.  The
+// answer must not be modified.
+func (c *answerCache) records(zone Zone, q dns.Question) []dns.RR {
+	key := answerKey{name: nameKey(q.Name), qtype: q.Qtype, qclass: q.Qclass &^ (1 << 15)}
+	c.lock.RLock()
+	answer, ok := c.answers[key]
+	generation := c.generation
+	c.lock.RUnlock()
+	if ok {
+		return answer
+	}
+
+	answer = zone.Records(q)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// An answer looked up while the zone changed may be stale.
+	if c.generation == generation {
+		if len(c.answers) >= maxCachedAnswers {
+			c.answers = make(map[answerKey][]dns.RR)
+		}
+		c.answers[key] = answer
+	}
+	return answer
+}
+
+// invalidate empties the cache.
+func (c *answerCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.answers = make(map[answerKey][]dns.RR)
+	c.generation++
+}
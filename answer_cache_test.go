@@ -0,0 +1,43 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// countingZone counts its lookups.
+type countingZone struct {
+	lookups int
+}
+
+func (z *countingZone) Records(q dns.Question) []dns.RR {
+	z.lookups++
+	return []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+		A:   net.IPv4(127, 0, 0, 1),
+	}}
+}
+
+func TestAnswerCache(t *testing.T) {
+	z := new(countingZone)
+	c := newAnswerCache()
+	q := dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.records(z, q)
+	// The case of the name and the unicast bit do not matter.
+	c.records(z, dns.Question{Name: "HOST.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET | 1<<15})
+	if z.lookups != 1 {
+		t.Errorf("%d lookups, want 1", z.lookups)
+	}
+	c.records(z, dns.Question{Name: "host.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	if z.lookups != 2 {
+		t.Errorf("%d lookups, want 2", z.lookups)
+	}
+
+	c.invalidate()
+	if recs := c.records(z, q); len(recs) != 1 || z.lookups != 3 {
+		t.Errorf("got %v after %d lookups", recs, z.lookups)
+	}
+}
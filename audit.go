@@ -0,0 +1,115 @@
+package mdns
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// AuditLog is an append-only log of a server's activity: the queries it
+// receives and from whom, what it answers, and the services it announces
+// and withdraws.  Each record is written to the underlying writer as a line
+// of JSON, such as:
+//
+//     {"time":"2016-01-01T00:00:00Z","event":"query","peer":"192.168.0.7:5353","questions":[{"name":"_http._tcp.local.","type":"PTR"}]}
+//     {"time":"2016-01-01T00:00:00Z","event":"response","peer":"192.168.0.7:5353","records":[{"name":"_http._tcp.local.","type":"PTR","ttl":120}]}
+//     {"time":"2016-01-01T00:00:00Z","event":"service_added","service":"hostname._http._tcp.local.","host":"testhost.","port":80}
+//     {"time":"2016-01-01T00:02:00Z","event":"service_removed","service":"hostname._http._tcp.local.","host":"testhost.","port":80}
+//
+// Questions asking for a unicast response, and unicast responses, have
+// "unicast":true.  An AuditLog may be shared by several servers.
+type AuditLog struct {
+	lock sync.Mutex
+	w    io.Writer
+	now  func() time.Time
+}
+
+// NewAuditLog returns an AuditLog writing to w, typically a file opened for
+// appending.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w, now: time.Now}
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time      time.Time       `json:"time"`
+	Event     string          `json:"event"`
+	Peer      string          `json:"peer,omitempty"`
+	Unicast   bool            `json:"unicast,omitempty"`
+	Questions []auditQuestion `json:"questions,omitempty"`
+	Records   []auditRR       `json:"records,omitempty"`
+	Service   string          `json:"service,omitempty"`
+	Host      string          `json:"host,omitempty"`
+	Port      int             `json:"port,omitempty"`
+}
+
+type auditQuestion struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Unicast bool   `json:"unicast,omitempty"`
+}
+
+type auditRR struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+}
+
+// write appends r to the log, stamped with the current time.
+func (a *AuditLog) write(r *auditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	r.Time = a.now().UTC()
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("[ERR] mdns: Failed to encode audit record: %v", err)
+		return
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("[ERR] mdns: Failed to write audit record: %v", err)
+	}
+}
+
+// query records the questions of a query received from peer.
+func (a *AuditLog) query(peer net.Addr, questions []dns.Question) {
+	r := &auditRecord{Event: "query", Peer: addrString(peer)}
+	for _, q := range questions {
+		r.Questions = append(r.Questions, auditQuestion{
+			Name:    q.Name,
+			Type:    dns.TypeToString[q.Qtype],
+			Unicast: q.Qclass&(1<<15) != 0,
+		})
+	}
+	a.write(r)
+}
+
+// response records the answers sent in response to a query from peer.
+func (a *AuditLog) response(peer net.Addr, answers []dns.RR, unicast bool) {
+	r := &auditRecord{Event: "response", Peer: addrString(peer), Unicast: unicast}
+	for _, rr := range answers {
+		hdr := rr.Header()
+		r.Records = append(r.Records, auditRR{Name: hdr.Name, Type: dns.TypeToString[hdr.Rrtype], TTL: hdr.Ttl})
+	}
+	a.write(r)
+}
+
+// service records that svc was announced, if added, or withdrawn.
+func (a *AuditLog) service(svc *MDNSService, added bool) {
+	event := "service_removed"
+	if added {
+		event = "service_added"
+	}
+	a.write(&auditRecord{Event: event, Service: svc.instanceAddr, Host: svc.HostName, Port: svc.Port})
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
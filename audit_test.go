@@ -0,0 +1,54 @@
+package mdns
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	a := NewAuditLog(&buf)
+	a.now = func() time.Time { return time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC) }
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	svc := makeService(t)
+
+	a.query(peer, []dns.Question{{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET | 1<<15}})
+	a.response(peer, svc.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})[:1], true)
+	a.service(svc, true)
+	a.service(svc, false)
+
+	want := []string{
+		`{"time":"2016-01-01T00:00:00Z","event":"query","peer":"192.168.0.7:5353","questions":[{"name":"_http._tcp.local.","type":"PTR","unicast":true}]}`,
+		`{"time":"2016-01-01T00:00:00Z","event":"response","peer":"192.168.0.7:5353","unicast":true,"records":[{"name":"_http._tcp.local.","type":"PTR","ttl":120}]}`,
+		`{"time":"2016-01-01T00:00:00Z","event":"service_added","service":"hostname._http._tcp.local.","host":"testhost.","port":80}`,
+		`{"time":"2016-01-01T00:00:00Z","event":"service_removed","service":"hostname._http._tcp.local.","host":"testhost.","port":80}`,
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\n got %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestServer_Audit(t *testing.T) {
+	var buf bytes.Buffer
+	serv, err := NewServer(&Config{Zone: makeService(t), Audit: NewAuditLog(&buf)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"event":"service_removed","service":"hostname._http._tcp.local."`) {
+		t.Errorf("withdrawal not recorded:\n%s", buf.String())
+	}
+}
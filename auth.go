@@ -0,0 +1,112 @@
+package mdns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// authOption is the EDNS0 option code, from the range for local use,
+	// of the option that carries a response's HMAC.
+	authOption = 65301
+
+	// authMaxSkew is how far the time a response was signed at may be from
+	// the receiver's clock, so that old responses cannot be replayed for
+	// long.
+	authMaxSkew = 5 * time.Minute
+)
+
+// authMAC returns the HMAC-SHA256, with key, of the time a message was
+// signed at, in seconds since the epoch, and of the records of its answer,
+// authority and additional sections, other than OPT records, each packed
+// uncompressed.
+func authMAC(key []byte, m *dns.Msg, signed uint64) []byte {
+	mac := hmac.New(sha256.New, key)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], signed)
+	mac.Write(b[:])
+	buf := make([]byte, 512)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			for {
+				n, err := dns.PackRR(rr, buf, 0, nil, false)
+				if err == nil {
+					mac.Write(buf[:n])
+					break
+				}
+				if len(buf) >= dns.MaxMsgSize {
+					break
+				}
+				buf = make([]byte, 2*len(buf))
+			}
+		}
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// signMsg adds to m an EDNS0 option holding the time and the HMAC of its
+// records, with key, replacing any added before.  The option is added to an
+// OPT record of its own, after the other records.
+func signMsg(key []byte, m *dns.Msg, now time.Time) {
+	unsignMsg(m)
+	signed := uint64(now.Unix())
+	data := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(data, signed)
+	data = append(data, authMAC(key, m, signed)...)
+
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	opt.Option = []dns.EDNS0{&dns.EDNS0_LOCAL{Code: authOption, Data: data}}
+	// The records may be shared, so the section is copied.
+	m.Extra = append(m.Extra[:len(m.Extra):len(m.Extra)], opt)
+}
+
+// unsignMsg removes the OPT record added by signMsg from m, and returns its
+// authentication option, or nil if there is none.
+func unsignMsg(m *dns.Msg) *dns.EDNS0_LOCAL {
+	for i, rr := range m.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		for _, o := range opt.Option {
+			if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == authOption {
+				m.Extra = append(m.Extra[:i:i], m.Extra[i+1:]...)
+				return local
+			}
+		}
+	}
+	return nil
+}
+
+// verifyMsg returns true if m was signed with key less than authMaxSkew from
+// now, and removes the signature from m.
+func verifyMsg(key []byte, m *dns.Msg, now time.Time) bool {
+	auth := unsignMsg(m)
+	if auth == nil || len(auth.Data) != 8+sha256.Size {
+		return false
+	}
+	signed := binary.BigEndian.Uint64(auth.Data)
+	if skew := now.Sub(time.Unix(int64(signed), 0)); skew > authMaxSkew || skew < -authMaxSkew {
+		return false
+	}
+	return hmac.Equal(auth.Data[8:], authMAC(key, m, signed))
+}
+
+// WithAuthKey only accepts responses signed with key by servers with the
+// same Config.AuthKey, and drops the others, which are counted in
+// ClientMetrics.Unauthenticated, and only logged with a logger given with
+// WithLogger.  Queries are not signed.
+func WithAuthKey(key []byte) QueryOption {
+	return func(p *QueryParam) {
+		p.AuthKey = key
+	}
+}
@@ -0,0 +1,110 @@
+package mdns
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestSignMsg(t *testing.T) {
+	key := []byte("secret")
+	svc := makeService(t)
+	now := time.Now()
+
+	sign := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.Response = true
+		m.Answer = svc.Records(dns.Question{Name: svc.serviceAddr, Qtype: dns.TypePTR})
+		signMsg(key, m, now)
+		buf, err := m.Pack()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := m.Unpack(buf); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return m
+	}
+
+	if m := sign(); !verifyMsg(key, m, now) {
+		t.Errorf("signed message not verified")
+	} else if m.IsEdns0() != nil {
+		t.Errorf("signature not removed: %v", m.Extra)
+	}
+	if m := sign(); verifyMsg([]byte("other"), m, now) {
+		t.Errorf("message verified with the wrong key")
+	}
+	if m := sign(); verifyMsg(key, m, now.Add(10*time.Minute)) {
+		t.Errorf("old message verified")
+	}
+	m := sign()
+	m.Answer[1].(*dns.SRV).Port = 8080
+	if verifyMsg(key, m, now) {
+		t.Errorf("changed message verified")
+	}
+	m.Extra = nil
+	if verifyMsg(key, m, now) {
+		t.Errorf("unsigned message verified")
+	}
+}
+
+func TestServer_AuthKey(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_auth._tcp"), AuthKey: []byte("secret")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	lookup := func(key []byte) (int, *ClientMetrics) {
+		metrics := new(ClientMetrics)
+		entries := make(chan *ServiceEntry, 4)
+		err := Lookup(context.Background(), "_auth._tcp", WithTimeout(500*time.Millisecond), WithEntriesChannel(entries), WithAuthKey(key), WithMetrics(metrics))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return len(entries), metrics
+	}
+	if n, _ := lookup([]byte("secret")); n != 1 {
+		t.Errorf("found %d entries with the key, want 1", n)
+	}
+
+	// The drops are counted, but not logged without a logger.
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if n, metrics := lookup([]byte("wrong")); n != 0 || metrics.Snapshot().Unauthenticated == 0 {
+		t.Errorf("found %d entries with the wrong key, and dropped %d responses", n, metrics.Snapshot().Unauthenticated)
+	}
+	if strings.Contains(buf.String(), "unauthenticated") {
+		t.Errorf("drops logged to the standard logger: %s", buf.String())
+	}
+}
+
+func TestServer_ResolveAuthKey(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_authresolve._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	name := "hostname._authresolve._tcp.local."
+	metrics := new(ClientMetrics)
+	if e, err := Resolve(context.Background(), name, WithTimeout(100*time.Millisecond), WithAuthKey([]byte("secret")), WithMetrics(metrics)); err == nil {
+		t.Errorf("resolved %v from an unsigned responder", e)
+	}
+	if metrics.Snapshot().Unauthenticated == 0 {
+		t.Errorf("unsigned responses not counted")
+	}
+	if types, err := ServiceTypes(context.Background(), WithTimeout(100*time.Millisecond), WithAuthKey([]byte("secret"))); err != nil || len(types) != 0 {
+		t.Errorf("service types from an unsigned responder: %v %v", types, err)
+	}
+	if _, err := Resolve(context.Background(), name, WithTimeout(100*time.Millisecond)); err != nil {
+		t.Errorf("err: %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+// Package avahi serves the core of Avahi's D-Bus API, backed by a Server, so
+// that Linux applications written against Avahi, with libavahi-client or its
+// Python bindings, can use this responder in place of avahi-daemon.
+//
+// It implements the org.freedesktop.Avahi.Server methods that clients call on
+// connecting, and the EntryGroup, ServiceBrowser and ServiceResolver objects.
+// Record browsers, host name and address resolvers, and the entry group
+// methods for subtypes, addresses and arbitrary records are not supported.
+//
+//     set, _ := mdns.NewServiceSet()
+//     server, _ := mdns.NewServer(&mdns.Config{Zone: set})
+//     d, err := avahi.New(&avahi.Config{Server: server, Services: set})
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer d.Close()
+//
+// avahi-daemon must not be running, as the daemon takes over its bus name,
+// and the system bus policy must allow the process to own
+// "org.freedesktop.Avahi", for example by installing avahi-daemon's policy
+// file and running as the user it names.
+package avahi
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/micro/mdns"
+)
+
+const (
+	busName         = "org.freedesktop.Avahi"
+	serverIface     = "org.freedesktop.Avahi.Server"
+	server2Iface    = "org.freedesktop.Avahi.Server2"
+	introspectIface = "org.freedesktop.DBus.Introspectable"
+
+	// apiVersion is the AVAHI_DBUS_API_VERSION of avahi 0.6 to 0.8, which
+	// libavahi-client checks on connecting.
+	apiVersion = 515
+
+	// serverRunning is AVAHI_SERVER_RUNNING.
+	serverRunning = 2
+
+	// maxObjects is the most objects a client may have at once, as in
+	// avahi-daemon.
+	maxObjects = 250
+)
+
+// Interface and protocol numbers, as in avahi-common/address.h.
+const (
+	ifUnspec    = -1
+	protoInet   = 0
+	protoInet6  = 1
+	protoUnspec = -1
+)
+
+// Lookup result flags, as in avahi-common/defs.h.
+const (
+	flagWideArea  = 2
+	flagMulticast = 4
+	flagLocal     = 8
+	flagOurOwn    = 16
+)
+
+// Config is used to configure a Daemon.
+type Config struct {
+	// Server is the server that entry groups are published with.  Its zone
+	// must be Services.
+	Server   *mdns.Server
+	Services *mdns.ServiceSet
+
+	// Conn is the bus to serve on.  The default is a new connection to the
+	// system bus, which is closed with the daemon.
+	Conn *dbus.Conn
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// bus is the part of a D-Bus connection that a Daemon uses to serve its
+// objects.
+type bus interface {
+	Export(v interface{}, path dbus.ObjectPath, iface string) error
+	Emit(path dbus.ObjectPath, name string, values ...interface{}) error
+}
+
+// object is one of the objects that a Daemon creates for a client.
+type object interface {
+	// free releases the object's resources, once it has been removed from
+	// the bus.
+	free()
+}
+
+// client holds the objects created by one bus client.
+type client struct {
+	id      int
+	next    int
+	objects map[dbus.ObjectPath]exported
+}
+
+// exported is an object and the interface it is exported on.
+type exported struct {
+	obj   object
+	iface string
+}
+
+// Daemon serves the Avahi D-Bus API.
+type Daemon struct {
+	config  *Config
+	conn    *dbus.Conn // nil if the bus is not a connection
+	ownConn bool
+	bus     bus
+	signals chan *dbus.Signal
+	host    string // Short host name
+	cookie  uint32 // Local service cookie
+
+	lock    sync.Mutex
+	clients map[dbus.Sender]*client
+	nextID  int
+	closed  bool
+}
+
+// New starts serving the Avahi API on the configured bus.
+func New(config *Config) (*Daemon, error) {
+	if config.Server == nil || config.Services == nil {
+		return nil, fmt.Errorf("mdns: the Avahi API needs a server and its service set")
+	}
+	conn, ownConn := config.Conn, false
+	if conn == nil {
+		var err error
+		if conn, err = dbus.ConnectSystemBus(); err != nil {
+			return nil, err
+		}
+		ownConn = true
+	}
+	d, err := newDaemon(config, conn)
+	if err != nil {
+		if ownConn {
+			conn.Close()
+		}
+		return nil, err
+	}
+	d.conn, d.ownConn = conn, ownConn
+
+	// Free the objects of clients that leave the bus.
+	err = conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"), dbus.WithMatchMember("NameOwnerChanged"))
+	if err == nil {
+		d.signals = make(chan *dbus.Signal, 16)
+		conn.Signal(d.signals)
+		go d.watch()
+	} else {
+		d.logf("[WARN] mdns: Failed to watch Avahi API clients: %v", err)
+	}
+
+	reply, err := conn.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		err = fmt.Errorf("mdns: %s is already owned, is avahi-daemon running?", busName)
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// newDaemon returns a daemon serving its server object on b.
+func newDaemon(config *Config, b bus) (*Daemon, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		host = host[:i]
+	}
+	var cookie [4]byte
+	if _, err := rand.Read(cookie[:]); err != nil {
+		return nil, err
+	}
+	d := &Daemon{
+		config:  config,
+		bus:     b,
+		host:    host,
+		cookie:  binary.BigEndian.Uint32(cookie[:]),
+		clients: make(map[dbus.Sender]*client),
+	}
+	s := &server{d: d}
+	for _, iface := range []string{serverIface, server2Iface} {
+		if err := b.Export(s, "/", iface); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.Export(introspect.Introspectable(serverXML), "/", introspectIface); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close stops serving the API and frees every client's objects, withdrawing
+// the services of their entry groups.
+func (d *Daemon) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+	d.closed = true
+	clients := d.clients
+	d.clients = make(map[dbus.Sender]*client)
+	d.lock.Unlock()
+
+	for _, c := range clients {
+		d.freeObjects(c)
+	}
+	for _, iface := range []string{serverIface, server2Iface, introspectIface} {
+		d.bus.Export(nil, "/", iface)
+	}
+	if d.conn == nil {
+		return nil
+	}
+	if d.signals != nil {
+		d.conn.RemoveSignal(d.signals)
+		close(d.signals)
+	}
+	d.conn.ReleaseName(busName)
+	if d.ownConn {
+		return d.conn.Close()
+	}
+	return nil
+}
+
+func (d *Daemon) logf(format string, v ...interface{}) {
+	if d.config.Logger != nil {
+		d.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// watch frees the objects of clients as they leave the bus.
+func (d *Daemon) watch() {
+	for sig := range d.signals {
+		if sig.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(sig.Body) != 3 {
+			continue
+		}
+		name, _ := sig.Body[0].(string)
+		newOwner, _ := sig.Body[2].(string)
+		if newOwner == "" {
+			d.freeClient(dbus.Sender(name))
+		}
+	}
+}
+
+// freeClient frees the objects of a client that has left the bus.
+func (d *Daemon) freeClient(sender dbus.Sender) {
+	d.lock.Lock()
+	c := d.clients[sender]
+	delete(d.clients, sender)
+	d.lock.Unlock()
+	if c != nil {
+		d.freeObjects(c)
+	}
+}
+
+// freeObjects removes a client's objects from the bus and frees them.
+func (d *Daemon) freeObjects(c *client) {
+	for path, e := range c.objects {
+		d.unexport(path, e.iface)
+		e.obj.free()
+	}
+}
+
+// add creates an object for a client, with a path such as
+// "/Client1/EntryGroup2", and exports it on iface.
+func (d *Daemon) add(sender dbus.Sender, kind, iface, xml string, newObject func(dbus.ObjectPath) object) (dbus.ObjectPath, *dbus.Error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return "", avahiError("DisconnectedError", "Daemon connection failed")
+	}
+	c := d.clients[sender]
+	if c == nil {
+		d.nextID++
+		c = &client{id: d.nextID, objects: make(map[dbus.ObjectPath]exported)}
+		d.clients[sender] = c
+	}
+	if len(c.objects) >= maxObjects {
+		return "", avahiError("TooManyObjectsError", "Too many objects")
+	}
+	c.next++
+	path := dbus.ObjectPath(fmt.Sprintf("/Client%d/%s%d", c.id, kind, c.next))
+	obj := newObject(path)
+	if err := d.bus.Export(obj, path, iface); err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	if err := d.bus.Export(introspect.Introspectable(xml), path, introspectIface); err != nil {
+		d.bus.Export(nil, path, iface)
+		return "", dbus.MakeFailedError(err)
+	}
+	c.objects[path] = exported{obj: obj, iface: iface}
+	return path, nil
+}
+
+// remove frees an object at the request of the client that created it.
+func (d *Daemon) remove(sender dbus.Sender, path dbus.ObjectPath) *dbus.Error {
+	d.lock.Lock()
+	c := d.clients[sender]
+	var e exported
+	ok := false
+	if c != nil {
+		if e, ok = c.objects[path]; ok {
+			delete(c.objects, path)
+		}
+	}
+	d.lock.Unlock()
+	if !ok {
+		return avahiError("AccessDeniedError", "Access denied")
+	}
+	d.unexport(path, e.iface)
+	e.obj.free()
+	return nil
+}
+
+// unexport removes an object from the bus.
+func (d *Daemon) unexport(path dbus.ObjectPath, iface string) {
+	d.bus.Export(nil, path, iface)
+	d.bus.Export(nil, path, introspectIface)
+}
+
+// emit sends a signal from one of the daemon's objects.
+func (d *Daemon) emit(path dbus.ObjectPath, name string, values ...interface{}) {
+	if err := d.bus.Emit(path, name, values...); err != nil {
+		d.logf("[ERR] mdns: Failed to emit %s from %s: %v", name, path, err)
+	}
+}
+
+// avahiError returns an Avahi error, such as "NotSupportedError", with its
+// message.
+func avahiError(name, msg string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.Avahi."+name, []interface{}{msg})
+}
+
+// errNotSupported is returned by the parts of the API that are not
+// implemented.
+var errNotSupported = avahiError("NotSupportedError", "Not supported")
+
+// lookupInterface returns the interface with an Avahi interface index, or nil
+// for ifUnspec.
+func lookupInterface(index int32) (*net.Interface, *dbus.Error) {
+	if index == ifUnspec {
+		return nil, nil
+	}
+	iface, err := net.InterfaceByIndex(int(index))
+	if err != nil {
+		return nil, avahiError("InvalidInterfaceError", "Invalid interface index")
+	}
+	return iface, nil
+}
+
+// checkProtocol returns an error for protocols other than protoInet,
+// protoInet6 and protoUnspec.
+func checkProtocol(proto int32) *dbus.Error {
+	if proto != protoInet && proto != protoInet6 && proto != protoUnspec {
+		return avahiError("InvalidProtocolError", "Invalid protocol specification")
+	}
+	return nil
+}
+
+// domainName returns the domain of a request in Avahi's form, without a
+// trailing dot, defaulting to "local".
+func domainName(domain string) string {
+	if domain = strings.TrimSuffix(domain, "."); domain == "" {
+		return "local"
+	}
+	return domain
+}
+
+// escapeLabel escapes the dots and backslashes of a DNS label, such as an
+// instance name.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `.`, `\.`).Replace(s)
+}
+
+// instanceName returns the fully qualified name of a service instance, such
+// as "My\.Printer._ipp._tcp.local.".
+func instanceName(name, typ, domain string) string {
+	return escapeLabel(name) + "." + strings.TrimSuffix(typ, ".") + "." + domainName(domain) + "."
+}
+
+// instanceLabel returns the unescaped first label of a fully qualified
+// instance name, such as "My Printer" for "My\ Printer._ipp._tcp.local.".
+func instanceLabel(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '.':
+			return b.String()
+		case c != '\\' || i+1 == len(name):
+			b.WriteByte(c)
+		case i+3 < len(name) && isDigits(name[i+1:i+4]):
+			b.WriteByte((name[i+1]-'0')*100 + (name[i+2]-'0')*10 + (name[i+3] - '0'))
+			i += 3
+		default:
+			i++
+			b.WriteByte(name[i])
+		}
+	}
+	return b.String()
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// localIPs returns the addresses of the host that services are published
+// with, on an interface and of a protocol that may be unspecified.  Loopback
+// addresses are only used if there are no others.
+func localIPs(index, proto int32) []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var ips, loopback []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || (index != ifUnspec && int32(iface.Index) != index) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || (proto == protoInet && ipnet.IP.To4() == nil) || (proto == protoInet6 && ipnet.IP.To4() != nil) {
+				continue
+			}
+			if iface.Flags&net.FlagLoopback != 0 {
+				loopback = append(loopback, ipnet.IP)
+			} else {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
+
+// txtStrings converts an Avahi TXT record to the strings of an MDNSService.
+func txtStrings(txt [][]byte) []string {
+	var out []string
+	for _, s := range txt {
+		out = append(out, string(s))
+	}
+	return out
+}
+
+// txtBytes converts the TXT strings of a ServiceEntry to Avahi's form.
+func txtBytes(txt []string) [][]byte {
+	out := [][]byte{}
+	for _, s := range txt {
+		out = append(out, []byte(s))
+	}
+	return out
+}
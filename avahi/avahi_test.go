@@ -0,0 +1,182 @@
+package avahi
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/micro/mdns"
+)
+
+// fakeBus records the objects exported and sends the signals emitted to a
+// channel.
+type fakeBus struct {
+	lock    sync.Mutex
+	objects map[string]interface{} // By path and interface
+	signals chan *dbus.Signal
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{objects: make(map[string]interface{}), signals: make(chan *dbus.Signal, 64)}
+}
+
+func (b *fakeBus) Export(v interface{}, path dbus.ObjectPath, iface string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if v == nil {
+		delete(b.objects, string(path)+" "+iface)
+	} else {
+		b.objects[string(path)+" "+iface] = v
+	}
+	return nil
+}
+
+func (b *fakeBus) Emit(path dbus.ObjectPath, name string, values ...interface{}) error {
+	b.signals <- &dbus.Signal{Path: path, Name: name, Body: values}
+	return nil
+}
+
+func (b *fakeBus) object(path dbus.ObjectPath, iface string) interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.objects[string(path)+" "+iface]
+}
+
+// next returns the next signal from path with the given name, skipping others.
+func (b *fakeBus) next(t *testing.T, path dbus.ObjectPath, name string) *dbus.Signal {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case sig := <-b.signals:
+			if sig.Path == path && sig.Name == name {
+				return sig
+			}
+		case <-timeout:
+			t.Fatalf("no %s signal from %s", name, path)
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	set, err := mdns.NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	srv, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer srv.Shutdown()
+	b := newFakeBus()
+	d, err := newDaemon(&Config{Server: srv, Services: set}, b)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer d.Close()
+	s := b.object("/", serverIface).(*server)
+	const sender = dbus.Sender(":1.42")
+
+	if v, _ := s.GetAPIVersion(); v != apiVersion {
+		t.Errorf("bad API version: %d", v)
+	}
+	if fqdn, _ := s.GetHostNameFqdn(); fqdn != d.host+".local" {
+		t.Errorf("bad host name: %s", fqdn)
+	}
+
+	// Publish a service with an entry group.
+	path, derr := s.EntryGroupNew(sender)
+	if derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	if path != "/Client1/EntryGroup1" {
+		t.Errorf("bad path: %s", path)
+	}
+	g := b.object(path, entryGroupIface).(*entryGroup)
+	if derr := g.AddService(ifUnspec, protoUnspec, 0, "My.Printer", "_avahitest._tcp", "", "", 631, [][]byte{[]byte("rp=ipp")}); derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	if derr := g.Commit(); derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	if sig := b.next(t, path, entryGroupIface+".StateChanged"); sig.Body[0] != int32(groupRegistering) {
+		t.Fatalf("bad state: %v", sig.Body)
+	}
+	if sig := b.next(t, path, entryGroupIface+".StateChanged"); sig.Body[0] != int32(groupEstablished) {
+		t.Fatalf("bad state: %v", sig.Body)
+	}
+	if set.Get(`My\.Printer._avahitest._tcp.local.`) == nil {
+		t.Fatalf("service not published: %v", set.Services())
+	}
+
+	// Names are unique across groups.
+	other, _ := s.EntryGroupNew(sender)
+	og := b.object(other, entryGroupIface).(*entryGroup)
+	if derr := og.AddService(ifUnspec, protoUnspec, 0, "My.Printer", "_avahitest._tcp", "local", "", 631, nil); derr == nil ||
+		derr.Name != "org.freedesktop.Avahi.CollisionError" {
+		t.Errorf("bad collision error: %v", derr)
+	}
+
+	// Browse and resolve it.
+	bpath, derr := s.ServiceBrowserNew(sender, ifUnspec, protoUnspec, "_avahitest._tcp", "", 0)
+	if derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	sig := b.next(t, bpath, browserIface+".ItemNew")
+	if sig.Body[2] != "My.Printer" || sig.Body[3] != "_avahitest._tcp" || sig.Body[4] != "local" ||
+		sig.Body[5].(uint32)&flagOurOwn == 0 {
+		t.Fatalf("bad item: %v", sig.Body)
+	}
+	b.next(t, bpath, browserIface+".AllForNow")
+
+	_, _, name, _, _, host, _, _, port, txt, _, derr := s.ResolveService(ifUnspec, protoUnspec, "My.Printer", "_avahitest._tcp", "local", protoUnspec, 0)
+	if derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	if name != "My.Printer" || host != d.host+".local" || port != 631 || len(txt) != 1 || string(txt[0]) != "rp=ipp" {
+		t.Errorf("bad resolution: %s %s %d %q", name, host, port, txt)
+	}
+
+	rpath, derr := s.ServiceResolverNew(sender, ifUnspec, protoUnspec, "My.Printer", "_avahitest._tcp", "local", protoInet, 0)
+	if derr != nil {
+		t.Fatalf("err: %v", derr)
+	}
+	if sig := b.next(t, rpath, resolverIface+".Found"); sig.Body[6] != int32(protoInet) || sig.Body[8] != uint16(631) {
+		t.Errorf("bad resolution: %v", sig.Body)
+	}
+
+	// Only the client that created an object may free it.
+	if derr := g.Free(":1.99"); derr == nil {
+		t.Errorf("freed another client's group")
+	}
+
+	// The group's services are withdrawn when its client leaves.
+	d.freeClient(sender)
+	if len(set.Services()) != 0 {
+		t.Errorf("services not withdrawn: %v", set.Services())
+	}
+	if b.object(path, entryGroupIface) != nil || b.object(bpath, browserIface) != nil {
+		t.Errorf("objects not removed")
+	}
+}
+
+func TestInstanceNames(t *testing.T) {
+	name := instanceName(`My.Printer\1`, "_ipp._tcp", "")
+	if name != `My\.Printer\\1._ipp._tcp.local.` {
+		t.Errorf("bad name: %s", name)
+	}
+	for in, want := range map[string]string{
+		name:                           `My.Printer\1`,
+		`My\ Printer._ipp._tcp.local.`: "My Printer",
+		`Caf\195\169._ipp._tcp.local.`: "Café",
+	} {
+		if got := instanceLabel(in); got != want {
+			t.Errorf("instanceLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if !strings.HasSuffix(instanceName("x", "_http._tcp.", "example.com."), "._http._tcp.example.com.") {
+		t.Errorf("bad domain handling")
+	}
+}
@@ -0,0 +1,176 @@
+package avahi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+const (
+	browserIface  = "org.freedesktop.Avahi.ServiceBrowser"
+	resolverIface = "org.freedesktop.Avahi.ServiceResolver"
+
+	// allForNowDelay is how long after starting a browser signals that it
+	// has probably found everything, as in avahi-daemon.
+	allForNowDelay = time.Second
+)
+
+// serviceBrowser is an org.freedesktop.Avahi.ServiceBrowser, which reports
+// the instances of a service type as they come and go.
+type serviceBrowser struct {
+	d      *Daemon
+	path   dbus.ObjectPath
+	iface  int32
+	proto  int32
+	typ    string
+	domain string
+
+	lock   sync.Mutex
+	cancel context.CancelFunc // nil until started
+	freed  bool
+}
+
+func (b *serviceBrowser) Free(sender dbus.Sender) *dbus.Error {
+	return b.d.remove(sender, b.path)
+}
+
+func (b *serviceBrowser) free() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.freed = true
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// Start starts a browser created with ServiceBrowserPrepare.
+func (b *serviceBrowser) Start() *dbus.Error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.cancel != nil || b.freed {
+		return nil
+	}
+	iface, err := lookupInterface(b.iface)
+	if err != nil {
+		return err
+	}
+	opts := []mdns.QueryOption{mdns.WithDomain(b.domain), mdns.WithLogger(b.d.config.Logger)}
+	if iface != nil {
+		opts = append(opts, mdns.WithInterface(iface))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	br, berr := mdns.NewBrowser(ctx, b.typ, opts...)
+	if berr != nil {
+		cancel()
+		b.d.emit(b.path, browserIface+".Failure", berr.Error())
+		return nil
+	}
+	b.cancel = cancel
+	go b.run(ctx, br)
+	return nil
+}
+
+// run signals the instances found by br until ctx is done.
+func (b *serviceBrowser) run(ctx context.Context, br *mdns.Browser) {
+	defer br.Close()
+	b.d.emit(b.path, browserIface+".CacheExhausted")
+	allForNow := time.NewTimer(allForNowDelay)
+	defer allForNow.Stop()
+
+	protos := make(map[string]int32) // Protocols reported, by instance name
+	for {
+		select {
+		case ev, ok := <-br.Events():
+			if !ok {
+				return
+			}
+			e := ev.Entry
+			name := instanceLabel(e.Name)
+			flags := b.d.flags(instanceName(name, b.typ, b.domain))
+			switch ev.Type {
+			case mdns.ServiceAdded:
+				proto := b.proto
+				if proto == protoUnspec {
+					proto = protoInet
+					if e.AddrV4 == nil && e.AddrV6 != nil {
+						proto = protoInet6
+					}
+				}
+				if (proto == protoInet && e.AddrV4 == nil) || (proto == protoInet6 && e.AddrV6 == nil) {
+					continue
+				}
+				protos[e.Name] = proto
+				b.d.emit(b.path, browserIface+".ItemNew", b.iface, proto, name, b.typ, b.domain, flags)
+			case mdns.ServiceRemoved:
+				proto, ok := protos[e.Name]
+				if !ok {
+					continue
+				}
+				delete(protos, e.Name)
+				b.d.emit(b.path, browserIface+".ItemRemove", b.iface, proto, name, b.typ, b.domain, flags)
+			}
+		case <-allForNow.C:
+			b.d.emit(b.path, browserIface+".AllForNow")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serviceResolver is an org.freedesktop.Avahi.ServiceResolver, which
+// resolves a service instance once and signals the result.
+type serviceResolver struct {
+	d      *Daemon
+	path   dbus.ObjectPath
+	iface  int32
+	proto  int32
+	name   string
+	typ    string
+	domain string
+	aproto int32
+
+	lock   sync.Mutex
+	cancel context.CancelFunc // nil until started
+	freed  bool
+}
+
+func (r *serviceResolver) Free(sender dbus.Sender) *dbus.Error {
+	return r.d.remove(sender, r.path)
+}
+
+func (r *serviceResolver) free() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.freed = true
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Start starts a resolver created with ServiceResolverPrepare.
+func (r *serviceResolver) Start() *dbus.Error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cancel != nil || r.freed {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		res, err := r.d.resolve(ctx, r.iface, r.proto, r.name, r.typ, r.domain, r.aproto)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			msg, _ := err.Body[0].(string)
+			r.d.emit(r.path, resolverIface+".Failure", msg)
+			return
+		}
+		r.d.emit(r.path, resolverIface+".Found", res.iface, res.proto, res.name, res.typ, res.domain,
+			res.host, res.aproto, res.addr, res.port, res.txt, res.flags)
+	}()
+	return nil
+}
@@ -0,0 +1,199 @@
+package avahi
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/micro/mdns"
+)
+
+const entryGroupIface = "org.freedesktop.Avahi.EntryGroup"
+
+// Entry group states, as in avahi-common/defs.h.
+const (
+	groupUncommitted = 0
+	groupRegistering = 1
+	groupEstablished = 2
+	groupCollision   = 3
+)
+
+// entryGroup is an org.freedesktop.Avahi.EntryGroup: a set of services that
+// are published together when committed.
+type entryGroup struct {
+	d    *Daemon
+	path dbus.ObjectPath
+
+	lock     sync.Mutex
+	state    int32
+	services []*mdns.MDNSService
+}
+
+func (g *entryGroup) Free(sender dbus.Sender) *dbus.Error {
+	return g.d.remove(sender, g.path)
+}
+
+func (g *entryGroup) free() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.withdraw()
+	g.services = nil
+}
+
+// Commit publishes the group's services.  If one of their names is already
+// taken by another group, none is published and the group's state becomes
+// collision.
+func (g *entryGroup) Commit() *dbus.Error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state != groupUncommitted && g.state != groupCollision {
+		return avahiError("BadStateError", "Bad state")
+	}
+	if len(g.services) == 0 {
+		return avahiError("IsEmptyError", "Is empty")
+	}
+	for i, svc := range g.services {
+		if err := g.d.config.Services.Add(svc); err != nil {
+			for _, added := range g.services[:i] {
+				g.d.config.Services.Remove(added.InstanceName())
+			}
+			g.setState(groupCollision, "Local name collision")
+			return nil
+		}
+	}
+	g.setState(groupRegistering, "")
+	for _, svc := range g.services {
+		g.d.config.Server.Announce(svc)
+	}
+	g.setState(groupEstablished, "")
+	return nil
+}
+
+// Reset withdraws the group's services, if committed, and empties it.
+func (g *entryGroup) Reset() *dbus.Error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.withdraw()
+	g.services = nil
+	g.setState(groupUncommitted, "")
+	return nil
+}
+
+func (g *entryGroup) GetState() (int32, *dbus.Error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.state, nil
+}
+
+func (g *entryGroup) IsEmpty() (bool, *dbus.Error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.services) == 0, nil
+}
+
+// AddService adds a service to the group, to be published on commit.  The
+// service's host defaults to this host, published with its addresses on the
+// given interface and protocol.
+func (g *entryGroup) AddService(iface, proto int32, flags uint32, name, typ, domain, host string, port uint16, txt [][]byte) *dbus.Error {
+	if _, err := lookupInterface(iface); err != nil {
+		return err
+	}
+	if err := checkProtocol(proto); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(typ, "_") {
+		return avahiError("InvalidServiceTypeError", "Invalid service type")
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state != groupUncommitted && g.state != groupCollision {
+		return avahiError("BadStateError", "Bad state")
+	}
+	instance := instanceName(name, typ, domain)
+	if g.find(instance) >= 0 || g.d.config.Services.Get(instance) != nil {
+		return avahiError("CollisionError", "Local name collision")
+	}
+
+	var ips []net.IP
+	if host == "" || strings.EqualFold(strings.TrimSuffix(host, "."), g.d.host+".local") {
+		host = g.d.host + ".local"
+		ips = localIPs(iface, proto)
+	}
+	svc, err := mdns.NewMDNSService(escapeLabel(name), typ, domainName(domain)+".", strings.TrimSuffix(host, ".")+".", int(port), ips, txtStrings(txt))
+	if err != nil {
+		return avahiError("InvalidArgumentError", err.Error())
+	}
+	g.services = append(g.services, svc)
+	return nil
+}
+
+// UpdateServiceTxt replaces the TXT record of one of the group's services,
+// announcing the change if the group is committed.
+func (g *entryGroup) UpdateServiceTxt(iface, proto int32, flags uint32, name, typ, domain string, txt [][]byte) *dbus.Error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	i := g.find(instanceName(name, typ, domain))
+	if i < 0 {
+		return avahiError("NotFoundError", "Not found")
+	}
+	// Services are replaced rather than modified, as the server may be
+	// reading the old one.
+	svc := *g.services[i]
+	svc.TXT = txtStrings(txt)
+	g.services[i] = &svc
+	if g.state == groupEstablished {
+		if _, err := g.d.config.Services.Replace(&svc); err != nil {
+			return dbus.MakeFailedError(err)
+		}
+		g.d.config.Server.Announce(&svc)
+	}
+	return nil
+}
+
+func (g *entryGroup) AddServiceSubtype(iface, proto int32, flags uint32, name, typ, domain, subtype string) *dbus.Error {
+	return errNotSupported
+}
+
+func (g *entryGroup) AddAddress(iface, proto int32, flags uint32, name, address string) *dbus.Error {
+	return errNotSupported
+}
+
+func (g *entryGroup) AddRecord(iface, proto int32, flags uint32, name string, class, typ uint16, ttl uint32, rdata []byte) *dbus.Error {
+	return errNotSupported
+}
+
+// find returns the index of the group's service with the given instance name,
+// or -1.  The caller must hold the lock.
+func (g *entryGroup) find(instance string) int {
+	for i, svc := range g.services {
+		if strings.EqualFold(svc.InstanceName(), instance) {
+			return i
+		}
+	}
+	return -1
+}
+
+// withdraw withdraws the group's services if they are published.  The
+// caller must hold the lock.
+func (g *entryGroup) withdraw() {
+	if g.state != groupRegistering && g.state != groupEstablished {
+		return
+	}
+	for _, svc := range g.services {
+		if g.d.config.Services.Remove(svc.InstanceName()) == nil {
+			continue
+		}
+		if err := g.d.config.Server.Withdraw(svc); err != nil {
+			g.d.logf("[ERR] mdns: Failed to withdraw %s: %v", svc.InstanceName(), err)
+		}
+	}
+}
+
+// setState changes the group's state and signals the change.  The caller
+// must hold the lock.
+func (g *entryGroup) setState(state int32, msg string) {
+	g.state = state
+	g.d.emit(g.path, entryGroupIface+".StateChanged", state, msg)
+}
@@ -0,0 +1,271 @@
+package avahi
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+const (
+	// resolveTimeout is how long a service resolver waits for an answer, as
+	// in avahi-daemon.
+	resolveTimeout = 5 * time.Second
+
+	// resolveAttempt is how long each query of a resolver collects answers.
+	// Queries are repeated until one is answered or resolveTimeout passes.
+	resolveAttempt = time.Second
+)
+
+// server is the org.freedesktop.Avahi.Server object, at "/".
+type server struct {
+	d *Daemon
+}
+
+func (s *server) GetVersionString() (string, *dbus.Error) {
+	return "avahi 0.8", nil
+}
+
+func (s *server) GetAPIVersion() (uint32, *dbus.Error) {
+	return apiVersion, nil
+}
+
+func (s *server) GetHostName() (string, *dbus.Error) {
+	return s.d.host, nil
+}
+
+func (s *server) SetHostName(name string) *dbus.Error {
+	return errNotSupported
+}
+
+func (s *server) GetHostNameFqdn() (string, *dbus.Error) {
+	return s.d.host + ".local", nil
+}
+
+func (s *server) GetDomainName() (string, *dbus.Error) {
+	return "local", nil
+}
+
+func (s *server) IsNSSSupportAvailable() (bool, *dbus.Error) {
+	return false, nil
+}
+
+func (s *server) GetState() (int32, *dbus.Error) {
+	return serverRunning, nil
+}
+
+func (s *server) GetLocalServiceCookie() (uint32, *dbus.Error) {
+	return s.d.cookie, nil
+}
+
+func (s *server) GetNetworkInterfaceNameByIndex(index int32) (string, *dbus.Error) {
+	iface, err := net.InterfaceByIndex(int(index))
+	if err != nil {
+		return "", avahiError("OSError", err.Error())
+	}
+	return iface.Name, nil
+}
+
+func (s *server) GetNetworkInterfaceIndexByName(name string) (int32, *dbus.Error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, avahiError("OSError", err.Error())
+	}
+	return int32(iface.Index), nil
+}
+
+func (s *server) EntryGroupNew(sender dbus.Sender) (dbus.ObjectPath, *dbus.Error) {
+	return s.d.add(sender, "EntryGroup", entryGroupIface, entryGroupXML, func(path dbus.ObjectPath) object {
+		return &entryGroup{d: s.d, path: path}
+	})
+}
+
+func (s *server) ServiceBrowserNew(sender dbus.Sender, iface, proto int32, typ, domain string, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	path, err := s.ServiceBrowserPrepare(sender, iface, proto, typ, domain, flags)
+	if err != nil {
+		return "", err
+	}
+	s.d.start(sender, path)
+	return path, nil
+}
+
+// ServiceBrowserPrepare creates a browser that starts when its Start method is
+// called, so that the client can subscribe to its signals first.  It is part
+// of org.freedesktop.Avahi.Server2.
+func (s *server) ServiceBrowserPrepare(sender dbus.Sender, iface, proto int32, typ, domain string, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	if _, err := lookupInterface(iface); err != nil {
+		return "", err
+	}
+	if err := checkProtocol(proto); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(typ, "_") {
+		return "", avahiError("InvalidServiceTypeError", "Invalid service type")
+	}
+	return s.d.add(sender, "ServiceBrowser", browserIface, browserXML, func(path dbus.ObjectPath) object {
+		return &serviceBrowser{d: s.d, path: path, iface: iface, proto: proto, typ: strings.TrimSuffix(typ, "."), domain: domainName(domain)}
+	})
+}
+
+func (s *server) ServiceResolverNew(sender dbus.Sender, iface, proto int32, name, typ, domain string, aproto int32, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	path, err := s.ServiceResolverPrepare(sender, iface, proto, name, typ, domain, aproto, flags)
+	if err != nil {
+		return "", err
+	}
+	s.d.start(sender, path)
+	return path, nil
+}
+
+// ServiceResolverPrepare creates a resolver that starts when its Start method
+// is called.  It is part of org.freedesktop.Avahi.Server2.
+func (s *server) ServiceResolverPrepare(sender dbus.Sender, iface, proto int32, name, typ, domain string, aproto int32, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	if _, err := lookupInterface(iface); err != nil {
+		return "", err
+	}
+	if err := checkProtocol(proto); err != nil {
+		return "", err
+	}
+	if err := checkProtocol(aproto); err != nil {
+		return "", err
+	}
+	return s.d.add(sender, "ServiceResolver", resolverIface, resolverXML, func(path dbus.ObjectPath) object {
+		return &serviceResolver{d: s.d, path: path, iface: iface, proto: proto, name: name, typ: typ, domain: domain, aproto: aproto}
+	})
+}
+
+// ResolveService resolves a service instance and returns its host, address,
+// port and TXT record, waiting for the answer.
+func (s *server) ResolveService(iface, proto int32, name, typ, domain string, aproto int32, flags uint32) (int32, int32, string, string, string, string, int32, string, uint16, [][]byte, uint32, *dbus.Error) {
+	if err := checkProtocol(aproto); err != nil {
+		return 0, 0, "", "", "", "", 0, "", 0, nil, 0, err
+	}
+	r, err := s.d.resolve(context.Background(), iface, proto, name, typ, domain, aproto)
+	if err != nil {
+		return 0, 0, "", "", "", "", 0, "", 0, nil, 0, err
+	}
+	return r.iface, r.proto, r.name, r.typ, r.domain, r.host, r.aproto, r.addr, r.port, r.txt, r.flags, nil
+}
+
+// The API's record browsers and host name and address resolvers are not
+// supported.
+
+func (s *server) ResolveHostName(iface, proto int32, name string, aproto int32, flags uint32) (int32, int32, string, int32, string, uint32, *dbus.Error) {
+	return 0, 0, "", 0, "", 0, errNotSupported
+}
+
+func (s *server) ResolveAddress(iface, proto int32, address string, flags uint32) (int32, int32, int32, string, string, uint32, *dbus.Error) {
+	return 0, 0, 0, "", "", 0, errNotSupported
+}
+
+func (s *server) ServiceTypeBrowserNew(iface, proto int32, domain string, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	return "", errNotSupported
+}
+
+func (s *server) DomainBrowserNew(iface, proto int32, domain string, btype int32, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	return "", errNotSupported
+}
+
+func (s *server) RecordBrowserNew(iface, proto int32, name string, class, typ uint16, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	return "", errNotSupported
+}
+
+// start starts a browser or resolver created by ServiceBrowserNew or
+// ServiceResolverNew.
+func (d *Daemon) start(sender dbus.Sender, path dbus.ObjectPath) {
+	d.lock.Lock()
+	var e exported
+	if c := d.clients[sender]; c != nil {
+		e = c.objects[path]
+	}
+	d.lock.Unlock()
+	if s, ok := e.obj.(interface{ Start() *dbus.Error }); ok {
+		s.Start()
+	}
+}
+
+// resolved is a resolved service, in the form of the API's results.
+type resolved struct {
+	iface, proto int32
+	name, typ    string
+	domain, host string
+	aproto       int32
+	addr         string
+	port         uint16
+	txt          [][]byte
+	flags        uint32
+}
+
+// resolve resolves a service instance with mDNS, or unicast DNS-SD for
+// domains other than "local".
+func (d *Daemon) resolve(ctx context.Context, index, proto int32, name, typ, domain string, aproto int32) (*resolved, *dbus.Error) {
+	iface, derr := lookupInterface(index)
+	if derr != nil {
+		return nil, derr
+	}
+	instance := instanceName(name, typ, domain)
+	opts := []mdns.QueryOption{mdns.WithTimeout(resolveAttempt), mdns.WithLogger(d.config.Logger)}
+	if iface != nil {
+		opts = append(opts, mdns.WithInterface(iface))
+	}
+	var e *mdns.ServiceEntry
+	deadline := time.Now().Add(resolveTimeout)
+	for {
+		var err error
+		if e, err = mdns.Resolve(ctx, instance, opts...); err == nil {
+			break
+		}
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return nil, avahiError("TimeoutError", "Timeout reached")
+		}
+	}
+
+	var addr net.IP
+	switch aproto {
+	case protoInet:
+		addr = e.AddrV4
+	case protoInet6:
+		addr = e.AddrV6
+	default:
+		if addr = e.AddrV4; addr == nil {
+			addr = e.AddrV6
+		}
+	}
+	if addr == nil {
+		return nil, avahiError("TimeoutError", "Timeout reached")
+	}
+	r := &resolved{
+		iface:  index,
+		proto:  proto,
+		name:   name,
+		typ:    strings.TrimSuffix(typ, "."),
+		domain: domainName(domain),
+		host:   strings.TrimSuffix(e.Host, "."),
+		aproto: protoInet6,
+		addr:   addr.String(),
+		port:   uint16(e.Port),
+		txt:    txtBytes(e.InfoFields),
+		flags:  d.flags(instance),
+	}
+	if addr.To4() != nil {
+		r.aproto = protoInet
+	}
+	if r.proto == protoUnspec {
+		r.proto = r.aproto
+	}
+	return r, nil
+}
+
+// flags returns the lookup result flags of a service instance.
+func (d *Daemon) flags(instance string) uint32 {
+	var flags uint32 = flagMulticast
+	if !strings.HasSuffix(strings.ToLower(instance), ".local.") {
+		flags = flagWideArea
+	}
+	if d.config.Services.Get(instance) != nil {
+		flags |= flagLocal | flagOurOwn
+	}
+	return flags
+}
@@ -0,0 +1,60 @@
+package mdns
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchWriter is an ipv4.PacketConn or ipv6.PacketConn, whose WriteBatch
+// sends several datagrams with one sendmmsg system call on Linux, and one
+// at a time elsewhere.
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// writeBatch sends each of bufs to the multicast groups, on each joined
+// interface in turn where sendPerInterface is set, and to the peers.
+func (s *Server) writeBatch(bufs [][]byte) {
+	s.writePeers(bufs)
+	if s.ipv4List != nil {
+		p := ipv4.NewPacketConn(s.ipv4List)
+		if !sendPerInterface || len(s.joined) == 0 {
+			writeBatch(p, bufs, ipv4Addr)
+		}
+		for _, iface := range s.joined {
+			if sendPerInterface && p.SetMulticastInterface(iface) == nil {
+				writeBatch(p, bufs, ipv4Addr)
+			}
+		}
+	}
+	if s.ipv6List != nil {
+		p := ipv6.NewPacketConn(s.ipv6List)
+		if !sendPerInterface || len(s.joined) == 0 {
+			writeBatch(p, bufs, ipv6Addr)
+		}
+		for _, iface := range s.joined {
+			if sendPerInterface && p.SetMulticastInterface(iface) == nil {
+				writeBatch(p, bufs, ipv6Addr)
+			}
+		}
+	}
+}
+
+// writeBatch sends each of bufs to addr through w, with as few calls as it
+// takes.  Errors are ignored, as for single sends: a host without a route
+// for one of the groups still announces on the other.
+func writeBatch(w batchWriter, bufs [][]byte, addr net.Addr) {
+	ms := make([]ipv4.Message, len(bufs))
+	for i, buf := range bufs {
+		ms[i] = ipv4.Message{Buffers: [][]byte{buf}, Addr: addr}
+	}
+	for len(ms) > 0 {
+		n, err := w.WriteBatch(ms, 0)
+		if err != nil || n == 0 {
+			return
+		}
+		ms = ms[n:]
+	}
+}
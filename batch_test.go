@@ -0,0 +1,34 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+// fakeBatchWriter sends at most two messages per call.
+type fakeBatchWriter struct {
+	calls int
+	sent  [][]byte
+}
+
+func (w *fakeBatchWriter) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	w.calls++
+	if len(ms) > 2 {
+		ms = ms[:2]
+	}
+	for _, m := range ms {
+		w.sent = append(w.sent, m.Buffers[0])
+	}
+	return len(ms), nil
+}
+
+func TestWriteBatch(t *testing.T) {
+	w := new(fakeBatchWriter)
+	bufs := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	writeBatch(w, bufs, &net.UDPAddr{IP: mdnsGroupIPv4, Port: 5353})
+	if w.calls != 2 || len(w.sent) != 3 || string(w.sent[2]) != "c" {
+		t.Errorf("sent %q in %d calls", w.sent, w.calls)
+	}
+}
@@ -0,0 +1,258 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// bridgeRefresh is the interval at which a Bridge queries for the services it
+// serves, after backing off from its first queries.
+const bridgeRefresh = time.Minute
+
+// BridgeConfig is used to configure a Bridge.
+type BridgeConfig struct {
+	// Domain is the unicast DNS domain the zone is served under, such as
+	// "lan.example.com", with names mapped from "local." as by a
+	// DiscoveryProxy.  The default is to serve the names in "local.".
+	Domain string
+
+	// Services are the service types to browse, such as "_http._tcp".  The
+	// default is every service type found with DNS-SD service enumeration.
+	Services []string
+
+	// Interface is the multicast interface to browse, default is the
+	// system's.
+	Interface *net.Interface
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Bridge serves a unicast DNS zone holding the services and hosts found on
+// the local link, so that tools that only speak ordinary DNS, such as
+// Prometheus' DNS service discovery or Ansible, can use them.  Unlike a
+// DiscoveryProxy, which queries the link for each question, a Bridge browses
+// continuously and answers from what it has found, and it supports zone
+// transfers (AXFR) over TCP.
+//
+// Bridge is a dns.Handler, so it is served with miekg/dns on any address:
+//
+//     bridge, err := mdns.NewBridge(&mdns.BridgeConfig{Domain: "lan.example.com"})
+//     ...
+//     log.Fatal(dns.ListenAndServe(":5300", "udp", bridge))
+type Bridge struct {
+	config *BridgeConfig
+	proxy  *DiscoveryProxy // Maps names between the zone and "local."
+	cache  *Cache
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBridge starts browsing for the configured services.
+func NewBridge(config *BridgeConfig) (*Bridge, error) {
+	domain := "local."
+	if config.Domain != "" {
+		domain = strings.ToLower(trimDot(config.Domain)) + "."
+	}
+	client, err := newClient(config.Logger, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.Interface != nil {
+		if err := client.setInterface(config.Interface, false); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	cache := NewCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		config: config,
+		proxy: &DiscoveryProxy{
+			config: &DiscoveryProxyConfig{Interface: config.Interface, Cache: cache, Logger: config.Logger},
+			domain: domain,
+		},
+		cache:  cache,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.browse(ctx, client)
+	return b, nil
+}
+
+// Shutdown stops browsing.  Queries are answered from the records already
+// found until they expire.
+func (b *Bridge) Shutdown() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// browse queries for the services to serve, and records every response
+// heard on the link, until ctx is done.
+func (b *Bridge) browse(ctx context.Context, client *client) {
+	defer close(b.done)
+	defer client.Close()
+
+	msgCh := make(chan *received, 32)
+	go client.recv(client.ipv4UnicastConn, msgCh)
+	go client.recv(client.ipv6UnicastConn, msgCh)
+	go client.recv(client.ipv4MulticastConn, msgCh)
+	go client.recv(client.ipv6MulticastConn, msgCh)
+
+	enum := "_services._dns-sd._udp.local."
+	types := make(map[string]bool) // Service types by lower case name
+	for _, svc := range b.config.Services {
+		types[strings.ToLower(trimDot(svc) + ".local.")] = true
+	}
+	query := func(name string) {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypePTR)
+		q.RecursionDesired = false
+		if err := client.sendQuery(q); err != nil {
+			client.logf("[ERR] mdns: Failed to query %s: %v", name, err)
+		}
+	}
+
+	interval := time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if len(b.config.Services) == 0 {
+				query(enum)
+			}
+			for name := range types {
+				query(name)
+			}
+			timer.Reset(interval)
+			if interval *= 2; interval > bridgeRefresh {
+				interval = bridgeRefresh
+			}
+		case r := <-msgCh:
+			if !r.msg.Response {
+				continue
+			}
+			b.cache.addMsg(r.msg, r.from)
+			if len(b.config.Services) != 0 {
+				continue
+			}
+			for _, rr := range r.msg.Answer {
+				ptr, ok := rr.(*dns.PTR)
+				if !ok || !strings.EqualFold(ptr.Hdr.Name, enum) {
+					continue
+				}
+				if name := strings.ToLower(ptr.Ptr); !types[name] {
+					types[name] = true
+					query(name)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ServeDNS answers a unicast DNS query from the records found on the link.
+func (b *Bridge) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeAXFR {
+		b.transfer(w, r)
+		return
+	}
+	m := b.answer(r)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		b.proxy.logf("[ERR] mdns: Bridge failed to answer %v: %v", w.RemoteAddr(), err)
+	}
+}
+
+// answer returns the response to a unicast DNS query.
+func (b *Bridge) answer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = false
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		return m
+	}
+	q := r.Question[0]
+	local, ok := b.proxy.toLocal(q.Name)
+	if !ok {
+		m.SetRcode(r, dns.RcodeRefused)
+		return m
+	}
+	m.Authoritative = true
+
+	if strings.EqualFold(dns.Fqdn(q.Name), b.proxy.domain) && (q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, b.soa())
+	}
+	answers := b.cache.Lookup(local, q.Qtype)
+	m.Answer = append(m.Answer, b.proxy.fromLocal(answers)...)
+	m.Extra = b.proxy.fromLocal(b.proxy.cachedExtra(answers))
+	if len(m.Answer) == 0 {
+		if !strings.EqualFold(dns.Fqdn(q.Name), b.proxy.domain) && len(b.cache.Lookup(local, dns.TypeANY)) == 0 {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = []dns.RR{b.soa()}
+	}
+	return m
+}
+
+// transfer sends the whole zone in answer to an AXFR query, which is only
+// allowed over TCP.
+func (b *Bridge) transfer(w dns.ResponseWriter, r *dns.Msg) {
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok || !strings.EqualFold(dns.Fqdn(r.Question[0].Name), b.proxy.domain) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+	soa := b.soa()
+	recs := append([]dns.RR{soa}, b.proxy.fromLocal(b.cache.all())...)
+	recs = append(recs, soa)
+
+	for len(recs) > 0 {
+		n := len(recs)
+		if n > 100 {
+			n = 100
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = recs[:n]
+		recs = recs[n:]
+		if err := w.WriteMsg(m); err != nil {
+			b.proxy.logf("[ERR] mdns: Bridge failed to transfer zone to %v: %v", w.RemoteAddr(), err)
+			break
+		}
+	}
+	w.Close()
+}
+
+// soa returns the SOA record of the zone.  The serial number is the current
+// time, as the zone changes whenever the link does.
+func (b *Bridge) soa() dns.RR {
+	domain := b.proxy.domain
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: proxyMaxTTL},
+		Ns:      "ns." + domain,
+		Mbox:    "hostmaster." + domain,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: uint32(bridgeRefresh / time.Second),
+		Retry:   uint32(bridgeRefresh / time.Second),
+		Expire:  uint32(time.Hour / time.Second),
+		Minttl:  proxyMaxTTL,
+	}
+}
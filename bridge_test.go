@@ -0,0 +1,89 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestBridge(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_bridgetest._tcp", "local.", "bridgehost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	b, err := NewBridge(&BridgeConfig{Domain: "lan.example.com"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Shutdown()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	dnsServer := &dns.Server{Listener: l, Handler: b}
+	go dnsServer.ActivateAndServe()
+	defer dnsServer.Shutdown()
+	addr := l.Addr().String()
+
+	c := &dns.Client{Net: "tcp", Timeout: 2 * time.Second}
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		resp, _, err := c.Exchange(q, addr)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+
+	// The service is found by enumerating the service types.
+	var resp *dns.Msg
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if resp = exchange("hostname._bridgetest._tcp.lan.example.com.", dns.TypeSRV); len(resp.Answer) > 0 {
+			break
+		}
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.SRV).Target != "bridgehost.lan.example.com." {
+		t.Fatalf("bad answer: %v", resp)
+	}
+
+	resp = exchange("nothere.lan.example.com.", dns.TypeA)
+	if resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 {
+		t.Errorf("bad answer for a missing name: %v", resp)
+	}
+	resp = exchange("example.com.", dns.TypeA)
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("query outside the zone not refused: %v", resp)
+	}
+
+	q := new(dns.Msg)
+	q.SetAxfr("lan.example.com.")
+	envs, err := new(dns.Transfer).In(q, addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	found := false
+	for env := range envs {
+		if env.Error != nil {
+			t.Fatalf("err: %v", env.Error)
+		}
+		for _, rr := range env.RR {
+			if a, ok := rr.(*dns.A); ok && a.Hdr.Name == "bridgehost.lan.example.com." {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("host address not transferred")
+	}
+}
@@ -0,0 +1,391 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// maxQueryInterval is the longest interval between repetitions of a
+// continuous query, as per section 5.2 of RFC 6762.
+const maxQueryInterval = time.Hour
+
+// BrowseEventType is the kind of change reported by a Browser.
+type BrowseEventType int
+
+const (
+	// ServiceAdded reports a newly discovered instance.
+	ServiceAdded BrowseEventType = iota
+	// ServiceUpdated reports a change to the host, port, addresses or TXT
+	// record of a known instance.
+	ServiceUpdated
+	// ServiceRemoved reports that an instance sent a goodbye or that its
+	// records expired.
+	ServiceRemoved
+)
+
+func (t BrowseEventType) String() string {
+	switch t {
+	case ServiceAdded:
+		return "added"
+	case ServiceUpdated:
+		return "updated"
+	case ServiceRemoved:
+		return "removed"
+	}
+	return fmt.Sprintf("BrowseEventType(%d)", int(t))
+}
+
+// BrowseEvent is a change to the set of instances seen by a Browser.
+type BrowseEvent struct {
+	Type  BrowseEventType
+	Entry *ServiceEntry
+}
+
+// Browser continuously browses for instances of a service and reports when
+// they appear, change and disappear.
+//
+// Unlike Lookup, which reports what answers a query in a fixed time, a
+// Browser keeps the set of instances up to date for as long as it runs: it
+// repeats its query with an interval that doubles up to an hour, re-queries
+// instances whose records are about to expire, and removes instances that
+// send goodbye packets or stop answering, once they have not answered a last
+// query for them either.  When the networks of the host's interfaces change,
+// as when a laptop roams, the records learned on the interfaces that changed
+// expire within seconds unless their responders answer again, and the query
+// starts over, asking for unicast responses first.
+type Browser struct {
+	params *QueryParam
+	events chan *BrowseEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock    sync.Mutex
+	entries map[string]*ServiceEntry // By instance name
+}
+
+// NewBrowser starts browsing for instances of service, such as "_http._tcp".
+// It accepts the same options as Lookup, except that WithTimeout and
+// WithEntriesChannel are ignored: the browser runs until ctx is done or Close
+// is called, and reports its results through Events.
+//
+// If WithCache is not given, the browser keeps a private cache.
+func NewBrowser(ctx context.Context, service string, opts ...QueryOption) (*Browser, error) {
+	params := DefaultParams(service)
+	params.Entries = nil
+	for _, opt := range opts {
+		opt(params)
+	}
+	if params.Domain == "" {
+		params.Domain = "local"
+	}
+	if params.Cache == nil {
+		params.Cache = NewCache()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	b := &Browser{
+		params:  params,
+		events:  make(chan *BrowseEvent, 16),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		entries: make(map[string]*ServiceEntry),
+	}
+	if params.System {
+		go func() {
+			defer close(b.done)
+			defer close(b.events)
+			b.runSystem(ctx)
+		}()
+		return b, nil
+	}
+
+	client, err := newClient(params.Logger, params.Metrics)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface, false); err != nil {
+			cancel()
+			client.Close()
+			return nil, err
+		}
+	}
+	client.responder = responderAddr(params.Responder)
+	client.trace = params.Trace
+	client.observer = params.Observer
+	client.authKey = params.AuthKey
+	client.peers = peerAddrs(params.Peers)
+
+	go func() {
+		defer close(b.done)
+		defer close(b.events)
+		defer client.Close()
+		b.run(ctx, client)
+	}()
+	return b, nil
+}
+
+// Events returns the channel that changes are sent to.  It must be read
+// continuously, or the browser stalls.  The channel is closed when the
+// browser stops.
+func (b *Browser) Events() <-chan *BrowseEvent {
+	return b.events
+}
+
+// Entries returns the instances currently known, sorted by name.
+func (b *Browser) Entries() []*ServiceEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entries := make([]*ServiceEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// Close stops the browser and waits for it to finish.
+func (b *Browser) Close() {
+	b.cancel()
+	<-b.done
+}
+
+// run is the browser's main loop.
+func (b *Browser) run(ctx context.Context, c *client) {
+	params := b.params
+	cache := params.Cache
+	domains := searchDomains(params)
+	serviceAddrs := make([]string, len(domains))
+	for i, domain := range domains {
+		serviceAddrs[i] = fmt.Sprintf("%s.%s.", trimDot(params.Service), domain)
+	}
+	c.trace.start(serviceAddrs[0])
+
+	msgCh := make(chan *received, 32)
+	unicastCh := make(chan *received, 32)
+	go c.recv(c.ipv4UnicastConn, unicastCh)
+	go c.recv(c.ipv6UnicastConn, unicastCh)
+	go c.recv(c.ipv4MulticastConn, msgCh)
+	go c.recv(c.ipv6MulticastConn, msgCh)
+
+	for i, domain := range domains {
+		if params.WideArea || !isLocalDomain(domain) {
+			go b.wideArea(ctx, c, domain, serviceAddrs[i], msgCh)
+		}
+	}
+
+	// Start from what the cache already knows.
+	for _, addr := range serviceAddrs {
+		for _, m := range cache.serviceMsgs(addr) {
+			select {
+			case msgCh <- &received{msg: m, at: time.Now()}:
+				c.trace.cache(m, true)
+			default:
+			}
+		}
+	}
+
+	q := browseQuery(serviceAddrs)
+	if params.WantUnicastResponse {
+		for i := range q.Question {
+			q.Question[i].Qclass |= 1 << 15
+		}
+	}
+
+	// Browsers of the same service share their queries.
+	member := joinQueryGroup(queryGroupKey(params, strings.Join(serviceAddrs, "\x00")), q, c, msgCh)
+	defer member.leave()
+	go member.forward(ctx, unicastCh, msgCh)
+
+	// Records expire without any packet arriving, so check them regularly.
+	maintain := time.NewTicker(time.Second)
+	defer maintain.Stop()
+
+	// Records learned on a network the host has left are stale.
+	netCheck := time.NewTicker(netCheckInterval)
+	defer netCheck.Stop()
+	watch := newNetWatch()
+
+	inprogress := make(map[string]*ServiceEntry)
+	delivered := make(deliveredEntries)
+	refreshes := make(map[string]int)   // Refresh queries sent for each instance
+	confirming := make(map[string]bool) // Instances asked for once more before removal
+
+	send := func(ev *BrowseEvent) bool {
+		select {
+		case b.events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case r := <-msgCh:
+			c.trace.response(r)
+			cache.addMsg(r.msg, r.from)
+			c.trace.cache(r.msg, false)
+			for _, inp := range r.entries(inprogress) {
+				domain, ok := instanceDomain(inp.Name, serviceAddrs, domains)
+				if !ok {
+					// Responses may hold records of other services.
+					c.trace.entry(inp.Name, "not an instance of "+strings.Join(serviceAddrs, " or "))
+					continue
+				}
+				if !inp.complete() {
+					c.trace.entry(inp.Name, "incomplete, asking for the missing records")
+					if m := c.followUp(inp, cache); m != nil {
+						if err := c.sendQuery(m); err != nil {
+							c.logf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+						}
+					}
+					continue
+				}
+				if inp.TTL == 0 {
+					// A goodbye; the instance is removed once its records
+					// leave the cache, unless it answers a confirmation.
+					c.trace.entry(inp.Name, "goodbye")
+					b.lock.Lock()
+					_, known := b.entries[inp.Name]
+					b.lock.Unlock()
+					if known && !confirming[inp.Name] {
+						confirming[inp.Name] = true
+						if err := c.sendQuery(instanceQuery(inp.Name)); err != nil {
+							c.logf("[ERR] mdns: Failed to confirm instance %s: %v", inp.Name, err)
+						}
+					}
+					continue
+				}
+				// The instance answered, such as to a confirmation.
+				delete(confirming, inp.Name)
+				e := delivered.next(inp)
+				if e == nil {
+					c.trace.entry(inp.Name, "already delivered")
+					continue
+				}
+				e.Domain = domain
+				c.trace.entry(e.Name, "")
+				if params.NAT64 {
+					synthesizeNAT64(ctx, e)
+				}
+				b.lock.Lock()
+				b.entries[e.Name] = e
+				b.lock.Unlock()
+				typ := ServiceAdded
+				if e.Updated {
+					typ = ServiceUpdated
+				}
+				if !send(&BrowseEvent{Type: typ, Entry: e}) {
+					return
+				}
+			}
+
+		case <-maintain.C:
+			for _, e := range b.Entries() {
+				used, ok := cache.lifetimeUsed(e.Name, dns.TypeSRV)
+				if !ok {
+					// Ask for the instance once more, and give it until
+					// the next check, a second like the goodbye grace
+					// period, to answer, so that a lost packet or a spoofed
+					// goodbye does not remove it (RFC 6762 section 10.4).
+					if !confirming[e.Name] {
+						c.trace.entry(e.Name, "records expired, confirming")
+						confirming[e.Name] = true
+						if err := c.sendQuery(instanceQuery(e.Name)); err != nil {
+							c.logf("[ERR] mdns: Failed to confirm instance %s: %v", e.Name, err)
+						}
+						continue
+					}
+					c.trace.entry(e.Name, "removed, its records expired")
+					b.lock.Lock()
+					delete(b.entries, e.Name)
+					b.lock.Unlock()
+					delete(inprogress, e.Name)
+					delete(delivered, e.Name)
+					delete(refreshes, e.Name)
+					delete(confirming, e.Name)
+					if !send(&BrowseEvent{Type: ServiceRemoved, Entry: e}) {
+						return
+					}
+					continue
+				}
+				// Ask again at 80%, 85%, 90% and 95% of the record's
+				// lifetime, as per section 5.2 of RFC 6762.
+				n := refreshes[e.Name]
+				if used < 0.8 {
+					refreshes[e.Name] = 0
+				} else if n < 4 && used >= 0.8+0.05*float64(n) {
+					refreshes[e.Name] = n + 1
+					if err := c.sendQuery(instanceQuery(e.Name)); err != nil {
+						c.logf("[ERR] mdns: Failed to refresh instance %s: %v", e.Name, err)
+					}
+				}
+			}
+
+		case <-netCheck.C:
+			if ifaces, changed := watch.check(); changed {
+				n := cache.networkChanged(ifaces)
+				c.logf("[INFO] mdns: Network changed, querying %s again and expiring %d cached records", strings.Join(serviceAddrs, ", "), n)
+				member.group.restart()
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// instanceQuery returns a query for the SRV and TXT records of an instance,
+// which refreshes or confirms them.
+func instanceQuery(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeSRV)
+	m.Question = append(m.Question, dns.Question{Name: name, Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+	m.RecursionDesired = false
+	return m
+}
+
+// wideArea browses domain with unicast DNS, by subscribing to a DNS Push
+// server if one is configured or found for the domain, and otherwise with a
+// single round of DNS-SD queries.
+func (b *Browser) wideArea(ctx context.Context, c *client, domain, serviceAddr string, msgCh chan<- *received) {
+	params := b.params
+	addr := params.PushServer
+	if addr != "" {
+		c.pushBrowse(ctx, addr, params.PushTLSConfig, serviceAddr, msgCh)
+		return
+	}
+
+	servers := params.Resolvers
+	if len(servers) == 0 {
+		var err error
+		if servers, err = systemResolvers(); err != nil {
+			c.logf("[ERR] mdns: %v", err)
+			return
+		}
+	}
+	if addr, err := discoverPushServer(ctx, servers, domain); err == nil {
+		c.pushBrowse(ctx, addr, params.PushTLSConfig, serviceAddr, msgCh)
+		return
+	}
+	c.wideAreaBrowse(ctx, serviceAddr, servers, msgCh)
+}
+
+// logf logs using the browser's logger, or the standard logger if none was
+// provided.
+func (b *Browser) logf(format string, v ...interface{}) {
+	if b.params.Logger != nil {
+		b.params.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
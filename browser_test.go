@@ -0,0 +1,223 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestServer_Browser(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	b, err := NewBrowser(context.Background(), "_foobar._tcp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	next := func() *BrowseEvent {
+		select {
+		case ev := <-b.Events():
+			return ev
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout")
+		}
+		return nil
+	}
+
+	ev := next()
+	if ev.Type != ServiceAdded || ev.Entry.Name != "hostname._foobar._tcp.local." {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+	}
+	if got := b.Entries(); len(got) != 1 {
+		t.Fatalf("bad entries: %v", got)
+	}
+
+	// Shutting down the server sends a goodbye, after which the instance is
+	// removed.
+	serv.Shutdown()
+	for ev = next(); ev.Type != ServiceRemoved; ev = next() {
+	}
+	if ev.Entry.Name != "hostname._foobar._tcp.local." {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+	}
+	if got := b.Entries(); len(got) != 0 {
+		t.Fatalf("bad entries: %v", got)
+	}
+}
+
+func TestServer_BrowserConfirmsGoodbye(t *testing.T) {
+	svc := makeServiceWithServiceName(t, "_confirm._tcp")
+	set, err := NewServiceSet(svc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	b, err := NewBrowser(context.Background(), "_confirm._tcp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	select {
+	case ev := <-b.Events():
+		if ev.Type != ServiceAdded {
+			t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// A goodbye for a service that is still served, as a spoofed one would
+	// be, is answered by the confirmation query, so the instance stays.
+	if err := serv.Withdraw(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	timeout := time.After(4 * time.Second)
+	for {
+		select {
+		case ev := <-b.Events():
+			if ev.Type == ServiceRemoved {
+				t.Fatalf("instance removed after a goodbye it contradicted")
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestServer_BrowserUpdated(t *testing.T) {
+	svc := makeServiceWithServiceName(t, "_updated._tcp")
+	set, err := NewServiceSet(svc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	b, err := NewBrowser(context.Background(), "_updated._tcp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	select {
+	case ev := <-b.Events():
+		if ev.Type != ServiceAdded {
+			t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// A changed TXT record is announced and reported as an update.
+	changed := makeServiceWithServiceName(t, "_updated._tcp")
+	changed.TXT = []string{"v=2"}
+	if _, err := set.Replace(changed); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv.Announce(changed)
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case ev := <-b.Events():
+			if ev.Type == ServiceUpdated && len(ev.Entry.InfoFields) == 1 && ev.Entry.InfoFields[0] == "v=2" {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("TXT change not reported")
+		}
+	}
+}
+
+func TestServer_BrowserAddressUpdated(t *testing.T) {
+	svc := makeServiceWithServiceName(t, "_moved._tcp")
+	set, err := NewServiceSet(svc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	b, err := NewBrowser(context.Background(), "_moved._tcp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	select {
+	case ev := <-b.Events():
+		if ev.Type != ServiceAdded || !ev.Entry.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+			t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// The host moves to another IPv4 address, keeping its IPv6 one.
+	moved := makeServiceWithServiceName(t, "_moved._tcp")
+	moved.IPs = []net.IP{net.IPv4(192, 168, 0, 43), moved.IPs[1]}
+	if _, err := set.Replace(moved); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv.Announce(moved)
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case ev := <-b.Events():
+			if ev.Type == ServiceUpdated && ev.Entry.AddrV4.Equal(net.IPv4(192, 168, 0, 43)) {
+				if !ev.Entry.AddrV6.Equal(moved.IPs[1]) {
+					t.Errorf("IPv6 address lost: %v", ev.Entry.AddrV6)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("address change not reported")
+		}
+	}
+}
+
+func TestReplacesAddr(t *testing.T) {
+	a := func(ip string, ttl uint32, class uint16) *dns.A {
+		return &dns.A{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: class, Ttl: ttl}, A: net.ParseIP(ip)}
+	}
+	srv := &dns.SRV{Hdr: dns.RR_Header{Name: "web._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120}, Target: "host.local."}
+	cur := net.ParseIP("10.0.0.1")
+	flush := uint16(dns.ClassINET | cacheFlushBit)
+
+	// The first of the records is the one checked.
+	cases := []struct {
+		name    string
+		records []dns.RR
+		want    bool
+	}{
+		{"flush", []dns.RR{a("10.0.0.2", 120, flush)}, true},
+		{"announcement", []dns.RR{a("10.0.0.2", 120, dns.ClassINET), srv}, true},
+		{"other address", []dns.RR{a("10.0.0.2", 120, dns.ClassINET)}, false},
+		{"current kept", []dns.RR{a("10.0.0.2", 120, flush), a("10.0.0.1", 120, flush)}, false},
+		{"goodbye", []dns.RR{a("10.0.0.2", 0, flush), srv}, false},
+	}
+	for _, c := range cases {
+		if got := replacesAddr(c.records, c.records[0], cur); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
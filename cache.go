@@ -0,0 +1,524 @@
+package mdns
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// cacheFlushBit is the top bit of the rrclass field.  In responses it
+	// indicates that the record is a member of a unique RRSet and that older
+	// records of the same name and type should be flushed.  See RFC 6762,
+	// section 10.2.
+	cacheFlushBit = 1 << 15
+
+	// goodbyeGrace is how long a record that was the subject of a goodbye
+	// packet, or a cache-flush instruction, remains in the cache.  See RFC 6762,
+	// sections 10.1 and 10.2.
+	goodbyeGrace = time.Second
+)
+
+// Cache holds DNS records learned from mDNS responses.
+//
+// When a Cache is supplied to Query, instances already known to the cache are
+// delivered immediately and the query sent on the network revalidates them in
+// the background.  A Cache may be shared between concurrent queries.
+//
+// The contents of a Cache can be written to disk with Save or SaveFile and
+// restored with Load or LoadFile.  Record expiry is tracked in wall clock time,
+// so time spent between saving and loading counts against each record's TTL.
+//
+// By default a Cache grows without limit.  Setting MaxEntries or MaxBytes
+// bounds it, evicting the least recently used records first.
+type Cache struct {
+	// MaxEntries is the maximum number of records held.  Zero means no limit.
+	MaxEntries int
+
+	// MaxBytes is the maximum total wire size of the records held.  Zero means
+	// no limit.
+	MaxBytes int
+
+	lock    sync.Mutex
+	records map[string]*cacheEntry
+	lru     *list.List // of *cacheEntry, most recently used first
+	bytes   int
+	stats   CacheStats
+
+	// now returns the current time; overridden in tests.
+	now func() time.Time
+}
+
+// cacheEntry is a single record held by a Cache.
+type cacheEntry struct {
+	key     string
+	rr      dns.RR
+	size    int
+	added   time.Time
+	expires time.Time
+	source  *net.UDPAddr // Responder the record was received from, if known
+	elem    *list.Element
+}
+
+// CacheStats reports the state and activity of a Cache.
+type CacheStats struct {
+	Entries     int    // Records currently held
+	Bytes       int    // Total wire size of the records currently held
+	Hits        uint64 // Lookups that found at least one record
+	Misses      uint64 // Lookups that found nothing
+	Evictions   uint64 // Records removed to stay within MaxEntries or MaxBytes
+	Expirations uint64 // Records removed because their TTL ran out
+}
+
+// cacheFileEntry is the on-disk representation of a cacheEntry.
+type cacheFileEntry struct {
+	RR      string    `json:"rr"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{
+		records: make(map[string]*cacheEntry),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// cacheKey returns the key identifying rr within a Cache.  Records are equal
+// if they have the same name (compared case-insensitively), type, class, and
+// rdata.
+func cacheKey(rr dns.RR) string {
+	hdr := *rr.Header()
+	hdr.Ttl = 0
+	hdr.Class &^= cacheFlushBit
+	return fmt.Sprintf("%s\x00%d\x00%d\x00%s", strings.ToLower(hdr.Name), hdr.Rrtype, hdr.Class,
+		strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
+
+// Add inserts rr into the cache, or refreshes the TTL of an identical record.
+//
+// A record with a TTL of zero is a goodbye, and causes the cached copy to be
+// removed after a one second grace period.
+func (c *Cache) Add(rr dns.RR) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.add(rr, c.now(), nil)
+}
+
+// add is Add without the lock held, for a record received from source, if
+// not nil.
+func (c *Cache) add(rr dns.RR, now time.Time, source *net.UDPAddr) {
+	hdr := rr.Header()
+	key := cacheKey(rr)
+
+	if hdr.Ttl == 0 {
+		if e, ok := c.records[key]; ok && e.expires.After(now.Add(goodbyeGrace)) {
+			e.expires = now.Add(goodbyeGrace)
+		}
+		return
+	}
+
+	if hdr.Class&cacheFlushBit != 0 {
+		for k, e := range c.records {
+			if k == key || !sameRRSet(e.rr, rr) || now.Sub(e.added) <= goodbyeGrace {
+				continue
+			}
+			// An instance has a single SRV and TXT record (RFC 6763
+			// section 6), so the new one replaces the old at once rather
+			// than after the grace period, and a lookup does not see both.
+			if hdr.Rrtype == dns.TypeSRV || hdr.Rrtype == dns.TypeTXT {
+				c.remove(e)
+				continue
+			}
+			if e.expires.After(now.Add(goodbyeGrace)) {
+				e.expires = now.Add(goodbyeGrace)
+			}
+		}
+	}
+
+	c.insert(&cacheEntry{
+		key:     key,
+		rr:      dns.Copy(rr),
+		added:   now,
+		expires: now.Add(time.Duration(hdr.Ttl) * time.Second),
+		source:  source,
+	})
+}
+
+// insert adds e to the cache, replacing any entry with the same key, and then
+// evicts records until the cache is within its limits.
+func (c *Cache) insert(e *cacheEntry) {
+	if old, ok := c.records[e.key]; ok {
+		c.remove(old)
+	}
+	e.size = dns.Len(e.rr)
+	e.elem = c.lru.PushFront(e)
+	c.records[e.key] = e
+	c.bytes += e.size
+
+	for c.lru.Len() > 1 && c.overLimit() {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+		c.stats.Evictions++
+	}
+}
+
+// overLimit returns true if the cache holds more than MaxEntries records or
+// MaxBytes bytes.
+func (c *Cache) overLimit() bool {
+	return (c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries) ||
+		(c.MaxBytes > 0 && c.bytes > c.MaxBytes)
+}
+
+// remove deletes e from the cache.
+func (c *Cache) remove(e *cacheEntry) {
+	delete(c.records, e.key)
+	c.lru.Remove(e.elem)
+	c.bytes -= e.size
+}
+
+// expire deletes e from the cache if its TTL has run out, and reports whether
+// it did so.
+func (c *Cache) expire(e *cacheEntry, now time.Time) bool {
+	if e.expires.After(now) {
+		return false
+	}
+	c.remove(e)
+	c.stats.Expirations++
+	return true
+}
+
+// addMsg adds every record in the answer and additional sections of m,
+// received from from, if not nil.
+func (c *Cache) addMsg(m *dns.Msg, from *net.UDPAddr) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	for _, rr := range m.Answer {
+		c.add(rr, now, from)
+	}
+	for _, rr := range m.Extra {
+		c.add(rr, now, from)
+	}
+}
+
+// sameRRSet returns true if a and b have the same name, type and class.
+func sameRRSet(a, b dns.RR) bool {
+	ah, bh := a.Header(), b.Header()
+	return ah.Rrtype == bh.Rrtype &&
+		ah.Class&^cacheFlushBit == bh.Class&^cacheFlushBit &&
+		strings.EqualFold(ah.Name, bh.Name)
+}
+
+// Lookup returns the unexpired records with the given name and type.  A qtype
+// of dns.TypeANY matches records of every type.  The TTL of each returned
+// record is set to its remaining lifetime in the cache.
+func (c *Cache) Lookup(name string, qtype uint16) []dns.RR {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	recs := c.lookup(name, qtype, c.now())
+	if len(recs) > 0 {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return recs
+}
+
+// lookup is Lookup without the lock held.  Matching records are marked as
+// recently used.
+func (c *Cache) lookup(name string, qtype uint16, now time.Time) []dns.RR {
+	var recs []dns.RR
+	for _, e := range c.records {
+		if c.expire(e, now) {
+			continue
+		}
+		hdr := e.rr.Header()
+		if qtype != dns.TypeANY && hdr.Rrtype != qtype {
+			continue
+		}
+		if !strings.EqualFold(hdr.Name, name) {
+			continue
+		}
+		c.lru.MoveToFront(e.elem)
+		recs = append(recs, e.record(now))
+	}
+	return recs
+}
+
+// lifetimeUsed returns the largest fraction of its TTL that any cached record
+// with the given name and type has used, or false if none is cached.
+func (c *Cache) lifetimeUsed(name string, qtype uint16) (float64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	used, ok := 0.0, false
+	for _, e := range c.records {
+		if c.expire(e, now) {
+			continue
+		}
+		hdr := e.rr.Header()
+		if hdr.Rrtype != qtype || !strings.EqualFold(hdr.Name, name) {
+			continue
+		}
+		ok = true
+		if life := e.expires.Sub(e.added); life > 0 {
+			if f := float64(now.Sub(e.added)) / float64(life); f > used {
+				used = f
+			}
+		}
+	}
+	return used, ok
+}
+
+// Negative returns true if a cached NSEC record for name denies that it has
+// records of type qtype, as responders do for the address family a host
+// lacks (RFC 6762, section 6.1), and no such record is cached.  There is no
+// point asking for the records until the NSEC record expires.
+func (c *Cache) Negative(name string, qtype uint16) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.negative(name, qtype, c.now())
+}
+
+// negative is Negative without the lock held.
+func (c *Cache) negative(name string, qtype uint16, now time.Time) bool {
+	if qtype == dns.TypeANY {
+		return false
+	}
+	denied := false
+	for _, e := range c.records {
+		if c.expire(e, now) {
+			continue
+		}
+		hdr := e.rr.Header()
+		if !strings.EqualFold(hdr.Name, name) {
+			continue
+		}
+		if hdr.Rrtype == qtype {
+			return false
+		}
+		if nsec, ok := e.rr.(*dns.NSEC); ok {
+			denied = true
+			for _, t := range nsec.TypeBitMap {
+				if t == qtype {
+					denied = false
+				}
+			}
+		}
+	}
+	return denied
+}
+
+// withoutNegative returns q without the questions that cached NSEC records
+// deny, or nil if none is left.  A nil cache denies nothing, and a nil
+// query is returned as is.
+func (c *Cache) withoutNegative(q *dns.Msg) *dns.Msg {
+	if c == nil || q == nil {
+		return q
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	var questions []dns.Question
+	for _, question := range q.Question {
+		if !c.negative(question.Name, question.Qtype, now) {
+			questions = append(questions, question)
+		}
+	}
+	if len(questions) == 0 {
+		return nil
+	}
+	if len(questions) == len(q.Question) {
+		return q
+	}
+	m := q.Copy()
+	m.Question = questions
+	return m
+}
+
+// Stats returns a snapshot of the cache's size and activity counters.
+func (c *Cache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// all returns every unexpired record in the cache, with TTLs set to their
+// remaining lifetimes.
+func (c *Cache) all() []dns.RR {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	var recs []dns.RR
+	for _, e := range c.records {
+		if !c.expire(e, now) {
+			recs = append(recs, e.record(now))
+		}
+	}
+	return recs
+}
+
+// record returns a copy of the cached record with the TTL set to its remaining
+// lifetime, rounded up to the nearest second.
+func (e *cacheEntry) record(now time.Time) dns.RR {
+	rr := dns.Copy(e.rr)
+	remaining := e.expires.Sub(now)
+	rr.Header().Ttl = uint32((remaining + time.Second - 1) / time.Second)
+	return rr
+}
+
+// serviceMsgs returns, for each cached instance of the given fully qualified
+// service name, a message holding the cached records needed to build its
+// ServiceEntry.
+func (c *Cache) serviceMsgs(service string) []*dns.Msg {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+
+	ptrs := c.lookup(service, dns.TypePTR, now)
+	if len(ptrs) > 0 {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+
+	var msgs []*dns.Msg
+	for _, ptr := range ptrs {
+		instance := ptr.(*dns.PTR).Ptr
+		m := new(dns.Msg)
+		m.Response = true
+		m.Answer = []dns.RR{ptr}
+		m.Extra = append(m.Extra, c.lookup(instance, dns.TypeTXT, now)...)
+		for _, srv := range c.lookup(instance, dns.TypeSRV, now) {
+			m.Extra = append(m.Extra, srv)
+			target := srv.(*dns.SRV).Target
+			m.Extra = append(m.Extra, c.lookup(target, dns.TypeA, now)...)
+			m.Extra = append(m.Extra, c.lookup(target, dns.TypeAAAA, now)...)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Save writes every unexpired record in the cache to w, one JSON object per
+// line.
+func (c *Cache) Save(w io.Writer) error {
+	c.lock.Lock()
+	var entries []cacheFileEntry
+	now := c.now()
+	// Write the least recently used records first, so that Load restores the
+	// same eviction order.
+	for elem := c.lru.Back(); elem != nil; {
+		e := elem.Value.(*cacheEntry)
+		elem = elem.Prev()
+		if c.expire(e, now) {
+			continue
+		}
+		entries = append(entries, cacheFileEntry{
+			RR:      e.rr.String(),
+			Expires: e.expires,
+		})
+	}
+	c.lock.Unlock()
+
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads records written by Save and adds them to the cache.  Records that
+// have expired since they were saved are discarded.
+func (c *Cache) Load(r io.Reader) error {
+	var entries []cacheFileEntry
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		var e cacheFileEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return fmt.Errorf("mdns: malformed cache entry %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	for _, e := range entries {
+		if !e.Expires.After(now) {
+			continue
+		}
+		rr, err := dns.NewRR(e.RR)
+		if err != nil {
+			return fmt.Errorf("mdns: malformed cached record %q: %v", e.RR, err)
+		}
+		if rr == nil {
+			continue
+		}
+		c.insert(&cacheEntry{
+			key:     cacheKey(rr),
+			rr:      rr,
+			added:   now,
+			expires: e.Expires,
+		})
+	}
+	return nil
+}
+
+// SaveFile writes the cache to the named file.  The file is replaced
+// atomically, so a concurrent LoadFile never sees a partially written cache.
+func (c *Cache) SaveFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadFile reads a cache written by SaveFile.  A missing file is not an
+// error; the cache is simply left as is.
+func (c *Cache) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
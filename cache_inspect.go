@@ -0,0 +1,107 @@
+package mdns
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// CacheRecord describes a record held by a Cache, for debugging tools.
+type CacheRecord struct {
+	Name string        // Owner name, such as "web._http._tcp.local."
+	Type string        // Record type, such as "SRV"
+	Data string        // Record data in zone file format
+	TTL  time.Duration // Remaining lifetime
+
+	// Source is the responder the record was received from, and Interface
+	// the name of the interface it arrived on.  They are not known for
+	// records added with Add or loaded from a file.
+	Source    *net.UDPAddr
+	Interface string
+
+	// RR is a copy of the record, with its TTL set to the remaining
+	// lifetime rounded up to the second.
+	RR dns.RR
+}
+
+// Entries returns the unexpired records in the cache, sorted by name and
+// type.  The interface a record arrived on is the zone of an IPv6
+// link-local source, or else the one with a network holding the source.
+func (c *Cache) Entries() []CacheRecord {
+	c.lock.Lock()
+	now := c.now()
+	var recs []CacheRecord
+	for _, e := range c.records {
+		if c.expire(e, now) {
+			continue
+		}
+		hdr := e.rr.Header()
+		recs = append(recs, CacheRecord{
+			Name:   hdr.Name,
+			Type:   dns.TypeToString[hdr.Rrtype],
+			Data:   strings.TrimPrefix(e.rr.String(), hdr.String()),
+			TTL:    e.expires.Sub(now),
+			Source: e.source,
+			RR:     e.record(now),
+		})
+	}
+	c.lock.Unlock()
+
+	var ifaces []scopeIface
+	for i := range recs {
+		src := recs[i].Source
+		if src == nil {
+			continue
+		}
+		if src.Zone != "" {
+			recs[i].Interface = src.Zone
+			continue
+		}
+		if ifaces == nil {
+			ifaces = newAddrScope(false, false).load(now)
+		}
+		if index, ok := arrival(ifaces, src, 0); ok {
+			for _, iface := range ifaces {
+				if iface.index == index {
+					recs[i].Interface = iface.name
+				}
+			}
+		}
+	}
+
+	sort.Slice(recs, func(i, j int) bool {
+		if a, b := strings.ToLower(recs[i].Name), strings.ToLower(recs[j].Name); a != b {
+			return a < b
+		}
+		if recs[i].Type != recs[j].Type {
+			return recs[i].Type < recs[j].Type
+		}
+		return recs[i].Data < recs[j].Data
+	})
+	return recs
+}
+
+// Flush removes the records with the given name and type from the cache,
+// and returns how many were removed.  A qtype of dns.TypeANY removes records
+// of every type, and an empty name those of every name, so that Flush("",
+// dns.TypeANY) empties the cache.
+func (c *Cache) Flush(name string, qtype uint16) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := 0
+	for _, e := range c.records {
+		hdr := e.rr.Header()
+		if qtype != dns.TypeANY && hdr.Rrtype != qtype {
+			continue
+		}
+		if name != "" && !strings.EqualFold(hdr.Name, dns.Fqdn(name)) {
+			continue
+		}
+		c.remove(e)
+		n++
+	}
+	return n
+}
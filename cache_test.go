@@ -0,0 +1,290 @@
+package mdns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeClock is a controllable time source for cache tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func makeTestCache() (*Cache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewCache()
+	c.now = clock.now
+	return c, clock
+}
+
+func cacheTestRecords(ttl uint32) []dns.RR {
+	return []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: "_foobar._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: "hostname._foobar._tcp.local.",
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "hostname._foobar._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			Port:   80,
+			Target: "testhost.local.",
+		},
+		&dns.TXT{
+			Hdr: dns.RR_Header{Name: "hostname._foobar._tcp.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			Txt: []string{"Local web server"},
+		},
+		&dns.A{
+			Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			A:   net.IP([]byte{192, 168, 0, 42}),
+		},
+	}
+}
+
+func TestCache_LookupExpiry(t *testing.T) {
+	c, clock := makeTestCache()
+	for _, rr := range cacheTestRecords(120) {
+		c.Add(rr)
+	}
+
+	clock.advance(20 * time.Second)
+	recs := c.Lookup("HOSTNAME._foobar._tcp.local.", dns.TypeSRV)
+	if len(recs) != 1 {
+		t.Fatalf("Lookup returned %d records, want 1: %v", len(recs), recs)
+	}
+	if got, want := recs[0].Header().Ttl, uint32(100); got != want {
+		t.Errorf("remaining TTL = %d, want %d", got, want)
+	}
+	if got := c.Lookup("hostname._foobar._tcp.local.", dns.TypeANY); len(got) != 2 {
+		t.Errorf("ANY lookup returned %v, want SRV and TXT", got)
+	}
+
+	clock.advance(100 * time.Second)
+	if got := c.Lookup("hostname._foobar._tcp.local.", dns.TypeSRV); len(got) != 0 {
+		t.Errorf("expired record returned: %v", got)
+	}
+}
+
+func TestCache_Goodbye(t *testing.T) {
+	c, clock := makeTestCache()
+	recs := cacheTestRecords(120)
+	for _, rr := range recs {
+		c.Add(rr)
+	}
+
+	bye := dns.Copy(recs[0])
+	bye.Header().Ttl = 0
+	c.Add(bye)
+	if got := c.Lookup("_foobar._tcp.local.", dns.TypePTR); len(got) != 1 {
+		t.Fatalf("record removed before goodbye grace period: %v", got)
+	}
+	clock.advance(goodbyeGrace)
+	if got := c.Lookup("_foobar._tcp.local.", dns.TypePTR); len(got) != 0 {
+		t.Errorf("record survived goodbye: %v", got)
+	}
+}
+
+func TestCache_CacheFlush(t *testing.T) {
+	c, clock := makeTestCache()
+	c.Add(cacheTestRecords(120)[3])
+	clock.advance(5 * time.Second)
+
+	moved := &dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		A:   net.IP([]byte{192, 168, 0, 43}),
+	}
+	c.Add(moved)
+	clock.advance(goodbyeGrace)
+
+	recs := c.Lookup("testhost.local.", dns.TypeA)
+	if len(recs) != 1 {
+		t.Fatalf("Lookup returned %v, want only the new address", recs)
+	}
+	if got := recs[0].(*dns.A).A; !got.Equal(moved.A) {
+		t.Errorf("Lookup returned address %v, want %v", got, moved.A)
+	}
+}
+
+func TestCache_CacheFlushTXT(t *testing.T) {
+	c, clock := makeTestCache()
+	c.Add(cacheTestRecords(120)[2])
+	clock.advance(5 * time.Second)
+
+	changed := &dns.TXT{
+		Hdr: dns.RR_Header{Name: "hostname._foobar._tcp.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		Txt: []string{"v=2"},
+	}
+	c.Add(changed)
+
+	// Unlike addresses, the old TXT record is replaced at once.
+	recs := c.Lookup("hostname._foobar._tcp.local.", dns.TypeTXT)
+	if len(recs) != 1 || recs[0].(*dns.TXT).Txt[0] != "v=2" {
+		t.Fatalf("Lookup returned %v, want only the new TXT record", recs)
+	}
+}
+
+func TestCache_SaveLoad(t *testing.T) {
+	c, clock := makeTestCache()
+	for _, rr := range cacheTestRecords(120) {
+		c.Add(rr)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Simulate a restart 30 seconds later.
+	clock.advance(30 * time.Second)
+	restored, _ := makeTestCache()
+	restored.now = clock.now
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	msgs := restored.serviceMsgs("_foobar._tcp.local.")
+	if len(msgs) != 1 {
+		t.Fatalf("serviceMsgs returned %d messages, want 1", len(msgs))
+	}
+	e := messageToEntry(msgs[0], make(map[string]*ServiceEntry))
+	if e == nil || !e.complete() {
+		t.Fatalf("restored cache produced incomplete entry: %+v", e)
+	}
+	if e.Name != "hostname._foobar._tcp.local." || e.Port != 80 || e.Info != "Local web server" {
+		t.Errorf("bad entry: %+v", e)
+	}
+	if got, want := e.TTL, 90; got != want {
+		t.Errorf("restored TTL = %d, want %d", got, want)
+	}
+
+	// Records that expire while the process is not running are dropped.
+	buf.Reset()
+	if err := restored.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	clock.advance(200 * time.Second)
+	expired, _ := makeTestCache()
+	expired.now = clock.now
+	if err := expired.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := expired.Lookup("_foobar._tcp.local.", dns.TypePTR); len(got) != 0 {
+		t.Errorf("expired record restored: %v", got)
+	}
+}
+
+func TestCache_LRUEviction(t *testing.T) {
+	c, _ := makeTestCache()
+	c.MaxEntries = 2
+	recs := cacheTestRecords(120)
+
+	c.Add(recs[0])
+	c.Add(recs[1])
+	// Touch the PTR record so that the SRV record is the least recently used.
+	c.Lookup("_foobar._tcp.local.", dns.TypePTR)
+	c.Add(recs[2])
+
+	if got := c.Lookup("hostname._foobar._tcp.local.", dns.TypeSRV); len(got) != 0 {
+		t.Errorf("least recently used record was not evicted: %v", got)
+	}
+	if got := c.Lookup("_foobar._tcp.local.", dns.TypePTR); len(got) != 1 {
+		t.Errorf("recently used record was evicted")
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("bad hit/miss counts: %+v", stats)
+	}
+}
+
+func TestCache_MaxBytes(t *testing.T) {
+	c, _ := makeTestCache()
+	recs := cacheTestRecords(120)
+	c.MaxBytes = dns.Len(recs[0]) + dns.Len(recs[3])
+
+	for _, rr := range recs {
+		c.Add(rr)
+	}
+	stats := c.Stats()
+	if stats.Bytes > c.MaxBytes {
+		t.Errorf("cache holds %d bytes, limit is %d", stats.Bytes, c.MaxBytes)
+	}
+	if got := c.Lookup("testhost.local.", dns.TypeA); len(got) != 1 {
+		t.Errorf("most recently added record was evicted")
+	}
+}
+
+func TestCache_EntriesAndFlush(t *testing.T) {
+	c, clock := makeTestCache()
+	from := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5353, Zone: "eth0"}
+	c.addMsg(&dns.Msg{Answer: cacheTestRecords(120)}, from)
+	clock.advance(20 * time.Second)
+
+	recs := c.Entries()
+	if len(recs) != 4 {
+		t.Fatalf("Entries returned %d records, want 4: %v", len(recs), recs)
+	}
+	srv := recs[1]
+	if srv.Name != "hostname._foobar._tcp.local." || srv.Type != "SRV" || srv.Data != "0 0 80 testhost.local." {
+		t.Errorf("bad record: %+v", srv)
+	}
+	if srv.TTL != 100*time.Second || srv.Source != from || srv.Interface != "eth0" {
+		t.Errorf("bad TTL, source or interface: %+v", srv)
+	}
+
+	if n := c.Flush("HOSTNAME._foobar._tcp.local", dns.TypeSRV); n != 1 {
+		t.Errorf("Flush removed %d records, want 1", n)
+	}
+	if recs := c.Lookup("hostname._foobar._tcp.local.", dns.TypeSRV); len(recs) != 0 {
+		t.Errorf("flushed record still cached: %v", recs)
+	}
+	if n := c.Flush("", dns.TypeANY); n != 3 {
+		t.Errorf("Flush removed %d records, want 3", n)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("cache not empty: %+v", stats)
+	}
+}
+
+func TestCache_Negative(t *testing.T) {
+	c, clock := makeTestCache()
+	c.Add(&dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IPv4(192, 168, 0, 42),
+	})
+	c.Add(&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET | cacheFlushBit, Ttl: 60},
+		NextDomain: "testhost.local.",
+		TypeBitMap: []uint16{dns.TypeA},
+	})
+	if !c.Negative("TestHost.local.", dns.TypeAAAA) {
+		t.Errorf("AAAA records not denied")
+	}
+	if c.Negative("testhost.local.", dns.TypeA) || c.Negative("other.local.", dns.TypeAAAA) {
+		t.Errorf("records denied without an NSEC record")
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("testhost.local.", dns.TypeA)
+	q.Question = append(q.Question, dns.Question{Name: "testhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	if m := c.withoutNegative(q); m == nil || len(m.Question) != 1 || m.Question[0].Qtype != dns.TypeA {
+		t.Errorf("bad query: %v", m)
+	}
+	if len(q.Question) != 2 {
+		t.Errorf("query changed: %v", q)
+	}
+
+	clock.advance(61 * time.Second)
+	if c.Negative("testhost.local.", dns.TypeAAAA) {
+		t.Errorf("expired NSEC record still denies AAAA records")
+	}
+}
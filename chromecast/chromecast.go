@@ -0,0 +1,181 @@
+// Package chromecast discovers Google Cast devices, which advertise
+// themselves as "_googlecast._tcp" instances with their identity in the TXT
+// record, so that cast senders do not need to parse it themselves.
+//
+// Devices are found by their friendly name, the name shown in the Google Home
+// app, with
+//
+//     d, err := chromecast.Find(ctx, "Living Room TV")
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     conn, err := net.Dial("tcp", d.Address())
+//
+// or all of them, optionally filtered, with Discover.
+package chromecast
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// Service is the service type of Google Cast devices.
+const Service = "_googlecast._tcp"
+
+// Capability is the bitmask of what a device can do, from the "ca" TXT key.
+type Capability int
+
+// Capabilities of a device.
+const (
+	VideoOut Capability = 1 << iota
+	VideoIn
+	AudioOut
+	AudioIn
+	DevMode
+	MultizoneGroup
+)
+
+// Has returns true if all the capabilities in c2 are in c.
+func (c Capability) Has(c2 Capability) bool {
+	return c&c2 == c2
+}
+
+// CastDevice is a Google Cast device, such as a Chromecast, a speaker or a
+// speaker group.
+type CastDevice struct {
+	ID           string     // The "id" TXT key, a UUID in hex without dashes
+	Name         string     // The friendly name, from the "fn" TXT key
+	Model        string     // The model name, from the "md" TXT key
+	Capabilities Capability // From the "ca" TXT key
+	IP           net.IP     // The IPv4 address if known, else the IPv6 address
+	Zone         string     // The zone of a link-local IPv6 address
+	Port         int        // The port of the Cast V2 protocol, usually 8009
+
+	// Entry is the service instance the device was found as.
+	Entry *mdns.ServiceEntry
+}
+
+// Address returns the "host:port" address the device is reached on.
+func (d *CastDevice) Address() string {
+	ip := (&net.IPAddr{IP: d.IP, Zone: d.Zone}).String()
+	return net.JoinHostPort(ip, strconv.Itoa(d.Port))
+}
+
+// Parse returns the device an instance advertises.  It fails if the instance
+// has no "id" TXT key, or if its capabilities are not a number.
+func Parse(entry *mdns.ServiceEntry) (*CastDevice, error) {
+	txt := entry.TXTMap()
+	d := &CastDevice{
+		ID:    txt["id"],
+		Name:  txt["fn"],
+		Model: txt["md"],
+		Port:  entry.Port,
+		Entry: entry,
+	}
+	if d.ID == "" {
+		return nil, fmt.Errorf("chromecast: %s has no id", entry.Name)
+	}
+	if ca, ok := txt["ca"]; ok {
+		n, err := strconv.Atoi(ca)
+		if err != nil {
+			return nil, fmt.Errorf("chromecast: %s has bad capabilities %q", entry.Name, ca)
+		}
+		d.Capabilities = Capability(n)
+	}
+	if entry.AddrV4 != nil {
+		d.IP = entry.AddrV4
+	} else {
+		d.IP, d.Zone = entry.AddrV6, entry.Zone
+	}
+	return d, nil
+}
+
+// Filter selects devices.
+type Filter func(*CastDevice) bool
+
+// WithName returns a filter selecting the devices with the given friendly
+// name, compared case-insensitively.
+func WithName(name string) Filter {
+	return func(d *CastDevice) bool {
+		return strings.EqualFold(d.Name, name)
+	}
+}
+
+// WithCapabilities returns a filter selecting the devices with all the given
+// capabilities.
+func WithCapabilities(c Capability) Filter {
+	return func(d *CastDevice) bool {
+		return d.Capabilities.Has(c)
+	}
+}
+
+// Discover looks for devices and returns those selected by filter, or all of
+// them if filter is nil, sorted by friendly name.  It returns when ctx is
+// done or the timeout given in opts, one second by default, elapses.
+// Instances that are not valid devices, or have no address, are skipped.
+func Discover(ctx context.Context, filter Filter, opts ...mdns.QueryOption) ([]*CastDevice, error) {
+	var devices []*CastDevice
+	err := discover(ctx, filter, opts, func(d *CastDevice) bool {
+		devices = append(devices, d)
+		return true
+	})
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, err
+}
+
+// Find returns the first device found with the given friendly name, or an
+// error if there is none before ctx is done or the timeout elapses.
+func Find(ctx context.Context, name string, opts ...mdns.QueryOption) (*CastDevice, error) {
+	var found *CastDevice
+	err := discover(ctx, WithName(name), opts, func(d *CastDevice) bool {
+		found = d
+		return false
+	})
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("chromecast: no device named %q found", name)
+}
+
+// discover calls found with each distinct device selected by filter, until
+// it returns false or the lookup ends.
+func discover(ctx context.Context, filter Filter, opts []mdns.QueryOption, found func(*CastDevice) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan error, 1)
+	opts = append(append([]mdns.QueryOption(nil), opts...), mdns.WithEntriesChannel(entries))
+	go func() {
+		done <- mdns.Lookup(ctx, Service, opts...)
+		close(entries)
+	}()
+
+	seen := make(map[string]bool)
+	for entry := range entries {
+		d, err := Parse(entry)
+		if err != nil || d.IP == nil || seen[d.ID] {
+			continue
+		}
+		seen[d.ID] = true
+		if filter != nil && !filter(d) {
+			continue
+		}
+		if !found(d) {
+			cancel()
+			break
+		}
+	}
+	// Drain the channel so that the lookup can finish.
+	for range entries {
+	}
+	return <-done
+}
@@ -0,0 +1,69 @@
+package chromecast
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+func TestParse(t *testing.T) {
+	d, err := Parse(&mdns.ServiceEntry{
+		Name:       "Chromecast-0123._googlecast._tcp.local.",
+		AddrV4:     net.IPv4(192, 168, 0, 42),
+		Port:       8009,
+		InfoFields: []string{"id=0123456789abcdef", "fn=Living Room TV", "md=Chromecast", "ca=4101"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.ID != "0123456789abcdef" || d.Name != "Living Room TV" || d.Model != "Chromecast" {
+		t.Errorf("bad device: %+v", d)
+	}
+	if !d.Capabilities.Has(VideoOut|AudioOut) || d.Capabilities.Has(VideoIn) {
+		t.Errorf("bad capabilities: %b", d.Capabilities)
+	}
+	if d.Address() != "192.168.0.42:8009" {
+		t.Errorf("bad address: %s", d.Address())
+	}
+
+	if _, err := Parse(&mdns.ServiceEntry{Name: "x._googlecast._tcp.local.", InfoFields: []string{"fn=x"}}); err == nil {
+		t.Errorf("expected an error without an id")
+	}
+	if _, err := Parse(&mdns.ServiceEntry{Name: "x._googlecast._tcp.local.", InfoFields: []string{"id=1", "ca=all"}}); err == nil {
+		t.Errorf("expected an error for bad capabilities")
+	}
+}
+
+func TestFind(t *testing.T) {
+	zone, err := mdns.NewMDNSService("Chromecast-0123", Service, "local.", "castdevice.", 8009,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"id=0123456789abcdef", "fn=Living Room TV", "md=Chromecast", "ca=5"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	d, err := Find(ctx, "living room tv")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.ID != "0123456789abcdef" || d.Address() != "192.168.0.42:8009" {
+		t.Errorf("bad device: %+v", d)
+	}
+
+	devices, err := Discover(context.Background(), WithCapabilities(VideoIn), mdns.WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("filter not applied: %+v", devices)
+	}
+}
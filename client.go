@@ -1,11 +1,13 @@
 package mdns
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -20,15 +22,23 @@ type ServiceEntry struct {
 	Host       string
 	AddrV4     net.IP
 	AddrV6     net.IP
+	Zone       string // IPv6 zone (interface name) of AddrV6, if it is link-local
+	Domain     string // Domain the instance was found in, such as "local"
+	NAT64      bool   // AddrV6 was synthesized from AddrV4 with the NAT64 prefix, see WithNAT64
 	Port       int
 	Info       string
 	InfoFields []string
 	TTL        int
+	LastSeen   time.Time // When a response for this entry was last received
+
+	// Updated is set when this entry refines one that was already delivered
+	// for the same instance, for example by adding the address of the other
+	// IP family or reflecting a changed port or TXT record.
+	Updated bool
 
 	Addr net.IP // @Deprecated
 
 	hasTXT bool
-	sent   bool
 }
 
 // complete is used to check if we have all the info we need
@@ -36,15 +46,106 @@ func (s *ServiceEntry) complete() bool {
 	return (s.AddrV4 != nil || s.AddrV6 != nil || s.Addr != nil) && s.Port != 0 && s.hasTXT
 }
 
+// state summarizes the resolved fields of the entry, and is used to tell
+// whether an entry carries information that has not been delivered yet.
+func (s *ServiceEntry) state() string {
+	return fmt.Sprintf("%s|%v|%v%%%s|%d|%q", s.Host, s.AddrV4, s.AddrV6, s.Zone, s.Port, s.InfoFields)
+}
+
+// Instance returns the instance label of the entry's name, unescaped, such as
+// "My Printer" for "My\ Printer._ipp._tcp.local.".
+func (s *ServiceEntry) Instance() string {
+	labels := dns.SplitDomainName(s.Name)
+	if len(labels) == 0 {
+		return ""
+	}
+	return unescapeLabel(labels[0])
+}
+
+// deliveredEntries tracks the entries handed to the caller, keyed by instance
+// name, so that the copies of a response received over both IPv4 and IPv6 are
+// only reported once.
+type deliveredEntries map[string]string
+
+// next returns the entry to deliver for inp, or nil if everything inp holds
+// has already been delivered.  The returned entry is a copy, so that later
+// changes to inp are not visible to the caller.
+func (d deliveredEntries) next(inp *ServiceEntry) *ServiceEntry {
+	state := inp.state()
+	prev, ok := d[inp.Name]
+	if ok && prev == state {
+		return nil
+	}
+	d[inp.Name] = state
+	e := *inp
+	e.Updated = ok
+	return &e
+}
+
 // QueryParam is used to customize how a Lookup is performed
 type QueryParam struct {
 	Service             string               // Service to lookup
 	Domain              string               // Lookup domain, default "local"
+	Domains             []string             // Lookup domains, instead of Domain if set, see WithDomains
 	Context             context.Context      // Context
 	Timeout             time.Duration        // Lookup timeout, default 1 second. Ignored if Context is provided
 	Interface           *net.Interface       // Multicast interface to use
 	Entries             chan<- *ServiceEntry // Entries Channel
 	WantUnicastResponse bool                 // Unicast response desired, as per 5.4 in RFC
+	Cache               *Cache               // Optional cache of records learned from earlier queries
+	Logger              *log.Logger          // Logger for errors, default is the standard logger; dropped packets are only logged to it
+	Metrics             *ClientMetrics       // Optional counters updated as the query runs
+
+	// Responder, if set, is the address of a single responder to query
+	// directly over unicast instead of multicasting the query.  The port
+	// defaults to 5353.  This is useful for probing a known device, reaching
+	// a device over a link without multicast, or checking that a cached
+	// entry is still alive.
+	Responder *net.UDPAddr
+
+	// WideArea also browses with conventional unicast DNS-SD queries sent to
+	// Resolvers, merging the results with multicast answers.  This is always
+	// done when Domain is not "local" or one of its subdomains.
+	WideArea  bool
+	Resolvers []string // Unicast DNS servers as "host:port", default from /etc/resolv.conf
+
+	// PushServer is the address, as "host:port", of a DNS Push server that
+	// a Browser subscribes to for live wide-area updates, as described in
+	// RFC 8765.  By default a Browser looks for the server in the
+	// "_dns-push-tls._tcp" SRV record of Domain, and polls Resolvers once if
+	// there is none.  PushTLSConfig is used to connect to the server.
+	PushServer    string
+	PushTLSConfig *tls.Config
+
+	// System browses through the operating system's DNS-SD daemon,
+	// mDNSResponder on macOS, instead of sending queries itself, which
+	// avoids competing with the daemon for the mDNS port.  The lookup fails
+	// on platforms without such a daemon, and Resolve does not support it.
+	// Cache, Responder, WideArea and the options that only affect queries
+	// are ignored; the daemon decides whether to browse wide-area domains.
+	System bool
+
+	// Trace, if set, records the steps of the lookup, see Trace.
+	Trace *Trace
+
+	// NAT64, if set, gives entries with only an IPv4 address the IPv6
+	// address that reaches it through the network's NAT64 prefix, if it has
+	// one, for hosts on IPv6-only networks.  See WithNAT64.
+	NAT64 bool
+
+	// Peers are also sent the queries by unicast, see WithPeers.
+	Peers []*net.UDPAddr
+
+	// AuthKey, if set, drops responses not signed with it, see WithAuthKey.
+	AuthKey []byte
+
+	// Observer, if set, is called with every message received, see
+	// WithMessageObserver.
+	Observer func(*Message)
+
+	// QuietPeriod, if set, ends a List once no instance has been found or
+	// changed for this long.
+	QuietPeriod time.Duration
 }
 
 // DefaultParams is used to return a default set of QueryParam's
@@ -61,10 +162,26 @@ func DefaultParams(service string) *QueryParam {
 // Query looks up a given service, in a domain, waiting at most
 // for a timeout before finishing the query. The results are streamed
 // to a channel. Sends will not block, so clients should make sure to
-// either read or buffer.
+// either read or buffer.  The query is repeated with a doubling interval
+// while it runs, as Lookup describes.
 func Query(params *QueryParam) error {
+	if params.System {
+		if params.Domain == "" {
+			params.Domain = "local"
+		}
+		if params.Context == nil {
+			if params.Timeout == 0 {
+				params.Timeout = time.Second
+			}
+			var cancel context.CancelFunc
+			params.Context, cancel = context.WithTimeout(context.Background(), params.Timeout)
+			defer cancel()
+		}
+		return systemQuery(params)
+	}
+
 	// Create a new client
-	client, err := newClient()
+	client, err := newClient(params.Logger, params.Metrics)
 	if err != nil {
 		return err
 	}
@@ -76,6 +193,11 @@ func Query(params *QueryParam) error {
 			return err
 		}
 	}
+	client.responder = responderAddr(params.Responder)
+	client.trace = params.Trace
+	client.observer = params.Observer
+	client.authKey = params.AuthKey
+	client.peers = peerAddrs(params.Peers)
 
 	// Ensure defaults are set
 	if params.Domain == "" {
@@ -99,7 +221,7 @@ func Query(params *QueryParam) error {
 // Listen listens indefinitely for multicast updates
 func Listen(entries chan<- *ServiceEntry, exit chan struct{}) error {
 	// Create a new client
-	client, err := newClient()
+	client, err := newClient(nil, nil)
 	if err != nil {
 		return err
 	}
@@ -108,7 +230,7 @@ func Listen(entries chan<- *ServiceEntry, exit chan struct{}) error {
 	client.setInterface(nil, true)
 
 	// Start listening for response packets
-	msgCh := make(chan *dns.Msg, 32)
+	msgCh := make(chan *received, 32)
 
 	go client.recv(client.ipv4MulticastConn, msgCh)
 	go client.recv(client.ipv6MulticastConn, msgCh)
@@ -116,6 +238,7 @@ func Listen(entries chan<- *ServiceEntry, exit chan struct{}) error {
 	go client.recv(client.ipv6MulticastConn, msgCh)
 
 	ip := make(map[string]*ServiceEntry)
+	delivered := make(deliveredEntries)
 
 	for {
 		select {
@@ -123,28 +246,24 @@ func Listen(entries chan<- *ServiceEntry, exit chan struct{}) error {
 			return nil
 		case <-client.closedCh:
 			return nil
-		case m := <-msgCh:
-			e := messageToEntry(m, ip)
-			if e == nil {
-				continue
-			}
-
-			// Check if this entry is complete
-			if e.complete() {
-				if e.sent {
-					continue
+		case r := <-msgCh:
+			sent := false
+			for _, e := range r.entries(ip) {
+				// Check if this entry is complete
+				if e.complete() {
+					if e = delivered.next(e); e == nil {
+						continue
+					}
+					entries <- e
+					sent = true
+				} else if m := client.followUp(e, nil); m != nil {
+					if err := client.sendQuery(m); err != nil {
+						client.logf("[ERR] mdns: Failed to query instance %s: %v", e.Name, err)
+					}
 				}
-				e.sent = true
-				entries <- e
+			}
+			if sent {
 				ip = make(map[string]*ServiceEntry)
-			} else {
-				// Fire off a node specific query
-				m := new(dns.Msg)
-				m.SetQuestion(e.Name, dns.TypePTR)
-				m.RecursionDesired = false
-				if err := client.sendQuery(m); err != nil {
-					log.Printf("[ERR] mdns: Failed to query instance %s: %v", e.Name, err)
-				}
 			}
 		}
 	}
@@ -152,13 +271,6 @@ func Listen(entries chan<- *ServiceEntry, exit chan struct{}) error {
 	return nil
 }
 
-// Lookup is the same as Query, however it uses all the default parameters
-func Lookup(service string, entries chan<- *ServiceEntry) error {
-	params := DefaultParams(service)
-	params.Entries = entries
-	return Query(params)
-}
-
 // Client provides a query interface that can be used to
 // search for service providers using mDNS
 type client struct {
@@ -168,72 +280,102 @@ type client struct {
 	ipv4MulticastConn *net.UDPConn
 	ipv6MulticastConn *net.UDPConn
 
+	ifaces []*net.Interface // Interfaces the groups were joined on
+
+	logger  *log.Logger
+	metrics *ClientMetrics
+
+	// responder, if not nil, is the only address queries are sent to.
+	responder *net.UDPAddr
+
+	trace *Trace // Set if the lookup is traced
+
+	observer func(*Message) // Called with every message received, if set
+	ipv6Only bool           // No interface has a usable IPv4 address
+	authKey  []byte         // Set if responses must be signed with it
+	peers    []*net.UDPAddr // Also sent queries by unicast
+	scope    *addrScope     // Finds the interfaces messages arrive on
+
 	closed    bool
 	closedCh  chan struct{} // TODO(reddaly): This doesn't appear to be used.
 	closeLock sync.Mutex
 }
 
 // NewClient creates a new mdns Client that can be used to query
-// for records
-func newClient() (*client, error) {
+// for records.  The logger and metrics may be nil.
+func newClient(logger *log.Logger, metrics *ClientMetrics) (*client, error) {
+	if metrics == nil {
+		metrics = new(ClientMetrics)
+	}
+	c := &client{
+		logger:   logger,
+		metrics:  metrics,
+		scope:    newAddrScope(false, false),
+		closedCh: make(chan struct{}),
+	}
+
 	// TODO(reddaly): At least attempt to bind to the port required in the spec.
 	// Create a IPv4 listener
-	uconn4, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	uconn4, err := listenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
 	if err != nil {
-		log.Printf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
+		c.logf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
 	}
-	uconn6, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6zero, Port: 0})
+	uconn6, err := listenUDP("udp6", &net.UDPAddr{IP: net.IPv6zero, Port: 0})
 	if err != nil {
-		log.Printf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
+		c.logf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
 	}
 
 	if uconn4 == nil && uconn6 == nil {
 		return nil, fmt.Errorf("failed to bind to any unicast udp port")
 	}
 
-	mconn4, err := net.ListenUDP("udp4", mdnsWildcardAddrIPv4)
+	mconn4, err := listenUDP("udp4", mdnsWildcardAddrIPv4)
 	if err != nil {
-		log.Printf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
+		c.logf("[ERR] mdns: Failed to bind to udp4 port: %v", err)
 	}
-	mconn6, err := net.ListenUDP("udp6", mdnsWildcardAddrIPv6)
+	mconn6, err := listenUDP("udp6", mdnsWildcardAddrIPv6)
 	if err != nil {
-		log.Printf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
+		c.logf("[ERR] mdns: Failed to bind to udp6 port: %v", err)
 	}
 
 	if mconn4 == nil && mconn6 == nil {
 		return nil, fmt.Errorf("failed to bind to any multicast udp port")
 	}
 
-	p1 := ipv4.NewPacketConn(mconn4)
-	p2 := ipv6.NewPacketConn(mconn6)
+	// Either socket is missing on a host without that address family.
+	var p1 *ipv4.PacketConn
+	if mconn4 != nil {
+		p1 = ipv4.NewPacketConn(mconn4)
+	}
+	var p2 *ipv6.PacketConn
+	if mconn6 != nil {
+		p2 = ipv6.NewPacketConn(mconn6)
+	}
 
-	ifaces, err := net.Interfaces()
+	ifaces, err := multicastInterfaces()
 	if err != nil {
 		return nil, err
 	}
 
-	var errCount1, errCount2 int
-
-	for _, iface := range ifaces {
-		if err := p1.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
-			errCount1++
-		}
-		if err := p2.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
-			errCount2++
+	for i := range ifaces {
+		iface := &ifaces[i]
+		ok1 := p1 != nil && p1.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}) == nil
+		ok2 := p2 != nil && p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}) == nil
+		if ok1 || ok2 {
+			c.ifaces = append(c.ifaces, iface)
 		}
 	}
 
-	if len(ifaces) == errCount1 && len(ifaces) == errCount2 {
+	if len(c.ifaces) == 0 {
 		return nil, fmt.Errorf("Failed to join multicast group on all interfaces!")
 	}
 
-	c := &client{
-		ipv4MulticastConn: mconn4,
-		ipv6MulticastConn: mconn6,
-		ipv4UnicastConn:   uconn4,
-		ipv6UnicastConn:   uconn6,
-		closedCh:          make(chan struct{}),
-	}
+	c.ipv4MulticastConn = mconn4
+	c.ipv6MulticastConn = mconn6
+	c.ipv4UnicastConn = uconn4
+	c.ipv6UnicastConn = uconn6
+	c.ipv6Only = uconn4 == nil || mconn4 == nil || ipv6Only(c.ifaces)
+	acquireMulticast()
 	return c, nil
 }
 
@@ -261,6 +403,7 @@ func (c *client) Close() error {
 	if c.ipv6MulticastConn != nil {
 		c.ipv6MulticastConn.Close()
 	}
+	releaseMulticast()
 
 	return nil
 }
@@ -268,46 +411,103 @@ func (c *client) Close() error {
 // setInterface is used to set the query interface, uses sytem
 // default if not provided
 func (c *client) setInterface(iface *net.Interface, loopback bool) error {
-	p := ipv4.NewPacketConn(c.ipv4UnicastConn)
-	if err := p.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
-		return err
-	}
-	p2 := ipv6.NewPacketConn(c.ipv6UnicastConn)
-	if err := p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
-		return err
-	}
-	p = ipv4.NewPacketConn(c.ipv4MulticastConn)
-	if err := p.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
-		return err
+	// On an IPv6-only host there are no IPv4 sockets.
+	if c.ipv4UnicastConn != nil && c.ipv4MulticastConn != nil {
+		p := ipv4.NewPacketConn(c.ipv4UnicastConn)
+		if err := p.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
+			return err
+		}
+		p = ipv4.NewPacketConn(c.ipv4MulticastConn)
+		if err := p.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
+			return err
+		}
+		if loopback {
+			p.SetMulticastLoopback(true)
+		}
 	}
-	p2 = ipv6.NewPacketConn(c.ipv6MulticastConn)
-	if err := p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
-		return err
+	// Nor, on an IPv4-only host, IPv6 ones.
+	if c.ipv6UnicastConn != nil && c.ipv6MulticastConn != nil {
+		p2 := ipv6.NewPacketConn(c.ipv6UnicastConn)
+		if err := p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
+			return err
+		}
+		p2 = ipv6.NewPacketConn(c.ipv6MulticastConn)
+		if err := p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
+			return err
+		}
+		if loopback {
+			p2.SetMulticastLoopback(true)
+		}
 	}
 
-	if loopback {
-		p.SetMulticastLoopback(true)
-		p2.SetMulticastLoopback(true)
+	if iface != nil {
+		c.ifaces = []*net.Interface{iface}
+		c.ipv6Only = c.ipv4UnicastConn == nil || c.ipv4MulticastConn == nil || ipv6Only(c.ifaces)
 	}
-
 	return nil
 }
 
 // query is used to perform a lookup and stream results
 func (c *client) query(params *QueryParam) error {
-	// Create the service name
-	serviceAddr := fmt.Sprintf("%s.%s.", trimDot(params.Service), trimDot(params.Domain))
+	// Create the service names
+	domains := searchDomains(params)
+	serviceAddrs := make([]string, len(domains))
+	for i, domain := range domains {
+		serviceAddrs[i] = fmt.Sprintf("%s.%s.", trimDot(params.Service), domain)
+	}
+	c.trace.start(serviceAddrs[0])
 
 	// Start listening for response packets
-	msgCh := make(chan *dns.Msg, 32)
+	msgCh := make(chan *received, 32)
+
+	// Deliver instances that are already cached first; the query sent below
+	// revalidates them.
+	cached := make(map[*dns.Msg]bool)
+	if params.Cache != nil {
+		var msgs []*dns.Msg
+		for _, addr := range serviceAddrs {
+			msgs = append(msgs, params.Cache.serviceMsgs(addr)...)
+		}
+		for _, m := range msgs {
+			cached[m] = true
+			c.trace.cache(m, true)
+		}
+		atomic.AddUint64(&c.metrics.CacheHits, uint64(len(msgs)))
+		go func() {
+			for _, m := range msgs {
+				select {
+				case msgCh <- &received{msg: m, at: time.Now()}:
+				case <-c.closedCh:
+					return
+				}
+			}
+		}()
+	}
+
 	go c.recv(c.ipv4UnicastConn, msgCh)
 	go c.recv(c.ipv6UnicastConn, msgCh)
 	go c.recv(c.ipv4MulticastConn, msgCh)
 	go c.recv(c.ipv6MulticastConn, msgCh)
 
+	var servers []string
+	for i, domain := range domains {
+		if !params.WideArea && isLocalDomain(domain) {
+			continue
+		}
+		if servers == nil {
+			servers = params.Resolvers
+			if len(servers) == 0 {
+				var err error
+				if servers, err = systemResolvers(); err != nil {
+					return err
+				}
+			}
+		}
+		go c.wideAreaBrowse(params.Context, serviceAddrs[i], servers, msgCh)
+	}
+
 	// Send the query
-	m := new(dns.Msg)
-	m.SetQuestion(serviceAddr, dns.TypePTR)
+	m := browseQuery(serviceAddrs)
 	// RFC 6762, section 18.12.  Repurposing of Top Bit of qclass in Question
 	// Section
 	//
@@ -315,42 +515,90 @@ func (c *client) query(params *QueryParam) error {
 	// field is used to indicate that unicast responses are preferred for this
 	// particular question.  (See Section 5.4.)
 	if params.WantUnicastResponse {
-		m.Question[0].Qclass |= 1 << 15
+		for i := range m.Question {
+			m.Question[i].Qclass |= 1 << 15
+		}
 	}
-	m.RecursionDesired = false
 	if err := c.sendQuery(m); err != nil {
 		return err
 	}
 
+	// Repeat the query for as long as the lookup runs, waiting one second
+	// before the first repetition and doubling the interval each time up to
+	// an hour, as described in section 5.2 of RFC 6762.
+	retransmitInterval := time.Second
+	retransmit := time.NewTimer(retransmitInterval)
+	defer retransmit.Stop()
+
 	// Map the in-progress responses
 	inprogress := make(map[string]*ServiceEntry)
+	delivered := make(deliveredEntries)
+	asked := make(map[string]bool)
+	retriedTCP := false
 
 	for {
 		select {
-		case resp := <-msgCh:
-			inp := messageToEntry(resp, inprogress)
-			if inp == nil {
-				continue
+		case <-retransmit.C:
+			atomic.AddUint64(&c.metrics.Retransmissions, 1)
+			if err := c.sendQuery(m); err != nil {
+				c.logf("[ERR] mdns: Failed to retransmit query for %s: %v", strings.Join(serviceAddrs, ", "), err)
+			}
+			if retransmitInterval *= 2; retransmitInterval > maxQueryInterval {
+				retransmitInterval = maxQueryInterval
+			}
+			retransmit.Reset(retransmitInterval)
+		case r := <-msgCh:
+			c.trace.response(r)
+			if params.Cache != nil && !cached[r.msg] {
+				params.Cache.addMsg(r.msg, r.from)
+				c.trace.cache(r.msg, false)
 			}
 
-			// Check if this entry is complete
-			if inp.complete() {
-				if inp.sent {
+			// A truncated reply to a query sent directly to a responder is a
+			// legacy unicast response, which the querier should reissue over
+			// TCP (RFC 6762, section 18.5).  The TC bit of multicast responses
+			// is ignored; records split across several packets are merged into
+			// the in-progress entries as they arrive.
+			if r.msg.Truncated && c.responder != nil && r.from != nil && !retriedTCP {
+				retriedTCP = true
+				go c.retryTCP(params.Context, m, msgCh)
+			}
+
+			for _, inp := range r.entries(inprogress) {
+				domain, ok := instanceDomain(inp.Name, serviceAddrs, domains)
+				if !ok {
+					// Responses may hold records of other services.
+					c.trace.entry(inp.Name, "not an instance of "+strings.Join(serviceAddrs, " or "))
 					continue
 				}
-				inp.sent = true
-				select {
-				case params.Entries <- inp:
-				case <-params.Context.Done():
-					return nil
-				}
-			} else {
-				// Fire off a node specific query
-				m := new(dns.Msg)
-				m.SetQuestion(inp.Name, dns.TypePTR)
-				m.RecursionDesired = false
-				if err := c.sendQuery(m); err != nil {
-					log.Printf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+				// Check if this entry is complete
+				if inp.complete() {
+					e := delivered.next(inp)
+					if e == nil {
+						c.trace.entry(inp.Name, "already delivered")
+						continue
+					}
+					e.Domain = domain
+					c.trace.entry(e.Name, "")
+					if params.NAT64 {
+						synthesizeNAT64(params.Context, e)
+					}
+					select {
+					case params.Entries <- e:
+					case <-params.Context.Done():
+						return nil
+					}
+				} else if m := c.followUp(inp, params.Cache); m != nil {
+					// Fire off a node specific query
+					c.trace.entry(inp.Name, "incomplete, asking for the missing records")
+					key := m.Question[0].Name + "/" + dns.TypeToString[m.Question[0].Qtype]
+					if asked[key] {
+						atomic.AddUint64(&c.metrics.Retransmissions, 1)
+					}
+					asked[key] = true
+					if err := c.sendQuery(m); err != nil {
+						c.logf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+					}
 				}
 			}
 		case <-params.Context.Done():
@@ -359,12 +607,94 @@ func (c *client) query(params *QueryParam) error {
 	}
 }
 
-// sendQuery is used to multicast a query out
+// isInstanceOf returns true if name is an instance of the fully qualified
+// service name, such as "web._http._tcp.local." of "_http._tcp.local.".  The
+// instances of a subtype, such as "_printer._sub._http._tcp.local.", are
+// named after the parent service type.
+func isInstanceOf(name, service string) bool {
+	name, service = strings.ToLower(name), strings.ToLower(service)
+	if i := strings.Index(service, "._sub."); i >= 0 {
+		service = service[i+len("._sub."):]
+	}
+	return len(name) > len(service)+1 && strings.HasSuffix(name, "."+service)
+}
+
+// followUpQuery returns a query for the records still missing from an
+// incomplete entry, or nil if there is nothing to ask for yet.
+func followUpQuery(inp *ServiceEntry) *dns.Msg {
+	if inp.Port == 0 && !inp.hasTXT && inp.Host == "" && (inp.AddrV4 != nil || inp.AddrV6 != nil) {
+		// Only the addresses of a host are known, not a service instance.
+		return nil
+	}
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	if inp.Port == 0 {
+		m.Question = append(m.Question, dns.Question{Name: inp.Name, Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	}
+	if !inp.hasTXT {
+		m.Question = append(m.Question, dns.Question{Name: inp.Name, Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+	}
+	if inp.AddrV4 == nil && inp.AddrV6 == nil && inp.Host != "" {
+		m.Question = append(m.Question,
+			dns.Question{Name: inp.Host, Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			dns.Question{Name: inp.Host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	}
+	if len(m.Question) == 0 {
+		return nil
+	}
+	return m
+}
+
+// retryTCP reissues q to the responder over TCP, after a truncated unicast
+// response, and delivers the full response to msgCh.
+func (c *client) retryTCP(ctx context.Context, q *dns.Msg, msgCh chan<- *received) {
+	tc := &dns.Client{Net: "tcp", Timeout: wideAreaTimeout}
+	resp, _, err := tc.ExchangeContext(ctx, q, c.responder.String())
+	if err != nil {
+		c.logf("[ERR] mdns: Failed to retry truncated query over TCP to %v: %v", c.responder, err)
+		return
+	}
+	if c.authKey != nil && !verifyMsg(c.authKey, resp, time.Now()) {
+		atomic.AddUint64(&c.metrics.Unauthenticated, 1)
+		c.debugf("[DEBUG] mdns: Dropping unauthenticated response from %v", c.responder)
+		return
+	}
+	select {
+	case msgCh <- &received{msg: resp, at: time.Now()}:
+	case <-ctx.Done():
+	}
+}
+
+// responderAddr returns addr with the port defaulted to the mDNS port, or nil
+// if addr is nil.
+func responderAddr(addr *net.UDPAddr) *net.UDPAddr {
+	if addr == nil {
+		return nil
+	}
+	a := *addr
+	if a.Port == 0 {
+		a.Port = 5353
+	}
+	return &a
+}
+
+// sendQuery is used to multicast a query out, or to send it to the
+// responder given in the query parameters.
 func (c *client) sendQuery(q *dns.Msg) error {
 	buf, err := q.Pack()
 	if err != nil {
 		return err
 	}
+	atomic.AddUint64(&c.metrics.QueriesSent, 1)
+	c.trace.query(q)
+	if c.responder != nil {
+		return c.sendTo(buf, c.responder)
+	}
+	c.sendToPeers(buf)
+	if sendPerInterface && len(c.ifaces) > 0 {
+		c.sendPerInterface(buf)
+		return nil
+	}
 	if c.ipv4UnicastConn != nil {
 		c.ipv4UnicastConn.WriteToUDP(buf, ipv4Addr)
 	}
@@ -374,8 +704,68 @@ func (c *client) sendQuery(q *dns.Msg) error {
 	return nil
 }
 
+// sendPerInterface sends a packed query to the multicast groups on each
+// joined interface in turn, for systems that only send multicast through
+// one interface.
+func (c *client) sendPerInterface(buf []byte) {
+	for _, iface := range c.ifaces {
+		if c.ipv4UnicastConn != nil {
+			p := ipv4.NewPacketConn(c.ipv4UnicastConn)
+			if p.SetMulticastInterface(iface) == nil {
+				p.WriteTo(buf, nil, ipv4Addr)
+			}
+		}
+		if c.ipv6UnicastConn != nil {
+			p := ipv6.NewPacketConn(c.ipv6UnicastConn)
+			if p.SetMulticastInterface(iface) == nil {
+				p.WriteTo(buf, nil, ipv6Addr)
+			}
+		}
+	}
+}
+
+// sendTo sends a packed query to a single address over unicast.
+func (c *client) sendTo(buf []byte, addr *net.UDPAddr) error {
+	conn := c.ipv6UnicastConn
+	if addr.IP.To4() != nil {
+		conn = c.ipv4UnicastConn
+	}
+	if conn == nil {
+		return fmt.Errorf("mdns: no socket available to query %v", addr)
+	}
+	_, err := conn.WriteToUDP(buf, addr)
+	return err
+}
+
+// received is a message read by the client.
+type received struct {
+	msg  *dns.Msg
+	from *net.UDPAddr // Source of the message; nil if not read from the network
+	at   time.Time    // When the message was received
+}
+
+// entries adds the records of the message to the in-progress entries, like
+// messageToEntries, and records when and where each entry was last seen.
+func (r *received) entries(inprogress map[string]*ServiceEntry) []*ServiceEntry {
+	responder := ""
+	if r.from != nil {
+		responder = r.from.IP.String()
+	}
+	entries := responderEntries(r.msg, inprogress, responder)
+	for _, inp := range entries {
+		inp.LastSeen = r.at
+		// A link-local IPv6 address is only usable together with the
+		// interface it was learned on, which is the zone of the responder's
+		// address.
+		if inp.AddrV6 != nil && inp.AddrV6.IsLinkLocalUnicast() && inp.Zone == "" && r.from != nil && r.from.IP.To4() == nil {
+			inp.Zone = r.from.Zone
+		}
+	}
+	return entries
+}
+
 // recv is used to receive until we get a shutdown
-func (c *client) recv(l *net.UDPConn, msgCh chan *dns.Msg) {
+func (c *client) recv(l *net.UDPConn, msgCh chan *received) {
 	if l == nil {
 		return
 	}
@@ -387,22 +777,66 @@ func (c *client) recv(l *net.UDPConn, msgCh chan *dns.Msg) {
 			return
 		}
 		c.closeLock.Unlock()
-		n, err := l.Read(buf)
+		n, from, err := l.ReadFromUDP(buf)
 		if err != nil {
 			continue
 		}
 		msg := new(dns.Msg)
 		if err := msg.Unpack(buf[:n]); err != nil {
+			atomic.AddUint64(&c.metrics.MalformedPackets, 1)
+			c.debugf("[DEBUG] mdns: Failed to unpack packet: %v", err)
 			continue
 		}
+		if msg.Response && c.authKey != nil && !verifyMsg(c.authKey, msg, time.Now()) {
+			atomic.AddUint64(&c.metrics.Unauthenticated, 1)
+			c.debugf("[DEBUG] mdns: Dropping unauthenticated response from %v", from)
+			continue
+		}
+		if msg.Response {
+			atomic.AddUint64(&c.metrics.ResponsesReceived, 1)
+		}
+		r := &received{msg: msg, from: from, at: time.Now()}
+		c.observe(r)
 		select {
-		case msgCh <- msg:
+		case msgCh <- r:
 		case <-c.closedCh:
 			return
 		}
 	}
 }
 
+// replacesAddr reports whether rr, an address record among records, replaces
+// cur, the address of the same family that its host's entry holds, such as
+// after the host moved to another address.  It does if it is not a goodbye,
+// and records, the records of one message, do not hold cur for the host but
+// say that they are all of its addresses: rr has the cache-flush bit set, or
+// they hold an SRV record naming the host, as an announcement does.  A host
+// with several addresses of a family thus keeps the one already delivered.
+func replacesAddr(records []dns.RR, rr dns.RR, cur net.IP) bool {
+	hdr := rr.Header()
+	if hdr.Ttl == 0 {
+		return false
+	}
+	complete := hdr.Class&cacheFlushBit != 0
+	for _, r := range records {
+		switch r := r.(type) {
+		case *dns.A:
+			if strings.EqualFold(r.Hdr.Name, hdr.Name) && r.A.Equal(cur) {
+				return false
+			}
+		case *dns.AAAA:
+			if strings.EqualFold(r.Hdr.Name, hdr.Name) && r.AAAA.Equal(cur) {
+				return false
+			}
+		case *dns.SRV:
+			if strings.EqualFold(r.Target, hdr.Name) {
+				complete = true
+			}
+		}
+	}
+	return complete
+}
+
 // ensureName is used to ensure the named node is in progress
 func ensureName(inprogress map[string]*ServiceEntry, name string) *ServiceEntry {
 	if inp, ok := inprogress[name]; ok {
@@ -421,15 +855,70 @@ func alias(inprogress map[string]*ServiceEntry, src, dst string) {
 	inprogress[dst] = srcEntry
 }
 
+// messageToEntry adds the records of m to the in-progress entries and returns
+// the entry of the last record, or nil if m holds no relevant records.
 func messageToEntry(m *dns.Msg, inprogress map[string]*ServiceEntry) *ServiceEntry {
+	entries := messageToEntries(m, inprogress)
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[len(entries)-1]
+}
+
+// messageToEntries adds the records of m to the in-progress entries and
+// returns every entry that m touched, in the order they first appear.  A
+// single response may describe several instances.
+func messageToEntries(m *dns.Msg, inprogress map[string]*ServiceEntry) []*ServiceEntry {
+	return responderEntries(m, inprogress, "")
+}
+
+// hostKey returns the key of the in-progress entry that the addresses of
+// host, as sent by responder, belong to.
+func hostKey(host, responder string) string {
+	return host + "\x00" + responder
+}
+
+// responderEntries is messageToEntries for a message sent by responder, or
+// by an unknown one if responder is empty.  The target host of an SRV
+// record is tied to its instance for that responder as well as for any, and
+// the address records of a responder go to the instance whose SRV record it
+// sent, so that two devices that happen to use the same host name, such as
+// "raspberrypi.local.", are not merged into one entry.  Address records go
+// to the last instance naming the host only if their responder sent no SRV
+// record for it.
+func responderEntries(m *dns.Msg, inprogress map[string]*ServiceEntry, responder string) []*ServiceEntry {
 	var inp *ServiceEntry
+	var touched []*ServiceEntry
+	ensure := func(name string) *ServiceEntry {
+		e := ensureName(inprogress, name)
+		for _, t := range touched {
+			if t == e {
+				return e
+			}
+		}
+		touched = append(touched, e)
+		return e
+	}
+	// host returns the entry that the addresses of the named host belong to.
+	host := func(name string) *ServiceEntry {
+		if responder != "" {
+			if _, ok := inprogress[hostKey(name, responder)]; ok {
+				return ensure(hostKey(name, responder))
+			}
+		}
+		return ensure(name)
+	}
 
-	for _, answer := range append(m.Answer, m.Extra...) {
+	records := append(m.Answer, m.Extra...)
+	if len(records) > maxMessageRecords {
+		records = records[:maxMessageRecords]
+	}
+	for _, answer := range records {
 		// TODO(reddaly): Check that response corresponds to serviceAddr?
 		switch rr := answer.(type) {
 		case *dns.PTR:
 			// Create new entry for this
-			inp = ensureName(inprogress, rr.Ptr)
+			inp = ensure(rr.Ptr)
 			if inp.complete() {
 				continue
 			}
@@ -437,36 +926,37 @@ func messageToEntry(m *dns.Msg, inprogress map[string]*ServiceEntry) *ServiceEnt
 			// Check for a target mismatch
 			if rr.Target != rr.Hdr.Name {
 				alias(inprogress, rr.Hdr.Name, rr.Target)
+				if responder != "" {
+					alias(inprogress, rr.Hdr.Name, hostKey(rr.Target, responder))
+				}
 			}
 
-			// Get the port
-			inp = ensureName(inprogress, rr.Hdr.Name)
-			if inp.complete() {
-				continue
-			}
+			// Get the port.  A complete entry takes the new port and
+			// host too, so that a change is delivered as an update.
+			inp = ensure(rr.Hdr.Name)
 			inp.Host = rr.Target
 			inp.Port = int(rr.Port)
 		case *dns.TXT:
-			// Pull out the txt
-			inp = ensureName(inprogress, rr.Hdr.Name)
-			if inp.complete() {
-				continue
-			}
+			// Pull out the txt, replacing that of a complete entry
+			inp = ensure(rr.Hdr.Name)
 			inp.Info = strings.Join(rr.Txt, "|")
 			inp.InfoFields = rr.Txt
 			inp.hasTXT = true
 		case *dns.A:
-			// Pull out the IP
-			inp = ensureName(inprogress, rr.Hdr.Name)
-			if inp.complete() {
+			// Pull out the IP.  Responders often answer over IPv4 and IPv6
+			// separately, so keep merging in the address of the other family
+			// even once the entry is complete, and take a new address of
+			// the same family only if it replaces the old one.
+			inp = host(rr.Hdr.Name)
+			if inp.AddrV4 != nil && !replacesAddr(records, rr, inp.AddrV4) {
 				continue
 			}
 			inp.Addr = rr.A // @Deprecated
 			inp.AddrV4 = rr.A
 		case *dns.AAAA:
 			// Pull out the IP
-			inp = ensureName(inprogress, rr.Hdr.Name)
-			if inp.complete() {
+			inp = host(rr.Hdr.Name)
+			if inp.AddrV6 != nil && !replacesAddr(records, rr, inp.AddrV6) {
 				continue
 			}
 			inp.Addr = rr.AAAA // @Deprecated
@@ -478,5 +968,5 @@ func messageToEntry(m *dns.Msg, inprogress map[string]*ServiceEntry) *ServiceEnt
 		}
 	}
 
-	return inp
+	return touched
 }
@@ -0,0 +1,43 @@
+//go:build linux && !tinygo
+// +build linux,!tinygo
+
+package mdns
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"syscall"
+	"testing"
+)
+
+// ipv6Binder refuses to bind IPv4 sockets, as on a host without IPv4.
+type ipv6Binder struct{}
+
+func (ipv6Binder) BindSocket(fd int) error {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	if _, ok := sa.(*syscall.SockaddrInet4); ok {
+		return errors.New("no IPv4")
+	}
+	return nil
+}
+
+func TestNewClient_IPv6Only(t *testing.T) {
+	SetNetworkBinder(ipv6Binder{})
+	defer SetNetworkBinder(nil)
+
+	c, err := newClient(log.New(ioutil.Discard, "", 0), nil)
+	if err != nil {
+		t.Skipf("no IPv6: %v", err)
+	}
+	defer c.Close()
+	if c.ipv4UnicastConn != nil || c.ipv4MulticastConn != nil || !c.ipv6Only {
+		t.Errorf("client has IPv4 sockets")
+	}
+	if len(c.ifaces) == 0 {
+		t.Errorf("IPv6 group not joined")
+	}
+}
@@ -0,0 +1,53 @@
+package mdns
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// ClientMetrics counts the activity of mDNS queries, so that applications can
+// monitor the health of discovery.
+//
+// A ClientMetrics may be shared by any number of concurrent queries.  The
+// counters are updated atomically; use Snapshot to read them.
+type ClientMetrics struct {
+	QueriesSent       uint64 // Queries sent on the network, including retransmissions
+	ResponsesReceived uint64 // Well-formed responses received
+	CacheHits         uint64 // Instances delivered from the cache without waiting on the network
+	Retransmissions   uint64 // Queries re-sent for a question that was already asked
+	MalformedPackets  uint64 // Packets received that could not be parsed
+	Unauthenticated   uint64 // Responses dropped for not being signed with the AuthKey
+}
+
+// Snapshot returns a copy of the counters.  Each is read atomically, but
+// not all at the same instant, so counters updated while it runs may be a
+// little out of step with each other.
+func (m *ClientMetrics) Snapshot() ClientMetrics {
+	return ClientMetrics{
+		QueriesSent:       atomic.LoadUint64(&m.QueriesSent),
+		ResponsesReceived: atomic.LoadUint64(&m.ResponsesReceived),
+		CacheHits:         atomic.LoadUint64(&m.CacheHits),
+		Retransmissions:   atomic.LoadUint64(&m.Retransmissions),
+		MalformedPackets:  atomic.LoadUint64(&m.MalformedPackets),
+		Unauthenticated:   atomic.LoadUint64(&m.Unauthenticated),
+	}
+}
+
+// logf logs using the client's logger, or the standard logger if none was
+// provided.
+func (c *client) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// debugf logs per-packet events, such as dropped responses, which can be
+// frequent on a busy network.  They are counted in the metrics, and only
+// logged if the client was given a logger.
+func (c *client) debugf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,105 @@
+package mdns
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestClientMetrics_Lookup(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_metrics._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	// A lookup lasting past the first retransmission, one second in.
+	cache := NewCache()
+	metrics := new(ClientMetrics)
+	entries := make(chan *ServiceEntry, 16)
+	err = Lookup(context.Background(), "_metrics._tcp",
+		WithEntriesChannel(entries),
+		WithTimeout(1500*time.Millisecond),
+		WithCache(cache),
+		WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	got := metrics.Snapshot()
+	if got.QueriesSent < 2 || got.Retransmissions < 1 {
+		t.Errorf("query not retransmitted: %+v", got)
+	}
+	if got.ResponsesReceived == 0 {
+		t.Errorf("no responses counted: %+v", got)
+	}
+	if got.CacheHits != 0 || got.MalformedPackets != 0 || got.Unauthenticated != 0 {
+		t.Errorf("bad counters: %+v", got)
+	}
+
+	// A second lookup finds the instance in the cache.
+	metrics = new(ClientMetrics)
+	err = Lookup(context.Background(), "_metrics._tcp",
+		WithEntriesChannel(entries),
+		WithTimeout(50*time.Millisecond),
+		WithCache(cache),
+		WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := metrics.Snapshot(); got.CacheHits == 0 || got.Retransmissions != 0 {
+		t.Errorf("bad counters: %+v", got)
+	}
+}
+
+func TestClientMetrics_Malformed(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c := &client{
+		closedCh: make(chan struct{}),
+		metrics:  new(ClientMetrics),
+		logger:   log.New(ioutil.Discard, "", 0),
+	}
+	defer func() {
+		c.closeLock.Lock()
+		c.closed = true
+		c.closeLock.Unlock()
+		close(c.closedCh)
+		l.Close()
+	}()
+	msgCh := make(chan *received, 4)
+	go c.recv(l, msgCh)
+
+	conn, err := net.DialUDP("udp4", nil, l.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	resp := new(dns.Msg)
+	resp.Response = true
+	buf, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, packet := range [][]byte{[]byte("garbage"), buf} {
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	select {
+	case <-msgCh:
+	case <-time.After(time.Second):
+		t.Fatalf("response not received")
+	}
+	got := c.metrics.Snapshot()
+	if got.MalformedPackets != 1 || got.ResponsesReceived != 1 {
+		t.Errorf("bad counters: %+v", got)
+	}
+}
@@ -0,0 +1,236 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// familyResponse returns a response for the test instance carrying only the
+// given address record, the way some responders answer separately over IPv4
+// and IPv6.
+func familyResponse(addr dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.Response = true
+	m.Answer = []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: "_foobar._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+			Ptr: "hostname._foobar._tcp.local.",
+		},
+	}
+	m.Extra = []dns.RR{
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "hostname._foobar._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+			Port:   80,
+			Target: "testhost.local.",
+		},
+		&dns.TXT{
+			Hdr: dns.RR_Header{Name: "hostname._foobar._tcp.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120},
+			Txt: []string{"Local web server"},
+		},
+		addr,
+	}
+	return m
+}
+
+func TestDeliveredEntries_MergesAddressFamilies(t *testing.T) {
+	v4 := familyResponse(&dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IP([]byte{192, 168, 0, 42}),
+	})
+	v6 := familyResponse(&dns.AAAA{
+		Hdr:  dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 120},
+		AAAA: net.ParseIP("2620:0:1000:1900:b0c2:d0b2:c411:18bc"),
+	})
+
+	inprogress := make(map[string]*ServiceEntry)
+	delivered := make(deliveredEntries)
+
+	first := delivered.next(messageToEntry(v4, inprogress))
+	if first == nil {
+		t.Fatalf("first response was not delivered")
+	}
+	if first.Updated || first.AddrV4 == nil || first.AddrV6 != nil {
+		t.Errorf("bad first entry: %+v", first)
+	}
+
+	// The same response arriving on the other socket is not delivered again.
+	if e := delivered.next(messageToEntry(v4, inprogress)); e != nil {
+		t.Errorf("duplicate response delivered: %+v", e)
+	}
+
+	second := delivered.next(messageToEntry(v6, inprogress))
+	if second == nil {
+		t.Fatalf("response adding an IPv6 address was not delivered")
+	}
+	if !second.Updated || second.AddrV4 == nil || second.AddrV6 == nil {
+		t.Errorf("bad merged entry: %+v", second)
+	}
+	if first.AddrV6 != nil {
+		t.Errorf("previously delivered entry was modified: %+v", first)
+	}
+}
+
+func TestResponderAddr(t *testing.T) {
+	if got := responderAddr(nil); got != nil {
+		t.Errorf("responderAddr(nil) = %v, want nil", got)
+	}
+
+	addr := &net.UDPAddr{IP: net.ParseIP("192.168.0.42")}
+	if got, want := responderAddr(addr).String(), "192.168.0.42:5353"; got != want {
+		t.Errorf("responderAddr(%v) = %v, want %v", addr, got, want)
+	}
+	if addr.Port != 0 {
+		t.Errorf("responderAddr modified its argument: %v", addr)
+	}
+
+	addr = &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5454, Zone: "eth0"}
+	if got, want := responderAddr(addr).String(), "[fe80::1%eth0]:5454"; got != want {
+		t.Errorf("responderAddr(%v) = %v, want %v", addr, got, want)
+	}
+}
+
+func TestMessageToEntries_MultipleInstances(t *testing.T) {
+	m := familyResponse(&dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IP([]byte{192, 168, 0, 42}),
+	})
+	other := familyResponse(&dns.A{
+		Hdr: dns.RR_Header{Name: "otherhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IP([]byte{192, 168, 0, 43}),
+	})
+	for _, rr := range append(other.Answer, other.Extra...) {
+		rr = dns.Copy(rr)
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			rr.Ptr = "other._foobar._tcp.local."
+			m.Answer = append(m.Answer, rr)
+			continue
+		case *dns.SRV:
+			rr.Hdr.Name = "other._foobar._tcp.local."
+			rr.Target = "otherhost.local."
+		case *dns.TXT:
+			rr.Hdr.Name = "other._foobar._tcp.local."
+		}
+		m.Extra = append(m.Extra, rr)
+	}
+
+	entries := messageToEntries(m, make(map[string]*ServiceEntry))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if !e.complete() {
+			t.Errorf("incomplete entry: %+v", e)
+		}
+	}
+}
+
+func TestReceived_EntriesPerResponder(t *testing.T) {
+	// Two devices use the same host name, and send their addresses after
+	// their SRV records.
+	service := func(instance string) *dns.Msg {
+		m := familyResponse(&dns.A{
+			Hdr: dns.RR_Header{Name: "raspberrypi.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+			A:   net.IPv4(192, 168, 0, 1),
+		})
+		m.Answer[0].(*dns.PTR).Ptr = instance
+		m.Extra[0].(*dns.SRV).Hdr.Name = instance
+		m.Extra[0].(*dns.SRV).Target = "raspberrypi.local."
+		m.Extra[1].(*dns.TXT).Hdr.Name = instance
+		m.Extra = m.Extra[:2]
+		return m
+	}
+	addr := func(ip net.IP) *dns.Msg {
+		m := new(dns.Msg)
+		m.Response = true
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "raspberrypi.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+			A:   ip,
+		}}
+		return m
+	}
+	first := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Port: 5353}
+	second := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 43), Port: 5353}
+
+	inprogress := make(map[string]*ServiceEntry)
+	(&received{msg: service("a._foobar._tcp.local."), from: first}).entries(inprogress)
+	(&received{msg: service("b._foobar._tcp.local."), from: second}).entries(inprogress)
+	(&received{msg: addr(first.IP), from: first}).entries(inprogress)
+	(&received{msg: addr(second.IP), from: second}).entries(inprogress)
+
+	if e := inprogress["a._foobar._tcp.local."]; !e.AddrV4.Equal(first.IP) {
+		t.Errorf("first instance has address %v, want %v", e.AddrV4, first.IP)
+	}
+	if e := inprogress["b._foobar._tcp.local."]; !e.AddrV4.Equal(second.IP) {
+		t.Errorf("second instance has address %v, want %v", e.AddrV4, second.IP)
+	}
+}
+
+func TestMessageToEntries_SplitAcrossPackets(t *testing.T) {
+	full := familyResponse(&dns.A{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   net.IP([]byte{192, 168, 0, 42}),
+	})
+	first := &dns.Msg{Answer: full.Answer, Extra: full.Extra[:1]}
+	first.Response, first.Truncated = true, true
+	second := &dns.Msg{Extra: full.Extra[1:]}
+	second.Response = true
+
+	inprogress := make(map[string]*ServiceEntry)
+	e := messageToEntry(first, inprogress)
+	if e == nil || e.complete() {
+		t.Fatalf("first packet should yield an incomplete entry: %+v", e)
+	}
+	q := followUpQuery(e)
+	if q == nil || len(q.Question) != 3 || q.Question[0].Qtype != dns.TypeTXT || q.Question[1].Name != "testhost.local." {
+		t.Errorf("follow-up query should ask for the missing TXT and address records: %v", q)
+	}
+
+	if e := messageToEntry(second, inprogress); e == nil || !e.complete() {
+		t.Fatalf("entry not completed by second packet: %+v", e)
+	}
+}
+
+func TestIsInstanceOf(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want bool
+	}{
+		{"web._http._tcp.local.", true},
+		{"Web._HTTP._tcp.local.", true},
+		{"my.web._http._tcp.local.", true},
+		{"_http._tcp.local.", false},
+		{"web._ssh._tcp.local.", false},
+		{"web.x_http._tcp.local.", false},
+		{"testhost.local.", false},
+	} {
+		if got := isInstanceOf(test.name, "_http._tcp.local."); got != test.want {
+			t.Errorf("isInstanceOf(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsInstanceOf_Subtype(t *testing.T) {
+	if !isInstanceOf("web._http._tcp.local.", "_printer._sub._http._tcp.local.") {
+		t.Errorf("instance of a subtype not recognized")
+	}
+	if isInstanceOf("web._ipp._tcp.local.", "_printer._sub._http._tcp.local.") {
+		t.Errorf("instance of another service recognized")
+	}
+}
+
+func TestServiceEntry_Instance(t *testing.T) {
+	for name, want := range map[string]string{
+		`web._http._tcp.local.`:                  "web",
+		`My\ Printer._ipp._tcp.local.`:           "My Printer",
+		`AABBCCDDEEFF\@Living\ Room._raop._tcp.`: "AABBCCDDEEFF@Living Room",
+		`a\.b._http._tcp.local.`:                 "a.b",
+		`caf\195\169._http._tcp.local.`:          "café",
+	} {
+		if got := (&ServiceEntry{Name: name}).Instance(); got != want {
+			t.Errorf("Instance of %q = %q, want %q", name, got, want)
+		}
+	}
+}
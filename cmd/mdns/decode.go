@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	mdnsPort = 5353
+
+	// cacheFlushBit is the top bit of the class of a record in a response,
+	// and unicastBit that of a question.
+	cacheFlushBit = 1 << 15
+	unicastBit    = 1 << 15
+)
+
+// packet is an mDNS message read by decode, with its addresses when it was
+// read from a capture.
+type packet struct {
+	data     []byte
+	at       time.Time
+	src, dst *net.UDPAddr // Nil for a hex dump
+}
+
+func decode(args []string) error {
+	fs := newFlagSet("decode")
+	port := fs.Int("port", mdnsPort, "UDP port of the packets to decode from a capture")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var packets []*packet
+	if isPcap(data) {
+		packets, err = readPcap(data, *port)
+	} else {
+		packets, err = readHex(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for i, p := range packets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printPacket(w, i+1, p)
+	}
+	return nil
+}
+
+// readHex reads messages written as hexadecimal, such as by "xxd -p", one
+// per paragraph.  Spaces, colons and "0x" prefixes are ignored.
+func readHex(data []byte) ([]*packet, error) {
+	var packets []*packet
+	for i, para := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n\n") {
+		digits := strings.NewReplacer("0x", "", "0X", "", ":", "", " ", "", "\t", "", "\n", "").Replace(para)
+		if digits == "" {
+			continue
+		}
+		buf, err := hex.DecodeString(digits)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %v", i+1, err)
+		}
+		packets = append(packets, &packet{data: buf})
+	}
+	return packets, nil
+}
+
+// pcap link types, from https://www.tcpdump.org/linktypes.html.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkLinuxSLL = 113
+	linkRawIPv4  = 228
+	linkRawIPv6  = 229
+)
+
+// isPcap returns true if data starts with the magic number of a pcap file.
+func isPcap(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	}
+	return false
+}
+
+// readPcap reads the UDP packets to or from port in a capture in the
+// classic pcap format.  Other packets are skipped.
+func readPcap(data []byte, port int) ([]*packet, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("truncated pcap header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	nano := false
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0xa1b23c4d:
+		nano = true
+	case 0x4d3cb2a1:
+		order, nano = binary.BigEndian, true
+	}
+	link := order.Uint32(data[20:]) & 0xffff
+
+	var packets []*packet
+	for rest := data[24:]; len(rest) > 0; {
+		if len(rest) < 16 {
+			return packets, fmt.Errorf("truncated pcap record")
+		}
+		sec, frac, n := order.Uint32(rest), order.Uint32(rest[4:]), int(order.Uint32(rest[8:]))
+		if len(rest) < 16+n {
+			return packets, fmt.Errorf("truncated pcap record")
+		}
+		frame := rest[16 : 16+n]
+		rest = rest[16+n:]
+
+		at := time.Unix(int64(sec), int64(frac)*1000)
+		if nano {
+			at = time.Unix(int64(sec), int64(frac))
+		}
+		p := udpPacket(link, frame)
+		if p == nil || (p.src.Port != port && p.dst.Port != port) {
+			continue
+		}
+		p.at = at
+		packets = append(packets, p)
+	}
+	return packets, nil
+}
+
+// udpPacket returns the UDP datagram in a captured frame of the given link
+// type, or nil if it holds none.
+func udpPacket(link uint32, frame []byte) *packet {
+	var ethertype uint16
+	switch link {
+	case linkEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+		ethertype, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		// Skip VLAN tags.
+		for (ethertype == 0x8100 || ethertype == 0x88a8) && len(frame) >= 4 {
+			ethertype, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		ethertype, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkNull, linkLoop:
+		if len(frame) < 4 {
+			return nil
+		}
+		// The address family is in host byte order; IPv4 is 2 everywhere.
+		if frame[0] == 2 || frame[3] == 2 {
+			ethertype = 0x0800
+		} else {
+			ethertype = 0x86dd
+		}
+		frame = frame[4:]
+	case linkRaw, linkRawIPv4, linkRawIPv6:
+		if len(frame) == 0 {
+			return nil
+		}
+		ethertype = 0x0800
+		if frame[0]>>4 == 6 {
+			ethertype = 0x86dd
+		}
+	default:
+		return nil
+	}
+
+	var src, dst net.IP
+	switch ethertype {
+	case 0x0800:
+		if len(frame) < 20 || frame[9] != 17 {
+			return nil
+		}
+		ihl := int(frame[0]&0x0f) * 4
+		if len(frame) < ihl {
+			return nil
+		}
+		src, dst, frame = net.IP(frame[12:16]), net.IP(frame[16:20]), frame[ihl:]
+	case 0x86dd:
+		// Extension headers are not followed.
+		if len(frame) < 40 || frame[6] != 17 {
+			return nil
+		}
+		src, dst, frame = net.IP(frame[8:24]), net.IP(frame[24:40]), frame[40:]
+	default:
+		return nil
+	}
+	if len(frame) < 8 {
+		return nil
+	}
+	return &packet{
+		data: frame[8:],
+		src:  &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(frame))},
+		dst:  &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(frame[2:]))},
+	}
+}
+
+// printPacket prints message n with the notes of RFC 6762 that apply to it.
+func printPacket(w io.Writer, n int, p *packet) {
+	fmt.Fprintf(w, "Message %d", n)
+	if !p.at.IsZero() {
+		fmt.Fprintf(w, " at %s", p.at.Format("15:04:05.000000"))
+	}
+	if p.src != nil {
+		fmt.Fprintf(w, ", %v -> %v", p.src, p.dst)
+	}
+	fmt.Fprintf(w, ", %d bytes\n", len(p.data))
+
+	m := new(dns.Msg)
+	if err := m.Unpack(p.data); err != nil {
+		fmt.Fprintf(w, "  malformed: %v\n", err)
+		return
+	}
+
+	kind := "query"
+	if m.Response {
+		kind = "response"
+	}
+	var notes []string
+	legacy := false
+	if p.src != nil {
+		switch {
+		case !m.Response && p.src.Port != mdnsPort:
+			legacy = true
+			notes = append(notes, fmt.Sprintf("legacy unicast query from port %d: answered by unicast, with its ID and without cache-flush bits (section 6.7)", p.src.Port))
+		case m.Response && p.dst.Port != mdnsPort:
+			legacy = true
+			notes = append(notes, fmt.Sprintf("legacy unicast response to port %d (section 6.7)", p.dst.Port))
+		case m.Response && !p.dst.IP.IsMulticast():
+			notes = append(notes, "unicast response (section 5.4)")
+		}
+	}
+	if m.Id != 0 && !legacy {
+		notes = append(notes, fmt.Sprintf("ID %d should be zero in multicast DNS (section 18.1)", m.Id))
+	}
+	if m.Opcode != dns.OpcodeQuery {
+		notes = append(notes, fmt.Sprintf("opcode %s is ignored by mDNS responders (section 18.3)", dns.OpcodeToString[m.Opcode]))
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		notes = append(notes, fmt.Sprintf("rcode %s should be zero (section 18.11)", dns.RcodeToString[m.Rcode]))
+	}
+	if m.Truncated {
+		if m.Response {
+			notes = append(notes, "TC: more records follow in other packets")
+		} else {
+			notes = append(notes, "TC: more known answers follow in other packets (section 7.2)")
+		}
+	}
+	if !m.Response && len(m.Ns) > 0 {
+		notes = append(notes, "probe: the authority section holds the proposed records, for tiebreaking (section 8.2)")
+	}
+	fmt.Fprintf(w, "  %s, id %d, %d questions, %d answers, %d authority, %d additional\n",
+		kind, m.Id, len(m.Question), len(m.Answer), len(m.Ns), len(m.Extra))
+	for _, note := range notes {
+		fmt.Fprintf(w, "  note: %s\n", note)
+	}
+
+	if len(m.Question) > 0 {
+		fmt.Fprintln(w, "  Questions:")
+		for _, q := range m.Question {
+			bit := "QM"
+			if q.Qclass&unicastBit != 0 {
+				bit = "QU, asks for a unicast response (section 5.4)"
+			}
+			fmt.Fprintf(w, "    %s %s %s  [%s]\n", q.Name, dns.ClassToString[q.Qclass&^unicastBit], dns.TypeToString[q.Qtype], bit)
+		}
+	}
+	answers := "Answers"
+	if !m.Response {
+		answers = "Known answers (section 7.1)"
+	}
+	printSection(w, answers, m.Answer, m.Response)
+	authority := "Authority"
+	if !m.Response && len(m.Ns) > 0 {
+		authority = "Proposed records (section 8.2)"
+	}
+	printSection(w, authority, m.Ns, false)
+	printSection(w, "Additional", m.Extra, m.Response)
+}
+
+// printSection prints the records of a section, noting the cache-flush bit
+// of those in responses and goodbyes.
+func printSection(w io.Writer, title string, rrs []dns.RR, response bool) {
+	if len(rrs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT {
+			fmt.Fprintf(w, "    %s\n", strings.TrimSpace(strings.Replace(rr.String(), "\n", " ", -1)))
+			continue
+		}
+		var notes []string
+		if response && hdr.Class&cacheFlushBit != 0 {
+			notes = append(notes, "cache-flush (section 10.2)")
+		}
+		if response && hdr.Ttl == 0 {
+			notes = append(notes, "goodbye (section 10.1)")
+		}
+		if hdr.Rrtype == dns.TypeNSEC && response {
+			notes = append(notes, "denies the types not listed (section 6.1)")
+		}
+		data := strings.TrimPrefix(rr.String(), hdr.String())
+		line := fmt.Sprintf("    %s %d %s %s %s", hdr.Name, hdr.Ttl, dns.ClassToString[hdr.Class&^cacheFlushBit], dns.TypeToString[hdr.Rrtype], data)
+		if len(notes) > 0 {
+			line += "  [" + strings.Join(notes, ", ") + "]"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
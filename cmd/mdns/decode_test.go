@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ptrQuery is the mDNS query in testdata/messages.hex, for _http._tcp.local PTR.
+var ptrQuery = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x05, '_', 'h', 't', 't', 'p', 0x04, '_', 't', 'c', 'p', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00,
+	0x00, 0x0c, 0x00, 0x01,
+}
+
+func TestReadHex(t *testing.T) {
+	cases := []struct {
+		name  string
+		in    string
+		count int
+		err   bool
+	}{
+		{"xxd", "0000000000010000\n00000000055f6874\n\n", 1, false},
+		{"spaced", "00 00 00 00\t00 01", 1, false},
+		{"prefixed", "0x00:0x00:0X00:0x00", 1, false},
+		{"paragraphs", "0000\r\n\r\n0001\n\n\n\n0002", 3, false},
+		{"empty", "\n\n", 0, false},
+		{"odd", "000", 0, true},
+		{"bad digit", "00zz", 0, true},
+	}
+	for _, c := range cases {
+		packets, err := readHex([]byte(c.in))
+		if (err != nil) != c.err {
+			t.Errorf("%s: err: %v", c.name, err)
+			continue
+		}
+		if len(packets) != c.count {
+			t.Errorf("%s: got %d messages, want %d", c.name, len(packets), c.count)
+		}
+	}
+
+	data, err := ioutil.ReadFile("testdata/messages.hex")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if isPcap(data) {
+		t.Fatalf("hex dump taken for a capture")
+	}
+	packets, err := readHex(data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("got %d messages, want 2", len(packets))
+	}
+	if !bytes.Equal(packets[0].data, ptrQuery) || packets[0].src != nil {
+		t.Errorf("bad query: %x", packets[0].data)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(packets[1].data); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !m.Response || len(m.Answer) != 1 || m.Answer[0].(*dns.PTR).Ptr != "web._http._tcp.local." {
+		t.Errorf("bad response: %v", m)
+	}
+}
+
+func TestIsPcap(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want bool
+	}{
+		{[]byte{0xd4, 0xc3, 0xb2, 0xa1}, true},
+		{[]byte{0xa1, 0xb2, 0xc3, 0xd4}, true},
+		{[]byte{0x4d, 0x3c, 0xb2, 0xa1}, true},
+		{[]byte{0xa1, 0xb2, 0x3c, 0x4d}, true},
+		{[]byte{0x0a, 0x0d, 0x0d, 0x0a}, false}, // pcapng
+		{[]byte("0000"), false},
+		{[]byte{0xd4, 0xc3}, false},
+	}
+	for _, c := range cases {
+		if got := isPcap(c.data); got != c.want {
+			t.Errorf("isPcap(%x) = %v, want %v", c.data, got, c.want)
+		}
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/mdns.pcap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !isPcap(data) {
+		t.Fatalf("capture not recognized")
+	}
+
+	// The capture holds an IPv4 query, a unicast DNS query, a response over
+	// IPv6 in a VLAN and a legacy unicast query, all over Ethernet.
+	packets, err := readPcap(data, mdnsPort)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := []struct {
+		src, dst string
+		at       time.Time
+	}{
+		{"192.168.1.10:5353", "224.0.0.251:5353", time.Unix(1700000000, 1000)},
+		{"[fe80::1]:5353", "[ff02::fb]:5353", time.Unix(1700000001, 0)},
+		{"192.168.1.20:50000", "224.0.0.251:5353", time.Unix(1700000002, 0)},
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(packets), len(want))
+	}
+	for i, w := range want {
+		p := packets[i]
+		if p.src.String() != w.src || p.dst.String() != w.dst || !p.at.Equal(w.at) {
+			t.Errorf("packet %d: %v -> %v at %v, want %s -> %s at %v", i+1, p.src, p.dst, p.at, w.src, w.dst, w.at)
+		}
+	}
+	if !bytes.Equal(packets[0].data[:len(ptrQuery)-4], ptrQuery[:len(ptrQuery)-4]) {
+		t.Errorf("bad payload: %x", packets[0].data)
+	}
+
+	if packets, err := readPcap(data, 53); err != nil || len(packets) != 1 {
+		t.Errorf("got %d packets on port 53: %v", len(packets), err)
+	}
+	if packets, err := readPcap(data[:len(data)-1], mdnsPort); err == nil || len(packets) != 2 {
+		t.Errorf("truncated capture: %d packets, err %v", len(packets), err)
+	}
+	if _, err := readPcap(data[:20], mdnsPort); err == nil {
+		t.Errorf("truncated header accepted")
+	}
+}
+
+func TestUDPPacket(t *testing.T) {
+	udp := []byte{0x14, 0xe9, 0x14, 0xe9, 0x00, 0x0c, 0x00, 0x00, 'd', 'a', 't', 'a'}
+	ipv4 := append([]byte{
+		0x45, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0xff, 17, 0x00, 0x00,
+		192, 168, 1, 10, 224, 0, 0, 251,
+	}, udp...)
+	ipv6 := append([]byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x0c, 17, 0xff,
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfb,
+	}, udp...)
+	tcp := append([]byte(nil), ipv4...)
+	tcp[9] = 6
+	sll := append(make([]byte, 14), 0x08, 0x00)
+
+	cases := []struct {
+		name  string
+		link  uint32
+		frame []byte
+		src   string
+	}{
+		{"null", linkNull, append([]byte{2, 0, 0, 0}, ipv4...), "192.168.1.10:5353"},
+		{"loop", linkLoop, append([]byte{0, 0, 0, 2}, ipv4...), "192.168.1.10:5353"},
+		{"null ipv6", linkNull, append([]byte{30, 0, 0, 0}, ipv6...), "[fe80::1]:5353"},
+		{"raw", linkRaw, ipv4, "192.168.1.10:5353"},
+		{"raw ipv6", linkRawIPv6, ipv6, "[fe80::1]:5353"},
+		{"sll", linkLinuxSLL, append(sll, ipv4...), "192.168.1.10:5353"},
+		{"tcp", linkRaw, tcp, ""},
+		{"short", linkRaw, ipv4[:24], ""},
+		{"unknown link", 147, ipv4, ""},
+	}
+	for _, c := range cases {
+		p := udpPacket(c.link, c.frame)
+		if c.src == "" {
+			if p != nil {
+				t.Errorf("%s: got a packet from %v", c.name, p.src)
+			}
+			continue
+		}
+		if p == nil {
+			t.Errorf("%s: no packet", c.name)
+			continue
+		}
+		if p.src.String() != c.src || string(p.data) != "data" {
+			t.Errorf("%s: got %q from %v", c.name, p.data, p.src)
+		}
+	}
+}
+
+func TestPrintPacket(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/mdns.pcap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	packets, err := readPcap(data, mdnsPort)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	packets = append(packets, &packet{data: []byte{0x00}})
+
+	cases := []string{
+		"QU, asks for a unicast response",
+		"web._http._tcp.local.",
+		"legacy unicast query from port 50000",
+		"malformed",
+	}
+	for i, want := range cases {
+		var buf bytes.Buffer
+		printPacket(&buf, i+1, packets[i])
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("message %d does not mention %q:\n%s", i+1, want, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	printPacket(&buf, 1, &packet{data: ptrQuery, src: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: mdnsPort}, dst: &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}})
+	if strings.Contains(buf.String(), "note:") {
+		t.Errorf("notes for a plain query:\n%s", buf.String())
+	}
+}
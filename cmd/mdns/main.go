@@ -0,0 +1,461 @@
+// Command mdns browses, resolves, publishes and queries mDNS services.
+//
+// Usage:
+//     mdns browse [flags] <service>          e.g. mdns browse _http._tcp
+//     mdns resolve [flags] <instance>        e.g. mdns resolve "My Printer._ipp._tcp.local."
+//     mdns publish [flags]                   e.g. mdns publish -type _ssh._tcp -port 22
+//     mdns query [flags] <name> [type]       e.g. mdns query myhost.local. A
+//     mdns tui [flags] [service...]          e.g. mdns tui _http._tcp _ssh._tcp
+//     mdns zone [flags] [service...]         e.g. mdns zone -o /var/lib/mdns/local.zone -interval 5m
+//     mdns decode [flags] [file]             e.g. mdns decode capture.pcap
+//
+// Results are printed as a table, or as JSON with -json.  The tui command
+// shows a live view of the services on the network.  The zone command writes
+// the records found while browsing as a DNS zone file.  The decode command
+// prints the mDNS messages of a pcap capture or a hex dump, noting the bits
+// and sections with a meaning particular to RFC 6762.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// command is a subcommand of the mdns tool.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []*command
+
+func init() {
+	// Assigned in init because the commands refer back to this list to print
+	// their usage.
+	commands = []*command{
+		{"browse", "browse [flags] <service>", "find instances of a service type", browse},
+		{"resolve", "resolve [flags] <instance>", "look up the host, port, addresses and TXT of an instance", resolve},
+		{"publish", "publish [flags]", "advertise a service until interrupted", publish},
+		{"tui", "tui [flags] [service...]", "interactively browse services, by default every type found", tui},
+		{"query", "query [flags] <name> [type]", "print the records returned for a name (type defaults to ANY)", query},
+		{"zone", "zone [flags] [service...]", "write the records of services, by default every type found, as a zone file", zone},
+		{"decode", "decode [flags] [file]", "print the mDNS messages of a pcap file or hex dump (default stdin)", decode},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "mdns %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: mdns <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-32s %s\n", c.usage, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'mdns <command> -h' for the flags of a command.\n")
+}
+
+// queryFlags are the flags shared by the commands that send queries.
+type queryFlags struct {
+	timeout   time.Duration
+	iface     string
+	unicast   bool
+	responder string
+	system    bool
+	json      bool
+}
+
+func (q *queryFlags) register(fs *flag.FlagSet, timeout time.Duration) {
+	fs.DurationVar(&q.timeout, "timeout", timeout, "how long to wait for responses")
+	fs.StringVar(&q.iface, "iface", "", "multicast interface to use")
+	fs.BoolVar(&q.unicast, "unicast", false, "ask for unicast responses")
+	fs.StringVar(&q.responder, "responder", "", "query this responder address directly instead of multicasting")
+	fs.BoolVar(&q.system, "system", false, "browse through the system's mDNSResponder (macOS)")
+	fs.BoolVar(&q.json, "json", false, "print results as JSON")
+}
+
+// options converts the flags to query options.
+func (q *queryFlags) options() ([]mdns.QueryOption, error) {
+	opts := []mdns.QueryOption{
+		mdns.WithTimeout(q.timeout),
+		mdns.WithUnicastResponse(q.unicast),
+		mdns.WithSystemResponder(q.system),
+	}
+	if q.iface != "" {
+		iface, err := net.InterfaceByName(q.iface)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, mdns.WithInterface(iface))
+	}
+	if q.responder != "" {
+		addr, err := parseResponder(q.responder)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, mdns.WithResponder(addr))
+	}
+	return opts, nil
+}
+
+// parseResponder parses an address with an optional port.
+func parseResponder(s string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(s, "5353")
+	}
+	return net.ResolveUDPAddr("udp", s)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(os.Stderr, "Usage: mdns %s\n\n%s.\n\nFlags:\n", c.usage, strings.ToUpper(c.summary[:1])+c.summary[1:])
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func browse(args []string) error {
+	fs := newFlagSet("browse")
+	var qf queryFlags
+	qf.register(fs, 3*time.Second)
+	domain := fs.String("domain", "local", "domain to browse")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := qf.options()
+	if err != nil {
+		return err
+	}
+	entries := make(chan *mdns.ServiceEntry, 16)
+	opts = append(opts, mdns.WithDomain(*domain), mdns.WithEntriesChannel(entries))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out := newEntryPrinter(os.Stdout, qf.json)
+		for e := range entries {
+			out.print(e)
+		}
+		out.flush()
+	}()
+	err = mdns.Lookup(context.Background(), fs.Arg(0), opts...)
+	close(entries)
+	<-done
+	return err
+}
+
+func resolve(args []string) error {
+	fs := newFlagSet("resolve")
+	var qf queryFlags
+	qf.register(fs, time.Second)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := qf.options()
+	if err != nil {
+		return err
+	}
+	e, err := mdns.Resolve(context.Background(), fs.Arg(0), opts...)
+	if err != nil {
+		return err
+	}
+	out := newEntryPrinter(os.Stdout, qf.json)
+	out.print(e)
+	out.flush()
+	return nil
+}
+
+func zone(args []string) error {
+	fs := newFlagSet("zone")
+	var qf queryFlags
+	qf.register(fs, 3*time.Second)
+	out := fs.String("o", "", "file to write the zone to, default is standard output")
+	interval := fs.Duration("interval", 0, "browse again and rewrite the file at this interval until interrupted (requires -o)")
+	fs.Parse(args)
+	if *interval > 0 && *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if qf.json {
+		return fmt.Errorf("-json is not supported by zone")
+	}
+	opts, err := qf.options()
+	if err != nil {
+		return err
+	}
+	cache := mdns.NewCache()
+	opts = append(opts, mdns.WithCache(cache))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	for {
+		if err := lookupAll(context.Background(), fs.Args(), opts); err != nil {
+			return err
+		}
+		if *out == "" {
+			return cache.WriteZone(os.Stdout, "")
+		}
+		if err := cache.WriteZoneFile(*out, ""); err != nil {
+			return err
+		}
+		if *interval == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(*interval):
+		case <-sig:
+			return nil
+		}
+	}
+}
+
+// lookupAll looks up the given service types, or every type found on the
+// network if there are none, at the same time, discarding the entries.
+func lookupAll(ctx context.Context, services []string, opts []mdns.QueryOption) error {
+	if len(services) == 0 {
+		var err error
+		if services, err = mdns.ServiceTypes(ctx, opts...); err != nil {
+			return err
+		}
+	}
+	errs := make(chan error, len(services))
+	for _, service := range services {
+		entries := make(chan *mdns.ServiceEntry, 16)
+		go func() {
+			for range entries {
+			}
+		}()
+		o := append(append([]mdns.QueryOption(nil), opts...), mdns.WithEntriesChannel(entries))
+		go func(service string) {
+			err := mdns.Lookup(ctx, service, o...)
+			close(entries)
+			errs <- err
+		}(service)
+	}
+	var first error
+	for range services {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = append(*l, s); return nil }
+
+func publish(args []string) error {
+	fs := newFlagSet("publish")
+	host, _ := os.Hostname()
+	name := fs.String("name", host, "instance name")
+	service := fs.String("type", "", "service type, e.g. _http._tcp (required)")
+	port := fs.Int("port", 0, "service port (required)")
+	domain := fs.String("domain", "local.", "domain to publish in")
+	hostName := fs.String("host", "", "host name, default is derived from the system host name")
+	ifaceName := fs.String("iface", "", "multicast interface to use")
+	system := fs.Bool("system", false, "publish through the system's mDNSResponder (macOS)")
+	var txt, addrs listFlag
+	fs.Var(&txt, "txt", "TXT record entry as key=value; may be repeated")
+	fs.Var(&addrs, "ip", "address to advertise for the host, default is looked up; may be repeated")
+	fs.Parse(args)
+	if *service == "" || *port == 0 || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", a)
+		}
+		ips = append(ips, ip)
+	}
+
+	zone, err := mdns.NewMDNSService(*name, *service, *domain, *hostName, *port, ips, txt)
+	if err != nil {
+		return err
+	}
+	config := &mdns.Config{Zone: zone, System: *system}
+	if *ifaceName != "" {
+		if config.Iface, err = net.InterfaceByName(*ifaceName); err != nil {
+			return err
+		}
+	}
+	server, err := mdns.NewServer(config)
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	fmt.Fprintf(os.Stderr, "Publishing %s.%s.%s on port %d; press Ctrl-C to stop.\n",
+		*name, strings.Trim(*service, "."), strings.Trim(*domain, "."), *port)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+	return nil
+}
+
+func query(args []string) error {
+	fs := newFlagSet("query")
+	var qf queryFlags
+	qf.register(fs, time.Second)
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if qf.system {
+		return fmt.Errorf("-system is not supported by query")
+	}
+
+	qtype := dns.TypeANY
+	if fs.NArg() == 2 {
+		t, ok := dns.StringToType[strings.ToUpper(fs.Arg(1))]
+		if !ok {
+			return fmt.Errorf("unknown record type %q", fs.Arg(1))
+		}
+		qtype = t
+	}
+	params := &mdns.RecordQueryParam{
+		Name:                fs.Arg(0),
+		Type:                qtype,
+		Timeout:             qf.timeout,
+		WantUnicastResponse: qf.unicast,
+	}
+	if qf.iface != "" {
+		iface, err := net.InterfaceByName(qf.iface)
+		if err != nil {
+			return err
+		}
+		params.Interface = iface
+	}
+	if qf.responder != "" {
+		addr, err := parseResponder(qf.responder)
+		if err != nil {
+			return err
+		}
+		params.Responder = addr
+	}
+	recs, err := mdns.QueryRecords(params)
+	if err != nil {
+		return err
+	}
+	printRecords(os.Stdout, recs, qf.json)
+	return nil
+}
+
+// entryPrinter prints service entries as a table or as JSON lines.
+type entryPrinter struct {
+	json bool
+	enc  *json.Encoder
+	tw   *tabwriter.Writer
+}
+
+func newEntryPrinter(w io.Writer, asJSON bool) *entryPrinter {
+	if asJSON {
+		return &entryPrinter{json: true, enc: json.NewEncoder(w)}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tHOST\tPORT\tIPV4\tIPV6\tTXT")
+	return &entryPrinter{tw: tw}
+}
+
+func (p *entryPrinter) print(e *mdns.ServiceEntry) {
+	if p.json {
+		p.enc.Encode(e)
+		return
+	}
+	ipv6 := ""
+	if e.AddrV6 != nil {
+		ipv6 = (&net.IPAddr{IP: e.AddrV6, Zone: e.Zone}).String()
+	}
+	ipv4 := ""
+	if e.AddrV4 != nil {
+		ipv4 = e.AddrV4.String()
+	}
+	fmt.Fprintf(p.tw, "%s\t%s\t%d\t%s\t%s\t%s\n", e.Name, e.Host, e.Port, ipv4, ipv6, strings.Join(e.InfoFields, " "))
+	// Flush each row so that results appear as they are discovered.
+	p.tw.Flush()
+}
+
+func (p *entryPrinter) flush() {
+	if p.tw != nil {
+		p.tw.Flush()
+	}
+}
+
+// recordJSON is the JSON form of a DNS record printed by the query command.
+type recordJSON struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+func printRecords(w io.Writer, recs []dns.RR, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, rr := range recs {
+			hdr := rr.Header()
+			enc.Encode(&recordJSON{
+				Name:  hdr.Name,
+				Type:  dns.TypeToString[hdr.Rrtype],
+				Class: dns.ClassToString[hdr.Class&^(1<<15)],
+				TTL:   hdr.Ttl,
+				Data:  strings.TrimPrefix(rr.String(), hdr.String()),
+			})
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tTTL\tDATA")
+	for _, rr := range recs {
+		hdr := rr.Header()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", hdr.Name, dns.TypeToString[hdr.Rrtype], hdr.Ttl,
+			strings.TrimPrefix(rr.String(), hdr.String()))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+	"golang.org/x/term"
+)
+
+// tuiHelp is shown at the bottom of the screen.
+const tuiHelp = "↑/↓ select  enter resolve  t TXT  c copy address  q quit"
+
+// tuiEntry is a row of the table.
+type tuiEntry struct {
+	service string
+	entry   *mdns.ServiceEntry
+	status  string // Result of the last resolve, if any
+}
+
+// address returns the entry's address as host:port, preferring IPv4.
+func (e *tuiEntry) address() string {
+	switch {
+	case e.entry.AddrV4 != nil:
+		return net.JoinHostPort(e.entry.AddrV4.String(), strconv.Itoa(e.entry.Port))
+	case e.entry.AddrV6 != nil:
+		ip := (&net.IPAddr{IP: e.entry.AddrV6, Zone: e.entry.Zone}).String()
+		return net.JoinHostPort(ip, strconv.Itoa(e.entry.Port))
+	}
+	return ""
+}
+
+// tuiEvent is something the TUI reacts to.  Exactly one field is set.
+type tuiEvent struct {
+	found    *tuiEntry      // A browse found or updated an entry
+	removed  *tuiEntry      // An entry went away
+	service  string         // A service type was discovered
+	resolved *resolveResult // A resolve completed
+	err      error          // A browse failed
+	key      string         // A key was pressed
+}
+
+// resolveResult is the outcome of resolving the instance name.
+type resolveResult struct {
+	name  string
+	entry *mdns.ServiceEntry
+	err   error
+}
+
+// tuiState is the state of the TUI, owned by its event loop.
+type tuiState struct {
+	entries  map[string]*tuiEntry // By instance name
+	services map[string]bool
+	selected string // Instance name of the selected row
+	showTXT  bool
+	message  string
+}
+
+func tui(args []string) error {
+	fs := newFlagSet("tui")
+	var qf queryFlags
+	qf.register(fs, 2*time.Second)
+	fs.Parse(args)
+	if qf.json {
+		return fmt.Errorf("-json is not supported by the TUI")
+	}
+	opts, err := qf.options()
+	if err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("standard input is not a terminal")
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldState)
+	// Switch to the alternate screen and hide the cursor while running.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan tuiEvent, 32)
+	go readKeys(os.Stdin, events)
+
+	st := &tuiState{entries: make(map[string]*tuiEntry), services: make(map[string]bool)}
+	startBrowse := func(service string) {
+		if st.services[service] {
+			return
+		}
+		st.services[service] = true
+		go browseForTUI(ctx, service, opts, events)
+	}
+	if fs.NArg() > 0 {
+		for _, service := range fs.Args() {
+			startBrowse(service)
+		}
+	} else {
+		go enumerateServices(ctx, opts, events)
+	}
+
+	for {
+		st.render(os.Stdout)
+		ev := <-events
+		switch {
+		case ev.found != nil:
+			if old, ok := st.entries[ev.found.entry.Name]; ok {
+				ev.found.status = old.status
+			}
+			st.entries[ev.found.entry.Name] = ev.found
+			if st.selected == "" {
+				st.selected = ev.found.entry.Name
+			}
+		case ev.removed != nil:
+			delete(st.entries, ev.removed.entry.Name)
+		case ev.service != "":
+			startBrowse(ev.service)
+		case ev.resolved != nil:
+			if e, ok := st.entries[ev.resolved.name]; ok {
+				if ev.resolved.err != nil {
+					e.status = "resolve failed"
+					st.message = ev.resolved.err.Error()
+				} else {
+					e.entry = ev.resolved.entry
+					e.status = "resolved"
+				}
+			}
+		case ev.err != nil:
+			st.message = ev.err.Error()
+		case ev.key != "":
+			if !st.handleKey(ev.key, opts, events) {
+				return nil
+			}
+		}
+	}
+}
+
+// handleKey acts on a key press, and returns false if the TUI should exit.
+func (st *tuiState) handleKey(key string, opts []mdns.QueryOption, events chan<- tuiEvent) bool {
+	rows := st.rows()
+	idx := -1
+	for i, e := range rows {
+		if e.entry.Name == st.selected {
+			idx = i
+		}
+	}
+	st.message = ""
+	switch key {
+	case "q", "\x03":
+		return false
+	case "up", "k":
+		if idx < 0 && len(rows) > 0 {
+			st.selected = rows[0].entry.Name
+		} else if idx > 0 {
+			st.selected = rows[idx-1].entry.Name
+		}
+	case "down", "j":
+		if idx < 0 && len(rows) > 0 {
+			st.selected = rows[0].entry.Name
+		} else if idx+1 < len(rows) {
+			st.selected = rows[idx+1].entry.Name
+		}
+	case "t":
+		st.showTXT = !st.showTXT
+	case "enter", "r":
+		if idx < 0 {
+			break
+		}
+		name := rows[idx].entry.Name
+		rows[idx].status = "resolving…"
+		go func() {
+			e, err := mdns.Resolve(context.Background(), name, opts...)
+			events <- tuiEvent{resolved: &resolveResult{name, e, err}}
+		}()
+	case "c":
+		if idx < 0 {
+			break
+		}
+		addr := rows[idx].address()
+		if addr == "" {
+			st.message = "no address to copy"
+			break
+		}
+		// OSC 52 asks the terminal to put the text on the clipboard, which
+		// also works over SSH.
+		fmt.Printf("\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(addr)))
+		st.message = "copied " + addr
+	}
+	return true
+}
+
+// rows returns the entries in display order: grouped by service type, then
+// by instance name.
+func (st *tuiState) rows() []*tuiEntry {
+	rows := make([]*tuiEntry, 0, len(st.entries))
+	for _, e := range st.entries {
+		rows = append(rows, e)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].service != rows[j].service {
+			return rows[i].service < rows[j].service
+		}
+		return rows[i].entry.Name < rows[j].entry.Name
+	})
+	return rows
+}
+
+// render redraws the whole screen.
+func (st *tuiState) render(w io.Writer) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "mdns: %d instances of %d service types\r\n", len(st.entries), len(st.services))
+
+	var selected *tuiEntry
+	service := ""
+	for _, e := range st.rows() {
+		if e.service != service {
+			service = e.service
+			fmt.Fprintf(&b, "\r\n\x1b[1m%s\x1b[0m\r\n", service)
+		}
+		mark := "  "
+		if e.entry.Name == st.selected {
+			mark = "> "
+			selected = e
+		}
+		instance := e.entry.Name
+		if i := strings.Index(instance, "."+strings.Trim(service, ".")+"."); i > 0 {
+			instance = instance[:i]
+		}
+		fmt.Fprintf(&b, "%s%-32.32s %-24.24s %-28s %s\r\n", mark, instance, e.entry.Host, e.address(), e.status)
+	}
+
+	if st.showTXT && selected != nil {
+		fmt.Fprintf(&b, "\r\n\x1b[1mTXT of %s\x1b[0m\r\n", selected.entry.Name)
+		if len(selected.entry.InfoFields) == 0 {
+			b.WriteString("  (none)\r\n")
+		}
+		for _, f := range selected.entry.InfoFields {
+			fmt.Fprintf(&b, "  %s\r\n", f)
+		}
+	}
+	if st.message != "" {
+		fmt.Fprintf(&b, "\r\n%s\r\n", st.message)
+	}
+	fmt.Fprintf(&b, "\r\n\x1b[2m%s\x1b[0m", tuiHelp)
+	io.WriteString(w, b.String())
+}
+
+// readKeys sends the keys read from r, with arrow keys and enter given names.
+func readKeys(r io.Reader, events chan<- tuiEvent) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			events <- tuiEvent{key: "q"}
+			return
+		}
+		key := string(buf[:n])
+		switch key {
+		case "\x1b[A", "\x1bOA":
+			key = "up"
+		case "\x1b[B", "\x1bOB":
+			key = "down"
+		case "\r", "\n":
+			key = "enter"
+		}
+		events <- tuiEvent{key: key}
+	}
+}
+
+// browseForTUI browses for service until ctx is done.
+func browseForTUI(ctx context.Context, service string, opts []mdns.QueryOption, events chan<- tuiEvent) {
+	b, err := mdns.NewBrowser(ctx, service, opts...)
+	if err != nil {
+		events <- tuiEvent{err: fmt.Errorf("browse %s: %v", service, err)}
+		return
+	}
+	for ev := range b.Events() {
+		e := &tuiEntry{service: service, entry: ev.Entry}
+		if ev.Type == mdns.ServiceRemoved {
+			events <- tuiEvent{removed: e}
+		} else {
+			events <- tuiEvent{found: e}
+		}
+	}
+}
+
+// enumerateServices periodically looks for the service types on the network
+// until ctx is done.
+func enumerateServices(ctx context.Context, opts []mdns.QueryOption, events chan<- tuiEvent) {
+	for {
+		types, err := mdns.ServiceTypes(ctx, opts...)
+		if err != nil {
+			events <- tuiEvent{err: fmt.Errorf("enumerate services: %v", err)}
+		}
+		for _, service := range types {
+			select {
+			case events <- tuiEvent{service: service}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-time.After(30 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/micro/mdns"
+)
+
+// config is the daemon's configuration file, for example:
+//
+//     {
+//       "interfaces": ["eth*"],
+//       "exclude_interfaces": ["docker0"],
+//       "services": [
+//         {"instance": "web", "service": "_http._tcp", "port": 80, "txt": ["path=/"]}
+//       ]
+//     }
+//
+// Interface names may be shell patterns.  When neither interface list is
+// given, services are published on all multicast interfaces.
+type config struct {
+	Interfaces        []string        `json:"interfaces"`
+	ExcludeInterfaces []string        `json:"exclude_interfaces"`
+	Services          []serviceConfig `json:"services"`
+}
+
+// serviceConfig describes one published service.  Empty fields take the
+// defaults of mdns.NewMDNSService.
+type serviceConfig struct {
+	Instance string   `json:"instance"`
+	Service  string   `json:"service"`
+	Domain   string   `json:"domain,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port"`
+	IPs      []string `json:"ips,omitempty"`
+	TXT      []string `json:"txt,omitempty"`
+}
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, pattern := range append(c.Interfaces, c.ExcludeInterfaces...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: bad interface pattern %q", path, pattern)
+		}
+	}
+	for i, s := range c.Services {
+		if s.Instance == "" || s.Service == "" || s.Port == 0 {
+			return nil, fmt.Errorf("%s: service %d needs an instance, service and port", path, i)
+		}
+		for _, ip := range s.IPs {
+			if net.ParseIP(ip) == nil {
+				return nil, fmt.Errorf("%s: service %q has invalid IP address %q", path, s.Instance, ip)
+			}
+		}
+	}
+	return &c, nil
+}
+
+// zone builds the zone that publishes the service.
+func (s *serviceConfig) zone() (*mdns.MDNSService, error) {
+	var ips []net.IP
+	for _, ip := range s.IPs {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return mdns.NewMDNSService(s.Instance, s.Service, s.Domain, s.Host, s.Port, ips, s.TXT)
+}
+
+// interfaces returns the multicast interfaces selected by the configuration,
+// or nil if every interface should be used.
+func (c *config) interfaces() ([]*net.Interface, error) {
+	if len(c.Interfaces) == 0 && len(c.ExcludeInterfaces) == 0 {
+		return nil, nil
+	}
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	return c.selectInterfaces(all)
+}
+
+// selectInterfaces returns those of all that are up, multicast capable and
+// pass the interface filters.
+func (c *config) selectInterfaces(all []net.Interface) ([]*net.Interface, error) {
+	var ifaces []*net.Interface
+	for i := range all {
+		iface := &all[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if len(c.Interfaces) > 0 && !matchAny(c.Interfaces, iface.Name) {
+			continue
+		}
+		if matchAny(c.ExcludeInterfaces, iface.Name) {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("no multicast interfaces match the configured filters")
+	}
+	return ifaces, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdnsd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"valid", `{"interfaces": ["eth*"], "exclude_interfaces": ["docker0"], "services": [{"instance": "web", "service": "_http._tcp", "port": 80, "ips": ["192.168.0.2", "fe80::1"], "txt": ["path=/"]}]}`, ""},
+		{"empty", `{}`, ""},
+		{"unknown field", `{"service": []}`, "unknown field"},
+		{"syntax", `{"services": [`, "unexpected EOF"},
+		{"bad pattern", `{"exclude_interfaces": ["eth["]}`, "bad interface pattern"},
+		{"no port", `{"services": [{"instance": "web", "service": "_http._tcp"}]}`, "needs an instance, service and port"},
+		{"no instance", `{"services": [{"service": "_http._tcp", "port": 80}]}`, "needs an instance, service and port"},
+		{"bad ip", `{"services": [{"instance": "web", "service": "_http._tcp", "port": 80, "ips": ["192.168.0"]}]}`, "invalid IP address"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, strings.Replace(c.name, " ", "_", -1)+".json")
+		if err := ioutil.WriteFile(path, []byte(c.json), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		cfg, err := loadConfig(path)
+		if c.err == "" {
+			if err != nil || cfg == nil {
+				t.Errorf("%s: err: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+		}
+	}
+
+	cfg, err := loadConfig(filepath.Join(dir, "valid.json"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := &config{
+		Interfaces:        []string{"eth*"},
+		ExcludeInterfaces: []string{"docker0"},
+		Services: []serviceConfig{
+			{Instance: "web", Service: "_http._tcp", Port: 80, IPs: []string{"192.168.0.2", "fe80::1"}, TXT: []string{"path=/"}},
+		},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	if _, err := loadConfig(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestSelectInterfaces(t *testing.T) {
+	up := net.FlagUp | net.FlagMulticast
+	all := []net.Interface{
+		{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Index: 2, Name: "eth0", Flags: up},
+		{Index: 3, Name: "eth1", Flags: up},
+		{Index: 4, Name: "eth2", Flags: net.FlagMulticast},
+		{Index: 5, Name: "docker0", Flags: up},
+		{Index: 6, Name: "wlan0", Flags: up},
+	}
+	cases := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{nil, []string{"docker*"}, []string{"eth0", "eth1", "wlan0"}},
+		{[]string{"eth*"}, nil, []string{"eth0", "eth1"}},
+		{[]string{"eth*", "docker0"}, []string{"eth1"}, []string{"eth0", "docker0"}},
+		{[]string{"lo", "eth2"}, nil, nil},
+		{[]string{"wlan0"}, []string{"wlan*"}, nil},
+	}
+	for _, c := range cases {
+		cfg := &config{Interfaces: c.include, ExcludeInterfaces: c.exclude}
+		ifaces, err := cfg.selectInterfaces(all)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%v - %v: selected %d interfaces, want an error", c.include, c.exclude, len(ifaces))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v - %v: err: %v", c.include, c.exclude, err)
+			continue
+		}
+		var names []string
+		for _, iface := range ifaces {
+			names = append(names, iface.Name)
+		}
+		if !reflect.DeepEqual(names, c.want) {
+			t.Errorf("%v - %v: got %v, want %v", c.include, c.exclude, names, c.want)
+		}
+	}
+
+	if ifaces, err := (&config{}).interfaces(); ifaces != nil || err != nil {
+		t.Errorf("unfiltered configuration selected %v: %v", ifaces, err)
+	}
+}
+
+func TestServerKey(t *testing.T) {
+	web := serviceConfig{Instance: "web", Service: "_http._tcp", Port: 80}
+	moved := web
+	moved.Port = 8080
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	eth1 := &net.Interface{Index: 3, Name: "eth1"}
+
+	if serverKey(&web, nil) != serverKey(&serviceConfig{Instance: "web", Service: "_http._tcp", Port: 80}, nil) {
+		t.Errorf("equal configurations have different keys")
+	}
+	keys := map[string]bool{}
+	for _, key := range []string{
+		serverKey(&web, nil),
+		serverKey(&moved, nil),
+		serverKey(&web, eth0),
+		serverKey(&web, eth1),
+		serverKey(&moved, eth0),
+	} {
+		if keys[key] {
+			t.Errorf("duplicate key %q", key)
+		}
+		keys[key] = true
+	}
+}
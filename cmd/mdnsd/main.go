@@ -0,0 +1,343 @@
+// Command mdnsd is a standalone mDNS responder that publishes the services
+// listed in a configuration file, for containers and appliances that have no
+// system responder.
+//
+// Usage:
+//     mdnsd -config /etc/mdnsd.json [-metrics :9353] [-admin 127.0.0.1:9354] [-avahi] [-system]
+//
+// Sending SIGHUP reloads the configuration: services that were removed or
+// changed are withdrawn with goodbye packets and new ones are announced.  On
+// SIGINT or SIGTERM every service is withdrawn before exiting.  With
+// -metrics, the server counters are served as JSON at /debug/vars.  With
+// -admin, services can also be published and withdrawn at runtime through the
+// HTTP API described in package admin; these are not saved to the
+// configuration file.  With -avahi, mdnsd takes the place of avahi-daemon on
+// the system D-Bus, so that applications using Avahi publish and browse
+// through it, as described in package avahi.  With -system, services are
+// published through the system's mDNSResponder on macOS, rather than by mdnsd
+// itself competing with it for the mDNS port.
+//
+// mdnsd supports systemd socket activation and Type=notify services, as
+// described in package systemd.  When started with the mDNS sockets, every
+// service is published on them, and the interface filters of the
+// configuration are ignored; BindToDevice= in the socket unit restricts the
+// interfaces instead.  Readiness is reported once the services have been
+// announced.
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/micro/mdns"
+	"github.com/micro/mdns/admin"
+	"github.com/micro/mdns/avahi"
+	"github.com/micro/mdns/systemd"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path of the configuration file (required)")
+	metricsAddr := flag.String("metrics", "", "address to serve metrics on, e.g. :9353")
+	adminAddr := flag.String("admin", "", "address to serve the admin API on, e.g. 127.0.0.1:9354")
+	avahiAPI := flag.Bool("avahi", false, "serve Avahi's D-Bus API on the system bus")
+	system := flag.Bool("system", false, "publish through the system's mDNSResponder (macOS)")
+	flag.Parse()
+	if *configPath == "" || flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: mdnsd -config <file> [-metrics <addr>] [-admin <addr>] [-avahi] [-system]\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("[ERR] mdnsd: %v", err)
+	}
+
+	conns, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("[ERR] mdnsd: %v", err)
+	}
+	if len(conns) > 0 && *system {
+		log.Fatalf("[ERR] mdnsd: -system cannot be used with socket activation")
+	}
+
+	d := newDaemon()
+	d.system = *system
+	if len(conns) > 0 {
+		d.set, _ = mdns.NewServiceSet()
+	}
+	if err := d.apply(cfg); err != nil {
+		log.Fatalf("[ERR] mdnsd: %v", err)
+	}
+	if len(conns) > 0 {
+		if len(cfg.Interfaces) > 0 || len(cfg.ExcludeInterfaces) > 0 {
+			log.Printf("[WARN] mdnsd: Socket activated, ignoring the interface filters")
+		}
+		shared, err := mdns.NewServer(&mdns.Config{Zone: d.set, Metrics: d.metrics, Conns: conns})
+		if err != nil {
+			log.Fatalf("[ERR] mdnsd: %v", err)
+		}
+		defer shared.Shutdown()
+		d.mu.Lock()
+		d.shared = shared
+		d.mu.Unlock()
+	}
+
+	if *metricsAddr != "" {
+		expvar.Publish("mdns", expvar.Func(func() interface{} {
+			return d.metrics.Snapshot()
+		}))
+		expvar.Publish("services", expvar.Func(func() interface{} {
+			return d.count()
+		}))
+		l, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			log.Fatalf("[ERR] mdnsd: %v", err)
+		}
+		go func() {
+			if err := http.Serve(l, nil); err != nil {
+				log.Printf("[ERR] mdnsd: Metrics server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Services published at runtime, through the admin or Avahi API, are
+	// served by one more server, unless socket activation already gave one
+	// server for every service.
+	set, server := d.set, d.shared
+	if server == nil && (*adminAddr != "" || *avahiAPI) {
+		set, _ = mdns.NewServiceSet()
+		server, err = mdns.NewServer(&mdns.Config{Zone: set, Metrics: d.metrics, System: *system})
+		if err != nil {
+			log.Fatalf("[ERR] mdnsd: %v", err)
+		}
+		defer server.Shutdown()
+	}
+
+	if *adminAddr != "" {
+		l, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			log.Fatalf("[ERR] mdnsd: %v", err)
+		}
+		go func() {
+			if err := http.Serve(l, admin.New(server, set)); err != nil {
+				log.Printf("[ERR] mdnsd: Admin server stopped: %v", err)
+			}
+		}()
+	}
+
+	if *avahiAPI {
+		a, err := avahi.New(&avahi.Config{Server: server, Services: set})
+		if err != nil {
+			log.Fatalf("[ERR] mdnsd: %v", err)
+		}
+		defer a.Close()
+	}
+
+	go func() {
+		d.waitAnnounced()
+		notify(systemd.Ready)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			log.Printf("[INFO] mdnsd: Received %v, withdrawing services", s)
+			notify(systemd.Stopping)
+			if err := d.apply(&config{}); err != nil {
+				log.Printf("[ERR] mdnsd: Failed to withdraw services: %v", err)
+			}
+			return
+		}
+		log.Printf("[INFO] mdnsd: Reloading %s", *configPath)
+		notify(systemd.Reloading)
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Printf("[ERR] mdnsd: Keeping the current configuration: %v", err)
+		} else if err := d.apply(cfg); err != nil {
+			log.Printf("[ERR] mdnsd: %v", err)
+		}
+		notify(systemd.Ready)
+	}
+}
+
+// notify reports the daemon's state to systemd, if it was started by systemd.
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		log.Printf("[ERR] mdnsd: Failed to notify systemd: %v", err)
+	}
+}
+
+// daemon runs one server for each published service and interface, or,
+// when socket activated, publishes every service from set on the single
+// server that uses the activated sockets.
+type daemon struct {
+	metrics *mdns.ServerMetrics
+	system  bool             // Publish through the system responder
+	set     *mdns.ServiceSet // Set when socket activated
+
+	mu sync.Mutex
+	// servers is keyed by the service's configuration and interface, so
+	// that a service whose configuration changes is restarted on reload.
+	servers map[string]*mdns.Server
+	// shared serves set, and services holds the services of the
+	// configuration in it, keyed like servers.  shared is nil until the
+	// initial services are in set, so that they are announced together.
+	shared   *mdns.Server
+	services map[string]*mdns.MDNSService
+}
+
+func newDaemon() *daemon {
+	return &daemon{
+		metrics:  new(mdns.ServerMetrics),
+		servers:  make(map[string]*mdns.Server),
+		services: make(map[string]*mdns.MDNSService),
+	}
+}
+
+// count returns the number of running servers, or of published services
+// when socket activated.
+func (d *daemon) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.servers) + len(d.services)
+}
+
+// waitAnnounced waits until the running servers have announced the services
+// they started with.
+func (d *daemon) waitAnnounced() {
+	d.mu.Lock()
+	var announced []<-chan struct{}
+	for _, s := range d.servers {
+		announced = append(announced, s.Announced())
+	}
+	if d.shared != nil {
+		announced = append(announced, d.shared.Announced())
+	}
+	d.mu.Unlock()
+	for _, ch := range announced {
+		<-ch
+	}
+}
+
+// apply makes the running servers match cfg.  Servers that are no longer
+// wanted are shut down first, which sends their goodbyes, and then servers
+// are started for new or changed services.  A service that fails to start is
+// logged and skipped so that the others keep running.
+func (d *daemon) apply(cfg *config) error {
+	if d.set != nil {
+		d.applyShared(cfg)
+		return nil
+	}
+
+	ifaces, err := cfg.interfaces()
+	if err != nil {
+		return err
+	}
+	if ifaces == nil {
+		// A nil interface joins the multicast group on every interface.
+		ifaces = []*net.Interface{nil}
+	}
+
+	type wanted struct {
+		svc   serviceConfig
+		iface *net.Interface
+	}
+	want := make(map[string]wanted)
+	for _, svc := range cfg.Services {
+		for _, iface := range ifaces {
+			want[serverKey(&svc, iface)] = wanted{svc, iface}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, s := range d.servers {
+		if _, ok := want[key]; !ok {
+			s.Shutdown()
+			delete(d.servers, key)
+		}
+	}
+
+	for key, w := range want {
+		if _, ok := d.servers[key]; ok {
+			continue
+		}
+		zone, err := w.svc.zone()
+		if err != nil {
+			log.Printf("[ERR] mdnsd: Service %q: %v", w.svc.Instance, err)
+			continue
+		}
+		s, err := mdns.NewServer(&mdns.Config{Zone: zone, Iface: w.iface, Metrics: d.metrics, System: d.system})
+		if err != nil {
+			log.Printf("[ERR] mdnsd: Service %q: %v", w.svc.Instance, err)
+			continue
+		}
+		d.servers[key] = s
+	}
+	log.Printf("[INFO] mdnsd: Publishing %d services on %d servers", len(cfg.Services), len(d.servers))
+	return nil
+}
+
+// applyShared makes the services in the set match cfg, when socket
+// activated.  Services that are no longer wanted are withdrawn first, and
+// then new or changed services are added and announced.
+func (d *daemon) applyShared(cfg *config) {
+	want := make(map[string]serviceConfig)
+	for _, svc := range cfg.Services {
+		want[serverKey(&svc, nil)] = svc
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, svc := range d.services {
+		if _, ok := want[key]; ok {
+			continue
+		}
+		d.set.Remove(svc.InstanceName())
+		delete(d.services, key)
+		if d.shared != nil {
+			if err := d.shared.Withdraw(svc); err != nil {
+				log.Printf("[ERR] mdnsd: Service %q: %v", svc.Instance, err)
+			}
+		}
+	}
+
+	for key, w := range want {
+		if _, ok := d.services[key]; ok {
+			continue
+		}
+		zone, err := w.zone()
+		if err != nil {
+			log.Printf("[ERR] mdnsd: Service %q: %v", w.Instance, err)
+			continue
+		}
+		if err := d.set.Add(zone); err != nil {
+			log.Printf("[ERR] mdnsd: Service %q: %v", w.Instance, err)
+			continue
+		}
+		d.services[key] = zone
+		if d.shared != nil {
+			d.shared.Announce(zone)
+		}
+	}
+	log.Printf("[INFO] mdnsd: Publishing %d services on the activated sockets", len(d.services))
+}
+
+func serverKey(svc *serviceConfig, iface *net.Interface) string {
+	b, _ := json.Marshal(svc)
+	if iface == nil {
+		return string(b)
+	}
+	return iface.Name + " " + string(b)
+}
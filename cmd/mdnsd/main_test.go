@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/micro/mdns"
+)
+
+var (
+	webConfig = serviceConfig{Instance: "web", Service: "_http._tcp", Host: "testhost.", Port: 80, IPs: []string{"192.168.0.2"}}
+	sshConfig = serviceConfig{Instance: "ssh", Service: "_ssh._tcp", Host: "testhost.", Port: 22, IPs: []string{"192.168.0.2"}}
+	badConfig = serviceConfig{Instance: "bad", Service: "_bad._tcp", Domain: "bad..domain", Port: 1}
+)
+
+func TestDaemon_Apply(t *testing.T) {
+	d := newDaemon()
+	defer d.apply(&config{})
+
+	if err := d.apply(&config{Services: []serviceConfig{webConfig, sshConfig, badConfig}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.count() != 2 {
+		t.Fatalf("%d servers running, want 2", d.count())
+	}
+	web := d.servers[serverKey(&webConfig, nil)]
+	ssh := d.servers[serverKey(&sshConfig, nil)]
+	if web == nil || ssh == nil {
+		t.Fatalf("servers not keyed by their configuration: %v", d.servers)
+	}
+
+	// An unchanged service keeps its server, a changed one is restarted.
+	moved := sshConfig
+	moved.Port = 2222
+	if err := d.apply(&config{Services: []serviceConfig{webConfig, moved}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.count() != 2 {
+		t.Fatalf("%d servers running, want 2", d.count())
+	}
+	if d.servers[serverKey(&webConfig, nil)] != web {
+		t.Errorf("unchanged service restarted")
+	}
+	if s := d.servers[serverKey(&moved, nil)]; s == nil || s == ssh {
+		t.Errorf("changed service not restarted")
+	}
+	if _, ok := d.servers[serverKey(&sshConfig, nil)]; ok {
+		t.Errorf("old configuration still served")
+	}
+
+	// As on SIGINT or SIGTERM, an empty configuration withdraws everything.
+	if err := d.apply(&config{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.count() != 0 {
+		t.Errorf("%d servers still running", d.count())
+	}
+
+	if err := d.apply(&config{Interfaces: []string{"no-such-interface"}, Services: []serviceConfig{webConfig}}); err == nil {
+		t.Errorf("configuration matching no interface applied")
+	}
+}
+
+func TestDaemon_ApplyShared(t *testing.T) {
+	d := newDaemon()
+	d.set, _ = mdns.NewServiceSet()
+
+	if err := d.apply(&config{Services: []serviceConfig{webConfig, sshConfig, badConfig}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := len(d.set.Services()); n != 2 || d.count() != 2 {
+		t.Fatalf("%d services in the set, %d published, want 2", n, d.count())
+	}
+	web := d.services[serverKey(&webConfig, nil)]
+
+	moved := sshConfig
+	moved.Port = 2222
+	if err := d.apply(&config{Services: []serviceConfig{webConfig, moved}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.services[serverKey(&webConfig, nil)] != web {
+		t.Errorf("unchanged service replaced")
+	}
+	if svc := d.set.Get(d.services[serverKey(&moved, nil)].InstanceName()); svc == nil || svc.Port != 2222 {
+		t.Errorf("changed service not replaced: %v", svc)
+	}
+
+	if err := d.apply(&config{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := len(d.set.Services()); n != 0 || d.count() != 0 {
+		t.Errorf("%d services left in the set, %d published", n, d.count())
+	}
+}
@@ -0,0 +1,289 @@
+// Package consul registers the services browsed with mDNS in a Consul agent,
+// so that devices on a LAN appear in the service catalog of a datacenter
+// alongside the services registered there.
+//
+// Each instance is registered through the agent's HTTP API as a service named
+// after its service type, "_ipp._tcp" becoming "ipp", with the ID
+// "mdns-<instance name>", the tag "mdns" and these meta keys:
+//
+//     mdns_instance  the instance name, e.g. "printer._ipp._tcp.local."
+//     mdns_host      the host name
+//     txt_<key>      the value of each key of the TXT record
+//
+// Instances are deregistered when they send goodbyes or their records
+// expire, and all of them when the bridge is closed:
+//
+//     b, err := consul.New(&consul.Config{Services: []string{"_ipp._tcp", "_hap._tcp"}})
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer b.Close()
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// DefaultAddress is the address of the local Consul agent's HTTP API.
+const DefaultAddress = "http://127.0.0.1:8500"
+
+// Tag is the tag of the services registered by a bridge.
+const Tag = "mdns"
+
+// Config is used to configure a Bridge.
+type Config struct {
+	// Services are the service types to browse and register.  Required.
+	Services []string
+
+	// Address is the URL of the Consul agent's HTTP API, default
+	// DefaultAddress.
+	Address string
+
+	// Token is the ACL token sent with each request, if any.
+	Token string
+
+	// Meta, if set, maps the TXT keys to copy into the service meta to the
+	// meta keys they are given as, instead of copying every key as
+	// txt_<key>.  Keys are compared case-insensitively.
+	Meta map[string]string
+
+	// Options are used for browsing, for example mdns.WithInterface.
+	Options []mdns.QueryOption
+
+	// Client is used to talk to the agent, default a client with a 10
+	// second timeout.
+	Client *http.Client
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Bridge browses for services and keeps them registered in a Consul agent.
+// It browses from the time it is created until Close is called.
+type Bridge struct {
+	config   *Config
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	browsers []*mdns.Browser
+
+	lock       sync.Mutex
+	registered map[string]bool // Service IDs registered in the agent
+}
+
+// Registration is a service registration, in the JSON form of the agent's
+// /v1/agent/service/register endpoint.
+type Registration struct {
+	ID      string
+	Name    string
+	Tags    []string
+	Address string
+	Port    int
+	Meta    map[string]string
+}
+
+// New creates a bridge and starts browsing.
+func New(config *Config) (*Bridge, error) {
+	if len(config.Services) == 0 {
+		return nil, fmt.Errorf("consul: no services to browse")
+	}
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		config:     config,
+		cancel:     cancel,
+		registered: make(map[string]bool),
+	}
+	for _, service := range config.Services {
+		browser, err := mdns.NewBrowser(ctx, service, config.Options...)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.browsers = append(b.browsers, browser)
+		b.wg.Add(1)
+		go b.run(browser)
+	}
+	return b, nil
+}
+
+// Close stops browsing and deregisters the services registered by the
+// bridge.
+func (b *Bridge) Close() {
+	b.cancel()
+	for _, browser := range b.browsers {
+		browser.Close()
+	}
+	b.wg.Wait()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for id := range b.registered {
+		if err := b.deregister(id); err != nil {
+			b.logf("[ERR] consul: Failed to deregister %s: %v", id, err)
+		}
+	}
+	b.registered = make(map[string]bool)
+}
+
+func (b *Bridge) logf(format string, v ...interface{}) {
+	if b.config.Logger != nil {
+		b.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// run registers and deregisters the instances a browser reports until it is
+// closed.
+func (b *Bridge) run(browser *mdns.Browser) {
+	defer b.wg.Done()
+	for ev := range browser.Events() {
+		id := serviceID(ev.Entry)
+		b.lock.Lock()
+		if ev.Type == mdns.ServiceRemoved {
+			if b.registered[id] {
+				if err := b.deregister(id); err != nil {
+					b.logf("[ERR] consul: Failed to deregister %s: %v", id, err)
+				}
+				delete(b.registered, id)
+			}
+		} else if reg := b.Registration(ev.Entry); reg != nil {
+			// Registering again replaces the service, so updates are
+			// registrations too.
+			if err := b.register(reg); err != nil {
+				b.logf("[ERR] consul: Failed to register %s: %v", id, err)
+			} else {
+				b.registered[id] = true
+			}
+		}
+		b.lock.Unlock()
+	}
+}
+
+// Registration returns the registration of an instance, or nil if it has no
+// address.
+func (b *Bridge) Registration(entry *mdns.ServiceEntry) *Registration {
+	var addr string
+	switch {
+	case entry.AddrV4 != nil:
+		addr = entry.AddrV4.String()
+	case entry.AddrV6 != nil:
+		// Consul has no notion of zones, so link-local addresses are only
+		// usable on the agent's own link.
+		addr = entry.AddrV6.String()
+	default:
+		return nil
+	}
+	service, proto := serviceType(entry.Name)
+	meta := map[string]string{
+		"mdns_instance": entry.Name,
+		"mdns_host":     entry.Host,
+	}
+	txt := entry.TXTMap()
+	if b.config.Meta == nil {
+		for key, value := range txt {
+			meta["txt_"+metaKey(key)] = value
+		}
+	} else {
+		for key, name := range b.config.Meta {
+			if value, ok := txt[strings.ToLower(key)]; ok {
+				meta[name] = value
+			}
+		}
+	}
+	tags := []string{Tag}
+	if proto != "" {
+		tags = append(tags, proto)
+	}
+	return &Registration{
+		ID:      serviceID(entry),
+		Name:    service,
+		Tags:    tags,
+		Address: addr,
+		Port:    entry.Port,
+		Meta:    meta,
+	}
+}
+
+// serviceID returns the Consul service ID of an instance.
+func serviceID(entry *mdns.ServiceEntry) string {
+	return "mdns-" + strings.TrimSuffix(entry.Name, ".")
+}
+
+// serviceType returns the Consul service name and protocol of an instance
+// name, such as "http" and "tcp" for "web._http._tcp.local.".
+func serviceType(name string) (service, proto string) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := len(labels) - 1; i > 0; i-- {
+		if l := labels[i]; l == "_tcp" || l == "_udp" {
+			return strings.TrimPrefix(labels[i-1], "_"), l[1:]
+		}
+	}
+	return name, ""
+}
+
+// metaKey turns a TXT key into a valid meta key, replacing the characters
+// that are not allowed with underscores.
+func metaKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func (b *Bridge) register(reg *Registration) error {
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return b.put("/v1/agent/service/register", bytes.NewReader(body))
+}
+
+func (b *Bridge) deregister(id string) error {
+	return b.put("/v1/agent/service/deregister/"+url.PathEscape(id), nil)
+}
+
+// put sends a PUT request to the agent.
+func (b *Bridge) put(path string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(b.config.Address, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.config.Token != "" {
+		req.Header.Set("X-Consul-Token", b.config.Token)
+	}
+	client := b.config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
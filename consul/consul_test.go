@@ -0,0 +1,118 @@
+package consul
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+)
+
+// testAgent records the services registered through the agent API.
+type testAgent struct {
+	lock     sync.Mutex
+	services map[string]*Registration
+	tokens   []string
+}
+
+func (a *testAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tokens = append(a.tokens, r.Header.Get("X-Consul-Token"))
+	switch {
+	case r.Method != http.MethodPut:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case r.URL.Path == "/v1/agent/service/register":
+		reg := new(Registration)
+		if err := json.NewDecoder(r.Body).Decode(reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.services[reg.ID] = reg
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(a.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// waitFor waits until the registered services satisfy ok.
+func (a *testAgent) waitFor(t *testing.T, ok func(map[string]*Registration) bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		a.lock.Lock()
+		done := ok(a.services)
+		a.lock.Unlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for the agent's services to change")
+}
+
+func TestBridge(t *testing.T) {
+	zone, err := mdns.NewMDNSService("printer", "_ipp._tcp", "local.", "testhost.", 631,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"rp=ipp/print", "Note=Office 2"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	agent := &testAgent{services: make(map[string]*Registration)}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+	b, err := New(&Config{Services: []string{"_ipp._tcp"}, Address: ts.URL, Token: "secret"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	const id = "mdns-printer._ipp._tcp.local"
+	agent.waitFor(t, func(s map[string]*Registration) bool { return s[id] != nil })
+	agent.lock.Lock()
+	reg := agent.services[id]
+	token := agent.tokens[0]
+	agent.lock.Unlock()
+	if reg.Name != "ipp" || reg.Address != "192.168.0.42" || reg.Port != 631 {
+		t.Errorf("bad registration: %+v", reg)
+	}
+	if len(reg.Tags) != 2 || reg.Tags[0] != Tag || reg.Tags[1] != "tcp" {
+		t.Errorf("bad tags: %v", reg.Tags)
+	}
+	if reg.Meta["txt_rp"] != "ipp/print" || reg.Meta["txt_note"] != "Office 2" || reg.Meta["mdns_host"] != "testhost." {
+		t.Errorf("bad meta: %v", reg.Meta)
+	}
+	if token != "secret" {
+		t.Errorf("bad token: %q", token)
+	}
+
+	// The goodbyes sent on shutdown deregister the service.
+	serv.Shutdown()
+	agent.waitFor(t, func(s map[string]*Registration) bool { return s[id] == nil })
+}
+
+func TestRegistration_Meta(t *testing.T) {
+	b := &Bridge{config: &Config{Meta: map[string]string{"RP": "path"}}}
+	reg := b.Registration(&mdns.ServiceEntry{
+		Name:       "printer._ipp._tcp.local.",
+		Host:       "testhost.",
+		AddrV4:     net.IPv4(192, 168, 0, 42),
+		Port:       631,
+		InfoFields: []string{"rp=ipp/print", "note=Office 2"},
+	})
+	if reg.Meta["path"] != "ipp/print" || reg.Meta["txt_note"] != "" {
+		t.Errorf("bad meta: %v", reg.Meta)
+	}
+
+	// Instances without an address are not registered.
+	if reg := b.Registration(&mdns.ServiceEntry{Name: "printer._ipp._tcp.local."}); reg != nil {
+		t.Errorf("expected no registration: %+v", reg)
+	}
+}
@@ -0,0 +1,134 @@
+// Package corednsplugin is a CoreDNS plugin that answers queries for ".local"
+// names and the link-local reverse mapping zones with multicast DNS, from the
+// records cached by earlier queries when possible, so that a CoreDNS server
+// bridges mDNS without a separate daemon.  It answers as mdns.StubResolver
+// does, and passes other queries to the next plugin.
+//
+// The plugin is compiled into CoreDNS by adding
+//
+//     mdns:github.com/micro/mdns/corednsplugin
+//
+// to its plugin.cfg, and configured in the Corefile, where every property is
+// optional:
+//
+//     local {
+//         mdns {
+//             interface eth0
+//             timeout 500ms
+//             cache_size 4096
+//         }
+//     }
+//
+// interface is the multicast interface to query, timeout is how long a query
+// waits for multicast answers, default 1s, and cache_size bounds the number
+// of records cached, default no limit.
+package corednsplugin
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// Name is the name of the plugin in the Corefile.
+const Name = "mdns"
+
+func init() {
+	plugin.Register(Name, setup)
+}
+
+// Handler is the plugin's handler.
+type Handler struct {
+	Next plugin.Handler
+
+	stub *mdns.StubResolver
+}
+
+// New returns a handler answering as a stub resolver with the given
+// configuration, whose Addr is not used, and passing other queries to next.
+func New(config *mdns.StubResolverConfig, next plugin.Handler) *Handler {
+	return &Handler{Next: next, stub: mdns.NewStubHandler(config)}
+}
+
+// Name implements plugin.Handler.
+func (h *Handler) Name() string {
+	return Name
+}
+
+// ServeDNS implements plugin.Handler.
+func (h *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	m := h.stub.Answer(r)
+	switch m.Rcode {
+	case dns.RcodeRefused, dns.RcodeNotImplemented:
+		// The query is not for the stub resolver's zones, or not one it
+		// understands.
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	case dns.RcodeServerFailure:
+		// The failure was logged by the stub resolver; CoreDNS writes the
+		// response.
+		return m.Rcode, nil
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, plugin.Error(Name, err)
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// setup parses the plugin's configuration and adds it to the server.
+func setup(c *caddy.Controller) error {
+	config, err := parse(c)
+	if err != nil {
+		return plugin.Error(Name, err)
+	}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		return New(config, next)
+	})
+	return nil
+}
+
+func parse(c *caddy.Controller) (*mdns.StubResolverConfig, error) {
+	config := new(mdns.StubResolverConfig)
+	for c.Next() {
+		if c.NextArg() {
+			return nil, c.ArgErr()
+		}
+		for c.NextBlock() {
+			property := c.Val()
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			switch property {
+			case "interface":
+				iface, err := net.InterfaceByName(args[0])
+				if err != nil {
+					return nil, c.Errf("interface %s: %v", args[0], err)
+				}
+				config.Interface = iface
+			case "timeout":
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid timeout %q", args[0])
+				}
+				config.Timeout = d
+			case "cache_size":
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, c.Errf("invalid cache_size %q", args[0])
+				}
+				config.Cache = mdns.NewCache()
+				config.Cache.MaxEntries = n
+			default:
+				return nil, c.Errf("unknown property %q", property)
+			}
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,79 @@
+package corednsplugin
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestParse(t *testing.T) {
+	c := caddy.NewTestController("dns", "mdns {\n timeout 200ms\n cache_size 100\n}")
+	config, err := parse(c)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if config.Timeout != 200*time.Millisecond || config.Cache == nil || config.Cache.MaxEntries != 100 {
+		t.Errorf("bad config: %+v", config)
+	}
+
+	for _, input := range []string{
+		"mdns extra",
+		"mdns {\n timeout never\n}",
+		"mdns {\n cache_size 1 2\n}",
+		"mdns {\n color blue\n}",
+	} {
+		if _, err := parse(caddy.NewTestController("dns", input)); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, err := mdns.NewMDNSService("hostname", "_corednstest._tcp", "local.", "corednshost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	var passed bool
+	next := plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		passed = true
+		return dns.RcodeSuccess, nil
+	})
+	h := New(&mdns.StubResolverConfig{Timeout: 200 * time.Millisecond}, next)
+
+	q := new(dns.Msg)
+	q.SetQuestion("corednshost.local.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := h.ServeDNS(context.Background(), rec, q); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if passed || rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		t.Fatalf("bad answer: %v", rec.Msg)
+	}
+	if a, ok := rec.Msg.Answer[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Errorf("bad record: %v", rec.Msg.Answer[0])
+	}
+
+	// Other names are passed to the next plugin.
+	q.SetQuestion("example.com.", dns.TypeA)
+	if _, err := h.ServeDNS(context.Background(), dnstest.NewRecorder(&test.ResponseWriter{}), q); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !passed {
+		t.Errorf("query outside .local not passed on")
+	}
+}
@@ -0,0 +1,258 @@
+// Package dashboard provides an http.Handler that serves a small web page
+// listing the mDNS services on the network and those published by the local
+// server.  The page updates live as services come and go.
+//
+// The handler can be mounted under any prefix, for example in an appliance's
+// admin UI:
+//
+//     h := dashboard.New(&dashboard.Config{Published: []*mdns.MDNSService{service}})
+//     defer h.Close()
+//     http.Handle("/mdns/", http.StripPrefix("/mdns", h))
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// enumerateInterval is how often the service types on the network are looked
+// up again when Config.Services is empty.
+const enumerateInterval = time.Minute
+
+// Config is used to configure a dashboard Handler.
+type Config struct {
+	// Services are the service types to show, such as "_http._tcp".  If
+	// empty, every service type found on the network is shown.
+	Services []string
+
+	// Published are the services advertised by the local server, which are
+	// listed with their records.
+	Published []*mdns.MDNSService
+
+	// Options are used for browsing, for example mdns.WithInterface.
+	Options []mdns.QueryOption
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Handler serves the dashboard.  It browses for services in the background
+// from the time it is created until Close is called.
+//
+// The handler serves these paths:
+//     /               the dashboard page
+//     /services.json  the current state as JSON
+//     /events         the state as a stream of Server-Sent Events, sent
+//                     whenever it changes
+type Handler struct {
+	config *Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lock     sync.Mutex
+	browsers map[string]*mdns.Browser // By service type
+	changed  chan struct{}            // Closed when the state changes
+}
+
+// New creates a dashboard handler and starts browsing.
+func New(config *Config) *Handler {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Handler{
+		config:   config,
+		ctx:      ctx,
+		cancel:   cancel,
+		browsers: make(map[string]*mdns.Browser),
+		changed:  make(chan struct{}),
+	}
+	if len(config.Services) > 0 {
+		for _, service := range config.Services {
+			h.browse(service)
+		}
+	} else {
+		h.wg.Add(1)
+		go h.enumerate()
+	}
+	return h
+}
+
+// Close stops browsing.  Open event streams are ended.
+func (h *Handler) Close() {
+	h.cancel()
+	h.wg.Wait()
+}
+
+func (h *Handler) logf(format string, v ...interface{}) {
+	if h.config.Logger != nil {
+		h.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// browse starts a browser for service, if there is not one already.
+func (h *Handler) browse(service string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.browsers[service]; ok {
+		return
+	}
+	b, err := mdns.NewBrowser(h.ctx, service, h.config.Options...)
+	if err != nil {
+		h.logf("[ERR] mdns: Failed to browse %s: %v", service, err)
+		return
+	}
+	h.browsers[service] = b
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for range b.Events() {
+			h.notify()
+		}
+	}()
+}
+
+// enumerate periodically looks for the service types on the network and
+// browses each of them.
+func (h *Handler) enumerate() {
+	defer h.wg.Done()
+	for {
+		opts := append(append([]mdns.QueryOption(nil), h.config.Options...), mdns.WithTimeout(2*time.Second))
+		types, err := mdns.ServiceTypes(h.ctx, opts...)
+		if err != nil {
+			h.logf("[ERR] mdns: Failed to enumerate service types: %v", err)
+		}
+		for _, t := range types {
+			h.browse(t)
+		}
+		select {
+		case <-time.After(enumerateInterval):
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// notify wakes up the event streams.
+func (h *Handler) notify() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// state is the JSON form of the dashboard's contents.
+type state struct {
+	Services  []serviceState   `json:"services"`
+	Published []publishedState `json:"published"`
+}
+
+type serviceState struct {
+	Type    string               `json:"type"`
+	Entries []*mdns.ServiceEntry `json:"entries"`
+}
+
+type publishedState struct {
+	Name    string   `json:"name"`
+	Records []string `json:"records"`
+}
+
+// snapshot returns the current state, and a channel that is closed when it
+// changes.
+func (h *Handler) snapshot() (*state, <-chan struct{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	st := &state{Services: []serviceState{}, Published: []publishedState{}}
+	for t, b := range h.browsers {
+		st.Services = append(st.Services, serviceState{Type: t, Entries: b.Entries()})
+	}
+	sort.Slice(st.Services, func(i, j int) bool { return st.Services[i].Type < st.Services[j].Type })
+	for _, s := range h.config.Published {
+		st.Published = append(st.Published, published(s))
+	}
+	return st, h.changed
+}
+
+// published lists the records that s answers with.
+func published(s *mdns.MDNSService) publishedState {
+	trim := func(s string) string { return strings.Trim(s, ".") }
+	domain := trim(s.Domain)
+	if domain == "" {
+		domain = "local"
+	}
+	service := fmt.Sprintf("%s.%s.", trim(s.Service), domain)
+	p := publishedState{Name: fmt.Sprintf("%s.%s", s.Instance, service), Records: []string{}}
+
+	var recs []dns.RR
+	for _, rr := range s.Records(dns.Question{Name: service, Qtype: dns.TypePTR, Qclass: dns.ClassINET}) {
+		dup := false
+		for _, r := range recs {
+			dup = dup || dns.IsDuplicate(r, rr)
+		}
+		if !dup {
+			recs = append(recs, rr)
+		}
+	}
+	for _, rr := range recs {
+		p.Records = append(p.Records, rr.String())
+	}
+	return p
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/", "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	case "/services.json":
+		st, _ := h.snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	case "/events":
+		h.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents streams the state as Server-Sent Events.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		st, changed := h.snapshot()
+		b, err := json.Marshal(st)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+)
+
+func TestHandler(t *testing.T) {
+	zone, err := mdns.NewMDNSService("hostname", "_foobar._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	h := New(&Config{Services: []string{"_foobar._tcp"}, Published: []*mdns.MDNSService{zone}})
+	defer h.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	var st state
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		resp, err := ts.Client().Get(ts.URL + "/services.json")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(st.Services) == 1 && len(st.Services[0].Entries) == 1 {
+			break
+		}
+	}
+	if len(st.Services) != 1 || len(st.Services[0].Entries) != 1 {
+		t.Fatalf("service not found: %+v", st)
+	}
+	if e := st.Services[0].Entries[0]; e.Name != "hostname._foobar._tcp.local." || e.Port != 80 {
+		t.Errorf("bad entry: %+v", e)
+	}
+
+	if len(st.Published) != 1 || st.Published[0].Name != "hostname._foobar._tcp.local." {
+		t.Fatalf("bad published services: %+v", st.Published)
+	}
+	if recs := strings.Join(st.Published[0].Records, "\n"); !strings.Contains(recs, "SRV") || !strings.Contains(recs, "192.168.0.42") {
+		t.Errorf("bad published records: %s", recs)
+	}
+}
@@ -0,0 +1,78 @@
+package dashboard
+
+// page is the dashboard's single page.  It renders the state received from
+// the events stream.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mDNS services</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+h2 { margin-top: 1.5em; font-size: 1.1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.2em 0.8em 0.2em 0; vertical-align: top; }
+th { border-bottom: 1px solid #ccc; font-weight: normal; color: #666; }
+td.txt, pre { font-family: monospace; font-size: 0.9em; }
+#status { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>mDNS services</h1>
+<div id="status">Connecting…</div>
+<div id="services"></div>
+<div id="published"></div>
+<script>
+function el(tag, text) {
+  var e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+
+function row(cells, header) {
+  var tr = el("tr");
+  cells.forEach(function(c) { tr.appendChild(el(header ? "th" : "td", c)); });
+  return tr;
+}
+
+function render(st) {
+  var services = document.getElementById("services");
+  services.textContent = "";
+  var count = 0;
+  st.services.forEach(function(s) {
+    if (s.entries.length === 0) return;
+    services.appendChild(el("h2", s.type));
+    var table = el("table");
+    table.appendChild(row(["Instance", "Host", "Port", "Addresses", "TXT"], true));
+    s.entries.forEach(function(e) {
+      var addrs = [e.ipv4, e.ipv6].filter(function(a) { return a; }).join(" ");
+      var tr = row([e.name, e.host, e.port, addrs, (e.txt_raw || []).join(" ")]);
+      tr.lastChild.className = "txt";
+      table.appendChild(tr);
+      count++;
+    });
+    services.appendChild(table);
+  });
+
+  var published = document.getElementById("published");
+  published.textContent = "";
+  if (st.published.length > 0) {
+    published.appendChild(el("h2", "Published by this server"));
+    st.published.forEach(function(p) {
+      published.appendChild(el("h3", p.name));
+      published.appendChild(el("pre", p.records.join("\n")));
+    });
+  }
+  document.getElementById("status").textContent =
+    count + " instances, updated " + new Date().toLocaleTimeString();
+}
+
+var events = new EventSource("events");
+events.onmessage = function(ev) { render(JSON.parse(ev.data)); };
+events.onerror = function() {
+  document.getElementById("status").textContent = "Disconnected, retrying…";
+};
+</script>
+</body>
+</html>
+`
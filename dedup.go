@@ -0,0 +1,92 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// dedup remembers the packets received in the last window, by a hash of
+// their contents and source, so that copies of a packet, such as a query
+// received on several joined interfaces, are only handled once.
+type dedup struct {
+	window time.Duration
+
+	lock  sync.Mutex
+	seen  map[uint64]time.Time // By hash, the time first received
+	swept time.Time            // When expired hashes were last removed
+}
+
+func newDedup(window time.Duration) *dedup {
+	return &dedup{window: window, seen: make(map[uint64]time.Time)}
+}
+
+// duplicate reports whether packet was already received from the same
+// source within the window, and remembers it if not.  Probes are never
+// duplicates: a host sends the same probe 250ms apart (RFC 6762 section
+// 8.1), and each must be seen for conflicts to be found.
+func (d *dedup) duplicate(packet []byte, from net.Addr, now time.Time) bool {
+	if isProbe(packet) {
+		return false
+	}
+	key := packetHash(packet, from)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if now.Sub(d.swept) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.swept = now
+	}
+	if t, ok := d.seen[key]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// packetHash returns the 64 bit FNV-1a hash of packet and the address it was
+// received from.  It is written out rather than using hash/fnv to keep the
+// receive path free of allocations.
+func packetHash(packet []byte, from net.Addr) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	add := func(b byte) {
+		h ^= uint64(b)
+		h *= prime64
+	}
+	for _, b := range packet {
+		add(b)
+	}
+	switch addr := from.(type) {
+	case *net.UDPAddr:
+		for _, b := range addr.IP.To16() {
+			add(b)
+		}
+		add(byte(addr.Port >> 8))
+		add(byte(addr.Port))
+	case nil:
+	default:
+		for _, b := range []byte(addr.String()) {
+			add(b)
+		}
+	}
+	return h
+}
+
+// isProbe reports whether packet is a query with records in its authority
+// section, which only probes have, going by its header alone.
+func isProbe(packet []byte) bool {
+	if len(packet) < 12 {
+		return false
+	}
+	response := packet[2]&0x80 != 0
+	nscount := int(packet[8])<<8 | int(packet[9])
+	return !response && nscount > 0
+}
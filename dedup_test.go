@@ -0,0 +1,68 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDedup(t *testing.T) {
+	d := newDedup(250 * time.Millisecond)
+	a := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 5353}
+	b := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 5353}
+	packet := []byte("query")
+	now := time.Now()
+
+	if d.duplicate(packet, a, now) {
+		t.Fatalf("first packet reported as a duplicate")
+	}
+	if !d.duplicate(packet, a, now.Add(100*time.Millisecond)) {
+		t.Errorf("copy not reported as a duplicate")
+	}
+	if d.duplicate(packet, b, now.Add(100*time.Millisecond)) {
+		t.Errorf("packet from another source reported as a duplicate")
+	}
+	if d.duplicate([]byte("other"), a, now.Add(100*time.Millisecond)) {
+		t.Errorf("another packet reported as a duplicate")
+	}
+
+	// Once the window has passed, the packet is handled again, and expired
+	// hashes are forgotten.
+	if d.duplicate(packet, a, now.Add(time.Second)) {
+		t.Errorf("repeated packet reported as a duplicate")
+	}
+	if len(d.seen) != 1 {
+		t.Errorf("expired hashes kept: %d", len(d.seen))
+	}
+}
+
+func TestDedup_Probe(t *testing.T) {
+	d := newDedup(time.Second)
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 5353}
+	svc := makeService(t)
+	probe := new(dns.Msg)
+	probe.SetQuestion(svc.instanceAddr, dns.TypeANY)
+	probe.Ns = svc.Records(dns.Question{Name: svc.instanceAddr, Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	packet, err := probe.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if d.duplicate(packet, from, now.Add(time.Duration(i)*250*time.Millisecond)) {
+			t.Fatalf("probe %d reported as a duplicate", i+1)
+		}
+	}
+
+	// The same query without the proposed records is an ordinary query.
+	probe.Ns = nil
+	if packet, err = probe.Pack(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d.duplicate(packet, from, now) || !d.duplicate(packet, from, now.Add(250*time.Millisecond)) {
+		t.Errorf("repeated query not reported as a duplicate")
+	}
+}
@@ -0,0 +1,377 @@
+package mdns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// diagWindow is the period over which the rates of a source are
+	// measured, in one second buckets.
+	diagWindow = 60
+
+	// diagMaxSources is the number of sources tracked; the one seen least
+	// recently is forgotten to make room for another.
+	diagMaxSources = 4096
+
+	// diagMaxKeys is the number of questions and records tracked for each
+	// source, to check the intervals at which they are sent.
+	diagMaxKeys = 256
+
+	// floodRate is the rate of queries, or of responses, in packets a
+	// second over diagWindow, above which a source is reported as flooding.
+	// A well-behaved host sends far less once its queries have backed off.
+	floodRate = 2
+
+	// backoffStrikes is how many repeats of a query in a row must fail to
+	// back off before the querier is reported, so that several programs
+	// on one host asking the same question are not.
+	backoffStrikes = 5
+)
+
+// Diagnostics tracks the mDNS traffic of each host on the network, to find
+// the devices behind an mDNS storm.  It measures the rate of each source's
+// queries and responses, and flags those that break the rules of RFC 6762:
+// queries repeated less than a second apart or without backing off (section
+// 5.2), records multicast again less than a second apart (section 6),
+// responses sent from a port other than 5353 (section 6), non-zero opcodes
+// and response codes (section 18), and malformed packets.
+//
+// A Diagnostics is fed by a server, with Config.Diagnostics, or by a lookup
+// or browser, with WithMessageObserver(d.Observe), and may be shared by
+// several.  Report summarizes what it has seen.
+type Diagnostics struct {
+	lock    sync.Mutex
+	sources map[string]*sourceStats // By IP address
+	since   time.Time
+	now     func() time.Time
+}
+
+// sourceStats is what a Diagnostics knows of one source.
+type sourceStats struct {
+	queries, responses, malformed uint64
+	first, last                   time.Time
+	buckets                       [diagWindow]diagBucket
+	ports                         map[int]bool // Source ports other than 5353 of responses
+
+	questions map[string]*repeatStats // By name and type
+	records   map[string]time.Time    // When each record was last sent, by cacheKey
+	issues    map[diagIssue]uint64
+}
+
+// diagBucket counts the packets of a source in one second.
+type diagBucket struct {
+	sec                int64
+	queries, responses int
+}
+
+// repeatStats tracks the repeats of a question by a source.
+type repeatStats struct {
+	last     time.Time
+	interval time.Duration // Between the last two
+	strikes  int           // Repeats in a row that did not back off
+}
+
+// diagIssue is a kind of misbehaviour.
+type diagIssue int
+
+const (
+	issueQueryFlood diagIssue = iota
+	issueResponseFlood
+	issueFastRepeat
+	issueNoBackoff
+	issueFastRecord
+	issueSourcePort
+	issueOpcode
+	issueRcode
+	issueMalformed
+)
+
+func (i diagIssue) String() string {
+	switch i {
+	case issueQueryFlood:
+		return fmt.Sprintf("sent more than %d queries a second", floodRate)
+	case issueResponseFlood:
+		return fmt.Sprintf("sent more than %d responses a second", floodRate)
+	case issueFastRepeat:
+		return "repeated a query within a second (RFC 6762 section 5.2)"
+	case issueNoBackoff:
+		return "repeated a query without doubling the interval (RFC 6762 section 5.2)"
+	case issueFastRecord:
+		return "multicast a record again within a second (RFC 6762 section 6)"
+	case issueSourcePort:
+		return "sent responses from a port other than 5353 (RFC 6762 section 6)"
+	case issueOpcode:
+		return "sent messages with a non-zero opcode (RFC 6762 section 18.3)"
+	case issueRcode:
+		return "sent messages with a non-zero response code (RFC 6762 section 18.11)"
+	case issueMalformed:
+		return "sent malformed packets"
+	}
+	return fmt.Sprintf("diagIssue(%d)", int(i))
+}
+
+// NewDiagnostics returns a Diagnostics that has seen nothing yet.
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{sources: make(map[string]*sourceStats), since: time.Now(), now: time.Now}
+}
+
+// Observe records a message received by a lookup or browser.  It has the
+// signature WithMessageObserver expects.
+func (d *Diagnostics) Observe(m *Message) {
+	if m.From == nil {
+		return
+	}
+	d.record(m.Msg, m.From, m.At)
+}
+
+// source returns the stats of the source with the given address, creating
+// them if needed.  d.lock must be held.
+func (d *Diagnostics) source(from net.Addr, now time.Time) *sourceStats {
+	ip := sourceIP(from)
+	if ip == nil {
+		return nil
+	}
+	key := ip.String()
+	st := d.sources[key]
+	if st == nil {
+		if len(d.sources) >= diagMaxSources {
+			oldest := ""
+			for k, s := range d.sources {
+				if oldest == "" || s.last.Before(d.sources[oldest].last) {
+					oldest = k
+				}
+			}
+			delete(d.sources, oldest)
+		}
+		st = &sourceStats{
+			first:     now,
+			questions: make(map[string]*repeatStats),
+			records:   make(map[string]time.Time),
+			issues:    make(map[diagIssue]uint64),
+		}
+		d.sources[key] = st
+	}
+	st.last = now
+	return st
+}
+
+// malformed records a packet from from that did not unpack.
+func (d *Diagnostics) malformed(from net.Addr, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if st := d.source(from, now); st != nil {
+		st.malformed++
+		st.issues[issueMalformed]++
+	}
+}
+
+// record records the message m, received from from at now.
+func (d *Diagnostics) record(m *dns.Msg, from net.Addr, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st := d.source(from, now)
+	if st == nil {
+		return
+	}
+	b := &st.buckets[now.Unix()%diagWindow]
+	if b.sec != now.Unix() {
+		*b = diagBucket{sec: now.Unix()}
+	}
+	if m.Opcode != dns.OpcodeQuery {
+		st.issues[issueOpcode]++
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		st.issues[issueRcode]++
+	}
+
+	if !m.Response {
+		st.queries++
+		b.queries++
+		if st.rate(now, true) > floodRate {
+			st.issues[issueQueryFlood]++
+		}
+		// A query with an authority section is a probe, which has its
+		// own schedule.
+		if len(m.Ns) == 0 {
+			for _, q := range m.Question {
+				st.question(q, now)
+			}
+		}
+		return
+	}
+
+	st.responses++
+	b.responses++
+	if st.rate(now, false) > floodRate {
+		st.issues[issueResponseFlood]++
+	}
+	if addr, ok := from.(*net.UDPAddr); ok && addr.Port != 5353 {
+		// Legacy unicast responses are sent from port 5353 too, so any
+		// other port is a mistake.
+		if st.ports == nil {
+			st.ports = make(map[int]bool)
+		}
+		st.ports[addr.Port] = true
+		st.issues[issueSourcePort]++
+	}
+	for _, rr := range m.Answer {
+		if rr.Header().Ttl == 0 {
+			// Goodbyes are sent once.
+			continue
+		}
+		key := cacheKey(rr)
+		if last, ok := st.records[key]; ok && now.Sub(last) < time.Second {
+			st.issues[issueFastRecord]++
+		}
+		if _, ok := st.records[key]; ok || len(st.records) < diagMaxKeys {
+			st.records[key] = now
+		}
+	}
+}
+
+// question records that the source asked q at now, and checks that it
+// backed off since it last did.
+func (st *sourceStats) question(q dns.Question, now time.Time) {
+	key := fmt.Sprintf("%s\x00%d", strings.ToLower(q.Name), q.Qtype)
+	r := st.questions[key]
+	if r == nil {
+		if len(st.questions) >= diagMaxKeys {
+			return
+		}
+		st.questions[key] = &repeatStats{last: now}
+		return
+	}
+	interval := now.Sub(r.last)
+	r.last = now
+	switch {
+	case interval < minQueryInterval:
+		st.issues[issueFastRepeat]++
+		r.strikes++
+	case r.interval > 0 && r.interval < maxQueryInterval && interval*10 < r.interval*19:
+		// Allow a little less than doubling, for timer slack.
+		r.strikes++
+	default:
+		r.strikes = 0
+	}
+	if r.strikes >= backoffStrikes {
+		st.issues[issueNoBackoff]++
+		r.strikes = 0
+	}
+	r.interval = interval
+}
+
+// rate returns the rate of queries, if queries is set, or of responses, in
+// packets a second over the diagWindow seconds before now.
+func (st *sourceStats) rate(now time.Time, queries bool) float64 {
+	n := 0
+	for _, b := range st.buckets {
+		if now.Unix()-b.sec >= diagWindow {
+			continue
+		}
+		if queries {
+			n += b.queries
+		} else {
+			n += b.responses
+		}
+	}
+	return float64(n) / diagWindow
+}
+
+// DiagnosticsReport summarizes the traffic seen by a Diagnostics.
+type DiagnosticsReport struct {
+	Since   time.Time      // When the Diagnostics was created
+	Sources []SourceReport // Misbehaving sources first, then the busiest
+}
+
+// SourceReport is the traffic of one source.
+type SourceReport struct {
+	Addr                    string  // IP address
+	Queries, Responses      uint64  // Packets seen
+	Malformed               uint64  // Packets that did not unpack
+	QueryRate, ResponseRate float64 // Packets a second over the last minute
+	FirstSeen, LastSeen     time.Time
+
+	// Issues describes the rules the source broke, with how many times,
+	// such as "repeated a query within a second (RFC 6762 section 5.2): 12".
+	Issues []string
+}
+
+// Report returns what the Diagnostics has seen.
+func (d *Diagnostics) Report() *DiagnosticsReport {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	r := &DiagnosticsReport{Since: d.since}
+	for addr, st := range d.sources {
+		sr := SourceReport{
+			Addr:         addr,
+			Queries:      st.queries,
+			Responses:    st.responses,
+			Malformed:    st.malformed,
+			QueryRate:    st.rate(now, true),
+			ResponseRate: st.rate(now, false),
+			FirstSeen:    st.first,
+			LastSeen:     st.last,
+		}
+		for issue := issueQueryFlood; issue <= issueMalformed; issue++ {
+			n := st.issues[issue]
+			if n == 0 {
+				continue
+			}
+			desc := issue.String()
+			if issue == issueSourcePort {
+				var ports []string
+				for port := range st.ports {
+					ports = append(ports, fmt.Sprint(port))
+				}
+				sort.Strings(ports)
+				desc = fmt.Sprintf("sent responses from port %s instead of 5353 (RFC 6762 section 6)", strings.Join(ports, ", "))
+			}
+			sr.Issues = append(sr.Issues, fmt.Sprintf("%s: %d", desc, n))
+		}
+		r.Sources = append(r.Sources, sr)
+	}
+	sort.Slice(r.Sources, func(i, j int) bool {
+		a, b := &r.Sources[i], &r.Sources[j]
+		if (len(a.Issues) > 0) != (len(b.Issues) > 0) {
+			return len(a.Issues) > 0
+		}
+		if ra, rb := a.QueryRate+a.ResponseRate, b.QueryRate+b.ResponseRate; ra != rb {
+			return ra > rb
+		}
+		return a.Addr < b.Addr
+	})
+	return r
+}
+
+// String formats the report as a table of the sources, followed by the
+// issues of each misbehaving one.
+func (r *DiagnosticsReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "mDNS traffic since %s, %d sources\n\n", r.Since.Format(time.RFC3339), len(r.Sources))
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tQUERIES\tRESPONSES\tQUERIES/S\tRESPONSES/S\tISSUES")
+	for _, s := range r.Sources {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%.2f\t%d\n", s.Addr, s.Queries, s.Responses, s.QueryRate, s.ResponseRate, len(s.Issues))
+	}
+	w.Flush()
+	for _, s := range r.Sources {
+		if len(s.Issues) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s:\n", s.Addr)
+		for _, issue := range s.Issues {
+			fmt.Fprintf(&buf, "  %s\n", issue)
+		}
+	}
+	return buf.String()
+}
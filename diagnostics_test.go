@@ -0,0 +1,76 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDiagnostics(t *testing.T) {
+	d := NewDiagnostics()
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return start.Add(20 * time.Second) }
+
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	chatty := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	polite := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 8), Port: 5353}
+
+	// One querier repeats every second, the other backs off.
+	for i := 0; i < 8; i++ {
+		d.record(q, chatty, start.Add(time.Duration(i)*time.Second))
+	}
+	d.record(q, chatty, start.Add(7500*time.Millisecond))
+	for _, at := range []time.Duration{0, 1, 3, 7, 15} {
+		d.record(q, polite, start.Add(at*time.Second))
+	}
+
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: "web._http._tcp.local.",
+	}}
+	responder := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 9), Port: 40000}
+	d.record(resp, responder, start)
+	d.record(resp, responder, start.Add(100*time.Millisecond))
+	d.malformed(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 10), Port: 5353}, start)
+
+	r := d.Report()
+	if len(r.Sources) != 4 {
+		t.Fatalf("%d sources, want 4", len(r.Sources))
+	}
+	issues := make(map[string][]string)
+	for _, s := range r.Sources {
+		issues[s.Addr] = s.Issues
+	}
+	has := func(addr, issue string) bool {
+		for _, i := range issues[addr] {
+			if strings.Contains(i, issue) {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("192.168.0.7", "without doubling") || !has("192.168.0.7", "within a second") {
+		t.Errorf("bad issues of the chatty querier: %v", issues["192.168.0.7"])
+	}
+	if len(issues["192.168.0.8"]) != 0 {
+		t.Errorf("polite querier flagged: %v", issues["192.168.0.8"])
+	}
+	if !has("192.168.0.9", "port 40000 instead of 5353") || !has("192.168.0.9", "record again within a second") {
+		t.Errorf("bad issues of the responder: %v", issues["192.168.0.9"])
+	}
+	if !has("192.168.0.10", "malformed") {
+		t.Errorf("bad issues of the malformed source: %v", issues["192.168.0.10"])
+	}
+	if last := r.Sources[len(r.Sources)-1]; last.Addr != "192.168.0.8" || last.Queries != 5 {
+		t.Errorf("well-behaved source not last: %+v", last)
+	}
+	if s := r.String(); !strings.Contains(s, "192.168.0.7") || !strings.Contains(s, "4 sources") {
+		t.Errorf("bad report:\n%s", s)
+	}
+}
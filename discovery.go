@@ -0,0 +1,363 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Protocol is a discovery protocol that a Device was found with.
+type Protocol int
+
+const (
+	// ProtocolMDNS is multicast DNS service discovery.
+	ProtocolMDNS Protocol = iota
+	// ProtocolSSDP is the Simple Service Discovery Protocol of UPnP.
+	ProtocolSSDP
+	// ProtocolWSDiscovery is WS-Discovery, used by ONVIF cameras and network
+	// printers and scanners.
+	ProtocolWSDiscovery
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolMDNS:
+		return "mdns"
+	case ProtocolSSDP:
+		return "ssdp"
+	case ProtocolWSDiscovery:
+		return "ws-discovery"
+	}
+	return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+// Device is a service found on the network by one of the discovery
+// protocols.
+type Device struct {
+	Protocol Protocol
+
+	// ID identifies the device or service within its protocol: the
+	// instance name for mDNS, such as "Living Room._hue._tcp.local.", the
+	// USN for SSDP, and the endpoint address, such as "urn:uuid:...", for
+	// WS-Discovery.
+	ID string
+
+	// Type is the service type: "_hue._tcp" for mDNS, the search or
+	// notification target for SSDP, such as
+	// "urn:schemas-upnp-org:device:MediaRenderer:1", and the space-separated
+	// types for WS-Discovery, such as "dn:NetworkVideoTransmitter".
+	Type string
+
+	// Name is the human-readable name, if the protocol gives one without
+	// further requests: the instance label for mDNS.
+	Name string
+
+	Addrs []net.IP
+	Port  int
+
+	// Location is the URL of the device's description for SSDP, and its
+	// first transport address (XAddr) for WS-Discovery.
+	Location string
+
+	// Info holds the protocol's details: the TXT record's key/value pairs
+	// for mDNS, the headers of the advertisement for SSDP, with canonical
+	// keys such as "Server", and "Types", "Scopes", "XAddrs" and
+	// "MetadataVersion" for WS-Discovery.
+	Info map[string]string
+
+	LastSeen time.Time
+
+	// Entry is the mDNS entry the device was made from, if any.
+	Entry *ServiceEntry
+
+	expires time.Time // When an SSDP or WS-Discovery device is dropped
+}
+
+// key returns the key identifying d across protocols.
+func (d *Device) key() string {
+	return fmt.Sprintf("%d\x00%s", d.Protocol, d.ID)
+}
+
+// deviceFromEntry returns the Device for an mDNS entry of the given service
+// type.
+func deviceFromEntry(e *ServiceEntry, service string) *Device {
+	d := &Device{
+		Protocol: ProtocolMDNS,
+		ID:       e.Name,
+		Type:     trimDot(service),
+		Name:     e.Name,
+		Port:     e.Port,
+		Info:     make(map[string]string),
+		LastSeen: e.LastSeen,
+		Entry:    e,
+	}
+	if i := strings.Index(e.Name, "."+d.Type+"."); i > 0 {
+		d.Name = e.Name[:i]
+	}
+	if e.AddrV4 != nil {
+		d.Addrs = append(d.Addrs, e.AddrV4)
+	}
+	if e.AddrV6 != nil {
+		d.Addrs = append(d.Addrs, e.AddrV6)
+	}
+	for _, f := range e.InfoFields {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			d.Info[k] = v
+		} else {
+			d.Info[f] = ""
+		}
+	}
+	return d
+}
+
+// DiscoveryConfig is used to configure a Discovery.
+type DiscoveryConfig struct {
+	// Services are the mDNS service types to browse, such as "_hue._tcp".
+	Services []string
+
+	// SSDP also searches for UPnP devices with SSDP.  SSDPTarget is the
+	// search target, default "ssdp:all".
+	SSDP       bool
+	SSDPTarget string
+
+	// WSDiscovery also probes for devices with WS-Discovery.
+	// WSDiscoveryTypes are the types to probe for, each a namespace and local
+	// name separated by the last colon, such as
+	// "http://www.onvif.org/ver10/network/wsdl:NetworkVideoTransmitter" for
+	// ONVIF cameras.  The default is every device.
+	WSDiscovery      bool
+	WSDiscoveryTypes []string
+
+	// Interface is the multicast interface to use, default is the system's.
+	Interface *net.Interface
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// DiscoveryEvent is a change to the set of devices seen by a Discovery.
+type DiscoveryEvent struct {
+	Type   BrowseEventType
+	Device *Device
+}
+
+// Discovery continuously discovers devices with several protocols at once,
+// such as mDNS, SSDP and WS-Discovery for smart-home gear and cameras, and reports them as Devices
+// through a single stream of events, like a Browser does for one mDNS
+// service.
+type Discovery struct {
+	config *DiscoveryConfig
+	events chan *DiscoveryEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	lock    sync.Mutex
+	devices map[string]*Device // By key
+}
+
+// NewDiscovery starts discovering the configured devices.  It runs until ctx
+// is done or Close is called.
+func NewDiscovery(ctx context.Context, config *DiscoveryConfig) (*Discovery, error) {
+	if len(config.Services) == 0 && !config.SSDP && !config.WSDiscovery {
+		return nil, fmt.Errorf("mdns: nothing to discover")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	d := &Discovery{
+		config:  config,
+		events:  make(chan *DiscoveryEvent, 16),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		devices: make(map[string]*Device),
+	}
+
+	opts := []QueryOption{WithLogger(config.Logger)}
+	if config.Interface != nil {
+		opts = append(opts, WithInterface(config.Interface))
+	}
+	for _, service := range config.Services {
+		b, err := NewBrowser(ctx, service, opts...)
+		if err != nil {
+			cancel()
+			d.wg.Wait()
+			return nil, err
+		}
+		d.wg.Add(1)
+		go d.browse(ctx, b, service)
+	}
+	if config.SSDP {
+		s, err := newSSDPSearch(config.Interface, config.Logger)
+		if err != nil {
+			cancel()
+			d.wg.Wait()
+			return nil, err
+		}
+		d.wg.Add(1)
+		go d.ssdp(ctx, s)
+	}
+	if config.WSDiscovery {
+		if _, err := wsdProbe(config.WSDiscoveryTypes); err != nil {
+			cancel()
+			d.wg.Wait()
+			return nil, err
+		}
+		s, err := newWSDSearch(config.Interface, config.Logger)
+		if err != nil {
+			cancel()
+			d.wg.Wait()
+			return nil, err
+		}
+		d.wg.Add(1)
+		go d.wsDiscovery(ctx, s)
+	}
+	go func() {
+		d.wg.Wait()
+		close(d.events)
+		close(d.done)
+	}()
+	return d, nil
+}
+
+// Events returns the channel that changes are sent to.  It must be read
+// continuously, or discovery stalls.  The channel is closed when discovery
+// stops.
+func (d *Discovery) Events() <-chan *DiscoveryEvent {
+	return d.events
+}
+
+// Devices returns the devices currently known, sorted by protocol and ID.
+func (d *Discovery) Devices() []*Device {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	devices := make([]*Device, 0, len(d.devices))
+	for _, dev := range d.devices {
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Protocol != devices[j].Protocol {
+			return devices[i].Protocol < devices[j].Protocol
+		}
+		return devices[i].ID < devices[j].ID
+	})
+	return devices
+}
+
+// Close stops discovery and waits for it to finish.
+func (d *Discovery) Close() {
+	d.cancel()
+	<-d.done
+}
+
+// report records a change and sends its event, and returns false if ctx is
+// done.
+func (d *Discovery) report(ctx context.Context, typ BrowseEventType, dev *Device) bool {
+	d.lock.Lock()
+	if typ == ServiceRemoved {
+		delete(d.devices, dev.key())
+	} else {
+		d.devices[dev.key()] = dev
+	}
+	d.lock.Unlock()
+	select {
+	case d.events <- &DiscoveryEvent{Type: typ, Device: dev}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// browse reports the instances found by an mDNS browser.
+func (d *Discovery) browse(ctx context.Context, b *Browser, service string) {
+	defer d.wg.Done()
+	defer b.Close()
+	for ev := range b.Events() {
+		if !d.report(ctx, ev.Type, deviceFromEntry(ev.Entry, service)) {
+			return
+		}
+	}
+}
+
+// seen records that a device was heard from, in the devices known to one
+// protocol, and reports it if it is new or changed.  It returns false if ctx
+// is done.
+func (d *Discovery) seen(ctx context.Context, known map[string]*Device, dev *Device) bool {
+	old := known[dev.ID]
+	typ := ServiceAdded
+	if old != nil {
+		if old.Type == dev.Type && old.Name == dev.Name && old.Location == dev.Location &&
+			old.Port == dev.Port && ipsEqual(old.Addrs, dev.Addrs) {
+			d.lock.Lock()
+			old.LastSeen, old.expires = dev.LastSeen, dev.expires
+			d.lock.Unlock()
+			return true
+		}
+		typ = ServiceUpdated
+	}
+	known[dev.ID] = dev
+	return d.report(ctx, typ, dev)
+}
+
+// gone removes the device with the given ID, from the devices known to one
+// protocol, and reports it if it was known.  It returns false if ctx is done.
+func (d *Discovery) gone(ctx context.Context, known map[string]*Device, id string) bool {
+	dev := known[id]
+	if dev == nil {
+		return true
+	}
+	delete(known, id)
+	return d.report(ctx, ServiceRemoved, dev)
+}
+
+// expire removes the devices known to one protocol that have not been heard
+// from in time.  It returns false if ctx is done.
+func (d *Discovery) expire(ctx context.Context, known map[string]*Device, now time.Time) bool {
+	for id, dev := range known {
+		if now.After(dev.expires) && !d.gone(ctx, known, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// packet is a UDP packet and the address it came from.
+type packet struct {
+	buf  []byte
+	from *net.UDPAddr
+	at   time.Time
+}
+
+// readPackets reads packets with read into ch until read fails or done is
+// closed.
+func readPackets(read func([]byte) (int, net.Addr, error), ch chan<- *packet, done <-chan struct{}) {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := read(buf)
+		if err != nil {
+			return
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		p := &packet{buf: append([]byte(nil), buf[:n]...), from: udp, at: time.Now()}
+		select {
+		case ch <- p:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *Discovery) logf(format string, v ...interface{}) {
+	if d.config.Logger != nil {
+		d.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
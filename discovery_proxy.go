@@ -0,0 +1,262 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// proxyMaxTTL is the largest TTL given to records in a Discovery Proxy's
+// answers, as per section 5.5.1 of RFC 8766.
+const proxyMaxTTL = 10
+
+// DiscoveryProxyConfig is used to configure a DiscoveryProxy.
+type DiscoveryProxyConfig struct {
+	// Domain is the unicast DNS domain delegated to the proxy, such as
+	// "home.example.com".  Required.
+	Domain string
+
+	// Interface is the multicast interface to query, default is the system's.
+	Interface *net.Interface
+
+	// Timeout is how long the proxy collects multicast answers to a query,
+	// default 1 second.
+	Timeout time.Duration
+
+	// Cache, if set, is used to answer immediately from records already
+	// known, and stores the records found by the proxy's queries.
+	Cache *Cache
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// DiscoveryProxy answers unicast DNS queries for a delegated domain by making
+// the same query with multicast DNS on the local link, as described in RFC
+// 8766, so that clients elsewhere can discover link-local services with
+// ordinary DNS-SD.  For example, with a domain of "home.example.com", a query
+// for "_ipp._tcp.home.example.com" is answered with the instances of
+// "_ipp._tcp.local", renamed into "home.example.com".
+//
+// DiscoveryProxy is a dns.Handler, so it is served with miekg/dns:
+//
+//     proxy, err := mdns.NewDiscoveryProxy(&mdns.DiscoveryProxyConfig{Domain: "home.example.com"})
+//     ...
+//     log.Fatal(dns.ListenAndServe(":53", "udp", proxy))
+type DiscoveryProxy struct {
+	config *DiscoveryProxyConfig
+	domain string // Lower case, with a trailing dot
+}
+
+// NewDiscoveryProxy returns a proxy for the configured domain.
+func NewDiscoveryProxy(config *DiscoveryProxyConfig) (*DiscoveryProxy, error) {
+	domain := strings.ToLower(trimDot(config.Domain))
+	if domain == "" {
+		return nil, fmt.Errorf("mdns: a discovery proxy needs a domain")
+	}
+	if isLocalDomain(domain) {
+		return nil, fmt.Errorf("mdns: %s is a multicast DNS domain", domain)
+	}
+	return &DiscoveryProxy{config: config, domain: domain + "."}, nil
+}
+
+// ServeDNS answers a unicast DNS query.  Queries for names outside the
+// proxy's domain are refused.
+func (p *DiscoveryProxy) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := p.answer(r)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		p.logf("[ERR] mdns: Discovery proxy failed to answer %v: %v", w.RemoteAddr(), err)
+	}
+}
+
+// answer returns the response to a unicast DNS query.
+func (p *DiscoveryProxy) answer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = false
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		return m
+	}
+	q := r.Question[0]
+	local, ok := p.toLocal(q.Name)
+	if !ok {
+		m.SetRcode(r, dns.RcodeRefused)
+		return m
+	}
+	m.Authoritative = true
+
+	answers, extra, err := p.lookup(local, q.Qtype)
+	if err != nil {
+		p.logf("[ERR] mdns: Discovery proxy failed to query %s: %v", local, err)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		return m
+	}
+	// An empty answer is "no data" rather than a name error: another type of
+	// record may exist, or the name may appear on the link later.
+	m.Answer = p.fromLocal(answers)
+	m.Extra = p.fromLocal(extra)
+	return m
+}
+
+// lookup returns the answers for a name in the local domain, and the records
+// that belong in the additional section, using the cache if possible.
+func (p *DiscoveryProxy) lookup(name string, qtype uint16) ([]dns.RR, []dns.RR, error) {
+	cache := p.config.Cache
+	if cache != nil {
+		if answers := cache.Lookup(name, qtype); len(answers) > 0 {
+			return answers, p.cachedExtra(answers), nil
+		}
+	}
+
+	timeout := p.config.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	recs, err := QueryRecords(&RecordQueryParam{
+		Name:      name,
+		Type:      qtype,
+		Context:   ctx,
+		Interface: p.config.Interface,
+		Logger:    p.config.Logger,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var answers, extra []dns.RR
+	for _, rr := range recs {
+		if cache != nil {
+			cache.Add(rr)
+		}
+		hdr := rr.Header()
+		if strings.EqualFold(hdr.Name, name) && (qtype == dns.TypeANY || hdr.Rrtype == qtype) {
+			answers = append(answers, rr)
+		} else {
+			extra = append(extra, rr)
+		}
+	}
+	return answers, extra, nil
+}
+
+// cachedExtra returns the cached records that a responder would add to the
+// given answers: the SRV and TXT records of the instances a PTR record points
+// to, and the addresses of the hosts an SRV record points to.
+func (p *DiscoveryProxy) cachedExtra(answers []dns.RR) []dns.RR {
+	cache := p.config.Cache
+	var extra, srvs []dns.RR
+	for _, rr := range answers {
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			recs := cache.Lookup(rr.Ptr, dns.TypeSRV)
+			srvs = append(srvs, recs...)
+			extra = append(extra, recs...)
+			extra = append(extra, cache.Lookup(rr.Ptr, dns.TypeTXT)...)
+		case *dns.SRV:
+			srvs = append(srvs, rr)
+		}
+	}
+	for _, rr := range srvs {
+		target := rr.(*dns.SRV).Target
+		extra = append(extra, cache.Lookup(target, dns.TypeA)...)
+		extra = append(extra, cache.Lookup(target, dns.TypeAAAA)...)
+	}
+	return extra
+}
+
+// toLocal maps a name in the proxy's domain to the same name in "local.".
+func (p *DiscoveryProxy) toLocal(name string) (string, bool) {
+	name = dns.Fqdn(name)
+	lower := strings.ToLower(name)
+	if lower == p.domain {
+		return "local.", true
+	}
+	if !strings.HasSuffix(lower, "."+p.domain) {
+		return "", false
+	}
+	return name[:len(name)-len(p.domain)] + "local.", true
+}
+
+// fromLocalName maps a name in "local." to the same name in the proxy's
+// domain.
+func (p *DiscoveryProxy) fromLocalName(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if lower == "local." {
+		return p.domain, true
+	}
+	if !strings.HasSuffix(lower, ".local.") {
+		return "", false
+	}
+	return name[:len(name)-len("local.")] + p.domain, true
+}
+
+// fromLocal returns copies of the records renamed into the proxy's domain,
+// with the TTL capped.  Records that cannot be renamed are dropped.
+func (p *DiscoveryProxy) fromLocal(recs []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range recs {
+		rr, ok := p.rename(rr)
+		if !ok {
+			continue
+		}
+		if hdr := rr.Header(); hdr.Ttl > proxyMaxTTL {
+			hdr.Ttl = proxyMaxTTL
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// rename returns a copy of a record renamed into the proxy's domain, with the
+// cache-flush bit cleared.  It returns false for records whose names are not
+// in "local.", such as reverse mappings, and for NSEC records.
+func (p *DiscoveryProxy) rename(rr dns.RR) (dns.RR, bool) {
+	if rr.Header().Rrtype == dns.TypeNSEC {
+		return nil, false
+	}
+	name, ok := p.fromLocalName(rr.Header().Name)
+	if !ok {
+		return nil, false
+	}
+	rr = dns.Copy(rr)
+	hdr := rr.Header()
+	hdr.Name = name
+	hdr.Class &^= cacheFlushBit
+	switch rr := rr.(type) {
+	case *dns.PTR:
+		if n, ok := p.fromLocalName(rr.Ptr); ok {
+			rr.Ptr = n
+		}
+	case *dns.SRV:
+		if n, ok := p.fromLocalName(rr.Target); ok {
+			rr.Target = n
+		}
+	case *dns.CNAME:
+		if n, ok := p.fromLocalName(rr.Target); ok {
+			rr.Target = n
+		}
+	}
+	return rr, true
+}
+
+func (p *DiscoveryProxy) logf(format string, v ...interface{}) {
+	if p.config.Logger != nil {
+		p.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
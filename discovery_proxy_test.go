@@ -0,0 +1,128 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDiscoveryProxy_Names(t *testing.T) {
+	if _, err := NewDiscoveryProxy(&DiscoveryProxyConfig{}); err == nil {
+		t.Errorf("expected error without a domain")
+	}
+	if _, err := NewDiscoveryProxy(&DiscoveryProxyConfig{Domain: "office.local"}); err == nil {
+		t.Errorf("expected error for a multicast domain")
+	}
+	p, err := NewDiscoveryProxy(&DiscoveryProxyConfig{Domain: "Home.Example.com."})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, local string
+		ok          bool
+	}{
+		{"_ipp._tcp.home.example.com.", "_ipp._tcp.local.", true},
+		{"My\\ Printer._ipp._tcp.HOME.example.com", "My\\ Printer._ipp._tcp.local.", true},
+		{"home.example.com.", "local.", true},
+		{"_ipp._tcp.example.com.", "", false},
+		{"xhome.example.com.", "", false},
+	} {
+		local, ok := p.toLocal(test.name)
+		if local != test.local || ok != test.ok {
+			t.Errorf("toLocal(%q) = %q, %v, want %q, %v", test.name, local, ok, test.local, test.ok)
+		}
+	}
+
+	srv := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "x._ipp._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+		Target: "printer.local.",
+	}
+	ptr := &dns.PTR{
+		Hdr: dns.RR_Header{Name: "42.0.168.192.in-addr.arpa.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: "printer.local.",
+	}
+	out := p.fromLocal([]dns.RR{srv, ptr})
+	if len(out) != 1 {
+		t.Fatalf("bad: %v", out)
+	}
+	got := out[0].(*dns.SRV)
+	if got.Hdr.Name != "x._ipp._tcp.home.example.com." || got.Target != "printer.home.example.com." {
+		t.Errorf("not renamed: %v", got)
+	}
+	if got.Hdr.Class != dns.ClassINET || got.Hdr.Ttl != proxyMaxTTL {
+		t.Errorf("bad header: %v", got)
+	}
+	if srv.Hdr.Name != "x._ipp._tcp.local." {
+		t.Errorf("original record changed")
+	}
+}
+
+func TestDiscoveryProxy_Query(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_proxytest._tcp", "local.", "proxyhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	cache := NewCache()
+	p, err := NewDiscoveryProxy(&DiscoveryProxyConfig{
+		Domain:  "home.example.com",
+		Timeout: 200 * time.Millisecond,
+		Cache:   cache,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	addr := pc.LocalAddr().String()
+	dnsServer := &dns.Server{PacketConn: pc, Handler: p}
+	go dnsServer.ActivateAndServe()
+	defer dnsServer.Shutdown()
+
+	c := &dns.Client{Timeout: 2 * time.Second}
+	exchange := func(name string, qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		resp, _, err := c.Exchange(q, addr)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+
+	resp := exchange("_proxytest._tcp.home.example.com.", dns.TypePTR)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.PTR).Ptr != "hostname._proxytest._tcp.home.example.com." {
+		t.Fatalf("bad answer: %v", resp)
+	}
+	var srv *dns.SRV
+	for _, rr := range resp.Extra {
+		if rr, ok := rr.(*dns.SRV); ok {
+			srv = rr
+		}
+	}
+	if srv == nil || srv.Target != "proxyhost.home.example.com." || srv.Port != 80 {
+		t.Fatalf("bad additional records: %v", resp.Extra)
+	}
+
+	// The address was cached by the first query.
+	resp = exchange("proxyhost.home.example.com.", dns.TypeA)
+	if len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Fatalf("bad answer: %v", resp)
+	}
+
+	resp = exchange("_proxytest._tcp.example.com.", dns.TypePTR)
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("query outside the domain not refused: %v", resp)
+	}
+}
@@ -0,0 +1,286 @@
+// Package dnssd is an API in the style of Apple's dns_sd.h, with
+// Register, Browse and Resolve operations that report through callbacks,
+// flags and error codes, built on this library.  It eases porting discovery
+// code from C and Objective-C, which is usually structured around that API:
+//
+//     ref, err := dnssd.Browse(0, 0, "_http._tcp", "", func(flags dnssd.Flags, ifIndex int, err error, name, regtype, domain string) {
+//         if err != nil {
+//             log.Printf("browse failed: %v", err)
+//             return
+//         }
+//         if flags&dnssd.FlagsAdd != 0 {
+//             log.Printf("found %s", name)
+//         } else {
+//             log.Printf("lost %s", name)
+//         }
+//     })
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer ref.Deallocate()
+//
+// As with dns_sd.h, an operation runs until its Ref is deallocated, and its
+// callbacks are called one at a time.  Unlike it, no run loop or file
+// descriptor needs servicing: callbacks are called from a goroutine of the
+// operation's own.
+package dnssd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// Flags are the flags passed to and reported by operations.
+type Flags uint32
+
+// Flags, with the values of dns_sd.h.
+const (
+	// FlagsMoreComing is set on a callback when more are about to follow,
+	// so that a user interface may wait before updating.
+	FlagsMoreComing Flags = 0x1
+
+	// FlagsAdd is set on a callback for a service that was found or
+	// registered, and cleared for one that was removed.
+	FlagsAdd Flags = 0x2
+
+	// FlagsNoAutoRename is accepted by Register for compatibility.  Services
+	// are never renamed on conflicts.
+	FlagsNoAutoRename Flags = 0x8
+)
+
+// ErrorCode is an error code of dns_sd.h.
+type ErrorCode int32
+
+// Error codes, with the values of dns_sd.h.
+const (
+	ErrUnknown           ErrorCode = -65537
+	ErrNoSuchName        ErrorCode = -65538
+	ErrBadParam          ErrorCode = -65540
+	ErrBadInterfaceIndex ErrorCode = -65552
+	ErrServiceNotRunning ErrorCode = -65563
+)
+
+// Error is an error returned or reported by an operation.
+type Error struct {
+	Code ErrorCode
+	Err  error // The underlying error, if any
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("dnssd: error %d: %v", e.Code, e.Err)
+	}
+	return fmt.Sprintf("dnssd: error %d", e.Code)
+}
+
+func newError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+// Ref is a running operation.
+type Ref struct {
+	cancel context.CancelFunc
+	once   sync.Once
+	stop   func() // Called once by Deallocate, if set
+	done   chan struct{}
+}
+
+func newRef() (*Ref, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Ref{cancel: cancel, done: make(chan struct{})}, ctx
+}
+
+// Deallocate stops the operation, withdrawing the service of a Register.  No
+// callback is called once it returns.
+func (r *Ref) Deallocate() {
+	r.once.Do(func() {
+		r.cancel()
+		<-r.done
+		if r.stop != nil {
+			r.stop()
+		}
+	})
+}
+
+// iface returns the interface with the given index, or nil for 0, which
+// stands for all interfaces.
+func iface(ifIndex int) (*net.Interface, error) {
+	if ifIndex == 0 {
+		return nil, nil
+	}
+	ifi, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return nil, newError(ErrBadInterfaceIndex, err)
+	}
+	return ifi, nil
+}
+
+// queryOptions returns the options of a query on the interface with the given
+// index in domain.
+func queryOptions(ifIndex int, domain string) ([]mdns.QueryOption, error) {
+	ifi, err := iface(ifIndex)
+	if err != nil {
+		return nil, err
+	}
+	opts := []mdns.QueryOption{mdns.WithDomain(domainName(domain))}
+	if ifi != nil {
+		opts = append(opts, mdns.WithInterface(ifi))
+	}
+	return opts, nil
+}
+
+// domainName returns domain without its trailing dot, "local" by default.
+func domainName(domain string) string {
+	if domain = strings.Trim(domain, "."); domain == "" {
+		return "local"
+	}
+	return domain
+}
+
+// RegisterReply is called when a service is registered, or fails to be.
+type RegisterReply func(flags Flags, err error, name, regtype, domain string)
+
+// Register advertises an instance of regtype, such as "_http._tcp", named
+// name, by default after the host, on port.  host defaults to this host, and
+// ifIndex to all interfaces.  regtype may list subtypes after the type, as
+// in "_http._tcp,_printer".  callback, which may be nil, is called once the
+// service has been announced.
+func Register(flags Flags, ifIndex int, name, regtype, domain, host string, port int, txt []string, callback RegisterReply) (*Ref, error) {
+	ifi, err := iface(ifIndex)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(regtype, ",")
+	if name == "" {
+		if name, err = hostLabel(); err != nil {
+			return nil, newError(ErrUnknown, err)
+		}
+	}
+	if host != "" {
+		host = dns.Fqdn(host)
+	}
+	svc, err := mdns.NewMDNSService(name, parts[0], domainName(domain)+".", host, port, nil, txt)
+	if err != nil {
+		return nil, newError(ErrBadParam, err)
+	}
+	svc.Subtypes = parts[1:]
+	server, err := mdns.NewServer(&mdns.Config{Zone: svc, Iface: ifi})
+	if err != nil {
+		return nil, newError(ErrServiceNotRunning, err)
+	}
+
+	ref, ctx := newRef()
+	ref.stop = func() { server.Shutdown() }
+	go func() {
+		defer close(ref.done)
+		select {
+		case <-server.Announced():
+			if callback != nil {
+				callback(FlagsAdd, nil, name, parts[0], domainName(domain)+".")
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ref, nil
+}
+
+// hostLabel returns the first label of this host's name, the default
+// instance name.
+func hostLabel() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(host, ".", 2)[0], nil
+}
+
+// BrowseReply is called when an instance is found, with FlagsAdd, or lost,
+// without it, or when browsing fails.
+type BrowseReply func(flags Flags, ifIndex int, err error, name, regtype, domain string)
+
+// Browse looks for instances of regtype in domain, "local" by default, on
+// the interface with index ifIndex, or all interfaces if 0.
+func Browse(flags Flags, ifIndex int, regtype, domain string, callback BrowseReply) (*Ref, error) {
+	opts, err := queryOptions(ifIndex, domain)
+	if err != nil {
+		return nil, err
+	}
+	ref, ctx := newRef()
+	browser, err := mdns.NewBrowser(ctx, browseName(regtype), opts...)
+	if err != nil {
+		ref.cancel()
+		return nil, newError(ErrServiceNotRunning, err)
+	}
+	ref.stop = browser.Close
+	go func() {
+		defer close(ref.done)
+		events := browser.Events()
+		for ev := range events {
+			if ev.Type == mdns.ServiceUpdated {
+				continue
+			}
+			var f Flags
+			if ev.Type == mdns.ServiceAdded {
+				f |= FlagsAdd
+			}
+			if len(events) > 0 {
+				f |= FlagsMoreComing
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			callback(f, ifIndex, nil, ev.Entry.Instance(), regtype, domainName(domain)+".")
+		}
+	}()
+	return ref, nil
+}
+
+// browseName returns the name browsed for regtype, with its first subtype if
+// any.
+func browseName(regtype string) string {
+	parts := strings.Split(regtype, ",")
+	if len(parts) > 1 {
+		return parts[1] + "._sub." + parts[0]
+	}
+	return parts[0]
+}
+
+// ResolveReply is called with the host, port and TXT record of an instance,
+// when it is resolved and each time they change.
+type ResolveReply func(flags Flags, ifIndex int, err error, fullName, host string, port int, txt []string)
+
+// Resolve resolves the instance name of regtype in domain, as reported by
+// Browse, and keeps reporting changes to it.
+func Resolve(flags Flags, ifIndex int, name, regtype, domain string, callback ResolveReply) (*Ref, error) {
+	if name == "" || regtype == "" {
+		return nil, newError(ErrBadParam, fmt.Errorf("missing name or type"))
+	}
+	opts, err := queryOptions(ifIndex, domain)
+	if err != nil {
+		return nil, err
+	}
+	ref, ctx := newRef()
+	browser, err := mdns.NewBrowser(ctx, strings.Split(regtype, ",")[0], opts...)
+	if err != nil {
+		ref.cancel()
+		return nil, newError(ErrServiceNotRunning, err)
+	}
+	ref.stop = browser.Close
+	go func() {
+		defer close(ref.done)
+		for ev := range browser.Events() {
+			if ev.Type == mdns.ServiceRemoved || ev.Entry.Instance() != name || ctx.Err() != nil {
+				continue
+			}
+			callback(0, ifIndex, nil, ev.Entry.Name, ev.Entry.Host, ev.Entry.Port, ev.Entry.InfoFields)
+		}
+	}()
+	return ref, nil
+}
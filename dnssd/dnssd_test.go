@@ -0,0 +1,80 @@
+package dnssd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegisterBrowseResolve(t *testing.T) {
+	registered := make(chan string, 1)
+	reg, err := Register(0, 0, "dnssd test", "_dnssdtest._tcp", "", "", 4242, []string{"v=1"},
+		func(flags Flags, err error, name, regtype, domain string) {
+			if err != nil || flags&FlagsAdd == 0 || regtype != "_dnssdtest._tcp" || domain != "local." {
+				t.Errorf("bad registration: %v %v %s %s", flags, err, regtype, domain)
+			}
+			registered <- name
+		})
+	if err != nil {
+		t.Skipf("cannot register: %v", err)
+	}
+	defer reg.Deallocate()
+
+	found := make(chan string, 4)
+	browse, err := Browse(0, 0, "_dnssdtest._tcp", "", func(flags Flags, ifIndex int, err error, name, regtype, domain string) {
+		if err == nil && flags&FlagsAdd != 0 {
+			found <- name
+		}
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer browse.Deallocate()
+
+	select {
+	case name := <-found:
+		if name != "dnssd test" {
+			t.Fatalf("found %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("instance not found")
+	}
+	select {
+	case name := <-registered:
+		if name != "dnssd test" {
+			t.Errorf("registered %q", name)
+		}
+	case <-time.After(10 * time.Second):
+		// Probing and the announcements, one and two seconds apart, take
+		// about four seconds.
+		t.Errorf("registration not reported")
+	}
+
+	resolved := make(chan int, 4)
+	resolve, err := Resolve(0, 0, "dnssd test", "_dnssdtest._tcp", "local.", func(flags Flags, ifIndex int, err error, fullName, host string, port int, txt []string) {
+		if len(txt) != 1 || txt[0] != "v=1" {
+			t.Errorf("bad TXT: %v", txt)
+		}
+		resolved <- port
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resolve.Deallocate()
+	select {
+	case port := <-resolved:
+		if port != 4242 {
+			t.Errorf("resolved port %d", port)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("instance not resolved")
+	}
+}
+
+func TestBadParams(t *testing.T) {
+	if _, err := Resolve(0, 0, "", "_http._tcp", "", nil); err == nil || err.(*Error).Code != ErrBadParam {
+		t.Errorf("got %v, want ErrBadParam", err)
+	}
+	if _, err := Browse(0, 1<<30, "_http._tcp", "", nil); err == nil || err.(*Error).Code != ErrBadInterfaceIndex {
+		t.Errorf("got %v, want ErrBadInterfaceIndex", err)
+	}
+}
@@ -0,0 +1,223 @@
+package mdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// WithDomains looks up or browses the service in each of the domains at
+// once, such as "local" and the unicast domains found with BrowseDomains,
+// instead of the single domain set with WithDomain.  A multicast query asks
+// for the service in every domain, and each domain that is not "local" is
+// also browsed with unicast DNS-SD.  The Domain of each entry is the domain
+// it was found in.  WithSystem ignores Domains and browses only Domain.
+func WithDomains(domains ...string) QueryOption {
+	return func(p *QueryParam) {
+		p.Domains = domains
+	}
+}
+
+// searchDomains returns the domains a lookup or browse with params covers,
+// without their trailing dots: Domains if set, or else Domain, which
+// defaults to "local".  Duplicates are dropped.
+func searchDomains(params *QueryParam) []string {
+	list := params.Domains
+	if len(list) == 0 {
+		list = []string{params.Domain}
+	}
+	var domains []string
+	seen := make(map[string]bool)
+	for _, domain := range list {
+		domain = trimDot(domain)
+		if domain == "" {
+			domain = "local"
+		}
+		if key := nameKey(domain); !seen[key] {
+			seen[key] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// instanceDomain returns the domain of domains whose service address, the
+// element of serviceAddrs at the same index, name is an instance of.  It
+// returns false if name is not an instance of any.
+func instanceDomain(name string, serviceAddrs, domains []string) (string, bool) {
+	for i, addr := range serviceAddrs {
+		if isInstanceOf(name, addr) {
+			return domains[i], true
+		}
+	}
+	return "", false
+}
+
+// browseQuery returns a query for the PTR records of each of serviceAddrs.
+func browseQuery(serviceAddrs []string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(serviceAddrs[0], dns.TypePTR)
+	for _, addr := range serviceAddrs[1:] {
+		m.Question = append(m.Question, dns.Question{Name: addr, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	}
+	m.RecursionDesired = false
+	return m
+}
+
+// BrowseDomains finds the domains recommended for browsing, as described in
+// section 11 of RFC 6763: those named by the "b._dns-sd._udp" and
+// "db._dns-sd._udp" PTR records of "local", asked for by multicast, and of
+// the unicast domains given with WithDomain or WithDomains and the
+// reverse-mapping domains of the host's IPv4 subnets, asked for with
+// unicast DNS.  The result starts with "local" and has no duplicates; pass
+// it to WithDomains to browse them all.
+//
+// It accepts the same options as Resolve, and lasts for the timeout, one
+// second by default.  Failing to reach the unicast DNS servers is not an
+// error, as "local" is always a browse domain.
+func BrowseDomains(ctx context.Context, opts ...QueryOption) ([]string, error) {
+	params := DefaultParams("")
+	for _, opt := range opts {
+		opt(params)
+	}
+	if params.System {
+		return nil, fmt.Errorf("mdns: BrowseDomains cannot use the system responder")
+	}
+	if params.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+
+	client, err := newClient(params.Logger, params.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface, false); err != nil {
+			return nil, err
+		}
+	}
+	client.responder = responderAddr(params.Responder)
+	client.observer = params.Observer
+	client.authKey = params.AuthKey
+	client.peers = peerAddrs(params.Peers)
+
+	found := []string{"local"}
+	seen := map[string]bool{"local": true}
+	add := func(rrs []dns.RR) {
+		for _, rr := range rrs {
+			ptr, ok := rr.(*dns.PTR)
+			if !ok || !isBrowseDomainRecord(ptr.Hdr.Name) {
+				continue
+			}
+			domain := trimDot(ptr.Ptr)
+			if key := nameKey(domain); domain != "" && !seen[key] {
+				seen[key] = true
+				found = append(found, domain)
+			}
+		}
+	}
+
+	msgCh := make(chan *received, 32)
+	go client.recv(client.ipv4UnicastConn, msgCh)
+	go client.recv(client.ipv6UnicastConn, msgCh)
+	go client.recv(client.ipv4MulticastConn, msgCh)
+	go client.recv(client.ipv6MulticastConn, msgCh)
+	if err := client.sendQuery(browseDomainsQuery("local")); err != nil {
+		return nil, err
+	}
+
+	unicast := make(chan []dns.RR, 1)
+	go func() {
+		unicast <- client.unicastBrowseDomains(ctx, params)
+	}()
+
+	for {
+		select {
+		case r := <-msgCh:
+			if r.msg.Response {
+				add(r.msg.Answer)
+				add(r.msg.Extra)
+			}
+		case rrs := <-unicast:
+			add(rrs)
+			unicast = nil
+		case <-ctx.Done():
+			return found, nil
+		}
+	}
+}
+
+// browseDomainsQuery returns a query for the browse domains of domain.
+func browseDomainsQuery(domain string) *dns.Msg {
+	return browseQuery([]string{
+		"b._dns-sd._udp." + trimDot(domain) + ".",
+		"db._dns-sd._udp." + trimDot(domain) + ".",
+	})
+}
+
+// isBrowseDomainRecord returns true if name is that of the PTR records
+// naming browse domains.
+func isBrowseDomainRecord(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "b._dns-sd._udp.") || strings.HasPrefix(name, "db._dns-sd._udp.")
+}
+
+// unicastBrowseDomains asks the unicast DNS servers for the browse domains
+// of the unicast domains of params and of the reverse-mapping domains of
+// the host's IPv4 subnets, and returns the records found.
+func (c *client) unicastBrowseDomains(ctx context.Context, params *QueryParam) []dns.RR {
+	var parents []string
+	for _, domain := range searchDomains(params) {
+		if !isLocalDomain(domain) {
+			parents = append(parents, domain)
+		}
+	}
+	ifaces, _ := readIfaces()
+	parents = append(parents, reverseSubnets(ifaces)...)
+	if len(parents) == 0 {
+		return nil
+	}
+
+	servers := params.Resolvers
+	if len(servers) == 0 {
+		var err error
+		if servers, err = systemResolvers(); err != nil {
+			return nil
+		}
+	}
+	var rrs []dns.RR
+	for _, parent := range parents {
+		for _, q := range browseDomainsQuery(parent).Question {
+			rrs = append(rrs, c.wideAreaRecords(ctx, servers, q.Name, dns.TypePTR)...)
+		}
+	}
+	return rrs
+}
+
+// reverseSubnets returns the reverse-mapping domains of the global IPv4
+// subnets of the interfaces, such as "0.1.168.192.in-addr.arpa" for
+// 192.168.1.0/24, which RFC 6763 section 11 asks for browse domains under.
+func reverseSubnets(ifaces []scopeIface) []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, iface := range ifaces {
+		for _, ipnet := range iface.nets {
+			ip := ipnet.IP.To4()
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			subnet := ip.Mask(ipnet.Mask)
+			domain := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", subnet[3], subnet[2], subnet[1], subnet[0])
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains
+}
@@ -0,0 +1,81 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchDomains(t *testing.T) {
+	cases := []struct {
+		params *QueryParam
+		want   []string
+	}{
+		{&QueryParam{}, []string{"local"}},
+		{&QueryParam{Domain: "example.com."}, []string{"example.com"}},
+		{&QueryParam{Domain: "example.com", Domains: []string{"local.", "Example.org", "LOCAL", "example.org."}}, []string{"local", "Example.org"}},
+	}
+	for _, c := range cases {
+		if got := searchDomains(c.params); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("searchDomains(%+v) = %v, want %v", c.params, got, c.want)
+		}
+	}
+}
+
+func TestInstanceDomain(t *testing.T) {
+	domains := []string{"local", "example.com"}
+	addrs := []string{"_http._tcp.local.", "_http._tcp.example.com."}
+	if d, ok := instanceDomain("web._http._tcp.example.com.", addrs, domains); !ok || d != "example.com" {
+		t.Errorf("bad domain: %q %v", d, ok)
+	}
+	if d, ok := instanceDomain("web._http._tcp.local.", addrs, domains); !ok || d != "local" {
+		t.Errorf("bad domain: %q %v", d, ok)
+	}
+	if _, ok := instanceDomain("web._http._tcp.example.org.", addrs, domains); ok {
+		t.Errorf("instance of another domain matched")
+	}
+
+	m := browseQuery(addrs)
+	if len(m.Question) != 2 || m.Question[1].Name != addrs[1] || m.RecursionDesired {
+		t.Errorf("bad query: %v", m)
+	}
+}
+
+func TestReverseSubnets(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.20/24")
+	_, ll, _ := net.ParseCIDR("169.254.3.4/16")
+	_, v6, _ := net.ParseCIDR("2001:db8::1/64")
+	ifaces := []scopeIface{{nets: []*net.IPNet{lan, ll, v6}}, {nets: []*net.IPNet{lan}}}
+	want := []string{"0.1.168.192.in-addr.arpa"}
+	if got := reverseSubnets(ifaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestServer_LookupDomains(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_domains._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	entries := make(chan *ServiceEntry, 4)
+	params := &QueryParam{
+		Service: "_domains._tcp",
+		Domains: []string{"local", "LOCAL."},
+		Timeout: 50 * time.Millisecond,
+		Entries: entries,
+	}
+	if err := Query(params); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._domains._tcp.local." || e.Domain != "local" {
+			t.Fatalf("bad: %v", e)
+		}
+	default:
+		t.Fatalf("record not found")
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux && !tinygo
+// +build linux,!tinygo
+
+package mdns
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableDropReports asks the kernel to report, with each packet received on
+// c, how many packets it has dropped because the socket's receive queue was
+// full (SO_RXQ_OVFL).
+func enableDropReports(c *net.UDPConn) bool {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RXQ_OVFL, 1)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
+
+// dropCount returns the number of packets dropped by the kernel so far, as
+// reported in the control messages of a received packet.  The kernel only
+// reports a count once there have been drops.
+func dropCount(oob []byte) (uint32, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_RXQ_OVFL && len(m.Data) >= 4 {
+			// The count is in host byte order.
+			return *(*uint32)(unsafe.Pointer(&m.Data[0])), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,35 @@
+//go:build linux && !tinygo
+// +build linux,!tinygo
+
+package mdns
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestDropReports(t *testing.T) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	if !enableDropReports(c) {
+		t.Fatalf("failed to enable SO_RXQ_OVFL")
+	}
+
+	if _, ok := dropCount(nil); ok {
+		t.Errorf("count reported without a control message")
+	}
+	oob := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.SOL_SOCKET
+	h.Type = syscall.SO_RXQ_OVFL
+	h.SetLen(syscall.CmsgLen(4))
+	*(*uint32)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = 7
+	if n, ok := dropCount(oob); !ok || n != 7 {
+		t.Errorf("got %d, %v, want 7", n, ok)
+	}
+}
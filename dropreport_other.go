@@ -0,0 +1,16 @@
+//go:build !linux || tinygo
+// +build !linux tinygo
+
+package mdns
+
+import "net"
+
+// enableDropReports reports that the kernel's receive queue drops cannot be
+// counted on this platform.
+func enableDropReports(c *net.UDPConn) bool {
+	return false
+}
+
+func dropCount(oob []byte) (uint32, bool) {
+	return 0, false
+}
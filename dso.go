@@ -0,0 +1,162 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// DNS Stateful Operations (RFC 8490) TLV types, including those of DNS Push
+// Notifications (RFC 8765).
+const (
+	dsoTypeKeepalive   = 0x0001
+	dsoTypeRetryDelay  = 0x0002
+	dsoTypeSubscribe   = 0x0040
+	dsoTypePush        = 0x0041
+	dsoTypeUnsubscribe = 0x0042
+	dsoTypeReconfirm   = 0x0043
+)
+
+// Special TTLs of records in a PUSH TLV, which remove records rather than
+// add them; see section 6.3.1 of RFC 8765.
+const (
+	pushDeleteRecord = 0xFFFFFFFF // Removes the record with the same rdata
+	pushDeleteRRSet  = 0xFFFFFFFE // Removes all records of the name and type
+)
+
+// dsoTLV is a TLV of a DSO message.
+type dsoTLV struct {
+	typ  uint16
+	data []byte
+}
+
+// dsoMsg is a DSO message.  Requests have a non-zero id and are answered by
+// a response with the same id; unidirectional messages have an id of zero.
+// The first TLV of a request or unidirectional message is its primary TLV.
+type dsoMsg struct {
+	id       uint16
+	response bool
+	rcode    int
+	tlvs     []dsoTLV
+}
+
+// isDSO returns true if packet is a DSO message.
+func isDSO(packet []byte) bool {
+	return len(packet) >= 12 && int(packet[2]>>3&0xf) == dns.OpcodeStateful
+}
+
+// pack returns the message in wire format.
+func (m *dsoMsg) pack() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, m.id)
+	flags := uint16(dns.OpcodeStateful)<<11 | uint16(m.rcode&0xf)
+	if m.response {
+		flags |= 1 << 15
+	}
+	binary.BigEndian.PutUint16(b[2:], flags)
+	for _, tlv := range m.tlvs {
+		var hdr [4]byte
+		binary.BigEndian.PutUint16(hdr[:], tlv.typ)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(tlv.data)))
+		b = append(b, hdr[:]...)
+		b = append(b, tlv.data...)
+	}
+	return b
+}
+
+// parseDSO parses a DSO message.
+func parseDSO(packet []byte) (*dsoMsg, error) {
+	if !isDSO(packet) {
+		return nil, fmt.Errorf("mdns: not a DSO message")
+	}
+	for i := 4; i < 12; i++ {
+		if packet[i] != 0 {
+			return nil, fmt.Errorf("mdns: DSO message has records")
+		}
+	}
+	flags := binary.BigEndian.Uint16(packet[2:])
+	m := &dsoMsg{
+		id:       binary.BigEndian.Uint16(packet),
+		response: flags&(1<<15) != 0,
+		rcode:    int(flags & 0xf),
+	}
+	b := packet[12:]
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("mdns: truncated DSO TLV")
+		}
+		typ, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return nil, fmt.Errorf("mdns: truncated DSO TLV")
+		}
+		m.tlvs = append(m.tlvs, dsoTLV{typ: typ, data: b[4 : 4+n]})
+		b = b[4+n:]
+	}
+	return m, nil
+}
+
+// primary returns the primary TLV of the message, if any.
+func (m *dsoMsg) primary() (dsoTLV, bool) {
+	if len(m.tlvs) == 0 {
+		return dsoTLV{}, false
+	}
+	return m.tlvs[0], true
+}
+
+// packQuestion returns the data of a SUBSCRIBE TLV: a name, type and class.
+func packQuestion(q dns.Question) ([]byte, error) {
+	b := make([]byte, 255+4)
+	off, err := dns.PackDomainName(dns.Fqdn(q.Name), b, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[off:], q.Qtype)
+	binary.BigEndian.PutUint16(b[off+2:], q.Qclass)
+	return b[:off+4], nil
+}
+
+// unpackQuestion parses the data of a SUBSCRIBE TLV.
+func unpackQuestion(b []byte) (dns.Question, error) {
+	name, off, err := dns.UnpackDomainName(b, 0)
+	if err != nil {
+		return dns.Question{}, err
+	}
+	if len(b) != off+4 {
+		return dns.Question{}, fmt.Errorf("mdns: bad SUBSCRIBE TLV")
+	}
+	return dns.Question{
+		Name:   name,
+		Qtype:  binary.BigEndian.Uint16(b[off:]),
+		Qclass: binary.BigEndian.Uint16(b[off+2:]),
+	}, nil
+}
+
+// packRecords returns the records in uncompressed wire format, as carried by
+// PUSH and RECONFIRM TLVs.
+func packRecords(recs []dns.RR) ([]byte, error) {
+	var out []byte
+	for _, rr := range recs {
+		b := make([]byte, dns.Len(rr))
+		off, err := dns.PackRR(rr, b, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b[:off]...)
+	}
+	return out, nil
+}
+
+// unpackRecords parses the records of a PUSH or RECONFIRM TLV.
+func unpackRecords(b []byte) ([]dns.RR, error) {
+	var recs []dns.RR
+	for off := 0; off < len(b); {
+		rr, next, err := dns.UnpackRR(b, off)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rr)
+		off = next
+	}
+	return recs, nil
+}
@@ -0,0 +1,63 @@
+package mdns
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDSO_PackParse(t *testing.T) {
+	q := dns.Question{Name: "_ipp._tcp.example.com.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}
+	data, err := packQuestion(q)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m := &dsoMsg{id: 7, tlvs: []dsoTLV{{typ: dsoTypeSubscribe, data: data}, {typ: 0x99}}}
+	packet := m.pack()
+	if !isDSO(packet) {
+		t.Fatalf("not recognized as DSO")
+	}
+	got, err := parseDSO(packet)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got.id != 7 || got.response || len(got.tlvs) != 2 || got.tlvs[1].typ != 0x99 {
+		t.Fatalf("bad: %+v", got)
+	}
+	tlv, _ := got.primary()
+	if tlv.typ != dsoTypeSubscribe || !bytes.Equal(tlv.data, data) {
+		t.Fatalf("bad primary TLV: %+v", tlv)
+	}
+	if gotQ, err := unpackQuestion(tlv.data); err != nil || gotQ != q {
+		t.Errorf("got %v, %v, want %v", gotQ, err, q)
+	}
+
+	if _, err := parseDSO(packet[:len(packet)-1]); err == nil {
+		t.Errorf("expected error for truncated TLV")
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	b, _ := query.Pack()
+	if isDSO(b) {
+		t.Errorf("query recognized as DSO")
+	}
+}
+
+func TestDSO_Records(t *testing.T) {
+	recs := []dns.RR{
+		&dns.PTR{Hdr: dns.RR_Header{Name: "_ipp._tcp.example.com.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120}, Ptr: "x._ipp._tcp.example.com."},
+		&dns.TXT{Hdr: dns.RR_Header{Name: "x._ipp._tcp.example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: pushDeleteRecord}, Txt: []string{"a=b"}},
+	}
+	data, err := packRecords(recs)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	got, err := unpackRecords(data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(got) != 2 || got[0].String() != recs[0].String() || got[1].String() != recs[1].String() {
+		t.Errorf("got %v, want %v", got, recs)
+	}
+}
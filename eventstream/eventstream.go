@@ -0,0 +1,197 @@
+// Package eventstream provides an http.Handler that streams the events of an
+// mDNS Browser to web clients, so that a page can show a live list of devices
+// without polling.
+//
+// Each event is sent as a JSON object such as
+//
+//     {"type": "added", "entry": {"name": "web._http._tcp.local.", ...}}
+//
+// where type is "added", "updated" or "removed" and entry is a ServiceEntry
+// in its JSON encoding.  A client that connects is first sent an "added"
+// event for every instance already known, then live events.  Clients should
+// treat events idempotently, as an instance that changes while a client
+// connects may be reported twice.
+//
+// Clients using EventSource receive the events as Server-Sent Events.
+// Requests to upgrade to WebSocket are served over WebSocket, with one text
+// message per event.
+package eventstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/websocket"
+)
+
+// clientBuffer is the number of events queued for a client.  A client that
+// falls further behind is disconnected rather than stalling the others.
+const clientBuffer = 64
+
+// event is the JSON form of a browse event.
+type event struct {
+	Type  string             `json:"type"`
+	Entry *mdns.ServiceEntry `json:"entry"`
+}
+
+// Handler streams the events of a Browser.
+type Handler struct {
+	browser *mdns.Browser
+
+	lock    sync.Mutex
+	clients map[chan *event]bool
+	done    chan struct{} // Closed when the browser stops
+}
+
+// New returns a handler that streams the events of b.  The handler takes
+// over reading b.Events, so the caller must not read them.  Open streams end
+// when the browser is closed.
+func New(b *mdns.Browser) *Handler {
+	h := &Handler{
+		browser: b,
+		clients: make(map[chan *event]bool),
+		done:    make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// run fans the browser's events out to the clients.
+func (h *Handler) run() {
+	for ev := range h.browser.Events() {
+		e := &event{Type: ev.Type.String(), Entry: ev.Entry}
+		h.lock.Lock()
+		for c := range h.clients {
+			select {
+			case c <- e:
+			default:
+				// The client is too slow; closing its channel ends its
+				// stream.
+				delete(h.clients, c)
+				close(c)
+			}
+		}
+		h.lock.Unlock()
+	}
+
+	h.lock.Lock()
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c)
+	}
+	close(h.done)
+	h.lock.Unlock()
+}
+
+// subscribe registers a new client and queues the instances already known.
+// It returns nil if the browser has stopped.
+func (h *Handler) subscribe() chan *event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	select {
+	case <-h.done:
+		return nil
+	default:
+	}
+	entries := h.browser.Entries()
+	c := make(chan *event, len(entries)+clientBuffer)
+	for _, e := range entries {
+		c <- &event{Type: mdns.ServiceAdded.String(), Entry: e}
+	}
+	h.clients[c] = true
+	return c
+}
+
+// unsubscribe removes a client whose stream has ended.
+func (h *Handler) unsubscribe(c chan *event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// The events are public discovery data, so WebSocket connections
+		// are accepted from any origin.
+		websocket.Server{Handler: h.serveWebSocket}.ServeHTTP(w, r)
+		return
+	}
+	h.serveSSE(w, r)
+}
+
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := h.subscribe()
+	if c == nil {
+		http.Error(w, "browser stopped", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *Handler) serveWebSocket(ws *websocket.Conn) {
+	c := h.subscribe()
+	if c == nil {
+		return
+	}
+	defer h.unsubscribe(c)
+
+	// Detect the client going away by reading until the connection fails;
+	// clients are not expected to send anything.
+	closed := make(chan struct{})
+	go func() {
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(closed)
+	}()
+
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(ws, e); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package eventstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+)
+
+func startTest(t *testing.T) (*httptest.Server, func()) {
+	zone, err := mdns.NewMDNSService("hostname", "_foobar._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := mdns.NewBrowser(context.Background(), "_foobar._tcp")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ts := httptest.NewServer(New(b))
+	return ts, func() {
+		ts.Close()
+		b.Close()
+		serv.Shutdown()
+	}
+}
+
+func checkAdded(t *testing.T, e *event) {
+	if e.Type != "added" || e.Entry == nil || e.Entry.Name != "hostname._foobar._tcp.local." || e.Entry.Port != 80 {
+		t.Fatalf("bad event: %+v", e)
+	}
+}
+
+func TestHandler_SSE(t *testing.T) {
+	ts, stop := startTest(t)
+	defer stop()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad content type: %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(resp.Body)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	for {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var e event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			checkAdded(t, &e)
+			return
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+}
+
+func TestHandler_WebSocket(t *testing.T) {
+	ts, stop := startTest(t)
+	defer stop()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	var e event
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkAdded(t, &e)
+}
@@ -6,6 +6,7 @@ import (
 	"os/signal"
 
 	"github.com/micro/mdns"
+	"golang.org/x/net/context"
 )
 
 func main() {
@@ -26,7 +27,7 @@ func main() {
 	}()
 
 	// Start the lookups
-	err := mdns.Lookup(serviceTag, entriesCh)
+	err := mdns.Lookup(context.Background(), serviceTag, mdns.WithEntriesChannel(entriesCh))
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -0,0 +1,90 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The seed corpora of these targets, in testdata/fuzz, hold packets in the
+// forms sent by mDNSResponder, Avahi and ESP-IDF: queries with known
+// answers, probes, responses with NSEC records, goodbyes and legacy unicast
+// queries.
+
+// fuzzSeeds adds packets built from msgs to the corpus of f.
+func fuzzSeeds(f *testing.F, msgs ...*dns.Msg) {
+	for _, m := range msgs {
+		packet, err := m.Pack()
+		if err != nil {
+			f.Fatalf("err: %v", err)
+		}
+		f.Add(packet)
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	svc, err := NewMDNSService("hostname", "_http._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42), net.ParseIP("fe80::42")}, []string{"path=/"})
+	if err != nil {
+		f.Fatalf("err: %v", err)
+	}
+	svc.Subtypes = []string{"_printer"}
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = svc.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	fuzzSeeds(f, q, resp)
+
+	// The server has no sockets, so responses are built and packed but not
+	// sent.
+	s := &Server{
+		config: &Config{
+			Zone:           svc,
+			CacheAnswers:   true,
+			DetectSpoofing: true,
+			OnEvent:        func(ServerEvent) {},
+		},
+		metrics:    new(ServerMetrics),
+		shutdownCh: make(chan struct{}),
+		answers:    newAnswerCache(),
+		spoof:      newSpoofDetector(),
+	}
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		s.parsePacket(packet, from, packetInfo{})
+	})
+}
+
+func FuzzMessageToEntries(f *testing.F) {
+	svc, err := NewMDNSService("hostname", "_http._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/"})
+	if err != nil {
+		f.Fatalf("err: %v", err)
+	}
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = svc.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	fuzzSeeds(f, resp)
+
+	from := &net.UDPAddr{IP: net.ParseIP("fe80::42"), Port: 5353, Zone: "eth0"}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packet); err != nil {
+			return
+		}
+		inprogress := make(map[string]*ServiceEntry)
+		delivered := make(deliveredEntries)
+		r := &received{msg: msg, from: from, at: time.Now()}
+		// The second pass finds the entries already in progress.
+		for i := 0; i < 2; i++ {
+			for _, e := range r.entries(inprogress) {
+				delivered.next(e)
+				e.Instance()
+				e.TXTMap()
+			}
+		}
+	})
+}
@@ -0,0 +1,155 @@
+// Package grpcresolver provides a gRPC name resolver that finds the servers
+// of a target by browsing for a DNS-SD service type with mDNS, so that
+// services on a LAN can reach each other without a registry.
+//
+// Targets name the service type to browse, and optionally the domain:
+//
+//     mdns:///_echo._tcp
+//     mdns:///_echo._tcp.example.com
+//
+// Each instance found is given to the balancer as one address, its IPv4
+// address if it has one, and the addresses are updated as instances appear,
+// change, send goodbyes or expire.  With the default pick_first balancer
+// every call goes to the first instance, so a load balancing policy is
+// usually configured as well:
+//
+//     grpcresolver.Register()
+//     conn, err := grpc.Dial("mdns:///_echo._tcp",
+//         grpc.WithTransportCredentials(insecure.NewCredentials()),
+//         grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`))
+package grpcresolver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme of the targets resolved with mDNS.
+const Scheme = "mdns"
+
+// attributeKey is the key of the instance an address was resolved from, in
+// the address's attributes.
+type attributeKey struct{}
+
+// Register registers a builder for the "mdns" scheme with gRPC, which
+// browses with the given options, for example mdns.WithInterface.  It must
+// be called at initialization time, before any connections are made.
+func Register(opts ...mdns.QueryOption) {
+	resolver.Register(NewBuilder(opts...))
+}
+
+// NewBuilder returns a builder for the "mdns" scheme, which browses with the
+// given options.  It can be given to a single connection with
+// grpc.WithResolvers instead of being registered.
+func NewBuilder(opts ...mdns.QueryOption) resolver.Builder {
+	return &builder{opts: opts}
+}
+
+// Entry returns the service instance that addr was resolved from, or nil if
+// it was not resolved by this package.  Balancers can use it to pick servers
+// by their TXT records.
+func Entry(addr resolver.Address) *mdns.ServiceEntry {
+	e, _ := addr.Attributes.Value(attributeKey{}).(*mdns.ServiceEntry)
+	return e
+}
+
+type builder struct {
+	opts []mdns.QueryOption
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service, domain, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]mdns.QueryOption(nil), b.opts...)
+	if domain != "" {
+		opts = append(opts, mdns.WithDomain(domain))
+	}
+	browser, err := mdns.NewBrowser(context.Background(), service, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r := &mdnsResolver{
+		cc:      cc,
+		browser: browser,
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+// parseTarget splits the endpoint of a target, such as
+// "_echo._tcp.example.com", into the service type and domain.
+func parseTarget(target resolver.Target) (service, domain string, err error) {
+	endpoint := strings.Trim(target.URL.Path, "/")
+	if endpoint == "" {
+		endpoint = target.URL.Opaque
+	}
+	labels := strings.Split(strings.TrimSuffix(endpoint, "."), ".")
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || labels[1] != "_tcp" && labels[1] != "_udp" {
+		return "", "", fmt.Errorf("grpcresolver: target %q does not name a service type, such as _echo._tcp", endpoint)
+	}
+	return labels[0] + "." + labels[1], strings.Join(labels[2:], "."), nil
+}
+
+// mdnsResolver keeps a connection's addresses up to date with the instances
+// found by a browser.
+type mdnsResolver struct {
+	cc      resolver.ClientConn
+	browser *mdns.Browser
+	done    chan struct{}
+}
+
+// run updates the addresses whenever the browser reports a change, until the
+// browser is closed.
+func (r *mdnsResolver) run() {
+	defer close(r.done)
+	for range r.browser.Events() {
+		r.update()
+	}
+}
+
+// update gives the connection the addresses of the instances currently
+// known.
+func (r *mdnsResolver) update() {
+	addrs := []resolver.Address{}
+	for _, e := range r.browser.Entries() {
+		var ip string
+		switch {
+		case e.AddrV4 != nil:
+			ip = e.AddrV4.String()
+		case e.AddrV6 != nil:
+			ip = (&net.IPAddr{IP: e.AddrV6, Zone: e.Zone}).String()
+		default:
+			continue
+		}
+		addrs = append(addrs, resolver.Address{
+			Addr:       net.JoinHostPort(ip, fmt.Sprint(e.Port)),
+			Attributes: attributes.New(attributeKey{}, e),
+		})
+	}
+	// An error means the balancer rejected the addresses, for example
+	// because there are none; it will ask again with ResolveNow, and the
+	// browser reports the next change anyway.
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow does nothing, as the browser follows changes continuously.
+func (r *mdnsResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close stops browsing.
+func (r *mdnsResolver) Close() {
+	r.browser.Close()
+	<-r.done
+}
@@ -0,0 +1,142 @@
+package grpcresolver
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"google.golang.org/grpc/resolver"
+)
+
+// testClientConn records the states given by a resolver.
+type testClientConn struct {
+	resolver.ClientConn
+
+	lock   sync.Mutex
+	states []resolver.State
+}
+
+func (c *testClientConn) UpdateState(s resolver.State) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.states = append(c.states, s)
+	return nil
+}
+
+// waitFor waits until the latest state satisfies ok.
+func (c *testClientConn) waitFor(t *testing.T, ok func(resolver.State) bool) resolver.State {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		c.lock.Lock()
+		var s resolver.State
+		if n := len(c.states); n > 0 {
+			s = c.states[n-1]
+		}
+		c.lock.Unlock()
+		if ok(s) {
+			return s
+		}
+	}
+	t.Fatalf("timed out waiting for the addresses to be updated")
+	return resolver.State{}
+}
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		target, service, domain string
+	}{
+		{"mdns:///_echo._tcp", "_echo._tcp", ""},
+		{"mdns:///_echo._udp.example.com.", "_echo._udp", "example.com"},
+		{"mdns:_echo._tcp", "_echo._tcp", ""},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.target)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		service, domain, err := parseTarget(resolver.Target{URL: *u})
+		if err != nil || service != c.service || domain != c.domain {
+			t.Errorf("parseTarget(%q) = %q, %q, %v", c.target, service, domain, err)
+		}
+	}
+	u, _ := url.Parse("mdns:///echo")
+	if _, _, err := parseTarget(resolver.Target{URL: *u}); err == nil {
+		t.Errorf("expected an error for a target that is not a service type")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	zone, err := mdns.NewMDNSService("hostname", "_foobar._tcp", "local.", "testhost.", 8080,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"version=1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	u, _ := url.Parse("mdns:///_foobar._tcp")
+	cc := new(testClientConn)
+	r, err := NewBuilder().Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer r.Close()
+
+	s := cc.waitFor(t, func(s resolver.State) bool { return len(s.Addresses) == 1 })
+	if addr := s.Addresses[0]; addr.Addr != "192.168.0.42:8080" {
+		t.Errorf("bad address: %v", addr.Addr)
+	}
+	if e := Entry(s.Addresses[0]); e == nil || e.TXTMap()["version"] != "1" {
+		t.Errorf("bad entry: %+v", e)
+	}
+
+	// The goodbyes sent on shutdown remove the address.
+	serv.Shutdown()
+	cc.waitFor(t, func(s resolver.State) bool { return s.Addresses != nil && len(s.Addresses) == 0 })
+}
+
+func TestResolver_AddressChange(t *testing.T) {
+	zone, err := mdns.NewMDNSService("hostname", "_moved._tcp", "local.", "testhost.", 8080,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	set, err := mdns.NewServiceSet(zone)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	u, _ := url.Parse("mdns:///_moved._tcp")
+	cc := new(testClientConn)
+	r, err := NewBuilder().Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer r.Close()
+	cc.waitFor(t, func(s resolver.State) bool {
+		return len(s.Addresses) == 1 && s.Addresses[0].Addr == "192.168.0.42:8080"
+	})
+
+	// The backend moves to another address, which replaces the old one.
+	moved, err := mdns.NewMDNSService("hostname", "_moved._tcp", "local.", "testhost.", 8080,
+		[]net.IP{net.IPv4(192, 168, 0, 43)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := set.Replace(moved); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv.Announce(moved)
+	cc.waitFor(t, func(s resolver.State) bool {
+		return len(s.Addresses) == 1 && s.Addresses[0].Addr == "192.168.0.43:8080"
+	})
+}
@@ -0,0 +1,269 @@
+// Package homekit advertises HomeKit accessories, as "_hap._tcp" instances
+// whose TXT record carries the keys required by the HomeKit Accessory
+// Protocol, and keeps that record correct as the accessory changes.
+//
+// An accessory server, such as a bridge, is advertised with
+//
+//     set, _ := mdns.NewServiceSet()
+//     server, _ := mdns.NewServer(&mdns.Config{Zone: set})
+//     a, err := homekit.New(&homekit.Config{
+//         Name:         "Go Bridge",
+//         DeviceID:     "12:34:56:78:9A:BC",
+//         Model:        "GoBridge1,1",
+//         Category:     homekit.Bridge,
+//         Port:         51826,
+//         ConfigNumber: saved.ConfigNumber,
+//         SetupHash:    homekit.SetupHash(setupID, "12:34:56:78:9A:BC"),
+//         Server:       server,
+//         Set:          set,
+//     })
+//
+// When the accessories, services or characteristics it serves change, or its
+// firmware is updated, the server calls ConfigChanged, which bumps the
+// configuration number ("c#") so that controllers fetch the new database, and
+// persists ConfigNumber.  SetPaired updates the status flags ("sf") when the
+// first controller pairs or the last one is removed.
+package homekit
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/micro/mdns"
+)
+
+// Service is the service type of HomeKit accessory servers on IP networks.
+const Service = "_hap._tcp"
+
+// maxConfigNumber is the largest configuration number, after which it wraps
+// to 1.
+const maxConfigNumber = 65535
+
+// Category is the category of an accessory, shown by controllers before it is
+// paired.
+type Category int
+
+// Accessory categories.
+const (
+	Other              Category = 1
+	Bridge             Category = 2
+	Fan                Category = 3
+	GarageDoorOpener   Category = 4
+	Lightbulb          Category = 5
+	DoorLock           Category = 6
+	Outlet             Category = 7
+	Switch             Category = 8
+	Thermostat         Category = 9
+	Sensor             Category = 10
+	SecuritySystem     Category = 11
+	Door               Category = 12
+	Window             Category = 13
+	WindowCovering     Category = 14
+	ProgrammableSwitch Category = 15
+	IPCamera           Category = 17
+	VideoDoorbell      Category = 18
+	AirPurifier        Category = 19
+	Heater             Category = 20
+	AirConditioner     Category = 21
+	Humidifier         Category = 22
+	Dehumidifier       Category = 23
+	Sprinkler          Category = 28
+	Faucet             Category = 29
+	ShowerSystem       Category = 30
+	Television         Category = 31
+	Remote             Category = 32
+	Router             Category = 33
+)
+
+// Config is used to configure an Accessory.
+type Config struct {
+	// Name is the instance name, shown by controllers.  Required.
+	Name string
+
+	// DeviceID is the accessory server's pairing identifier, in the form of
+	// a MAC address such as "12:34:56:78:9A:BC".  It must stay the same for
+	// the life of the pairings.  Required.
+	DeviceID string
+
+	// Model is the model name, such as "GoBridge1,1".  Required.
+	Model string
+
+	// Category is the category of the accessory, or Bridge.  Required.
+	Category Category
+
+	// Port is the port of the accessory server.  Required.
+	Port int
+
+	// ConfigNumber is the configuration number persisted from the last run,
+	// default 1.
+	ConfigNumber int
+
+	// Paired is whether the accessory server has been paired with a
+	// controller.
+	Paired bool
+
+	// Features are the pairing feature flags ("ff"), default 0 for software
+	// authentication.
+	Features int
+
+	// SetupHash, if set, is advertised so that controllers can match the
+	// accessory to a scanned setup code; see SetupHash.
+	SetupHash string
+
+	// HostName and IPs default as for mdns.NewMDNSService.
+	HostName string
+	IPs      []net.IP
+
+	// Server and Set publish the accessory; Set must be the server's zone.
+	// Required.
+	Server *mdns.Server
+	Set    *mdns.ServiceSet
+}
+
+// Accessory is an advertised accessory server.  It is safe for concurrent
+// use.
+type Accessory struct {
+	config *Config
+
+	lock         sync.Mutex
+	svc          *mdns.MDNSService
+	configNumber int
+	paired       bool
+}
+
+// SetupHash returns the setup hash ("sh") of an accessory server, from the
+// four-character setup ID of its setup code and its device ID.
+func SetupHash(setupID, deviceID string) string {
+	sum := sha512.Sum512([]byte(setupID + deviceID))
+	return base64.StdEncoding.EncodeToString(sum[:4])
+}
+
+// New advertises an accessory server.
+func New(config *Config) (*Accessory, error) {
+	if config.Name == "" || config.Model == "" || config.Port == 0 || config.Category == 0 {
+		return nil, fmt.Errorf("homekit: a name, model, category and port are required")
+	}
+	if _, err := net.ParseMAC(config.DeviceID); err != nil || len(config.DeviceID) != 17 {
+		return nil, fmt.Errorf("homekit: bad device ID %q", config.DeviceID)
+	}
+	if config.Server == nil || config.Set == nil {
+		return nil, fmt.Errorf("homekit: a server and its service set are required")
+	}
+	a := &Accessory{
+		config:       config,
+		configNumber: config.ConfigNumber,
+		paired:       config.Paired,
+	}
+	if a.configNumber < 1 || a.configNumber > maxConfigNumber {
+		a.configNumber = 1
+	}
+	svc, err := mdns.NewMDNSService(config.Name, Service, "", config.HostName, config.Port, config.IPs, a.txt())
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Set.Add(svc); err != nil {
+		return nil, err
+	}
+	a.svc = svc
+	config.Server.Announce(svc)
+	return a, nil
+}
+
+// txt returns the TXT record for the current state.  The caller must hold
+// lock, or be creating the accessory.
+func (a *Accessory) txt() []string {
+	sf := 1
+	if a.paired {
+		sf = 0
+	}
+	txt := []string{
+		"c#=" + strconv.Itoa(a.configNumber),
+		"ff=" + strconv.Itoa(a.config.Features),
+		"id=" + a.config.DeviceID,
+		"md=" + a.config.Model,
+		"pv=1.1",
+		"s#=1",
+		"sf=" + strconv.Itoa(sf),
+		"ci=" + strconv.Itoa(int(a.config.Category)),
+	}
+	if a.config.SetupHash != "" {
+		txt = append(txt, "sh="+a.config.SetupHash)
+	}
+	return txt
+}
+
+// update replaces the advertised service with one carrying the current TXT
+// record, and announces it.  The caller must hold lock.
+func (a *Accessory) update() {
+	if a.svc == nil {
+		return
+	}
+	// Services are replaced rather than modified, as the server may be
+	// reading the old one.
+	svc := *a.svc
+	svc.TXT = a.txt()
+	if _, err := a.config.Set.Replace(&svc); err != nil {
+		return
+	}
+	a.svc = &svc
+	a.config.Server.Announce(&svc)
+}
+
+// TXT returns the TXT record currently advertised.
+func (a *Accessory) TXT() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.txt()
+}
+
+// ConfigNumber returns the current configuration number, which the accessory
+// server persists so that it keeps increasing across restarts.
+func (a *Accessory) ConfigNumber() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.configNumber
+}
+
+// ConfigChanged increments the configuration number, wrapping to 1 after
+// 65535, and announces it.  It is called when accessories, services or
+// characteristics are added or removed, and after a firmware update.  It
+// returns the new number.
+func (a *Accessory) ConfigChanged() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.configNumber++
+	if a.configNumber > maxConfigNumber {
+		a.configNumber = 1
+	}
+	a.update()
+	return a.configNumber
+}
+
+// SetPaired sets whether the accessory server is paired, announcing the new
+// status flags if it changed.
+func (a *Accessory) SetPaired(paired bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.paired == paired {
+		return
+	}
+	a.paired = paired
+	a.update()
+}
+
+// Close withdraws the accessory server.
+func (a *Accessory) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.svc == nil {
+		return nil
+	}
+	svc := a.svc
+	a.svc = nil
+	a.config.Set.Remove(svc.InstanceName())
+	return a.config.Server.Withdraw(svc)
+}
@@ -0,0 +1,82 @@
+package homekit
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/micro/mdns"
+)
+
+func newTestAccessory(t *testing.T, configNumber int) (*Accessory, *mdns.ServiceSet) {
+	set, err := mdns.NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	t.Cleanup(func() { serv.Shutdown() })
+	a, err := New(&Config{
+		Name:         "Go Bridge",
+		DeviceID:     "12:34:56:78:9A:BC",
+		Model:        "GoBridge1,1",
+		Category:     Bridge,
+		Port:         51826,
+		ConfigNumber: configNumber,
+		SetupHash:    "abcd",
+		HostName:     "bridge.local.",
+		IPs:          []net.IP{net.IPv4(192, 168, 0, 42)},
+		Server:       serv,
+		Set:          set,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return a, set
+}
+
+func TestAccessory(t *testing.T) {
+	a, set := newTestAccessory(t, 0)
+	want := []string{"c#=1", "ff=0", "id=12:34:56:78:9A:BC", "md=GoBridge1,1", "pv=1.1", "s#=1", "sf=1", "ci=2", "sh=abcd"}
+	if got := set.Get("Go Bridge._hap._tcp.local.").TXT; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad TXT: %v", got)
+	}
+
+	if n := a.ConfigChanged(); n != 2 {
+		t.Errorf("bad config number: %d", n)
+	}
+	a.SetPaired(true)
+	txt := set.Get("Go Bridge._hap._tcp.local.").TXT
+	if txt[0] != "c#=2" || txt[6] != "sf=0" {
+		t.Errorf("bad TXT: %v", txt)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if svc := set.Get("Go Bridge._hap._tcp.local."); svc != nil {
+		t.Errorf("service not withdrawn: %+v", svc)
+	}
+}
+
+func TestAccessory_ConfigNumberWraps(t *testing.T) {
+	a, _ := newTestAccessory(t, 65535)
+	if n := a.ConfigChanged(); n != 1 {
+		t.Errorf("config number did not wrap: %d", n)
+	}
+}
+
+func TestSetupHash(t *testing.T) {
+	// The first four bytes of the SHA-512 of "7OSXC8:D8:3A:B6:8C:E5".
+	if h := SetupHash("7OSX", "C8:D8:3A:B6:8C:E5"); h != "3dW7+A==" {
+		t.Errorf("bad setup hash: %q", h)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&Config{Name: "x", DeviceID: "not a MAC", Model: "m", Category: Other, Port: 1}); err == nil {
+		t.Errorf("expected an error for a bad device ID")
+	}
+}
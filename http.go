@@ -0,0 +1,89 @@
+package mdns
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// tlsListenerType is the type of the listeners http.Server.ServeTLS serves
+// on, which is not exported.
+var tlsListenerType = reflect.TypeOf(tls.NewListener(nil, &tls.Config{}))
+
+// PublishHTTP advertises srv as an instance of "_http._tcp", or of
+// "_https._tcp" if it serves TLS with ServeTLS or ListenAndServeTLS, once it
+// starts serving, and withdraws it when srv.Shutdown is called.  It must be
+// called before srv is started.
+//
+// The port is taken from the listener srv serves on, so servers listening on
+// a port chosen by the system are published correctly:
+//
+//     srv := &http.Server{Handler: mux}
+//     if err := mdns.PublishHTTP(srv, "My App", "path=/"); err != nil {
+//         log.Fatal(err)
+//     }
+//     log.Fatal(srv.ListenAndServe())
+//
+// The service is published for the first listener only, with the
+// listener's address if it is bound to one, else with the host's addresses.
+// Errors while publishing are logged.
+func PublishHTTP(srv *http.Server, instance string, txt ...string) error {
+	if instance == "" {
+		return fmt.Errorf("mdns: missing service instance name")
+	}
+	var once sync.Once
+	base := srv.BaseContext
+	srv.BaseContext = func(l net.Listener) context.Context {
+		once.Do(func() { publishHTTP(srv, l, instance, txt) })
+		if base != nil {
+			return base(l)
+		}
+		return context.Background()
+	}
+	return nil
+}
+
+// publishHTTP starts advertising srv as served on l.
+func publishHTTP(srv *http.Server, l net.Listener, instance string, txt []string) {
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		log.Printf("[ERR] mdns: Failed to publish %s: %v is not a TCP address", instance, l.Addr())
+		return
+	}
+	service := httpServiceType(l)
+	var ips []net.IP
+	if !addr.IP.IsUnspecified() {
+		ips = []net.IP{addr.IP}
+	}
+	svc, err := NewMDNSService(instance, service, "", "", addr.Port, ips, txt)
+	if err != nil {
+		log.Printf("[ERR] mdns: Failed to publish %s: %v", instance, err)
+		return
+	}
+	server, err := NewServer(&Config{Zone: svc})
+	if err != nil {
+		log.Printf("[ERR] mdns: Failed to publish %s: %v", instance, err)
+		return
+	}
+	srv.RegisterOnShutdown(func() {
+		if err := server.Shutdown(); err != nil {
+			log.Printf("[ERR] mdns: Failed to withdraw %s: %v", instance, err)
+		}
+	})
+}
+
+// httpServiceType returns the service type of an HTTP server serving on l.
+// TLS is told from the listener alone, as http.Server sets TLSConfig while
+// setting up HTTP/2 even for servers that do not serve TLS.
+func httpServiceType(l net.Listener) string {
+	if reflect.TypeOf(l) == tlsListenerType {
+		return "_https._tcp"
+	}
+	return "_http._tcp"
+}
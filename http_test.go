@@ -0,0 +1,76 @@
+package mdns
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestPublishHTTP(t *testing.T) {
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	if err := PublishHTTP(srv, "httptest", "path=/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The service is withdrawn in the background; wait for its server to
+	// close, so that it does not outlive the test.
+	held := func() int {
+		mobile.lock.Lock()
+		defer mobile.lock.Unlock()
+		return mobile.held
+	}
+	before := held()
+	go srv.Serve(l)
+	defer func() {
+		srv.Shutdown(context.Background())
+		for deadline := time.Now().Add(3 * time.Second); held() > before; {
+			if time.Now().After(deadline) {
+				t.Fatalf("service not withdrawn")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var entry *ServiceEntry
+	for deadline := time.Now().Add(3 * time.Second); entry == nil && time.Now().Before(deadline); {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		entry, _ = Resolve(ctx, "httptest._http._tcp.local.")
+		cancel()
+	}
+	if entry == nil {
+		t.Fatalf("service not published")
+	}
+	if entry.Port != l.Addr().(*net.TCPAddr).Port || !entry.AddrV4.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("bad entry: %+v", entry)
+	}
+	if entry.TXTMap()["path"] != "/" {
+		t.Errorf("bad TXT: %v", entry.InfoFields)
+	}
+}
+
+func TestPublishHTTP_NoInstance(t *testing.T) {
+	if err := PublishHTTP(&http.Server{}, ""); err == nil {
+		t.Errorf("expected an error without an instance name")
+	}
+}
+
+func TestHTTPServiceType(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	if got := httpServiceType(l); got != "_http._tcp" {
+		t.Errorf("plain listener published as %s", got)
+	}
+	if got := httpServiceType(tls.NewListener(l, &tls.Config{})); got != "_https._tcp" {
+		t.Errorf("TLS listener published as %s", got)
+	}
+}
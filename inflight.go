@@ -0,0 +1,64 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy is what a server does with a query received while
+// Config.MaxInFlight queries are already being handled.
+type OverflowPolicy int
+
+const (
+	// OverflowWait stops reading packets until a query has been handled,
+	// leaving new ones queued in the socket's receive buffer, where the
+	// kernel drops them once it is full.
+	OverflowWait OverflowPolicy = iota
+
+	// OverflowDrop drops the query at once, keeping the receive loop
+	// running.
+	OverflowDrop
+)
+
+// packetBufs holds the copies of packets handled in the background.
+var packetBufs = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// handlePacket handles a received packet: at once if Config.MaxInFlight is
+// not set, else in the background, with at most MaxInFlight packets handled
+// at a time.  packet may be reused once it returns.
+func (s *Server) handlePacket(packet []byte, from net.Addr, info packetInfo) {
+	if s.inFlight == nil {
+		if err := s.parsePacket(packet, from, info); err != nil {
+			log.Printf("[ERR] mdns: Failed to handle query: %v", err)
+		}
+		return
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		if s.conf().Overflow == OverflowDrop {
+			atomic.AddUint64(&s.metrics.DroppedPackets, 1)
+			return
+		}
+		select {
+		case s.inFlight <- struct{}{}:
+		case <-s.shutdownCh:
+			return
+		}
+	}
+	bp := packetBufs.Get().(*[]byte)
+	buf := append((*bp)[:0], packet...)
+	go func() {
+		defer func() {
+			*bp = buf[:0]
+			packetBufs.Put(bp)
+			<-s.inFlight
+		}()
+		if err := s.parsePacket(buf, from, info); err != nil {
+			log.Printf("[ERR] mdns: Failed to handle query: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package mdns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// blockingZone blocks lookups of "block.local." until release is closed.
+type blockingZone struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (z *blockingZone) Records(q dns.Question) []dns.RR {
+	if q.Name == "block.local." {
+		z.started <- struct{}{}
+		<-z.release
+	}
+	return nil
+}
+
+func TestServer_MaxInFlight(t *testing.T) {
+	z := &blockingZone{started: make(chan struct{}, 2), release: make(chan struct{})}
+	metrics := new(ServerMetrics)
+	serv, err := NewServer(&Config{Zone: z, MaxInFlight: 1, Overflow: OverflowDrop, Metrics: metrics})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	q := new(dns.Msg)
+	q.SetQuestion("block.local.", dns.TypeA)
+	packet, err := q.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5354}
+
+	serv.handlePacket(packet, from, packetInfo{})
+	select {
+	case <-z.started:
+	case <-time.After(time.Second):
+		t.Fatalf("query not handled")
+	}
+
+	// The first query is still being handled, so the second is dropped.
+	serv.handlePacket(packet, from, packetInfo{})
+	if n := atomic.LoadUint64(&metrics.DroppedPackets); n != 1 {
+		t.Errorf("dropped %d packets, want 1", n)
+	}
+
+	close(z.release)
+	select {
+	case serv.inFlight <- struct{}{}:
+		<-serv.inFlight
+	case <-time.After(time.Second):
+		t.Fatalf("slot not released")
+	}
+}
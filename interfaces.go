@@ -0,0 +1,21 @@
+//go:build !android && !tinygo && !windows
+// +build !android,!tinygo,!windows
+
+package mdns
+
+import "net"
+
+// sendPerInterface is set where packets for the groups are sent on each
+// joined interface in turn, as on Windows.
+const sendPerInterface = false
+
+// systemInterfaces returns the interfaces to join the mDNS groups on when
+// none are configured.
+func systemInterfaces() ([]net.Interface, error) {
+	return net.Interfaces()
+}
+
+// bindUDP binds a UDP socket to addr.
+func bindUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	return net.ListenUDP(network, addr)
+}
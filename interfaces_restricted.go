@@ -0,0 +1,29 @@
+//go:build android || tinygo
+// +build android tinygo
+
+package mdns
+
+import "net"
+
+// sendPerInterface is set where packets for the groups are sent on each
+// joined interface in turn, as on Windows.
+const sendPerInterface = false
+
+// systemInterfaces returns the interfaces to join the mDNS groups on when
+// none are configured.  Apps on recent Android versions may not enumerate
+// interfaces, and TinyGo may not support it, so if that fails the groups are
+// joined on the system's default multicast interface, given by the zero
+// Interface.  Pass interfaces explicitly, with Config.Interfaces or
+// WithInterface, to use others.
+func systemInterfaces() ([]net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		return []net.Interface{{}}, nil
+	}
+	return ifaces, nil
+}
+
+// bindUDP binds a UDP socket to addr.
+func bindUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	return net.ListenUDP(network, addr)
+}
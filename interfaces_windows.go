@@ -0,0 +1,67 @@
+//go:build windows && !tinygo
+// +build windows,!tinygo
+
+package mdns
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// sendPerInterface is set where a multicast send leaves through only one
+// interface, so that packets for the groups are sent on each joined
+// interface in turn.  Windows sends them through the adapter of the default
+// route, which on typical machines is a VPN or a Hyper-V or WSL switch
+// rather than the LAN.
+const sendPerInterface = true
+
+// systemInterfaces returns the interfaces to join the mDNS groups on when
+// none are configured.  On Windows, joining a group on an adapter that is
+// disconnected or has no address, as VPN, Hyper-V and WSL adapters often
+// are, fails or takes the group away from the adapter that works, so only
+// the up, multicast capable adapters with an address are returned.
+func systemInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if addrs, err := iface.Addrs(); err != nil || len(addrs) == 0 {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+// bindUDP binds a UDP socket to addr.  Windows does not bind to multicast
+// addresses, so a socket for a group is bound to the unspecified address
+// instead, and the port is shared with the other responders on the machine,
+// such as Bonjour's, which Windows only allows with SO_REUSEADDR set before
+// binding.
+func bindUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	if addr.IP.IsMulticast() {
+		addr = &net.UDPAddr{Port: addr.Port}
+	}
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+	c, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return c.(*net.UDPConn), nil
+}
@@ -0,0 +1,172 @@
+package mdns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// The exchanges in testdata/interop are in the forms sent by mDNSResponder,
+// Avahi and ESP-IDF: each file holds a query sent by one of them, and the
+// response it gives when advertising the service of interopService.  Our
+// responder must give the same answers to the query, and our client must
+// find the service in the response.
+//
+// A file starts with comments describing the exchange, followed by a line
+// holding "query" and the query packet in hex, and a line holding
+// "response" and the response packet in hex.  More exchanges can be added by
+// capturing them, for example with tcpdump, against a host advertising the
+// same service.
+
+// interopService returns the service advertised in the exchanges.
+func interopService(t *testing.T) *MDNSService {
+	svc, err := NewMDNSService("printer", "_http._tcp", "local.", "printer.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 1, 20)}, []string{"path=/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return svc
+}
+
+// interopExchange is a query and the response to it.
+type interopExchange struct {
+	name            string
+	query, response *dns.Msg
+}
+
+func readInteropExchanges(t *testing.T) []interopExchange {
+	files, err := filepath.Glob(filepath.Join("testdata", "interop", "*.txt"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no exchanges found")
+	}
+	var exchanges []interopExchange
+	for _, file := range files {
+		packets, err := readHexPackets(file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		ex := interopExchange{name: filepath.Base(file), query: new(dns.Msg), response: new(dns.Msg)}
+		if err := ex.query.Unpack(packets["query"]); err != nil {
+			t.Fatalf("%s: bad query: %v", file, err)
+		}
+		if err := ex.response.Unpack(packets["response"]); err != nil {
+			t.Fatalf("%s: bad response: %v", file, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges
+}
+
+// readHexPackets reads the packets of an exchange file, by section name.
+func readHexPackets(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hexes := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "query" || line == "response":
+			section = line
+		case section == "":
+			return nil, fmt.Errorf("packet data before a section")
+		default:
+			hexes[section] += line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	packets := make(map[string][]byte)
+	for _, section := range []string{"query", "response"} {
+		b, err := hex.DecodeString(hexes[section])
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("bad or missing %s: %v", section, err)
+		}
+		packets[section] = b
+	}
+	return packets, nil
+}
+
+// semanticRecords describes the records of rrs that our responder is
+// expected to give, ignoring their order, section, TTL and cache-flush bit,
+// and the SRV priority and weight.  NSEC and OPT records, which it does not
+// send, are left out.
+func semanticRecords(rrs []dns.RR) []string {
+	var recs []string
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		var rec string
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			rec = fmt.Sprintf("%s PTR %s", name, strings.ToLower(rr.Ptr))
+		case *dns.SRV:
+			rec = fmt.Sprintf("%s SRV %d %s", name, rr.Port, strings.ToLower(rr.Target))
+		case *dns.TXT:
+			rec = fmt.Sprintf("%s TXT %q", name, rr.Txt)
+		case *dns.A:
+			rec = fmt.Sprintf("%s A %v", name, rr.A)
+		case *dns.AAAA:
+			rec = fmt.Sprintf("%s AAAA %v", name, rr.AAAA)
+		default:
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	sort.Strings(recs)
+	return recs
+}
+
+func TestInterop_Responder(t *testing.T) {
+	s := &Server{config: &Config{Zone: interopService(t)}, metrics: new(ServerMetrics)}
+	for _, ex := range readInteropExchanges(t) {
+		st := queryStates.Get().(*queryState)
+		st.query = *ex.query
+		s.handleQuestions(st)
+		got := semanticRecords(append(append([]dns.RR(nil), st.multicast...), st.unicast...))
+		st.release()
+
+		want := semanticRecords(append(append([]dns.RR(nil), ex.response.Answer...), ex.response.Extra...))
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: got answers\n\t%s\nwant\n\t%s", ex.name, strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+		}
+	}
+}
+
+func TestInterop_Client(t *testing.T) {
+	for _, ex := range readInteropExchanges(t) {
+		if len(ex.query.Question) == 0 || ex.query.Question[0].Qtype != dns.TypePTR {
+			continue
+		}
+		var found *ServiceEntry
+		for _, e := range messageToEntries(ex.response, make(map[string]*ServiceEntry)) {
+			if e.Name == "printer._http._tcp.local." {
+				found = e
+			}
+		}
+		if found == nil {
+			t.Errorf("%s: service not found", ex.name)
+			continue
+		}
+		if !found.complete() || found.Host != "printer.local." || found.Port != 80 ||
+			!found.AddrV4.Equal(net.IPv4(192, 168, 1, 20)) || strings.Join(found.InfoFields, "|") != "path=/" {
+			t.Errorf("%s: bad entry: %+v", ex.name, found)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package inventory
+
+import "strings"
+
+// Category is a kind of device, such as a printer or a camera.
+type Category string
+
+// The categories of DefaultRules.
+const (
+	Printer  Category = "printer"
+	Scanner  Category = "scanner"
+	Cast     Category = "cast"
+	NAS      Category = "nas"
+	Camera   Category = "camera"
+	HomeKit  Category = "homekit"
+	Computer Category = "computer"
+)
+
+// Rule assigns a category to the devices offering a matching service.  A
+// service matches if it has the rule's type, if set, carries the rule's TXT
+// keys, and satisfies Match, if set.
+type Rule struct {
+	Category Category
+
+	// Type is the service type to match, such as "_ipp._tcp".
+	Type string
+
+	// TXT are keys the service's TXT record must hold.  A key mapped to a
+	// non-empty value must have that value; both are compared without
+	// regard to case.
+	TXT map[string]string
+
+	// Match, if set, is called to decide on services that pass the other
+	// checks.
+	Match func(Service) bool
+}
+
+// DefaultRules classify the devices commonly found on home and office
+// networks.  They can be extended by appending to a copy:
+//
+//     rules := append(append([]inventory.Rule(nil), inventory.DefaultRules...),
+//         inventory.Rule{Category: "thermostat", Type: "_ecobee._tcp"})
+//     inv := inventory.New(&inventory.Config{Rules: rules})
+var DefaultRules = []Rule{
+	{Category: Printer, Type: "_ipp._tcp"},
+	{Category: Printer, Type: "_ipps._tcp"},
+	{Category: Printer, Type: "_printer._tcp"},
+	{Category: Printer, Type: "_pdl-datastream._tcp"},
+	{Category: Scanner, Type: "_uscan._tcp"},
+	{Category: Scanner, Type: "_uscans._tcp"},
+	{Category: Scanner, Type: "_scanner._tcp"},
+	{Category: Cast, Type: "_googlecast._tcp"},
+	{Category: Cast, Type: "_airplay._tcp"},
+	{Category: Cast, Type: "_raop._tcp"},
+	{Category: Cast, Type: "_spotify-connect._tcp"},
+	{Category: NAS, Type: "_adisk._tcp"},
+	{Category: NAS, Type: "_afpovertcp._tcp"},
+	{Category: NAS, Type: "_nfs._tcp"},
+	{Category: Camera, Type: "_axis-video._tcp"},
+	{Category: Camera, Type: "_rtsp._tcp"},
+	// HomeKit accessory category 17 is IP camera, 18 video doorbell.
+	{Category: Camera, Type: "_hap._tcp", TXT: map[string]string{"ci": "17"}},
+	{Category: Camera, Type: "_hap._tcp", TXT: map[string]string{"ci": "18"}},
+	{Category: HomeKit, Type: "_hap._tcp"},
+	{Category: HomeKit, Type: "_hap._udp"},
+	{Category: Computer, Type: "_workstation._tcp"},
+	{Category: Computer, Type: "_ssh._tcp"},
+}
+
+// matches returns true if s matches r.
+func (r *Rule) matches(s Service) bool {
+	if r.Type != "" && !strings.EqualFold(strings.Trim(r.Type, "."), strings.Trim(s.Type, ".")) {
+		return false
+	}
+	for key, want := range r.TXT {
+		value, ok := txtLookup(s.TXT, key)
+		if !ok || want != "" && !strings.EqualFold(value, want) {
+			return false
+		}
+	}
+	return r.Match == nil || r.Match(s)
+}
+
+// Classify returns the categories of the rules matched by any of services,
+// in the order of the rules, without duplicates.
+func Classify(services []Service, rules []Rule) []Category {
+	var categories []Category
+	seen := make(map[Category]bool)
+	for i := range rules {
+		r := &rules[i]
+		if seen[r.Category] {
+			continue
+		}
+		for _, s := range services {
+			if r.matches(s) {
+				seen[r.Category] = true
+				categories = append(categories, r.Category)
+				break
+			}
+		}
+	}
+	return categories
+}
+
+// txtLookup returns the value of key in the TXT strings txt, comparing keys
+// without regard to case.  Only the first occurrence of a key counts (RFC
+// 6763 section 6.4).
+func txtLookup(txt []string, key string) (string, bool) {
+	for _, s := range txt {
+		k, v := s, ""
+		if i := strings.Index(s, "="); i >= 0 {
+			k, v = s[:i], s[i+1:]
+		}
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
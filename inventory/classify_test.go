@@ -0,0 +1,34 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		services []Service
+		want     []Category
+	}{
+		{nil, nil},
+		{[]Service{{Type: "_http._tcp"}}, nil},
+		{[]Service{{Type: "_ipp._tcp"}, {Type: "_uscan._tcp"}, {Type: "_ipps._tcp"}}, []Category{Printer, Scanner}},
+		{[]Service{{Type: "_GoogleCast._tcp."}}, []Category{Cast}},
+		{[]Service{{Type: "_hap._tcp", TXT: []string{"c#=2", "ci=2"}}}, []Category{HomeKit}},
+		{[]Service{{Type: "_hap._tcp", TXT: []string{"CI=17", "ci=2"}}}, []Category{Camera, HomeKit}},
+	} {
+		if got := Classify(test.services, DefaultRules); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Classify(%+v) = %v, want %v", test.services, got, test.want)
+		}
+	}
+
+	rules := []Rule{
+		{Category: "thermostat", TXT: map[string]string{"md": ""}, Match: func(s Service) bool { return s.Port == 8080 }},
+	}
+	if got := Classify([]Service{{Type: "_x._tcp", Port: 80, TXT: []string{"md=t"}}}, rules); got != nil {
+		t.Errorf("got %v, want no categories", got)
+	}
+	if got := Classify([]Service{{Type: "_x._tcp", Port: 8080, TXT: []string{"md=t"}}}, rules); !reflect.DeepEqual(got, []Category{"thermostat"}) {
+		t.Errorf("got %v, want thermostat", got)
+	}
+}
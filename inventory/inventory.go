@@ -0,0 +1,344 @@
+// Package inventory keeps a list of the devices on the network, built from
+// the services they advertise: for each responder, its host name, its
+// addresses, the services it offers and when it was first and last seen.
+// It is the information `avahi-browse -art` prints, as a Go API, and can be
+// exported as JSON or CSV:
+//
+//	inv := inventory.New(&inventory.Config{})
+//	defer inv.Close()
+//	time.Sleep(10 * time.Second)
+//	for _, d := range inv.Devices() {
+//	    fmt.Println(d.Host, d.AddrV4, d.ServiceTypes())
+//	}
+//	inv.WriteCSV(os.Stdout)
+//
+// Devices stay in the inventory after their services are withdrawn, so that
+// the inventory records every device seen while it runs.
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// enumerateInterval is how often the service types on the network are looked
+// up again when Config.Services is empty.
+const enumerateInterval = time.Minute
+
+// Config is used to configure an Inventory.
+type Config struct {
+	// Services are the service types to browse, such as "_http._tcp".  If
+	// empty, every service type found on the network is browsed.
+	Services []string
+
+	// Options are used for browsing, for example mdns.WithInterface.
+	Options []mdns.QueryOption
+
+	// Rules classify the devices found, default DefaultRules.
+	Rules []Rule
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Device is a responder on the network, identified by its host name.
+type Device struct {
+	Host      string    `json:"host"`
+	AddrV4    []net.IP  `json:"ipv4"`
+	AddrV6    []net.IP  `json:"ipv6"`
+	Services  []Service `json:"services"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Categories are the categories of the rules that the device's services
+	// match, in the order of the rules.
+	Categories []Category `json:"categories"`
+}
+
+// Service is a service instance advertised by a device.
+type Service struct {
+	Instance string   `json:"instance"`
+	Type     string   `json:"type"`
+	Port     int      `json:"port"`
+	TXT      []string `json:"txt"`
+}
+
+// ServiceTypes returns the distinct types of the device's services, sorted.
+func (d *Device) ServiceTypes() []string {
+	// Services are kept sorted by type.
+	var types []string
+	for _, s := range d.Services {
+		if len(types) == 0 || types[len(types)-1] != s.Type {
+			types = append(types, s.Type)
+		}
+	}
+	return types
+}
+
+// Inventory browses for services in the background, from the time it is
+// created until Close is called, and keeps track of the devices offering
+// them.
+type Inventory struct {
+	config *Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lock     sync.Mutex
+	browsing map[string]bool    // Service types browsed
+	devices  map[string]*Device // By lower case host name
+}
+
+// New creates an inventory and starts browsing.
+func New(config *Config) *Inventory {
+	ctx, cancel := context.WithCancel(context.Background())
+	inv := &Inventory{
+		config:   config,
+		ctx:      ctx,
+		cancel:   cancel,
+		browsing: make(map[string]bool),
+		devices:  make(map[string]*Device),
+	}
+	if len(config.Services) > 0 {
+		for _, service := range config.Services {
+			inv.browse(service)
+		}
+	} else {
+		inv.wg.Add(1)
+		go inv.enumerate()
+	}
+	return inv
+}
+
+// Close stops browsing.  The devices found remain available.
+func (inv *Inventory) Close() {
+	inv.cancel()
+	inv.wg.Wait()
+}
+
+func (inv *Inventory) logf(format string, v ...interface{}) {
+	if inv.config.Logger != nil {
+		inv.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// browse starts a browser for service, if there is not one already.
+func (inv *Inventory) browse(service string) {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	if inv.browsing[service] {
+		return
+	}
+	b, err := mdns.NewBrowser(inv.ctx, service, inv.config.Options...)
+	if err != nil {
+		inv.logf("[ERR] inventory: Failed to browse %s: %v", service, err)
+		return
+	}
+	inv.browsing[service] = true
+	inv.wg.Add(1)
+	go func() {
+		defer inv.wg.Done()
+		for ev := range b.Events() {
+			inv.update(service, ev)
+		}
+	}()
+}
+
+// enumerate periodically looks for the service types on the network and
+// browses each of them.
+func (inv *Inventory) enumerate() {
+	defer inv.wg.Done()
+	for {
+		opts := append(append([]mdns.QueryOption(nil), inv.config.Options...), mdns.WithTimeout(2*time.Second))
+		types, err := mdns.ServiceTypes(inv.ctx, opts...)
+		if err != nil {
+			inv.logf("[ERR] inventory: Failed to enumerate service types: %v", err)
+		}
+		for _, t := range types {
+			inv.browse(t)
+		}
+		select {
+		case <-time.After(enumerateInterval):
+		case <-inv.ctx.Done():
+			return
+		}
+	}
+}
+
+// update records the change ev to an instance of service.
+func (inv *Inventory) update(service string, ev *mdns.BrowseEvent) {
+	e := ev.Entry
+	if e.Host == "" {
+		return
+	}
+	seen := e.LastSeen
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	key := strings.ToLower(e.Host)
+	d := inv.devices[key]
+	if d == nil {
+		d = &Device{Host: e.Host, FirstSeen: seen}
+		inv.devices[key] = d
+	}
+	if seen.After(d.LastSeen) {
+		d.LastSeen = seen
+	}
+
+	instance := e.Instance()
+	for i, s := range d.Services {
+		if s.Instance == instance && s.Type == service {
+			d.Services = append(d.Services[:i], d.Services[i+1:]...)
+			break
+		}
+	}
+	if ev.Type == mdns.ServiceRemoved {
+		return
+	}
+	d.Services = append(d.Services, Service{
+		Instance: instance,
+		Type:     service,
+		Port:     e.Port,
+		TXT:      append([]string(nil), e.InfoFields...),
+	})
+	sort.Slice(d.Services, func(i, j int) bool {
+		a, b := d.Services[i], d.Services[j]
+		return a.Type < b.Type || a.Type == b.Type && a.Instance < b.Instance
+	})
+	d.AddrV4 = addIP(d.AddrV4, e.AddrV4)
+	d.AddrV6 = addIP(d.AddrV6, e.AddrV6)
+}
+
+// addIP adds ip, if not nil, to the sorted ips.
+func addIP(ips []net.IP, ip net.IP) []net.IP {
+	if ip == nil {
+		return ips
+	}
+	i := sort.Search(len(ips), func(i int) bool { return bytes.Compare(ips[i].To16(), ip.To16()) >= 0 })
+	if i < len(ips) && ips[i].Equal(ip) {
+		return ips
+	}
+	ips = append(ips, nil)
+	copy(ips[i+1:], ips[i:])
+	ips[i] = append(net.IP(nil), ip...)
+	return ips
+}
+
+// Devices returns copies of the devices seen, sorted by host name.
+func (inv *Inventory) Devices() []Device {
+	rules := inv.config.Rules
+	if rules == nil {
+		rules = DefaultRules
+	}
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	devices := make([]Device, 0, len(inv.devices))
+	for _, d := range inv.devices {
+		c := *d
+		c.AddrV4 = append([]net.IP(nil), d.AddrV4...)
+		c.AddrV6 = append([]net.IP(nil), d.AddrV6...)
+		c.Services = append([]Service(nil), d.Services...)
+		c.Categories = Classify(c.Services, rules)
+		devices = append(devices, c)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Host < devices[j].Host })
+	return devices
+}
+
+// WriteJSON writes the devices seen to w as a JSON array of Devices.
+func (inv *Inventory) WriteJSON(w io.Writer) error {
+	devices := inv.Devices()
+	for i := range devices {
+		if devices[i].AddrV4 == nil {
+			devices[i].AddrV4 = []net.IP{}
+		}
+		if devices[i].AddrV6 == nil {
+			devices[i].AddrV6 = []net.IP{}
+		}
+		if devices[i].Services == nil {
+			devices[i].Services = []Service{}
+		}
+		if devices[i].Categories == nil {
+			devices[i].Categories = []Category{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(devices)
+}
+
+// csvHeader names the columns written by WriteCSV.
+var csvHeader = []string{"host", "ipv4", "ipv6", "type", "instance", "port", "txt", "categories", "first_seen", "last_seen"}
+
+// WriteCSV writes the devices seen to w as CSV, with a header row and a row
+// for each service, or a single row with empty service columns for a device
+// with none.  Multiple addresses, TXT strings and categories are separated
+// by spaces.
+func (inv *Inventory) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, d := range inv.Devices() {
+		services := d.Services
+		if len(services) == 0 {
+			services = []Service{{}}
+		}
+		for _, s := range services {
+			port := ""
+			if s.Port != 0 {
+				port = strconv.Itoa(s.Port)
+			}
+			row := []string{
+				d.Host,
+				joinIPs(d.AddrV4),
+				joinIPs(d.AddrV6),
+				s.Type,
+				s.Instance,
+				port,
+				strings.Join(s.TXT, " "),
+				joinCategories(d.Categories),
+				d.FirstSeen.UTC().Format(time.RFC3339),
+				d.LastSeen.UTC().Format(time.RFC3339),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func joinCategories(categories []Category) string {
+	s := make([]string, len(categories))
+	for i, c := range categories {
+		s[i] = string(c)
+	}
+	return strings.Join(s, " ")
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+)
+
+func TestInventory_Update(t *testing.T) {
+	inv := &Inventory{config: &Config{}, devices: make(map[string]*Device)}
+	first := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := first.Add(time.Minute)
+	inv.update("_http._tcp", &mdns.BrowseEvent{Type: mdns.ServiceAdded, Entry: &mdns.ServiceEntry{
+		Name: "web._http._tcp.local.", Host: "nas.local.", AddrV4: net.IPv4(192, 168, 0, 9),
+		Port: 80, InfoFields: []string{"path=/"}, LastSeen: first,
+	}})
+	inv.update("_smb._tcp", &mdns.BrowseEvent{Type: mdns.ServiceAdded, Entry: &mdns.ServiceEntry{
+		Name: "files._smb._tcp.local.", Host: "NAS.local.", AddrV4: net.IPv4(192, 168, 0, 9),
+		AddrV6: net.ParseIP("fe80::1"), Port: 445, LastSeen: last,
+	}})
+
+	devices := inv.Devices()
+	if len(devices) != 1 {
+		t.Fatalf("got %d devices, want 1: %+v", len(devices), devices)
+	}
+	d := devices[0]
+	if d.Host != "nas.local." || !d.FirstSeen.Equal(first) || !d.LastSeen.Equal(last) {
+		t.Errorf("bad device: %+v", d)
+	}
+	if len(d.AddrV4) != 1 || len(d.AddrV6) != 1 {
+		t.Errorf("bad addresses: %v %v", d.AddrV4, d.AddrV6)
+	}
+	if types := d.ServiceTypes(); !reflect.DeepEqual(types, []string{"_http._tcp", "_smb._tcp"}) {
+		t.Errorf("bad service types: %v", types)
+	}
+
+	var buf bytes.Buffer
+	if err := inv.WriteCSV(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	want := strings.Join([]string{
+		"host,ipv4,ipv6,type,instance,port,txt,categories,first_seen,last_seen",
+		"nas.local.,192.168.0.9,fe80::1,_http._tcp,web,80,path=/,,2016-01-01T00:00:00Z,2016-01-01T00:01:00Z",
+		"nas.local.,192.168.0.9,fe80::1,_smb._tcp,files,445,,,2016-01-01T00:00:00Z,2016-01-01T00:01:00Z",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Errorf("got CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	inv.update("_http._tcp", &mdns.BrowseEvent{Type: mdns.ServiceRemoved, Entry: &mdns.ServiceEntry{
+		Name: "web._http._tcp.local.", Host: "nas.local.", LastSeen: last,
+	}})
+	buf.Reset()
+	if err := inv.WriteJSON(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got []Device
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(got) != 1 || len(got[0].Services) != 1 || got[0].Services[0].Type != "_smb._tcp" {
+		t.Errorf("bad devices after removal: %+v", got)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	zone, err := mdns.NewMDNSService("hostname", "_inventorytest._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	inv := New(&Config{Services: []string{"_inventorytest._tcp"}})
+	defer inv.Close()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if devices := inv.Devices(); len(devices) == 1 {
+			if d := devices[0]; d.Host != "testhost." || len(d.Services) != 1 || d.Services[0].Port != 80 {
+				t.Errorf("bad device: %+v", d)
+			}
+			return
+		}
+	}
+	t.Fatalf("device not found")
+}
@@ -0,0 +1,47 @@
+//go:build go1.23
+// +build go1.23
+
+package mdns
+
+import (
+	"iter"
+
+	"golang.org/x/net/context"
+)
+
+// Discover returns an iterator over the instances of service, such as
+// "_http._tcp", yielding each as it is found and again when it changes.
+// It browses with the options of NewBrowser until ctx is done or the loop
+// stops, and so leaves nothing running behind it.  Instances that go away
+// are not reported; use a Browser to follow them.
+//
+// Example usage:
+//     for entry := range mdns.Discover(ctx, "_http._tcp") {
+//       fmt.Println(entry.Name, entry.AddrV4)
+//     }
+//
+// An error starting the browser ends the iteration at once; it is logged
+// like the browser's other errors.
+func Discover(ctx context.Context, service string, opts ...QueryOption) iter.Seq[*ServiceEntry] {
+	return func(yield func(*ServiceEntry) bool) {
+		b, err := NewBrowser(ctx, service, opts...)
+		if err != nil {
+			params := DefaultParams(service)
+			for _, opt := range opts {
+				opt(params)
+			}
+			(&Browser{params: params}).logf("[ERR] mdns: Failed to browse for %s: %v", service, err)
+			return
+		}
+		defer b.Close()
+
+		for ev := range b.Events() {
+			if ev.Type == ServiceRemoved {
+				continue
+			}
+			if !yield(ev.Entry) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build go1.23
+// +build go1.23
+
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestServer_Discover(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_iter._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var found *ServiceEntry
+	for entry := range Discover(ctx, "_iter._tcp") {
+		found = entry
+		break
+	}
+	if found == nil || found.Name != "hostname._iter._tcp.local." {
+		t.Fatalf("bad entry: %v", found)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("discovery ran until the timeout")
+	}
+}
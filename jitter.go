@@ -0,0 +1,88 @@
+package mdns
+
+import (
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseJitter is the delay before a server sends its answers to a query
+// for shared records, such as a browse, as set by Config.ResponseJitter.
+// Section 6 of RFC 6762 delays such answers by 20 to 120ms so that the
+// responses of the hosts answering the same query do not collide; fleets of
+// hundreds of responders, such as classroom devices or kiosks, need a wider
+// window, and Spread keeps hosts apart even if their random delays match.
+type ResponseJitter struct {
+	// Min and Max bound the random part of the delay.
+	Min, Max time.Duration
+
+	// Spread, if set, adds a fixed offset of up to Spread to each delay,
+	// derived from HostKey, which is the host's name by default.  Each host
+	// then answers in its own part of the window.
+	Spread  time.Duration
+	HostKey string
+}
+
+// offset returns the fixed part of the delay of this host.
+func (j *ResponseJitter) offset() time.Duration {
+	if j.Spread <= 0 {
+		return 0
+	}
+	key := j.HostKey
+	if key == "" {
+		key, _ = os.Hostname()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return time.Duration(h.Sum64() % uint64(j.Spread))
+}
+
+// delay returns how long to wait before sending a response holding recs:
+// nothing if they are all unique records, which are answered at once.
+func (j *ResponseJitter) delay(offset time.Duration, recs []dns.RR) time.Duration {
+	shared := false
+	for _, rr := range recs {
+		if _, ok := rr.(*dns.PTR); ok {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		return 0
+	}
+	d := offset + j.Min
+	if j.Max > j.Min {
+		d += time.Duration(rand.Int63n(int64(j.Max - j.Min)))
+	}
+	return d
+}
+
+// sendDelayed multicasts resp, the answer to a query from from received as
+// info tells, after d, in the background.
+func (s *Server) sendDelayed(resp *dns.Msg, from net.Addr, info packetInfo, d time.Duration) error {
+	buf, err := s.packMsg(resp, nil)
+	if err != nil || buf == nil {
+		return err
+	}
+	time.AfterFunc(d, func() {
+		if err := s.writeMulticast(buf, from, info); err != nil {
+			log.Printf("[ERR] mdns: error sending delayed response: %v", err)
+		}
+	})
+	return nil
+}
+
+// responseDelay returns how long to wait before multicasting recs, as
+// Config.ResponseJitter asks.
+func (s *Server) responseDelay(recs []dns.RR) time.Duration {
+	config := s.conf()
+	if config.ResponseJitter == nil {
+		return 0
+	}
+	return config.ResponseJitter.delay(s.jitterOffset, recs)
+}
@@ -0,0 +1,34 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResponseJitter(t *testing.T) {
+	j := &ResponseJitter{Min: 20 * time.Millisecond, Max: 500 * time.Millisecond, Spread: time.Second, HostKey: "kiosk-17"}
+	offset := j.offset()
+	if offset < 0 || offset >= j.Spread {
+		t.Fatalf("offset %v outside [0, %v)", offset, j.Spread)
+	}
+	if again := j.offset(); again != offset {
+		t.Errorf("offset changed from %v to %v", offset, again)
+	}
+	if other := (&ResponseJitter{Spread: time.Second, HostKey: "kiosk-18"}).offset(); other == offset {
+		t.Errorf("hosts share the offset %v", offset)
+	}
+
+	svc := makeService(t)
+	unique := svc.Records(dns.Question{Name: svc.instanceAddr, Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	if d := j.delay(offset, unique); d != 0 {
+		t.Errorf("unique records delayed by %v", d)
+	}
+	shared := svc.Records(dns.Question{Name: svc.serviceAddr, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	for i := 0; i < 100; i++ {
+		if d := j.delay(offset, shared); d < offset+j.Min || d >= offset+j.Max {
+			t.Fatalf("delay %v outside [%v, %v)", d, offset+j.Min, offset+j.Max)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package mdns
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+)
+
+// serviceEntryJSON is the JSON representation of a ServiceEntry.  The field
+// names and formats are a stable schema that programs may depend on:
+//
+//     {
+//       "name": "hostname._http._tcp.local.",
+//       "host": "testhost.local.",
+//       "port": 80,
+//       "ipv4": "192.168.0.42",
+//       "ipv6": "fe80::1%en0",
+//       "txt": {"path": "/"},
+//       "txt_raw": ["path=/"],
+//       "ttl": 120,
+//       "last_seen": "2016-01-01T00:00:00Z",
+//       "expires": "2016-01-01T00:02:00Z",
+//       "updated": true
+//     }
+//
+// Addresses of the families that are not known are omitted, as are the
+// timestamps of entries that were not received from the network.  IPv6
+// addresses include the zone when they are link-local.
+type serviceEntryJSON struct {
+	Name     string            `json:"name"`
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	IPv4     string            `json:"ipv4,omitempty"`
+	IPv6     string            `json:"ipv6,omitempty"`
+	TXT      map[string]string `json:"txt"`
+	TXTRaw   []string          `json:"txt_raw"`
+	TTL      int               `json:"ttl"`
+	LastSeen *time.Time        `json:"last_seen,omitempty"`
+	Expires  *time.Time        `json:"expires,omitempty"`
+	Updated  bool              `json:"updated,omitempty"`
+}
+
+// MarshalJSON encodes the entry using the schema documented on
+// serviceEntryJSON.
+func (s *ServiceEntry) MarshalJSON() ([]byte, error) {
+	j := serviceEntryJSON{
+		Name:    s.Name,
+		Host:    s.Host,
+		Port:    s.Port,
+		TXT:     s.TXTMap(),
+		TXTRaw:  s.InfoFields,
+		TTL:     s.TTL,
+		Updated: s.Updated,
+	}
+	if j.TXTRaw == nil {
+		j.TXTRaw = []string{}
+	}
+	if s.AddrV4 != nil {
+		j.IPv4 = s.AddrV4.String()
+	}
+	if s.AddrV6 != nil {
+		j.IPv6 = (&net.IPAddr{IP: s.AddrV6, Zone: s.Zone}).String()
+	}
+	if !s.LastSeen.IsZero() {
+		seen := s.LastSeen.UTC()
+		expires := seen.Add(time.Duration(s.TTL) * time.Second)
+		j.LastSeen, j.Expires = &seen, &expires
+	}
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON.
+func (s *ServiceEntry) UnmarshalJSON(data []byte) error {
+	var j serviceEntryJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = ServiceEntry{
+		Name:       j.Name,
+		Host:       j.Host,
+		Port:       j.Port,
+		InfoFields: j.TXTRaw,
+		Info:       strings.Join(j.TXTRaw, "|"),
+		TTL:        j.TTL,
+		Updated:    j.Updated,
+		hasTXT:     j.TXTRaw != nil,
+	}
+	if j.IPv4 != "" {
+		s.AddrV4 = net.ParseIP(j.IPv4).To4()
+		s.Addr = s.AddrV4 // @Deprecated
+	}
+	if j.IPv6 != "" {
+		ip, zone := j.IPv6, ""
+		if i := strings.LastIndex(ip, "%"); i >= 0 {
+			ip, zone = ip[:i], ip[i+1:]
+		}
+		s.AddrV6 = net.ParseIP(ip)
+		s.Zone = zone
+		s.Addr = s.AddrV6 // @Deprecated
+	}
+	if j.LastSeen != nil {
+		s.LastSeen = *j.LastSeen
+	}
+	return nil
+}
+
+// TXTMap returns the key/value pairs of the entry's TXT record, as described
+// in section 6 of RFC 6763.  Keys are lowercased, since they are compared
+// case-insensitively, and only the first occurrence of a key is kept.  A key
+// given without a value maps to the empty string.
+func (s *ServiceEntry) TXTMap() map[string]string {
+	return parseTXT(s.InfoFields)
+}
+
+// parseTXT parses DNS-SD TXT record strings into key/value pairs.
+func parseTXT(fields []string) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		key, value := f, ""
+		if i := strings.Index(f, "="); i >= 0 {
+			key, value = f[:i], f[i+1:]
+		}
+		if key == "" {
+			continue
+		}
+		key = strings.ToLower(key)
+		if _, ok := m[key]; ok {
+			continue
+		}
+		m[key] = value
+	}
+	return m
+}
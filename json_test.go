@@ -0,0 +1,46 @@
+package mdns
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServiceEntry_JSON(t *testing.T) {
+	e := &ServiceEntry{
+		Name:       "hostname._http._tcp.local.",
+		Host:       "testhost.local.",
+		AddrV4:     net.IP([]byte{192, 168, 0, 42}),
+		AddrV6:     net.ParseIP("fe80::1"),
+		Zone:       "en0",
+		Port:       80,
+		Info:       "path=/|Secure|PATH=/ignored",
+		InfoFields: []string{"path=/", "Secure", "PATH=/ignored"},
+		TTL:        120,
+		LastSeen:   time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		hasTXT:     true,
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"name":"hostname._http._tcp.local.","host":"testhost.local.","port":80,` +
+		`"ipv4":"192.168.0.42","ipv6":"fe80::1%en0","txt":{"path":"/","secure":""},` +
+		`"txt_raw":["path=/","Secure","PATH=/ignored"],"ttl":120,` +
+		`"last_seen":"2016-01-01T00:00:00Z","expires":"2016-01-01T00:02:00Z"}`
+	if got := string(data); got != want {
+		t.Errorf("Marshal(%+v) =\n%s\nwant\n%s", e, got, want)
+	}
+
+	var decoded ServiceEntry
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	decoded.Addr = nil
+	if !reflect.DeepEqual(&decoded, e) {
+		t.Errorf("round trip produced %+v, want %+v", &decoded, e)
+	}
+}
@@ -0,0 +1,15 @@
+package mdns
+
+// Limits on the work done for a single packet, so that malformed or hostile
+// traffic cannot make a server or client do unbounded work.  A packet of at
+// most 9000 bytes can still hold hundreds of small questions or records.
+const (
+	// maxQuestions is the most questions of a query that are answered.
+	// Queries from real responders ask a handful, or a few dozen when
+	// browsing many service types at once.
+	maxQuestions = 64
+
+	// maxMessageRecords is the most answer and additional records of a
+	// response that a client looks at.
+	maxMessageRecords = 256
+)
@@ -0,0 +1,240 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// llmnrTTL is the TTL of LLMNR answers, as recommended by section 2.8 of RFC
+// 4795.
+const llmnrTTL = 30
+
+var (
+	// LLMNR group addresses, from section 2 of RFC 4795.
+	llmnrAddrIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 252), Port: 5355}
+	llmnrAddrIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::1:3"), Port: 5355}
+)
+
+// llmnrToLocal maps an LLMNR name to the multicast DNS name of the same
+// host: a single label name such as "host." becomes "host.local.".  Reverse
+// mapping names are unchanged.  It returns false for other names, which LLMNR
+// responders do not answer.
+func llmnrToLocal(name string) (string, bool) {
+	name = dns.Fqdn(name)
+	if strings.HasSuffix(strings.ToLower(name), ".arpa.") {
+		return name, true
+	}
+	if dns.CountLabel(name) != 1 {
+		return "", false
+	}
+	return name + "local.", true
+}
+
+// llmnrFromLocal maps a multicast DNS host name, such as "host.local.", to
+// its LLMNR name, "host.".  Other names are unchanged.
+func llmnrFromLocal(name string) string {
+	if dns.CountLabel(name) == 2 && strings.HasSuffix(strings.ToLower(name), ".local.") {
+		return name[:len(name)-len("local.")]
+	}
+	return name
+}
+
+// listenLLMNR starts answering LLMNR queries for the server's zone.
+func (s *Server) listenLLMNR() error {
+	config := s.conf()
+	var ifaces []*net.Interface
+	if config.Iface != nil {
+		ifaces = []*net.Interface{config.Iface}
+	}
+	p4, p6, err := listenMulticast(ifaces, !config.DisableMulticastLoopback, llmnrAddrIPv4, llmnrAddrIPv6)
+	if err != nil {
+		return err
+	}
+	s.llmnr4, s.llmnr6 = p4, p6
+	if p4 != nil {
+		s.wg.Add(1)
+		go s.recvLLMNR(func(b []byte) (int, net.Addr, error) {
+			n, _, from, err := p4.ReadFrom(b)
+			return n, from, err
+		}, func(b []byte, to net.Addr) error {
+			_, err := p4.WriteTo(b, nil, to)
+			return err
+		})
+	}
+	if p6 != nil {
+		s.wg.Add(1)
+		go s.recvLLMNR(func(b []byte) (int, net.Addr, error) {
+			n, _, from, err := p6.ReadFrom(b)
+			return n, from, err
+		}, func(b []byte, to net.Addr) error {
+			_, err := p6.WriteTo(b, nil, to)
+			return err
+		})
+	}
+	return nil
+}
+
+// recvLLMNR answers the LLMNR queries read from one of the server's LLMNR
+// sockets until the server shuts down.
+func (s *Server) recvLLMNR(read func([]byte) (int, net.Addr, error), write func([]byte, net.Addr) error) {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := read(buf)
+		if err != nil {
+			select {
+			case <-s.shutdownCh:
+				return
+			default:
+				continue
+			}
+		}
+		resp := s.llmnrResponse(buf[:n])
+		if resp == nil || s.Suspended() {
+			continue
+		}
+		out, err := resp.Pack()
+		if err != nil {
+			log.Printf("[ERR] mdns: Failed to pack LLMNR response: %v", err)
+			continue
+		}
+		// Responses are sent by unicast to the querier, as per section 2.5
+		// of RFC 4795.
+		if err := write(out, from); err != nil {
+			log.Printf("[ERR] mdns: Failed to send LLMNR response to %v: %v", from, err)
+		}
+	}
+}
+
+// llmnrResponse returns the response to an LLMNR query, or nil if the zone
+// has no answer.
+func (s *Server) llmnrResponse(packet []byte) *dns.Msg {
+	var q dns.Msg
+	if err := q.Unpack(packet); err != nil {
+		return nil
+	}
+	if q.Response || q.Opcode != dns.OpcodeQuery || len(q.Question) != 1 || len(q.Answer) != 0 || len(q.Ns) != 0 {
+		return nil
+	}
+	question := q.Question[0]
+	local, ok := llmnrToLocal(question.Name)
+	if !ok {
+		return nil
+	}
+
+	var answers []dns.RR
+	for _, rr := range s.conf().Zone.Records(dns.Question{Name: local, Qtype: question.Qtype, Qclass: dns.ClassINET}) {
+		if !strings.EqualFold(rr.Header().Name, local) {
+			continue
+		}
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Name = question.Name
+		hdr.Class &^= cacheFlushBit
+		hdr.Ttl = llmnrTTL
+		if ptr, ok := rr.(*dns.PTR); ok {
+			ptr.Ptr = llmnrFromLocal(ptr.Ptr)
+		}
+		answers = append(answers, rr)
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(&q)
+	resp.RecursionDesired = false
+	resp.Answer = answers
+	resp.Truncate(dns.MinMsgSize)
+	return resp
+}
+
+// queryLLMNR sends an LLMNR query for a multicast DNS host name, such as
+// "host.local.", or a reverse mapping name, and sends the responses to msgCh,
+// with their names mapped into "local.", until ctx is done.  It does nothing
+// for names that LLMNR cannot resolve.
+func queryLLMNR(ctx context.Context, name string, qtype uint16, iface *net.Interface, msgCh chan<- *received) error {
+	lname := llmnrFromLocal(dns.Fqdn(name))
+	if _, ok := llmnrToLocal(lname); !ok {
+		return nil
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(lname, qtype)
+	q.RecursionDesired = false
+	buf, err := q.Pack()
+	if err != nil {
+		return err
+	}
+
+	var conns []*net.UDPConn
+	if c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero}); err == nil {
+		if iface != nil {
+			ipv4.NewPacketConn(c).SetMulticastInterface(iface)
+		}
+		if _, err := c.WriteToUDP(buf, llmnrAddrIPv4); err == nil {
+			conns = append(conns, c)
+		} else {
+			c.Close()
+		}
+	}
+	if c, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified}); err == nil {
+		if iface != nil {
+			ipv6.NewPacketConn(c).SetMulticastInterface(iface)
+		}
+		if _, err := c.WriteToUDP(buf, llmnrAddrIPv6); err == nil {
+			conns = append(conns, c)
+		} else {
+			c.Close()
+		}
+	}
+
+	for _, c := range conns {
+		go func(c *net.UDPConn) {
+			b := make([]byte, 65536)
+			for {
+				n, from, err := c.ReadFromUDP(b)
+				if err != nil {
+					return
+				}
+				m := new(dns.Msg)
+				if err := m.Unpack(b[:n]); err != nil || !m.Response || m.Id != q.Id {
+					continue
+				}
+				m.Answer = llmnrRecordsToLocal(m.Answer)
+				m.Extra = llmnrRecordsToLocal(m.Extra)
+				select {
+				case msgCh <- &received{msg: m, from: from, at: time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(c)
+	}
+	<-ctx.Done()
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+// llmnrRecordsToLocal maps the names of LLMNR records into "local.".
+func llmnrRecordsToLocal(recs []dns.RR) []dns.RR {
+	for _, rr := range recs {
+		hdr := rr.Header()
+		if local, ok := llmnrToLocal(hdr.Name); ok {
+			hdr.Name = local
+		}
+		if ptr, ok := rr.(*dns.PTR); ok {
+			if local, ok := llmnrToLocal(ptr.Ptr); ok && dns.CountLabel(ptr.Ptr) == 1 {
+				ptr.Ptr = local
+			}
+		}
+	}
+	return recs
+}
@@ -0,0 +1,72 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestLLMNR_Names(t *testing.T) {
+	for _, test := range []struct {
+		name, local string
+		ok          bool
+	}{
+		{"host", "host.local.", true},
+		{"HOST.", "HOST.local.", true},
+		{"42.0.168.192.in-addr.arpa.", "42.0.168.192.in-addr.arpa.", true},
+		{"host.example.com.", "", false},
+	} {
+		local, ok := llmnrToLocal(test.name)
+		if local != test.local || ok != test.ok {
+			t.Errorf("llmnrToLocal(%q) = %q, %v, want %q, %v", test.name, local, ok, test.local, test.ok)
+		}
+	}
+	if got := llmnrFromLocal("host.local."); got != "host." {
+		t.Errorf("llmnrFromLocal = %q", got)
+	}
+	if got := llmnrFromLocal("x._http._tcp.local."); got != "x._http._tcp.local." {
+		t.Errorf("llmnrFromLocal = %q", got)
+	}
+}
+
+func TestLLMNR_Query(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_llmnrtest._tcp", "local.", "llmnrhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s, LLMNR: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	msgCh := make(chan *received, 8)
+	go queryLLMNR(ctx, "llmnrhost.local.", dns.TypeA, nil, msgCh)
+
+	select {
+	case r := <-msgCh:
+		if len(r.msg.Answer) != 1 {
+			t.Fatalf("bad response: %v", r.msg)
+		}
+		a, ok := r.msg.Answer[0].(*dns.A)
+		if !ok || a.Hdr.Name != "llmnrhost.local." || a.Hdr.Ttl != llmnrTTL || !a.A.Equal(net.IPv4(192, 168, 0, 42)) {
+			t.Errorf("bad answer: %v", r.msg.Answer[0])
+		}
+	case <-ctx.Done():
+		t.Fatalf("no LLMNR response")
+	}
+
+	// Names that are not single labels are not answered.
+	q := new(dns.Msg)
+	q.SetQuestion("llmnrhost.local.", dns.TypeA)
+	packet, _ := q.Pack()
+	if resp := serv.llmnrResponse(packet); resp != nil {
+		t.Errorf("answered a multi-label name: %v", resp)
+	}
+}
@@ -0,0 +1,288 @@
+// Package lobby discovers sessions of a game or tool on the local network,
+// such as the open matches of a LAN multiplayer game, without the
+// boilerplate of publishing, browsing and decoding TXT records by hand.
+//
+// A session carries a typed payload, a struct encoded in its TXT record by
+// Marshal.  Each copy of the application opens a lobby for its service type,
+// hosts sessions in it, and is told when the sessions of others appear,
+// change and end:
+//
+//     type Match struct {
+//         Map     string
+//         Players int
+//         Max     int `txt:"max"`
+//     }
+//
+//     l, err := lobby.New(&lobby.Config{
+//         Service: "_mygame._udp",
+//         OnJoin: func(s lobby.Session) {
+//             var m Match
+//             if err := s.Decode(&m); err == nil {
+//                 log.Printf("%s on %s at %v", s.Name, m.Map, s.Addr())
+//             }
+//         },
+//     })
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer l.Close()
+//     err = l.Host("Alice's match", 7777, &Match{Map: "dust", Players: 1, Max: 8})
+//     ...
+//     err = l.Update("Alice's match", &Match{Map: "dust", Players: 2, Max: 8})
+//
+// Sessions are published with a short TTL, so that the sessions of a host
+// that crashes disappear within seconds rather than minutes, and updates and
+// ends are announced at once.
+package lobby
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// DefaultTTL is the TTL of sessions if Config.TTL is not set.
+const DefaultTTL = 10 * time.Second
+
+// Config is used to configure a Lobby.
+type Config struct {
+	// Service is the service type of the application's sessions, such as
+	// "_mygame._udp".  Required.
+	Service string
+
+	// TTL is the TTL of the records of hosted sessions, default DefaultTTL.
+	// It bounds how long the sessions of a host that stops without
+	// withdrawing them are still seen.
+	TTL time.Duration
+
+	// Interface, if set, is the only interface sessions are hosted and
+	// browsed on.
+	Interface *net.Interface
+
+	// OnJoin is called when a session is found, OnUpdate when its payload
+	// or address changes, and OnLeave when it ends.  They are called one at
+	// a time, from a single goroutine, and may be nil.  Sessions hosted by
+	// this lobby are not reported.
+	OnJoin   func(Session)
+	OnUpdate func(Session)
+	OnLeave  func(Session)
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Session is a session hosted by another copy of the application.
+type Session struct {
+	// Name is the session's name, its instance name.
+	Name string
+
+	// Entry is the service instance the session was found as.
+	Entry *mdns.ServiceEntry
+}
+
+// Decode decodes the session's payload into the struct v points to.
+func (s Session) Decode(v interface{}) error {
+	return Unmarshal(s.Entry.InfoFields, v)
+}
+
+// Addr returns the address of the session's host, preferring IPv4.
+func (s Session) Addr() string {
+	port := strconv.Itoa(s.Entry.Port)
+	if s.Entry.AddrV4 != nil {
+		return net.JoinHostPort(s.Entry.AddrV4.String(), port)
+	}
+	if s.Entry.AddrV6 != nil {
+		return net.JoinHostPort((&net.IPAddr{IP: s.Entry.AddrV6, Zone: s.Entry.Zone}).String(), port)
+	}
+	return net.JoinHostPort(strings.TrimSuffix(s.Entry.Host, "."), port)
+}
+
+// Lobby hosts sessions and discovers those of others, from the time it is
+// created until Close is called.
+type Lobby struct {
+	config  *Config
+	set     *mdns.ServiceSet
+	server  *mdns.Server
+	browser *mdns.Browser
+	done    chan struct{}
+
+	lock     sync.Mutex
+	sessions map[string]Session // By instance name
+}
+
+// New opens a lobby and starts looking for sessions.
+func New(config *Config) (*Lobby, error) {
+	if config.Service == "" {
+		return nil, fmt.Errorf("lobby: a service is required")
+	}
+	set, err := mdns.NewServiceSet()
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: set, Iface: config.Interface})
+	if err != nil {
+		return nil, err
+	}
+	var opts []mdns.QueryOption
+	if config.Interface != nil {
+		opts = append(opts, mdns.WithInterface(config.Interface))
+	}
+	if config.Logger != nil {
+		opts = append(opts, mdns.WithLogger(config.Logger))
+	}
+	browser, err := mdns.NewBrowser(context.Background(), config.Service, opts...)
+	if err != nil {
+		server.Shutdown()
+		return nil, err
+	}
+
+	l := &Lobby{
+		config:   config,
+		set:      set,
+		server:   server,
+		browser:  browser,
+		done:     make(chan struct{}),
+		sessions: make(map[string]Session),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Close stops discovering sessions, and ends the sessions hosted by the
+// lobby so that others see them end at once.
+func (l *Lobby) Close() {
+	l.browser.Close()
+	<-l.done
+	if err := l.server.Shutdown(); err != nil {
+		l.logf("[ERR] lobby: Failed to withdraw sessions: %v", err)
+	}
+}
+
+func (l *Lobby) logf(format string, v ...interface{}) {
+	if l.config.Logger != nil {
+		l.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// Host publishes a session with the given name, at most 63 bytes long,
+// reached on port of this host, with payload as its TXT record.
+func (l *Lobby) Host(name string, port int, payload interface{}) error {
+	txt, err := Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if len(name) > 63 {
+		return fmt.Errorf("lobby: session name %q is longer than 63 bytes", name)
+	}
+	svc, err := mdns.NewMDNSService(name, l.config.Service, "", "", port, nil, txt)
+	if err != nil {
+		return err
+	}
+	svc.TTL = uint32(l.ttl() / time.Second)
+	if err := l.set.Add(svc); err != nil {
+		return err
+	}
+	l.server.Announce(svc)
+	return nil
+}
+
+func (l *Lobby) ttl() time.Duration {
+	if l.config.TTL < time.Second {
+		return DefaultTTL
+	}
+	return l.config.TTL
+}
+
+// Update replaces the payload of the hosted session with the given name.
+func (l *Lobby) Update(name string, payload interface{}) error {
+	txt, err := Marshal(payload)
+	if err != nil {
+		return err
+	}
+	old := l.set.Get(l.instanceName(name))
+	if old == nil {
+		return fmt.Errorf("lobby: no session %q", name)
+	}
+	// Services are replaced rather than modified, as the server may be
+	// reading the old one.
+	svc := *old
+	svc.TXT = txt
+	if _, err := l.set.Replace(&svc); err != nil {
+		return err
+	}
+	l.server.Announce(&svc)
+	return nil
+}
+
+// End withdraws the hosted session with the given name.
+func (l *Lobby) End(name string) error {
+	svc := l.set.Remove(l.instanceName(name))
+	if svc == nil {
+		return fmt.Errorf("lobby: no session %q", name)
+	}
+	return l.server.Withdraw(svc)
+}
+
+// instanceName returns the instance name of the hosted session name.
+func (l *Lobby) instanceName(name string) string {
+	return name + "." + strings.Trim(l.config.Service, ".") + ".local."
+}
+
+// Sessions returns the sessions of others currently known, sorted by name.
+func (l *Lobby) Sessions() []Session {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sessions := make([]Session, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Name < sessions[j].Name })
+	return sessions
+}
+
+// run turns the browser's events into callbacks until it is closed.
+func (l *Lobby) run() {
+	defer close(l.done)
+	for ev := range l.browser.Events() {
+		s := Session{Name: ev.Entry.Instance(), Entry: ev.Entry}
+		if l.set.Get(l.instanceName(s.Name)) != nil {
+			continue
+		}
+
+		l.lock.Lock()
+		_, known := l.sessions[ev.Entry.Name]
+		if ev.Type == mdns.ServiceRemoved {
+			delete(l.sessions, ev.Entry.Name)
+		} else {
+			l.sessions[ev.Entry.Name] = s
+		}
+		l.lock.Unlock()
+
+		switch {
+		case ev.Type == mdns.ServiceRemoved:
+			if known {
+				call(l.config.OnLeave, s)
+			}
+		case !known:
+			call(l.config.OnJoin, s)
+		default:
+			call(l.config.OnUpdate, s)
+		}
+	}
+}
+
+func call(f func(Session), s Session) {
+	if f != nil {
+		f(s)
+	}
+}
@@ -0,0 +1,122 @@
+package lobby
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type match struct {
+	Map     string
+	Players int
+	Max     uint8 `txt:"max"`
+	Ranked  bool  `txt:",omitempty"`
+	Tick    time.Duration
+	Secret  string `txt:"-"`
+	private string
+}
+
+func TestMarshal(t *testing.T) {
+	m := &match{Map: "dust", Players: 2, Max: 8, Tick: 50 * time.Millisecond, Secret: "x", private: "y"}
+	txt, err := Marshal(m)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if want := []string{"map=dust", "players=2", "max=8", "tick=50ms"}; !reflect.DeepEqual(txt, want) {
+		t.Fatalf("got %v, want %v", txt, want)
+	}
+
+	var got match
+	if err := Unmarshal(append(txt, "unknown=1", "MAP=other"), &got); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m.Secret, m.private = "", ""
+	if !reflect.DeepEqual(&got, m) {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+
+	got = match{Players: 3}
+	if err := Unmarshal([]string{"map=x", "players=many"}, &got); err == nil {
+		t.Errorf("expected an error for a bad number")
+	}
+	if got.Players != 3 {
+		t.Errorf("bad value changed the field to %d", got.Players)
+	}
+	if err := Unmarshal([]string{"max=300"}, &got); err == nil {
+		t.Errorf("expected an error for an overflow")
+	}
+	if _, err := Marshal(struct{ Tags []string }{}); err == nil {
+		t.Errorf("expected an error for a slice")
+	}
+}
+
+func TestLobby(t *testing.T) {
+	joined := make(chan Session, 4)
+	updated := make(chan Session, 4)
+	left := make(chan Session, 4)
+	a, err := New(&Config{
+		Service:  "_lobbytest._udp",
+		OnJoin:   func(s Session) { joined <- s },
+		OnUpdate: func(s Session) { updated <- s },
+		OnLeave:  func(s Session) { left <- s },
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer a.Close()
+	b, err := New(&Config{Service: "_lobbytest._udp"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+	if err := a.Host("own match", 7776, &match{Map: "own"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.Host("Bob's match", 7777, &match{Map: "dust", Players: 1}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// a sees b's session, but not its own.
+	var m match
+	select {
+	case s := <-joined:
+		if s.Name != "Bob's match" || s.Entry.Port != 7777 {
+			t.Fatalf("bad session: %+v", s)
+		}
+		if err := s.Decode(&m); err != nil || m.Map != "dust" || m.Players != 1 {
+			t.Fatalf("bad payload %+v: %v", m, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session not found")
+	}
+
+	if err := b.Update("Bob's match", &match{Map: "dust", Players: 2}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case s := <-updated:
+		if err := s.Decode(&m); err != nil || m.Players != 2 {
+			t.Fatalf("bad payload %+v: %v", m, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("update not seen")
+	}
+
+	if err := b.End("Bob's match"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case s := <-left:
+		if s.Name != "Bob's match" {
+			t.Errorf("bad session: %+v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("end not seen")
+	}
+	if err := b.End("Bob's match"); err == nil {
+		t.Errorf("expected an error for an unknown session")
+	}
+	for _, s := range a.Sessions() {
+		t.Errorf("unexpected session %+v", s)
+	}
+}
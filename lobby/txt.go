@@ -0,0 +1,173 @@
+package lobby
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is an exported struct field encoded in a TXT record.
+type field struct {
+	key       string
+	index     int
+	omitEmpty bool
+}
+
+// fields returns the encoded fields of struct type t.  A field's key is the
+// name given in its "txt" tag, or its lower case name; a tag of "-" skips the
+// field, and an ",omitempty" option omits the field when it has its zero
+// value.
+func fields(t reflect.Type) ([]field, error) {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("txt")
+		if tag == "-" {
+			continue
+		}
+		f := field{key: strings.ToLower(sf.Name), index: i}
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			f.key = strings.ToLower(parts[0])
+		}
+		for _, opt := range parts[1:] {
+			f.omitEmpty = f.omitEmpty || opt == "omitempty"
+		}
+		if strings.Contains(f.key, "=") {
+			return nil, fmt.Errorf("lobby: key %q of field %s contains '='", f.key, sf.Name)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// structValue returns the struct v holds or points to.
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("lobby: payload %T is not a struct", v)
+	}
+	return rv, nil
+}
+
+// Marshal encodes the exported fields of the struct v, or of the struct it
+// points to, as "key=value" TXT strings.  Fields may be strings, booleans,
+// numbers or time.Durations.
+func Marshal(v interface{}) ([]string, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := fields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	var txt []string
+	for _, f := range fs {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.Interface() == reflect.Zero(fv.Type()).Interface() {
+			continue
+		}
+		var value string
+		switch kind := fv.Kind(); {
+		case fv.Type() == durationType:
+			value = time.Duration(fv.Int()).String()
+		case kind == reflect.String:
+			value = fv.String()
+		case kind == reflect.Bool:
+			value = strconv.FormatBool(fv.Bool())
+		case kind >= reflect.Int && kind <= reflect.Int64:
+			value = strconv.FormatInt(fv.Int(), 10)
+		case kind >= reflect.Uint && kind <= reflect.Uint64:
+			value = strconv.FormatUint(fv.Uint(), 10)
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			value = strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits())
+		default:
+			return nil, fmt.Errorf("lobby: field %s of type %v cannot be encoded", rv.Type().Field(f.index).Name, fv.Type())
+		}
+		s := f.key + "=" + value
+		if len(s) > 255 {
+			return nil, fmt.Errorf("lobby: TXT string %q is longer than 255 bytes", s)
+		}
+		txt = append(txt, s)
+	}
+	return txt, nil
+}
+
+// Unmarshal decodes TXT strings into the struct v points to, as encoded by
+// Marshal.  Keys are matched without regard to case, keys of no field are
+// ignored, and fields without a key are left unchanged.
+func Unmarshal(txt []string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("lobby: Unmarshal needs a pointer to a struct, not %T", v)
+	}
+	rv = rv.Elem()
+	fs, err := fields(rv.Type())
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	for _, s := range txt {
+		key, value := s, ""
+		if i := strings.Index(s, "="); i >= 0 {
+			key, value = s[:i], s[i+1:]
+		}
+		// Only the first occurrence of a key counts (RFC 6763 section 6.4).
+		key = strings.ToLower(key)
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+	for _, f := range fs {
+		value, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		// Values are decoded into a temporary, so that a field is not
+		// changed when its value is bad.
+		fv := reflect.New(rv.Field(f.index).Type()).Elem()
+		var err error
+		switch kind := fv.Kind(); {
+		case fv.Type() == durationType:
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			fv.SetInt(int64(d))
+		case kind == reflect.String:
+			fv.SetString(value)
+		case kind == reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			fv.SetBool(b)
+		case kind >= reflect.Int && kind <= reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, fv.Type().Bits())
+			fv.SetInt(n)
+		case kind >= reflect.Uint && kind <= reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(value, 10, fv.Type().Bits())
+			fv.SetUint(n)
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			var x float64
+			x, err = strconv.ParseFloat(value, fv.Type().Bits())
+			fv.SetFloat(x)
+		default:
+			err = fmt.Errorf("type %v cannot be decoded", fv.Type())
+		}
+		if err != nil {
+			return fmt.Errorf("lobby: bad value %q for %s: %v", value, f.key, err)
+		}
+		rv.Field(f.index).Set(fv)
+	}
+	return nil
+}
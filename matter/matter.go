@@ -0,0 +1,436 @@
+// Package matter encodes and decodes the DNS-SD advertisements of Matter
+// nodes, as described in section 4.3 of the Matter Core Specification:
+// commissionable nodes, advertised as "_matterc._udp" instances while they
+// can be commissioned, and operational nodes, advertised as "_matter._tcp"
+// instances named after their fabric and node ID.
+//
+// A device advertises itself for commissioning with
+//
+//     c := &matter.Commissionable{
+//         Discriminator:     3840,
+//         VendorID:          0xFFF1,
+//         ProductID:         0x8000,
+//         CommissioningMode: matter.BasicCommissioning,
+//         Port:              5540,
+//     }
+//     svc, err := c.Service("", nil)
+//
+// and a controller finds the devices matching the discriminator of a setup
+// code, which are also browsable by the subtype of that discriminator, with
+//
+//     nodes, err := matter.DiscoverCommissionable(ctx, matter.LongDiscriminatorSubtype(3840))
+package matter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// Service types of Matter nodes.
+const (
+	CommissionableService = "_matterc._udp"
+	OperationalService    = "_matter._tcp"
+)
+
+// CommissioningModeSubtype is the subtype of the commissionable nodes that
+// are in commissioning mode.
+const CommissioningModeSubtype = "_CM"
+
+// LongDiscriminatorSubtype returns the subtype of the commissionable nodes
+// with a 12-bit discriminator.
+func LongDiscriminatorSubtype(discriminator uint16) string {
+	return "_L" + strconv.Itoa(int(discriminator&0xFFF))
+}
+
+// ShortDiscriminatorSubtype returns the subtype of the commissionable nodes
+// whose discriminator has the given upper 4 bits, as found in a manual
+// pairing code.
+func ShortDiscriminatorSubtype(short uint8) string {
+	return "_S" + strconv.Itoa(int(short&0xF))
+}
+
+// VendorSubtype returns the subtype of the commissionable nodes of a vendor.
+func VendorSubtype(vendorID uint16) string {
+	return "_V" + strconv.Itoa(int(vendorID))
+}
+
+// DeviceTypeSubtype returns the subtype of the commissionable nodes of a
+// device type.
+func DeviceTypeSubtype(deviceType uint32) string {
+	return "_T" + strconv.Itoa(int(deviceType))
+}
+
+// CompressedFabricSubtype returns the subtype of the operational nodes of a
+// fabric.
+func CompressedFabricSubtype(compressedFabricID uint64) string {
+	return fmt.Sprintf("_I%016X", compressedFabricID)
+}
+
+// OperationalInstance returns the instance name of an operational node.
+func OperationalInstance(compressedFabricID, nodeID uint64) string {
+	return fmt.Sprintf("%016X-%016X", compressedFabricID, nodeID)
+}
+
+// CommissioningMode is the commissioning mode of a commissionable node, in the
+// "CM" TXT key.
+type CommissioningMode int
+
+// Commissioning modes.
+const (
+	NotCommissioning      CommissioningMode = 0
+	BasicCommissioning    CommissioningMode = 1
+	EnhancedCommissioning CommissioningMode = 2
+)
+
+// SessionParams are the session parameters a node may advertise, in the
+// "SII", "SAI", "SAT" and "T" TXT keys.  Zero durations are not advertised.
+type SessionParams struct {
+	IdleInterval    time.Duration // Retransmission interval when idle
+	ActiveInterval  time.Duration // Retransmission interval when active
+	ActiveThreshold time.Duration // How long a node stays active
+	TCP             bool          // Whether the node supports TCP
+}
+
+func (p *SessionParams) txt() []string {
+	var txt []string
+	for _, kv := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"SII", p.IdleInterval},
+		{"SAI", p.ActiveInterval},
+		{"SAT", p.ActiveThreshold},
+	} {
+		if kv.d > 0 {
+			txt = append(txt, kv.key+"="+strconv.FormatInt(int64(kv.d/time.Millisecond), 10))
+		}
+	}
+	if p.TCP {
+		txt = append(txt, "T=1")
+	}
+	return txt
+}
+
+func (p *SessionParams) parse(txt map[string]string) error {
+	for key, d := range map[string]*time.Duration{
+		"sii": &p.IdleInterval,
+		"sai": &p.ActiveInterval,
+		"sat": &p.ActiveThreshold,
+	} {
+		if v, ok := txt[key]; ok {
+			ms, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return fmt.Errorf("matter: bad %s %q", strings.ToUpper(key), v)
+			}
+			*d = time.Duration(ms) * time.Millisecond
+		}
+	}
+	p.TCP = txt["t"] == "1"
+	return nil
+}
+
+// Commissionable is a node that can be commissioned.
+type Commissionable struct {
+	// Instance is the instance name, 16 hex digits chosen at random.  A
+	// random one is used when advertising a node without one.
+	Instance string
+
+	Discriminator      uint16            // The 12-bit discriminator, "D"
+	VendorID           uint16            // "VP", with ProductID
+	ProductID          uint16            // Zero if not advertised
+	DeviceType         uint32            // "DT", zero if not advertised
+	DeviceName         string            // "DN"
+	CommissioningMode  CommissioningMode // "CM"
+	RotatingID         string            // "RI", in hex
+	PairingHint        uint16            // "PH", zero if not advertised
+	PairingInstruction string            // "PI"
+	SessionParams
+
+	// Port is the node's UDP port, usually 5540.
+	Port int
+
+	// IP is the node's IPv4 address if known, else its IPv6 address, with the
+	// zone in Zone if it is link-local.  They are set for discovered nodes
+	// only.
+	IP   net.IP
+	Zone string
+}
+
+// Subtypes returns the subtypes the node is browsable as.
+func (c *Commissionable) Subtypes() []string {
+	subs := []string{
+		LongDiscriminatorSubtype(c.Discriminator),
+		ShortDiscriminatorSubtype(uint8(c.Discriminator >> 8)),
+	}
+	if c.VendorID != 0 {
+		subs = append(subs, VendorSubtype(c.VendorID))
+	}
+	if c.DeviceType != 0 {
+		subs = append(subs, DeviceTypeSubtype(c.DeviceType))
+	}
+	if c.CommissioningMode != NotCommissioning {
+		subs = append(subs, CommissioningModeSubtype)
+	}
+	return subs
+}
+
+// TXT returns the node's TXT record.
+func (c *Commissionable) TXT() []string {
+	txt := []string{"D=" + strconv.Itoa(int(c.Discriminator&0xFFF))}
+	if c.VendorID != 0 {
+		vp := strconv.Itoa(int(c.VendorID))
+		if c.ProductID != 0 {
+			vp += "+" + strconv.Itoa(int(c.ProductID))
+		}
+		txt = append(txt, "VP="+vp)
+	}
+	txt = append(txt, "CM="+strconv.Itoa(int(c.CommissioningMode)))
+	if c.DeviceType != 0 {
+		txt = append(txt, "DT="+strconv.Itoa(int(c.DeviceType)))
+	}
+	if c.DeviceName != "" {
+		txt = append(txt, "DN="+c.DeviceName)
+	}
+	if c.RotatingID != "" {
+		txt = append(txt, "RI="+c.RotatingID)
+	}
+	if c.PairingHint != 0 {
+		txt = append(txt, "PH="+strconv.Itoa(int(c.PairingHint)))
+	}
+	if c.PairingInstruction != "" {
+		txt = append(txt, "PI="+c.PairingInstruction)
+	}
+	return append(txt, c.SessionParams.txt()...)
+}
+
+// Service returns the service advertising the node, with the given host name
+// and addresses, which default as for mdns.NewMDNSService.  If the node has
+// no instance name, a random one is chosen and stored in Instance.
+func (c *Commissionable) Service(host string, ips []net.IP) (*mdns.MDNSService, error) {
+	if c.Instance == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c.Instance = strings.ToUpper(hex.EncodeToString(b))
+	}
+	svc, err := mdns.NewMDNSService(c.Instance, CommissionableService, "", host, c.Port, ips, c.TXT())
+	if err != nil {
+		return nil, err
+	}
+	svc.Subtypes = c.Subtypes()
+	return svc, nil
+}
+
+// ParseCommissionable returns the node a "_matterc._udp" instance advertises.
+// It fails if the instance has no valid discriminator.
+func ParseCommissionable(entry *mdns.ServiceEntry) (*Commissionable, error) {
+	txt := entry.TXTMap()
+	d, err := strconv.ParseUint(txt["d"], 10, 12)
+	if err != nil {
+		return nil, fmt.Errorf("matter: %s has no valid discriminator", entry.Name)
+	}
+	c := &Commissionable{
+		Instance:           entry.Instance(),
+		Discriminator:      uint16(d),
+		DeviceName:         txt["dn"],
+		RotatingID:         txt["ri"],
+		PairingInstruction: txt["pi"],
+		Port:               entry.Port,
+	}
+	if vp, ok := txt["vp"]; ok {
+		parts := strings.SplitN(vp, "+", 2)
+		vid, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("matter: %s has bad VP %q", entry.Name, vp)
+		}
+		c.VendorID = uint16(vid)
+		if len(parts) == 2 {
+			pid, err := strconv.ParseUint(parts[1], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("matter: %s has bad VP %q", entry.Name, vp)
+			}
+			c.ProductID = uint16(pid)
+		}
+	}
+	cm, err := parseUint(txt, "cm", 8)
+	if err != nil {
+		return nil, fmt.Errorf("matter: %s has %v", entry.Name, err)
+	}
+	dt, err := parseUint(txt, "dt", 32)
+	if err != nil {
+		return nil, fmt.Errorf("matter: %s has %v", entry.Name, err)
+	}
+	ph, err := parseUint(txt, "ph", 16)
+	if err != nil {
+		return nil, fmt.Errorf("matter: %s has %v", entry.Name, err)
+	}
+	c.CommissioningMode = CommissioningMode(cm)
+	c.DeviceType = uint32(dt)
+	c.PairingHint = uint16(ph)
+	if err := c.SessionParams.parse(txt); err != nil {
+		return nil, err
+	}
+	c.IP, c.Zone = address(entry)
+	return c, nil
+}
+
+// parseUint parses the decimal value of a TXT key, which is zero if the key
+// is absent.
+func parseUint(txt map[string]string, key string, bits int) (uint64, error) {
+	v, ok := txt[key]
+	if !ok {
+		return 0, nil
+	}
+	u, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", strings.ToUpper(key), v)
+	}
+	return u, nil
+}
+
+// Matches returns true if the node is browsable as the given subtype.
+func (c *Commissionable) Matches(subtype string) bool {
+	for _, sub := range c.Subtypes() {
+		if strings.EqualFold(sub, subtype) {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverCommissionable looks for commissionable nodes, only those browsable
+// as subtype if it is not empty, and returns them.  It returns when ctx is
+// done or the timeout given in opts, one second by default, elapses.
+// Instances that are not valid nodes are skipped.
+func DiscoverCommissionable(ctx context.Context, subtype string, opts ...mdns.QueryOption) ([]*Commissionable, error) {
+	service := CommissionableService
+	if subtype != "" {
+		service = subtype + "._sub." + service
+	}
+	entries, err := lookup(ctx, service, opts)
+	var nodes []*Commissionable
+	for _, entry := range entries {
+		c, err := ParseCommissionable(entry)
+		// Responses may also describe nodes that are not of the subtype.
+		if err == nil && (subtype == "" || c.Matches(subtype)) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes, err
+}
+
+// Operational is a commissioned node, on one of its fabrics.
+type Operational struct {
+	CompressedFabricID uint64
+	NodeID             uint64
+	SessionParams
+
+	// Port is the node's UDP port, usually 5540.
+	Port int
+
+	// IP and Zone are the node's address, as for Commissionable.
+	IP   net.IP
+	Zone string
+}
+
+// Service returns the service advertising the node, with the given host name
+// and addresses, which default as for mdns.NewMDNSService.
+func (o *Operational) Service(host string, ips []net.IP) (*mdns.MDNSService, error) {
+	instance := OperationalInstance(o.CompressedFabricID, o.NodeID)
+	svc, err := mdns.NewMDNSService(instance, OperationalService, "", host, o.Port, ips, o.SessionParams.txt())
+	if err != nil {
+		return nil, err
+	}
+	svc.Subtypes = []string{CompressedFabricSubtype(o.CompressedFabricID)}
+	return svc, nil
+}
+
+// ParseOperational returns the node a "_matter._tcp" instance advertises.  It
+// fails if the instance name is not of the form "<fabric>-<node>".
+func ParseOperational(entry *mdns.ServiceEntry) (*Operational, error) {
+	instance := entry.Instance()
+	parts := strings.Split(instance, "-")
+	if len(parts) != 2 || len(parts[0]) != 16 || len(parts[1]) != 16 {
+		return nil, fmt.Errorf("matter: bad operational instance name %q", instance)
+	}
+	fabric, err := strconv.ParseUint(parts[0], 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("matter: bad operational instance name %q", instance)
+	}
+	node, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("matter: bad operational instance name %q", instance)
+	}
+	o := &Operational{CompressedFabricID: fabric, NodeID: node, Port: entry.Port}
+	if err := o.SessionParams.parse(entry.TXTMap()); err != nil {
+		return nil, err
+	}
+	o.IP, o.Zone = address(entry)
+	return o, nil
+}
+
+// ResolveOperational finds the address of a node on a fabric.
+func ResolveOperational(ctx context.Context, compressedFabricID, nodeID uint64, opts ...mdns.QueryOption) (*Operational, error) {
+	name := OperationalInstance(compressedFabricID, nodeID) + "." + OperationalService + ".local."
+	entry, err := mdns.Resolve(ctx, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOperational(entry)
+}
+
+// DiscoverOperational looks for the operational nodes of a fabric, and
+// returns them.  It returns when ctx is done or the timeout given in opts, one
+// second by default, elapses.
+func DiscoverOperational(ctx context.Context, compressedFabricID uint64, opts ...mdns.QueryOption) ([]*Operational, error) {
+	service := CompressedFabricSubtype(compressedFabricID) + "._sub." + OperationalService
+	entries, err := lookup(ctx, service, opts)
+	var nodes []*Operational
+	for _, entry := range entries {
+		o, err := ParseOperational(entry)
+		if err == nil && o.CompressedFabricID == compressedFabricID {
+			nodes = append(nodes, o)
+		}
+	}
+	return nodes, err
+}
+
+// lookup returns the instances of service found, in the order they were
+// first found, each as last updated.
+func lookup(ctx context.Context, service string, opts []mdns.QueryOption) ([]*mdns.ServiceEntry, error) {
+	ch := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan error, 1)
+	opts = append(append([]mdns.QueryOption(nil), opts...), mdns.WithEntriesChannel(ch))
+	go func() {
+		done <- mdns.Lookup(ctx, service, opts...)
+		close(ch)
+	}()
+	var entries []*mdns.ServiceEntry
+	index := make(map[string]int)
+	for entry := range ch {
+		if i, ok := index[entry.Name]; ok {
+			entries[i] = entry
+			continue
+		}
+		index[entry.Name] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries, <-done
+}
+
+// address returns the address of an instance, preferring IPv4.
+func address(entry *mdns.ServiceEntry) (net.IP, string) {
+	if entry.AddrV4 != nil {
+		return entry.AddrV4, ""
+	}
+	return entry.AddrV6, entry.Zone
+}
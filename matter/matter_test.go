@@ -0,0 +1,111 @@
+package matter
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+func testCommissionable() *Commissionable {
+	return &Commissionable{
+		Instance:          "0123456789ABCDEF",
+		Discriminator:     3840,
+		VendorID:          0xFFF1,
+		ProductID:         0x8000,
+		DeviceType:        257,
+		DeviceName:        "Kitchen Light",
+		CommissioningMode: BasicCommissioning,
+		PairingHint:       33,
+		SessionParams:     SessionParams{IdleInterval: 5 * time.Second, ActiveInterval: 300 * time.Millisecond, TCP: true},
+		Port:              5540,
+	}
+}
+
+func TestCommissionable_Encode(t *testing.T) {
+	c := testCommissionable()
+	if got, want := c.Subtypes(), []string{"_L3840", "_S15", "_V65521", "_T257", "_CM"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bad subtypes: %v", got)
+	}
+	want := []string{"D=3840", "VP=65521+32768", "CM=1", "DT=257", "DN=Kitchen Light", "PH=33", "SII=5000", "SAI=300", "T=1"}
+	if got := c.TXT(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bad TXT: %v", got)
+	}
+}
+
+func TestParseCommissionable(t *testing.T) {
+	c := testCommissionable()
+	got, err := ParseCommissionable(&mdns.ServiceEntry{
+		Name:       "0123456789ABCDEF._matterc._udp.local.",
+		AddrV4:     net.IPv4(192, 168, 0, 42),
+		Port:       5540,
+		InfoFields: c.TXT(),
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c.IP = net.IPv4(192, 168, 0, 42)
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+
+	for _, txt := range [][]string{{"VP=1"}, {"D=4096"}, {"D=1", "VP=x"}, {"D=1", "CM=x"}} {
+		if _, err := ParseCommissionable(&mdns.ServiceEntry{Name: "x._matterc._udp.local.", InfoFields: txt}); err == nil {
+			t.Errorf("expected an error for %v", txt)
+		}
+	}
+}
+
+func TestParseOperational(t *testing.T) {
+	o := &Operational{CompressedFabricID: 0x87E1B004E235A130, NodeID: 0x8FC7772401CD0696, Port: 5540}
+	svc, err := o.Service("node.local.", []net.IP{net.IPv4(192, 168, 0, 42)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if svc.Instance != "87E1B004E235A130-8FC7772401CD0696" || !reflect.DeepEqual(svc.Subtypes, []string{"_I87E1B004E235A130"}) {
+		t.Fatalf("bad service: %+v", svc)
+	}
+	got, err := ParseOperational(&mdns.ServiceEntry{Name: svc.InstanceName(), Port: 5540})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got.CompressedFabricID != o.CompressedFabricID || got.NodeID != o.NodeID {
+		t.Errorf("bad node: %+v", got)
+	}
+	if _, err := ParseOperational(&mdns.ServiceEntry{Name: "node._matter._tcp.local."}); err == nil {
+		t.Errorf("expected an error for a bad instance name")
+	}
+}
+
+func TestDiscoverCommissionable(t *testing.T) {
+	c := testCommissionable()
+	svc, err := c.Service("light.local.", []net.IP{net.IPv4(192, 168, 0, 42)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: svc})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	nodes, err := DiscoverCommissionable(context.Background(), LongDiscriminatorSubtype(3840), mdns.WithTimeout(500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Instance != c.Instance || nodes[0].VendorID != 0xFFF1 {
+		t.Fatalf("bad nodes: %+v", nodes)
+	}
+
+	// Nodes with another discriminator are not reported.
+	nodes, err = DiscoverCommissionable(context.Background(), LongDiscriminatorSubtype(1234), mdns.WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("bad nodes: %+v", nodes)
+	}
+}
@@ -0,0 +1,151 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+)
+
+// MulticastLock is held while servers or clients of this package have
+// sockets joined to the mDNS groups.  On Android, the Wi-Fi driver drops
+// multicast packets unless the app holds a WifiManager.MulticastLock, so an
+// app built with gomobile passes SetMulticastLock an implementation, written
+// in Java or Kotlin, that acquires and releases one.
+type MulticastLock interface {
+	Acquire()
+	Release()
+}
+
+// NetworkBinder binds sockets to a network.  On Android, an app whose
+// default network is cellular or a VPN passes SetNetworkBinder an
+// implementation that gives the socket to Network.bindSocket, for the Wi-Fi
+// network's Network, so that the mDNS traffic uses it.
+type NetworkBinder interface {
+	// BindSocket binds the socket with the file descriptor fd, which must
+	// not be closed.
+	BindSocket(fd int) error
+}
+
+// mobile holds the hooks set for platforms, such as Android, where the
+// package cannot set up multicast by itself.
+var mobile struct {
+	lock   sync.Mutex
+	mlock  MulticastLock
+	held   int // Number of servers and clients holding mlock
+	binder NetworkBinder
+	ifaces []net.Interface // Set if given with AddInterface
+}
+
+// SetMulticastLock sets the lock to hold while servers or clients have
+// sockets open.  It should be called before any are created; a nil lock
+// removes it.
+func SetMulticastLock(l MulticastLock) {
+	mobile.lock.Lock()
+	defer mobile.lock.Unlock()
+	if mobile.mlock != nil && mobile.held > 0 {
+		mobile.mlock.Release()
+	}
+	mobile.mlock = l
+	if l != nil && mobile.held > 0 {
+		l.Acquire()
+	}
+}
+
+// SetNetworkBinder sets the binder that the sockets of servers and clients
+// created afterwards are bound to a network with.  A nil binder removes it.
+func SetNetworkBinder(b NetworkBinder) {
+	mobile.lock.Lock()
+	mobile.binder = b
+	mobile.lock.Unlock()
+}
+
+// AddInterface adds an interface, by name and index, to join the mDNS
+// groups on when none are configured, in place of those the system lists.
+// Apps on Android 11 and later cannot list the interfaces, so they add the
+// Wi-Fi interface, as given by LinkProperties.getInterfaceName and
+// NetworkInterface.getIndex.
+func AddInterface(name string, index int) {
+	mobile.lock.Lock()
+	mobile.ifaces = append(mobile.ifaces, net.Interface{
+		Index: index,
+		Name:  name,
+		Flags: net.FlagUp | net.FlagMulticast,
+	})
+	mobile.lock.Unlock()
+}
+
+// ClearInterfaces removes the interfaces added with AddInterface.
+func ClearInterfaces() {
+	mobile.lock.Lock()
+	mobile.ifaces = nil
+	mobile.lock.Unlock()
+}
+
+// multicastInterfaces returns the interfaces to join the mDNS groups on when
+// none are configured: those added with AddInterface, if any, or else those
+// of the system.
+func multicastInterfaces() ([]net.Interface, error) {
+	mobile.lock.Lock()
+	ifaces := append([]net.Interface(nil), mobile.ifaces...)
+	mobile.lock.Unlock()
+	if len(ifaces) > 0 {
+		return ifaces, nil
+	}
+	return systemInterfaces()
+}
+
+// acquireMulticast acquires the multicast lock, if one is set, for a server
+// or client.  Each call must be matched by one to releaseMulticast.
+func acquireMulticast() {
+	mobile.lock.Lock()
+	defer mobile.lock.Unlock()
+	mobile.held++
+	if mobile.held == 1 && mobile.mlock != nil {
+		mobile.mlock.Acquire()
+	}
+}
+
+// releaseMulticast releases the multicast lock once no server or client
+// holds it.
+func releaseMulticast() {
+	mobile.lock.Lock()
+	defer mobile.lock.Unlock()
+	mobile.held--
+	if mobile.held == 0 && mobile.mlock != nil {
+		mobile.mlock.Release()
+	}
+}
+
+// listenUDP binds a UDP socket to addr, and to the network of the
+// NetworkBinder, if one is set.
+func listenUDP(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	c, err := bindUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	mobile.lock.Lock()
+	binder := mobile.binder
+	mobile.lock.Unlock()
+	if binder == nil {
+		return c, nil
+	}
+	if err := bindNetwork(c, binder); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// bindNetwork binds c with binder.
+func bindNetwork(c *net.UDPConn, binder NetworkBinder) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var berr error
+	if err := raw.Control(func(fd uintptr) {
+		berr = binder.BindSocket(int(fd))
+	}); err != nil {
+		return err
+	}
+	return berr
+}
@@ -0,0 +1,39 @@
+package mdns
+
+import (
+	"testing"
+)
+
+type countingLock struct{ acquired, released int }
+
+func (l *countingLock) Acquire() { l.acquired++ }
+func (l *countingLock) Release() { l.released++ }
+
+func TestMulticastLock(t *testing.T) {
+	l := new(countingLock)
+	SetMulticastLock(l)
+	defer SetMulticastLock(nil)
+
+	acquireMulticast()
+	acquireMulticast()
+	if l.acquired != 1 || l.released != 0 {
+		t.Errorf("acquired %d, released %d times, want 1 and 0", l.acquired, l.released)
+	}
+	releaseMulticast()
+	releaseMulticast()
+	if l.acquired != 1 || l.released != 1 {
+		t.Errorf("acquired %d, released %d times, want 1 and 1", l.acquired, l.released)
+	}
+}
+
+func TestAddInterface(t *testing.T) {
+	AddInterface("wlan0", 7)
+	defer ClearInterfaces()
+	ifaces, err := multicastInterfaces()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(ifaces) != 1 || ifaces[0].Name != "wlan0" || ifaces[0].Index != 7 {
+		t.Errorf("bad interfaces: %+v", ifaces)
+	}
+}
@@ -0,0 +1,307 @@
+// Package mqttbridge publishes the services browsed with mDNS to an MQTT
+// broker, and can advertise services described by MQTT messages, so that
+// home-automation systems built around a broker see the devices of the LAN.
+//
+// Each instance is published as a retained message on the topic
+//
+//     <prefix>/services/<service>/<instance>
+//
+// such as "mdns/services/_ipp._tcp/printer", whose payload is the entry in
+// its JSON encoding.  When the instance leaves, the retained message is
+// cleared with an empty payload.  Config.Topic and Config.Payload change the
+// scheme, for example to publish Home Assistant discovery messages, which are
+// also removed by an empty payload.
+//
+// If Config.Set is given, retained messages on <prefix>/advertise/<id> whose
+// payload is a service such as
+//
+//     {"instance": "web", "service": "_http._tcp", "port": 80, "txt": ["path=/"]}
+//
+// are advertised by Config.Server, and withdrawn when the message is cleared:
+//
+//     set, _ := mdns.NewServiceSet()
+//     server, _ := mdns.NewServer(&mdns.Config{Zone: set})
+//     b, err := mqttbridge.New(&mqttbridge.Config{
+//         Client:   client, // A connected github.com/eclipse/paho.mqtt.golang client
+//         Services: []string{"_ipp._tcp"},
+//         Server:   server,
+//         Set:      set,
+//     })
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer b.Close()
+package mqttbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// DefaultPrefix is the first level of the topics used when Config.Prefix is
+// empty.
+const DefaultPrefix = "mdns"
+
+// publishTimeout bounds how long a publication waits for the broker.
+const publishTimeout = 10 * time.Second
+
+// Config is used to configure a Bridge.
+type Config struct {
+	// Client is a connected MQTT client.  Required.
+	Client mqtt.Client
+
+	// Services are the service types to browse and publish.
+	Services []string
+
+	// Prefix is the first level of the topics, default DefaultPrefix.
+	Prefix string
+
+	// Topic, if set, returns the topic an instance is published on, instead
+	// of <prefix>/services/<service>/<instance>.  Returning "" skips it.
+	Topic func(*mdns.ServiceEntry) string
+
+	// Payload, if set, returns the payload an instance is published with,
+	// instead of its JSON encoding.
+	Payload func(*mdns.ServiceEntry) ([]byte, error)
+
+	// QoS is the quality of service of the publications and subscription.
+	QoS byte
+
+	// Server and Set, if given, advertise the services described by the
+	// messages on <prefix>/advertise/+.  Set must be the server's zone.
+	Server *mdns.Server
+	Set    *mdns.ServiceSet
+
+	// Options are used for browsing, for example mdns.WithInterface.
+	Options []mdns.QueryOption
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Bridge publishes browse events to a broker, and advertises services
+// described by messages, from the time it is created until Close is called.
+type Bridge struct {
+	config   *Config
+	prefix   string
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	browsers []*mdns.Browser
+
+	lock       sync.Mutex
+	advertised map[string]*mdns.MDNSService // By topic
+}
+
+// service is the JSON form of an advertised service, as in the admin
+// package.
+type service struct {
+	Instance string   `json:"instance"`
+	Service  string   `json:"service"`
+	Domain   string   `json:"domain,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port"`
+	IPs      []net.IP `json:"ips,omitempty"`
+	TXT      []string `json:"txt"`
+}
+
+// New creates a bridge, starts browsing and subscribes to advertisements.
+func New(config *Config) (*Bridge, error) {
+	if config.Client == nil {
+		return nil, fmt.Errorf("mqttbridge: an MQTT client is required")
+	}
+	if (config.Server == nil) != (config.Set == nil) {
+		return nil, fmt.Errorf("mqttbridge: Server and Set must be given together")
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		config:     config,
+		prefix:     prefix,
+		cancel:     cancel,
+		advertised: make(map[string]*mdns.MDNSService),
+	}
+	for _, service := range config.Services {
+		browser, err := mdns.NewBrowser(ctx, service, config.Options...)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.browsers = append(b.browsers, browser)
+		b.wg.Add(1)
+		go b.run(browser)
+	}
+	if config.Set != nil {
+		t := config.Client.Subscribe(b.advertiseFilter(), config.QoS, b.handleAdvertisement)
+		if err := wait(t); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("mqttbridge: failed to subscribe: %v", err)
+		}
+	}
+	return b, nil
+}
+
+// Close stops browsing, and withdraws the services advertised from
+// messages.  Published instances are left on the broker, as the instances
+// remain on the network.
+func (b *Bridge) Close() {
+	if b.config.Set != nil {
+		if err := wait(b.config.Client.Unsubscribe(b.advertiseFilter())); err != nil {
+			b.logf("[ERR] mqttbridge: Failed to unsubscribe: %v", err)
+		}
+	}
+	b.cancel()
+	for _, browser := range b.browsers {
+		browser.Close()
+	}
+	b.wg.Wait()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for topic := range b.advertised {
+		b.withdraw(topic)
+	}
+}
+
+func (b *Bridge) logf(format string, v ...interface{}) {
+	if b.config.Logger != nil {
+		b.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// wait waits for an MQTT operation to complete.
+func wait(t mqtt.Token) error {
+	if !t.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out")
+	}
+	return t.Error()
+}
+
+// run publishes the events of a browser until it is closed.
+func (b *Bridge) run(browser *mdns.Browser) {
+	defer b.wg.Done()
+	for ev := range browser.Events() {
+		topic := b.Topic(ev.Entry)
+		if topic == "" {
+			continue
+		}
+		var payload []byte
+		if ev.Type != mdns.ServiceRemoved {
+			var err error
+			if payload, err = b.payload(ev.Entry); err != nil {
+				b.logf("[ERR] mqttbridge: Failed to encode %s: %v", ev.Entry.Name, err)
+				continue
+			}
+		}
+		if err := wait(b.config.Client.Publish(topic, b.config.QoS, true, payload)); err != nil {
+			b.logf("[ERR] mqttbridge: Failed to publish %s: %v", topic, err)
+		}
+	}
+}
+
+// Topic returns the topic an instance is published on.
+func (b *Bridge) Topic(entry *mdns.ServiceEntry) string {
+	if b.config.Topic != nil {
+		return b.config.Topic(entry)
+	}
+	name := strings.TrimSuffix(entry.Name, ".")
+	var instance, service string
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i > 0; i-- {
+		if l := labels[i]; l == "_tcp" || l == "_udp" {
+			// Instance names may contain escaped dots, so the instance is
+			// everything before the service type.
+			instance = strings.Join(labels[:i-1], ".")
+			service = labels[i-1] + "." + l
+			break
+		}
+	}
+	if instance == "" {
+		return ""
+	}
+	return b.prefix + "/services/" + service + "/" + topicLevel(instance)
+}
+
+// topicLevel replaces the characters that have a meaning in topics.
+func topicLevel(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+func (b *Bridge) payload(entry *mdns.ServiceEntry) ([]byte, error) {
+	if b.config.Payload != nil {
+		return b.config.Payload(entry)
+	}
+	return json.Marshal(entry)
+}
+
+func (b *Bridge) advertiseFilter() string {
+	return b.prefix + "/advertise/+"
+}
+
+// handleAdvertisement advertises, replaces or withdraws the service
+// described by a message.
+func (b *Bridge) handleAdvertisement(_ mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(msg.Payload()) == 0 {
+		b.withdraw(topic)
+		return
+	}
+	var req service
+	if err := json.Unmarshal(msg.Payload(), &req); err != nil {
+		b.logf("[ERR] mqttbridge: Bad advertisement on %s: %v", topic, err)
+		return
+	}
+	svc, err := mdns.NewMDNSService(req.Instance, req.Service, req.Domain, req.Host, req.Port, req.IPs, req.TXT)
+	if err != nil {
+		b.logf("[ERR] mqttbridge: Bad advertisement on %s: %v", topic, err)
+		return
+	}
+
+	old := b.advertised[topic]
+	if old != nil && old.InstanceName() != svc.InstanceName() {
+		// The message now describes another instance.
+		b.withdraw(topic)
+		old = nil
+	}
+	if old != nil {
+		_, err = b.config.Set.Replace(svc)
+	} else {
+		err = b.config.Set.Add(svc)
+	}
+	if err != nil {
+		b.logf("[ERR] mqttbridge: Failed to advertise %s: %v", svc.InstanceName(), err)
+		return
+	}
+	b.advertised[topic] = svc
+	b.config.Server.Announce(svc)
+}
+
+// withdraw withdraws the service advertised from a topic, if any.  The
+// caller must hold lock.
+func (b *Bridge) withdraw(topic string) {
+	svc := b.advertised[topic]
+	if svc == nil {
+		return
+	}
+	delete(b.advertised, topic)
+	b.config.Set.Remove(svc.InstanceName())
+	if err := b.config.Server.Withdraw(svc); err != nil {
+		b.logf("[ERR] mqttbridge: Failed to withdraw %s: %v", svc.InstanceName(), err)
+	}
+}
@@ -0,0 +1,163 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/micro/mdns"
+)
+
+// doneToken is a token for an operation that has completed.
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{}          { c := make(chan struct{}); close(c); return c }
+func (doneToken) Error() error                   { return nil }
+
+// testMessage is a message received from the broker.
+type testMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m *testMessage) Topic() string   { return m.topic }
+func (m *testMessage) Payload() []byte { return m.payload }
+
+// testClient records the retained messages published by a bridge.
+type testClient struct {
+	mqtt.Client
+
+	lock     sync.Mutex
+	retained map[string][]byte
+	handler  mqtt.MessageHandler
+}
+
+func (c *testClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if b := payload.([]byte); len(b) > 0 {
+		c.retained[topic] = b
+	} else {
+		delete(c.retained, topic)
+	}
+	return doneToken{}
+}
+
+func (c *testClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.handler = callback
+	return doneToken{}
+}
+
+func (c *testClient) Unsubscribe(topics ...string) mqtt.Token {
+	return doneToken{}
+}
+
+// waitFor waits until the retained messages satisfy ok.
+func (c *testClient) waitFor(t *testing.T, ok func(map[string][]byte) bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		c.lock.Lock()
+		done := ok(c.retained)
+		c.lock.Unlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for the retained messages to change")
+}
+
+func TestBridge_Publish(t *testing.T) {
+	zone, err := mdns.NewMDNSService("printer", "_ipp._tcp", "local.", "testhost.", 631,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"rp=ipp/print"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client := &testClient{retained: make(map[string][]byte)}
+	b, err := New(&Config{Client: client, Services: []string{"_ipp._tcp"}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	const topic = "mdns/services/_ipp._tcp/printer"
+	client.waitFor(t, func(m map[string][]byte) bool { return m[topic] != nil })
+	client.lock.Lock()
+	var entry mdns.ServiceEntry
+	err = json.Unmarshal(client.retained[topic], &entry)
+	client.lock.Unlock()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry.Port != 631 || entry.TXTMap()["rp"] != "ipp/print" {
+		t.Errorf("bad entry: %+v", entry)
+	}
+
+	// The goodbyes sent on shutdown clear the retained message.
+	serv.Shutdown()
+	client.waitFor(t, func(m map[string][]byte) bool { return m[topic] == nil })
+}
+
+func TestBridge_Advertise(t *testing.T) {
+	set, err := mdns.NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	client := &testClient{retained: make(map[string][]byte)}
+	b, err := New(&Config{Client: client, Server: serv, Set: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if client.handler == nil {
+		t.Fatalf("not subscribed")
+	}
+
+	client.handler(client, &testMessage{
+		topic:   "mdns/advertise/web",
+		payload: []byte(`{"instance": "web", "service": "_http._tcp", "host": "testhost.", "port": 80, "ips": ["192.168.0.42"], "txt": ["path=/"]}`),
+	})
+	svc := set.Get("web._http._tcp.local.")
+	if svc == nil || svc.Port != 80 {
+		t.Fatalf("service not advertised: %+v", svc)
+	}
+
+	// A new message replaces the service.
+	client.handler(client, &testMessage{
+		topic:   "mdns/advertise/web",
+		payload: []byte(`{"instance": "web", "service": "_http._tcp", "host": "testhost.", "port": 8080, "ips": ["192.168.0.42"]}`),
+	})
+	if svc := set.Get("web._http._tcp.local."); svc == nil || svc.Port != 8080 {
+		t.Fatalf("service not replaced: %+v", svc)
+	}
+
+	// Clearing the message withdraws it.
+	client.handler(client, &testMessage{topic: "mdns/advertise/web"})
+	if svc := set.Get("web._http._tcp.local."); svc != nil {
+		t.Fatalf("service not withdrawn: %+v", svc)
+	}
+
+	// Closing the bridge withdraws the services it advertised.
+	client.handler(client, &testMessage{
+		topic:   "mdns/advertise/ssh",
+		payload: []byte(`{"instance": "box", "service": "_ssh._tcp", "host": "testhost.", "port": 22, "ips": ["192.168.0.42"]}`),
+	})
+	b.Close()
+	if svcs := set.Services(); len(svcs) != 0 {
+		t.Errorf("services not withdrawn: %v", svcs)
+	}
+}
@@ -0,0 +1,177 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// nat64Refresh is how long a discovered NAT64 prefix is used before it is
+// discovered again.
+const nat64Refresh = 5 * time.Minute
+
+// nat64WellKnown are the addresses of "ipv4only.arpa.", which a DNS64
+// resolver synthesizes AAAA records for with the network's NAT64 prefix
+// (RFC 7050, section 2.2).
+var nat64WellKnown = []net.IP{net.IPv4(192, 0, 0, 170).To4(), net.IPv4(192, 0, 0, 171).To4()}
+
+// nat64Positions are the bytes of an IPv6 address that hold the embedded
+// IPv4 address, for each NAT64 prefix length (RFC 6052, section 2.2).  Byte
+// 8 is always zero.
+var nat64Positions = map[int][4]int{
+	32: {4, 5, 6, 7},
+	40: {5, 6, 7, 9},
+	48: {6, 7, 9, 10},
+	56: {7, 9, 10, 11},
+	64: {9, 10, 11, 12},
+	96: {12, 13, 14, 15},
+}
+
+var (
+	nat64Lock   sync.Mutex
+	nat64Prefix *net.IPNet
+	nat64Read   time.Time // When nat64Prefix was discovered, zero if never
+)
+
+// DiscoverNAT64Prefix finds the NAT64 prefix of the network, as described
+// in RFC 7050, by asking the system's resolver for the AAAA records of
+// "ipv4only.arpa.".  It returns nil, and no error, on a network without
+// DNS64.
+func DiscoverNAT64Prefix(ctx context.Context) (*net.IPNet, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", "ipv4only.arpa")
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, ip := range ips {
+		if prefix := nat64PrefixOf(ip); prefix != nil {
+			return prefix, nil
+		}
+	}
+	return nil, nil
+}
+
+// nat64PrefixOf returns the NAT64 prefix that ip, an address of
+// "ipv4only.arpa.", was synthesized with, or nil if it holds neither of the
+// well-known addresses.
+func nat64PrefixOf(ip net.IP) *net.IPNet {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil
+	}
+	for _, bits := range []int{96, 64, 56, 48, 40, 32} {
+		pos := nat64Positions[bits]
+		v4 := net.IP{ip16[pos[0]], ip16[pos[1]], ip16[pos[2]], ip16[pos[3]]}
+		for _, known := range nat64WellKnown {
+			if v4.Equal(known) && (bits == 96 || ip16[8] == 0) {
+				mask := net.CIDRMask(bits, 128)
+				return &net.IPNet{IP: ip16.Mask(mask), Mask: mask}
+			}
+		}
+	}
+	return nil
+}
+
+// NAT64Address returns the IPv6 address through which the IPv4 address v4
+// is reached with the NAT64 prefix, as described in RFC 6052.
+func NAT64Address(prefix *net.IPNet, v4 net.IP) (net.IP, error) {
+	bits, size := prefix.Mask.Size()
+	pos, ok := nat64Positions[bits]
+	if size != 128 || !ok {
+		return nil, fmt.Errorf("mdns: invalid NAT64 prefix %v", prefix)
+	}
+	ip4 := v4.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("mdns: %v is not an IPv4 address", v4)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16().Mask(prefix.Mask))
+	ip[8] = 0
+	for i, p := range pos {
+		ip[p] = ip4[i]
+	}
+	return ip, nil
+}
+
+// currentNAT64Prefix returns the network's NAT64 prefix, discovering it
+// again once it is older than nat64Refresh, or nil if there is none.
+func currentNAT64Prefix(ctx context.Context) *net.IPNet {
+	nat64Lock.Lock()
+	defer nat64Lock.Unlock()
+	if !nat64Read.IsZero() && time.Since(nat64Read) < nat64Refresh {
+		return nat64Prefix
+	}
+	prefix, err := DiscoverNAT64Prefix(ctx)
+	if err != nil {
+		// Try again with the next entry.
+		return nil
+	}
+	nat64Prefix, nat64Read = prefix, time.Now()
+	return prefix
+}
+
+// synthesizeNAT64 gives e, an entry with only an IPv4 address, the IPv6
+// address that reaches it through the network's NAT64 prefix, if there is
+// one.
+func synthesizeNAT64(ctx context.Context, e *ServiceEntry) {
+	if e.AddrV6 != nil || e.AddrV4 == nil {
+		return
+	}
+	prefix := currentNAT64Prefix(ctx)
+	if prefix == nil {
+		return
+	}
+	if ip, err := NAT64Address(prefix, e.AddrV4); err == nil {
+		e.AddrV6 = ip
+		e.Zone = ""
+		e.NAT64 = true
+	}
+}
+
+// ipv6Only returns true if none of ifaces has an IPv4 address other than a
+// link-local one, so that A records are of no use.
+func ipv6Only(ifaces []*net.Interface) bool {
+	if len(ifaces) == 0 {
+		return false
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return false
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLinkLocalUnicast() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// followUp returns the query for the records still missing from the
+// incomplete entry inp, like followUpQuery, without the questions that
+// cached NSEC records deny and, on an IPv6-only network, those for A
+// records, or nil if there is nothing to ask.
+func (c *client) followUp(inp *ServiceEntry, cache *Cache) *dns.Msg {
+	m := cache.withoutNegative(followUpQuery(inp))
+	if m == nil || !c.ipv6Only {
+		return m
+	}
+	var questions []dns.Question
+	for _, q := range m.Question {
+		if q.Qtype != dns.TypeA {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return nil
+	}
+	m.Question = questions
+	return m
+}
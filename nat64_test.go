@@ -0,0 +1,46 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNAT64Prefix(t *testing.T) {
+	for _, test := range []struct {
+		ip, prefix string
+	}{
+		{"64:ff9b::c000:aa", "64:ff9b::/96"},
+		{"2001:db8:c000:aa::", "2001:db8::/32"},
+		{"2001:db8:1c0:0:aa::", "2001:db8:100::/40"},
+		{"2001:db8:122:c000:0:aa00::", "2001:db8:122::/48"},
+		{"2001:db8:122:3c0:0:aa::", "2001:db8:122:300::/56"},
+		{"2001:db8:122:344:c0:0:aa00:0", "2001:db8:122:344::/64"},
+	} {
+		prefix := nat64PrefixOf(net.ParseIP(test.ip))
+		if prefix == nil || prefix.String() != test.prefix {
+			t.Errorf("prefix of %s is %v, want %s", test.ip, prefix, test.prefix)
+			continue
+		}
+		ip, err := NAT64Address(prefix, net.IPv4(192, 0, 0, 170))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !ip.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("NAT64Address(%v, 192.0.0.170) = %v, want %s", prefix, ip, test.ip)
+		}
+	}
+	if prefix := nat64PrefixOf(net.ParseIP("2001:db8::1")); prefix != nil {
+		t.Errorf("found prefix %v in an ordinary address", prefix)
+	}
+}
+
+func TestClient_FollowUpIPv6Only(t *testing.T) {
+	e := &ServiceEntry{Name: "hostname._foobar._tcp.local.", Host: "testhost.local.", Port: 80, hasTXT: true}
+	c := &client{ipv6Only: true}
+	m := c.followUp(e, nil)
+	if m == nil || len(m.Question) != 1 || m.Question[0].Qtype != dns.TypeAAAA {
+		t.Fatalf("bad follow-up query: %v", m)
+	}
+}
@@ -0,0 +1,114 @@
+package mdns
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// netCheckInterval is how often a Browser reads the addresses of the
+	// interfaces, to notice when the host moves to another network.
+	netCheckInterval = 5 * time.Second
+
+	// networkChangeGrace is how long the records learned on an interface
+	// whose networks changed remain in the cache.  Responders still
+	// present refresh them by answering the queries sent after the change,
+	// and the others are removed once a last query for them goes
+	// unanswered, as for any record that expires.
+	networkChangeGrace = 2 * time.Second
+)
+
+// netWatch notices changes to the networks of the interfaces, as when a
+// laptop roams to another Wi-Fi network or a cable is plugged in.
+type netWatch struct {
+	ifaces []scopeIface
+	read   func() ([]scopeIface, error) // readIfaces; overridden in tests
+}
+
+func newNetWatch() *netWatch {
+	w := &netWatch{read: readIfaces}
+	w.ifaces, _ = w.read()
+	return w
+}
+
+// check reads the interfaces again and reports whether their networks
+// changed since the last check.  It returns the interfaces whose networks
+// changed or that disappeared, as they were before, so that the records
+// learned on them can be found; an interface that appeared is a change but
+// is not returned.
+func (w *netWatch) check() ([]scopeIface, bool) {
+	ifaces, err := w.read()
+	if err != nil {
+		return nil, false
+	}
+	old, changed := changedIfaces(w.ifaces, ifaces)
+	w.ifaces = ifaces
+	return old, changed
+}
+
+// changedIfaces compares two readings of the interfaces, and returns those
+// of before whose networks are not the same in after, and whether any
+// interface changed, appeared or disappeared.  Only the networks are
+// compared, so that a new temporary IPv6 address or a renewed DHCP lease in
+// the same subnet is not a change.
+func changedIfaces(before, after []scopeIface) ([]scopeIface, bool) {
+	nets := make(map[int]string, len(after))
+	for _, iface := range after {
+		nets[iface.index] = ifaceNetworks(iface)
+	}
+	var changed []scopeIface
+	for _, iface := range before {
+		n, ok := nets[iface.index]
+		delete(nets, iface.index)
+		if !ok || n != ifaceNetworks(iface) {
+			changed = append(changed, iface)
+		}
+	}
+	for _, n := range nets {
+		if n != "" {
+			return changed, true
+		}
+	}
+	return changed, len(changed) > 0
+}
+
+// ifaceNetworks returns the networks of an interface's addresses, such as
+// "192.168.1.0/24", sorted and joined with spaces.
+func ifaceNetworks(iface scopeIface) string {
+	nets := make([]string, 0, len(iface.nets))
+	for _, ipnet := range iface.nets {
+		n := net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}
+		nets = append(nets, n.String())
+	}
+	sort.Strings(nets)
+	return strings.Join(nets, " ")
+}
+
+// networkChanged makes the records received from responders on the given
+// interfaces, which are no longer on the networks they were, expire within
+// networkChangeGrace, and returns how many there were.  Records whose
+// source is not known, such as those loaded from a file, are kept.
+func (c *Cache) networkChanged(ifaces []scopeIface) int {
+	if c == nil || len(ifaces) == 0 {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	n := 0
+	for _, e := range c.records {
+		if e.source == nil {
+			continue
+		}
+		if _, ok := arrival(ifaces, e.source, 0); !ok {
+			continue
+		}
+		if e.expires.After(now.Add(networkChangeGrace)) {
+			e.expires = now.Add(networkChangeGrace)
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func testIface(index int, name string, cidrs ...string) scopeIface {
+	iface := scopeIface{index: index, name: name}
+	for _, cidr := range cidrs {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ipnet.IP = ip
+		iface.nets = append(iface.nets, ipnet)
+	}
+	return iface
+}
+
+func TestChangedIfaces(t *testing.T) {
+	home := []scopeIface{
+		testIface(1, "lo", "127.0.0.1/8"),
+		testIface(2, "wlan0", "192.168.1.20/24", "fe80::1/64"),
+	}
+
+	// A new address in the same subnet is not a change.
+	renewed := []scopeIface{
+		testIface(1, "lo", "127.0.0.1/8"),
+		testIface(2, "wlan0", "192.168.1.35/24", "fe80::1/64"),
+	}
+	if old, changed := changedIfaces(home, renewed); changed || len(old) != 0 {
+		t.Errorf("address renewal is a change: %v", old)
+	}
+
+	roamed := []scopeIface{
+		testIface(1, "lo", "127.0.0.1/8"),
+		testIface(2, "wlan0", "10.0.0.7/16", "fe80::1/64"),
+	}
+	old, changed := changedIfaces(home, roamed)
+	if !changed || len(old) != 1 || old[0].name != "wlan0" || old[0].nets[0].String() != "192.168.1.20/24" {
+		t.Errorf("bad change: %v %v", changed, old)
+	}
+
+	// An interface that appears is a change, without records to expire.
+	plugged := append(home, testIface(3, "eth0", "172.16.0.2/12"))
+	if old, changed := changedIfaces(home, plugged); !changed || len(old) != 0 {
+		t.Errorf("bad change: %v %v", changed, old)
+	}
+	if old, changed := changedIfaces(plugged, home); !changed || len(old) != 1 || old[0].name != "eth0" {
+		t.Errorf("bad change: %v %v", changed, old)
+	}
+}
+
+func TestCache_NetworkChanged(t *testing.T) {
+	c, clock := makeTestCache()
+	a := func(name string) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+			A:   net.IPv4(10, 0, 0, 1),
+		}
+	}
+	c.add(a("home.local."), clock.now(), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 5), Port: 5353})
+	c.add(a("linklocal.local."), clock.now(), &net.UDPAddr{IP: net.ParseIP("fe80::5"), Port: 5353, Zone: "wlan0"})
+	c.add(a("office.local."), clock.now(), &net.UDPAddr{IP: net.IPv4(172, 16, 0, 5), Port: 5353})
+	c.Add(a("loaded.local."))
+
+	if n := c.networkChanged([]scopeIface{testIface(2, "wlan0", "192.168.1.20/24")}); n != 2 {
+		t.Fatalf("%d records expiring, want 2", n)
+	}
+	clock.advance(networkChangeGrace)
+	for name, want := range map[string]int{"home.local.": 0, "linklocal.local.": 0, "office.local.": 1, "loaded.local.": 1} {
+		if got := len(c.Lookup(name, dns.TypeA)); got != want {
+			t.Errorf("%s: %d records, want %d", name, got, want)
+		}
+	}
+}
+
+func TestQueryGroup_Restart(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	c, err := newClient(nil, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	c.responder = conn.LocalAddr().(*net.UDPAddr)
+
+	q := new(dns.Msg)
+	q.SetQuestion("_restart._tcp.local.", dns.TypePTR)
+	member := joinQueryGroup("", q, c, make(chan *received, 1))
+	defer member.leave()
+
+	read := func(timeout time.Duration) *dns.Msg {
+		buf := make([]byte, 1500)
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return m
+	}
+	if m := read(time.Second); m == nil || m.Question[0].Qclass != dns.ClassINET {
+		t.Fatalf("bad first query: %v", m)
+	}
+
+	member.group.restart()
+	member.group.restart()
+	if m := read(time.Second); m == nil || m.Question[0].Qclass != dns.ClassINET|1<<15 {
+		t.Fatalf("restart did not send a QU query: %v", m)
+	}
+	if m := read(500 * time.Millisecond); m != nil {
+		t.Errorf("second restart sent a query: %v", m)
+	}
+	if m := read(time.Second); m == nil || m.Question[0].Qclass != dns.ClassINET {
+		t.Errorf("bad repeated query: %v", m)
+	}
+}
@@ -0,0 +1,51 @@
+package mdns
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Message is a DNS message received by a lookup or browser, as given to the
+// observer set with WithMessageObserver.
+type Message struct {
+	Msg       *dns.Msg
+	From      *net.UDPAddr // Sender of the message
+	Interface string       // Name of the interface it arrived on, if known
+	At        time.Time    // When it was received
+}
+
+// WithMessageObserver calls observe with every message the lookup or browser
+// receives, queries and responses alike, before the message is processed.
+// This gives access to records the package does not interpret, such as
+// vendor-specific ones, without reimplementing the receive loop.
+//
+// The observer is called from the goroutines reading the sockets, so it must
+// return quickly and must not change the message.
+func WithMessageObserver(observe func(*Message)) QueryOption {
+	return func(p *QueryParam) {
+		p.Observer = observe
+	}
+}
+
+// observe passes the message r to the client's observer, if it has one.
+func (c *client) observe(r *received) {
+	if c.observer == nil {
+		return
+	}
+	m := &Message{Msg: r.msg, From: r.from, At: r.at}
+	if r.from.Zone != "" {
+		m.Interface = r.from.Zone
+	} else {
+		ifaces := c.scope.load(r.at)
+		if index, ok := arrival(ifaces, r.from, 0); ok {
+			for _, iface := range ifaces {
+				if iface.index == index {
+					m.Interface = iface.name
+				}
+			}
+		}
+	}
+	c.observer(m)
+}
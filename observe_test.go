@@ -0,0 +1,43 @@
+package mdns
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestServer_MessageObserver(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_observe._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	var lock sync.Mutex
+	var responses []*Message
+	observe := func(m *Message) {
+		lock.Lock()
+		defer lock.Unlock()
+		if m.Msg.Response {
+			responses = append(responses, m)
+		}
+	}
+	entries := make(chan *ServiceEntry, 4)
+	err = Lookup(context.Background(), "_observe._tcp", WithTimeout(500*time.Millisecond), WithEntriesChannel(entries), WithMessageObserver(observe))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(responses) == 0 {
+		t.Fatalf("no responses observed")
+	}
+	for _, m := range responses {
+		if m.From == nil || m.At.IsZero() {
+			t.Errorf("bad message: %+v", m)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package mdns
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// QueryOption customizes a Lookup.  Options are applied in order to a
+// QueryParam that starts out with the values returned by DefaultParams.
+type QueryOption func(*QueryParam)
+
+// WithTimeout bounds how long the lookup runs.  The default is one second.  A
+// timeout of zero means the lookup runs until its context is done.
+func WithTimeout(timeout time.Duration) QueryOption {
+	return func(p *QueryParam) {
+		p.Timeout = timeout
+	}
+}
+
+// WithDomain sets the domain to look up the service in.  The default is
+// "local".
+func WithDomain(domain string) QueryOption {
+	return func(p *QueryParam) {
+		p.Domain = domain
+	}
+}
+
+// WithInterface sends the query on, and listens for responses on, the given
+// multicast interface.
+func WithInterface(iface *net.Interface) QueryOption {
+	return func(p *QueryParam) {
+		p.Interface = iface
+	}
+}
+
+// WithUnicastResponse asks responders to reply over unicast, as per section
+// 5.4 of RFC 6762.
+func WithUnicastResponse(unicast bool) QueryOption {
+	return func(p *QueryParam) {
+		p.WantUnicastResponse = unicast
+	}
+}
+
+// WithEntriesChannel sets the channel that discovered services are sent to.
+// This option is required.
+func WithEntriesChannel(entries chan<- *ServiceEntry) QueryOption {
+	return func(p *QueryParam) {
+		p.Entries = entries
+	}
+}
+
+// WithCache answers the lookup from, and records responses in, the given
+// cache.
+func WithCache(cache *Cache) QueryOption {
+	return func(p *QueryParam) {
+		p.Cache = cache
+	}
+}
+
+// WithLogger sets the logger used for errors.
+func WithLogger(logger *log.Logger) QueryOption {
+	return func(p *QueryParam) {
+		p.Logger = logger
+	}
+}
+
+// WithMetrics sets counters to update as the lookup runs.
+func WithMetrics(metrics *ClientMetrics) QueryOption {
+	return func(p *QueryParam) {
+		p.Metrics = metrics
+	}
+}
+
+// WithResponder sends the query directly to a single responder over unicast
+// rather than multicasting it.
+func WithResponder(addr *net.UDPAddr) QueryOption {
+	return func(p *QueryParam) {
+		p.Responder = addr
+	}
+}
+
+// WithWideArea also browses using unicast DNS-SD queries, which is always
+// done for domains other than "local".
+func WithWideArea(enabled bool) QueryOption {
+	return func(p *QueryParam) {
+		p.WideArea = enabled
+	}
+}
+
+// WithResolvers sets the unicast DNS servers, as "host:port", used for
+// wide-area browsing instead of those in /etc/resolv.conf.
+func WithResolvers(servers ...string) QueryOption {
+	return func(p *QueryParam) {
+		p.Resolvers = servers
+	}
+}
+
+// WithPushServer sets the DNS Push server that a Browser subscribes to for
+// wide-area browsing, and the TLS configuration used to connect to it, which
+// may be nil.
+func WithPushServer(addr string, config *tls.Config) QueryOption {
+	return func(p *QueryParam) {
+		p.PushServer = addr
+		p.PushTLSConfig = config
+	}
+}
+
+// WithTrace records the steps of the lookup or browse in t, for debugging.
+func WithTrace(t *Trace) QueryOption {
+	return func(p *QueryParam) {
+		p.Trace = t
+	}
+}
+
+// WithSystemResponder browses through the operating system's DNS-SD daemon,
+// mDNSResponder on macOS, instead of sending mDNS queries directly.
+func WithSystemResponder(enabled bool) QueryOption {
+	return func(p *QueryParam) {
+		p.System = enabled
+	}
+}
+
+// WithNAT64 gives entries with only an IPv4 address the IPv6 address that
+// reaches it through the network's NAT64 prefix, discovered as described in
+// RFC 7050, so that hosts on IPv6-only networks can connect to IPv4-only
+// devices.  Such entries have NAT64 set.
+func WithNAT64(enabled bool) QueryOption {
+	return func(p *QueryParam) {
+		p.NAT64 = enabled
+	}
+}
+
+// WithQuietPeriod ends a List once no instance has been found or changed
+// for d, rather than only at the timeout.
+func WithQuietPeriod(d time.Duration) QueryOption {
+	return func(p *QueryParam) {
+		p.QuietPeriod = d
+	}
+}
+
+// Lookup looks up instances of a service and sends them to the channel given
+// with WithEntriesChannel.  It returns when ctx is done or the timeout, one
+// second by default, elapses.  The query is repeated while the lookup runs,
+// one, two, four seconds and so on after it was first sent (RFC 6762 section
+// 5.2), each repeat being counted in ClientMetrics.Retransmissions.
+//
+// Example usage:
+//     entries := make(chan *mdns.ServiceEntry, 8)
+//     go func() {
+//       for e := range entries {
+//         fmt.Printf("Got new entry: %v\n", e)
+//       }
+//     }()
+//     err := mdns.Lookup(ctx, "_foobar._tcp", mdns.WithEntriesChannel(entries))
+//     close(entries)
+func Lookup(ctx context.Context, service string, opts ...QueryOption) error {
+	params := DefaultParams(service)
+	params.Entries = nil
+	for _, opt := range opts {
+		opt(params)
+	}
+	if params.Entries == nil {
+		return fmt.Errorf("mdns: Lookup requires an entries channel, see WithEntriesChannel")
+	}
+
+	if params.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+	params.Context = ctx
+	return Query(params)
+}
+
+// List looks up instances of a service like Lookup, and returns them once
+// the lookup ends, at the timeout, one second by default, or, with
+// WithQuietPeriod, once no instance has been found or changed for the quiet
+// period.  Each instance is returned once, as last updated, and the entries
+// are sorted by name.  WithEntriesChannel is ignored.
+func List(ctx context.Context, service string, opts ...QueryOption) ([]*ServiceEntry, error) {
+	params := DefaultParams(service)
+	for _, opt := range opts {
+		opt(params)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	entriesCh := make(chan *ServiceEntry, 16)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Lookup(ctx, service, append(opts, WithEntriesChannel(entriesCh))...)
+		close(entriesCh)
+	}()
+
+	var quiet <-chan time.Time
+	var timer *time.Timer
+	if params.QuietPeriod > 0 {
+		timer = time.NewTimer(params.QuietPeriod)
+		defer timer.Stop()
+		quiet = timer.C
+	}
+	found := make(map[string]*ServiceEntry)
+	for done := false; !done; {
+		select {
+		case e, ok := <-entriesCh:
+			if !ok {
+				done = true
+				break
+			}
+			found[e.Name] = e
+			if timer != nil {
+				timer.Reset(params.QuietPeriod)
+			}
+		case <-quiet:
+			cancel()
+			quiet = nil
+		}
+	}
+
+	entries := make([]*ServiceEntry, 0, len(found))
+	for _, e := range found {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, <-errCh
+}
@@ -0,0 +1,73 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestLookup_RequiresEntries(t *testing.T) {
+	if err := Lookup(context.Background(), "_foobar._tcp"); err == nil {
+		t.Fatalf("Lookup without an entries channel should fail")
+	}
+}
+
+func TestServer_LookupOptions(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	entries := make(chan *ServiceEntry, 4)
+	metrics := new(ClientMetrics)
+	err = Lookup(context.Background(), "_foobar._tcp",
+		WithEntriesChannel(entries),
+		WithTimeout(50*time.Millisecond),
+		WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Name != "hostname._foobar._tcp.local." || e.Port != 80 {
+			t.Errorf("bad: %v", e)
+		}
+	default:
+		t.Fatalf("record not found")
+	}
+	if got := metrics.Snapshot().QueriesSent; got == 0 {
+		t.Errorf("metrics were not updated")
+	}
+}
+
+func TestServer_List(t *testing.T) {
+	var servers []*Server
+	for _, instance := range []string{"zulu", "alpha"} {
+		svc, err := NewMDNSService(instance, "_list._tcp", "local.", "testhost.", 80, []net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		serv, err := NewServer(&Config{Zone: svc})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer serv.Shutdown()
+		servers = append(servers, serv)
+	}
+
+	start := time.Now()
+	entries, err := List(context.Background(), "_list._tcp", WithTimeout(5*time.Second), WithQuietPeriod(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("List ran for %v despite the quiet period", elapsed)
+	}
+	if len(entries) != 2 || entries[0].Name != "alpha._list._tcp.local." || entries[1].Name != "zulu._list._tcp.local." {
+		t.Fatalf("bad entries: %v", entries)
+	}
+}
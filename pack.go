@@ -0,0 +1,65 @@
+package mdns
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// packMsg packs msg as msg.PackBuffer(buf) does.  If a record of msg does
+// not pack, because of bad rdata for instance, it is logged and removed from
+// msg, and the rest is packed, so that one bad record returned by a zone does
+// not stop the others from being sent.  It returns nil, and no error, if msg
+// has no records left to send.  With Config.AuthKey, msg is signed first.
+func (s *Server) packMsg(msg *dns.Msg, buf []byte) ([]byte, error) {
+	config := s.conf()
+	if config != nil && config.AuthKey != nil {
+		signMsg(config.AuthKey, msg, time.Now())
+	}
+	packed, err := msg.PackBuffer(buf)
+	if err == nil {
+		return packed, nil
+	}
+	skipped := 0
+	msg.Answer, skipped = s.packableRRs(msg.Answer, skipped)
+	msg.Ns, skipped = s.packableRRs(msg.Ns, skipped)
+	msg.Extra, skipped = s.packableRRs(msg.Extra, skipped)
+	if skipped == 0 {
+		// The records are fine on their own, so the message is at fault.
+		return nil, err
+	}
+	if len(msg.Answer)+len(msg.Ns)+len(msg.Extra) == 0 {
+		return nil, nil
+	}
+	if config != nil && config.AuthKey != nil {
+		signMsg(config.AuthKey, msg, time.Now())
+	}
+	return msg.PackBuffer(buf)
+}
+
+// packableRRs returns the records of rrs that pack on their own, in a new
+// slice if any are left out, and skipped plus the number left out.
+func (s *Server) packableRRs(rrs []dns.RR, skipped int) ([]dns.RR, int) {
+	var kept []dns.RR
+	for i, rr := range rrs {
+		_, err := (&dns.Msg{Answer: []dns.RR{rr}}).Pack()
+		if err == nil {
+			if kept != nil {
+				kept = append(kept, rr)
+			}
+			continue
+		}
+		log.Printf("[WARN] mdns: Skipping record that does not pack: %v: %v", rr.Header(), err)
+		atomic.AddUint64(&s.metrics.SkippedRecords, 1)
+		skipped++
+		if kept == nil {
+			kept = append(make([]dns.RR, 0, len(rrs)-1), rrs[:i]...)
+		}
+	}
+	if kept == nil {
+		return rrs, skipped
+	}
+	return kept, skipped
+}
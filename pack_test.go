@@ -0,0 +1,41 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_PackMsg_SkipsBadRecords(t *testing.T) {
+	s := &Server{metrics: new(ServerMetrics)}
+	good := &dns.A{
+		Hdr: dns.RR_Header{Name: "good.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+		A:   net.IPv4(192, 168, 0, 42),
+	}
+	bad := &dns.A{
+		Hdr: dns.RR_Header{Name: "bad.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+		A:   net.IP{1, 2, 3},
+	}
+	msg := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{bad, good}}
+	buf, err := s.packMsg(msg, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got dns.Msg
+	if err := got.Unpack(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(got.Answer) != 1 || got.Answer[0].Header().Name != "good.local." {
+		t.Errorf("bad answers: %v", got.Answer)
+	}
+	if n := s.metrics.SkippedRecords; n != 1 {
+		t.Errorf("skipped %d records, want 1", n)
+	}
+
+	// Nothing is sent if no record is left.
+	msg = &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}, Answer: []dns.RR{bad}}
+	if buf, err := s.packMsg(msg, nil); err != nil || buf != nil {
+		t.Errorf("got %v, %v for a message without good records", buf, err)
+	}
+}
@@ -0,0 +1,45 @@
+package mdns
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// packetInfo is where a packet was received, as far as the platform
+// reports it.
+type packetInfo struct {
+	ifIndex int    // Index of the interface it arrived on, 0 if unknown
+	dst     net.IP // Address it was sent to, nil if unknown
+}
+
+// enablePacketInfo asks the kernel to report the interface and destination
+// address of each packet received on c: with IP_PKTINFO or
+// IPV6_RECVPKTINFO on Linux, and IP_RECVIF and IP_RECVDSTADDR or
+// IPV6_RECVPKTINFO on Darwin and the BSDs.  It returns false where they
+// cannot be reported, as on Windows.
+func enablePacketInfo(c *net.UDPConn, v6 bool) bool {
+	if v6 {
+		return ipv6.NewPacketConn(c).SetControlMessage(ipv6.FlagInterface|ipv6.FlagDst, true) == nil
+	}
+	return ipv4.NewPacketConn(c).SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst, true) == nil
+}
+
+// parsePacketInfo returns the interface and destination address reported
+// in the control messages of a received packet.  Other control messages,
+// such as the kernel's drop counts, are skipped.
+func parsePacketInfo(oob []byte, v6 bool) packetInfo {
+	if v6 {
+		var cm ipv6.ControlMessage
+		if cm.Parse(oob) != nil {
+			return packetInfo{}
+		}
+		return packetInfo{ifIndex: cm.IfIndex, dst: cm.Dst}
+	}
+	var cm ipv4.ControlMessage
+	if cm.Parse(oob) != nil {
+		return packetInfo{}
+	}
+	return packetInfo{ifIndex: cm.IfIndex, dst: cm.Dst}
+}
@@ -0,0 +1,20 @@
+package mdns
+
+import (
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestParsePacketInfo(t *testing.T) {
+	if info := parsePacketInfo(nil, false); info.ifIndex != 0 || info.dst != nil {
+		t.Errorf("got %+v from no control messages", info)
+	}
+	oob := (&ipv4.ControlMessage{IfIndex: 3}).Marshal()
+	if oob == nil {
+		t.Skip("interfaces are not reported on this platform")
+	}
+	if info := parsePacketInfo(oob, false); info.ifIndex != 3 {
+		t.Errorf("got interface %d, want 3", info.ifIndex)
+	}
+}
@@ -0,0 +1,52 @@
+package mdns
+
+import (
+	"net"
+)
+
+// WithPeers also sends the lookup's queries by unicast to each of peers, for
+// hosts reached over links without multicast, such as WireGuard or other
+// point-to-point tunnels, whose interfaces are otherwise left out.  The
+// port of each peer defaults to 5353.  A broadcast address may be given for
+// a link that supports broadcast but not multicast.  The peers answer by
+// unicast, as to a direct query.
+func WithPeers(peers ...*net.UDPAddr) QueryOption {
+	return func(p *QueryParam) {
+		p.Peers = peers
+	}
+}
+
+// peerAddrs returns peers with their ports defaulted to the mDNS port.
+func peerAddrs(peers []*net.UDPAddr) []*net.UDPAddr {
+	addrs := make([]*net.UDPAddr, 0, len(peers))
+	for _, peer := range peers {
+		addrs = append(addrs, responderAddr(peer))
+	}
+	return addrs
+}
+
+// sendToPeers sends buf to each of the client's peers.
+func (c *client) sendToPeers(buf []byte) {
+	for _, peer := range c.peers {
+		if err := c.sendTo(buf, peer); err != nil {
+			c.logf("[ERR] mdns: Failed to query peer %v: %v", peer, err)
+		}
+	}
+}
+
+// writePeers sends each of bufs by unicast to the server's peers, see
+// Config.Peers.
+func (s *Server) writePeers(bufs [][]byte) {
+	for _, peer := range s.peers {
+		conn := s.ipv6List
+		if peer.IP.To4() != nil {
+			conn = s.ipv4List
+		}
+		if conn == nil {
+			continue
+		}
+		for _, buf := range bufs {
+			conn.WriteToUDP(buf, peer)
+		}
+	}
+}
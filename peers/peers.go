@@ -0,0 +1,262 @@
+// Package peers discovers the other peers of an application on the local
+// network, as peer-to-peer and clustering programs do to find each other
+// without any configuration.
+//
+// Each peer publishes itself as an instance of the application's service
+// type, with its ID and the addresses it can be reached on in its TXT
+// record, and browses for the others:
+//
+//     d, err := peers.New(&peers.Config{
+//         Service: "_myapp._udp",
+//         ID:      id,
+//         Port:    4001,
+//         Addrs:   []string{"/ip4/192.168.1.10/udp/4001/quic"},
+//         OnJoin:  func(p peers.Peer) { log.Printf("%s joined at %v", p.ID, p.Addrs) },
+//         OnLeave: func(p peers.Peer) { log.Printf("%s left", p.ID) },
+//     })
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer d.Close()
+//
+// Addresses are opaque strings, so they may be "host:port" pairs, URLs or
+// multiaddrs.  A peer leaves when it closes its Discovery, which sends
+// goodbyes, or when its records expire.
+package peers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+const (
+	// txtID and txtAddr are the TXT keys of a peer's ID and addresses.  The
+	// address key is repeated for each address.
+	txtID   = "id"
+	txtAddr = "addr"
+)
+
+// Config is used to configure a Discovery.
+type Config struct {
+	// Service is the service type shared by the application's peers, such
+	// as "_myapp._udp".  Required.
+	Service string
+
+	// ID identifies this peer.  It is also used as the instance name, so it
+	// must be at most 63 bytes long.  Required.
+	ID string
+
+	// Port is the port advertised in the SRV record.  Required.
+	Port int
+
+	// Addrs are the addresses this peer can be reached on, published in its
+	// TXT record.  If empty, other peers use the addresses of its host with
+	// Port.
+	Addrs []string
+
+	// Interface, if set, is the only interface peers are published and
+	// browsed on.
+	Interface *net.Interface
+
+	// OnJoin is called when a peer is found, OnUpdate when its addresses
+	// change, and OnLeave when it leaves.  They are called one at a time,
+	// from a single goroutine, and may be nil.
+	OnJoin   func(Peer)
+	OnUpdate func(Peer)
+	OnLeave  func(Peer)
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Peer is another peer of the application.
+type Peer struct {
+	ID    string
+	Addrs []string
+
+	// Entry is the service instance the peer was found as.
+	Entry *mdns.ServiceEntry
+}
+
+// Discovery publishes this peer and discovers the others, from the time it is
+// created until Close is called.
+type Discovery struct {
+	config  *Config
+	server  *mdns.Server
+	browser *mdns.Browser
+	done    chan struct{}
+
+	lock  sync.Mutex
+	peers map[string]Peer // By instance name
+}
+
+// New publishes this peer and starts looking for the others.
+func New(config *Config) (*Discovery, error) {
+	if config.Service == "" || config.ID == "" || config.Port == 0 {
+		return nil, fmt.Errorf("peers: a service, ID and port are required")
+	}
+	if len(config.ID) > 63 {
+		return nil, fmt.Errorf("peers: ID %q is longer than 63 bytes", config.ID)
+	}
+	txt := []string{txtID + "=" + config.ID}
+	for _, addr := range config.Addrs {
+		txt = append(txt, txtAddr+"="+addr)
+	}
+	for _, s := range txt {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("peers: TXT string %q is longer than 255 bytes", s)
+		}
+	}
+
+	zone, err := mdns.NewMDNSService(config.ID, config.Service, "", "", config.Port, nil, txt)
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: zone, Iface: config.Interface})
+	if err != nil {
+		return nil, err
+	}
+	var opts []mdns.QueryOption
+	if config.Interface != nil {
+		opts = append(opts, mdns.WithInterface(config.Interface))
+	}
+	if config.Logger != nil {
+		opts = append(opts, mdns.WithLogger(config.Logger))
+	}
+	browser, err := mdns.NewBrowser(context.Background(), config.Service, opts...)
+	if err != nil {
+		server.Shutdown()
+		return nil, err
+	}
+
+	d := &Discovery{
+		config:  config,
+		server:  server,
+		browser: browser,
+		done:    make(chan struct{}),
+		peers:   make(map[string]Peer),
+	}
+	go d.run()
+	return d, nil
+}
+
+// Close stops discovering peers, and withdraws this peer so that the others
+// see it leave at once.
+func (d *Discovery) Close() {
+	d.browser.Close()
+	<-d.done
+	if err := d.server.Shutdown(); err != nil {
+		d.logf("[ERR] peers: Failed to withdraw %s: %v", d.config.ID, err)
+	}
+}
+
+func (d *Discovery) logf(format string, v ...interface{}) {
+	if d.config.Logger != nil {
+		d.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// Peers returns the peers currently known, sorted by ID.
+func (d *Discovery) Peers() []Peer {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	peers := make([]Peer, 0, len(d.peers))
+	for _, p := range d.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// run turns the browser's events into callbacks until it is closed.
+func (d *Discovery) run() {
+	defer close(d.done)
+	for ev := range d.browser.Events() {
+		p := peerOf(ev.Entry)
+		if p.ID == d.config.ID {
+			continue
+		}
+
+		d.lock.Lock()
+		old, known := d.peers[ev.Entry.Name]
+		if ev.Type == mdns.ServiceRemoved {
+			delete(d.peers, ev.Entry.Name)
+		} else {
+			d.peers[ev.Entry.Name] = p
+		}
+		d.lock.Unlock()
+
+		switch {
+		case ev.Type == mdns.ServiceRemoved:
+			if known {
+				call(d.config.OnLeave, old)
+			}
+		case !known:
+			call(d.config.OnJoin, p)
+		case !sameAddrs(old.Addrs, p.Addrs):
+			call(d.config.OnUpdate, p)
+		}
+	}
+}
+
+func call(f func(Peer), p Peer) {
+	if f != nil {
+		f(p)
+	}
+}
+
+// peerOf returns the peer published as e.  Peers that do not publish their
+// addresses are reached on those of their host.
+func peerOf(e *mdns.ServiceEntry) Peer {
+	p := Peer{Entry: e}
+	for _, f := range e.InfoFields {
+		i := strings.Index(f, "=")
+		if i < 0 {
+			continue
+		}
+		switch key, value := strings.ToLower(f[:i]), f[i+1:]; key {
+		case txtID:
+			if p.ID == "" {
+				p.ID = value
+			}
+		case txtAddr:
+			p.Addrs = append(p.Addrs, value)
+		}
+	}
+	if p.ID == "" {
+		// The instance name is the ID of a peer that does not give one.
+		p.ID = strings.SplitN(e.Name, ".", 2)[0]
+	}
+	if len(p.Addrs) == 0 {
+		port := strconv.Itoa(e.Port)
+		if e.AddrV4 != nil {
+			p.Addrs = append(p.Addrs, net.JoinHostPort(e.AddrV4.String(), port))
+		}
+		if e.AddrV6 != nil {
+			p.Addrs = append(p.Addrs, net.JoinHostPort((&net.IPAddr{IP: e.AddrV6, Zone: e.Zone}).String(), port))
+		}
+	}
+	return p
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package peers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+)
+
+func TestPeerOf(t *testing.T) {
+	p := peerOf(&mdns.ServiceEntry{
+		Name:       "a._app._udp.local.",
+		InfoFields: []string{"id=peer-a", "addr=/ip4/10.0.0.1/udp/4001", "addr=10.0.0.1:4001"},
+	})
+	if p.ID != "peer-a" || !reflect.DeepEqual(p.Addrs, []string{"/ip4/10.0.0.1/udp/4001", "10.0.0.1:4001"}) {
+		t.Errorf("bad peer: %+v", p)
+	}
+
+	// Without an ID or addresses, the instance name and the host's
+	// addresses are used.
+	p = peerOf(&mdns.ServiceEntry{
+		Name:   "b._app._udp.local.",
+		Port:   4001,
+		AddrV4: net.IPv4(10, 0, 0, 2),
+	})
+	if p.ID != "b" || !reflect.DeepEqual(p.Addrs, []string{"10.0.0.2:4001"}) {
+		t.Errorf("bad peer: %+v", p)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	joined := make(chan Peer, 4)
+	left := make(chan Peer, 4)
+	a, err := New(&Config{
+		Service: "_peertest._udp",
+		ID:      "peer-a",
+		Port:    4001,
+		Addrs:   []string{"10.0.0.1:4001"},
+		OnJoin:  func(p Peer) { joined <- p },
+		OnLeave: func(p Peer) { left <- p },
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer a.Close()
+	b, err := New(&Config{
+		Service: "_peertest._udp",
+		ID:      "peer-b",
+		Port:    4002,
+		Addrs:   []string{"10.0.0.2:4002"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// a sees b join, but not itself.
+	select {
+	case p := <-joined:
+		if p.ID != "peer-b" || !reflect.DeepEqual(p.Addrs, []string{"10.0.0.2:4002"}) {
+			t.Fatalf("bad peer: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for peer-b to join")
+	}
+	if peers := a.Peers(); len(peers) != 1 || peers[0].ID != "peer-b" {
+		t.Errorf("bad peers: %+v", peers)
+	}
+
+	// Closing b sends goodbyes, so a sees it leave.
+	b.Close()
+	select {
+	case p := <-left:
+		if p.ID != "peer-b" {
+			t.Fatalf("bad peer: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for peer-b to leave")
+	}
+	if peers := a.Peers(); len(peers) != 0 {
+		t.Errorf("bad peers: %+v", peers)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&Config{Service: "_peertest._udp", Port: 4001}); err == nil {
+		t.Errorf("expected an error without an ID")
+	}
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := New(&Config{Service: "_peertest._udp", ID: string(long), Port: 4001}); err == nil {
+		t.Errorf("expected an error for a long ID")
+	}
+}
@@ -0,0 +1,92 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// listenPeer returns a socket standing in for a peer across a tunnel.
+func listenPeer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestServer_PeersAnnounced(t *testing.T) {
+	peer := listenPeer(t)
+	defer peer.Close()
+	svc := makeServiceWithServiceName(t, "_peer._tcp")
+	set, err := NewServiceSet(svc)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set, Peers: []*net.UDPAddr{peer.LocalAddr().(*net.UDPAddr)}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := peer.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("no announcement: %v", err)
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, rr := range m.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && ptr.Ptr == svc.instanceAddr {
+				return
+			}
+		}
+	}
+}
+
+func TestClient_PeersQueried(t *testing.T) {
+	peer := listenPeer(t)
+	defer peer.Close()
+	entries := make(chan *ServiceEntry, 4)
+	go Lookup(context.Background(), "_peer._tcp", WithTimeout(time.Second), WithEntriesChannel(entries), WithPeers(peer.LocalAddr().(*net.UDPAddr)))
+
+	buf := make([]byte, 65536)
+	n, _, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("no query: %v", err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(m.Question) == 0 || m.Question[0].Name != "_peer._tcp.local." {
+		t.Errorf("bad query: %v", m)
+	}
+}
+
+func TestClient_PeersResolve(t *testing.T) {
+	peer := listenPeer(t)
+	defer peer.Close()
+	go Resolve(context.Background(), "web._peer._tcp.local.", WithTimeout(time.Second), WithPeers(peer.LocalAddr().(*net.UDPAddr)))
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("no query: %v", err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(m.Question) == 0 || m.Question[0].Name != "web._peer._tcp.local." {
+		t.Errorf("bad query: %v", m)
+	}
+}
@@ -0,0 +1,167 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// probeInterval is the time between probes, and waited after the last one
+// for answers (RFC 6762 section 8.1).
+const probeInterval = 250 * time.Millisecond
+
+// ErrServerClosed is returned by AnnounceAndWait and ProbeAndWait when the
+// server shuts down before they finish.
+var ErrServerClosed = errors.New("mdns: server closed")
+
+// ProbeResult is the outcome of ProbeAndWait.
+type ProbeResult struct {
+	// Conflicts are the NameConflict events reported while probing, for
+	// names claimed by another responder with a different host or port.
+	// The names are free if there are none.
+	Conflicts []ServerEvent
+}
+
+// Conflict reports whether another responder claimed one of the names
+// probed for.
+func (r ProbeResult) Conflict() bool {
+	return len(r.Conflicts) > 0
+}
+
+// ProbeAndWait probes for the instance names of the services of the
+// server's zone, an MDNSService or a ServiceSet, and returns once the probes
+// have been sent and answered, or another responder has claimed one of the
+// names.  It returns ctx's error if ctx is done first, and ErrServerClosed
+// if the server shuts down first.  Nothing is probed for by servers
+// publishing through the system responder or with Config.UnicastOnly set.
+func (s *Server) ProbeAndWait(ctx context.Context) (ProbeResult, error) {
+	config := s.conf()
+	svcs := zoneServices(config.Zone)
+	if len(svcs) == 0 || s.system != nil || config.UnicastOnly {
+		return ProbeResult{}, nil
+	}
+	return s.probeServices(ctx, svcs)
+}
+
+// AnnounceAndWait announces the services of the server's zone, an
+// MDNSService or a ServiceSet, as Announce does, and returns once the
+// announcements have all been sent, so that the caller can then report the
+// services ready.  It returns ctx's error if ctx is done first, leaving the
+// announcements to finish in the background, and ErrServerClosed if the
+// server shuts down first.
+func (s *Server) AnnounceAndWait(ctx context.Context) error {
+	config := s.conf()
+	if config.UnicastOnly {
+		return nil
+	}
+	select {
+	case ok := <-s.announceServices(zoneServices(config.Zone), nil):
+		if !ok {
+			return ErrServerClosed
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeServices multicasts probes for the names of svcs three times, after
+// a random delay of up to 250ms, then 250ms apart, and waits a further 250ms
+// for answers.  It stops early when a conflict is reported, ctx is done or
+// the server shuts down.
+func (s *Server) probeServices(ctx context.Context, svcs []*MDNSService) (ProbeResult, error) {
+	var result ProbeResult
+	conflicts := s.watchConflicts()
+	defer s.unwatchConflicts(conflicts)
+	for _, svc := range svcs {
+		s.event(ServerEvent{Type: ProbeStarted, Service: svc})
+	}
+
+	q := probeQuery(svcs)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(probeInterval))))
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		select {
+		case ev := <-conflicts:
+			result.Conflicts = append(result.Conflicts, ev)
+			for len(conflicts) > 0 {
+				result.Conflicts = append(result.Conflicts, <-conflicts)
+			}
+			return result, nil
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-s.shutdownCh:
+			return result, ErrServerClosed
+		case <-timer.C:
+		}
+		if i == 3 {
+			return result, nil
+		}
+		if err := s.multicastResponse(q); err != nil {
+			log.Println("[ERR] mdns: failed to send probe:", err.Error())
+		}
+		timer.Reset(probeInterval)
+	}
+}
+
+// probeQuery returns a query for the instance names of svcs, with the SRV
+// and TXT records claimed for them in its authority section, as described
+// in section 8.1 of RFC 6762.
+func probeQuery(svcs []*MDNSService) *dns.Msg {
+	q := new(dns.Msg)
+	for _, svc := range svcs {
+		question := dns.Question{Name: svc.instanceAddr, Qtype: dns.TypeANY, Qclass: dns.ClassINET}
+		q.Question = append(q.Question, question)
+		for _, rr := range svc.Records(question) {
+			switch rr.(type) {
+			case *dns.SRV, *dns.TXT:
+				q.Ns = append(q.Ns, rr)
+			}
+		}
+	}
+	return q
+}
+
+// watchConflicts returns a channel receiving the NameConflict events
+// reported until it is passed to unwatchConflicts.
+func (s *Server) watchConflicts() chan ServerEvent {
+	ch := make(chan ServerEvent, 8)
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+	if s.watches == nil {
+		s.watches = make(map[chan ServerEvent]struct{})
+	}
+	s.watches[ch] = struct{}{}
+	atomic.AddInt32(&s.watching, 1)
+	return ch
+}
+
+// unwatchConflicts stops reporting conflicts to ch.
+func (s *Server) unwatchConflicts(ch chan ServerEvent) {
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+	delete(s.watches, ch)
+	atomic.AddInt32(&s.watching, -1)
+}
+
+// conflict reports ev, a NameConflict, to Config.OnEvent and to the
+// channels of watchConflicts, dropping it for those that are full.
+func (s *Server) conflict(ev ServerEvent) {
+	s.event(ev)
+	if atomic.LoadInt32(&s.watching) == 0 {
+		return
+	}
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+	for ch := range s.watches {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestProbeQuery(t *testing.T) {
+	svc := makeService(t)
+	q := probeQuery([]*MDNSService{svc})
+	if len(q.Question) != 1 || q.Question[0].Name != svc.instanceAddr || q.Question[0].Qtype != dns.TypeANY {
+		t.Fatalf("bad questions: %v", q.Question)
+	}
+	if len(q.Ns) != 2 {
+		t.Fatalf("bad authority records: %v", q.Ns)
+	}
+	if _, ok := q.Ns[0].(*dns.SRV); !ok {
+		t.Errorf("first authority record is %v, want an SRV record", q.Ns[0])
+	}
+	if _, ok := q.Ns[1].(*dns.TXT); !ok {
+		t.Errorf("second authority record is %v, want a TXT record", q.Ns[1])
+	}
+}
+
+func TestServer_ProbeAndWaitConflict(t *testing.T) {
+	svc := makeService(t)
+	s := &Server{config: &Config{Zone: svc, UnicastOnly: true}, metrics: new(ServerMetrics)}
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	go func() {
+		for atomic.LoadInt32(&s.watching) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		msg := new(dns.Msg)
+		msg.Answer = []dns.RR{&dns.SRV{
+			Hdr:    dns.RR_Header{Name: svc.instanceAddr, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+			Target: "other.local.",
+			Port:   80,
+		}}
+		s.checkConflicts(msg, from)
+	}()
+
+	start := time.Now()
+	result, err := s.probeServices(context.Background(), []*MDNSService{svc})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !result.Conflict() || result.Conflicts[0].From != from {
+		t.Fatalf("bad result: %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("probing did not stop at the conflict: %v", elapsed)
+	}
+}
+
+func TestServer_AnnounceAndWait(t *testing.T) {
+	set, err := NewServiceSet(makeService(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := serv.ProbeAndWait(ctx)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Conflict() {
+		t.Fatalf("unexpected conflicts: %+v", result.Conflicts)
+	}
+	if err := serv.AnnounceAndWait(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	serv.Shutdown()
+	if err := serv.AnnounceAndWait(ctx); err != ErrServerClosed {
+		t.Errorf("got %v after shutdown, want %v", err, ErrServerClosed)
+	}
+}
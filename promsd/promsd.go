@@ -0,0 +1,271 @@
+// Package promsd exposes the services browsed with mDNS as Prometheus scrape
+// targets, through HTTP service discovery or a file for file-based service
+// discovery, so that exporters advertised on a LAN are scraped without
+// listing them in the Prometheus configuration.
+//
+// Each instance becomes a target group with a single target, its address and
+// port, and these labels:
+//
+//     __meta_mdns_name       the instance name, e.g. "node._prometheus-http._tcp.local."
+//     __meta_mdns_service    the service type, e.g. "_prometheus-http._tcp"
+//     __meta_mdns_host       the host name
+//     __meta_mdns_txt_<key>  the value of each key of the TXT record
+//
+// TXT keys can also be mapped to other labels with Config.Labels, for
+// example "path" to "__metrics_path__".  The exporter is served with
+//
+//     e, err := promsd.New(&promsd.Config{Labels: map[string]string{"path": "__metrics_path__"}})
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer e.Close()
+//     http.Handle("/mdns-sd", e)
+//
+// and used in the Prometheus configuration as
+//
+//     scrape_configs:
+//       - job_name: mdns
+//         http_sd_configs:
+//           - url: http://localhost:9354/mdns-sd
+package promsd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+	"golang.org/x/net/context"
+)
+
+// DefaultService is the service type browsed when Config.Services is empty,
+// under which Prometheus exporters conventionally advertise themselves.
+const DefaultService = "_prometheus-http._tcp"
+
+// Config is used to configure an Exporter.
+type Config struct {
+	// Services are the service types to browse, default DefaultService.
+	Services []string
+
+	// Labels maps TXT keys to the labels their values are given in, in
+	// addition to the __meta_mdns_txt_<key> labels.  Keys are compared
+	// case-insensitively.
+	Labels map[string]string
+
+	// File, if set, is the path that the targets are written to in the
+	// format of Prometheus' file_sd_configs, whenever they change.
+	File string
+
+	// Options are used for browsing, for example mdns.WithInterface.
+	Options []mdns.QueryOption
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// TargetGroup is a group of scrape targets that share labels, in the JSON
+// form used by HTTP and file-based service discovery.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Exporter browses for services and serves them as Prometheus HTTP service
+// discovery targets.  It browses from the time it is created until Close is
+// called.
+type Exporter struct {
+	config   *Config
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	browsers []*mdns.Browser
+	changed  chan struct{} // Signalled when the targets change
+}
+
+// New creates an exporter and starts browsing.
+func New(config *Config) (*Exporter, error) {
+	services := config.Services
+	if len(services) == 0 {
+		services = []string{DefaultService}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Exporter{
+		config:  config,
+		cancel:  cancel,
+		changed: make(chan struct{}, 1),
+	}
+	for _, service := range services {
+		b, err := mdns.NewBrowser(ctx, service, config.Options...)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		e.browsers = append(e.browsers, b)
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			for range b.Events() {
+				select {
+				case e.changed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+	if config.File != "" {
+		e.wg.Add(1)
+		go e.writeFiles(ctx)
+	}
+	return e, nil
+}
+
+// Close stops browsing.
+func (e *Exporter) Close() {
+	e.cancel()
+	for _, b := range e.browsers {
+		b.Close()
+	}
+	e.wg.Wait()
+}
+
+func (e *Exporter) logf(format string, v ...interface{}) {
+	if e.config.Logger != nil {
+		e.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// Targets returns a target group for each instance currently known, sorted
+// by instance name.  Instances without an address are left out.
+func (e *Exporter) Targets() []*TargetGroup {
+	groups := []*TargetGroup{}
+	for _, b := range e.browsers {
+		for _, entry := range b.Entries() {
+			if g := e.group(entry); g != nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Labels["__meta_mdns_name"] < groups[j].Labels["__meta_mdns_name"]
+	})
+	return groups
+}
+
+// group returns the target group of an instance, or nil if it has no
+// address.
+func (e *Exporter) group(entry *mdns.ServiceEntry) *TargetGroup {
+	var host string
+	switch {
+	case entry.AddrV4 != nil:
+		host = entry.AddrV4.String()
+	case entry.AddrV6 != nil:
+		host = (&net.IPAddr{IP: entry.AddrV6, Zone: entry.Zone}).String()
+	default:
+		return nil
+	}
+	labels := map[string]string{
+		"__meta_mdns_name":    entry.Name,
+		"__meta_mdns_service": serviceType(entry.Name),
+		"__meta_mdns_host":    entry.Host,
+	}
+	txt := entry.TXTMap()
+	for key, value := range txt {
+		labels["__meta_mdns_txt_"+labelName(key)] = value
+	}
+	for key, label := range e.config.Labels {
+		if value, ok := txt[strings.ToLower(key)]; ok {
+			labels[label] = value
+		}
+	}
+	return &TargetGroup{
+		Targets: []string{net.JoinHostPort(host, fmt.Sprint(entry.Port))},
+		Labels:  labels,
+	}
+}
+
+// serviceType returns the service type of an instance name, such as
+// "_http._tcp" for "web._http._tcp.local.".
+func serviceType(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := len(labels) - 1; i > 0; i-- {
+		if l := labels[i]; l == "_tcp" || l == "_udp" {
+			return labels[i-1] + "." + l
+		}
+	}
+	return ""
+}
+
+// labelName turns a TXT key into a valid Prometheus label name, replacing the
+// characters that are not allowed with underscores.
+func labelName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// ServeHTTP serves the targets as the response to an HTTP service discovery
+// request.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.Targets()); err != nil {
+		e.logf("[ERR] mdns: Failed to write targets: %v", err)
+	}
+}
+
+// WriteFile writes the targets to path in the format of file-based service
+// discovery.  The file is replaced atomically, so Prometheus never reads a
+// partly written file.
+func (e *Exporter) WriteFile(path string) error {
+	data, err := json.MarshalIndent(e.Targets(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeFiles writes Config.File at start and whenever the targets change.
+func (e *Exporter) writeFiles(ctx context.Context) {
+	defer e.wg.Done()
+	for {
+		if err := e.WriteFile(e.config.File); err != nil {
+			e.logf("[ERR] mdns: Failed to write %s: %v", e.config.File, err)
+		}
+		select {
+		case <-e.changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
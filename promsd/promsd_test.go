@@ -0,0 +1,89 @@
+package promsd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/micro/mdns"
+)
+
+func TestExporter(t *testing.T) {
+	zone, err := mdns.NewMDNSService("node", "_prometheus-http._tcp", "local.", "testhost.", 9100,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/metrics", "Job-Name=node"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	file := filepath.Join(t.TempDir(), "mdns.json")
+	e, err := New(&Config{Labels: map[string]string{"path": "__metrics_path__"}, File: file})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer e.Close()
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	var groups []*TargetGroup
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		resp, err := ts.Client().Get(ts.URL)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&groups)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(groups) == 1 {
+			break
+		}
+	}
+	if len(groups) != 1 {
+		t.Fatalf("target not found: %+v", groups)
+	}
+	g := groups[0]
+	if len(g.Targets) != 1 || g.Targets[0] != "192.168.0.42:9100" {
+		t.Errorf("bad targets: %v", g.Targets)
+	}
+	want := map[string]string{
+		"__meta_mdns_name":         "node._prometheus-http._tcp.local.",
+		"__meta_mdns_service":      "_prometheus-http._tcp",
+		"__meta_mdns_host":         "testhost.",
+		"__meta_mdns_txt_path":     "/metrics",
+		"__meta_mdns_txt_job_name": "node",
+		"__metrics_path__":         "/metrics",
+	}
+	for k, v := range want {
+		if g.Labels[k] != v {
+			t.Errorf("label %s is %q, want %q", k, g.Labels[k], v)
+		}
+	}
+
+	// The file is rewritten once the target is found.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(50 * time.Millisecond) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var fromFile []*TargetGroup
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(fromFile) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("target not written to file: %s", data)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package mdns
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// pushRecordTTL is the TTL given to records learned from a DNS Push server.
+// They stay valid until the server deletes them or the session ends, when
+// goodbyes are generated for them.
+const pushRecordTTL = 24 * 60 * 60
+
+// pushRetryMax is the longest wait before reconnecting to a DNS Push server.
+const pushRetryMax = time.Minute
+
+// pushClient is a session with a DNS Push Notification server, as described
+// in RFC 8765.
+type pushClient struct {
+	conn    net.Conn
+	pushes  chan []dns.RR // Records from PUSH messages
+	closing chan struct{} // Closed by close
+	done    chan struct{} // Closed when the session ends
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan *dsoMsg // Requests awaiting responses, by id
+	err     error                   // Why the session ended
+	closed  bool
+}
+
+func newPushClient(conn net.Conn) *pushClient {
+	p := &pushClient{
+		conn:    conn,
+		pushes:  make(chan []dns.RR),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		pending: make(map[uint16]chan *dsoMsg),
+	}
+	go p.read()
+	return p
+}
+
+// close ends the session.
+func (p *pushClient) close() {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+	}
+	p.lock.Unlock()
+	p.conn.Close()
+	<-p.done
+}
+
+// read handles the server's messages until the session ends.
+func (p *pushClient) read() {
+	defer close(p.done)
+	fail := func(err error) {
+		p.lock.Lock()
+		if !p.closed {
+			p.err = err
+		}
+		p.lock.Unlock()
+		p.conn.Close()
+	}
+
+	for {
+		packet, err := readFrame(p.conn)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !isDSO(packet) {
+			// Answers to ordinary queries are not used.
+			continue
+		}
+		m, err := parseDSO(packet)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if m.response {
+			p.lock.Lock()
+			ch := p.pending[m.id]
+			delete(p.pending, m.id)
+			p.lock.Unlock()
+			if ch != nil {
+				ch <- m
+			}
+			continue
+		}
+
+		tlv, ok := m.primary()
+		if !ok {
+			fail(fmt.Errorf("mdns: DSO message without a TLV"))
+			return
+		}
+		switch tlv.typ {
+		case dsoTypePush:
+			recs, err := unpackRecords(tlv.data)
+			if err != nil {
+				fail(err)
+				return
+			}
+			select {
+			case p.pushes <- recs:
+			case <-p.closing:
+				return
+			}
+		case dsoTypeRetryDelay:
+			fail(fmt.Errorf("mdns: DNS Push server ended the session"))
+			return
+		default:
+			if m.id != 0 {
+				reply := &dsoMsg{id: m.id, response: true, rcode: dns.RcodeStatefulTypeNotImplemented}
+				p.write(reply.pack())
+			}
+		}
+	}
+}
+
+// write sends a message to the server.
+func (p *pushClient) write(packet []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	return writeFrame(p.conn, packet)
+}
+
+// request sends a request with the given primary TLV and waits for the
+// response.
+func (p *pushClient) request(ctx context.Context, tlv dsoTLV) (*dsoMsg, error) {
+	ch := make(chan *dsoMsg, 1)
+	p.lock.Lock()
+	if p.nextID++; p.nextID == 0 {
+		p.nextID++
+	}
+	id := p.nextID
+	p.pending[id] = ch
+	p.lock.Unlock()
+
+	if err := p.write((&dsoMsg{id: id, tlvs: []dsoTLV{tlv}}).pack()); err != nil {
+		return nil, err
+	}
+	select {
+	case m := <-ch:
+		if m.rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("mdns: DNS Push server returned %s", dns.RcodeToString[m.rcode])
+		}
+		return m, nil
+	case <-p.done:
+		return nil, fmt.Errorf("mdns: DNS Push session ended")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// keepalive exchanges Keepalive TLVs with the server, which establishes the
+// session, and returns the interval at which the server wants keepalive
+// traffic.
+func (p *pushClient) keepalive(ctx context.Context) (time.Duration, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(data[4:], 0xFFFFFFFF)
+	m, err := p.request(ctx, dsoTLV{typ: dsoTypeKeepalive, data: data})
+	if err != nil {
+		return 0, err
+	}
+	interval := pushKeepaliveInterval
+	if tlv, ok := m.primary(); ok && tlv.typ == dsoTypeKeepalive && len(tlv.data) == 8 {
+		interval = time.Duration(binary.BigEndian.Uint32(tlv.data[4:])) * time.Millisecond
+	}
+	// The minimum keepalive interval is ten seconds, as per section 6.5.2
+	// of RFC 8490.
+	if interval < 10*time.Second {
+		interval = 10 * time.Second
+	}
+	return interval, nil
+}
+
+// subscribe asks for the records matching q and returns the id of the
+// subscription.  Records are sent to p.pushes.
+func (p *pushClient) subscribe(ctx context.Context, q dns.Question) (uint16, error) {
+	data, err := packQuestion(q)
+	if err != nil {
+		return 0, err
+	}
+	m, err := p.request(ctx, dsoTLV{typ: dsoTypeSubscribe, data: data})
+	if err != nil {
+		return 0, err
+	}
+	return m.id, nil
+}
+
+// unsubscribe cancels a subscription.
+func (p *pushClient) unsubscribe(id uint16) error {
+	var data [2]byte
+	binary.BigEndian.PutUint16(data[:], id)
+	return p.write((&dsoMsg{tlvs: []dsoTLV{{typ: dsoTypeUnsubscribe, data: data[:]}}}).pack())
+}
+
+// discoverPushServer finds the DNS Push server of a domain from its
+// "_dns-push-tls._tcp" SRV record, as described in section 6.1 of RFC 8765,
+// and returns its address as "host:port".
+func discoverPushServer(ctx context.Context, servers []string, domain string) (string, error) {
+	name := "_dns-push-tls._tcp." + trimDot(domain) + "."
+	resp, err := unicastExchange(ctx, servers, name, dns.TypeSRV)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range resp.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			return net.JoinHostPort(trimDot(srv.Target), fmt.Sprint(srv.Port)), nil
+		}
+	}
+	return "", fmt.Errorf("mdns: no DNS Push server for %s", domain)
+}
+
+// pushBrowse browses for instances of service by subscribing to a DNS Push
+// server, reconnecting when the session fails, until ctx is done.  The
+// records pushed are sent to msgCh as responses, so that they are assembled
+// and delivered like multicast responses.
+func (c *client) pushBrowse(ctx context.Context, addr string, config *tls.Config, service string, msgCh chan<- *received) {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := c.pushSession(ctx, addr, config, service, msgCh)
+		if ctx.Err() != nil {
+			return
+		}
+		c.logf("[ERR] mdns: DNS Push session with %s failed: %v", addr, err)
+		if time.Since(start) > pushRetryMax {
+			delay = time.Second
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if delay *= 2; delay > pushRetryMax {
+			delay = pushRetryMax
+		}
+	}
+}
+
+// pushSession runs one session of pushBrowse.  It subscribes to the PTR
+// records of the service, to every record of each instance found, and to the
+// addresses of their hosts.
+func (c *client) pushSession(ctx context.Context, addr string, config *tls.Config, service string, msgCh chan<- *received) error {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: wideAreaTimeout}, "tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	p := newPushClient(conn)
+	defer p.close()
+
+	held := make(map[string]dns.RR) // Records pushed, by cacheKey
+	defer func() {
+		// Without the session the records can no longer be trusted.
+		m := new(dns.Msg)
+		m.Response = true
+		for _, rr := range held {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = 0
+			m.Answer = append(m.Answer, rr)
+		}
+		if len(m.Answer) > 0 {
+			select {
+			case msgCh <- &received{msg: m, at: time.Now()}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	interval, err := p.keepalive(ctx)
+	if err != nil {
+		return err
+	}
+	keepalive := time.NewTicker(interval)
+	defer keepalive.Stop()
+	if _, err := p.subscribe(ctx, dns.Question{Name: service, Qtype: dns.TypePTR, Qclass: dns.ClassINET}); err != nil {
+		return err
+	}
+
+	// Further subscriptions are made in the background, as their responses
+	// may queue behind PUSH messages.
+	type subResult struct {
+		name string
+		id   uint16
+		err  error
+	}
+	subCh := make(chan subResult, 8)
+	subs := make(map[string]uint16) // Subscription ids by name, 0 while pending
+	subscribe := func(name string) {
+		key := strings.ToLower(name)
+		if _, ok := subs[key]; ok {
+			return
+		}
+		subs[key] = 0
+		go func() {
+			id, err := p.subscribe(ctx, dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+			select {
+			case subCh <- subResult{key, id, err}:
+			case <-p.done:
+			}
+		}()
+	}
+
+	for {
+		select {
+		case recs := <-p.pushes:
+			m := new(dns.Msg)
+			m.Response = true
+			withdraw := func(name string, match func(dns.RR) bool) {
+				for key, rr := range held {
+					if strings.EqualFold(rr.Header().Name, name) && match(rr) {
+						rr = dns.Copy(rr)
+						rr.Header().Ttl = 0
+						delete(held, key)
+						m.Answer = append(m.Answer, rr)
+					}
+				}
+			}
+			for _, rr := range recs {
+				hdr := rr.Header()
+				switch hdr.Ttl {
+				case pushDeleteRecord:
+					rr = dns.Copy(rr)
+					rr.Header().Ttl = 0
+					delete(held, cacheKey(rr))
+					m.Answer = append(m.Answer, rr)
+					if ptr, ok := rr.(*dns.PTR); ok && strings.EqualFold(hdr.Name, service) {
+						// The server stops pushing the instance's records
+						// once unsubscribed, so withdraw them here.
+						key := strings.ToLower(ptr.Ptr)
+						if id := subs[key]; id != 0 {
+							p.unsubscribe(id)
+						}
+						delete(subs, key)
+						withdraw(ptr.Ptr, func(dns.RR) bool { return true })
+					}
+				case pushDeleteRRSet:
+					withdraw(hdr.Name, func(rr dns.RR) bool {
+						return hdr.Class == dns.ClassANY || rr.Header().Rrtype == hdr.Rrtype
+					})
+				default:
+					rr = dns.Copy(rr)
+					rr.Header().Ttl = pushRecordTTL
+					held[cacheKey(rr)] = rr
+					m.Answer = append(m.Answer, rr)
+					switch rr := rr.(type) {
+					case *dns.PTR:
+						if strings.EqualFold(hdr.Name, service) {
+							subscribe(rr.Ptr)
+						}
+					case *dns.SRV:
+						subscribe(rr.Target)
+					}
+				}
+			}
+			if len(m.Answer) > 0 {
+				select {
+				case msgCh <- &received{msg: m, at: time.Now()}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+		case r := <-subCh:
+			if r.err != nil {
+				return r.err
+			}
+			if _, ok := subs[r.name]; ok {
+				subs[r.name] = r.id
+			} else {
+				// The instance went away while subscribing.
+				p.unsubscribe(r.id)
+			}
+
+		case <-keepalive.C:
+			go p.keepalive(ctx)
+
+		case <-p.done:
+			return p.err
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mdns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBrowser_PushServer(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_pushbrowse._tcp", "local.", "pushhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	// Borrow the test certificate of httptest, which is valid for
+	// "example.com".
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	push, err := NewPushServer(&PushServerConfig{Domain: "home.example.com"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", ts.TLS)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	go push.Serve(l)
+	defer push.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b, err := NewBrowser(ctx, "_pushbrowse._tcp",
+		WithDomain("home.example.com"),
+		WithPushServer(l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "example.com"}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+
+	ev := <-b.Events()
+	if ev == nil || ev.Type != ServiceAdded {
+		t.Fatalf("bad event: %+v", ev)
+	}
+	e := ev.Entry
+	if e.Name != "hostname._pushbrowse._tcp.home.example.com." || e.Host != "pushhost.home.example.com." || e.Port != 80 {
+		t.Fatalf("bad entry: %+v", e)
+	}
+	if !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Errorf("bad address: %v", e.AddrV4)
+	}
+
+	// The goodbye on the local link is pushed, and removes the instance.
+	serv.Shutdown()
+	for ev := range b.Events() {
+		if ev.Type == ServiceRemoved {
+			if ev.Entry.Name != e.Name {
+				t.Errorf("bad removal: %+v", ev.Entry)
+			}
+			return
+		}
+	}
+	t.Fatalf("instance not removed")
+}
@@ -0,0 +1,392 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// pushKeepaliveInterval is the keepalive interval a PushServer asks clients
+// to use.  Sessions with subscriptions are never closed for inactivity.
+const pushKeepaliveInterval = 15 * time.Minute
+
+// PushServerConfig is used to configure a PushServer.
+type PushServerConfig struct {
+	// Domain is the unicast DNS domain served, such as "home.example.com",
+	// with the same meaning as for a DiscoveryProxy.  Required.
+	Domain string
+
+	// Interface is the multicast interface to query, default is the system's.
+	Interface *net.Interface
+
+	// Timeout is how long ordinary queries sent over a session wait for
+	// multicast answers, default 1 second.
+	Timeout time.Duration
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// PushServer is a DNS Push Notification server, as described in RFC 8765.
+// Clients connect over a stream, normally TLS, and subscribe to names in the
+// server's domain; the server finds the matching records on the local link
+// with multicast DNS, sends them, and then sends each record that appears or
+// goes away for as long as the subscription lasts.  Names are mapped between
+// the server's domain and "local." like a DiscoveryProxy, which also answers
+// ordinary queries sent over the same connection.
+//
+// The server does not handle TLS itself; pass it a listener from tls.Listen
+// or tls.NewListener.
+type PushServer struct {
+	config *PushServerConfig
+	proxy  *DiscoveryProxy
+
+	lock      sync.Mutex
+	listeners map[net.Listener]bool
+	sessions  map[*pushSession]bool
+	shutdown  bool
+	wg        sync.WaitGroup
+}
+
+// NewPushServer returns a server for the configured domain.  Call Serve to
+// accept connections.
+func NewPushServer(config *PushServerConfig) (*PushServer, error) {
+	proxy, err := NewDiscoveryProxy(&DiscoveryProxyConfig{
+		Domain:    config.Domain,
+		Interface: config.Interface,
+		Timeout:   config.Timeout,
+		Logger:    config.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PushServer{
+		config:    config,
+		proxy:     proxy,
+		listeners: make(map[net.Listener]bool),
+		sessions:  make(map[*pushSession]bool),
+	}, nil
+}
+
+// Serve accepts connections on l until it fails or the server is shut down.
+func (s *PushServer) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.shutdown {
+		s.lock.Unlock()
+		return fmt.Errorf("mdns: push server is shut down")
+	}
+	s.listeners[l] = true
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			delete(s.listeners, l)
+			if s.shutdown {
+				return nil
+			}
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sess := &pushSession{
+			server: s,
+			conn:   conn,
+			ctx:    ctx,
+			cancel: cancel,
+			subs:   make(map[uint16]context.CancelFunc),
+		}
+		s.lock.Lock()
+		if s.shutdown {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.sessions[sess] = true
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go sess.serve()
+	}
+}
+
+// Shutdown closes the server's listeners and sessions.
+func (s *PushServer) Shutdown() error {
+	s.lock.Lock()
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for sess := range s.sessions {
+		sess.close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *PushServer) logf(format string, v ...interface{}) {
+	if s.config.Logger != nil {
+		s.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// pushSession is a client's connection to a PushServer.
+type pushSession struct {
+	server *PushServer
+	conn   net.Conn
+	ctx    context.Context // Done when the session ends
+	cancel context.CancelFunc
+
+	writeLock sync.Mutex
+
+	lock sync.Mutex
+	subs map[uint16]context.CancelFunc // By SUBSCRIBE message id
+	wg   sync.WaitGroup
+}
+
+// close ends the session.
+func (p *pushSession) close() {
+	p.cancel()
+	p.conn.Close()
+}
+
+// write sends a message to the client, and ends the session if that fails.
+func (p *pushSession) write(packet []byte) {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	if err := writeFrame(p.conn, packet); err != nil {
+		p.close()
+	}
+}
+
+// serve reads and handles the client's messages until the session ends.
+func (p *pushSession) serve() {
+	defer p.server.wg.Done()
+	defer func() {
+		p.close()
+		p.wg.Wait()
+		p.server.lock.Lock()
+		delete(p.server.sessions, p)
+		p.server.lock.Unlock()
+	}()
+
+	for {
+		packet, err := readFrame(p.conn)
+		if err != nil {
+			return
+		}
+		if !isDSO(packet) {
+			var r dns.Msg
+			if err := r.Unpack(packet); err != nil {
+				return
+			}
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				if out, err := p.server.proxy.answer(&r).Pack(); err == nil {
+					p.write(out)
+				}
+			}()
+			continue
+		}
+
+		m, err := parseDSO(packet)
+		if err != nil {
+			p.server.logf("[ERR] mdns: Bad DSO message from %v: %v", p.conn.RemoteAddr(), err)
+			return
+		}
+		if !p.handle(m) {
+			return
+		}
+	}
+}
+
+// handle acts on a DSO message, and returns false if the session must end.
+func (p *pushSession) handle(m *dsoMsg) bool {
+	if m.response {
+		// The server sends no requests, so expects no responses.
+		return false
+	}
+	tlv, ok := m.primary()
+	if !ok {
+		return false
+	}
+	reply := &dsoMsg{id: m.id, response: true}
+
+	switch tlv.typ {
+	case dsoTypeKeepalive:
+		if m.id == 0 || len(tlv.data) != 8 {
+			return false
+		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, 0xFFFFFFFF) // No inactivity timeout
+		binary.BigEndian.PutUint32(data[4:], uint32(pushKeepaliveInterval/time.Millisecond))
+		reply.tlvs = []dsoTLV{{typ: dsoTypeKeepalive, data: data}}
+
+	case dsoTypeSubscribe:
+		if m.id == 0 {
+			return false
+		}
+		q, err := unpackQuestion(tlv.data)
+		if err != nil {
+			reply.rcode = dns.RcodeFormatError
+			break
+		}
+		local, ok := p.server.proxy.toLocal(q.Name)
+		if !ok {
+			reply.rcode = dns.RcodeRefused
+			break
+		}
+		// The response must reach the client before any PUSH message, so
+		// hold the write lock until it is sent.
+		p.writeLock.Lock()
+		defer p.writeLock.Unlock()
+		p.lock.Lock()
+		_, dup := p.subs[m.id]
+		if !dup {
+			ctx, cancel := context.WithCancel(p.ctx)
+			p.subs[m.id] = cancel
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				p.watch(ctx, local, q.Qtype)
+			}()
+		}
+		p.lock.Unlock()
+		if dup {
+			return false
+		}
+		return writeFrame(p.conn, reply.pack()) == nil
+
+	case dsoTypeUnsubscribe:
+		if m.id != 0 || len(tlv.data) != 2 {
+			return false
+		}
+		id := binary.BigEndian.Uint16(tlv.data)
+		p.lock.Lock()
+		if cancel, ok := p.subs[id]; ok {
+			cancel()
+			delete(p.subs, id)
+		}
+		p.lock.Unlock()
+		return true
+
+	case dsoTypeReconfirm:
+		// Records are checked continuously with multicast queries, which
+		// already remove records that stop being answered.
+		return m.id == 0
+
+	default:
+		if m.id == 0 {
+			return true
+		}
+		reply.rcode = dns.RcodeStatefulTypeNotImplemented
+	}
+	p.write(reply.pack())
+	return true
+}
+
+// watch keeps the records of a name in the local domain up to date with
+// continuous multicast queries, and pushes every change to the client, until
+// ctx is done.
+func (p *pushSession) watch(ctx context.Context, name string, qtype uint16) {
+	config := p.server.config
+	client, err := newClient(config.Logger, nil)
+	if err != nil {
+		p.server.logf("[ERR] mdns: Failed to watch %s: %v", name, err)
+		return
+	}
+	defer client.Close()
+	if config.Interface != nil {
+		if err := client.setInterface(config.Interface, false); err != nil {
+			p.server.logf("[ERR] mdns: Failed to watch %s: %v", name, err)
+			return
+		}
+	}
+
+	msgCh := make(chan *received, 32)
+	go client.recv(client.ipv4UnicastConn, msgCh)
+	go client.recv(client.ipv6UnicastConn, msgCh)
+	go client.recv(client.ipv4MulticastConn, msgCh)
+	go client.recv(client.ipv6MulticastConn, msgCh)
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.RecursionDesired = false
+
+	interval := time.Second
+	query := time.NewTimer(0)
+	defer query.Stop()
+
+	// Records expire without any packet arriving, so check them regularly.
+	expiry := time.NewTicker(time.Second)
+	defer expiry.Stop()
+
+	cache := NewCache()
+	sent := make(map[string]dns.RR) // Records the client has, by cacheKey
+	push := func() {
+		current := make(map[string]dns.RR)
+		for _, rr := range cache.Lookup(name, qtype) {
+			current[cacheKey(rr)] = rr
+		}
+		var changes []dns.RR
+		for key, rr := range current {
+			if _, ok := sent[key]; ok {
+				continue
+			}
+			if rr, ok := p.server.proxy.rename(rr); ok {
+				changes = append(changes, rr)
+			}
+		}
+		for key, rr := range sent {
+			if _, ok := current[key]; ok {
+				continue
+			}
+			if rr, ok := p.server.proxy.rename(rr); ok {
+				rr.Header().Ttl = pushDeleteRecord
+				changes = append(changes, rr)
+			}
+		}
+		sent = current
+		if len(changes) == 0 {
+			return
+		}
+		data, err := packRecords(changes)
+		if err != nil {
+			p.server.logf("[ERR] mdns: Failed to pack records of %s: %v", name, err)
+			return
+		}
+		p.write((&dsoMsg{tlvs: []dsoTLV{{typ: dsoTypePush, data: data}}}).pack())
+	}
+
+	for {
+		select {
+		case <-query.C:
+			if err := client.sendQuery(q); err != nil {
+				client.logf("[ERR] mdns: Failed to query %s: %v", name, err)
+			}
+			query.Reset(interval)
+			if interval *= 2; interval > maxQueryInterval {
+				interval = maxQueryInterval
+			}
+		case r := <-msgCh:
+			if r.msg.Response {
+				cache.addMsg(r.msg, r.from)
+				push()
+			}
+		case <-expiry.C:
+			push()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPushServer(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_pushtest._tcp", "local.", "pushhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	push, err := NewPushServer(&PushServerConfig{Domain: "home.example.com", Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	go push.Serve(l)
+	defer push.Shutdown()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	send := func(m *dsoMsg) {
+		if err := writeFrame(conn, m.pack()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	recv := func() *dsoMsg {
+		packet, err := readFrame(conn)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		m, err := parseDSO(packet)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return m
+	}
+
+	send(&dsoMsg{id: 1, tlvs: []dsoTLV{{typ: dsoTypeKeepalive, data: make([]byte, 8)}}})
+	if m := recv(); m.id != 1 || !m.response || m.rcode != 0 || len(m.tlvs) != 1 || m.tlvs[0].typ != dsoTypeKeepalive {
+		t.Fatalf("bad keepalive response: %+v", m)
+	}
+
+	data, _ := packQuestion(dns.Question{Name: "_pushtest._tcp.example.com.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	send(&dsoMsg{id: 2, tlvs: []dsoTLV{{typ: dsoTypeSubscribe, data: data}}})
+	if m := recv(); m.id != 2 || m.rcode != dns.RcodeRefused {
+		t.Fatalf("subscription outside the domain not refused: %+v", m)
+	}
+
+	data, _ = packQuestion(dns.Question{Name: "_pushtest._tcp.home.example.com.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	send(&dsoMsg{id: 3, tlvs: []dsoTLV{{typ: dsoTypeSubscribe, data: data}}})
+	if m := recv(); m.id != 3 || !m.response || m.rcode != 0 {
+		t.Fatalf("bad subscribe response: %+v", m)
+	}
+
+	pushed := func() *dns.PTR {
+		m := recv()
+		if m.id != 0 || len(m.tlvs) != 1 || m.tlvs[0].typ != dsoTypePush {
+			t.Fatalf("bad push: %+v", m)
+		}
+		recs, err := unpackRecords(m.tlvs[0].data)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(recs) != 1 {
+			t.Fatalf("bad records: %v", recs)
+		}
+		return recs[0].(*dns.PTR)
+	}
+	ptr := pushed()
+	if ptr.Ptr != "hostname._pushtest._tcp.home.example.com." || ptr.Hdr.Ttl == pushDeleteRecord {
+		t.Fatalf("bad add: %v", ptr)
+	}
+
+	// The service's goodbye is pushed as a deletion.
+	serv.Shutdown()
+	ptr = pushed()
+	if ptr.Ptr != "hostname._pushtest._tcp.home.example.com." || ptr.Hdr.Ttl != pushDeleteRecord {
+		t.Fatalf("bad delete: %v", ptr)
+	}
+
+	var id [2]byte
+	binary.BigEndian.PutUint16(id[:], 3)
+	send(&dsoMsg{tlvs: []dsoTLV{{typ: dsoTypeUnsubscribe, data: id[:]}}})
+	send(&dsoMsg{id: 4, tlvs: []dsoTLV{{typ: 0x7777}}})
+	if m := recv(); m.id != 4 || m.rcode != dns.RcodeStatefulTypeNotImplemented {
+		t.Fatalf("bad response to unknown TLV: %+v", m)
+	}
+}
@@ -0,0 +1,195 @@
+package mdns
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// minQueryInterval is the shortest interval between repetitions of a
+// continuous query, as per section 5.2 of RFC 6762.
+const minQueryInterval = time.Second
+
+var (
+	queryGroupsLock sync.Mutex
+	queryGroups     = make(map[string]*queryGroup) // By queryGroupKey
+)
+
+// queryGroup sends the continuous query of the browsers of a service in
+// this process, so that they share one stream of queries on the network,
+// repeated with an interval that doubles up to maxQueryInterval, instead of
+// each sending its own.  Responses sent by unicast to the browser whose
+// client sent a query are passed on to the others.
+type queryGroup struct {
+	key string // Empty if the group is not shared
+	q   *dns.Msg
+
+	lock     sync.Mutex
+	members  []*queryMember // In the order they joined; the first sends
+	interval time.Duration
+	last     time.Time // When the query was last sent
+	timer    *time.Timer
+
+	unicast   bool      // Set if the next query asks for unicast responses
+	restarted time.Time // When restart last sent the query at once
+}
+
+// queryMember is a browser's membership of a queryGroup.
+type queryMember struct {
+	group *queryGroup
+	c     *client
+	msgCh chan<- *received
+}
+
+// queryGroupKey returns the key of the query group of a browse of
+// serviceAddr with params, or "" if the browse must not share its queries,
+// as when it is traced.
+func queryGroupKey(params *QueryParam, serviceAddr string) string {
+	if params.Trace != nil {
+		return ""
+	}
+	iface := ""
+	if params.Interface != nil {
+		iface = params.Interface.Name
+	}
+	return fmt.Sprintf("%s\x00%v\x00%s\x00%s\x00%v", serviceAddr, params.WantUnicastResponse, iface, params.Responder, params.Peers)
+}
+
+// joinQueryGroup adds a browser, with client c and message channel msgCh, to
+// the query group with the given key, or to a new group sending q, and
+// queries at once unless the group queried less than minQueryInterval ago.
+func joinQueryGroup(key string, q *dns.Msg, c *client, msgCh chan<- *received) *queryMember {
+	queryGroupsLock.Lock()
+	defer queryGroupsLock.Unlock()
+	g := queryGroups[key]
+	if g == nil {
+		g = &queryGroup{key: key, q: q}
+		if key != "" {
+			queryGroups[key] = g
+		}
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	m := &queryMember{group: g, c: c, msgCh: msgCh}
+	g.members = append(g.members, m)
+
+	// A new browser knows nothing yet, so the backoff starts over.
+	g.interval = minQueryInterval
+	wait := time.Until(g.last.Add(minQueryInterval))
+	if wait < 0 {
+		wait = 0
+	}
+	if g.timer == nil {
+		g.timer = time.AfterFunc(wait, g.send)
+	} else {
+		g.timer.Stop()
+		g.timer.Reset(wait)
+	}
+	return m
+}
+
+// send sends the group's query through the client of its first member, and
+// schedules the next one.
+func (g *queryGroup) send() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if len(g.members) == 0 {
+		return
+	}
+	c := g.members[0].c
+	q := g.q
+	if g.unicast {
+		q = g.q.Copy()
+		for i := range q.Question {
+			q.Question[i].Qclass |= 1 << 15
+		}
+		g.unicast = false
+	}
+	if err := c.sendQuery(q); err != nil {
+		c.logf("[ERR] mdns: Failed to query %s: %v", g.q.Question[0].Name, err)
+	}
+	g.last = time.Now()
+	g.timer.Reset(g.interval)
+	if g.interval *= 2; g.interval > maxQueryInterval {
+		g.interval = maxQueryInterval
+	}
+}
+
+// restart sends the group's query at once, asking for unicast responses as
+// a new query does (RFC 6762 section 5.4), and repeats it with the interval
+// of a new query, as after the host moves to another network.  Its members
+// all notice the change, so a restart less than minQueryInterval after the
+// last one does nothing.
+func (g *queryGroup) restart() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now := time.Now()
+	if len(g.members) == 0 || now.Sub(g.restarted) < minQueryInterval {
+		return
+	}
+	g.restarted = now
+	g.interval = minQueryInterval
+	g.unicast = true
+	g.timer.Stop()
+	g.timer.Reset(0)
+}
+
+// leave removes m from its group, which stops querying once it has no
+// members left.
+func (m *queryMember) leave() {
+	queryGroupsLock.Lock()
+	defer queryGroupsLock.Unlock()
+	g := m.group
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i, member := range g.members {
+		if member == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	if len(g.members) == 0 {
+		g.timer.Stop()
+		if g.key != "" {
+			delete(queryGroups, g.key)
+		}
+	}
+}
+
+// forward passes the messages received on the unicast sockets of m's client
+// from in to out, and to the other members of the group, until ctx is done.
+func (m *queryMember) forward(ctx context.Context, in <-chan *received, out chan<- *received) {
+	for {
+		select {
+		case r := <-in:
+			m.group.share(m, r)
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// share passes r to the members of the group other than from, dropping it
+// for those that are not keeping up.
+func (g *queryGroup) share(from *queryMember, r *received) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, m := range g.members {
+		if m == from {
+			continue
+		}
+		select {
+		case m.msgCh <- r:
+		default:
+		}
+	}
+}
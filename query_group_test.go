@@ -0,0 +1,61 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBrowser_SharedQueries(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_shared._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	metrics := new(ClientMetrics)
+	var browsers []*Browser
+	for i := 0; i < 3; i++ {
+		b, err := NewBrowser(context.Background(), "_shared._tcp", WithMetrics(metrics))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer b.Close()
+		browsers = append(browsers, b)
+	}
+
+	for _, b := range browsers {
+		select {
+		case ev := <-b.Events():
+			if ev.Type != ServiceAdded || ev.Entry.Name != "hostname._shared._tcp.local." {
+				t.Fatalf("bad event: %v %+v", ev.Type, ev.Entry)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	queryGroupsLock.Lock()
+	g := queryGroups[queryGroupKey(&QueryParam{}, "_shared._tcp.local.")]
+	queryGroupsLock.Unlock()
+	if g == nil || len(g.members) != 3 {
+		t.Fatalf("browsers do not share a query group: %+v", g)
+	}
+
+	// The browsers query once at first, then after one and two seconds,
+	// rather than each sending its own queries.
+	time.Sleep(2500 * time.Millisecond)
+	if n := metrics.Snapshot().QueriesSent; n > 3 {
+		t.Errorf("%d queries sent by three browsers", n)
+	}
+
+	for _, b := range browsers {
+		b.Close()
+	}
+	queryGroupsLock.Lock()
+	defer queryGroupsLock.Unlock()
+	if len(queryGroups) != 0 {
+		t.Errorf("query groups left after the browsers closed: %v", queryGroups)
+	}
+}
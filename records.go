@@ -0,0 +1,299 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// RecordQueryParam is used to customize how QueryRecords is performed
+type RecordQueryParam struct {
+	Name                string          // Fully qualified name to query, e.g. "My Printer._ipp._tcp.local."
+	Type                uint16          // Record type to query, default dns.TypeANY
+	Context             context.Context // Context
+	Timeout             time.Duration   // Query timeout, default 1 second. Ignored if Context is provided
+	Interface           *net.Interface  // Multicast interface to use
+	WantUnicastResponse bool            // Unicast response desired, as per 5.4 in RFC
+	Logger              *log.Logger     // Logger for errors, default is the standard logger
+	Metrics             *ClientMetrics  // Optional counters updated as the query runs
+	Responder           *net.UDPAddr    // Optional responder to query directly over unicast, see QueryParam
+	WideArea            bool            // Also query unicast DNS, always done for names outside "local"
+	Resolvers           []string        // Unicast DNS servers as "host:port", default from /etc/resolv.conf
+	LLMNR               bool            // Also query LLMNR, for host names such as "host.local."
+	Cache               *Cache          // Optional cache that the records received are added to
+	AuthKey             []byte          // If set, responses not signed with it are dropped, see WithAuthKey
+	Peers               []*net.UDPAddr  // Also sent the query by unicast, see WithPeers
+}
+
+// QueryRecords asks for the records of a single name, such as a service
+// instance or host name, and returns every distinct record that responders
+// send back before the timeout, including those in the additional section.
+//
+// Querying a service instance with the default type of dns.TypeANY yields the
+// same information as `dns-sd -Z`: the SRV and TXT records of the instance and
+// the addresses of its host.  The records are sorted by name and type.
+func QueryRecords(params *RecordQueryParam) ([]dns.RR, error) {
+	client, err := newClient(params.Logger, params.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface, false); err != nil {
+			return nil, err
+		}
+	}
+	client.responder = responderAddr(params.Responder)
+	client.authKey = params.AuthKey
+	client.peers = peerAddrs(params.Peers)
+
+	qtype := params.Type
+	if qtype == 0 {
+		qtype = dns.TypeANY
+	}
+
+	ctx := params.Context
+	if ctx == nil {
+		timeout := params.Timeout
+		if timeout == 0 {
+			timeout = time.Second
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+	}
+
+	msgCh := make(chan *received, 32)
+	go client.recv(client.ipv4UnicastConn, msgCh)
+	go client.recv(client.ipv6UnicastConn, msgCh)
+	go client.recv(client.ipv4MulticastConn, msgCh)
+	go client.recv(client.ipv6MulticastConn, msgCh)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(params.Name), qtype)
+	if params.WantUnicastResponse {
+		m.Question[0].Qclass |= 1 << 15
+	}
+	m.RecursionDesired = false
+	if err := client.sendQuery(m); err != nil {
+		return nil, err
+	}
+
+	if params.WideArea || !isLocalDomain(m.Question[0].Name) {
+		servers := params.Resolvers
+		if len(servers) == 0 {
+			if servers, err = systemResolvers(); err != nil {
+				return nil, err
+			}
+		}
+		go func() {
+			resp, err := unicastExchange(ctx, servers, m.Question[0].Name, qtype)
+			if err != nil {
+				client.logf("[ERR] mdns: Failed to query %s over unicast DNS: %v", m.Question[0].Name, err)
+				return
+			}
+			select {
+			case msgCh <- &received{msg: resp, at: time.Now()}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if params.LLMNR {
+		go func() {
+			if err := queryLLMNR(ctx, m.Question[0].Name, qtype, params.Interface, msgCh); err != nil {
+				client.logf("[ERR] mdns: Failed to query %s with LLMNR: %v", m.Question[0].Name, err)
+			}
+		}()
+	}
+
+	var set recordSet
+	for {
+		select {
+		case r := <-msgCh:
+			if params.Cache != nil && r.msg.Response {
+				params.Cache.addMsg(r.msg, r.from)
+			}
+			set.add(r.msg, m.Question[0])
+		case <-ctx.Done():
+			return set.sorted(), nil
+		}
+	}
+}
+
+// recordSet accumulates the distinct records received in answer to a
+// question.
+type recordSet struct {
+	recs []dns.RR
+}
+
+// add adds the records of m to the set if m answers q.  Only responses with an
+// answer for the queried name are considered, but all of their records,
+// including the additional section, are kept.
+func (s *recordSet) add(m *dns.Msg, q dns.Question) {
+	if !m.Response {
+		return
+	}
+	answers := false
+	for _, rr := range m.Answer {
+		hdr := rr.Header()
+		if strings.EqualFold(hdr.Name, q.Name) && (q.Qtype == dns.TypeANY || q.Qtype == hdr.Rrtype) {
+			answers = true
+			break
+		}
+	}
+	if !answers {
+		return
+	}
+
+	for _, rr := range append(m.Answer, m.Extra...) {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		if !s.contains(rr) {
+			s.recs = append(s.recs, rr)
+		}
+	}
+}
+
+// contains returns true if the set already holds a record equal to rr,
+// ignoring the TTL.
+func (s *recordSet) contains(rr dns.RR) bool {
+	for _, r := range s.recs {
+		if dns.IsDuplicate(r, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the records in the set ordered by name and then type.
+func (s *recordSet) sorted() []dns.RR {
+	recs := append([]dns.RR(nil), s.recs...)
+	sort.SliceStable(recs, func(i, j int) bool {
+		a, b := recs[i].Header(), recs[j].Header()
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+		return a.Rrtype < b.Rrtype
+	})
+	return recs
+}
+
+// Resolve looks up a single service instance, given its fully qualified name
+// such as "My Printer._ipp._tcp.local.", and returns its host, port, TXT record
+// and addresses.  It accepts the same options as Lookup, except for
+// WithEntriesChannel, WithDomain and WithCache, which are ignored.
+func Resolve(ctx context.Context, instance string, opts ...QueryOption) (*ServiceEntry, error) {
+	params := DefaultParams("")
+	for _, opt := range opts {
+		opt(params)
+	}
+	if params.System {
+		return nil, fmt.Errorf("mdns: Resolve cannot use the system responder")
+	}
+	if params.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+	rparams := &RecordQueryParam{
+		Name:                instance,
+		Context:             ctx,
+		Interface:           params.Interface,
+		WantUnicastResponse: params.WantUnicastResponse,
+		Logger:              params.Logger,
+		Metrics:             params.Metrics,
+		Responder:           params.Responder,
+		WideArea:            params.WideArea,
+		Resolvers:           params.Resolvers,
+		AuthKey:             params.AuthKey,
+		Peers:               params.Peers,
+	}
+	recs, err := QueryRecords(rparams)
+	if err != nil {
+		return nil, err
+	}
+
+	// The records are sorted by name, so the host's addresses may come
+	// before the SRV record that refers to the host.  Put them last so that
+	// they are matched to the instance.
+	m := new(dns.Msg)
+	for _, rr := range recs {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			m.Extra = append(m.Extra, rr)
+		default:
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	name := dns.Fqdn(instance)
+	inprogress := make(map[string]*ServiceEntry)
+	messageToEntries(m, inprogress)
+	e, ok := inprogress[name]
+	if !ok || e.Port == 0 {
+		return nil, fmt.Errorf("mdns: no SRV record found for %s", name)
+	}
+	e.LastSeen = time.Now()
+	return e, nil
+}
+
+// ServiceTypes returns the service types, such as "_http._tcp", advertised in
+// the lookup domain, found by querying "_services._dns-sd._udp" as described
+// in section 9 of RFC 6763.  It accepts the same options as Resolve.
+func ServiceTypes(ctx context.Context, opts ...QueryOption) ([]string, error) {
+	params := DefaultParams("")
+	for _, opt := range opts {
+		opt(params)
+	}
+	if params.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+	domain := trimDot(params.Domain)
+	if domain == "" {
+		domain = "local"
+	}
+	recs, err := QueryRecords(&RecordQueryParam{
+		Name:                "_services._dns-sd._udp." + domain + ".",
+		Type:                dns.TypePTR,
+		Context:             ctx,
+		Interface:           params.Interface,
+		WantUnicastResponse: params.WantUnicastResponse,
+		Logger:              params.Logger,
+		Metrics:             params.Metrics,
+		Responder:           params.Responder,
+		WideArea:            params.WideArea,
+		Resolvers:           params.Resolvers,
+		AuthKey:             params.AuthKey,
+		Peers:               params.Peers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := "." + domain + "."
+	var types []string
+	seen := make(map[string]bool)
+	for _, rr := range recs {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok || !strings.HasSuffix(strings.ToLower(ptr.Ptr), strings.ToLower(suffix)) {
+			continue
+		}
+		t := ptr.Ptr[:len(ptr.Ptr)-len(suffix)]
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return types, nil
+}
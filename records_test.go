@@ -0,0 +1,119 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestRecordSet_Add(t *testing.T) {
+	q := dns.Question{Name: "hostname._foobar._tcp.local.", Qtype: dns.TypeANY, Qclass: dns.ClassINET}
+	s := makeServiceWithServiceName(t, "_foobar._tcp")
+
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Answer = s.Records(q)
+
+	var set recordSet
+	set.add(resp, q)
+	set.add(resp, q) // received again on the other socket
+	set.add(&dns.Msg{Answer: resp.Answer}, q)
+
+	unrelated := new(dns.Msg)
+	unrelated.Response = true
+	other := makeServiceWithServiceName(t, "_other._tcp")
+	unrelated.Answer = other.Records(dns.Question{Name: "_other._tcp.local.", Qtype: dns.TypePTR})
+	set.add(unrelated, q)
+
+	recs := set.sorted()
+	if got, want := len(recs), 4; got != want {
+		t.Fatalf("got %d records, want %d: %v", got, want, recs)
+	}
+	for _, want := range []uint16{dns.TypeSRV, dns.TypeTXT, dns.TypeA, dns.TypeAAAA} {
+		found := false
+		for _, rr := range recs {
+			if rr.Header().Rrtype == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no %s record in %v", dns.TypeToString[want], recs)
+		}
+	}
+}
+
+func TestServer_QueryRecords(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	recs, err := QueryRecords(&RecordQueryParam{
+		Name:    "hostname._foobar._tcp.local.",
+		Timeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var srv *dns.SRV
+	for _, rr := range recs {
+		if rr, ok := rr.(*dns.SRV); ok {
+			srv = rr
+		}
+	}
+	if srv == nil {
+		t.Fatalf("no SRV record in %v", recs)
+	}
+	if srv.Port != 80 {
+		t.Errorf("bad SRV record: %v", srv)
+	}
+}
+
+func TestServer_Resolve(t *testing.T) {
+	// The host name sorts before the instance name, so the addresses are
+	// returned before the SRV record.
+	zone, err := NewMDNSService("hostname", "_foobar._tcp", "local.", "alpha.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"path=/"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	e, err := Resolve(context.Background(), "hostname._foobar._tcp.local.", WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e.Host != "alpha.local." || e.Port != 80 || !e.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Errorf("bad: %+v", e)
+	}
+}
+
+func TestServer_ServiceTypes(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	types, err := ServiceTypes(context.Background(), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	found := false
+	for _, typ := range types {
+		if typ == "_foobar._tcp" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("_foobar._tcp not in %v", types)
+	}
+}
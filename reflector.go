@@ -0,0 +1,381 @@
+package mdns
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// defaultReflectorRate is the default number of packets per second
+	// relayed from each interface.
+	defaultReflectorRate = 50
+
+	// reflectorDedupWindow is how long a relayed packet is remembered, so
+	// that copies of it that come back, for example from another reflector,
+	// are not relayed again.
+	reflectorDedupWindow = time.Second
+)
+
+// ReflectorConfig is used to configure a Reflector.
+type ReflectorConfig struct {
+	// Interfaces are the interfaces to relay between.  At least two are
+	// required.
+	Interfaces []*net.Interface
+
+	// RateLimit is the most packets per second relayed from each interface,
+	// with bursts of up to the same number.  The default is 50.
+	RateLimit int
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Reflector relays mDNS queries and responses between interfaces, so that
+// services on one network segment, such as an IoT VLAN, can be discovered
+// from another, like avahi's reflector.
+//
+// Every multicast packet received on one of the interfaces is sent out on all
+// the others.  Questions asking for unicast responses are relayed as ordinary
+// multicast questions, so that the answers come back through the reflector.
+// Legacy unicast queries, sent from a port other than 5353, are not relayed,
+// as their answers could not be routed back.
+//
+// To avoid loops, the reflector ignores packets sent from its own addresses
+// and packets identical to one it relayed in the last second, which also
+// keeps two reflectors on the same segments from feeding each other.
+type Reflector struct {
+	config *ReflectorConfig
+	ifaces map[int]*net.Interface // By index
+
+	ipv4Conn *ipv4.PacketConn
+	ipv6Conn *ipv6.PacketConn
+
+	lock    sync.Mutex
+	local   map[string]bool      // Addresses of the interfaces
+	history *packetHistory       // Relayed packets
+	buckets map[int]*tokenBucket // Rate limits, by interface index
+
+	shutdown   bool
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewReflector starts relaying between the configured interfaces.
+func NewReflector(config *ReflectorConfig) (*Reflector, error) {
+	if len(config.Interfaces) < 2 {
+		return nil, fmt.Errorf("mdns: a reflector needs at least two interfaces")
+	}
+	r := &Reflector{
+		config:     config,
+		ifaces:     make(map[int]*net.Interface),
+		local:      make(map[string]bool),
+		history:    newPacketHistory(),
+		buckets:    make(map[int]*tokenBucket),
+		shutdownCh: make(chan struct{}),
+	}
+	rate := config.RateLimit
+	if rate == 0 {
+		rate = defaultReflectorRate
+	}
+	for _, iface := range config.Interfaces {
+		r.ifaces[iface.Index] = iface
+		r.buckets[iface.Index] = newTokenBucket(rate)
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				r.local[ipnet.IP.String()] = true
+			}
+		}
+	}
+
+	var err error
+	if r.ipv4Conn, r.ipv6Conn, err = listenGroups(config.Interfaces, false); err != nil {
+		return nil, err
+	}
+
+	if r.ipv4Conn != nil {
+		r.wg.Add(1)
+		go r.recv4()
+	}
+	if r.ipv6Conn != nil {
+		r.wg.Add(1)
+		go r.recv6()
+	}
+	return r, nil
+}
+
+// Shutdown stops the reflector.
+func (r *Reflector) Shutdown() error {
+	r.lock.Lock()
+	if r.shutdown {
+		r.lock.Unlock()
+		return nil
+	}
+	r.shutdown = true
+	close(r.shutdownCh)
+	r.lock.Unlock()
+
+	if r.ipv4Conn != nil {
+		r.ipv4Conn.Close()
+	}
+	if r.ipv6Conn != nil {
+		r.ipv6Conn.Close()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Reflector) logf(format string, v ...interface{}) {
+	if r.config.Logger != nil {
+		r.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func (r *Reflector) recv4() {
+	defer r.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, cm, from, err := r.ipv4Conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.shutdownCh:
+				return
+			default:
+				continue
+			}
+		}
+		if cm == nil {
+			continue
+		}
+		out, ok := r.filter(buf[:n], from.(*net.UDPAddr), cm.IfIndex, time.Now())
+		if !ok {
+			continue
+		}
+		for idx := range r.ifaces {
+			if idx == cm.IfIndex {
+				continue
+			}
+			if _, err := r.ipv4Conn.WriteTo(out, &ipv4.ControlMessage{IfIndex: idx}, ipv4Addr); err != nil {
+				r.logf("[ERR] mdns: Failed to reflect packet to %s: %v", r.ifaces[idx].Name, err)
+			}
+		}
+	}
+}
+
+func (r *Reflector) recv6() {
+	defer r.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, cm, from, err := r.ipv6Conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.shutdownCh:
+				return
+			default:
+				continue
+			}
+		}
+		if cm == nil {
+			continue
+		}
+		out, ok := r.filter(buf[:n], from.(*net.UDPAddr), cm.IfIndex, time.Now())
+		if !ok {
+			continue
+		}
+		for idx := range r.ifaces {
+			if idx == cm.IfIndex {
+				continue
+			}
+			if _, err := r.ipv6Conn.WriteTo(out, &ipv6.ControlMessage{IfIndex: idx}, ipv6Addr); err != nil {
+				r.logf("[ERR] mdns: Failed to reflect packet to %s: %v", r.ifaces[idx].Name, err)
+			}
+		}
+	}
+}
+
+// listenGroups binds sockets to the mDNS group addresses, which shares the
+// port with any responder on this host, and joins the groups on the given
+// interfaces, or on every multicast interface if ifaces is nil.  The sockets
+// report the interface each packet arrives on.  One of the sockets is nil if
+// its address family is unavailable.
+func listenGroups(ifaces []*net.Interface, loopback bool) (*ipv4.PacketConn, *ipv6.PacketConn, error) {
+	return listenMulticast(ifaces, loopback, ipv4Addr, ipv6Addr)
+}
+
+// listenMulticast is listenGroups for any pair of IPv4 and IPv6 group
+// addresses.
+func listenMulticast(ifaces []*net.Interface, loopback bool, group4, group6 *net.UDPAddr) (*ipv4.PacketConn, *ipv6.PacketConn, error) {
+	if ifaces == nil {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range all {
+			if all[i].Flags&net.FlagUp != 0 && all[i].Flags&net.FlagMulticast != 0 {
+				ifaces = append(ifaces, &all[i])
+			}
+		}
+	}
+
+	var p4 *ipv4.PacketConn
+	if c, err := listenUDP("udp4", group4); err == nil {
+		p := ipv4.NewPacketConn(c)
+		joined := 0
+		for _, iface := range ifaces {
+			if p.JoinGroup(iface, &net.UDPAddr{IP: group4.IP}) == nil {
+				joined++
+			}
+		}
+		if joined > 0 && p.SetControlMessage(ipv4.FlagInterface, true) == nil {
+			p.SetMulticastLoopback(loopback)
+			p4 = p
+		} else {
+			c.Close()
+		}
+	}
+	var p6 *ipv6.PacketConn
+	if c, err := listenUDP("udp6", group6); err == nil {
+		p := ipv6.NewPacketConn(c)
+		joined := 0
+		for _, iface := range ifaces {
+			if p.JoinGroup(iface, &net.UDPAddr{IP: group6.IP}) == nil {
+				joined++
+			}
+		}
+		if joined > 0 && p.SetControlMessage(ipv6.FlagInterface, true) == nil {
+			p.SetMulticastLoopback(loopback)
+			p6 = p
+		} else {
+			c.Close()
+		}
+	}
+	if p4 == nil && p6 == nil {
+		return nil, nil, fmt.Errorf("mdns: failed to join the multicast group on the interfaces")
+	}
+	return p4, p6, nil
+}
+
+// filter decides whether a packet received on the interface with the given
+// index is relayed, and returns the packet to send.
+func (r *Reflector) filter(packet []byte, from *net.UDPAddr, ifIndex int, now time.Time) ([]byte, bool) {
+	if _, ok := r.ifaces[ifIndex]; !ok {
+		return nil, false
+	}
+	packet, ok := relayable(packet, from)
+	if !ok {
+		return nil, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.local[from.IP.String()] {
+		return nil, false
+	}
+	if r.history.contains(packet, now) {
+		return nil, false
+	}
+	if !r.buckets[ifIndex].take(now) {
+		return nil, false
+	}
+	r.history.add(packet, now)
+	return packet, true
+}
+
+// relayable checks that a packet received from a peer can be relayed to
+// another network, and returns the packet to send.  Questions asking for
+// unicast responses are changed to ask for multicast responses, so that the
+// answers come back through the relay.  Legacy unicast queries, sent from a
+// port other than 5353, cannot be relayed as their answers could not be
+// routed back; see section 6.7 of RFC 6762.
+func relayable(packet []byte, from *net.UDPAddr) ([]byte, bool) {
+	if from.Port != 5353 {
+		return nil, false
+	}
+	var msg dns.Msg
+	if err := msg.Unpack(packet); err != nil {
+		return nil, false
+	}
+	if msg.Response {
+		return packet, true
+	}
+	changed := false
+	for i := range msg.Question {
+		if msg.Question[i].Qclass&(1<<15) != 0 {
+			msg.Question[i].Qclass &^= 1 << 15
+			changed = true
+		}
+	}
+	if !changed {
+		return packet, true
+	}
+	packet, err := msg.Pack()
+	return packet, err == nil
+}
+
+// packetHistory remembers the packets relayed recently, so that copies of
+// them that come back are not relayed again.
+type packetHistory struct {
+	recent map[[sha1.Size]byte]time.Time
+}
+
+func newPacketHistory() *packetHistory {
+	return &packetHistory{recent: make(map[[sha1.Size]byte]time.Time)}
+}
+
+// contains returns true if packet was added less than reflectorDedupWindow
+// before now.
+func (p *packetHistory) contains(packet []byte, now time.Time) bool {
+	for h, t := range p.recent {
+		if now.Sub(t) > reflectorDedupWindow {
+			delete(p.recent, h)
+		}
+	}
+	_, ok := p.recent[sha1.Sum(packet)]
+	return ok
+}
+
+// add records that packet was relayed at now.
+func (p *packetHistory) add(packet []byte, now time.Time) {
+	p.recent[sha1.Sum(packet)] = now
+}
+
+// tokenBucket is a rate limiter allowing rate events per second, in bursts of
+// up to rate.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate)}
+}
+
+// take returns true, and uses a token, if one is available at now.
+func (b *tokenBucket) take(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
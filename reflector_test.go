@@ -0,0 +1,97 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makeTestReflector(rate int) *Reflector {
+	r := &Reflector{
+		config:  &ReflectorConfig{},
+		ifaces:  map[int]*net.Interface{1: {Index: 1, Name: "lan"}, 2: {Index: 2, Name: "iot"}},
+		local:   map[string]bool{"192.168.1.1": true},
+		history: newPacketHistory(),
+		buckets: map[int]*tokenBucket{1: newTokenBucket(rate), 2: newTokenBucket(rate)},
+	}
+	return r
+}
+
+func packQuery(t *testing.T, name string, qu bool) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypePTR)
+	m.Id = 0
+	if qu {
+		m.Question[0].Qclass |= 1 << 15
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return b
+}
+
+func TestReflector_Filter(t *testing.T) {
+	r := makeTestReflector(10)
+	now := time.Now()
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5353}
+
+	q := packQuery(t, "_http._tcp.local.", true)
+	out, ok := r.filter(q, peer, 1, now)
+	if !ok {
+		t.Fatalf("query not relayed")
+	}
+	var m dns.Msg
+	if err := m.Unpack(out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if m.Question[0].Qclass&(1<<15) != 0 {
+		t.Errorf("unicast-response bit not cleared")
+	}
+
+	// The same packet coming back, for example through another reflector.
+	if _, ok := r.filter(q, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 5353}, 2, now); ok {
+		t.Errorf("duplicate relayed")
+	}
+	// Once the window has passed it is relayed again.
+	if _, ok := r.filter(q, peer, 1, now.Add(2*reflectorDedupWindow)); !ok {
+		t.Errorf("repeated query not relayed")
+	}
+
+	other := packQuery(t, "_ssh._tcp.local.", false)
+	if _, ok := r.filter(other, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 5353}, 1, now); ok {
+		t.Errorf("own packet relayed")
+	}
+	if _, ok := r.filter(other, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 40000}, 1, now); ok {
+		t.Errorf("legacy unicast query relayed")
+	}
+	if _, ok := r.filter(other, peer, 3, now); ok {
+		t.Errorf("packet from an unconfigured interface relayed")
+	}
+}
+
+func TestReflector_RateLimit(t *testing.T) {
+	r := makeTestReflector(2)
+	now := time.Now()
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5353}
+
+	relayed := 0
+	for _, name := range []string{"a.local.", "b.local.", "c.local."} {
+		if _, ok := r.filter(packQuery(t, name, false), peer, 1, now); ok {
+			relayed++
+		}
+	}
+	if relayed != 2 {
+		t.Errorf("relayed %d packets, want 2", relayed)
+	}
+	// The other interface has its own budget.
+	if _, ok := r.filter(packQuery(t, "d.local.", false), peer, 2, now); !ok {
+		t.Errorf("packet from the other interface not relayed")
+	}
+	// Tokens are refilled over time.
+	if _, ok := r.filter(packQuery(t, "e.local.", false), peer, 1, now.Add(time.Second)); !ok {
+		t.Errorf("packet not relayed after the limit was refilled")
+	}
+}
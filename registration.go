@@ -0,0 +1,97 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Registration is a service registered with Server.Register, which keeps
+// the service's records and their announcements and goodbyes in step.
+type Registration struct {
+	server *Server
+	set    *ServiceSet
+
+	lock   sync.Mutex
+	svc    *MDNSService // Replaced, never changed, as the server may read it
+	closed bool
+}
+
+// ServiceUpdate is a change to a registered service.  Fields left at their
+// zero value are not changed.
+type ServiceUpdate struct {
+	TXT  []string
+	Port int
+	IPs  []net.IP
+}
+
+// Register adds svc to the server's zone, which must be a ServiceSet, and
+// announces it.  The returned Registration updates the service and
+// withdraws it.
+func (s *Server) Register(svc *MDNSService) (*Registration, error) {
+	set, ok := s.conf().Zone.(*ServiceSet)
+	if !ok {
+		return nil, fmt.Errorf("mdns: services can only be registered with a server of a ServiceSet")
+	}
+	if err := set.Add(svc); err != nil {
+		return nil, err
+	}
+	s.Announce(svc)
+	return &Registration{server: s, set: set, svc: svc}, nil
+}
+
+// Service returns the service as last updated.  It must not be changed.
+func (r *Registration) Service() *MDNSService {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.svc
+}
+
+// Update changes the service's TXT record, port or addresses, and announces
+// the change.
+func (r *Registration) Update(u ServiceUpdate) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return fmt.Errorf("mdns: service %s is no longer registered", r.svc.instanceAddr)
+	}
+	svc := *r.svc
+	if u.TXT != nil {
+		svc.TXT = u.TXT
+	}
+	if u.Port != 0 {
+		svc.Port = u.Port
+	}
+	if u.IPs != nil {
+		svc.IPs = u.IPs
+	}
+	if _, err := r.set.Replace(&svc); err != nil {
+		return err
+	}
+	r.svc = &svc
+	r.server.Announce(&svc)
+	return nil
+}
+
+// Reannounce announces the service again, such as after the host joins a
+// network.
+func (r *Registration) Reannounce() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.closed {
+		r.server.Announce(r.svc)
+	}
+}
+
+// Close removes the service from the server's zone and sends goodbyes for
+// it.  Closing a registration again does nothing.
+func (r *Registration) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.set.Remove(r.svc.instanceAddr)
+	return r.server.Withdraw(r.svc)
+}
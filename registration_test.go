@@ -0,0 +1,98 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestServer_Register(t *testing.T) {
+	set, err := NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	reg, err := serv.Register(makeService(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	name := reg.Service().InstanceName()
+	if set.Get(name) == nil {
+		t.Fatalf("%s is not in the zone", name)
+	}
+
+	b, err := NewBrowser(context.Background(), reg.Service().Service)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer b.Close()
+	waitEvent := func(what string, ok func(*BrowseEvent) bool) {
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case ev := <-b.Events():
+				if ev.Entry.Name == name && ok(ev) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("%s not seen", what)
+			}
+		}
+	}
+	waitEvent("service", func(ev *BrowseEvent) bool { return ev.Type == ServiceAdded })
+
+	if err := reg.Update(ServiceUpdate{TXT: []string{"v=2"}, Port: 8080, IPs: []net.IP{net.IPv4(192, 168, 0, 43)}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	recs := set.Records(dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+	var port uint16
+	var txt []string
+	var addrs []net.IP
+	for _, rr := range recs {
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			port = rr.Port
+		case *dns.TXT:
+			txt = rr.Txt
+		case *dns.A:
+			addrs = append(addrs, rr.A)
+		}
+	}
+	if port != 8080 || len(txt) != 1 || txt[0] != "v=2" || len(addrs) != 1 || !addrs[0].Equal(net.IPv4(192, 168, 0, 43)) {
+		t.Errorf("zone not updated: %v", recs)
+	}
+	// A browser sees the new address, port and TXT record.
+	waitEvent("update", func(ev *BrowseEvent) bool {
+		e := ev.Entry
+		return ev.Type == ServiceUpdated && e.AddrV4.Equal(net.IPv4(192, 168, 0, 43)) && e.Port == 8080 && e.Info == "v=2"
+	})
+	reg.Reannounce()
+
+	if err := reg.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if set.Get(name) != nil {
+		t.Errorf("%s is still in the zone", name)
+	}
+	if err := reg.Update(ServiceUpdate{Port: 80}); err == nil {
+		t.Errorf("closed registration was updated")
+	}
+	if err := reg.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestServer_RegisterNeedsServiceSet(t *testing.T) {
+	s := &Server{config: &Config{Zone: makeService(t)}}
+	if _, err := s.Register(makeServiceWithServiceName(t, "_ssh._tcp")); err == nil {
+		t.Errorf("registered with a server of a single service")
+	}
+}
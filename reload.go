@@ -0,0 +1,261 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Reload changes the configuration of a running server, such as when a
+// daemon is sent SIGHUP, without closing its sockets.  The multicast groups
+// are joined on the interfaces newly selected by Iface or Interfaces and
+// left on those no longer selected.  Goodbyes are sent for the services of
+// the old zone that are not in the new one, and for the PTR records that
+// the services changed no longer have, and the services added or changed
+// are announced, without probing.  Other options apply from the next
+// packet.  The services of the new zone are announced when a suspended
+// server resumes.
+//
+// The options the server built its sockets and state from when it started
+// cannot be changed: Conns, System, LowMemory, LLMNR, MaxInFlight,
+// DuplicateWindow, CacheAnswers, DetectSpoofing, InterfaceScopedAddrs,
+// SubnetScopedAddrs, ResponseJitter, and the Metrics and SRP given.  If any
+// differ, Reload returns an error and changes nothing.
+func (s *Server) Reload(config *Config) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	s.shutdownLock.Lock()
+	closed := s.shutdown
+	s.shutdownLock.Unlock()
+	if closed {
+		return ErrServerClosed
+	}
+	old := s.conf()
+	if fields := fixedFieldsChanged(old, config); len(fields) > 0 {
+		return fmt.Errorf("mdns: %s cannot be changed without a new server", strings.Join(fields, ", "))
+	}
+
+	if s.system == nil {
+		if err := s.rejoin(config); err != nil {
+			return err
+		}
+		if config.DisableMulticastLoopback != old.DisableMulticastLoopback {
+			if s.ipv4List != nil {
+				ipv4.NewPacketConn(s.ipv4List).SetMulticastLoopback(!config.DisableMulticastLoopback)
+			}
+			if s.ipv6List != nil {
+				ipv6.NewPacketConn(s.ipv6List).SetMulticastLoopback(!config.DisableMulticastLoopback)
+			}
+		}
+	}
+
+	s.sendLock.Lock()
+	s.peers = peerAddrs(config.Peers)
+	s.sendLock.Unlock()
+
+	// The new zone answers before the goodbyes for the old one are sent, so
+	// that no answer brings back what they withdraw.
+	s.reloaded.Store(config)
+	if s.answers != nil {
+		if set, ok := config.Zone.(*ServiceSet); ok && config.Zone != old.Zone {
+			set.OnChange(s.answers.invalidate)
+		}
+		s.answers.invalidate()
+	}
+	if s.Suspended() {
+		// Resume announces the new zone.
+		return nil
+	}
+
+	removed, stale, announced := zoneChanges(zoneServices(old.Zone), zoneServices(config.Zone))
+	for _, svc := range removed {
+		if err := s.Withdraw(svc); err != nil {
+			log.Printf("[ERR] mdns: Failed to withdraw %s: %v", svc.instanceAddr, err)
+		}
+	}
+	if s.system == nil && len(stale) > 0 {
+		if err := s.multicastResponse(stale...); err != nil {
+			log.Printf("[ERR] mdns: Failed to send goodbyes: %v", err)
+		}
+	}
+	if len(announced) > 0 {
+		s.announceServices(announced, nil)
+	}
+	return nil
+}
+
+// fixedFieldsChanged returns the names of the fields that Reload cannot
+// change and that differ between old and config.
+func fixedFieldsChanged(old, config *Config) []string {
+	var fields []string
+	same := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+	same("Conns", old.Conns, config.Conns)
+	same("System", old.System, config.System)
+	same("LowMemory", old.LowMemory, config.LowMemory)
+	same("LLMNR", old.LLMNR, config.LLMNR)
+	same("MaxInFlight", old.MaxInFlight, config.MaxInFlight)
+	same("DuplicateWindow", old.DuplicateWindow, config.DuplicateWindow)
+	same("CacheAnswers", old.CacheAnswers, config.CacheAnswers)
+	same("DetectSpoofing", old.DetectSpoofing, config.DetectSpoofing)
+	same("InterfaceScopedAddrs", old.InterfaceScopedAddrs, config.InterfaceScopedAddrs)
+	same("SubnetScopedAddrs", old.SubnetScopedAddrs, config.SubnetScopedAddrs)
+	same("ResponseJitter", old.ResponseJitter, config.ResponseJitter)
+	// These hold state, so the same ones must be given.
+	if old.Metrics != config.Metrics {
+		fields = append(fields, "Metrics")
+	}
+	if old.SRP != config.SRP {
+		fields = append(fields, "SRP")
+	}
+	return fields
+}
+
+// rejoin joins the multicast groups on the interfaces config selects that
+// the server has not joined them on, and leaves them on those it no longer
+// selects.  It fails, leaving the groups as they were, if config selects
+// interfaces and the groups cannot be joined on any of them.
+func (s *Server) rejoin(config *Config) error {
+	var want []*net.Interface
+	switch {
+	case config.Iface != nil:
+		want = []*net.Interface{config.Iface}
+	case len(config.Interfaces) > 0:
+		want = config.Interfaces
+	default:
+		all, err := multicastInterfaces()
+		if err != nil {
+			return err
+		}
+		for i := range all {
+			want = append(want, &all[i])
+		}
+	}
+
+	s.sendLock.Lock()
+	joined := s.joined
+	s.sendLock.Unlock()
+	has := func(ifaces []*net.Interface, iface *net.Interface) bool {
+		for _, i := range ifaces {
+			if i.Index == iface.Index {
+				return true
+			}
+		}
+		return false
+	}
+
+	var p1 *ipv4.PacketConn
+	var p2 *ipv6.PacketConn
+	if s.ipv4List != nil {
+		p1 = ipv4.NewPacketConn(s.ipv4List)
+	}
+	if s.ipv6List != nil {
+		p2 = ipv6.NewPacketConn(s.ipv6List)
+	}
+	var now []*net.Interface
+	for _, iface := range want {
+		if has(joined, iface) {
+			now = append(now, iface)
+			continue
+		}
+		ok4 := p1 != nil && p1.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}) == nil
+		ok6 := p2 != nil && p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}) == nil
+		if ok4 || ok6 {
+			now = append(now, iface)
+		}
+	}
+	if len(want) > 0 && len(now) == 0 {
+		return fmt.Errorf("mdns: failed to join the multicast groups on any of the interfaces")
+	}
+	for _, iface := range joined {
+		if has(now, iface) {
+			continue
+		}
+		if p1 != nil {
+			p1.LeaveGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4})
+		}
+		if p2 != nil {
+			p2.LeaveGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6})
+		}
+	}
+
+	s.sendLock.Lock()
+	s.joined = now
+	s.sendLock.Unlock()
+	return nil
+}
+
+// zoneChanges compares the services of a zone before and after a reload,
+// and returns those removed, the goodbyes for the PTR records that changed
+// services no longer have, and the services added or changed.
+func zoneChanges(before, after []*MDNSService) (removed []*MDNSService, stale []*dns.Msg, announced []*MDNSService) {
+	old := make(map[string]*MDNSService, len(before))
+	for _, svc := range before {
+		old[nameKey(svc.instanceAddr)] = svc
+	}
+	for _, svc := range after {
+		key := nameKey(svc.instanceAddr)
+		prev, ok := old[key]
+		delete(old, key)
+		switch {
+		case !ok:
+			announced = append(announced, svc)
+		case prev == svc || serviceRecords(prev) == serviceRecords(svc):
+		default:
+			announced = append(announced, svc)
+			if m := staleRecords(prev, svc); m != nil {
+				stale = append(stale, m)
+			}
+		}
+	}
+	for _, svc := range before {
+		if _, ok := old[nameKey(svc.instanceAddr)]; ok {
+			removed = append(removed, svc)
+		}
+	}
+	return removed, stale, announced
+}
+
+// serviceRecords returns the records a service announces, in zone file
+// format, sorted and joined with newlines, for comparing services.
+func serviceRecords(svc *MDNSService) string {
+	recs := svc.Records(dns.Question{Name: svc.serviceAddr, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	var lines []string
+	for _, rr := range append(recs, svc.subtypePTRs()...) {
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// staleRecords returns the goodbyes for the PTR records of prev that svc,
+// which replaces it, does not have, or nil if there are none.  The other
+// records are unique, so svc's announcement replaces them.
+func staleRecords(prev, svc *MDNSService) *dns.Msg {
+	keep := make(map[string]bool)
+	for _, rr := range goodbye(svc, false).Answer {
+		keep[cacheKey(rr)] = true
+	}
+	m := goodbye(prev, false)
+	recs := m.Answer[:0]
+	for _, rr := range m.Answer {
+		if _, ok := rr.(*dns.PTR); ok && !keep[cacheKey(rr)] {
+			recs = append(recs, rr)
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+	m.Answer = recs
+	return m
+}
@@ -0,0 +1,85 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_Reload(t *testing.T) {
+	web := makeServiceWithServiceName(t, "_web._tcp")
+	ssh := makeServiceWithServiceName(t, "_ssh._tcp")
+	set, err := NewServiceSet(web)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	events := make(chan ServerEvent, 64)
+	onEvent := func(ev ServerEvent) {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+	serv, err := NewServer(&Config{Zone: set, OnEvent: onEvent})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	if err := serv.Reload(&Config{Zone: set, OnEvent: onEvent, LLMNR: true}); err == nil {
+		t.Fatalf("LLMNR changed by reload")
+	}
+
+	next, err := NewServiceSet(ssh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Reload(&Config{Zone: next, OnEvent: onEvent}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	deadline := time.After(10 * time.Second)
+	for announced := false; !announced; {
+		select {
+		case ev := <-events:
+			announced = ev.Type == Announced && ev.Service == ssh
+		case <-deadline:
+			t.Fatalf("added service not announced")
+		}
+	}
+
+	q := dns.Question{Name: web.instanceAddr, Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+	if recs := serv.records(q, nil); len(recs) != 0 {
+		t.Errorf("removed service answered: %v", recs)
+	}
+	q.Name = ssh.instanceAddr
+	if recs := serv.records(q, nil); len(recs) == 0 {
+		t.Errorf("added service not answered")
+	}
+}
+
+func TestZoneChanges(t *testing.T) {
+	kept := makeServiceWithServiceName(t, "_kept._tcp")
+	gone := makeServiceWithServiceName(t, "_gone._tcp")
+	before := makeServiceWithServiceName(t, "_changed._tcp")
+	before.Subtypes = []string{"_old"}
+	after := makeServiceWithServiceName(t, "_changed._tcp")
+	after.Port = 8080
+	added := makeServiceWithServiceName(t, "_added._tcp")
+
+	removed, stale, announced := zoneChanges(
+		[]*MDNSService{kept, gone, before},
+		[]*MDNSService{makeServiceWithServiceName(t, "_kept._tcp"), after, added})
+	if len(removed) != 1 || removed[0] != gone {
+		t.Errorf("bad removed services: %v", removed)
+	}
+	if len(announced) != 2 || announced[0] != after || announced[1] != added {
+		t.Errorf("bad announced services: %v", announced)
+	}
+	if len(stale) != 1 || len(stale[0].Answer) != 1 {
+		t.Fatalf("bad goodbyes: %v", stale)
+	}
+	if ptr, ok := stale[0].Answer[0].(*dns.PTR); !ok || ptr.Hdr.Name != "_old._sub._changed._tcp.local." || ptr.Hdr.Ttl != 0 {
+		t.Errorf("bad goodbye: %v", stale[0].Answer[0])
+	}
+}
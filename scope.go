@@ -0,0 +1,199 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// scopeRefresh is how often the addresses of the interfaces are read again,
+// so that addresses gained or lost, as when a VPN or container starts, are
+// seen.
+const scopeRefresh = 30 * time.Second
+
+// scopeIface is an interface and the networks of its addresses.
+type scopeIface struct {
+	index int
+	name  string
+	nets  []*net.IPNet
+}
+
+// addrScope finds the addresses in answers that the querier should not be
+// given: those of other interfaces than the one a query arrived on, for
+// Config.InterfaceScopedAddrs, and those outside the querier's subnet, for
+// Config.SubnetScopedAddrs.
+type addrScope struct {
+	iface, subnet bool
+
+	lock   sync.Mutex
+	ifaces []scopeIface
+	read   time.Time // When ifaces were last read, zero if never
+}
+
+func newAddrScope(iface, subnet bool) *addrScope {
+	return &addrScope{iface: iface, subnet: subnet}
+}
+
+// load returns the interfaces, reading them again if they are stale.
+func (a *addrScope) load(now time.Time) []scopeIface {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.read.IsZero() && now.Sub(a.read) < scopeRefresh {
+		return a.ifaces
+	}
+	a.read = now
+	ifaces, err := readIfaces()
+	if err != nil {
+		return a.ifaces
+	}
+	a.ifaces = ifaces
+	return ifaces
+}
+
+// readIfaces returns the interfaces and the networks of their addresses.
+func readIfaces() ([]scopeIface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ifaces := make([]scopeIface, 0, len(all))
+	for _, iface := range all {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		si := scopeIface{index: iface.Index, name: iface.Name}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				si.nets = append(si.nets, ipnet)
+			}
+		}
+		ifaces = append(ifaces, si)
+	}
+	return ifaces, nil
+}
+
+// arrival returns the index of the interface a packet from from arrived on:
+// ifIndex, if the kernel reported it, or else the interface named by the
+// zone of an IPv6 link-local source, or else the one with a network holding
+// the source.  It returns false if none is found.
+func arrival(ifaces []scopeIface, from net.Addr, ifIndex int) (int, bool) {
+	if ifIndex > 0 {
+		return ifIndex, true
+	}
+	addr, ok := from.(*net.UDPAddr)
+	if !ok || addr.IP == nil {
+		return 0, false
+	}
+	if addr.Zone != "" {
+		for _, iface := range ifaces {
+			if iface.name == addr.Zone {
+				return iface.index, true
+			}
+		}
+	}
+	for _, iface := range ifaces {
+		for _, ipnet := range iface.nets {
+			if ipnet.Contains(addr.IP) {
+				return iface.index, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// bestSubnet returns the network of the interfaces' addresses with the
+// longest prefix holding the address of from, or nil if none does.
+func bestSubnet(ifaces []scopeIface, from net.Addr) *net.IPNet {
+	ip := sourceIP(from)
+	if ip == nil {
+		return nil
+	}
+	var best *net.IPNet
+	bestOnes := -1
+	for _, iface := range ifaces {
+		for _, ipnet := range iface.nets {
+			if ones, _ := ipnet.Mask.Size(); ones > bestOnes && ipnet.Contains(ip) {
+				best, bestOnes = ipnet, ones
+			}
+		}
+	}
+	return best
+}
+
+// filter removes from rrs the A and AAAA records of this host's addresses
+// that the querier from should not be given, and returns the records left:
+// with interface scoping, those assigned to an interface other than the one
+// the query arrived on, and with subnet scoping, those of the querier's
+// address family outside the longest-prefix network holding the querier.
+// Addresses not assigned to any interface, such as those of services
+// advertised for other hosts, are kept, as are all the records if the
+// interface or subnet cannot be told.  ifIndex is the interface the query
+// arrived on, if known.
+func (a *addrScope) filter(rrs []dns.RR, from net.Addr, ifIndex int) []dns.RR {
+	ifaces := a.load(time.Now())
+	index, byIface := arrival(ifaces, from, ifIndex)
+	byIface = byIface && a.iface
+	var subnet *net.IPNet
+	if a.subnet {
+		subnet = bestSubnet(ifaces, from)
+	}
+	if !byIface && subnet == nil {
+		return rrs
+	}
+	v4 := sourceIP(from).To4() != nil
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		switch {
+		case ip == nil:
+		case byIface && otherIface(ifaces, index, ip):
+			continue
+		case subnet != nil && (ip.To4() != nil) == v4 && !subnet.Contains(ip) && assigned(ifaces, ip):
+			continue
+		}
+		kept = append(kept, rr)
+	}
+	for i := len(kept); i < len(rrs); i++ {
+		rrs[i] = nil
+	}
+	return kept
+}
+
+// assigned returns true if ip is assigned to one of the interfaces.
+func assigned(ifaces []scopeIface, ip net.IP) bool {
+	for _, iface := range ifaces {
+		for _, ipnet := range iface.nets {
+			if ipnet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// otherIface returns true if ip is assigned to an interface other than the
+// one with the given index, and not to that one.
+func otherIface(ifaces []scopeIface, index int, ip net.IP) bool {
+	other := false
+	for _, iface := range ifaces {
+		for _, ipnet := range iface.nets {
+			if !ipnet.IP.Equal(ip) {
+				continue
+			}
+			if iface.index == index {
+				return false
+			}
+			other = true
+		}
+	}
+	return other
+}
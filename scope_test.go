@@ -0,0 +1,105 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAddrScope_Filter(t *testing.T) {
+	wifi := &net.IPNet{IP: net.IPv4(192, 168, 1, 20), Mask: net.CIDRMask(24, 32)}
+	docker := &net.IPNet{IP: net.IPv4(172, 17, 0, 1), Mask: net.CIDRMask(16, 32)}
+	ll := &net.IPNet{IP: net.ParseIP("fe80::20"), Mask: net.CIDRMask(64, 128)}
+	a := &addrScope{
+		iface: true,
+		ifaces: []scopeIface{
+			{index: 2, name: "wlan0", nets: []*net.IPNet{wifi, ll}},
+			{index: 3, name: "docker0", nets: []*net.IPNet{docker}},
+		},
+		read: time.Now(),
+	}
+
+	hdr := dns.RR_Header{Name: "host.local.", Class: dns.ClassINET, Ttl: 120}
+	answers := func() []dns.RR {
+		return []dns.RR{
+			&dns.SRV{Hdr: hdr, Port: 80, Target: "host.local."},
+			&dns.A{Hdr: hdr, A: net.IPv4(192, 168, 1, 20)},
+			&dns.A{Hdr: hdr, A: net.IPv4(172, 17, 0, 1)},
+			&dns.A{Hdr: hdr, A: net.IPv4(10, 0, 0, 5)}, // Not assigned here
+			&dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("fe80::20")},
+		}
+	}
+	for _, test := range []struct {
+		from net.Addr
+		want int
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 1, 7), Port: 5353}, 4},
+		{&net.UDPAddr{IP: net.IPv4(172, 17, 0, 2), Port: 5353}, 3},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::7"), Port: 5353, Zone: "docker0"}, 3},
+		{&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 5353}, 5},
+		{&net.IPAddr{IP: net.IPv4(192, 168, 1, 7)}, 5},
+	} {
+		rrs := answers()
+		got := a.filter(rrs, test.from, 0)
+		if len(got) != test.want {
+			t.Errorf("from %v: got %d records, want %d: %v", test.from, len(got), test.want, got)
+		}
+		for i := len(got); i < len(rrs); i++ {
+			if rrs[i] != nil {
+				t.Errorf("from %v: record %d not cleared", test.from, i)
+			}
+		}
+	}
+
+	// The interface reported by the kernel is used over the source's.
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 7), Port: 5353}
+	if got := a.filter(answers(), from, 3); len(got) != 3 {
+		t.Errorf("got %d records on docker0, want 3: %v", len(got), got)
+	}
+}
+
+func TestAddrScope_Subnet(t *testing.T) {
+	lan := &net.IPNet{IP: net.IPv4(192, 168, 1, 20), Mask: net.CIDRMask(24, 32)}
+	lab := &net.IPNet{IP: net.IPv4(10, 1, 2, 3), Mask: net.CIDRMask(24, 32)}
+	wide := &net.IPNet{IP: net.IPv4(10, 1, 0, 1), Mask: net.CIDRMask(16, 32)}
+	a := &addrScope{
+		subnet: true,
+		ifaces: []scopeIface{{index: 2, name: "eth0", nets: []*net.IPNet{lan, lab, wide}}},
+		read:   time.Now(),
+	}
+
+	hdr := dns.RR_Header{Name: "host.local.", Class: dns.ClassINET, Ttl: 120}
+	answers := func() []dns.RR {
+		return []dns.RR{
+			&dns.A{Hdr: hdr, A: net.IPv4(192, 168, 1, 20)},
+			&dns.A{Hdr: hdr, A: net.IPv4(10, 1, 2, 3)},
+			&dns.A{Hdr: hdr, A: net.IPv4(10, 1, 0, 1)},
+			&dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("fd00::20")},
+		}
+	}
+	for _, test := range []struct {
+		from net.Addr
+		want []string
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 168, 1, 7)}, []string{"192.168.1.20", "fd00::20"}},
+		{&net.UDPAddr{IP: net.IPv4(10, 1, 2, 9)}, []string{"10.1.2.3", "fd00::20"}},
+		{&net.UDPAddr{IP: net.IPv4(10, 1, 9, 9)}, []string{"10.1.2.3", "10.1.0.1", "fd00::20"}},
+		{&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8)}, []string{"192.168.1.20", "10.1.2.3", "10.1.0.1", "fd00::20"}},
+	} {
+		var got []string
+		for _, rr := range a.filter(answers(), test.from, 0) {
+			switch rr := rr.(type) {
+			case *dns.A:
+				got = append(got, rr.A.String())
+			case *dns.AAAA:
+				got = append(got, rr.AAAA.String())
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("from %v: got %v, want %v", test.from, got, test.want)
+		}
+	}
+}
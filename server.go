@@ -1,11 +1,12 @@
 package mdns
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go.net/ipv4"
@@ -50,58 +51,268 @@ type Config struct {
 	// is used.
 	Iface *net.Interface
 
+	// Interfaces, if given and Iface is not, are the interfaces the
+	// multicast groups are joined on, instead of all of the host's.  This
+	// is needed on platforms where interfaces cannot be enumerated, such as
+	// apps on recent Android versions.
+	Interfaces []*net.Interface
+
 	// Whether to set the IP_MULTICAST_LOOP socket option on the multicast sockets
 	// opened.  Setting this to true allows mDNS clients on the same machine to
 	// discover the service. See
 	// http://stackoverflow.com/questions/1719156/is-there-a-way-to-test-multicast-ip-on-same-box
 	DisableMulticastLoopback bool
+
+	// Metrics, if provided, is updated as the server runs.
+	Metrics *ServerMetrics
+
+	// SRP, if provided, also registers the zone's services with an SRP
+	// registrar, and deregisters them when they are withdrawn or the server
+	// shuts down.
+	SRP *SRPClient
+
+	// LLMNR, if set, also answers LLMNR (RFC 4795) queries for the zone's
+	// host names, such as "host" for "host.local.", which Windows hosts use
+	// instead of multicast DNS.
+	LLMNR bool
+
+	// System, if set, publishes the zone's services through the operating
+	// system's DNS-SD daemon, mDNSResponder on macOS, instead of opening the
+	// mDNS port, which the daemon already holds.  The zone must be an
+	// MDNSService or a ServiceSet, and NewServer fails on platforms without
+	// such a daemon.
+	System bool
+
+	// Conns, if given, are already bound mDNS sockets that the server uses
+	// instead of binding its own, such as those passed by systemd socket
+	// activation.  An IPv4 and an IPv6 socket may be given, which are told
+	// apart by their local address.  The server still joins the multicast
+	// groups on them, and closes them on shutdown.
+	Conns []*net.UDPConn
+
+	// DuplicateWindow, if positive, is how long received packets are
+	// remembered, so that copies of a packet from the same source, such as
+	// a query received on several joined interfaces, are handled once.  It
+	// should be well under the one second minimum between repeats of a
+	// query (RFC 6762 section 5.2), so that repeated queries are still
+	// answered; 250ms suits most networks.  Probes are always handled.  By
+	// default every packet is handled.
+	DuplicateWindow time.Duration
+
+	// MaxInFlight, if set, is the number of received packets handled at
+	// once, in the background, so that a slow zone does not hold up the
+	// receive loop.  Overflow is what is done with packets received while
+	// MaxInFlight are being handled.  If MaxInFlight is not set, each
+	// socket's packets are handled one at a time as they are read.
+	MaxInFlight int
+	Overflow    OverflowPolicy
+
+	// QuestionWorkers, if more than one, is the number of questions of a
+	// query looked up in the zone at once, which shortens the responses to
+	// queries with many questions when lookups are slow.  The answers are
+	// still sent in the order of the questions.
+	QuestionWorkers int
+
+	// CacheAnswers, if set, caches the zone's answers to each question, so
+	// that repeated queries, such as browses, do not look them up again.
+	// The cache is emptied when a ServiceSet zone changes; other zones must
+	// call Server.InvalidateAnswers after they change.
+	CacheAnswers bool
+
+	// LowMemory configures the server for constrained devices, such as
+	// OpenWrt routers.  Packets are read into a 9000 byte buffer, the
+	// largest mDNS packet (RFC 6762 section 17), instead of a 64KB one; only
+	// an IPv4 socket is opened, unless Conns are given, so that a single
+	// goroutine receives queries; and MaxInFlight, QuestionWorkers and
+	// CacheAnswers are ignored, so that queries are handled one at a time
+	// in that goroutine without a cache.
+	//
+	// The server's memory use is then bounded by the receive buffer, one
+	// response of at most the same size being built, the duplicate packet
+	// table (see DuplicateWindow), and the records of the zone itself.
+	LowMemory bool
+
+	// OnEvent, if set, is called with the steps of the server's life, such
+	// as probing, announcing and answering queries, for example to show an
+	// application's advertising state.  It is called synchronously from the
+	// server's goroutines, so it must return quickly and must not call
+	// Shutdown.
+	OnEvent func(ServerEvent)
+
+	// Audit, if set, records the queries received, the responses sent and
+	// the services announced and withdrawn, for security reviews and
+	// troubleshooting.
+	Audit *AuditLog
+
+	// Diagnostics, if set, tracks the rate of the queries and responses of
+	// each host the server hears, and the hosts breaking the rules of RFC
+	// 6762, to find the cause of an mDNS storm.
+	Diagnostics *Diagnostics
+
+	// DetectSpoofing, if set, watches the responses of other hosts for signs
+	// of mDNS spoofing: another host answering for a unique name, one whose
+	// records have the cache-flush bit set, with different data while the
+	// first host's answer is still valid, or an instance of a well-known
+	// service, such as a printer or an AirPlay receiver, being answered for
+	// from a different host than before.  They are logged, counted in
+	// ServerMetrics and reported as SpoofSuspected and OriginChanged events.
+	DetectSpoofing bool
+
+	// ResponsePolicy, if set, decides for each question of a query, and the
+	// address the query came from, whether the answers are sent by unicast,
+	// by multicast, or not at all.  By default, and when it returns
+	// ResponseHonorQU, the unicast-response bit of the question decides.
+	ResponsePolicy func(q dns.Question, from net.Addr) ResponsePolicy
+
+	// UnicastOnly answers every query by unicast to the querier, and never
+	// sends to the multicast groups, although the server still listens on
+	// them: services are not probed for, announced or withdrawn with
+	// goodbyes, so other hosts only learn of them by asking.  This suits
+	// networks whose access points throttle or drop multicast.
+	UnicastOnly bool
+
+	// InterfaceScopedAddrs, if set, leaves out of the answers to a query
+	// the A and AAAA records of addresses assigned to interfaces other than
+	// the one the query arrived on, so that, for example, a Docker bridge
+	// address is not advertised to Wi-Fi clients.  The interface is the one
+	// named by the zone of an IPv6 link-local querier, or else the one with
+	// a network holding the querier's address.  Addresses not assigned to
+	// this host are always answered.
+	InterfaceScopedAddrs bool
+
+	// SubnetScopedAddrs, if set, leaves out of the answers to a query the
+	// A or AAAA records, of the querier's address family, of this host's
+	// addresses outside the querier's subnet: the network of the host's
+	// addresses with the longest prefix holding the querier's address.  On
+	// a host with several subnets, even on one interface or socket, the
+	// querier is then only given addresses it can reach directly.  Queries
+	// from outside all of the host's subnets are answered with every
+	// address.
+	SubnetScopedAddrs bool
+
+	// ResponseJitter, if set, delays the answers to queries for shared
+	// records, such as browses, that are not sent by unicast, by a random
+	// time in a window and an optional fixed offset for this host, so that
+	// the answers of large fleets of responders are spread out.  By
+	// default, answers are sent at once.
+	ResponseJitter *ResponseJitter
+
+	// AddrOrder is the order of the A and AAAA records of each host name in
+	// responses: the zone's by default, IPv4 or IPv6 first, or rotated by
+	// one for each response, so that simple clients taking the first
+	// address spread across the host's addresses.
+	AddrOrder AddrOrder
+
+	// Fallback, if set, is asked for the answer to each question that the
+	// zone has no records for, along with the address of the querier.  It
+	// may consult another Zone or a database, or return an NSEC record to
+	// deny that the name has records of the type.  Its answers are not kept
+	// with CacheAnswers, and it is called from the server's goroutines, so
+	// it must be safe for concurrent use.
+	Fallback func(q dns.Question, from net.Addr) []dns.RR
+
+	// AuthKey, if set, is a key shared with the clients of a closed
+	// network, with which every response and announcement is signed: an
+	// EDNS0 option holds the time and an HMAC-SHA256 of its records.
+	// Clients given the key with WithAuthKey drop responses that are not
+	// signed with it.  This keeps out devices that do not know the key, but
+	// does not hide the records, which are sent in the clear.
+	AuthKey []byte
+
+	// Peers are hosts reached over links without multicast, such as
+	// WireGuard or other point-to-point tunnels, whose interfaces are left
+	// out of the multicast groups.  They are sent the server's
+	// announcements and goodbyes by unicast, and can query it by unicast,
+	// as with WithPeers, to be answered the same way.  The port of each
+	// peer defaults to 5353.  A broadcast address may be given for a link
+	// that supports broadcast but not multicast.
+	Peers []*net.UDPAddr
 }
 
+// ResponsePolicy is how the answers to a question are sent, as decided by
+// Config.ResponsePolicy.
+type ResponsePolicy int
+
+const (
+	// ResponseHonorQU sends the answers by unicast if the question asks
+	// for a unicast response, and by multicast otherwise.
+	ResponseHonorQU ResponsePolicy = iota
+	// ResponseUnicast sends the answers by unicast to the querier.
+	ResponseUnicast
+	// ResponseMulticast sends the answers to the multicast group, and by
+	// unicast as well to a one-shot querier, which does not listen on it.
+	ResponseMulticast
+	// ResponseSuppress does not answer the question.
+	ResponseSuppress
+)
+
 // mDNS server is used to listen for mDNS queries and respond if we
 // have a matching local record
 type Server struct {
-	config *Config
+	config     *Config      // As given to NewServer; see conf
+	reloaded   atomic.Value // *Config given to Reload, if any
+	reloadLock sync.Mutex   // Serializes Reload
+	metrics    *ServerMetrics
 
 	ipv4List *net.UDPConn
 	ipv6List *net.UDPConn
+	joined   []*net.Interface // Interfaces the groups are joined on, changed with sendLock held
 
 	shutdown     bool
 	shutdownCh   chan struct{}
 	shutdownLock sync.Mutex
 	wg           sync.WaitGroup
+
+	sendLock  sync.Mutex // Serializes sends, so that nothing follows the goodbyes
+	silent    bool       // Set when the goodbyes have been sent
+	suspended bool       // Set between Suspend and Resume
+
+	llmnr4 *ipv4.PacketConn
+	llmnr6 *ipv6.PacketConn
+
+	system systemRegistrar // Set if the services are published by the system
+
+	dedup        *dedup         // Nil if copies of packets are not looked for
+	inFlight     chan struct{}  // Holds a value per packet being handled, if bounded
+	answers      *answerCache   // Set if answers are cached
+	spoof        *spoofDetector // Set if spoofing is looked for
+	scope        *addrScope     // Set if addresses are scoped to the querier
+	rotation     uint32         // Responses whose addresses were rotated, read atomically
+	peers        []*net.UDPAddr // Config.Peers, with their ports defaulted, changed with sendLock held
+	jitterOffset time.Duration  // Fixed part of the response delay
+
+	watchLock sync.Mutex
+	watches   map[chan ServerEvent]struct{} // Receive conflicts while probing
+	watching  int32                         // Number of watches, read atomically
+
+	announced chan struct{} // Closed when the initial announcements are done
+
+	announceLock sync.Mutex
+	announcing   map[string]*MDNSService // Service last announced, by instance key
 }
 
 // NewServer is used to create a new mDNS server from a config
 func NewServer(config *Config) (*Server, error) {
-	// Create the listeners
-	// Create wildcard connections (because :5353 can be already taken by other apps)
-	// TODO(reddaly): Handle errors returned by ListenMulticastUDP
-	ipv4List, _ := net.ListenUDP("udp4", mdnsWildcardAddrIPv4)
-	ipv6List, _ := net.ListenUDP("udp6", mdnsWildcardAddrIPv6)
-
-	{
-		p := ipv4.NewPacketConn(ipv4List)
-		if err := p.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-			return nil, err
-		}
-	}
-	{
-		p := ipv6.NewPacketConn(ipv6List)
-		if err := p.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-			return nil, err
-		}
+	if config.System {
+		return newSystemServer(config)
 	}
 
-	{
-		p := ipv4.NewPacketConn(ipv4List)
-		if err := p.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-			return nil, err
+	// Create the listeners
+	var ipv4List, ipv6List *net.UDPConn
+	if len(config.Conns) > 0 {
+		for _, c := range config.Conns {
+			if addr, ok := c.LocalAddr().(*net.UDPAddr); ok && addr.IP != nil && addr.IP.To4() == nil {
+				ipv6List = c
+			} else {
+				ipv4List = c
+			}
 		}
-	}
-	{
-		p := ipv6.NewPacketConn(ipv6List)
-		if err := p.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-			return nil, err
+	} else {
+		// Create wildcard connections (because :5353 can be already taken by other apps)
+		// TODO(reddaly): Handle errors returned by ListenMulticastUDP
+		ipv4List, _ = listenUDP("udp4", mdnsWildcardAddrIPv4)
+		if !config.LowMemory {
+			ipv6List, _ = listenUDP("udp6", mdnsWildcardAddrIPv6)
 		}
 	}
 
@@ -110,49 +321,109 @@ func NewServer(config *Config) (*Server, error) {
 		return nil, fmt.Errorf("[ERR] mdns: Failed to bind to any udp port!")
 	}
 
-	// Join multicast groups to receive announcements
-	p1 := ipv4.NewPacketConn(ipv4List)
-	p2 := ipv6.NewPacketConn(ipv6List)
-	if err := p1.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-		return nil, err
+	// Join multicast groups to receive announcements.  Either listener may
+	// be missing, such as the IPv6 one with LowMemory or on an IPv4-only
+	// host.
+	var p1 *ipv4.PacketConn
+	var p2 *ipv6.PacketConn
+	if ipv4List != nil {
+		p1 = ipv4.NewPacketConn(ipv4List)
+		if err := p1.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
+			return nil, err
+		}
 	}
-	if err := p2.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
-		return nil, err
+	if ipv6List != nil {
+		p2 = ipv6.NewPacketConn(ipv6List)
+		if err := p2.SetMulticastLoopback(!config.DisableMulticastLoopback); err != nil {
+			return nil, err
+		}
 	}
 
+	var joined []*net.Interface
 	if config.Iface != nil {
-		if err := p1.JoinGroup(config.Iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
-			return nil, err
+		if p1 != nil {
+			if err := p1.JoinGroup(config.Iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
+				return nil, err
+			}
 		}
-		if err := p2.JoinGroup(config.Iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
-			return nil, err
+		if p2 != nil {
+			if err := p2.JoinGroup(config.Iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
+				return nil, err
+			}
 		}
+		joined = append(joined, config.Iface)
 	} else {
-		ifaces, err := net.Interfaces()
-		if err != nil {
-			return nil, err
+		ifaces := config.Interfaces
+		if len(ifaces) == 0 {
+			all, err := multicastInterfaces()
+			if err != nil {
+				return nil, err
+			}
+			for i := range all {
+				ifaces = append(ifaces, &all[i])
+			}
 		}
-		errCount1, errCount2 := 0, 0
 		for _, iface := range ifaces {
-			if err := p1.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv4}); err != nil {
-				errCount1++
-			}
-			if err := p2.JoinGroup(&iface, &net.UDPAddr{IP: mdnsGroupIPv6}); err != nil {
-				errCount2++
+			ok1 := p1 != nil && p1.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv4}) == nil
+			ok2 := p2 != nil && p2.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroupIPv6}) == nil
+			if ok1 || ok2 {
+				joined = append(joined, iface)
 			}
 		}
-		if len(ifaces) == errCount1 && len(ifaces) == errCount2 {
+		if len(joined) == 0 {
 			return nil, fmt.Errorf("Failed to join multicast group on all interfaces!")
 		}
 	}
 
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = new(ServerMetrics)
+	}
 	s := &Server{
 		config:     config,
+		metrics:    metrics,
 		ipv4List:   ipv4List,
 		ipv6List:   ipv6List,
+		joined:     joined,
+		peers:      peerAddrs(config.Peers),
 		shutdownCh: make(chan struct{}),
+		announced:  make(chan struct{}),
+	}
+	if config.DetectSpoofing {
+		s.spoof = newSpoofDetector()
+	}
+	if config.ResponseJitter != nil {
+		s.jitterOffset = config.ResponseJitter.offset()
+	}
+	if config.InterfaceScopedAddrs || config.SubnetScopedAddrs {
+		s.scope = newAddrScope(config.InterfaceScopedAddrs, config.SubnetScopedAddrs)
+	}
+	if config.MaxInFlight > 0 && !config.LowMemory {
+		s.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	if config.CacheAnswers && !config.LowMemory {
+		s.answers = newAnswerCache()
+		if set, ok := config.Zone.(*ServiceSet); ok {
+			set.OnChange(s.answers.invalidate)
+		}
+	}
+	if config.DuplicateWindow > 0 {
+		s.dedup = newDedup(config.DuplicateWindow)
 	}
 
+	if config.LLMNR {
+		if err := s.listenLLMNR(); err != nil {
+			if ipv4List != nil {
+				ipv4List.Close()
+			}
+			if ipv6List != nil {
+				ipv6List.Close()
+			}
+			return nil, err
+		}
+	}
+
+	acquireMulticast()
 	if ipv4List != nil {
 		go s.recv(s.ipv4List)
 	}
@@ -161,14 +432,46 @@ func NewServer(config *Config) (*Server, error) {
 		go s.recv(s.ipv6List)
 	}
 
+	var initial sync.WaitGroup
+	initial.Add(1)
 	s.wg.Add(1)
-	go s.probe()
+	go func() {
+		defer initial.Done()
+		s.probe()
+	}()
+
+	switch z := config.Zone.(type) {
+	case *ServiceSet:
+		s.announceServices(z.Services(), &initial)
+	case *MDNSService:
+		s.register(z)
+	}
 
+	go func() {
+		initial.Wait()
+		close(s.announced)
+	}()
 	return s, nil
 }
 
+// conf returns the server's configuration, as last given to Reload.
+func (s *Server) conf() *Config {
+	if config, ok := s.reloaded.Load().(*Config); ok {
+		return config
+	}
+	return s.config
+}
+
+// Announced returns a channel that is closed once the server has finished
+// announcing the services it started with, or has been shut down.  Services
+// published through the system responder count as announced at once.
+func (s *Server) Announced() <-chan struct{} {
+	return s.announced
+}
+
 // Shutdown is used to shutdown the listener
 func (s *Server) Shutdown() error {
+	config := s.conf()
 	s.shutdownLock.Lock()
 	defer s.shutdownLock.Unlock()
 
@@ -178,7 +481,31 @@ func (s *Server) Shutdown() error {
 
 	s.shutdown = true
 	close(s.shutdownCh)
-	s.unregister()
+
+	if config.Audit != nil {
+		for _, svc := range zoneServices(config.Zone) {
+			config.Audit.service(svc, false)
+		}
+	}
+
+	if s.system != nil {
+		s.system.close()
+		s.wg.Wait()
+		s.deregisterSRP()
+		s.event(ServerEvent{Type: ShutdownComplete})
+		return nil
+	}
+
+	// A response sent after the goodbyes would bring the records back, so
+	// nothing more is sent once they are.
+	s.sendLock.Lock()
+	s.silent = true
+	if !s.suspended {
+		if err := s.multicast(s.goodbyes()...); err != nil {
+			log.Printf("[ERR] mdns: Failed to send goodbyes: %v", err)
+		}
+	}
+	s.sendLock.Unlock()
 
 	if s.ipv4List != nil {
 		s.ipv4List.Close()
@@ -186,17 +513,48 @@ func (s *Server) Shutdown() error {
 	if s.ipv6List != nil {
 		s.ipv6List.Close()
 	}
+	releaseMulticast()
+	if s.llmnr4 != nil {
+		s.llmnr4.Close()
+	}
+	if s.llmnr6 != nil {
+		s.llmnr6.Close()
+	}
 
 	s.wg.Wait()
+	s.deregisterSRP()
+	s.event(ServerEvent{Type: ShutdownComplete})
 	return nil
 }
 
+// deregisterSRP deregisters the zone's services from the SRP registrar, if
+// any.
+func (s *Server) deregisterSRP() {
+	config := s.conf()
+	if config.SRP == nil {
+		return
+	}
+	for _, svc := range zoneServices(config.Zone) {
+		if err := config.SRP.Deregister(svc); err != nil {
+			log.Printf("[ERR] mdns: Failed to deregister %s from SRP registrar: %v", svc.instanceAddr, err)
+		}
+	}
+}
+
 // recv is a long running routine to receive packets from an interface
 func (s *Server) recv(c *net.UDPConn) {
 	if c == nil {
 		return
 	}
-	buf := make([]byte, 65536)
+	buf := make([]byte, maxPacketSize(s.conf()))
+	v6 := c == s.ipv6List
+	var oob []byte   // Set if the kernel reports its drops or packet info
+	var drops uint32 // Kernel drops already counted
+	reportsDrops := enableDropReports(c)
+	reportsInfo := enablePacketInfo(c, v6)
+	if reportsDrops || reportsInfo {
+		oob = make([]byte, 128)
+	}
 	for {
 		s.shutdownLock.Lock()
 		if s.shutdown {
@@ -204,28 +562,118 @@ func (s *Server) recv(c *net.UDPConn) {
 			return
 		}
 		s.shutdownLock.Unlock()
-		n, from, err := c.ReadFrom(buf)
+		n, from, info, err := s.read(c, buf, oob, v6, &drops)
 		if err != nil {
 			continue
 		}
-		if err := s.parsePacket(buf[:n], from); err != nil {
-			log.Printf("[ERR] mdns: Failed to handle query: %v", err)
+		if s.dedup != nil && s.dedup.duplicate(buf[:n], from, time.Now()) {
+			atomic.AddUint64(&s.metrics.DuplicatePackets, 1)
+			continue
 		}
+		s.handlePacket(buf[:n], from, info)
+	}
+}
+
+// maxPacketSize returns the size of the buffer packets are received in.
+func maxPacketSize(config *Config) int {
+	if config.LowMemory {
+		return 9000
 	}
+	return 65536
 }
 
-// parsePacket is used to parse an incoming packet
-func (s *Server) parsePacket(packet []byte, from net.Addr) error {
-	var msg dns.Msg
-	if err := msg.Unpack(packet); err != nil {
+// read reads a packet from c.  If oob is set, it also returns where the
+// packet arrived, and counts the packets the kernel reports it dropped,
+// drops being the count already seen.  v6 is set if c is an IPv6 socket.
+func (s *Server) read(c *net.UDPConn, buf, oob []byte, v6 bool, drops *uint32) (int, net.Addr, packetInfo, error) {
+	if oob == nil {
+		n, from, err := c.ReadFrom(buf)
+		return n, from, packetInfo{}, err
+	}
+	n, oobn, _, from, err := c.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return 0, nil, packetInfo{}, err
+	}
+	if total, ok := dropCount(oob[:oobn]); ok && total != *drops {
+		atomic.AddUint64(&s.metrics.KernelDrops, uint64(total-*drops))
+		*drops = total
+	}
+	return n, from, parsePacketInfo(oob[:oobn], v6), nil
+}
+
+// queryState holds the messages and buffers used to handle one query.  They
+// are pooled, so that handling a query allocates little beyond what unpacking
+// it and the zone's records take.
+type queryState struct {
+	query dns.Msg
+	resp  dns.Msg
+
+	multicast []dns.RR
+	unicast   []dns.RR
+	buf       []byte     // Packing buffer, grown as needed
+	from      net.Addr   // Source of the query
+	info      packetInfo // Where the query arrived
+}
+
+var queryStates = sync.Pool{New: func() interface{} { return new(queryState) }}
+
+// release clears the references st holds and returns it to the pool.
+func (st *queryState) release() {
+	for i := range st.multicast {
+		st.multicast[i] = nil
+	}
+	for i := range st.unicast {
+		st.unicast[i] = nil
+	}
+	st.multicast, st.unicast = st.multicast[:0], st.unicast[:0]
+	st.query = dns.Msg{}
+	st.resp = dns.Msg{}
+	st.from = nil
+	st.info = packetInfo{}
+	queryStates.Put(st)
+}
+
+// parsePacket is used to parse an incoming packet, received as info tells
+func (s *Server) parsePacket(packet []byte, from net.Addr, info packetInfo) error {
+	config := s.conf()
+	st := queryStates.Get().(*queryState)
+	defer st.release()
+	st.info = info
+	if err := st.query.Unpack(packet); err != nil {
+		atomic.AddUint64(&s.metrics.MalformedPackets, 1)
 		log.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
+		if config.Diagnostics != nil {
+			config.Diagnostics.malformed(from, time.Now())
+		}
 		return err
 	}
-	return s.handleQuery(&msg, from)
+	if config.Diagnostics != nil {
+		config.Diagnostics.record(&st.query, from, time.Now())
+	}
+	if !st.query.Response {
+		atomic.AddUint64(&s.metrics.QueriesReceived, 1)
+		if config.Audit != nil {
+			config.Audit.query(from, st.query.Question)
+		}
+		if config.OnEvent != nil {
+			questions := append([]dns.Question(nil), st.query.Question...)
+			s.event(ServerEvent{Type: QueryReceived, From: from, Questions: questions, IfIndex: info.ifIndex, Dst: info.dst})
+		}
+	} else {
+		if s.spoof != nil {
+			s.checkSpoofing(&st.query, from)
+		}
+		if config.OnEvent != nil || atomic.LoadInt32(&s.watching) > 0 {
+			s.checkConflicts(&st.query, from)
+		}
+	}
+	return s.handleQuery(st, from)
 }
 
-// handleQuery is used to handle an incoming query
-func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
+// handleQuery is used to handle an incoming query, st.query
+func (s *Server) handleQuery(st *queryState, from net.Addr) error {
+	config := s.conf()
+	query := &st.query
 	if query.Opcode != dns.OpcodeQuery {
 		// "In both multicast query and multicast response messages, the OPCODE MUST
 		// be zero on transmission (only standard queries are currently supported
@@ -250,90 +698,165 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 		return fmt.Errorf("[ERR] mdns: support for DNS requests with high truncated bit not implemented: %v", *query)
 	}
 
-	var unicastAnswer, multicastAnswer []dns.RR
+	if s.Suspended() {
+		return nil
+	}
 
 	// Handle each question
-	for _, q := range query.Question {
-		mrecs, urecs := s.handleQuestion(q)
-		multicastAnswer = append(multicastAnswer, mrecs...)
-		unicastAnswer = append(unicastAnswer, urecs...)
+	st.from = from
+	s.handleQuestions(st)
+	if s.scope != nil {
+		st.multicast = s.scope.filter(st.multicast, from, st.info.ifIndex)
+		st.unicast = s.scope.filter(st.unicast, from, st.info.ifIndex)
 	}
+	s.orderAddrs(st.multicast)
+	s.orderAddrs(st.unicast)
 
-	// See section 18 of RFC 6762 for rules about DNS headers.
-	resp := func(unicast bool) *dns.Msg {
-		// 18.1: ID (Query Identifier)
-		// 0 for multicast response, query.Id for unicast response
-		id := uint16(0)
-		if unicast {
-			id = query.Id
-		}
-
-		var answer []dns.RR
-		if unicast {
-			answer = unicastAnswer
+	if len(st.multicast) > 0 {
+		var err error
+		if d := s.responseDelay(st.multicast); d > 0 {
+			err = s.sendDelayed(st.response(0, st.multicast), from, st.info, d)
 		} else {
-			answer = multicastAnswer
+			err = s.sendMulticastResponse(st.response(0, st.multicast), from, st)
 		}
-		if len(answer) == 0 {
-			return nil
+		if err != nil {
+			return fmt.Errorf("mdns: error sending multicast response: %v", err)
 		}
+		if config.Audit != nil {
+			config.Audit.response(from, st.multicast, false)
+		}
+		s.event(ServerEvent{Type: ResponseSent, From: from, Answers: len(st.multicast), IfIndex: st.info.ifIndex})
+	}
+	if len(st.unicast) > 0 {
+		if err := s.sendResponse(st.response(query.Id, st.unicast), from, st); err != nil {
+			return fmt.Errorf("mdns: error sending unicast response: %v", err)
+		}
+		if config.Audit != nil {
+			config.Audit.response(from, st.unicast, true)
+		}
+		s.event(ServerEvent{Type: ResponseSent, From: from, Answers: len(st.unicast), Unicast: true, IfIndex: st.info.ifIndex})
+	}
+	return nil
+}
 
-		return &dns.Msg{
-			MsgHdr: dns.MsgHdr{
-				Id: id,
-
-				// 18.2: QR (Query/Response) Bit - must be set to 1 in response.
-				Response: true,
-
-				// 18.3: OPCODE - must be zero in response (OpcodeQuery == 0)
-				Opcode: dns.OpcodeQuery,
-
-				// 18.4: AA (Authoritative Answer) Bit - must be set to 1
-				Authoritative: true,
+// response fills in and returns st.resp, a response with the given ID and
+// answers.  See section 18 of RFC 6762 for rules about DNS headers.
+func (st *queryState) response(id uint16, answer []dns.RR) *dns.Msg {
+	st.resp = dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			// 18.1: ID (Query Identifier)
+			// 0 for multicast response, query.Id for unicast response
+			Id: id,
+
+			// 18.2: QR (Query/Response) Bit - must be set to 1 in response.
+			Response: true,
+
+			// 18.3: OPCODE - must be zero in response (OpcodeQuery == 0)
+			Opcode: dns.OpcodeQuery,
+
+			// 18.4: AA (Authoritative Answer) Bit - must be set to 1
+			Authoritative: true,
+
+			// The following fields must all be set to 0:
+			// 18.5: TC (TRUNCATED) Bit
+			// 18.6: RD (Recursion Desired) Bit
+			// 18.7: RA (Recursion Available) Bit
+			// 18.8: Z (Zero) Bit
+			// 18.9: AD (Authentic Data) Bit
+			// 18.10: CD (Checking Disabled) Bit
+			// 18.11: RCODE (Response Code)
+		},
+		// 18.12 pertains to questions (handled by handleQuestion)
+		// 18.13 pertains to resource records (handled by handleQuestion)
 
-				// The following fields must all be set to 0:
-				// 18.5: TC (TRUNCATED) Bit
-				// 18.6: RD (Recursion Desired) Bit
-				// 18.7: RA (Recursion Available) Bit
-				// 18.8: Z (Zero) Bit
-				// 18.9: AD (Authentic Data) Bit
-				// 18.10: CD (Checking Disabled) Bit
-				// 18.11: RCODE (Response Code)
-			},
-			// 18.12 pertains to questions (handled by handleQuestion)
-			// 18.13 pertains to resource records (handled by handleQuestion)
+		// 18.14: Name Compression - responses should be compressed (though see
+		// caveats in the RFC), so set the Compress bit (part of the dns library
+		// API, not part of the DNS packet) to true.
+		Compress: true,
 
-			// 18.14: Name Compression - responses should be compressed (though see
-			// caveats in the RFC), so set the Compress bit (part of the dns library
-			// API, not part of the DNS packet) to true.
-			Compress: true,
+		Answer: answer,
+	}
+	return &st.resp
+}
 
-			Answer: answer,
+// handleQuestions looks up the answers to the questions of st.query, and
+// adds them to st.multicast and st.unicast in the order of the questions.
+// With Config.QuestionWorkers set, the questions of a query that has several
+// are looked up concurrently.
+func (s *Server) handleQuestions(st *queryState) {
+	config := s.conf()
+	questions := st.query.Question
+	if len(questions) > maxQuestions {
+		questions = questions[:maxQuestions]
+	}
+	workers := config.QuestionWorkers
+	if workers < 2 || len(questions) < 2 || config.LowMemory {
+		for _, q := range questions {
+			st.multicast, st.unicast = s.handleQuestion(q, st.from, s.records(q, st.from), st.multicast, st.unicast)
 		}
+		return
 	}
 
-	if mresp := resp(false); mresp != nil {
-		if err := s.sendResponse(mresp, from); err != nil {
-			return fmt.Errorf("mdns: error sending multicast response: %v", err)
-		}
+	if workers > len(questions) {
+		workers = len(questions)
 	}
-	if uresp := resp(true); uresp != nil {
-		if err := s.sendResponse(uresp, from); err != nil {
-			return fmt.Errorf("mdns: error sending unicast response: %v", err)
-		}
+	answers := make([][]dns.RR, len(questions))
+	next := int32(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= len(questions) {
+					return
+				}
+				answers[i] = s.records(questions[i], st.from)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, q := range questions {
+		st.multicast, st.unicast = s.handleQuestion(q, st.from, answers[i], st.multicast, st.unicast)
 	}
-	return nil
 }
 
-// handleQuestion is used to handle an incoming question
+// records returns the zone's answer to q, from the answer cache if there is
+// one, or else that of the fallback for a query from from.
+func (s *Server) records(q dns.Question, from net.Addr) []dns.RR {
+	config := s.conf()
+	var recs []dns.RR
+	if s.answers != nil {
+		recs = s.answers.records(config.Zone, q)
+	} else {
+		recs = config.Zone.Records(q)
+	}
+	if len(recs) == 0 && config.Fallback != nil {
+		recs = config.Fallback(q, from)
+	}
+	return recs
+}
+
+// InvalidateAnswers empties the cache of the zone's answers kept with
+// Config.CacheAnswers.  It must be called after a zone other than a
+// ServiceSet changes.
+func (s *Server) InvalidateAnswers() {
+	if s.answers != nil {
+		s.answers.invalidate()
+	}
+}
+
+// handleQuestion is used to handle an incoming question from from, given the
+// zone's records in answer to it
 //
 // The response to a question may be transmitted over multicast, unicast, or
-// both.  The return values are DNS records for each transmission type.
-func (s *Server) handleQuestion(q dns.Question) (multicastRecs, unicastRecs []dns.RR) {
-	records := s.config.Zone.Records(q)
-
+// both.  The answers are appended to multicastRecs or unicastRecs, which are
+// returned.
+func (s *Server) handleQuestion(q dns.Question, from net.Addr, records []dns.RR, multicastRecs, unicastRecs []dns.RR) ([]dns.RR, []dns.RR) {
+	config := s.conf()
 	if len(records) == 0 {
-		return nil, nil
+		return multicastRecs, unicastRecs
 	}
 
 	// Handle unicast and multicast responses.
@@ -347,69 +870,55 @@ func (s *Server) handleQuestion(q dns.Question) (multicastRecs, unicastRecs []dn
 	//     In the Question Section of a Multicast DNS query, the top bit of the
 	//     qclass field is used to indicate that unicast responses are preferred
 	//     for this particular question.  (See Section 5.4.)
-	if q.Qclass&(1<<15) != 0 {
-		return nil, records
+	unicast := q.Qclass&(1<<15) != 0
+	if config.ResponsePolicy != nil {
+		switch config.ResponsePolicy(q, from) {
+		case ResponseUnicast:
+			unicast = true
+		case ResponseMulticast:
+			unicast = false
+		case ResponseSuppress:
+			return multicastRecs, unicastRecs
+		}
+	}
+	if unicast || config.UnicastOnly {
+		return multicastRecs, append(unicastRecs, records...)
 	}
-	return records, nil
+	return append(multicastRecs, records...), unicastRecs
 }
 
 func (s *Server) probe() {
+	config := s.conf()
 	defer s.wg.Done()
 
-	sd, ok := s.config.Zone.(*MDNSService)
-	if !ok {
+	sd, ok := config.Zone.(*MDNSService)
+	if !ok || config.UnicastOnly {
 		return
 	}
-
-	name := fmt.Sprintf("%s.%s.%s.", sd.Instance, trimDot(sd.Service), trimDot(sd.Domain))
-
-	q := new(dns.Msg)
-	q.SetQuestion(name, dns.TypePTR)
-	q.RecursionDesired = false
-
-	srv := &dns.SRV{
-		Hdr: dns.RR_Header{
-			Name:   name,
-			Rrtype: dns.TypeSRV,
-			Class:  dns.ClassINET,
-			Ttl:    defaultTTL,
-		},
-		Priority: 0,
-		Weight:   0,
-		Port:     uint16(sd.Port),
-		Target:   sd.HostName,
-	}
-	txt := &dns.TXT{
-		Hdr: dns.RR_Header{
-			Name:   name,
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET,
-			Ttl:    defaultTTL,
-		},
-		Txt: sd.TXT,
-	}
-	q.Ns = []dns.RR{srv, txt}
-
-	randomizer := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	for i := 0; i < 3; i++ {
-		if err := s.multicastResponse(q); err != nil {
-			log.Println("[ERR] mdns: failed to send probe:", err.Error())
-		}
-		time.Sleep(time.Duration(randomizer.Intn(250)) * time.Millisecond)
+	if _, err := s.probeServices(context.Background(), []*MDNSService{sd}); err != nil {
+		return
 	}
 
 	resp := new(dns.Msg)
 	resp.MsgHdr.Response = true
+	resp.Answer = append(resp.Answer, config.Zone.Records(dns.Question{Name: sd.instanceAddr, Qtype: dns.TypeANY, Qclass: dns.ClassINET})...)
 
-	// set for query
-	q.SetQuestion(name, dns.TypeANY)
-
-	resp.Answer = append(resp.Answer, s.config.Zone.Records(q.Question[0])...)
-
-	// reset
-	q.SetQuestion(name, dns.TypePTR)
+	if config.Audit != nil {
+		config.Audit.service(sd, true)
+	}
+	if s.announce(func() []*dns.Msg { return []*dns.Msg{resp} }) {
+		s.event(ServerEvent{Type: Announced, Service: sd})
+	}
+}
 
+// announce multicasts unsolicited responses three times, one, then two
+// seconds apart, stopping early if the server shuts down.  Each time it sends
+// the responses current returns, and stops once there are none.  It reports
+// whether the announcements were all sent.
+func (s *Server) announce(current func() []*dns.Msg) bool {
+	if s.conf().UnicastOnly {
+		return false
+	}
 	// From RFC6762
 	//    The Multicast DNS responder MUST send at least two unsolicited
 	//    responses, one second apart. To provide increased robustness against
@@ -418,71 +927,354 @@ func (s *Server) probe() {
 	//    at least a factor of two with every response sent.
 	timeout := 1 * time.Second
 	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	for i := 0; i < 3; i++ {
-		if err := s.multicastResponse(resp); err != nil {
+		resps := current()
+		if len(resps) == 0 {
+			return true
+		}
+		if err := s.multicastResponse(resps...); err != nil {
 			log.Println("[ERR] mdns: failed to send announcement:", err.Error())
 		}
+		if i == 2 {
+			// Done once the last is sent, rather than after another wait.
+			break
+		}
 		select {
 		case <-timer.C:
 			timeout *= 2
 			timer.Reset(timeout)
 		case <-s.shutdownCh:
-			timer.Stop()
-			return
+			return false
 		}
 	}
+	return true
 }
 
-// multicastResponse us used to send a multicast response packet
-func (s *Server) multicastResponse(msg *dns.Msg) error {
-	buf, err := msg.Pack()
-	if err != nil {
-		return err
+// multicastResponse us used to send multicast response packets
+func (s *Server) multicastResponse(msgs ...*dns.Msg) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.silent || s.suspended {
+		return nil
 	}
-	if s.ipv4List != nil {
-		s.ipv4List.WriteToUDP(buf, ipv4Addr)
+	return s.multicast(msgs...)
+}
+
+// multicast sends packets to the multicast groups, in as few system calls
+// as the platform allows.  sendLock must be held.
+func (s *Server) multicast(msgs ...*dns.Msg) error {
+	if s.conf().UnicastOnly {
+		return nil
 	}
-	if s.ipv6List != nil {
-		s.ipv6List.WriteToUDP(buf, ipv6Addr)
+	bufs := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		buf, err := s.packMsg(msg, nil)
+		if err != nil {
+			return err
+		}
+		if buf != nil {
+			bufs = append(bufs, buf)
+		}
+	}
+	if len(bufs) == 0 {
+		return nil
 	}
+	atomic.AddUint64(&s.metrics.AnnouncementsSent, uint64(len(bufs)))
+	s.writeBatch(bufs)
 	return nil
 }
 
-// sendResponse is used to send a response packet
-func (s *Server) sendResponse(resp *dns.Msg, from net.Addr) error {
-	// TODO(reddaly): Respect the unicast argument, and allow sending responses
-	// over multicast.
-	buf, err := resp.Pack()
-	if err != nil {
+// sendResponse is used to send a response packet by unicast to from, packed
+// in st's buffer
+func (s *Server) sendResponse(resp *dns.Msg, from net.Addr, st *queryState) error {
+	buf, err := s.pack(resp, st)
+	if err != nil || buf == nil {
 		return err
 	}
+	return s.write(buf, from)
+}
 
-	// Determine the socket to send from
+// sendMulticastResponse sends a response to a query from from by multicast,
+// packed in st's buffer.  See writeMulticast.
+func (s *Server) sendMulticastResponse(resp *dns.Msg, from net.Addr, st *queryState) error {
+	buf, err := s.pack(resp, st)
+	if err != nil || buf == nil {
+		return err
+	}
+	return s.writeMulticast(buf, from, st.info)
+}
+
+// pack packs resp in st's buffer, growing it if needed.
+func (s *Server) pack(resp *dns.Msg, st *queryState) ([]byte, error) {
+	buf, err := s.packMsg(resp, st.buf)
+	if err != nil || buf == nil {
+		return nil, err
+	}
+	if cap(buf) > cap(st.buf) {
+		st.buf = buf[:cap(buf)]
+	}
+	return buf, nil
+}
+
+// write sends a packed response to from, unless the server is silent or
+// suspended.
+func (s *Server) write(buf []byte, from net.Addr) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.silent || s.suspended {
+		return nil
+	}
+
+	// Determine the socket to send from.  A server without one of the
+	// querier's family, such as one built for fuzzing, drops the response.
 	addr := from.(*net.UDPAddr)
+	conn := s.ipv6List
 	if addr.IP.To4() != nil {
-		_, err = s.ipv4List.WriteToUDP(buf, addr)
-		return err
-	} else {
-		_, err = s.ipv6List.WriteToUDP(buf, addr)
-		return err
+		conn = s.ipv4List
 	}
+	if conn == nil {
+		return nil
+	}
+	atomic.AddUint64(&s.metrics.ResponsesSent, 1)
+	_, err := conn.WriteToUDP(buf, addr)
+	return err
 }
 
-func (s *Server) unregister() error {
-	sd, ok := s.config.Zone.(*MDNSService)
-	if !ok {
+// writeMulticast sends a packed response to a query from from to the
+// multicast group of the querier's address family, on the interface the
+// query arrived on if it is known, unless the server is silent or
+// suspended.  A one-shot querier, which sends from a port other than 5353,
+// does not listen on the group, so it is sent a copy by unicast too (RFC
+// 6762 section 5.1), and a query received by unicast is answered by unicast
+// only (section 5.5).
+func (s *Server) writeMulticast(buf []byte, from net.Addr, info packetInfo) error {
+	addr := from.(*net.UDPAddr)
+	if info.dst != nil && !info.dst.IsMulticast() {
+		return s.write(buf, from)
+	}
+	if addr.Port != 5353 {
+		if err := s.write(buf, from); err != nil {
+			return err
+		}
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.silent || s.suspended || s.conf().UnicastOnly {
 		return nil
 	}
+	var err error
+	if addr.IP.To4() != nil {
+		if s.ipv4List == nil {
+			return nil
+		}
+		var cm *ipv4.ControlMessage
+		if info.ifIndex > 0 {
+			cm = &ipv4.ControlMessage{IfIndex: info.ifIndex}
+		}
+		_, err = ipv4.NewPacketConn(s.ipv4List).WriteTo(buf, cm, ipv4Addr)
+	} else {
+		if s.ipv6List == nil {
+			return nil
+		}
+		var cm *ipv6.ControlMessage
+		if info.ifIndex > 0 {
+			cm = &ipv6.ControlMessage{IfIndex: info.ifIndex}
+		}
+		_, err = ipv6.NewPacketConn(s.ipv6List).WriteTo(buf, cm, ipv6Addr)
+	}
+	if err == nil {
+		atomic.AddUint64(&s.metrics.ResponsesSent, 1)
+	}
+	return err
+}
+
+// Announce tells the network about a service that was added to, or changed
+// in, the server's ServiceSet.  The announcement is repeated in the
+// background as required by section 8.3 of RFC 6762.
+func (s *Server) Announce(svc *MDNSService) {
+	s.announceService(svc, nil)
+}
+
+// announceService announces svc in the background, and marks done, if not
+// nil, once the announcement is finished.
+func (s *Server) announceService(svc *MDNSService, done *sync.WaitGroup) {
+	s.announceServices([]*MDNSService{svc}, done)
+}
 
-	sd.TTL = 0
-	name := fmt.Sprintf("%s.%s.%s.", sd.Instance, trimDot(sd.Service), trimDot(sd.Domain))
+// announceServices announces svcs in the background, sending the
+// announcements of all of them together each time, and marks done, if not
+// nil, once the announcements are finished.  The returned channel receives
+// whether they were all sent, rather than cut short by shutdown.
+func (s *Server) announceServices(svcs []*MDNSService, done *sync.WaitGroup) <-chan bool {
+	config := s.conf()
+	result := make(chan bool, 1)
+	if config.Audit != nil {
+		for _, svc := range svcs {
+			config.Audit.service(svc, true)
+		}
+	}
+	if s.system != nil {
+		s.shutdownLock.Lock()
+		defer s.shutdownLock.Unlock()
+		if s.shutdown {
+			result <- false
+			return result
+		}
+		for _, svc := range svcs {
+			if err := s.system.register(svc); err != nil {
+				log.Printf("[ERR] mdns: Failed to publish %s through the system responder: %v", svc.instanceAddr, err)
+			}
+			s.register(svc)
+		}
+		result <- true
+		return result
+	}
 
-	q := new(dns.Msg)
-	q.SetQuestion(name, dns.TypeANY)
+	resps := make([]*dns.Msg, 0, len(svcs))
+	for _, svc := range svcs {
+		resp := new(dns.Msg)
+		resp.MsgHdr.Response = true
+		resp.Answer = svc.Records(dns.Question{Name: svc.serviceAddr, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+		resp.Answer = append(resp.Answer, svc.subtypePTRs()...)
+		resps = append(resps, resp)
+	}
 
+	s.shutdownLock.Lock()
+	defer s.shutdownLock.Unlock()
+	if s.shutdown || len(resps) == 0 {
+		result <- !s.shutdown
+		return result
+	}
+	s.announceLock.Lock()
+	if s.announcing == nil {
+		s.announcing = make(map[string]*MDNSService)
+	}
+	for _, svc := range svcs {
+		s.announcing[nameKey(svc.instanceAddr)] = svc
+	}
+	s.announceLock.Unlock()
+	// Repeats stop for the services that are announced again, such as with
+	// a new TXT record, or withdrawn, so that the old records do not follow
+	// the new ones or the goodbyes.
+	current := func() []*dns.Msg {
+		s.announceLock.Lock()
+		defer s.announceLock.Unlock()
+		var msgs []*dns.Msg
+		for i, svc := range svcs {
+			if s.announcing[nameKey(svc.instanceAddr)] == svc {
+				msgs = append(msgs, resps[i])
+			}
+		}
+		return msgs
+	}
+
+	s.wg.Add(1)
+	if done != nil {
+		done.Add(1)
+	}
+	go func() {
+		defer s.wg.Done()
+		if done != nil {
+			defer done.Done()
+		}
+		ok := s.announce(current)
+		if ok {
+			for _, svc := range svcs {
+				s.event(ServerEvent{Type: Announced, Service: svc})
+			}
+		}
+		result <- ok
+	}()
+	for _, svc := range svcs {
+		s.register(svc)
+	}
+	return result
+}
+
+// register registers svc with the SRP registrar, if any, in the background.
+// The caller must hold shutdownLock or be creating the server.
+func (s *Server) register(svc *MDNSService) {
+	config := s.conf()
+	if config.SRP == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := config.SRP.Register(svc); err != nil {
+			log.Printf("[ERR] mdns: Failed to register %s with SRP registrar: %v", svc.instanceAddr, err)
+		}
+	}()
+}
+
+// Withdraw sends goodbye packets for a service that was removed from the
+// server's ServiceSet, so that other hosts forget it at once rather than when
+// its records expire.  The addresses of the service's host are not withdrawn,
+// as other services may share the host.
+func (s *Server) Withdraw(svc *MDNSService) error {
+	config := s.conf()
+	if config.Audit != nil {
+		config.Audit.service(svc, false)
+	}
+	if config.SRP != nil {
+		if err := config.SRP.Deregister(svc); err != nil {
+			log.Printf("[ERR] mdns: Failed to deregister %s from SRP registrar: %v", svc.instanceAddr, err)
+		}
+	}
+	if s.system != nil {
+		return s.system.deregister(svc)
+	}
+	s.announceLock.Lock()
+	delete(s.announcing, nameKey(svc.instanceAddr))
+	s.announceLock.Unlock()
+	return s.multicastResponse(goodbye(svc, false))
+}
+
+// goodbye returns a packet holding the records of svc with a TTL of zero,
+// which withdraws them as per section 10.1 of RFC 6762.
+func goodbye(svc *MDNSService, addrs bool) *dns.Msg {
 	resp := new(dns.Msg)
 	resp.MsgHdr.Response = true
-	resp.Answer = append(resp.Answer, s.config.Zone.Records(q.Question[0])...)
+	recs := svc.Records(dns.Question{Name: svc.serviceAddr, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	for _, rr := range append(recs, svc.subtypePTRs()...) {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			if !addrs {
+				continue
+			}
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+// goodbyes returns the goodbye packets for every service of the zone.
+func (s *Server) goodbyes() []*dns.Msg {
+	switch z := s.conf().Zone.(type) {
+	case *MDNSService:
+		return []*dns.Msg{goodbye(z, true)}
+	case *ServiceSet:
+		var resps []*dns.Msg
+		for _, svc := range z.Services() {
+			resps = append(resps, goodbye(svc, false))
+		}
+		return resps
+	}
+	return nil
+}
 
-	return s.multicastResponse(resp)
+// zoneServices returns the services of a zone that is an MDNSService or a
+// ServiceSet.
+func zoneServices(zone Zone) []*MDNSService {
+	switch z := zone.(type) {
+	case *MDNSService:
+		return []*MDNSService{z}
+	case *ServiceSet:
+		return z.Services()
+	}
+	return nil
 }
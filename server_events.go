@@ -0,0 +1,117 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ServerEventType is the kind of a ServerEvent.
+type ServerEventType int
+
+const (
+	// ProbeStarted reports that the server started probing for its
+	// service's name.
+	ProbeStarted ServerEventType = iota
+	// NameConflict reports that another responder answered for the name of
+	// one of the server's services with a different host or port.
+	NameConflict
+	// Announced reports that a service has been announced.
+	Announced
+	// QueryReceived reports a query.
+	QueryReceived
+	// ResponseSent reports a response to a query.
+	ResponseSent
+	// ShutdownComplete reports that the server has shut down.
+	ShutdownComplete
+	// SpoofSuspected reports a response from another host with different
+	// data for a unique record set already answered for (see
+	// Config.DetectSpoofing).
+	SpoofSuspected
+	// OriginChanged reports an instance of a well-known service answered
+	// for from a different host than before.
+	OriginChanged
+)
+
+func (t ServerEventType) String() string {
+	switch t {
+	case ProbeStarted:
+		return "probe started"
+	case NameConflict:
+		return "name conflict"
+	case Announced:
+		return "announced"
+	case QueryReceived:
+		return "query received"
+	case ResponseSent:
+		return "response sent"
+	case ShutdownComplete:
+		return "shutdown complete"
+	case SpoofSuspected:
+		return "spoof suspected"
+	case OriginChanged:
+		return "origin changed"
+	}
+	return "unknown"
+}
+
+// ServerEvent is a step in the life of a server, reported to
+// Config.OnEvent.
+type ServerEvent struct {
+	Type ServerEventType
+
+	// Service is the service probed, announced or in conflict.
+	Service *MDNSService
+
+	// From is the source of a query or conflicting response, or the
+	// destination of a response.
+	From net.Addr
+
+	// Questions are the questions of a query.
+	Questions []dns.Question
+
+	// Answers is the number of records in a response, and Unicast whether
+	// it was a unicast response.
+	Answers int
+	Unicast bool
+
+	// IfIndex is the index of the interface a query arrived on, and Dst
+	// the address it was sent to, where the platform reports them.  They
+	// are zero otherwise.
+	IfIndex int
+	Dst     net.IP
+
+	// Record is the record of a SpoofSuspected or OriginChanged event, and
+	// Previous the host that answered for its name before.
+	Record   dns.RR
+	Previous net.Addr
+}
+
+// event reports ev to Config.OnEvent, if set.
+func (s *Server) event(ev ServerEvent) {
+	config := s.conf()
+	if config.OnEvent != nil {
+		config.OnEvent(ev)
+	}
+}
+
+// checkConflicts reports a NameConflict for each SRV record of msg, a
+// response received from another host, that claims the name of one of the
+// server's services with another host or port.
+func (s *Server) checkConflicts(msg *dns.Msg, from net.Addr) {
+	for _, rrs := range [][]dns.RR{msg.Answer, msg.Extra} {
+		for _, rr := range rrs {
+			srv, ok := rr.(*dns.SRV)
+			if !ok || srv.Hdr.Ttl == 0 {
+				continue
+			}
+			for _, svc := range zoneServices(s.conf().Zone) {
+				if strings.EqualFold(srv.Hdr.Name, svc.instanceAddr) &&
+					(!strings.EqualFold(srv.Target, svc.HostName) || int(srv.Port) != svc.Port) {
+					s.conflict(ServerEvent{Type: NameConflict, Service: svc, From: from})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_CheckConflicts(t *testing.T) {
+	svc := makeService(t)
+	var events []ServerEvent
+	s := &Server{config: &Config{Zone: svc, OnEvent: func(ev ServerEvent) { events = append(events, ev) }}}
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+
+	srv := func(target string, port uint16, ttl uint32) dns.RR {
+		return &dns.SRV{
+			Hdr:    dns.RR_Header{Name: "HostName._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Target: target,
+			Port:   port,
+		}
+	}
+	msg := &dns.Msg{}
+	msg.Answer = []dns.RR{srv("testhost.", 80, 120), srv("testhost.", 80, 0)}
+	s.checkConflicts(msg, from)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	msg.Answer = []dns.RR{srv("other.", 80, 120)}
+	msg.Extra = []dns.RR{srv("testhost.", 8080, 120)}
+	s.checkConflicts(msg, from)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	for _, ev := range events {
+		if ev.Type != NameConflict || ev.Service != svc || ev.From != from {
+			t.Errorf("bad event: %+v", ev)
+		}
+	}
+}
+
+func TestServer_OnEvent(t *testing.T) {
+	events := make(chan ServerEvent, 16)
+	serv, err := NewServer(&Config{
+		Zone:    makeService(t),
+		OnEvent: func(ev ServerEvent) { events <- ev },
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(events)
+
+	var last ServerEvent
+	for ev := range events {
+		last = ev
+	}
+	if last.Type != ShutdownComplete {
+		t.Errorf("last event was %v, want %v", last.Type, ShutdownComplete)
+	}
+}
@@ -0,0 +1,46 @@
+package mdns
+
+import "sync/atomic"
+
+// ServerMetrics counts the activity of an mDNS server.
+//
+// A ServerMetrics may be shared by any number of servers.  The counters are
+// updated atomically; use Snapshot to read them.
+type ServerMetrics struct {
+	QueriesReceived   uint64 // Well-formed queries received
+	ResponsesSent     uint64 // Responses sent in answer to queries
+	AnnouncementsSent uint64 // Unsolicited probes, announcements and goodbyes multicast
+	MalformedPackets  uint64 // Packets received that could not be parsed
+	DuplicatePackets  uint64 // Copies of recently received packets ignored
+	DroppedPackets    uint64 // Packets dropped by OverflowDrop
+	SkippedRecords    uint64 // Records left out of messages as they did not pack
+
+	// KernelDrops counts the packets the kernel dropped before the server
+	// read them, because the socket's receive queue was full.  It is only
+	// counted on Linux.  A rising count means the server misses queries
+	// although the network is not quiet.
+	KernelDrops uint64
+
+	// SpoofSuspected and OriginChanges count the signs of spoofing found
+	// with Config.DetectSpoofing.
+	SpoofSuspected uint64
+	OriginChanges  uint64
+}
+
+// Snapshot returns a copy of the counters.  Each is read atomically, but
+// not all at the same instant, so counters updated while it runs may be a
+// little out of step with each other.
+func (m *ServerMetrics) Snapshot() ServerMetrics {
+	return ServerMetrics{
+		QueriesReceived:   atomic.LoadUint64(&m.QueriesReceived),
+		ResponsesSent:     atomic.LoadUint64(&m.ResponsesSent),
+		AnnouncementsSent: atomic.LoadUint64(&m.AnnouncementsSent),
+		MalformedPackets:  atomic.LoadUint64(&m.MalformedPackets),
+		DuplicatePackets:  atomic.LoadUint64(&m.DuplicatePackets),
+		DroppedPackets:    atomic.LoadUint64(&m.DroppedPackets),
+		SkippedRecords:    atomic.LoadUint64(&m.SkippedRecords),
+		KernelDrops:       atomic.LoadUint64(&m.KernelDrops),
+		SpoofSuspected:    atomic.LoadUint64(&m.SpoofSuspected),
+		OriginChanges:     atomic.LoadUint64(&m.OriginChanges),
+	}
+}
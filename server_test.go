@@ -1,8 +1,12 @@
 package mdns
 
 import (
+	"net"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
 )
 
 func TestServer_StartStop(t *testing.T) {
@@ -59,3 +63,300 @@ func TestServer_Lookup(t *testing.T) {
 		t.Fatalf("record not found")
 	}
 }
+
+func TestServer_Announced(t *testing.T) {
+	set, err := NewServiceSet(makeService(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	select {
+	case <-serv.Announced():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("announcements did not finish")
+	}
+}
+
+func BenchmarkServer_HandleQuery(b *testing.B) {
+	svc, err := NewMDNSService("hostname", "_http._tcp", "local.", "testhost.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: svc})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	q.RecursionDesired = false
+	packet, err := q.Pack()
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5354}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := serv.parsePacket(packet, from, packetInfo{}); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+// slowZone answers every A question with 127.0.0.1 after a delay.
+type slowZone struct {
+	delay time.Duration
+}
+
+func (z *slowZone) Records(q dns.Question) []dns.RR {
+	time.Sleep(z.delay)
+	return []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+		A:   net.IPv4(127, 0, 0, 1),
+	}}
+}
+
+func TestServer_QuestionWorkers(t *testing.T) {
+	s := &Server{config: &Config{Zone: &slowZone{delay: 100 * time.Millisecond}, QuestionWorkers: 4}}
+	st := new(queryState)
+	names := []string{"a.local.", "b.local.", "c.local.", "d.local."}
+	for _, name := range names {
+		st.query.Question = append(st.query.Question, dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	}
+	// The last question asks for a unicast response.
+	st.query.Question[3].Qclass |= 1 << 15
+
+	start := time.Now()
+	s.handleQuestions(st)
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("questions were not looked up concurrently: %v", elapsed)
+	}
+	if len(st.multicast) != 3 || len(st.unicast) != 1 {
+		t.Fatalf("bad answers: %v %v", st.multicast, st.unicast)
+	}
+	for i, rr := range append(st.multicast, st.unicast...) {
+		if rr.Header().Name != names[i] {
+			t.Errorf("answer %d is for %s, want %s", i, rr.Header().Name, names[i])
+		}
+	}
+}
+
+func TestServer_ResponsePolicy(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	s := &Server{config: &Config{
+		Zone: &slowZone{},
+		ResponsePolicy: func(q dns.Question, from net.Addr) ResponsePolicy {
+			if from != peer {
+				t.Errorf("policy called with %v, want %v", from, peer)
+			}
+			switch q.Name {
+			case "unicast.local.":
+				return ResponseUnicast
+			case "multicast.local.":
+				return ResponseMulticast
+			case "suppress.local.":
+				return ResponseSuppress
+			}
+			return ResponseHonorQU
+		},
+	}}
+	st := new(queryState)
+	st.from = peer
+	for _, name := range []string{"unicast.local.", "multicast.local.", "suppress.local.", "qu.local."} {
+		st.query.Question = append(st.query.Question, dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET | 1<<15})
+	}
+	s.handleQuestions(st)
+	if len(st.multicast) != 1 || st.multicast[0].Header().Name != "multicast.local." {
+		t.Errorf("bad multicast answers: %v", st.multicast)
+	}
+	if len(st.unicast) != 2 || st.unicast[0].Header().Name != "unicast.local." || st.unicast[1].Header().Name != "qu.local." {
+		t.Errorf("bad unicast answers: %v", st.unicast)
+	}
+}
+
+func TestServer_MulticastResponse(t *testing.T) {
+	l, err := listenUDP("udp4", mdnsWildcardAddrIPv4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	pl := ipv4.NewPacketConn(l)
+	if err := pl.SetControlMessage(ipv4.FlagDst, true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ifaces, err := multicastInterfaces()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := range ifaces {
+		pl.JoinGroup(&ifaces[i], &net.UDPAddr{IP: mdnsGroupIPv4})
+	}
+
+	serv, err := NewServer(&Config{
+		Zone: &slowZone{},
+		ResponsePolicy: func(q dns.Question, from net.Addr) ResponsePolicy {
+			return ResponseMulticast
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	m := new(dns.Msg)
+	m.SetQuestion("multicast.local.", dns.TypeA)
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.WriteToUDP(buf, ipv4Addr); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	packet := make([]byte, 9000)
+	deadline := time.Now().Add(2 * time.Second)
+	l.SetReadDeadline(deadline)
+	for time.Now().Before(deadline) {
+		n, cm, _, err := pl.ReadFrom(packet)
+		if err != nil {
+			break
+		}
+		resp := new(dns.Msg)
+		if resp.Unpack(packet[:n]) != nil || !resp.Response || len(resp.Answer) == 0 || resp.Answer[0].Header().Name != "multicast.local." {
+			continue
+		}
+		if cm == nil || !cm.Dst.Equal(mdnsGroupIPv4) {
+			t.Fatalf("response not sent to the group: %v", cm)
+		}
+		return
+	}
+	t.Fatalf("multicast response not seen")
+}
+
+func TestServer_Fallback(t *testing.T) {
+	svc := makeService(t)
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 7), Port: 5353}
+	var asked []string
+	s := &Server{config: &Config{
+		Zone: svc,
+		Fallback: func(q dns.Question, from net.Addr) []dns.RR {
+			if from != peer {
+				t.Errorf("fallback called with %v, want %v", from, peer)
+			}
+			asked = append(asked, q.Name)
+			if q.Name != "other.local." {
+				return nil
+			}
+			return (&slowZone{}).Records(q)
+		},
+	}}
+	st := new(queryState)
+	st.from = peer
+	for _, name := range []string{svc.instanceAddr, "other.local.", "missing.local."} {
+		st.query.Question = append(st.query.Question, dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+	}
+	s.handleQuestions(st)
+	if len(asked) != 2 || asked[0] != "other.local." || asked[1] != "missing.local." {
+		t.Errorf("fallback asked for %v", asked)
+	}
+	var fromFallback int
+	for _, rr := range st.multicast {
+		if rr.Header().Name == "other.local." {
+			fromFallback++
+		}
+	}
+	if fromFallback != 1 {
+		t.Errorf("bad answers: %v", st.multicast)
+	}
+}
+
+func TestServer_UnicastOnly(t *testing.T) {
+	s := &Server{config: &Config{Zone: &slowZone{}, UnicastOnly: true}, metrics: new(ServerMetrics)}
+	st := new(queryState)
+	st.query.Question = []dns.Question{{Name: "a.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}}
+	s.handleQuestions(st)
+	if len(st.multicast) != 0 || len(st.unicast) != 1 {
+		t.Errorf("bad answers: %v %v", st.multicast, st.unicast)
+	}
+
+	resp := new(dns.Msg)
+	resp.Answer = st.unicast
+	if err := s.multicast(resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := s.metrics.Snapshot().AnnouncementsSent; got != 0 {
+		t.Errorf("%d packets multicast", got)
+	}
+}
+
+func TestServer_LowMemory(t *testing.T) {
+	serv, err := NewServer(&Config{
+		Zone:            makeService(t),
+		LowMemory:       true,
+		MaxInFlight:     8,
+		QuestionWorkers: 4,
+		CacheAnswers:    true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+	if serv.ipv6List != nil || serv.inFlight != nil || serv.answers != nil {
+		t.Errorf("low memory server has an IPv6 socket, in-flight bound or cache")
+	}
+	if n := maxPacketSize(serv.config); n != 9000 {
+		t.Errorf("receive buffer of %d bytes", n)
+	}
+}
+
+func TestServer_SuspendResume(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_suspend._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	lookup := func() bool {
+		entries := make(chan *ServiceEntry, 4)
+		params := &QueryParam{
+			Service: "_suspend._tcp",
+			Domain:  "local",
+			Timeout: 200 * time.Millisecond,
+			Entries: entries,
+		}
+		if err := Query(params); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return len(entries) > 0
+	}
+
+	serv.Suspend()
+	if !serv.Suspended() {
+		t.Fatalf("server not suspended")
+	}
+	if lookup() {
+		t.Errorf("suspended server answered")
+	}
+
+	serv.Resume()
+	if serv.Suspended() {
+		t.Fatalf("server still suspended")
+	}
+	if !lookup() {
+		t.Errorf("resumed server did not answer")
+	}
+}
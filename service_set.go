@@ -0,0 +1,131 @@
+package mdns
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ServiceSet is a Zone that serves any number of services.  Services may be
+// added, replaced and removed while a Server is using the set; use the
+// Server's Announce and Withdraw methods to tell the network about the
+// change.
+type ServiceSet struct {
+	lock     sync.RWMutex
+	services map[string]*MDNSService // By nameKey of the instance name
+	hooks    []func()                // Called after each change
+}
+
+// NewServiceSet returns a set holding the given services.
+func NewServiceSet(services ...*MDNSService) (*ServiceSet, error) {
+	s := &ServiceSet{services: make(map[string]*MDNSService)}
+	for _, svc := range services {
+		if err := s.Add(svc); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add adds a service to the set.  It fails if the set already holds an
+// instance with the same name.
+func (s *ServiceSet) Add(svc *MDNSService) error {
+	s.lock.Lock()
+	key := nameKey(svc.instanceAddr)
+	if _, ok := s.services[key]; ok {
+		s.lock.Unlock()
+		return fmt.Errorf("mdns: service %s is already registered", svc.instanceAddr)
+	}
+	s.services[key] = svc
+	s.changed()
+	return nil
+}
+
+// Replace replaces the service with the same instance name as svc, and
+// returns the old one.  It fails if there is no such service.
+func (s *ServiceSet) Replace(svc *MDNSService) (*MDNSService, error) {
+	s.lock.Lock()
+	key := nameKey(svc.instanceAddr)
+	old, ok := s.services[key]
+	if !ok {
+		s.lock.Unlock()
+		return nil, fmt.Errorf("mdns: service %s is not registered", svc.instanceAddr)
+	}
+	s.services[key] = svc
+	s.changed()
+	return old, nil
+}
+
+// Remove removes the service with the given instance name, such as
+// "web._http._tcp.local.", and returns it, or nil if there is none.
+func (s *ServiceSet) Remove(instance string) *MDNSService {
+	s.lock.Lock()
+	key := nameKey(dns.Fqdn(instance))
+	svc, ok := s.services[key]
+	if !ok {
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.services, key)
+	s.changed()
+	return svc
+}
+
+// OnChange registers f to be called after each change to the set, such as
+// to invalidate a cache of its records.
+func (s *ServiceSet) OnChange(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hooks = append(s.hooks, f)
+}
+
+// changed unlocks the set, which must be locked after a change, and calls the
+// hooks.
+func (s *ServiceSet) changed() {
+	hooks := s.hooks
+	s.lock.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// Get returns the service with the given instance name, or nil if there is
+// none.
+func (s *ServiceSet) Get(instance string) *MDNSService {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.services[nameKey(dns.Fqdn(instance))]
+}
+
+// Services returns the services in the set, sorted by instance name.
+func (s *ServiceSet) Services() []*MDNSService {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	services := make([]*MDNSService, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].instanceAddr < services[j].instanceAddr })
+	return services
+}
+
+// Records returns the records of every service in the set that answer q,
+// without duplicates, so that services sharing a host or service type do
+// not repeat its address or enumeration records.
+func (s *ServiceSet) Records(q dns.Question) []dns.RR {
+	var recs []dns.RR
+	for _, svc := range s.Services() {
+	next:
+		for _, rr := range svc.Records(q) {
+			for _, r := range recs {
+				if dns.IsDuplicate(r, rr) {
+					continue next
+				}
+			}
+			recs = append(recs, rr)
+		}
+	}
+	return recs
+}
@@ -0,0 +1,62 @@
+package mdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServiceSet_Records(t *testing.T) {
+	a := makeServiceWithServiceName(t, "_foobar._tcp")
+	b := makeServiceWithServiceName(t, "_other._tcp")
+	set, err := NewServiceSet(a, b)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := set.Add(a); err == nil {
+		t.Fatalf("adding a duplicate instance should fail")
+	}
+
+	// Both services share a host, whose address is only returned once.
+	recs := set.Records(dns.Question{Name: "testhost.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if len(recs) != 1 {
+		t.Fatalf("bad records: %v", recs)
+	}
+
+	recs = set.Records(dns.Question{Name: "_services._dns-sd._udp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	if len(recs) != 2 {
+		t.Fatalf("bad records: %v", recs)
+	}
+
+	if set.Remove("hostname._other._tcp.local") != b {
+		t.Fatalf("remove failed")
+	}
+	if recs := set.Records(dns.Question{Name: "_other._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}); len(recs) != 0 {
+		t.Fatalf("removed service still answered: %v", recs)
+	}
+}
+
+func TestServiceSet_OnChange(t *testing.T) {
+	set, err := NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	changes := 0
+	set.OnChange(func() { changes++ })
+
+	svc := makeService(t)
+	if err := set.Add(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := set.Add(svc); err == nil {
+		t.Fatalf("adding a duplicate instance should fail")
+	}
+	if _, err := set.Replace(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	set.Remove(svc.instanceAddr)
+	set.Remove(svc.instanceAddr)
+	if changes != 3 {
+		t.Errorf("%d changes, want 3", changes)
+	}
+}
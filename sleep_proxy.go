@@ -0,0 +1,361 @@
+package mdns
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// edns0Owner is the EDNS0 option code of the Owner Option, which
+	// identifies the sleeping host by the MAC address to wake; see
+	// draft-cheshire-edns0-owner-option.  miekg/dns parses it as an
+	// EDNS0_ESU, which has the same code.
+	edns0Owner = 0x4
+
+	defaultSleepProxyMaxLease = 2 * time.Hour
+)
+
+// wakeAddr is where Wake-on-LAN magic packets are sent.
+var wakeAddr = &net.UDPAddr{IP: net.IPv4bcast, Port: 9}
+
+// SleepProxyConfig is used to configure a SleepProxy.
+type SleepProxyConfig struct {
+	// Name is the instance name of the proxy's "_sleep-proxy._udp" service,
+	// which ranks proxies as described by Apple's Bonjour Sleep Proxy.  The
+	// default is "70-35-60-63.1 " followed by the host name, which marks a
+	// proxy that is always on and has average power and metric.
+	Name string
+
+	// Port is the UDP port registrations are received on, default is any
+	// free port.
+	Port int
+
+	// Iface is the multicast interface to serve, default is the system's.
+	Iface *net.Interface
+
+	// MaxLease caps how long a registration lasts, default two hours.
+	MaxLease time.Duration
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// SleepProxy is a Bonjour Sleep Proxy server.  Hosts going to sleep register
+// their multicast DNS records with it using DNS Update, with an EDNS0 Owner
+// option giving the MAC address to wake them with, and the proxy answers
+// queries for the records on their behalf until they wake or the lease
+// expires.
+//
+// The proxy does not capture traffic itself.  Code that sees connection
+// attempts to a sleeping host, such as TCP SYNs found with a packet capture,
+// reports them with Connect, and the proxy wakes the host with a Wake-on-LAN
+// magic packet if it registered a service on the port.
+type SleepProxy struct {
+	config  *SleepProxyConfig
+	conn    *net.UDPConn
+	server  *Server
+	service *MDNSService
+
+	lock  sync.Mutex
+	hosts map[string]*sleepHost // By primary MAC address
+	done  chan struct{}
+}
+
+// sleepHost is a sleeping host registered with a SleepProxy.
+type sleepHost struct {
+	owner   sleepOwner
+	records []dns.RR
+	timer   *time.Timer // Fires when the lease expires
+}
+
+// sleepOwner is the content of an EDNS0 Owner option.
+type sleepOwner struct {
+	seq      uint8
+	primary  net.HardwareAddr // Identifies the host
+	wake     net.HardwareAddr // Wakes the host
+	password []byte           // SecureOn password, if any
+}
+
+// parseOwner parses the data of an EDNS0 Owner option.
+func parseOwner(b []byte) (sleepOwner, error) {
+	if len(b) < 8 || len(b) > 8 && len(b) < 14 || b[0] != 0 {
+		return sleepOwner{}, fmt.Errorf("mdns: bad EDNS0 Owner option")
+	}
+	o := sleepOwner{seq: b[1], primary: net.HardwareAddr(b[2:8])}
+	o.wake = o.primary
+	if len(b) >= 14 {
+		o.wake = net.HardwareAddr(b[8:14])
+		o.password = b[14:]
+	}
+	if n := len(o.password); n != 0 && n != 4 && n != 6 {
+		return sleepOwner{}, fmt.Errorf("mdns: bad EDNS0 Owner option")
+	}
+	return o, nil
+}
+
+// NewSleepProxy starts a sleep proxy, and advertises it on the local link.
+func NewSleepProxy(config *SleepProxyConfig) (*SleepProxy, error) {
+	name := config.Name
+	if name == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		name = "70-35-60-63.1 " + firstLabel(host)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.Port})
+	if err != nil {
+		return nil, err
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	service, err := NewMDNSService(name, "_sleep-proxy._udp", "", "", port, nil, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &SleepProxy{
+		config:  config,
+		conn:    conn,
+		service: service,
+		hosts:   make(map[string]*sleepHost),
+		done:    make(chan struct{}),
+	}
+	p.server, err = NewServer(&Config{Zone: p, Iface: config.Iface})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address registrations are received on.
+func (p *SleepProxy) Addr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+// Shutdown stops the proxy.  Registered records are no longer answered.
+func (p *SleepProxy) Shutdown() error {
+	p.conn.Close()
+	<-p.done
+	p.lock.Lock()
+	for _, h := range p.hosts {
+		h.timer.Stop()
+	}
+	p.hosts = make(map[string]*sleepHost)
+	p.lock.Unlock()
+	return p.server.Shutdown()
+}
+
+// Records returns the records of the proxy's own service and of the sleeping
+// hosts that answer q, which makes the proxy the Zone of its Server.
+func (p *SleepProxy) Records(q dns.Question) []dns.RR {
+	recs := p.service.Records(q)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, h := range p.hosts {
+		for _, rr := range h.records {
+			hdr := rr.Header()
+			if strings.EqualFold(hdr.Name, q.Name) && (q.Qtype == dns.TypeANY || q.Qtype == hdr.Rrtype) {
+				recs = append(recs, rr)
+			}
+		}
+	}
+	return recs
+}
+
+// Connect reports an attempt to connect to the given address, and wakes the
+// sleeping host with the address if it registered a service on the port.  It
+// returns true if a host was woken.
+func (p *SleepProxy) Connect(ip net.IP, port int) bool {
+	p.lock.Lock()
+	var owner *sleepOwner
+	for _, h := range p.hosts {
+		if h.serves(ip, port) {
+			owner = &h.owner
+			break
+		}
+	}
+	p.lock.Unlock()
+	if owner == nil {
+		return false
+	}
+	if err := Wake(owner.wake, owner.password); err != nil {
+		p.logf("[ERR] mdns: Failed to wake %v: %v", owner.wake, err)
+		return false
+	}
+	return true
+}
+
+// serves returns true if the host has the address and a service on the port.
+func (h *sleepHost) serves(ip net.IP, port int) bool {
+	hasIP, hasPort := false, false
+	for _, rr := range h.records {
+		switch rr := rr.(type) {
+		case *dns.A:
+			hasIP = hasIP || rr.A.Equal(ip)
+		case *dns.AAAA:
+			hasIP = hasIP || rr.AAAA.Equal(ip)
+		case *dns.SRV:
+			hasPort = hasPort || int(rr.Port) == port
+		}
+	}
+	return hasIP && hasPort
+}
+
+// Wake sends a Wake-on-LAN magic packet for the given MAC address, with an
+// optional SecureOn password, to the broadcast address.
+func Wake(mac net.HardwareAddr, password []byte) error {
+	if len(mac) != 6 {
+		return fmt.Errorf("mdns: bad MAC address %v", mac)
+	}
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, mac...)
+	}
+	packet = append(packet, password...)
+	conn, err := net.DialUDP("udp4", nil, wakeAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+// serve handles registrations until the proxy is shut down.
+func (p *SleepProxy) serve() {
+	defer close(p.done)
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(buf[:n]); err != nil || m.Response {
+			continue
+		}
+		resp := p.register(m)
+		if out, err := resp.Pack(); err == nil {
+			p.conn.WriteTo(out, from)
+		}
+	}
+}
+
+// register handles a registration, and returns the response.
+func (p *SleepProxy) register(m *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetRcode(m, dns.RcodeSuccess)
+	if m.Opcode != dns.OpcodeUpdate {
+		resp.Rcode = dns.RcodeNotImplemented
+		return resp
+	}
+
+	var owner *sleepOwner
+	lease := defaultSRPLease
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			switch o := o.(type) {
+			case *dns.EDNS0_ESU:
+				parsed, err := parseOwner([]byte(o.Uri))
+				if err != nil {
+					resp.Rcode = dns.RcodeFormatError
+					return resp
+				}
+				owner = &parsed
+			case *dns.EDNS0_UL:
+				lease = time.Duration(o.Lease) * time.Second
+			}
+		}
+	}
+	if owner == nil {
+		resp.Rcode = dns.RcodeFormatError
+		return resp
+	}
+	maxLease := p.config.MaxLease
+	if maxLease == 0 {
+		maxLease = defaultSleepProxyMaxLease
+	}
+	if lease != 0 {
+		lease = clampDuration(lease, srpMinLease, maxLease)
+	}
+
+	var recs []dns.RR
+	for _, rr := range m.Ns {
+		hdr := rr.Header()
+		if hdr.Class&^cacheFlushBit != dns.ClassINET || !strings.HasSuffix(strings.ToLower(hdr.Name), ".local.") {
+			continue
+		}
+		recs = append(recs, dns.Copy(rr))
+	}
+
+	key := owner.primary.String()
+	p.lock.Lock()
+	if h := p.hosts[key]; h != nil {
+		if owner.seq < h.owner.seq && h.owner.seq-owner.seq < 128 {
+			// A stale, reordered registration.
+			p.lock.Unlock()
+			return p.leaseResponse(resp, lease)
+		}
+		h.timer.Stop()
+		delete(p.hosts, key)
+	}
+	if lease != 0 && len(recs) != 0 {
+		h := &sleepHost{owner: *owner, records: recs}
+		h.timer = time.AfterFunc(lease, func() { p.expire(key, h) })
+		p.hosts[key] = h
+	}
+	p.lock.Unlock()
+	return p.leaseResponse(resp, lease)
+}
+
+// leaseResponse adds the granted lease to a response.
+func (p *SleepProxy) leaseResponse(resp *dns.Msg, lease time.Duration) *dns.Msg {
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: uint32(lease / time.Second)})
+	resp.Extra = append(resp.Extra, opt)
+	return resp
+}
+
+// expire removes a host whose lease ran out, and sends goodbyes for its
+// records, as it has not come back to answer for them.
+func (p *SleepProxy) expire(key string, h *sleepHost) {
+	p.lock.Lock()
+	if p.hosts[key] != h {
+		p.lock.Unlock()
+		return
+	}
+	delete(p.hosts, key)
+	p.lock.Unlock()
+
+	resp := new(dns.Msg)
+	resp.MsgHdr.Response = true
+	for _, rr := range h.records {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		resp.Answer = append(resp.Answer, rr)
+	}
+	if err := p.server.multicastResponse(resp); err != nil {
+		p.logf("[ERR] mdns: Failed to send goodbye: %v", err)
+	}
+}
+
+func (p *SleepProxy) logf(format string, v ...interface{}) {
+	if p.config.Logger != nil {
+		p.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
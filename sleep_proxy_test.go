@@ -0,0 +1,76 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSleepProxy(t *testing.T) {
+	wake, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer wake.Close()
+	old := wakeAddr
+	wakeAddr = wake.LocalAddr().(*net.UDPAddr)
+	defer func() { wakeAddr = old }()
+
+	p, err := NewSleepProxy(&SleepProxyConfig{Name: "70-35-60-63.1 test"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer p.Shutdown()
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	register := func(lease uint32) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate("local.")
+		m.Insert([]dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "sleepy.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: 120}, A: net.IPv4(192, 0, 2, 7)},
+			&dns.SRV{Hdr: dns.RR_Header{Name: "sleepy._ssh._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120}, Port: 22, Target: "sleepy.local."},
+		})
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt := m.IsEdns0()
+		owner := append([]byte{0, 1}, mac...)
+		opt.Option = append(opt.Option,
+			&dns.EDNS0_LOCAL{Code: edns0Owner, Data: owner},
+			&dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: lease})
+		resp, err := dns.Exchange(m, p.Addr().String())
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+
+	if resp := register(600); resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("bad response: %v", resp)
+	}
+	if recs := p.Records(dns.Question{Name: "sleepy.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}); len(recs) != 1 {
+		t.Fatalf("bad records: %v", recs)
+	}
+
+	if p.Connect(net.IPv4(192, 0, 2, 7), 80) {
+		t.Errorf("woke host for a port without a service")
+	}
+	if !p.Connect(net.IPv4(192, 0, 2, 7), 22) {
+		t.Fatalf("host not woken")
+	}
+	wake.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := wake.Read(buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 102 || buf[0] != 0xff || net.HardwareAddr(buf[6:12]).String() != mac.String() {
+		t.Errorf("bad magic packet: %x", buf[:n])
+	}
+
+	// The host registers a lease of zero on waking.
+	register(0)
+	if recs := p.Records(dns.Question{Name: "sleepy.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}); len(recs) != 0 {
+		t.Errorf("records not removed: %v", recs)
+	}
+}
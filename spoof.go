@@ -0,0 +1,205 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// originMemory is how long the source of a well-known service's instance is
+// remembered after its records expire.  A service answered from another
+// host within this time is reported as having changed origin.
+const originMemory = 10 * time.Minute
+
+// watchedServices are the well-known services whose instances are expected
+// to keep answering from the same host.  They are the usual targets of
+// spoofing, as clients send them documents, media or credentials.
+var watchedServices = []string{
+	"_airplay._tcp",
+	"_companion-link._tcp",
+	"_googlecast._tcp",
+	"_hap._tcp",
+	"_http._tcp",
+	"_ipp._tcp",
+	"_ipps._tcp",
+	"_printer._tcp",
+	"_raop._tcp",
+	"_smb._tcp",
+	"_ssh._tcp",
+}
+
+// spoofKey identifies a unique record set as received over one address
+// family, as a dual-stack host answers from an address of each.
+type spoofKey struct {
+	name  string // Lower case
+	rtype uint16
+	v6    bool
+}
+
+// spoofOrigin is the source of the records of a key.
+type spoofOrigin struct {
+	source  string
+	rdata   map[string]bool // Of the records last received from source
+	expires time.Time
+}
+
+// spoofDetector watches the responses on the network for the signs of
+// spoofing described on Config.DetectSpoofing.
+type spoofDetector struct {
+	lock    sync.Mutex
+	unique  map[spoofKey]*spoofOrigin // By record set, while unexpired
+	origins map[spoofKey]*spoofOrigin // By SRV of watched instances
+	swept   time.Time
+}
+
+func newSpoofDetector() *spoofDetector {
+	return &spoofDetector{
+		unique:  make(map[spoofKey]*spoofOrigin),
+		origins: make(map[spoofKey]*spoofOrigin),
+	}
+}
+
+// spoofReport is a sign of spoofing found in a response.
+type spoofReport struct {
+	typ      ServerEventType
+	record   dns.RR
+	previous net.Addr
+}
+
+// check looks at the records of the response msg received from from, and
+// returns the signs of spoofing found.
+func (d *spoofDetector) check(msg *dns.Msg, from net.Addr, now time.Time) []spoofReport {
+	ip := sourceIP(from)
+	if ip == nil {
+		return nil
+	}
+	source := ip.String()
+	v6 := ip.To4() == nil
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if now.Sub(d.swept) >= time.Minute {
+		for k, o := range d.unique {
+			if now.After(o.expires) {
+				delete(d.unique, k)
+			}
+		}
+		for k, o := range d.origins {
+			if now.After(o.expires.Add(originMemory)) {
+				delete(d.origins, k)
+			}
+		}
+		d.swept = now
+	}
+
+	// The records of each set received from this source in this response.
+	sets := make(map[spoofKey][]dns.RR)
+	var order []spoofKey
+	for _, rrs := range [][]dns.RR{msg.Answer, msg.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			// Only records with the cache-flush bit set are unique
+			// (RFC 6762 section 10.2).
+			if hdr.Class&(1<<15) == 0 {
+				continue
+			}
+			k := spoofKey{name: strings.ToLower(hdr.Name), rtype: hdr.Rrtype, v6: v6}
+			if _, ok := sets[k]; !ok {
+				order = append(order, k)
+			}
+			sets[k] = append(sets[k], rr)
+		}
+	}
+
+	var reports []spoofReport
+	for _, k := range order {
+		rrs := sets[k]
+		ttl := rrs[0].Header().Ttl
+		if o := d.unique[k]; o != nil && o.source != source && now.Before(o.expires) {
+			// A goodbye or the same records from another source,
+			// such as a sleep proxy, are not a conflict.
+			for _, rr := range rrs {
+				if rr.Header().Ttl != 0 && !o.rdata[rdata(rr)] {
+					reports = append(reports, spoofReport{typ: SpoofSuspected, record: rr, previous: &net.IPAddr{IP: net.ParseIP(o.source)}})
+					break
+				}
+			}
+		} else if ttl == 0 {
+			delete(d.unique, k)
+		} else {
+			o := &spoofOrigin{source: source, rdata: make(map[string]bool), expires: now.Add(time.Duration(ttl) * time.Second)}
+			for _, rr := range rrs {
+				o.rdata[rdata(rr)] = true
+			}
+			d.unique[k] = o
+		}
+
+		if k.rtype != dns.TypeSRV || ttl == 0 || !watchedInstance(k.name) {
+			continue
+		}
+		o := d.origins[k]
+		expires := now.Add(time.Duration(ttl) * time.Second)
+		switch {
+		case o == nil:
+			d.origins[k] = &spoofOrigin{source: source, expires: expires}
+		case o.source != source:
+			reports = append(reports, spoofReport{typ: OriginChanged, record: rrs[0], previous: &net.IPAddr{IP: net.ParseIP(o.source)}})
+			o.source, o.expires = source, expires
+		default:
+			o.expires = expires
+		}
+	}
+	return reports
+}
+
+// watchedInstance returns true if name is the name of an instance of one of
+// the watchedServices.
+func watchedInstance(name string) bool {
+	labels := dns.SplitDomainName(name)
+	if len(labels) < 4 {
+		return false
+	}
+	service := labels[1] + "." + labels[2]
+	for _, s := range watchedServices {
+		if service == s {
+			return true
+		}
+	}
+	return false
+}
+
+// rdata returns the presentation format of the data of rr, without its
+// header.
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// sourceIP returns the IP address of from, or nil if it has none.
+func sourceIP(from net.Addr) net.IP {
+	switch addr := from.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// checkSpoofing reports the signs of spoofing in msg, a response received
+// from from.
+func (s *Server) checkSpoofing(msg *dns.Msg, from net.Addr) {
+	for _, r := range s.spoof.check(msg, from, time.Now()) {
+		if r.typ == SpoofSuspected {
+			atomic.AddUint64(&s.metrics.SpoofSuspected, 1)
+		} else {
+			atomic.AddUint64(&s.metrics.OriginChanges, 1)
+		}
+		log.Printf("[WARN] mdns: %v: %s from %v, previously answered by %v", r.typ, r.record, from, r.previous)
+		s.event(ServerEvent{Type: r.typ, From: from, Record: r.record, Previous: r.previous})
+	}
+}
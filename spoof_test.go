@@ -0,0 +1,80 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSpoofDetector(t *testing.T) {
+	d := newSpoofDetector()
+	now := time.Now()
+	host := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 9), Port: 5353}
+	proxy := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 5353}
+	rogue := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 66), Port: 5353}
+	v6 := &net.UDPAddr{IP: net.ParseIP("fe80::9"), Port: 5353}
+
+	a := func(ip string, ttl uint32) *dns.Msg {
+		return &dns.Msg{Answer: []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "printer.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | 1<<15, Ttl: ttl},
+			A:   net.ParseIP(ip),
+		}}}
+	}
+	srv := func(ttl uint32) *dns.Msg {
+		return &dns.Msg{Answer: []dns.RR{&dns.SRV{
+			Hdr:    dns.RR_Header{Name: "Printer._ipp._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET | 1<<15, Ttl: ttl},
+			Port:   631,
+			Target: "printer.local.",
+		}}}
+	}
+
+	for _, test := range []struct {
+		msg  *dns.Msg
+		from net.Addr
+		want []ServerEventType
+	}{
+		{a("192.168.0.9", 120), host, nil},
+		{a("192.168.0.9", 120), proxy, nil}, // Same data
+		{a("192.168.0.9", 120), v6, nil},    // Other family
+		{a("192.168.0.66", 120), rogue, []ServerEventType{SpoofSuspected}},
+		{a("192.168.0.66", 0), rogue, nil},  // Goodbye
+		{a("192.168.0.10", 120), host, nil}, // Changed by its owner
+		{srv(120), host, nil},
+		{srv(120), host, nil},
+		{srv(120), rogue, []ServerEventType{OriginChanged}},
+	} {
+		var got []ServerEventType
+		for _, r := range d.check(test.msg, test.from, now) {
+			got = append(got, r.typ)
+			if r.previous == nil {
+				t.Errorf("no previous source for %v", r.record)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v from %v: got %v, want %v", test.msg.Answer[0], test.from, got, test.want)
+		}
+	}
+
+	// Once the records expire, another host may answer for the name, but a
+	// watched service is still reported as having moved.
+	later := now.Add(5 * time.Minute)
+	reports := d.check(srv(120), host, later)
+	if len(reports) != 1 || reports[0].typ != OriginChanged {
+		t.Errorf("got %+v, want an origin change", reports)
+	}
+	if reports := d.check(a("10.0.0.1", 120), rogue, later); len(reports) != 0 {
+		t.Errorf("got %+v after expiry", reports)
+	}
+
+	// Records without the cache-flush bit are shared, and not checked.
+	shared := &dns.Msg{Answer: []dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: "_ipp._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+		Ptr: "Other._ipp._tcp.local.",
+	}}}
+	if reports := d.check(shared, rogue, later); len(reports) != 0 {
+		t.Errorf("got %+v for a shared record", reports)
+	}
+}
@@ -0,0 +1,362 @@
+package mdns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultSRPDomain is the domain services are registered in, as per
+	// section 3.1 of RFC 9665.
+	defaultSRPDomain = "default.service.arpa."
+
+	defaultSRPLease    = 2 * time.Hour
+	defaultSRPKeyLease = 14 * 24 * time.Hour
+
+	// srpKeyFlags marks a KEY record as the key of a host or other entity,
+	// rather than of a zone or user; see section 3.1.2 of RFC 2535.
+	srpKeyFlags = 0x0200
+
+	// srpRetryMax is the longest wait before retrying a failed registration.
+	srpRetryMax = 10 * time.Minute
+)
+
+// SRPClientConfig is used to configure an SRPClient.
+type SRPClientConfig struct {
+	// Registrar is the address of the SRP registrar as "host:port".  The port
+	// defaults to 53.  Required.
+	Registrar string
+
+	// Domain is the domain services are registered in, default
+	// "default.service.arpa".
+	Domain string
+
+	// Key is the P-256 key that the registrations are signed with.  The
+	// registrar gives a host's names to the first key that registers them,
+	// so the same key should be used each time the host starts.  If nil, a
+	// new key is generated.
+	Key *ecdsa.PrivateKey
+
+	// Lease is how long registrations last unless refreshed, default two
+	// hours.  KeyLease is how long the registrar keeps the names for the key
+	// once a registration has lapsed, default fourteen days.
+	Lease    time.Duration
+	KeyLease time.Duration
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// SRPClient registers services with an SRP registrar using the Service
+// Registration Protocol of RFC 9665, as Thread and Matter devices do with
+// their border routers, so that they can be discovered on networks where
+// multicast is unreliable or unavailable.  Registrations are refreshed before
+// their leases expire until they are deregistered or the client is closed.
+//
+// An SRPClient can be given to a Server with Config.SRP, which then registers
+// its services as well as announcing them with multicast.
+type SRPClient struct {
+	config *SRPClientConfig
+	addr   string
+	domain string
+	key    *ecdsa.PrivateKey
+
+	lock   sync.Mutex
+	regs   map[string]*srpRegistration // By instance name
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// srpRegistration is a service kept registered by an SRPClient.
+type srpRegistration struct {
+	svc  *MDNSService
+	stop chan struct{}
+}
+
+// NewSRPClient returns a client for the configured registrar.
+func NewSRPClient(config *SRPClientConfig) (*SRPClient, error) {
+	if config.Registrar == "" {
+		return nil, fmt.Errorf("mdns: an SRP client needs a registrar")
+	}
+	addr := config.Registrar
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	domain := defaultSRPDomain
+	if config.Domain != "" {
+		domain = dns.Fqdn(config.Domain)
+	}
+	key := config.Key
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	} else if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("mdns: SRP keys must use the P-256 curve")
+	}
+	return &SRPClient{
+		config: config,
+		addr:   addr,
+		domain: domain,
+		key:    key,
+		regs:   make(map[string]*srpRegistration),
+	}, nil
+}
+
+// Register registers a service, or updates its registration, and keeps it
+// registered in the background.  If the first attempt fails its error is
+// returned, and the registration is retried in the background.
+func (c *SRPClient) Register(svc *MDNSService) error {
+	reg := &srpRegistration{svc: svc, stop: make(chan struct{})}
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return fmt.Errorf("mdns: SRP client is closed")
+	}
+	if old, ok := c.regs[svc.instanceAddr]; ok {
+		close(old.stop)
+	}
+	c.regs[svc.instanceAddr] = reg
+	c.wg.Add(1)
+	c.lock.Unlock()
+
+	lease, err := c.update(svc, false, c.lease())
+	go c.maintain(reg, lease, err)
+	return err
+}
+
+// Deregister removes a service from the registrar.  The host's addresses
+// stay registered until the lease expires, as other services may share the
+// host.
+func (c *SRPClient) Deregister(svc *MDNSService) error {
+	c.lock.Lock()
+	if reg, ok := c.regs[svc.instanceAddr]; ok {
+		close(reg.stop)
+		delete(c.regs, svc.instanceAddr)
+	}
+	c.lock.Unlock()
+	_, err := c.update(svc, true, c.lease())
+	return err
+}
+
+// Close stops refreshing registrations and removes the registered services
+// and hosts from the registrar, which keeps their names reserved for the key.
+func (c *SRPClient) Close() error {
+	c.lock.Lock()
+	c.closed = true
+	regs := c.regs
+	c.regs = make(map[string]*srpRegistration)
+	for _, reg := range regs {
+		close(reg.stop)
+	}
+	c.lock.Unlock()
+	c.wg.Wait()
+
+	var firstErr error
+	for _, reg := range regs {
+		// A lease of zero removes everything the update describes.
+		if _, err := c.update(reg.svc, false, 0); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// lease returns the configured lease.
+func (c *SRPClient) lease() time.Duration {
+	if c.config.Lease != 0 {
+		return c.config.Lease
+	}
+	return defaultSRPLease
+}
+
+// maintain refreshes a registration at 80% of its lease, or retries it
+// after a failure, until it is stopped.
+func (c *SRPClient) maintain(reg *srpRegistration, lease time.Duration, err error) {
+	defer c.wg.Done()
+	retry := time.Second
+	for {
+		wait := lease * 4 / 5
+		if err != nil {
+			c.logf("[ERR] mdns: Failed to register %s with SRP registrar %s: %v", reg.svc.instanceAddr, c.addr, err)
+			wait = retry
+			if retry *= 2; retry > srpRetryMax {
+				retry = srpRetryMax
+			}
+		} else {
+			retry = time.Second
+		}
+		select {
+		case <-time.After(wait):
+		case <-reg.stop:
+			return
+		}
+		lease, err = c.update(reg.svc, false, c.lease())
+	}
+}
+
+// update sends an SRP update for svc, which registers it or, if remove is
+// true, removes it, and returns the lease granted by the registrar.
+func (c *SRPClient) update(svc *MDNSService, remove bool, lease time.Duration) (time.Duration, error) {
+	keyLease := c.config.KeyLease
+	if keyLease == 0 {
+		keyLease = defaultSRPKeyLease
+	}
+	m, host := c.updateMsg(svc, remove, lease, keyLease)
+
+	now := time.Now()
+	sig := new(dns.SIG)
+	sig.Algorithm = dns.ECDSAP256SHA256
+	sig.KeyTag = c.keyRR(host).KeyTag()
+	sig.SignerName = host
+	sig.Inception = uint32(now.Add(-5 * time.Minute).Unix())
+	sig.Expiration = uint32(now.Add(5 * time.Minute).Unix())
+	buf, err := sig.Sign(c.key, m)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.exchange("udp", buf, m.Id)
+	if err == nil && resp.Truncated {
+		resp, err = c.exchange("tcp", buf, m.Id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("mdns: SRP registrar returned %s", dns.RcodeToString[resp.Rcode])
+	}
+	if opt := resp.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ul, ok := o.(*dns.EDNS0_UL); ok && ul.Lease != 0 {
+				return time.Duration(ul.Lease) * time.Second, nil
+			}
+		}
+	}
+	return lease, nil
+}
+
+// exchange sends a signed update to the registrar and returns its response.
+func (c *SRPClient) exchange(network string, buf []byte, id uint16) (*dns.Msg, error) {
+	co, err := dns.DialTimeout(network, c.addr, wideAreaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer co.Close()
+	co.SetDeadline(time.Now().Add(wideAreaTimeout))
+	if _, err := co.Write(buf); err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := co.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if resp.Id == id {
+			return resp, nil
+		}
+	}
+}
+
+// updateMsg returns an unsigned SRP update for svc, and the host name that
+// signs it.  The update holds the instructions of section 3.2.1 of RFC 9665:
+// the service discovery and description instructions for svc, and the host
+// description instruction for its host.
+func (c *SRPClient) updateMsg(svc *MDNSService, remove bool, lease, keyLease time.Duration) (*dns.Msg, string) {
+	ttl := svc.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	host := firstLabel(svc.HostName) + "." + c.domain
+	instance := escapeLabel(svc.Instance) + "." + trimDot(svc.Service) + "." + c.domain
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(c.domain)
+	ptr := &dns.PTR{Hdr: hdr(trimDot(svc.Service)+"."+c.domain, dns.TypePTR), Ptr: instance}
+	if remove {
+		m.Remove([]dns.RR{ptr})
+		m.RemoveName([]dns.RR{&dns.ANY{Hdr: hdr(instance, dns.TypeANY)}})
+	} else {
+		m.Insert([]dns.RR{ptr})
+		m.RemoveName([]dns.RR{&dns.ANY{Hdr: hdr(instance, dns.TypeANY)}})
+		m.Insert([]dns.RR{
+			&dns.SRV{Hdr: hdr(instance, dns.TypeSRV), Port: uint16(svc.Port), Target: host},
+			&dns.TXT{Hdr: hdr(instance, dns.TypeTXT), Txt: svc.TXT},
+			c.keyRR(instance),
+		})
+	}
+
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: hdr(host, dns.TypeANY)}})
+	var addrs []dns.RR
+	for _, ip := range svc.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			addrs = append(addrs, &dns.A{Hdr: hdr(host, dns.TypeA), A: ip4})
+		} else {
+			addrs = append(addrs, &dns.AAAA{Hdr: hdr(host, dns.TypeAAAA), AAAA: ip})
+		}
+	}
+	m.Insert(append(addrs, c.keyRR(host)))
+
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	opt.Option = append(opt.Option, &dns.EDNS0_UL{
+		Code:     dns.EDNS0UL,
+		Lease:    uint32(lease / time.Second),
+		KeyLease: uint32(keyLease / time.Second),
+	})
+	m.Extra = append(m.Extra, opt)
+	return m, host
+}
+
+// keyRR returns the KEY record of the client's key for the given name.
+func (c *SRPClient) keyRR(name string) *dns.KEY {
+	pub := make([]byte, 64)
+	c.key.X.FillBytes(pub[:32])
+	c.key.Y.FillBytes(pub[32:])
+	key := new(dns.KEY)
+	key.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET, Ttl: defaultTTL}
+	key.Flags = srpKeyFlags
+	key.Protocol = 3
+	key.Algorithm = dns.ECDSAP256SHA256
+	key.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	return key
+}
+
+func (c *SRPClient) logf(format string, v ...interface{}) {
+	if c.config.Logger != nil {
+		c.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// firstLabel returns the first label of a domain name, such as "host" for
+// "host.local.".
+func firstLabel(name string) string {
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[0]
+}
+
+// escapeLabel escapes the dots and backslashes of an instance name, so that
+// it is a single label.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `.`, `\.`).Replace(s)
+}
@@ -0,0 +1,158 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeRegistrar answers SRP updates on a UDP socket, checking their
+// signatures, and sends the updates it accepts to the returned channel.
+func fakeRegistrar(t *testing.T) (string, <-chan *dns.Msg) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	updates := make(chan *dns.Msg, 8)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Errorf("err: %v", err)
+				continue
+			}
+			resp := new(dns.Msg)
+			resp.SetRcode(m, dns.RcodeSuccess)
+			if err := verifySRP(m, buf[:n]); err != nil {
+				t.Errorf("bad signature: %v", err)
+				resp.Rcode = dns.RcodeRefused
+			} else {
+				updates <- m
+			}
+			resp.SetEdns0(dns.DefaultMsgSize, false)
+			opt := resp.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: 60, KeyLease: 600})
+			out, _ := resp.Pack()
+			conn.WriteTo(out, from)
+		}
+	}()
+	return conn.LocalAddr().String(), updates
+}
+
+// verifySRP checks the SIG(0) signature of an update with the KEY record of
+// the signer in the update.
+func verifySRP(m *dns.Msg, buf []byte) error {
+	sig, ok := m.Extra[len(m.Extra)-1].(*dns.SIG)
+	if !ok {
+		return errors.New("no SIG(0) record")
+	}
+	for _, rr := range m.Ns {
+		if key, ok := rr.(*dns.KEY); ok && key.Hdr.Name == sig.SignerName {
+			return sig.Verify(key, buf)
+		}
+	}
+	return errors.New("no KEY record for the signer")
+}
+
+func TestSRPClient_Register(t *testing.T) {
+	addr, updates := fakeRegistrar(t)
+	c, err := NewSRPClient(&SRPClientConfig{Registrar: addr, Lease: time.Hour})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	svc, err := NewMDNSService("My Printer", "_ipp._tcp", "", "printer.local.", 631,
+		[]net.IP{net.IP([]byte{192, 168, 0, 42})}, []string{"rp=ipp/print"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Register(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	m := <-updates
+	if m.Opcode != dns.OpcodeUpdate || m.Question[0].Name != defaultSRPDomain {
+		t.Fatalf("bad update: %v", m)
+	}
+	found := make(map[uint16]dns.RR)
+	for _, rr := range m.Ns {
+		if rr.Header().Class == dns.ClassINET {
+			found[rr.Header().Rrtype] = rr
+		}
+	}
+	if ptr, ok := found[dns.TypePTR].(*dns.PTR); !ok || ptr.Hdr.Name != "_ipp._tcp.default.service.arpa." ||
+		ptr.Ptr != `My\ Printer._ipp._tcp.default.service.arpa.` {
+		t.Errorf("bad PTR: %v", found[dns.TypePTR])
+	}
+	if srv, ok := found[dns.TypeSRV].(*dns.SRV); !ok || srv.Target != "printer.default.service.arpa." || srv.Port != 631 {
+		t.Errorf("bad SRV: %v", found[dns.TypeSRV])
+	}
+	if a, ok := found[dns.TypeA].(*dns.A); !ok || !a.A.Equal(net.IP{192, 168, 0, 42}) {
+		t.Errorf("bad A: %v", found[dns.TypeA])
+	}
+	if _, ok := found[dns.TypeTXT]; !ok {
+		t.Errorf("no TXT record")
+	}
+	var lease uint32
+	for _, o := range m.IsEdns0().Option {
+		if ul, ok := o.(*dns.EDNS0_UL); ok {
+			lease = ul.Lease
+		}
+	}
+	if lease != 3600 {
+		t.Errorf("lease = %d, want 3600", lease)
+	}
+
+	if err := c.Deregister(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m = <-updates
+	removed := false
+	for _, rr := range m.Ns {
+		if ptr, ok := rr.(*dns.PTR); ok && ptr.Hdr.Class == dns.ClassNONE {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Errorf("PTR not removed: %v", m)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSRPClient_Close(t *testing.T) {
+	addr, updates := fakeRegistrar(t)
+	c, err := NewSRPClient(&SRPClientConfig{Registrar: addr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	svc, err := NewMDNSService("hostname", "_foobar._tcp", "", "testhost.", 80, []net.IP{net.IP([]byte{192, 168, 0, 42})}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Register(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	<-updates
+	if err := c.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m := <-updates
+	for _, o := range m.IsEdns0().Option {
+		if ul, ok := o.(*dns.EDNS0_UL); ok && ul.Lease != 0 {
+			t.Errorf("lease = %d, want 0", ul.Lease)
+		}
+	}
+	if err := c.Register(svc); err == nil {
+		t.Errorf("expected error registering with a closed client")
+	}
+}
@@ -0,0 +1,569 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// srpMinLease is the shortest lease a registrar grants, as per section
+	// 5.3 of RFC 9665.
+	srpMinLease = 30 * time.Second
+
+	defaultSRPMaxLease    = 24 * time.Hour
+	defaultSRPMaxKeyLease = 7 * 24 * time.Hour
+)
+
+// SRPRegistrarConfig is used to configure an SRPRegistrar.
+type SRPRegistrarConfig struct {
+	// Domain is the domain services are registered in, default
+	// "default.service.arpa".
+	Domain string
+
+	// Services is the set that registered services are added to, with
+	// their names moved from Domain to "local.".  Required.
+	Services *ServiceSet
+
+	// Server, if provided, announces registered services and withdraws them
+	// when they are removed or their leases expire.  It normally serves
+	// Services.
+	Server *Server
+
+	// MaxLease and MaxKeyLease cap the leases granted to clients, default
+	// one day and seven days.
+	MaxLease    time.Duration
+	MaxKeyLease time.Duration
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// SRPRegistrar is the registrar of the Service Registration Protocol of RFC
+// 9665.  It accepts DNS Updates signed with SIG(0), as sent by an SRPClient or
+// by Thread and Matter devices, and advertises the registered services with
+// multicast DNS, acting as an advertising proxy for devices that sleep or
+// cannot use multicast themselves.
+//
+// The first key to register a host or service instance name owns it until
+// its key lease expires; updates for the name signed with other keys are
+// refused.
+type SRPRegistrar struct {
+	config *SRPRegistrarConfig
+	domain string
+
+	lock     sync.Mutex
+	hosts    map[string]*srpHost  // By lower case host name in domain
+	claims   map[string]*srpClaim // Names owned by keys, by lower case name
+	closers  map[interface{ Close() error }]bool
+	shutdown bool
+	wg       sync.WaitGroup
+}
+
+// srpHost is a host registered with an SRPRegistrar, and its services.
+type srpHost struct {
+	name     string // Host label
+	key      string // Public key of the owner
+	ips      []net.IP
+	services map[string]*MDNSService // By lower case instance name in domain
+	timer    *time.Timer             // Fires when the lease expires
+}
+
+// srpClaim records the key that owns a name.
+type srpClaim struct {
+	key     string
+	expires time.Time
+}
+
+// NewSRPRegistrar returns a registrar that adds registered services to the
+// configured set.  Call Serve or ServePacket to accept registrations.
+func NewSRPRegistrar(config *SRPRegistrarConfig) (*SRPRegistrar, error) {
+	if config.Services == nil {
+		return nil, fmt.Errorf("mdns: an SRP registrar needs a service set")
+	}
+	domain := defaultSRPDomain
+	if config.Domain != "" {
+		domain = strings.ToLower(dns.Fqdn(config.Domain))
+	}
+	return &SRPRegistrar{
+		config:  config,
+		domain:  domain,
+		hosts:   make(map[string]*srpHost),
+		claims:  make(map[string]*srpClaim),
+		closers: make(map[interface{ Close() error }]bool),
+	}, nil
+}
+
+// ServePacket handles updates sent over UDP to conn until it fails or the
+// registrar is shut down.
+func (r *SRPRegistrar) ServePacket(conn net.PacketConn) error {
+	if !r.track(conn) {
+		return fmt.Errorf("mdns: SRP registrar is shut down")
+	}
+	defer r.untrack(conn)
+
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if r.isShutdown() {
+				return nil
+			}
+			return err
+		}
+		if resp := r.handle(buf[:n]); resp != nil {
+			if out, err := resp.Pack(); err == nil {
+				conn.WriteTo(out, from)
+			}
+		}
+	}
+}
+
+// Serve accepts TCP connections on l, and handles the updates sent over
+// them, until it fails or the registrar is shut down.
+func (r *SRPRegistrar) Serve(l net.Listener) error {
+	if !r.track(l) {
+		return fmt.Errorf("mdns: SRP registrar is shut down")
+	}
+	defer r.untrack(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if r.isShutdown() {
+				return nil
+			}
+			return err
+		}
+		if !r.track(conn) {
+			conn.Close()
+			continue
+		}
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer r.untrack(conn)
+			defer conn.Close()
+			for {
+				packet, err := readFrame(conn)
+				if err != nil {
+					return
+				}
+				resp := r.handle(packet)
+				if resp == nil {
+					continue
+				}
+				out, err := resp.Pack()
+				if err != nil || writeFrame(conn, out) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Shutdown stops serving, and withdraws the services registered.
+func (r *SRPRegistrar) Shutdown() error {
+	r.lock.Lock()
+	r.shutdown = true
+	for c := range r.closers {
+		c.Close()
+	}
+	hosts := r.hosts
+	r.hosts = make(map[string]*srpHost)
+	r.lock.Unlock()
+	r.wg.Wait()
+
+	for _, h := range hosts {
+		h.timer.Stop()
+		for _, svc := range h.services {
+			r.withdraw(svc)
+		}
+	}
+	return nil
+}
+
+// track records a listener or connection to close on shutdown, and returns
+// false if the registrar is already shut down.
+func (r *SRPRegistrar) track(c interface{ Close() error }) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.shutdown {
+		return false
+	}
+	r.closers[c] = true
+	return true
+}
+
+func (r *SRPRegistrar) untrack(c interface{ Close() error }) {
+	r.lock.Lock()
+	delete(r.closers, c)
+	r.lock.Unlock()
+}
+
+func (r *SRPRegistrar) isShutdown() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.shutdown
+}
+
+// handle processes an update in wire format, and returns the response, or
+// nil if there should be none.
+func (r *SRPRegistrar) handle(buf []byte) *dns.Msg {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil || m.Response {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.SetRcode(m, dns.RcodeSuccess)
+	if m.Opcode != dns.OpcodeUpdate {
+		resp.Rcode = dns.RcodeNotImplemented
+		return resp
+	}
+	lease, keyLease, rcode := r.update(m, buf)
+	resp.Rcode = rcode
+	if rcode == dns.RcodeSuccess {
+		opt := new(dns.OPT)
+		opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+		opt.SetUDPSize(dns.DefaultMsgSize)
+		opt.Option = append(opt.Option, &dns.EDNS0_UL{
+			Code:     dns.EDNS0UL,
+			Lease:    uint32(lease / time.Second),
+			KeyLease: uint32(keyLease / time.Second),
+		})
+		resp.Extra = append(resp.Extra, opt)
+	}
+	return resp
+}
+
+// srpInstance is a service instance described by an update.
+type srpInstance struct {
+	name   string // Instance name in the registrar's domain
+	remove bool   // Set if the update removes the instance
+	srv    *dns.SRV
+	txt    []string
+}
+
+// update validates an SRP update and applies it, as described in section 5
+// of RFC 9665.  It returns the leases granted and the response code.
+func (r *SRPRegistrar) update(m *dns.Msg, buf []byte) (time.Duration, time.Duration, int) {
+	if len(m.Question) != 1 || m.Question[0].Qtype != dns.TypeSOA ||
+		!strings.EqualFold(dns.Fqdn(m.Question[0].Name), r.domain) {
+		return 0, 0, dns.RcodeNotZone
+	}
+	if len(m.Answer) != 0 || len(m.Extra) == 0 {
+		return 0, 0, dns.RcodeFormatError
+	}
+	sig, ok := m.Extra[len(m.Extra)-1].(*dns.SIG)
+	if !ok {
+		return 0, 0, dns.RcodeRefused
+	}
+
+	// Sort the records of the update section into instructions.
+	deletes := make(map[string]bool) // Names whose records are all deleted
+	instances := make(map[string]*srpInstance)
+	instance := func(name string) *srpInstance {
+		key := strings.ToLower(name)
+		if instances[key] == nil {
+			instances[key] = &srpInstance{name: name}
+		}
+		return instances[key]
+	}
+	var key *dns.KEY
+	var ips []net.IP
+	host := ""
+	for _, rr := range m.Ns {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(r.domain, strings.ToLower(hdr.Name)) {
+			return 0, 0, dns.RcodeNotZone
+		}
+		if hdr.Class == dns.ClassANY && hdr.Rrtype == dns.TypeANY {
+			deletes[strings.ToLower(hdr.Name)] = true
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			if hdr.Class == dns.ClassNONE {
+				instance(rr.Ptr).remove = true
+			} else {
+				instance(rr.Ptr)
+			}
+		case *dns.SRV:
+			instance(hdr.Name).srv = rr
+		case *dns.TXT:
+			instance(hdr.Name).txt = rr.Txt
+		case *dns.KEY:
+			if key != nil && (key.PublicKey != rr.PublicKey || key.Algorithm != rr.Algorithm) {
+				return 0, 0, dns.RcodeFormatError
+			}
+			key = rr
+		case *dns.A:
+			host, ips = hdr.Name, append(ips, rr.A)
+		case *dns.AAAA:
+			host, ips = hdr.Name, append(ips, rr.AAAA)
+		default:
+			return 0, 0, dns.RcodeFormatError
+		}
+	}
+
+	// The host is the signer, whose records must be replaced as a whole.
+	if host != "" && !strings.EqualFold(host, sig.SignerName) {
+		return 0, 0, dns.RcodeFormatError
+	}
+	host = sig.SignerName
+	if key == nil || !deletes[strings.ToLower(host)] || instances[strings.ToLower(host)] != nil {
+		return 0, 0, dns.RcodeFormatError
+	}
+	hostLabel, ok := r.relative(host, 1)
+	if !ok {
+		return 0, 0, dns.RcodeFormatError
+	}
+	key.Hdr.Name = host
+	if err := sig.Verify(key, buf); err != nil {
+		return 0, 0, dns.RcodeRefused
+	}
+	for _, in := range instances {
+		if in.srv != nil && !strings.EqualFold(in.srv.Target, host) {
+			return 0, 0, dns.RcodeFormatError
+		}
+		if in.srv == nil {
+			// An instance without an SRV record is being removed.
+			in.remove = true
+		}
+		if _, ok := r.relative(in.name, 3); !ok {
+			return 0, 0, dns.RcodeFormatError
+		}
+	}
+
+	lease, keyLease := r.leases(m)
+	if lease != 0 && len(ips) == 0 {
+		return 0, 0, dns.RcodeFormatError
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.shutdown {
+		return 0, 0, dns.RcodeServerFailure
+	}
+	now := time.Now()
+	names := []string{strings.ToLower(host)}
+	for name := range instances {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if c := r.claims[name]; c != nil && c.key != key.PublicKey && now.Before(c.expires) {
+			return 0, 0, dns.RcodeYXDomain
+		}
+	}
+	for _, in := range instances {
+		if in.remove {
+			continue
+		}
+		local, _ := r.toLocal(in)
+		if svc := r.config.Services.Get(local); svc != nil && r.owner(local) == nil {
+			// The instance is served by something other than the registrar.
+			return 0, 0, dns.RcodeYXDomain
+		}
+	}
+	for _, name := range names {
+		r.claims[name] = &srpClaim{key: key.PublicKey, expires: now.Add(keyLease)}
+	}
+
+	h := r.hosts[strings.ToLower(host)]
+	if lease == 0 {
+		// A lease of zero removes the host and all of its services.
+		if h != nil {
+			h.timer.Stop()
+			delete(r.hosts, strings.ToLower(host))
+			for _, svc := range h.services {
+				r.withdraw(svc)
+			}
+		}
+		return 0, keyLease, dns.RcodeSuccess
+	}
+	if h == nil {
+		h = &srpHost{name: hostLabel, services: make(map[string]*MDNSService)}
+		r.hosts[strings.ToLower(host)] = h
+	} else {
+		h.timer.Stop()
+	}
+	h.key = key.PublicKey
+	h.ips = ips
+	h.timer = time.AfterFunc(lease, func() { r.expire(strings.ToLower(host), h) })
+
+	for name, in := range instances {
+		if in.remove {
+			if svc := h.services[name]; svc != nil {
+				delete(h.services, name)
+				r.withdraw(svc)
+			}
+			continue
+		}
+		instLabel, service := r.toLocal(in)
+		svc, err := NewMDNSService(instLabel, service, "local.", h.name+".local.", int(in.srv.Port), ips, in.txt)
+		if err != nil {
+			r.logf("[ERR] mdns: Bad SRP registration of %s: %v", in.name, err)
+			continue
+		}
+		h.services[name] = svc
+		r.advertise(svc)
+	}
+	// The host's other services follow its addresses.
+	for name, svc := range h.services {
+		if instances[name] != nil || ipsEqual(svc.IPs, ips) {
+			continue
+		}
+		updated := *svc
+		updated.IPs = ips
+		h.services[name] = &updated
+		r.advertise(&updated)
+	}
+	return lease, keyLease, dns.RcodeSuccess
+}
+
+// leases returns the leases to grant for an update, from the client's
+// request in its Update Lease option.
+func (r *SRPRegistrar) leases(m *dns.Msg) (time.Duration, time.Duration) {
+	maxLease, maxKeyLease := r.config.MaxLease, r.config.MaxKeyLease
+	if maxLease == 0 {
+		maxLease = defaultSRPMaxLease
+	}
+	if maxKeyLease == 0 {
+		maxKeyLease = defaultSRPMaxKeyLease
+	}
+	lease, keyLease := defaultSRPLease, defaultSRPKeyLease
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ul, ok := o.(*dns.EDNS0_UL); ok {
+				lease = time.Duration(ul.Lease) * time.Second
+				keyLease = time.Duration(ul.KeyLease) * time.Second
+				if ul.KeyLease == 0 {
+					keyLease = lease
+				}
+			}
+		}
+	}
+	if lease != 0 {
+		lease = clampDuration(lease, srpMinLease, maxLease)
+	}
+	if keyLease < lease {
+		keyLease = lease
+	}
+	return lease, clampDuration(keyLease, srpMinLease, maxKeyLease)
+}
+
+// expire removes a host and its services when its lease runs out.
+func (r *SRPRegistrar) expire(name string, h *srpHost) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.hosts[name] != h {
+		return
+	}
+	delete(r.hosts, name)
+	for _, svc := range h.services {
+		r.withdraw(svc)
+	}
+}
+
+// owner returns the registered host serving the given instance name in
+// "local.", if any.  The caller must hold the lock.
+func (r *SRPRegistrar) owner(local string) *srpHost {
+	for _, h := range r.hosts {
+		for _, svc := range h.services {
+			if strings.EqualFold(svc.instanceAddr, local) {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// relative returns the labels of name before the registrar's domain, and
+// false unless there are at least n of them.
+func (r *SRPRegistrar) relative(name string, n int) (string, bool) {
+	labels := dns.CountLabel(name) - dns.CountLabel(r.domain)
+	if labels < n || !dns.IsSubDomain(r.domain, strings.ToLower(name)) {
+		return "", false
+	}
+	idx := dns.Split(name)
+	end := idx[labels]
+	return name[:end-1], true
+}
+
+// toLocal returns the instance label and service name of an instance, such
+// as "My Printer" and "_ipp._tcp", and the instance's name in "local.".
+func (r *SRPRegistrar) toLocal(in *srpInstance) (string, string) {
+	rel, _ := r.relative(in.name, 3)
+	labels := dns.SplitDomainName(rel)
+	return unescapeLabel(labels[0]), strings.Join(labels[1:], ".")
+}
+
+// advertise adds or replaces a service in the set, and announces it.
+func (r *SRPRegistrar) advertise(svc *MDNSService) {
+	if _, err := r.config.Services.Replace(svc); err != nil {
+		if err := r.config.Services.Add(svc); err != nil {
+			r.logf("[ERR] mdns: Failed to advertise %s: %v", svc.instanceAddr, err)
+			return
+		}
+	}
+	if r.config.Server != nil {
+		r.config.Server.Announce(svc)
+	}
+}
+
+// withdraw removes a service from the set, and sends goodbyes for it.
+func (r *SRPRegistrar) withdraw(svc *MDNSService) {
+	r.config.Services.Remove(svc.instanceAddr)
+	if r.config.Server != nil {
+		if err := r.config.Server.Withdraw(svc); err != nil {
+			r.logf("[ERR] mdns: Failed to withdraw %s: %v", svc.instanceAddr, err)
+		}
+	}
+}
+
+func (r *SRPRegistrar) logf(format string, v ...interface{}) {
+	if r.config.Logger != nil {
+		r.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// unescapeLabel undoes the escaping of a label in presentation format, such
+// as "My\ Printer" or "a\046b".
+func unescapeLabel(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) {
+			if n, err := strconv.Atoi(s[i+1 : i+4]); err == nil && n < 256 {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		i++
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// clampDuration returns d limited to the range [min, max].
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
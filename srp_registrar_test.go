@@ -0,0 +1,76 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSRPRegistrar(t *testing.T) {
+	set, err := NewServiceSet()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r, err := NewSRPRegistrar(&SRPRegistrarConfig{Services: set})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer r.Shutdown()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	go r.ServePacket(conn)
+
+	c, err := NewSRPClient(&SRPClientConfig{Registrar: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	svc, err := NewMDNSService("My Printer", "_ipp._tcp", "", "printer.local.", 631,
+		[]net.IP{net.IP([]byte{192, 168, 0, 42})}, []string{"rp=ipp/print"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Register(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	got := set.Get("My Printer._ipp._tcp.local.")
+	if got == nil {
+		t.Fatalf("service not registered: %v", set.Services())
+	}
+	if got.HostName != "printer.local." || got.Port != 631 || len(got.TXT) != 1 || got.TXT[0] != "rp=ipp/print" ||
+		len(got.IPs) != 1 || !got.IPs[0].Equal(net.IP{192, 168, 0, 42}) {
+		t.Errorf("bad service: %#v", got)
+	}
+
+	// Another key may not take over the names.
+	other, err := NewSRPClient(&SRPClientConfig{Registrar: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := other.Register(svc); err == nil {
+		t.Errorf("expected error registering with another key")
+	}
+	other.Close()
+
+	if err := c.Deregister(svc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if set.Get("My Printer._ipp._tcp.local.") != nil {
+		t.Errorf("service not removed")
+	}
+}
+
+func TestUnescapeLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		`My\ Printer`: "My Printer",
+		`a\.b`:        "a.b",
+		`a\046b`:      "a.b",
+		`back\\slash`: `back\slash`,
+	} {
+		if got := unescapeLabel(in); got != want {
+			t.Errorf("unescapeLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package mdns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// ssdpDefaultMaxAge is how long an SSDP advertisement without a max-age
+	// is kept, the minimum recommended by the UPnP Device Architecture.
+	ssdpDefaultMaxAge = 1800 * time.Second
+
+	// ssdpSearchInterval is the interval at which searches are repeated,
+	// after backing off from the first ones.
+	ssdpSearchInterval = time.Minute
+)
+
+var (
+	// SSDP group addresses, from section 1 of the UPnP Device Architecture.
+	ssdpAddrIPv4 = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	ssdpAddrIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::c"), Port: 1900}
+)
+
+// ssdpSearch holds the sockets used to discover devices with SSDP: one that
+// M-SEARCH requests are sent from, which receives the responses, and one
+// joined to the SSDP group, which receives NOTIFY advertisements.
+type ssdpSearch struct {
+	conn   *net.UDPConn
+	notify *ipv4.PacketConn // nil if the group could not be joined
+}
+
+// newSSDPSearch opens the sockets for an SSDP search on iface, or on the
+// system's default interface if it is nil.
+func newSSDPSearch(iface *net.Interface, logger *log.Logger) (*ssdpSearch, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	if iface != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(iface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	s := &ssdpSearch{conn: conn}
+
+	var ifaces []*net.Interface
+	if iface != nil {
+		ifaces = []*net.Interface{iface}
+	}
+	// Advertisements are only a help, as searches are repeated, so carry on
+	// without them if the group cannot be joined.
+	if p4, p6, err := listenMulticast(ifaces, true, ssdpAddrIPv4, ssdpAddrIPv6); err != nil {
+		msg := fmt.Sprintf("[WARN] mdns: Failed to listen for SSDP advertisements: %v", err)
+		if logger != nil {
+			logger.Print(msg)
+		} else {
+			log.Print(msg)
+		}
+	} else {
+		if p6 != nil {
+			p6.Close()
+		}
+		s.notify = p4
+	}
+	return s, nil
+}
+
+// Close closes the search's sockets.
+func (s *ssdpSearch) Close() {
+	s.conn.Close()
+	if s.notify != nil {
+		s.notify.Close()
+	}
+}
+
+// search sends an M-SEARCH request for target to the SSDP group.
+func (s *ssdpSearch) search(target string) error {
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddrIPv4.String() + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + target + "\r\n\r\n"
+	_, err := s.conn.WriteToUDP([]byte(req), ssdpAddrIPv4)
+	return err
+}
+
+// parseSSDP parses a search response or NOTIFY advertisement received from
+// the given address.  It returns the device it describes, and whether the
+// device has left the network (ssdp:byebye).  Other packets, such as the
+// M-SEARCH requests of other control points, give an error.
+func parseSSDP(buf []byte, from *net.UDPAddr, now time.Time) (*Device, bool, error) {
+	var header http.Header
+	var typ string
+	byebye := false
+	r := bufio.NewReader(bytes.NewReader(buf))
+	if bytes.HasPrefix(buf, []byte("HTTP/")) {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, false, fmt.Errorf("mdns: SSDP response status %q", resp.Status)
+		}
+		header = resp.Header
+		typ = header.Get("ST")
+	} else {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Body.Close()
+		if req.Method != "NOTIFY" {
+			return nil, false, fmt.Errorf("mdns: not an SSDP advertisement: %s", req.Method)
+		}
+		header = req.Header
+		typ = header.Get("NT")
+		switch nts := header.Get("NTS"); nts {
+		case "ssdp:alive":
+		case "ssdp:byebye":
+			byebye = true
+		default:
+			return nil, false, fmt.Errorf("mdns: unsupported SSDP notification %q", nts)
+		}
+	}
+	usn := header.Get("USN")
+	if usn == "" {
+		return nil, false, fmt.Errorf("mdns: SSDP packet without a USN")
+	}
+
+	d := &Device{
+		Protocol: ProtocolSSDP,
+		ID:       usn,
+		Type:     typ,
+		Location: header.Get("Location"),
+		Info:     make(map[string]string, len(header)),
+		LastSeen: now,
+		expires:  now.Add(ssdpMaxAge(header.Get("Cache-Control"))),
+	}
+	for k, v := range header {
+		d.Info[k] = v[0]
+	}
+	ip := from.IP
+	if u, err := url.Parse(d.Location); err == nil && u.Host != "" {
+		if host := net.ParseIP(u.Hostname()); host != nil {
+			ip = host
+		}
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			d.Port = port
+		} else if u.Scheme == "http" {
+			d.Port = 80
+		} else if u.Scheme == "https" {
+			d.Port = 443
+		}
+	}
+	d.Addrs = []net.IP{ip}
+	return d, byebye, nil
+}
+
+// ssdpMaxAge returns the max-age of a CACHE-CONTROL header, or the default if
+// it has none.
+func ssdpMaxAge(cc string) time.Duration {
+	for _, directive := range strings.Split(cc, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "max-age") {
+			continue
+		}
+		if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return ssdpDefaultMaxAge
+}
+
+// ssdp searches for devices with SSDP, and reports them until ctx is done.
+// Devices are removed when they say goodbye, or when their advertisements
+// run out.
+func (d *Discovery) ssdp(ctx context.Context, s *ssdpSearch) {
+	defer d.wg.Done()
+	defer s.Close()
+
+	target := d.config.SSDPTarget
+	if target == "" {
+		target = "ssdp:all"
+	}
+	ch := make(chan *packet, 32)
+	done := ctx.Done()
+	go readPackets(s.conn.ReadFrom, ch, done)
+	if s.notify != nil {
+		go readPackets(func(b []byte) (int, net.Addr, error) {
+			n, _, from, err := s.notify.ReadFrom(b)
+			return n, from, err
+		}, ch, done)
+	}
+
+	known := make(map[string]*Device) // By USN
+	interval := time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	sweep := time.NewTicker(time.Second)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := s.search(target); err != nil {
+				d.logf("[ERR] mdns: Failed to send SSDP search: %v", err)
+			}
+			timer.Reset(interval)
+			if interval *= 2; interval > ssdpSearchInterval {
+				interval = ssdpSearchInterval
+			}
+		case p := <-ch:
+			dev, byebye, err := parseSSDP(p.buf, p.from, p.at)
+			if err != nil {
+				continue
+			}
+			ok := true
+			switch {
+			case byebye:
+				ok = d.gone(ctx, known, dev.ID)
+			case target != "ssdp:all" && dev.Type != target:
+				// An advertisement for something not searched for.
+			default:
+				ok = d.seen(ctx, known, dev)
+			}
+			if !ok {
+				return
+			}
+		case now := <-sweep.C:
+			if !d.expire(ctx, known, now) {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
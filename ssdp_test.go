@@ -0,0 +1,125 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseSSDP(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 9), Port: 1900}
+	now := time.Now()
+	resp := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"LOCATION: http://192.168.0.10:49152/desc.xml\r\n" +
+		"SERVER: Linux/3.0 UPnP/1.0 Lamp/1.0\r\n" +
+		"ST: urn:schemas-upnp-org:device:Basic:1\r\n" +
+		"USN: uuid:lamp::urn:schemas-upnp-org:device:Basic:1\r\n\r\n"
+	d, byebye, err := parseSSDP([]byte(resp), from, now)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if byebye || d.Protocol != ProtocolSSDP || d.ID != "uuid:lamp::urn:schemas-upnp-org:device:Basic:1" ||
+		d.Type != "urn:schemas-upnp-org:device:Basic:1" || d.Port != 49152 ||
+		len(d.Addrs) != 1 || !d.Addrs[0].Equal(net.IPv4(192, 168, 0, 10)) ||
+		d.Info["Server"] != "Linux/3.0 UPnP/1.0 Lamp/1.0" || !d.expires.Equal(now.Add(100*time.Second)) {
+		t.Errorf("bad device: %+v", d)
+	}
+
+	notify := "NOTIFY * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"NT: upnp:rootdevice\r\n" +
+		"NTS: ssdp:byebye\r\n" +
+		"USN: uuid:lamp::upnp:rootdevice\r\n\r\n"
+	d, byebye, err = parseSSDP([]byte(notify), from, now)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !byebye || d.Type != "upnp:rootdevice" || !d.Addrs[0].Equal(from.IP) || !d.expires.Equal(now.Add(ssdpDefaultMaxAge)) {
+		t.Errorf("bad byebye: %+v", d)
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nST: ssdp:all\r\n\r\n"
+	if _, _, err := parseSSDP([]byte(search), from, now); err == nil {
+		t.Errorf("parsed a search")
+	}
+}
+
+func TestDiscovery_SSDP(t *testing.T) {
+	p4, p6, err := listenMulticast(nil, true, ssdpAddrIPv4, ssdpAddrIPv6)
+	if err != nil || p4 == nil {
+		t.Skipf("cannot join the SSDP group: %v", err)
+	}
+	if p6 != nil {
+		p6.Close()
+	}
+	defer p4.Close()
+
+	const usn = "uuid:ssdptest-lamp::urn:schemas-upnp-org:device:SSDPTest:1"
+	// Answer searches like a device.
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, from, err := p4.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+				continue
+			}
+			resp := "HTTP/1.1 200 OK\r\n" +
+				"CACHE-CONTROL: max-age=1800\r\n" +
+				"LOCATION: http://127.0.0.1:49152/desc.xml\r\n" +
+				"ST: urn:schemas-upnp-org:device:SSDPTest:1\r\n" +
+				"USN: " + usn + "\r\n\r\n"
+			p4.WriteTo([]byte(resp), nil, from)
+		}
+	}()
+
+	d, err := NewDiscovery(context.Background(), &DiscoveryConfig{
+		SSDP:       true,
+		SSDPTarget: "urn:schemas-upnp-org:device:SSDPTest:1",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer d.Close()
+
+	next := func() *DiscoveryEvent {
+		for {
+			select {
+			case ev := <-d.Events():
+				if ev.Device.ID == usn {
+					return ev
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("no event")
+			}
+		}
+	}
+	ev := next()
+	if ev.Type != ServiceAdded || ev.Device.Port != 49152 || ev.Device.Location != "http://127.0.0.1:49152/desc.xml" {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Device)
+	}
+	if devices := d.Devices(); len(devices) != 1 || devices[0].ID != usn {
+		t.Fatalf("bad devices: %v", devices)
+	}
+
+	byebye := "NOTIFY * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"NT: urn:schemas-upnp-org:device:SSDPTest:1\r\n" +
+		"NTS: ssdp:byebye\r\n" +
+		"USN: " + usn + "\r\n\r\n"
+	if _, err := p4.WriteTo([]byte(byebye), nil, ssdpAddrIPv4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ev := next(); ev.Type != ServiceRemoved {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Device)
+	}
+	if devices := d.Devices(); len(devices) != 0 {
+		t.Fatalf("bad devices: %v", devices)
+	}
+}
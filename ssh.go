@@ -0,0 +1,99 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Service types advertised by PublishSSH.
+const (
+	SSHService  = "_ssh._tcp"
+	SFTPService = "_sftp-ssh._tcp"
+)
+
+// PublishSSH advertises the SSH server of this host, listening on port
+// (default 22), as "_ssh._tcp" and "_sftp-ssh._tcp" so that it is listed by
+// Finder and the host pickers of SSH clients.  Shut the returned server down
+// to withdraw the advertisement.
+func PublishSSH(port int) (*Server, error) {
+	services, err := SSHServices(port)
+	if err != nil {
+		return nil, err
+	}
+	set, err := NewServiceSet(services...)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(&Config{Zone: set})
+}
+
+// SSHServices returns the services that PublishSSH advertises, for serving
+// them with other services.  They are named after the host, and the host's
+// name is its short name in the "local." domain rather than the name that
+// os.Hostname returns, which may be in a unicast domain.
+func SSHServices(port int) ([]*MDNSService, error) {
+	if port == 0 {
+		port = 22
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("mdns: could not determine host: %v", err)
+	}
+	host = shortHostName(host)
+	if host == "" {
+		return nil, fmt.Errorf("mdns: host has no name")
+	}
+	ips, err := hostIPs()
+	if err != nil {
+		return nil, err
+	}
+	var services []*MDNSService
+	for _, service := range []string{SSHService, SFTPService} {
+		svc, err := NewMDNSService(host, service, "", host+".local.", port, ips, nil)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// shortHostName returns the first label of a host name, without any unicast
+// domain, such as "box" for "box.example.com".
+func shortHostName(host string) string {
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// hostIPs returns the unicast addresses of the host's interfaces, or its
+// loopback addresses if it has no others.
+func hostIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("mdns: could not determine host IP addresses: %v", err)
+	}
+	var ips, loopback []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		switch ip := ipnet.IP; {
+		case ip.IsLoopback():
+			loopback = append(loopback, ip)
+		case ip.IsGlobalUnicast():
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		ips = loopback
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("mdns: could not determine host IP addresses")
+	}
+	return ips, nil
+}
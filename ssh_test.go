@@ -0,0 +1,38 @@
+package mdns
+
+import (
+	"os"
+	"testing"
+)
+
+func TestShortHostName(t *testing.T) {
+	for host, want := range map[string]string{
+		"box":             "box",
+		"box.example.com": "box",
+		"box.local":       "box",
+	} {
+		if got := shortHostName(host); got != want {
+			t.Errorf("shortHostName(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestSSHServices(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no host name: %v", err)
+	}
+	host = shortHostName(host)
+	services, err := SSHServices(0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(services) != 2 || services[0].Service != SSHService || services[1].Service != SFTPService {
+		t.Fatalf("bad services: %+v", services)
+	}
+	for _, svc := range services {
+		if svc.Instance != host || svc.HostName != host+".local." || svc.Port != 22 || len(svc.IPs) == 0 {
+			t.Errorf("bad service: %+v", svc)
+		}
+	}
+}
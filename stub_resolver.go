@@ -0,0 +1,209 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// stubZones are the zones a StubResolver answers for: "local." and the
+// link-local reverse mapping zones of section 4 of RFC 6762.
+var stubZones = []string{
+	"local.",
+	"254.169.in-addr.arpa.",
+	"8.e.f.ip6.arpa.",
+	"9.e.f.ip6.arpa.",
+	"a.e.f.ip6.arpa.",
+	"b.e.f.ip6.arpa.",
+}
+
+// StubResolverConfig is used to configure a StubResolver.
+type StubResolverConfig struct {
+	// Addr is the address to listen on for UDP and TCP, default
+	// "127.0.0.1:53".
+	Addr string
+
+	// Interface is the multicast interface to query, default is the system's.
+	Interface *net.Interface
+
+	// Timeout is how long a query waits for multicast answers, default 1
+	// second.
+	Timeout time.Duration
+
+	// Cache is used to answer from records already known, and stores the
+	// records found.  The default is a new cache.
+	Cache *Cache
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// StubResolver is a unicast DNS server that answers queries for ".local"
+// names with multicast DNS, so that applications and containers that only
+// speak ordinary DNS can resolve Bonjour names.  Point the system resolver at
+// it for the "local" domain, for example with systemd-resolved's "DNS=" and
+// "Domains=~local", or with a forwarding rule in dnsmasq.
+//
+// Queries for names outside ".local" and the link-local reverse mapping zones
+// are refused.
+type StubResolver struct {
+	config *StubResolverConfig
+	proxy  *DiscoveryProxy // Looks up names, without renaming them
+	udp    *dns.Server
+	tcp    *dns.Server
+}
+
+// NewStubResolver starts a stub resolver listening on the configured
+// address.
+func NewStubResolver(config *StubResolverConfig) (*StubResolver, error) {
+	addr := config.Addr
+	if addr == "" {
+		addr = "127.0.0.1:53"
+	}
+	r := NewStubHandler(config)
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Listen for TCP on the same port, which matters if it was chosen by the
+	// system.
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	r.udp = &dns.Server{PacketConn: pc, Handler: r}
+	r.tcp = &dns.Server{Listener: l, Handler: r}
+	go r.serve(r.udp)
+	go r.serve(r.tcp)
+	return r, nil
+}
+
+// NewStubHandler returns a stub resolver that does not listen itself, to be
+// served by another DNS server through ServeDNS or Answer.  Config.Addr is
+// not used, and Addr returns nil.
+func NewStubHandler(config *StubResolverConfig) *StubResolver {
+	cache := config.Cache
+	if cache == nil {
+		cache = NewCache()
+	}
+	return &StubResolver{
+		config: config,
+		proxy: &DiscoveryProxy{
+			config: &DiscoveryProxyConfig{
+				Interface: config.Interface,
+				Timeout:   config.Timeout,
+				Cache:     cache,
+				Logger:    config.Logger,
+			},
+			domain: "local.",
+		},
+	}
+}
+
+// serve runs one of the resolver's DNS servers.
+func (r *StubResolver) serve(s *dns.Server) {
+	if err := s.ActivateAndServe(); err != nil {
+		r.proxy.logf("[ERR] mdns: Stub resolver stopped: %v", err)
+	}
+}
+
+// Addr returns the UDP address the resolver listens on.
+func (r *StubResolver) Addr() net.Addr {
+	if r.udp == nil {
+		return nil
+	}
+	return r.udp.PacketConn.LocalAddr()
+}
+
+// Shutdown stops the resolver.
+func (r *StubResolver) Shutdown() error {
+	if r.udp == nil {
+		return nil
+	}
+	err := r.udp.Shutdown()
+	if err2 := r.tcp.Shutdown(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// ServeDNS answers a unicast DNS query.
+func (r *StubResolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := r.Answer(req)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		r.proxy.logf("[ERR] mdns: Stub resolver failed to answer %v: %v", w.RemoteAddr(), err)
+	}
+}
+
+// Answer returns the response to a unicast DNS query, without truncating
+// it.  Queries for names outside the resolver's zones are refused.
+func (r *StubResolver) Answer(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = false
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		m.SetRcode(req, dns.RcodeNotImplemented)
+		return m
+	}
+	q := req.Question[0]
+	if !inStubZones(q.Name) {
+		m.SetRcode(req, dns.RcodeRefused)
+		return m
+	}
+	m.Authoritative = true
+
+	answers, extra, err := r.proxy.lookup(dns.Fqdn(q.Name), q.Qtype)
+	if err != nil {
+		r.proxy.logf("[ERR] mdns: Stub resolver failed to query %s: %v", q.Name, err)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		return m
+	}
+	m.Answer = unicastRecords(answers)
+	m.Extra = unicastRecords(extra)
+	return m
+}
+
+// inStubZones returns true if name is in one of the zones a StubResolver
+// answers for.
+func inStubZones(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, zone := range stubZones {
+		if dns.IsSubDomain(zone, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unicastRecords returns copies of multicast records fit for a unicast DNS
+// response: without the cache-flush bit, and with TTLs capped as per section
+// 6.7 of RFC 6762.  NSEC records, which only make sense in multicast, are
+// dropped.
+func unicastRecords(recs []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range recs {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			continue
+		}
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Class &^= cacheFlushBit
+		if hdr.Ttl > proxyMaxTTL {
+			hdr.Ttl = proxyMaxTTL
+		}
+		out = append(out, rr)
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestStubResolver(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_stubtest._tcp", "local.", "stubhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	r, err := NewStubResolver(&StubResolverConfig{Addr: "127.0.0.1:0", Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer r.Shutdown()
+
+	for _, network := range []string{"udp", "tcp"} {
+		c := &dns.Client{Net: network, Timeout: 2 * time.Second}
+		q := new(dns.Msg)
+		q.SetQuestion("stubhost.local.", dns.TypeA)
+		resp, _, err := c.Exchange(q, r.Addr().String())
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].Header().Class != dns.ClassINET || resp.Answer[0].Header().Ttl > proxyMaxTTL {
+			t.Fatalf("bad answer over %s: %v", network, resp)
+		}
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	resp, err := dns.Exchange(q, r.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("query outside .local not refused: %v", resp)
+	}
+}
@@ -0,0 +1,67 @@
+package mdns
+
+import (
+	"log"
+)
+
+// Suspend withdraws the server's services with goodbyes and stops answering
+// queries, without closing its sockets, such as during maintenance or a
+// firmware update.  Resume brings the services back.
+func (s *Server) Suspend() {
+	config := s.conf()
+	s.sendLock.Lock()
+	if s.suspended || s.silent {
+		s.sendLock.Unlock()
+		return
+	}
+	if s.system == nil {
+		if err := s.multicast(s.goodbyes()...); err != nil {
+			log.Printf("[ERR] mdns: Failed to send goodbyes: %v", err)
+		}
+	}
+	s.suspended = true
+	s.sendLock.Unlock()
+
+	for _, svc := range zoneServices(config.Zone) {
+		if config.Audit != nil {
+			config.Audit.service(svc, false)
+		}
+		if s.system != nil {
+			if err := s.system.deregister(svc); err != nil {
+				log.Printf("[ERR] mdns: Failed to withdraw %s from the system responder: %v", svc.instanceAddr, err)
+			}
+		}
+	}
+}
+
+// Resume answers queries again after Suspend, probing for the name of an
+// MDNSService zone and announcing the services in the background, as when
+// the server started.
+func (s *Server) Resume() {
+	config := s.conf()
+	s.sendLock.Lock()
+	suspended := s.suspended
+	s.suspended = false
+	s.sendLock.Unlock()
+	if !suspended {
+		return
+	}
+
+	if _, ok := config.Zone.(*MDNSService); ok && s.system == nil {
+		s.shutdownLock.Lock()
+		defer s.shutdownLock.Unlock()
+		if !s.shutdown {
+			s.wg.Add(1)
+			go s.probe()
+		}
+		return
+	}
+	s.announceServices(zoneServices(config.Zone), nil)
+}
+
+// Suspended reports whether the server is suspended.
+func (s *Server) Suspended() bool {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.suspended
+}
@@ -0,0 +1,108 @@
+package mdns
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// systemRegistrar publishes services through the operating system's DNS-SD
+// daemon, such as mDNSResponder on macOS, which then answers for them on the
+// network.
+type systemRegistrar interface {
+	// register publishes a service, or replaces the published copy of a
+	// service with the same instance name.
+	register(svc *MDNSService) error
+	// deregister withdraws a published service.
+	deregister(svc *MDNSService) error
+	// close withdraws every published service.
+	close()
+}
+
+// newSystemServer returns a server that publishes the services of
+// config.Zone through the system's DNS-SD daemon instead of answering
+// queries itself.
+func newSystemServer(config *Config) (*Server, error) {
+	switch config.Zone.(type) {
+	case *MDNSService, *ServiceSet:
+	default:
+		return nil, fmt.Errorf("mdns: only an MDNSService or ServiceSet can be published through the system responder")
+	}
+	var ifIndex int
+	if config.Iface != nil {
+		ifIndex = config.Iface.Index
+	}
+	registrar, err := newSystemRegistrar(ifIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = new(ServerMetrics)
+	}
+	s := &Server{
+		config:     config,
+		metrics:    metrics,
+		shutdownCh: make(chan struct{}),
+		system:     registrar,
+		announced:  make(chan struct{}),
+	}
+	close(s.announced)
+	services := zoneServices(config.Zone)
+	for _, svc := range services {
+		if err := registrar.register(svc); err != nil {
+			registrar.close()
+			return nil, err
+		}
+	}
+	for _, svc := range services {
+		s.register(svc)
+	}
+	return s, nil
+}
+
+// systemQuery runs a lookup through the system's DNS-SD daemon.
+func systemQuery(params *QueryParam) error {
+	events := make(chan *BrowseEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- systemBrowse(params.Context, params, events)
+		close(events)
+	}()
+	for ev := range events {
+		if ev.Type == ServiceRemoved {
+			continue
+		}
+		select {
+		case params.Entries <- ev.Entry:
+		case <-params.Context.Done():
+		}
+	}
+	return <-errCh
+}
+
+// runSystem is the main loop of a browser that browses through the system's
+// DNS-SD daemon.
+func (b *Browser) runSystem(ctx context.Context) {
+	events := make(chan *BrowseEvent)
+	go func() {
+		if err := systemBrowse(ctx, b.params, events); err != nil {
+			b.logf("[ERR] mdns: Failed to browse %s: %v", b.params.Service, err)
+		}
+		close(events)
+	}()
+	for ev := range events {
+		b.lock.Lock()
+		if ev.Type == ServiceRemoved {
+			delete(b.entries, ev.Entry.Name)
+		} else {
+			b.entries[ev.Entry.Name] = ev.Entry
+		}
+		b.lock.Unlock()
+		select {
+		case b.events <- ev:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,659 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package mdns
+
+/*
+#include <dns_sd.h>
+#include <errno.h>
+#include <poll.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <arpa/inet.h>
+
+extern void dnssdRegisterReply(DNSServiceRef, DNSServiceFlags, DNSServiceErrorType, char *, char *, char *, void *);
+extern void dnssdRecordReply(DNSServiceRef, DNSRecordRef, DNSServiceFlags, DNSServiceErrorType, void *);
+extern void dnssdBrowseReply(DNSServiceRef, DNSServiceFlags, uint32_t, DNSServiceErrorType, char *, char *, char *, void *);
+extern void dnssdResolveReply(DNSServiceRef, DNSServiceFlags, uint32_t, DNSServiceErrorType, char *, char *, uint16_t, uint16_t, unsigned char *, void *);
+extern void dnssdAddrInfoReply(DNSServiceRef, DNSServiceFlags, uint32_t, DNSServiceErrorType, char *, struct sockaddr *, uint32_t, void *);
+
+// dnssdWait waits until fd or stop is readable, and returns 1 for fd, 0 for
+// stop and -1 on error.
+static int dnssdWait(int fd, int stop) {
+	struct pollfd fds[2] = {{fd, POLLIN, 0}, {stop, POLLIN, 0}};
+	while (poll(fds, 2, -1) < 0) {
+		if (errno != EINTR) {
+			return -1;
+		}
+	}
+	return fds[1].revents == 0;
+}
+
+// The functions below start operations on the shared connection conn, and
+// pass ctx, a cgo.Handle, to their callbacks.
+
+static DNSServiceErrorType dnssdRegister(DNSServiceRef conn, DNSServiceRef *ref, uint32_t ifIndex, const char *name, const char *regtype, const char *domain, const char *host, uint16_t port, uint16_t txtLen, const void *txt, uintptr_t ctx) {
+	*ref = conn;
+	return DNSServiceRegister(ref, kDNSServiceFlagsShareConnection, ifIndex, name, regtype, domain, host, htons(port), txtLen, txt, (DNSServiceRegisterReply)dnssdRegisterReply, (void *)ctx);
+}
+
+static DNSServiceErrorType dnssdRegisterRecord(DNSServiceRef conn, DNSRecordRef *ref, uint32_t ifIndex, const char *name, uint16_t rrtype, uint16_t rdlen, const void *rdata, uint32_t ttl, uintptr_t ctx) {
+	return DNSServiceRegisterRecord(conn, ref, kDNSServiceFlagsShared, ifIndex, name, rrtype, kDNSServiceClass_IN, rdlen, rdata, ttl, (DNSServiceRegisterRecordReply)dnssdRecordReply, (void *)ctx);
+}
+
+static DNSServiceErrorType dnssdBrowse(DNSServiceRef conn, DNSServiceRef *ref, uint32_t ifIndex, const char *regtype, const char *domain, uintptr_t ctx) {
+	*ref = conn;
+	return DNSServiceBrowse(ref, kDNSServiceFlagsShareConnection, ifIndex, regtype, domain, (DNSServiceBrowseReply)dnssdBrowseReply, (void *)ctx);
+}
+
+static DNSServiceErrorType dnssdResolve(DNSServiceRef conn, DNSServiceRef *ref, uint32_t ifIndex, const char *name, const char *regtype, const char *domain, uintptr_t ctx) {
+	*ref = conn;
+	return DNSServiceResolve(ref, kDNSServiceFlagsShareConnection, ifIndex, name, regtype, domain, (DNSServiceResolveReply)dnssdResolveReply, (void *)ctx);
+}
+
+static DNSServiceErrorType dnssdGetAddrInfo(DNSServiceRef conn, DNSServiceRef *ref, uint32_t ifIndex, const char *host, uintptr_t ctx) {
+	*ref = conn;
+	return DNSServiceGetAddrInfo(ref, kDNSServiceFlagsShareConnection, ifIndex, 0, host, (DNSServiceGetAddrInfoReply)dnssdAddrInfoReply, (void *)ctx);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime/cgo"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// dnssdFlagAdd is set in the replies of browse and address operations that
+// report something found, rather than something gone.
+const dnssdFlagAdd = uint32(C.kDNSServiceFlagsAdd)
+
+// dnssdError is an error code returned by the DNS-SD API of dns_sd.h.
+type dnssdError int32
+
+var dnssdErrors = map[dnssdError]string{
+	C.kDNSServiceErr_NoSuchName:        "no such name",
+	C.kDNSServiceErr_BadParam:          "bad parameter",
+	C.kDNSServiceErr_AlreadyRegistered: "already registered",
+	C.kDNSServiceErr_NameConflict:      "name conflict",
+	C.kDNSServiceErr_Refused:           "refused",
+	C.kDNSServiceErr_NoSuchRecord:      "no such record",
+	C.kDNSServiceErr_ServiceNotRunning: "mDNSResponder is not running",
+	C.kDNSServiceErr_Timeout:           "timeout",
+}
+
+func (e dnssdError) Error() string {
+	if s, ok := dnssdErrors[e]; ok {
+		return "mdns: DNS-SD: " + s
+	}
+	return fmt.Sprintf("mdns: DNS-SD error %d", int32(e))
+}
+
+// dnssdErr returns the error for a DNS-SD error code, or nil if there is
+// none.
+func dnssdErr(code C.DNSServiceErrorType) error {
+	if code == C.kDNSServiceErr_NoError {
+		return nil
+	}
+	return dnssdError(code)
+}
+
+// dnssdReply holds the arguments passed to a DNS-SD callback, copied to Go
+// values.  Which fields are set depends on the operation.
+type dnssdReply struct {
+	flags   uint32
+	ifIndex uint32
+	err     error
+	name    string // Service instance name, or host name for addresses
+	regtype string
+	domain  string
+	host    string
+	port    int
+	txt     []string
+	ip      net.IP
+	ttl     uint32
+}
+
+// dnssdOp is an operation started on a dnssdConn, whose replies are passed
+// to the function held by handle.
+type dnssdOp struct {
+	ref     C.DNSServiceRef  // Nil for operations that only register records
+	records []C.DNSRecordRef // Records registered by the operation
+	handle  cgo.Handle
+}
+
+// dnssdConn is a connection to mDNSResponder that any number of operations
+// share.  Replies are read, and callbacks run, by a goroutine that holds lock
+// while doing so, as the DNS-SD API must not be used concurrently; the
+// methods that start and cancel operations require the caller to hold lock,
+// which callbacks already do.
+type dnssdConn struct {
+	lock   sync.Mutex
+	ref    C.DNSServiceRef
+	ops    map[*dnssdOp]bool
+	logger *log.Logger
+
+	stop [2]int // Pipe that tells run to return
+	done chan struct{}
+	err  error // Why run returned early, set before done is closed
+}
+
+// newDNSSDConn connects to mDNSResponder and starts reading its replies.
+func newDNSSDConn(logger *log.Logger) (*dnssdConn, error) {
+	c := &dnssdConn{
+		ops:    make(map[*dnssdOp]bool),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	if err := dnssdErr(C.DNSServiceCreateConnection(&c.ref)); err != nil {
+		return nil, err
+	}
+	if err := syscall.Pipe(c.stop[:]); err != nil {
+		C.DNSServiceRefDeallocate(c.ref)
+		return nil, err
+	}
+	go c.run()
+	return c, nil
+}
+
+// run processes replies until the connection is closed or lost.
+func (c *dnssdConn) run() {
+	defer close(c.done)
+	fd := C.DNSServiceRefSockFD(c.ref)
+	for {
+		switch C.dnssdWait(fd, C.int(c.stop[0])) {
+		case 0:
+			return
+		case -1:
+			c.err = fmt.Errorf("mdns: Failed to wait for mDNSResponder")
+			c.logf("[ERR] %v", c.err)
+			return
+		}
+		c.lock.Lock()
+		err := dnssdErr(C.DNSServiceProcessResult(c.ref))
+		c.lock.Unlock()
+		if err != nil {
+			c.err = err
+			c.logf("[ERR] mdns: Lost connection to mDNSResponder: %v", err)
+			return
+		}
+	}
+}
+
+// close closes the connection, which cancels every operation on it.
+func (c *dnssdConn) close() {
+	syscall.Write(c.stop[1], []byte{0})
+	<-c.done
+	c.lock.Lock()
+	C.DNSServiceRefDeallocate(c.ref)
+	for op := range c.ops {
+		op.handle.Delete()
+	}
+	c.ops = nil
+	c.lock.Unlock()
+	syscall.Close(c.stop[0])
+	syscall.Close(c.stop[1])
+}
+
+// logf logs using the connection's logger, or the standard logger if none
+// was provided.
+func (c *dnssdConn) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// newOp returns an operation whose replies are passed to reply.  It must be
+// cancelled once it is no longer needed.
+func (c *dnssdConn) newOp(reply func(*dnssdReply)) *dnssdOp {
+	op := &dnssdOp{handle: cgo.NewHandle(reply)}
+	c.ops[op] = true
+	return op
+}
+
+// cancel stops an operation, and removes the records it registered.
+func (c *dnssdConn) cancel(op *dnssdOp) {
+	for _, rec := range op.records {
+		C.DNSServiceRemoveRecord(c.ref, rec, 0)
+	}
+	if op.ref != nil {
+		C.DNSServiceRefDeallocate(op.ref)
+	}
+	op.handle.Delete()
+	delete(c.ops, op)
+}
+
+// cstring returns s as a C string that must be freed, or nil if s is empty.
+func cstring(s string) *C.char {
+	if s == "" {
+		return nil
+	}
+	return C.CString(s)
+}
+
+// register starts registering a service with op, as per DNSServiceRegister.
+func (c *dnssdConn) register(op *dnssdOp, ifIndex int, name, regtype, domain, host string, port int, txt []byte) error {
+	cname, cregtype, cdomain, chost := cstring(name), cstring(regtype), cstring(domain), cstring(host)
+	defer C.free(unsafe.Pointer(cname))
+	defer C.free(unsafe.Pointer(cregtype))
+	defer C.free(unsafe.Pointer(cdomain))
+	defer C.free(unsafe.Pointer(chost))
+	var ctxt unsafe.Pointer
+	if len(txt) > 0 {
+		ctxt = C.CBytes(txt)
+		defer C.free(ctxt)
+	}
+	var ref C.DNSServiceRef
+	err := dnssdErr(C.dnssdRegister(c.ref, &ref, C.uint32_t(ifIndex), cname, cregtype, cdomain, chost, C.uint16_t(port), C.uint16_t(len(txt)), ctxt, C.uintptr_t(op.handle)))
+	if err == nil {
+		op.ref = ref
+	}
+	return err
+}
+
+// registerAddr registers an address record for host with op, as per
+// DNSServiceRegisterRecord.  The record is shared, as several services may
+// be on the same host.
+func (c *dnssdConn) registerAddr(op *dnssdOp, ifIndex int, host string, ip net.IP, ttl uint32) error {
+	rrtype, rdata := dns.TypeAAAA, ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		rrtype, rdata = dns.TypeA, ip4
+	}
+	chost := C.CString(host)
+	defer C.free(unsafe.Pointer(chost))
+	crdata := C.CBytes(rdata)
+	defer C.free(crdata)
+	var rec C.DNSRecordRef
+	err := dnssdErr(C.dnssdRegisterRecord(c.ref, &rec, C.uint32_t(ifIndex), chost, C.uint16_t(rrtype), C.uint16_t(len(rdata)), crdata, C.uint32_t(ttl), C.uintptr_t(op.handle)))
+	if err == nil {
+		op.records = append(op.records, rec)
+	}
+	return err
+}
+
+// browse starts browsing for a service type with op, as per
+// DNSServiceBrowse.
+func (c *dnssdConn) browse(op *dnssdOp, ifIndex int, regtype, domain string) error {
+	cregtype, cdomain := cstring(regtype), cstring(domain)
+	defer C.free(unsafe.Pointer(cregtype))
+	defer C.free(unsafe.Pointer(cdomain))
+	var ref C.DNSServiceRef
+	err := dnssdErr(C.dnssdBrowse(c.ref, &ref, C.uint32_t(ifIndex), cregtype, cdomain, C.uintptr_t(op.handle)))
+	if err == nil {
+		op.ref = ref
+	}
+	return err
+}
+
+// resolve starts resolving a service instance with op, as per
+// DNSServiceResolve.  It keeps running, reporting changes to the instance's
+// SRV and TXT records, until cancelled.
+func (c *dnssdConn) resolve(op *dnssdOp, ifIndex int, name, regtype, domain string) error {
+	cname, cregtype, cdomain := cstring(name), cstring(regtype), cstring(domain)
+	defer C.free(unsafe.Pointer(cname))
+	defer C.free(unsafe.Pointer(cregtype))
+	defer C.free(unsafe.Pointer(cdomain))
+	var ref C.DNSServiceRef
+	err := dnssdErr(C.dnssdResolve(c.ref, &ref, C.uint32_t(ifIndex), cname, cregtype, cdomain, C.uintptr_t(op.handle)))
+	if err == nil {
+		op.ref = ref
+	}
+	return err
+}
+
+// getAddrInfo starts looking up the IPv4 and IPv6 addresses of host with op,
+// as per DNSServiceGetAddrInfo.
+func (c *dnssdConn) getAddrInfo(op *dnssdOp, ifIndex int, host string) error {
+	chost := C.CString(host)
+	defer C.free(unsafe.Pointer(chost))
+	var ref C.DNSServiceRef
+	err := dnssdErr(C.dnssdGetAddrInfo(c.ref, &ref, C.uint32_t(ifIndex), chost, C.uintptr_t(op.handle)))
+	if err == nil {
+		op.ref = ref
+	}
+	return err
+}
+
+// dnssdFullName returns the name of a service instance, as reported by a
+// browse operation, as an escaped domain name.
+func dnssdFullName(name, regtype, domain string) string {
+	cname, cregtype, cdomain := C.CString(name), C.CString(regtype), C.CString(domain)
+	defer C.free(unsafe.Pointer(cname))
+	defer C.free(unsafe.Pointer(cregtype))
+	defer C.free(unsafe.Pointer(cdomain))
+	buf := make([]C.char, C.kDNSServiceMaxDomainName)
+	C.DNSServiceConstructFullName(&buf[0], cname, cregtype, cdomain)
+	return C.GoString(&buf[0])
+}
+
+// txtRecordData encodes TXT strings as the rdata of a TXT record.
+func txtRecordData(txt []string) ([]byte, error) {
+	var data []byte
+	for _, s := range txt {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("mdns: TXT string %q is longer than 255 bytes", s)
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	return data, nil
+}
+
+// isSystemHostName reports whether host is this machine's own name, with or
+// without the "local" domain, which mDNSResponder already publishes.
+func isSystemHostName(host string) bool {
+	name, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+	short := func(s string) string {
+		return strings.TrimSuffix(strings.ToLower(trimDot(s)), ".local")
+	}
+	return short(host) == short(name)
+}
+
+// dnssdRegistrar publishes services through mDNSResponder.
+type dnssdRegistrar struct {
+	conn    *dnssdConn
+	ifIndex int
+	regs    map[string]*dnssdRegistration // By lower case instance name, guarded by conn.lock
+}
+
+// dnssdRegistration is a service published by a dnssdRegistrar.
+type dnssdRegistration struct {
+	service *dnssdOp
+	addrs   *dnssdOp // Set if the service's host is not this machine
+}
+
+func newSystemRegistrar(ifIndex int) (systemRegistrar, error) {
+	conn, err := newDNSSDConn(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &dnssdRegistrar{
+		conn:    conn,
+		ifIndex: ifIndex,
+		regs:    make(map[string]*dnssdRegistration),
+	}, nil
+}
+
+func (r *dnssdRegistrar) register(svc *MDNSService) error {
+	txt, err := txtRecordData(svc.TXT)
+	if err != nil {
+		return err
+	}
+
+	r.conn.lock.Lock()
+	defer r.conn.lock.Unlock()
+	key := strings.ToLower(svc.instanceAddr)
+	if old := r.regs[key]; old != nil {
+		r.remove(old)
+		delete(r.regs, key)
+	}
+
+	// The daemon publishes its own host name and addresses; those of any
+	// other host the service is on are registered along with it.
+	reg := new(dnssdRegistration)
+	var host string
+	if !isSystemHostName(svc.HostName) {
+		host = svc.HostName
+		reg.addrs = r.conn.newOp(func(rep *dnssdReply) {
+			if rep.err != nil {
+				log.Printf("[ERR] mdns: Failed to publish the addresses of %s: %v", host, rep.err)
+			}
+		})
+		for _, ip := range svc.IPs {
+			if err := r.conn.registerAddr(reg.addrs, r.ifIndex, host, ip, svc.TTL); err != nil {
+				r.remove(reg)
+				return err
+			}
+		}
+	}
+	reg.service = r.conn.newOp(func(rep *dnssdReply) {
+		switch {
+		case rep.err != nil:
+			log.Printf("[ERR] mdns: Failed to publish %s: %v", svc.instanceAddr, rep.err)
+		case rep.flags&dnssdFlagAdd != 0 && rep.name != svc.Instance:
+			log.Printf("[INFO] mdns: %s was published as %q to resolve a conflict", svc.instanceAddr, rep.name)
+		}
+	})
+	// DNSServiceRegister takes the subtypes after the service type, separated
+	// by commas.
+	regtype := trimDot(svc.Service)
+	for _, sub := range svc.Subtypes {
+		regtype += "," + trimDot(sub)
+	}
+	if err := r.conn.register(reg.service, r.ifIndex, svc.Instance, regtype, svc.Domain, host, svc.Port, txt); err != nil {
+		r.remove(reg)
+		return err
+	}
+	r.regs[key] = reg
+	return nil
+}
+
+func (r *dnssdRegistrar) deregister(svc *MDNSService) error {
+	r.conn.lock.Lock()
+	defer r.conn.lock.Unlock()
+	key := strings.ToLower(svc.instanceAddr)
+	reg := r.regs[key]
+	if reg == nil {
+		return fmt.Errorf("mdns: service %s is not published", svc.instanceAddr)
+	}
+	r.remove(reg)
+	delete(r.regs, key)
+	return nil
+}
+
+func (r *dnssdRegistrar) close() {
+	r.conn.close()
+}
+
+// remove cancels the operations of a registration.  conn.lock must be held.
+func (r *dnssdRegistrar) remove(reg *dnssdRegistration) {
+	if reg.service != nil {
+		r.conn.cancel(reg.service)
+	}
+	if reg.addrs != nil {
+		r.conn.cancel(reg.addrs)
+	}
+}
+
+// dnssdBrowse tracks the instances found by a browse operation, resolving
+// each to a ServiceEntry.  Its methods are called by the connection's
+// callbacks, with the connection's lock held.
+type dnssdBrowse struct {
+	ctx       context.Context
+	conn      *dnssdConn
+	service   string
+	ifIndex   int
+	events    chan<- *BrowseEvent
+	instances map[string]*dnssdInstance // By instance name
+	delivered deliveredEntries
+}
+
+// dnssdInstance is an instance found by a dnssdBrowse.
+type dnssdInstance struct {
+	entry   *ServiceEntry
+	ifaces  map[uint32]bool // The interfaces the instance was found on
+	resolve *dnssdOp
+	addrs   *dnssdOp // Looks up the addresses of entry.Host
+}
+
+func systemBrowse(ctx context.Context, params *QueryParam, events chan<- *BrowseEvent) error {
+	conn, err := newDNSSDConn(params.Logger)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	b := &dnssdBrowse{
+		ctx:       ctx,
+		conn:      conn,
+		service:   params.Service,
+		events:    events,
+		instances: make(map[string]*dnssdInstance),
+		delivered: make(deliveredEntries),
+	}
+	if params.Interface != nil {
+		b.ifIndex = params.Interface.Index
+	}
+	conn.lock.Lock()
+	err = conn.browse(conn.newOp(b.browsed), b.ifIndex, trimDot(params.Service), params.Domain)
+	conn.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-conn.done:
+		return conn.err
+	}
+}
+
+// browsed handles an instance being found or lost on an interface.
+func (b *dnssdBrowse) browsed(r *dnssdReply) {
+	if r.err != nil {
+		b.conn.logf("[ERR] mdns: Failed to browse %s: %v", b.service, r.err)
+		return
+	}
+	name := dnssdFullName(r.name, r.regtype, r.domain)
+	inst := b.instances[name]
+	if r.flags&dnssdFlagAdd != 0 {
+		if inst == nil {
+			inst = &dnssdInstance{
+				entry:  &ServiceEntry{Name: name},
+				ifaces: make(map[uint32]bool),
+			}
+			inst.resolve = b.conn.newOp(func(r *dnssdReply) { b.resolved(inst, r) })
+			if err := b.conn.resolve(inst.resolve, b.ifIndex, r.name, r.regtype, r.domain); err != nil {
+				b.conn.cancel(inst.resolve)
+				b.conn.logf("[ERR] mdns: Failed to resolve %s: %v", name, err)
+				return
+			}
+			b.instances[name] = inst
+		}
+		inst.ifaces[r.ifIndex] = true
+		return
+	}
+
+	if inst == nil {
+		return
+	}
+	delete(inst.ifaces, r.ifIndex)
+	if len(inst.ifaces) > 0 {
+		return
+	}
+	b.conn.cancel(inst.resolve)
+	if inst.addrs != nil {
+		b.conn.cancel(inst.addrs)
+	}
+	delete(b.instances, name)
+	if _, ok := b.delivered[name]; ok {
+		delete(b.delivered, name)
+		e := *inst.entry
+		b.send(&BrowseEvent{Type: ServiceRemoved, Entry: &e})
+	}
+}
+
+// resolved handles the host, port and TXT record of an instance.
+func (b *dnssdBrowse) resolved(inst *dnssdInstance, r *dnssdReply) {
+	e := inst.entry
+	if r.err != nil {
+		b.conn.logf("[ERR] mdns: Failed to resolve %s: %v", e.Name, r.err)
+		return
+	}
+	e.Port = r.port
+	e.Info = strings.Join(r.txt, "|")
+	e.InfoFields = r.txt
+	e.hasTXT = true
+	if !strings.EqualFold(e.Host, r.host) {
+		if inst.addrs != nil {
+			b.conn.cancel(inst.addrs)
+			inst.addrs = nil
+		}
+		e.Host = r.host
+		e.AddrV4, e.AddrV6, e.Addr, e.Zone = nil, nil, nil, ""
+		op := b.conn.newOp(func(r *dnssdReply) { b.addrFound(inst, r) })
+		if err := b.conn.getAddrInfo(op, b.ifIndex, r.host); err != nil {
+			b.conn.cancel(op)
+			b.conn.logf("[ERR] mdns: Failed to look up the addresses of %s: %v", r.host, err)
+			return
+		}
+		inst.addrs = op
+	}
+	b.update(inst)
+}
+
+// addrFound handles an address of an instance's host being found or lost.
+func (b *dnssdBrowse) addrFound(inst *dnssdInstance, r *dnssdReply) {
+	e := inst.entry
+	if r.err == error(dnssdError(C.kDNSServiceErr_NoSuchRecord)) {
+		// The host has no address of one of the families.
+		return
+	}
+	if r.err != nil {
+		b.conn.logf("[ERR] mdns: Failed to look up the addresses of %s: %v", e.Host, r.err)
+		return
+	}
+	add := r.flags&dnssdFlagAdd != 0
+	if ip4 := r.ip.To4(); ip4 != nil {
+		if add {
+			e.AddrV4, e.Addr = ip4, ip4
+		} else if e.AddrV4.Equal(ip4) {
+			e.AddrV4, e.Addr = nil, nil
+		}
+	} else if r.ip != nil {
+		if add {
+			e.AddrV6, e.Zone = r.ip, ""
+			if r.ip.IsLinkLocalUnicast() {
+				if iface, err := net.InterfaceByIndex(int(r.ifIndex)); err == nil {
+					e.Zone = iface.Name
+				}
+			}
+		} else if e.AddrV6.Equal(r.ip) {
+			e.AddrV6, e.Zone = nil, ""
+		}
+	}
+	e.TTL = int(r.ttl)
+	b.update(inst)
+}
+
+// update reports an instance if it is complete and has changed since it was
+// last reported.
+func (b *dnssdBrowse) update(inst *dnssdInstance) {
+	inst.entry.LastSeen = time.Now()
+	if !inst.entry.complete() {
+		return
+	}
+	e := b.delivered.next(inst.entry)
+	if e == nil {
+		return
+	}
+	typ := ServiceAdded
+	if e.Updated {
+		typ = ServiceUpdated
+	}
+	b.send(&BrowseEvent{Type: typ, Entry: e})
+}
+
+// send reports an event, unless the browse is stopped first.
+func (b *dnssdBrowse) send(ev *BrowseEvent) {
+	select {
+	case b.events <- ev:
+	case <-b.ctx.Done():
+	}
+}
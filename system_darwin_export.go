@@ -0,0 +1,103 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package mdns
+
+// The callbacks of the DNS-SD operations started in system_darwin.go.  They
+// are kept apart because a file that exports functions to C may only declare
+// C functions in its preamble, not define them.
+
+/*
+#include <dns_sd.h>
+#include <netinet/in.h>
+#include <sys/socket.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"net"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// dnssdDispatch passes a reply to the function held by the handle ctx.
+func dnssdDispatch(ctx unsafe.Pointer, r *dnssdReply) {
+	cgo.Handle(uintptr(ctx)).Value().(func(*dnssdReply))(r)
+}
+
+//export dnssdRegisterReply
+func dnssdRegisterReply(ref C.DNSServiceRef, flags C.DNSServiceFlags, code C.DNSServiceErrorType, name, regtype, domain *C.char, ctx unsafe.Pointer) {
+	dnssdDispatch(ctx, &dnssdReply{
+		flags:   uint32(flags),
+		err:     dnssdErr(code),
+		name:    C.GoString(name),
+		regtype: C.GoString(regtype),
+		domain:  C.GoString(domain),
+	})
+}
+
+//export dnssdRecordReply
+func dnssdRecordReply(ref C.DNSServiceRef, rec C.DNSRecordRef, flags C.DNSServiceFlags, code C.DNSServiceErrorType, ctx unsafe.Pointer) {
+	dnssdDispatch(ctx, &dnssdReply{
+		flags: uint32(flags),
+		err:   dnssdErr(code),
+	})
+}
+
+//export dnssdBrowseReply
+func dnssdBrowseReply(ref C.DNSServiceRef, flags C.DNSServiceFlags, ifIndex C.uint32_t, code C.DNSServiceErrorType, name, regtype, domain *C.char, ctx unsafe.Pointer) {
+	dnssdDispatch(ctx, &dnssdReply{
+		flags:   uint32(flags),
+		ifIndex: uint32(ifIndex),
+		err:     dnssdErr(code),
+		name:    C.GoString(name),
+		regtype: C.GoString(regtype),
+		domain:  C.GoString(domain),
+	})
+}
+
+//export dnssdResolveReply
+func dnssdResolveReply(ref C.DNSServiceRef, flags C.DNSServiceFlags, ifIndex C.uint32_t, code C.DNSServiceErrorType, fullname, host *C.char, port, txtLen C.uint16_t, txt *C.uchar, ctx unsafe.Pointer) {
+	r := &dnssdReply{
+		flags:   uint32(flags),
+		ifIndex: uint32(ifIndex),
+		err:     dnssdErr(code),
+		name:    C.GoString(fullname),
+		host:    C.GoString(host),
+		// The port is in network byte order.
+		port: int(binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&port))[:])),
+	}
+	data := C.GoBytes(unsafe.Pointer(txt), C.int(txtLen))
+	for len(data) > 0 {
+		n := int(data[0])
+		if n >= len(data) {
+			n = len(data) - 1
+		}
+		r.txt = append(r.txt, string(data[1:1+n]))
+		data = data[1+n:]
+	}
+	dnssdDispatch(ctx, r)
+}
+
+//export dnssdAddrInfoReply
+func dnssdAddrInfoReply(ref C.DNSServiceRef, flags C.DNSServiceFlags, ifIndex C.uint32_t, code C.DNSServiceErrorType, host *C.char, addr *C.struct_sockaddr, ttl C.uint32_t, ctx unsafe.Pointer) {
+	r := &dnssdReply{
+		flags:   uint32(flags),
+		ifIndex: uint32(ifIndex),
+		err:     dnssdErr(code),
+		name:    C.GoString(host),
+		ttl:     uint32(ttl),
+	}
+	if addr != nil && code == C.kDNSServiceErr_NoError {
+		switch addr.sa_family {
+		case C.AF_INET:
+			sin := (*C.struct_sockaddr_in)(unsafe.Pointer(addr))
+			r.ip = net.IP(C.GoBytes(unsafe.Pointer(&sin.sin_addr), 4))
+		case C.AF_INET6:
+			sin6 := (*C.struct_sockaddr_in6)(unsafe.Pointer(addr))
+			r.ip = net.IP(C.GoBytes(unsafe.Pointer(&sin6.sin6_addr), 16))
+		}
+	}
+	dnssdDispatch(ctx, r)
+}
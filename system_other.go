@@ -0,0 +1,22 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package mdns
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// errNoSystemResponder is returned when Config.System or QueryParam.System
+// is set on a platform whose DNS-SD daemon is not supported.
+var errNoSystemResponder = fmt.Errorf("mdns: there is no supported system DNS-SD responder on this platform")
+
+func newSystemRegistrar(ifIndex int) (systemRegistrar, error) {
+	return nil, errNoSystemResponder
+}
+
+func systemBrowse(ctx context.Context, params *QueryParam, events chan<- *BrowseEvent) error {
+	return errNoSystemResponder
+}
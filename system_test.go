@@ -0,0 +1,30 @@
+package mdns
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSystemServer_RejectsOtherZones(t *testing.T) {
+	zone := &DNSSDService{MDNSService: makeService(t)}
+	if _, err := NewServer(&Config{Zone: zone, System: true}); err == nil {
+		t.Fatalf("expected an error for a zone whose services cannot be listed")
+	}
+}
+
+func TestSystemResponder_Unsupported(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("mDNSResponder may be available")
+	}
+	if _, err := NewServer(&Config{Zone: makeService(t), System: true}); err == nil {
+		t.Fatalf("expected an error without a system responder")
+	}
+
+	entries := make(chan *ServiceEntry, 1)
+	err := Lookup(context.Background(), "_foobar._tcp", WithEntriesChannel(entries), WithSystemResponder(true))
+	if err == nil {
+		t.Fatalf("expected an error without a system responder")
+	}
+}
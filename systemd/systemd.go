@@ -0,0 +1,114 @@
+// Package systemd integrates an mDNS responder with systemd: it receives the
+// sockets of socket activation, and reports the service's state to the
+// service manager, as described in sd_listen_fds(3) and sd_notify(3).
+//
+// A socket unit that binds the mDNS port for a responder looks like
+//
+//     [Socket]
+//     ListenDatagram=0.0.0.0:5353
+//     ListenDatagram=[::]:5353
+//     BindIPv6Only=ipv6-only
+//     ReusePort=true
+//
+// and its service is declared with Type=notify, so that systemd waits for
+// the initial announcements before starting the units that depend on it:
+//
+//     conns, err := systemd.Listeners()
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     server, err := mdns.NewServer(&mdns.Config{Zone: set, Conns: conns})
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     <-server.Announced()
+//     systemd.Notify(systemd.Ready)
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// States reported with Notify.
+const (
+	// Ready tells systemd that the service has finished starting up.
+	Ready = "READY=1"
+	// Reloading tells systemd that the service is reloading its
+	// configuration, and is followed by Ready once it is done.
+	Reloading = "RELOADING=1"
+	// Stopping tells systemd that the service is shutting down.
+	Stopping = "STOPPING=1"
+)
+
+// Listeners returns the UDP sockets passed to the process by systemd socket
+// activation, or none if the process was not socket activated.  Sockets of
+// other kinds are an error.  The environment variables that pass them are
+// unset, so that child processes do not take them as their own.
+func Listeners() ([]*net.UDPConn, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	var conns []*net.UDPConn
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		c, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			closeAll(conns)
+			return nil, fmt.Errorf("systemd: socket %d: %v", fd, err)
+		}
+		udp, ok := c.(*net.UDPConn)
+		if !ok {
+			c.Close()
+			closeAll(conns)
+			return nil, fmt.Errorf("systemd: socket %d is not a UDP socket", fd)
+		}
+		conns = append(conns, udp)
+	}
+	return conns, nil
+}
+
+func closeAll(conns []*net.UDPConn) {
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Notify sends a state, such as Ready, to systemd.  It returns false, and no
+// error, if the process was not started by systemd with a notification
+// socket, so it is safe to call unconditionally.
+func Notify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	// A leading "@" names a socket in the abstract namespace.
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListeners_NotActivated(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	conns, err := Listeners()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(conns) != 0 {
+		t.Fatalf("got %d sockets meant for another process", len(conns))
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatalf("LISTEN_FDS was not unset")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if ok, err := Notify(Ready); ok || err != nil {
+		t.Fatalf("expected nothing to be sent without a socket, got %v, %v", ok, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot listen on a unix datagram socket: %v", err)
+	}
+	defer l.Close()
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if ok, err := Notify(Ready); !ok || err != nil {
+		t.Fatalf("Notify: %v, %v", ok, err)
+	}
+	buf := make([]byte, 64)
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := string(buf[:n]); got != Ready {
+		t.Fatalf("got %q, want %q", got, Ready)
+	}
+}
@@ -0,0 +1,228 @@
+package mdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxTraceEvents bounds the events a Trace keeps, so that tracing a Browser
+// left running for a long time does not grow without limit.  Later events
+// are counted but not kept.
+const maxTraceEvents = 10000
+
+// TraceKind is the kind of a TraceEvent.
+type TraceKind int
+
+const (
+	// TraceQuerySent reports a query sent, with its questions.
+	TraceQuerySent TraceKind = iota
+	// TraceResponse reports a message received, from the network or the
+	// cache.
+	TraceResponse
+	// TraceRecordUsed reports a record of a response that was used.
+	TraceRecordUsed
+	// TraceRecordIgnored reports a record of a response that was not used,
+	// with the reason.
+	TraceRecordIgnored
+	// TraceCacheHit reports a message of cached records delivered before
+	// the query was sent.
+	TraceCacheHit
+	// TraceCacheStore reports the records of a response added to the
+	// cache.
+	TraceCacheStore
+	// TraceEntryDelivered reports an entry given to the caller.
+	TraceEntryDelivered
+	// TraceEntrySkipped reports an entry that was not given to the caller,
+	// with the reason.
+	TraceEntrySkipped
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceQuerySent:
+		return "query_sent"
+	case TraceResponse:
+		return "response"
+	case TraceRecordUsed:
+		return "record_used"
+	case TraceRecordIgnored:
+		return "record_ignored"
+	case TraceCacheHit:
+		return "cache_hit"
+	case TraceCacheStore:
+		return "cache_store"
+	case TraceEntryDelivered:
+		return "entry_delivered"
+	case TraceEntrySkipped:
+		return "entry_skipped"
+	}
+	return fmt.Sprintf("TraceKind(%d)", int(k))
+}
+
+// MarshalText encodes the kind as its name.
+func (k TraceKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// TraceEvent is a step of a traced lookup or browse.
+type TraceEvent struct {
+	Time time.Time `json:"time"`
+	Kind TraceKind `json:"kind"`
+
+	// From is the source of a response, empty for cached records.
+	From string `json:"from,omitempty"`
+
+	// Questions are the questions of a query, as "name type".
+	Questions []string `json:"questions,omitempty"`
+
+	// Record is a record, in zone file format.
+	Record string `json:"record,omitempty"`
+
+	// Entry is the instance name of an entry.
+	Entry string `json:"entry,omitempty"`
+
+	// Reason tells why a record or entry was not used.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Trace records what a lookup or browse does, for debugging and to attach to
+// bug reports: each question sent, each response received and which of its
+// records were used or ignored and why, the cache's part, and the entries
+// delivered or held back.  A Trace is given to a lookup with WithTrace, and
+// may be read while the lookup runs.
+type Trace struct {
+	lock    sync.Mutex
+	service string
+	events  []TraceEvent
+	dropped int
+}
+
+// NewTrace returns an empty Trace.
+func NewTrace() *Trace {
+	return &Trace{}
+}
+
+// Events returns a copy of the events recorded so far.
+func (t *Trace) Events() []TraceEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]TraceEvent(nil), t.events...)
+}
+
+// traceReport is the JSON form of a Trace.
+type traceReport struct {
+	Service string       `json:"service"`
+	Events  []TraceEvent `json:"events"`
+	Dropped int          `json:"dropped,omitempty"`
+}
+
+// WriteJSON writes the trace to w as a JSON report, with the service traced,
+// the events in order, and the number of events dropped over the limit of
+// events kept, if any.
+func (t *Trace) WriteJSON(w io.Writer) error {
+	t.lock.Lock()
+	report := traceReport{Service: t.service, Events: append([]TraceEvent{}, t.events...), Dropped: t.dropped}
+	t.lock.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&report)
+}
+
+// add records ev.  A nil Trace records nothing.
+func (t *Trace) add(ev TraceEvent) {
+	if t == nil {
+		return
+	}
+	ev.Time = time.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.events) >= maxTraceEvents {
+		t.dropped++
+		return
+	}
+	t.events = append(t.events, ev)
+}
+
+// start records the service a lookup or browse is for.
+func (t *Trace) start(service string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.service = service
+	t.lock.Unlock()
+}
+
+// query records a query sent.
+func (t *Trace) query(q *dns.Msg) {
+	if t == nil {
+		return
+	}
+	questions := make([]string, len(q.Question))
+	for i, question := range q.Question {
+		questions[i] = question.Name + " " + dns.TypeToString[question.Qtype]
+	}
+	t.add(TraceEvent{Kind: TraceQuerySent, Questions: questions})
+}
+
+// response records a message received, and whether each of its records is
+// one that the client looks at.
+func (t *Trace) response(r *received) {
+	if t == nil {
+		return
+	}
+	ev := TraceEvent{Kind: TraceResponse}
+	if r.from != nil {
+		ev.From = r.from.String()
+	}
+	t.add(ev)
+	i := 0
+	for _, rrs := range [][]dns.RR{r.msg.Answer, r.msg.Extra} {
+		for _, rr := range rrs {
+			ev := TraceEvent{Kind: TraceRecordUsed, Record: rr.String()}
+			switch rr.(type) {
+			case *dns.PTR, *dns.SRV, *dns.TXT, *dns.A, *dns.AAAA:
+				if i >= maxMessageRecords {
+					ev.Kind, ev.Reason = TraceRecordIgnored, "over the limit of records per message"
+				}
+			default:
+				ev.Kind, ev.Reason = TraceRecordIgnored, "type not used"
+			}
+			t.add(ev)
+			i++
+		}
+	}
+}
+
+// cache records cached records delivered, if hit, or stored.
+func (t *Trace) cache(m *dns.Msg, hit bool) {
+	if t == nil {
+		return
+	}
+	kind := TraceCacheStore
+	if hit {
+		kind = TraceCacheHit
+	}
+	for _, rrs := range [][]dns.RR{m.Answer, m.Extra} {
+		for _, rr := range rrs {
+			t.add(TraceEvent{Kind: kind, Record: rr.String()})
+		}
+	}
+}
+
+// entry records an entry delivered, if reason is empty, or skipped.
+func (t *Trace) entry(name, reason string) {
+	if t == nil {
+		return
+	}
+	if reason == "" {
+		t.add(TraceEvent{Kind: TraceEntryDelivered, Entry: name})
+		return
+	}
+	t.add(TraceEvent{Kind: TraceEntrySkipped, Entry: name, Reason: reason})
+}
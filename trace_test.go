@@ -0,0 +1,79 @@
+package mdns
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestTrace_Response(t *testing.T) {
+	tr := NewTrace()
+	tr.start("_foobar._tcp.local.")
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120}, A: net.IPv4(192, 168, 0, 42)},
+		&dns.HINFO{Hdr: dns.RR_Header{Name: "testhost.", Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: 120}, Cpu: "x", Os: "y"},
+	}
+	tr.response(&received{msg: m, from: &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Port: 5353}})
+	tr.entry("other._http._tcp.local.", "not an instance of _foobar._tcp.local.")
+
+	events := tr.Events()
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(events), events)
+	}
+	if events[0].Kind != TraceResponse || events[0].From != "192.168.0.42:5353" {
+		t.Errorf("bad response event: %+v", events[0])
+	}
+	if events[1].Kind != TraceRecordUsed || events[2].Kind != TraceRecordIgnored || events[2].Reason == "" {
+		t.Errorf("bad record events: %+v %+v", events[1], events[2])
+	}
+	if events[3].Kind != TraceEntrySkipped {
+		t.Errorf("bad entry event: %+v", events[3])
+	}
+
+	var buf bytes.Buffer
+	if err := tr.WriteJSON(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var report struct {
+		Service string
+		Events  []struct{ Kind string }
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if report.Service != "_foobar._tcp.local." || len(report.Events) != 4 || report.Events[2].Kind != "record_ignored" {
+		t.Errorf("bad report: %s", buf.String())
+	}
+}
+
+func TestServer_LookupTrace(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	tr := NewTrace()
+	entries := make(chan *ServiceEntry, 4)
+	err = Lookup(context.Background(), "_foobar._tcp",
+		WithEntriesChannel(entries),
+		WithTimeout(50*time.Millisecond),
+		WithTrace(tr))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	kinds := make(map[TraceKind]int)
+	for _, ev := range tr.Events() {
+		kinds[ev.Kind]++
+	}
+	if kinds[TraceQuerySent] == 0 || kinds[TraceResponse] == 0 || kinds[TraceEntryDelivered] != 1 {
+		t.Errorf("bad trace: %+v", tr.Events())
+	}
+}
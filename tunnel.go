@@ -0,0 +1,261 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TunnelConfig is used to configure a Tunnel.
+type TunnelConfig struct {
+	// Interfaces are the local interfaces whose traffic is sent through the
+	// tunnel, and on which traffic from the peer is multicast.  By default
+	// every multicast interface is used.
+	Interfaces []*net.Interface
+
+	// RateLimit is the most packets per second from the peer that are
+	// multicast locally, with bursts of up to the same number.  The default
+	// is 50.
+	RateLimit int
+
+	// Logger is used for errors, default is the standard logger.
+	Logger *log.Logger
+}
+
+// Tunnel relays mDNS traffic between the local network and a peer on another
+// network, over a stream connection such as TCP, so that services can be
+// discovered across routed sites or a VPN.  Each end of the connection runs a
+// Tunnel: packets multicast on one network are sent through the connection
+// and multicast again on the other, with the same rules as a Reflector.
+//
+// The connection carries each packet prefixed with its length as a two byte
+// big-endian integer, as DNS does over TCP.  Any net.Conn may be used, for
+// example one secured with TLS.
+type Tunnel struct {
+	conn   net.Conn
+	config *TunnelConfig
+
+	ipv4Conn *ipv4.PacketConn
+	ipv6Conn *ipv6.PacketConn
+
+	writeLock sync.Mutex // Serializes writes to conn
+
+	lock    sync.Mutex
+	history *packetHistory // Packets relayed in either direction
+	bucket  *tokenBucket   // Rate limit for packets from the peer
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewTunnel starts relaying between the local network and the peer at the
+// other end of conn.  The tunnel owns conn, and closes it when done.
+func NewTunnel(conn net.Conn, config *TunnelConfig) (*Tunnel, error) {
+	rate := config.RateLimit
+	if rate == 0 {
+		rate = defaultReflectorRate
+	}
+	t := &Tunnel{
+		conn:    conn,
+		config:  config,
+		history: newPacketHistory(),
+		bucket:  newTokenBucket(rate),
+		closed:  make(chan struct{}),
+	}
+
+	// Loopback stays enabled so that programs on this host see the peer's
+	// services; the copies that come back to the tunnel are in its history.
+	var err error
+	if t.ipv4Conn, t.ipv6Conn, err = listenGroups(config.Interfaces, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.ipv4Conn != nil {
+		t.wg.Add(1)
+		go t.recvLocal(func(buf []byte) (int, net.Addr, error) {
+			n, _, from, err := t.ipv4Conn.ReadFrom(buf)
+			return n, from, err
+		})
+	}
+	if t.ipv6Conn != nil {
+		t.wg.Add(1)
+		go t.recvLocal(func(buf []byte) (int, net.Addr, error) {
+			n, _, from, err := t.ipv6Conn.ReadFrom(buf)
+			return n, from, err
+		})
+	}
+	t.wg.Add(1)
+	go t.recvPeer()
+	return t, nil
+}
+
+// Done returns a channel that is closed when the tunnel stops, either because
+// Close was called or because the connection failed.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.closed
+}
+
+// Close stops the tunnel and closes its connection.
+func (t *Tunnel) Close() error {
+	t.stop()
+	t.wg.Wait()
+	return nil
+}
+
+// stop closes the tunnel's connection and sockets, which ends its goroutines.
+func (t *Tunnel) stop() {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		t.conn.Close()
+		if t.ipv4Conn != nil {
+			t.ipv4Conn.Close()
+		}
+		if t.ipv6Conn != nil {
+			t.ipv6Conn.Close()
+		}
+	})
+}
+
+func (t *Tunnel) logf(format string, v ...interface{}) {
+	if t.config.Logger != nil {
+		t.config.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// recvLocal sends the packets read from the local network to the peer.
+func (t *Tunnel) recvLocal(read func([]byte) (int, net.Addr, error)) {
+	defer t.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := read(buf)
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			default:
+				continue
+			}
+		}
+		packet, ok := relayable(buf[:n], from.(*net.UDPAddr))
+		if !ok {
+			continue
+		}
+
+		t.lock.Lock()
+		seen := t.history.contains(packet, time.Now())
+		if !seen {
+			t.history.add(packet, time.Now())
+		}
+		t.lock.Unlock()
+		if seen {
+			continue
+		}
+
+		t.writeLock.Lock()
+		err = writeFrame(t.conn, packet)
+		t.writeLock.Unlock()
+		if err != nil {
+			t.logf("[ERR] mdns: Tunnel to %v failed: %v", t.conn.RemoteAddr(), err)
+			t.stop()
+			return
+		}
+	}
+}
+
+// recvPeer multicasts the packets read from the peer on the local network.
+func (t *Tunnel) recvPeer() {
+	defer t.wg.Done()
+	defer t.stop()
+	for {
+		packet, err := readFrame(t.conn)
+		if err != nil {
+			select {
+			case <-t.closed:
+			default:
+				if err != io.EOF {
+					t.logf("[ERR] mdns: Tunnel from %v failed: %v", t.conn.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+		var msg dns.Msg
+		if err := msg.Unpack(packet); err != nil {
+			continue
+		}
+
+		now := time.Now()
+		t.lock.Lock()
+		ok := !t.history.contains(packet, now) && t.bucket.take(now)
+		if ok {
+			t.history.add(packet, now)
+		}
+		t.lock.Unlock()
+		if ok {
+			t.multicast(packet)
+		}
+	}
+}
+
+// multicast sends a packet to the mDNS groups on the tunnel's interfaces.
+func (t *Tunnel) multicast(packet []byte) {
+	ifaces := t.config.Interfaces
+	if ifaces == nil {
+		// Use the system's default multicast interface.
+		ifaces = []*net.Interface{nil}
+	}
+	for _, iface := range ifaces {
+		var cm4 *ipv4.ControlMessage
+		var cm6 *ipv6.ControlMessage
+		if iface != nil {
+			cm4 = &ipv4.ControlMessage{IfIndex: iface.Index}
+			cm6 = &ipv6.ControlMessage{IfIndex: iface.Index}
+		}
+		if t.ipv4Conn != nil {
+			if _, err := t.ipv4Conn.WriteTo(packet, cm4, ipv4Addr); err != nil {
+				t.logf("[ERR] mdns: Failed to multicast packet from tunnel: %v", err)
+			}
+		}
+		if t.ipv6Conn != nil {
+			if _, err := t.ipv6Conn.WriteTo(packet, cm6, ipv6Addr); err != nil {
+				t.logf("[ERR] mdns: Failed to multicast packet from tunnel: %v", err)
+			}
+		}
+	}
+}
+
+// writeFrame writes a packet prefixed with its length.
+func writeFrame(w io.Writer, packet []byte) error {
+	if len(packet) > 0xffff {
+		return fmt.Errorf("mdns: packet of %d bytes is too large", len(packet))
+	}
+	frame := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(frame, uint16(len(packet)))
+	copy(frame[2:], packet)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a packet written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
@@ -0,0 +1,102 @@
+package mdns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTunnel_Frames(t *testing.T) {
+	var buf bytes.Buffer
+	for _, p := range [][]byte{[]byte("first"), {}, []byte("third")} {
+		if err := writeFrame(&buf, p); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for _, want := range []string{"first", "", "third"} {
+		p, err := readFrame(&buf)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(p) != want {
+			t.Errorf("got %q, want %q", p, want)
+		}
+	}
+	if err := writeFrame(&buf, make([]byte, 70000)); err == nil {
+		t.Errorf("expected error for oversized packet")
+	}
+}
+
+func TestTunnel_FromPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	tun, err := NewTunnel(local, &TunnelConfig{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer tun.Close()
+	// Drain what the tunnel sends, such as the queries made below.
+	go func() {
+		for {
+			if _, err := readFrame(remote); err != nil {
+				return
+			}
+		}
+	}()
+
+	// A response from a service on the peer's network.
+	s, err := NewMDNSService("remote", "_tunnel._tcp", "", "remote.local.", 80, []net.IP{net.IPv4(10, 9, 8, 7)}, []string{"far"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+	resp.Answer = s.Records(dns.Question{Name: "_tunnel._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	packet, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	entries := make(chan *ServiceEntry, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		params := DefaultParams("_tunnel._tcp")
+		params.Entries = entries
+		params.Timeout = 2 * time.Second
+		Query(params)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := writeFrame(remote, packet); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Name != "remote._tunnel._tcp.local." || e.Port != 80 {
+			t.Errorf("bad entry: %#v", e)
+		}
+	case <-done:
+		t.Fatalf("peer's service not found")
+	}
+	<-done
+}
+
+func TestTunnel_PeerClosed(t *testing.T) {
+	local, remote := net.Pipe()
+	tun, err := NewTunnel(local, &TunnelConfig{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer tun.Close()
+
+	remote.Close()
+	select {
+	case <-tun.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("tunnel did not stop")
+	}
+}
@@ -0,0 +1,37 @@
+package mdns
+
+import (
+	"golang.org/x/net/context"
+)
+
+// WaitFor browses for instances of service, such as "_http._tcp", until one
+// for which match returns true appears, and returns it.  A nil match
+// accepts any instance.  Entries are checked as they are found and as they
+// change, so that match may look at the instance's name, TXT record or
+// addresses.  WaitFor accepts the options of NewBrowser, and the browser
+// repeats its queries and keeps a cache as usual.  It returns ctx's error if
+// ctx is done first.
+//
+// Example usage:
+//     entry, err := mdns.WaitFor(ctx, "_http._tcp", func(e *mdns.ServiceEntry) bool {
+//       return strings.HasPrefix(e.Name, "printer-")
+//     })
+func WaitFor(ctx context.Context, service string, match func(*ServiceEntry) bool, opts ...QueryOption) (*ServiceEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b, err := NewBrowser(ctx, service, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	for ev := range b.Events() {
+		if ev.Type == ServiceRemoved {
+			continue
+		}
+		if match == nil || match(ev.Entry) {
+			return ev.Entry, nil
+		}
+	}
+	return nil, ctx.Err()
+}
@@ -0,0 +1,35 @@
+package mdns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWaitFor(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_waitfor._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	entry, err := WaitFor(ctx, "_waitfor._tcp", func(e *ServiceEntry) bool {
+		return strings.HasPrefix(e.Name, "hostname.") && e.Port == 80
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry.Name != "hostname._waitfor._tcp.local." {
+		t.Errorf("bad entry: %+v", entry)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := WaitFor(ctx, "_waitfor._tcp", func(e *ServiceEntry) bool { return e.Port == 8080 }); err != context.DeadlineExceeded {
+		t.Errorf("got %v for an instance that never appears, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,176 @@
+package mdns
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// HostAddrs is the set of addresses known for a host at a point in time.
+type HostAddrs struct {
+	Host   string   `json:"host"`
+	AddrV4 []net.IP `json:"ipv4"`
+	AddrV6 []net.IP `json:"ipv6"`
+}
+
+// equal returns true if h and o hold the same addresses.
+func (h *HostAddrs) equal(o *HostAddrs) bool {
+	return ipsEqual(h.AddrV4, o.AddrV4) && ipsEqual(h.AddrV6, o.AddrV6)
+}
+
+func ipsEqual(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// HostWatchParam is used to customize how WatchHost is performed
+type HostWatchParam struct {
+	Host      string            // Host name to watch, e.g. "nas.local."
+	Context   context.Context   // Watching stops when the context is done. Required
+	Interface *net.Interface    // Multicast interface to use
+	Interval  time.Duration     // Longest interval between queries, default 1 minute
+	Changes   chan<- *HostAddrs // Receives the host's addresses each time they change
+	Logger    *log.Logger       // Logger for errors, default is the standard logger
+	Metrics   *ClientMetrics    // Optional counters updated as the watch runs
+}
+
+// WatchHost monitors the A and AAAA records of a host and sends its addresses
+// to params.Changes whenever they change, so that long lived connections can
+// be re-established when, for example, a device is given a new address by
+// DHCP.  The first value sent holds the addresses found initially; a value
+// with no addresses means the host's records have expired or been withdrawn.
+//
+// WatchHost queries the host with an interval that starts at one second and
+// doubles up to params.Interval, and also picks up unsolicited announcements.
+// It blocks until params.Context is done.
+func WatchHost(params *HostWatchParam) error {
+	if params.Context == nil {
+		return fmt.Errorf("mdns: WatchHost requires a Context")
+	}
+	host := dns.Fqdn(params.Host)
+	maxInterval := params.Interval
+	if maxInterval == 0 {
+		maxInterval = time.Minute
+	}
+
+	client, err := newClient(params.Logger, params.Metrics)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface, false); err != nil {
+			return err
+		}
+	}
+
+	msgCh := make(chan *received, 32)
+	go client.recv(client.ipv4UnicastConn, msgCh)
+	go client.recv(client.ipv6UnicastConn, msgCh)
+	go client.recv(client.ipv4MulticastConn, msgCh)
+	go client.recv(client.ipv6MulticastConn, msgCh)
+
+	q := new(dns.Msg)
+	q.SetQuestion(host, dns.TypeA)
+	q.Question = append(q.Question, dns.Question{Name: host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	q.RecursionDesired = false
+
+	interval := time.Second
+	query := time.NewTimer(0)
+	defer query.Stop()
+
+	// Records can expire without any packet arriving, so check regularly.
+	expiry := time.NewTicker(time.Second)
+	defer expiry.Stop()
+
+	cache := NewCache()
+	var last *HostAddrs
+	notify := func() error {
+		addrs := cachedHostAddrs(cache, host)
+		if last != nil && last.equal(addrs) {
+			return nil
+		}
+		last = addrs
+		select {
+		case params.Changes <- addrs:
+			return nil
+		case <-params.Context.Done():
+			return params.Context.Err()
+		}
+	}
+
+	for {
+		select {
+		case <-query.C:
+			// A host without addresses of one family says so with an NSEC
+			// record, and is not asked for them again until it expires.
+			if m := cache.withoutNegative(q); m != nil {
+				if err := client.sendQuery(m); err != nil {
+					client.logf("[ERR] mdns: Failed to query host %s: %v", host, err)
+				}
+			}
+			query.Reset(interval)
+			if interval *= 2; interval > maxInterval {
+				interval = maxInterval
+			}
+		case r := <-msgCh:
+			if !r.msg.Response {
+				continue
+			}
+			cache.addMsg(r.msg, r.from)
+			if last == nil {
+				// Wait for the first query to be answered before reporting.
+				if len(cache.Lookup(host, dns.TypeA)) == 0 && len(cache.Lookup(host, dns.TypeAAAA)) == 0 {
+					continue
+				}
+			}
+			if err := notify(); err != nil {
+				return nil
+			}
+		case <-expiry.C:
+			if last == nil {
+				continue
+			}
+			if err := notify(); err != nil {
+				return nil
+			}
+		case <-params.Context.Done():
+			return nil
+		}
+	}
+}
+
+// cachedHostAddrs returns the addresses of host held in the cache, in a
+// stable order.
+func cachedHostAddrs(cache *Cache, host string) *HostAddrs {
+	addrs := &HostAddrs{Host: host}
+	for _, rr := range cache.Lookup(host, dns.TypeA) {
+		addrs.AddrV4 = append(addrs.AddrV4, rr.(*dns.A).A)
+	}
+	for _, rr := range cache.Lookup(host, dns.TypeAAAA) {
+		addrs.AddrV6 = append(addrs.AddrV6, rr.(*dns.AAAA).AAAA)
+	}
+	sortIPs(addrs.AddrV4)
+	sortIPs(addrs.AddrV6)
+	return addrs
+}
+
+func sortIPs(ips []net.IP) {
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0
+	})
+}
@@ -0,0 +1,68 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+func TestCachedHostAddrs_Rotation(t *testing.T) {
+	c, clock := makeTestCache()
+	a := func(ip net.IP) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: "nas.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: 120},
+			A:   ip,
+		}
+	}
+
+	c.Add(a(net.IP([]byte{192, 168, 0, 42})))
+	before := cachedHostAddrs(c, "nas.local.")
+	if len(before.AddrV4) != 1 {
+		t.Fatalf("bad addresses: %+v", before)
+	}
+
+	// The device is given a new address and announces it.
+	clock.advance(10 * time.Second)
+	c.Add(a(net.IP([]byte{192, 168, 0, 99})))
+	clock.advance(goodbyeGrace)
+
+	after := cachedHostAddrs(c, "nas.local.")
+	if before.equal(after) {
+		t.Fatalf("address change not detected: %+v", after)
+	}
+	if len(after.AddrV4) != 1 || !after.AddrV4[0].Equal(net.IP([]byte{192, 168, 0, 99})) {
+		t.Errorf("bad addresses after rotation: %+v", after)
+	}
+}
+
+func TestServer_WatchHost(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_foobar._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer serv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	changes := make(chan *HostAddrs, 1)
+	go WatchHost(&HostWatchParam{
+		Host:    "testhost.",
+		Context: ctx,
+		Changes: changes,
+	})
+
+	select {
+	case addrs := <-changes:
+		if len(addrs.AddrV4) != 1 || !addrs.AddrV4[0].Equal(net.IP([]byte{192, 168, 0, 42})) {
+			t.Errorf("bad IPv4 addresses: %+v", addrs)
+		}
+		if len(addrs.AddrV6) != 1 {
+			t.Errorf("bad IPv6 addresses: %+v", addrs)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timeout")
+	}
+}
@@ -0,0 +1,131 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// resolvConfPath is the resolver configuration read to find unicast DNS
+// servers when none are configured.
+var resolvConfPath = "/etc/resolv.conf"
+
+// wideAreaTimeout bounds each unicast DNS exchange.
+const wideAreaTimeout = 2 * time.Second
+
+// isLocalDomain returns true if names in domain are resolved with multicast
+// DNS: "local." and its subdomains, and the link-local reverse mapping
+// domains listed in section 3 of RFC 6762.
+func isLocalDomain(domain string) bool {
+	d := strings.ToLower(trimDot(domain))
+	if d == "local" || strings.HasSuffix(d, ".local") {
+		return true
+	}
+	for _, ll := range []string{"254.169.in-addr.arpa", "8.e.f.ip6.arpa", "9.e.f.ip6.arpa", "a.e.f.ip6.arpa", "b.e.f.ip6.arpa"} {
+		if d == ll || strings.HasSuffix(d, "."+ll) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemResolvers returns the unicast DNS servers listed in the system
+// resolver configuration, as "host:port" strings.
+func systemResolvers() ([]string, error) {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("mdns: could not read resolver configuration: %v", err)
+	}
+	var servers []string
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("mdns: no DNS servers in %s", resolvConfPath)
+	}
+	return servers, nil
+}
+
+// unicastExchange asks the given unicast DNS servers, in turn, for records of
+// the given name and type, and returns the first successful response.
+// Truncated responses are retried over TCP.
+func unicastExchange(ctx context.Context, servers []string, name string, qtype uint16) (*dns.Msg, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), qtype)
+
+	var lastErr error
+	for _, server := range servers {
+		c := &dns.Client{Timeout: wideAreaTimeout}
+		resp, _, err := c.ExchangeContext(ctx, q, server)
+		if err == nil && resp.Truncated {
+			c.Net = "tcp"
+			resp, _, err = c.ExchangeContext(ctx, q, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("mdns: %s returned %s for %s", server, dns.RcodeToString[resp.Rcode], name)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// wideAreaBrowse browses for instances of service using conventional unicast
+// DNS-SD, as described in RFC 6763.  For each instance found, a response
+// holding its PTR, SRV, TXT and address records is sent to msgCh, so that the
+// results are assembled and delivered like multicast responses.
+func (c *client) wideAreaBrowse(ctx context.Context, service string, servers []string, msgCh chan<- *received) {
+	ptrs, err := unicastExchange(ctx, servers, service, dns.TypePTR)
+	if err != nil {
+		c.logf("[ERR] mdns: Failed to browse %s over unicast DNS: %v", service, err)
+		return
+	}
+
+	for _, rr := range ptrs.Answer {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok {
+			continue
+		}
+		m := new(dns.Msg)
+		m.Response = true
+		m.Answer = []dns.RR{ptr}
+		srvs := c.wideAreaRecords(ctx, servers, ptr.Ptr, dns.TypeSRV)
+		m.Extra = append(srvs, c.wideAreaRecords(ctx, servers, ptr.Ptr, dns.TypeTXT)...)
+		for _, rr := range srvs {
+			srv := rr.(*dns.SRV)
+			m.Extra = append(m.Extra, c.wideAreaRecords(ctx, servers, srv.Target, dns.TypeA)...)
+			m.Extra = append(m.Extra, c.wideAreaRecords(ctx, servers, srv.Target, dns.TypeAAAA)...)
+		}
+
+		select {
+		case msgCh <- &received{msg: m, at: time.Now()}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wideAreaRecords returns the records of the given name and type found with
+// unicast DNS.
+func (c *client) wideAreaRecords(ctx context.Context, servers []string, name string, qtype uint16) []dns.RR {
+	resp, err := unicastExchange(ctx, servers, name, qtype)
+	if err != nil {
+		c.logf("[ERR] mdns: Failed to resolve %s over unicast DNS: %v", name, err)
+		return nil
+	}
+	var recs []dns.RR
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype {
+			recs = append(recs, rr)
+		}
+	}
+	return recs
+}
@@ -0,0 +1,77 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// startUnicastServer starts a unicast DNS server on the loopback interface
+// that answers from the given zone, and returns its address.
+func startUnicastServer(t *testing.T, zone Zone) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = zone.Records(r.Question[0])
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	return pc.LocalAddr().String(), func() { srv.Shutdown() }
+}
+
+func TestIsLocalDomain(t *testing.T) {
+	for _, test := range []struct {
+		domain string
+		want   bool
+	}{
+		{"local", true},
+		{"local.", true},
+		{"Office.LOCAL.", true},
+		{"254.169.in-addr.arpa.", true},
+		{"example.com.", false},
+		{"localhost.", false},
+	} {
+		if got := isLocalDomain(test.domain); got != test.want {
+			t.Errorf("isLocalDomain(%q) = %v, want %v", test.domain, got, test.want)
+		}
+	}
+}
+
+func TestWideAreaBrowse(t *testing.T) {
+	s, err := NewMDNSService("hostname", "_foobar._tcp", "example.com.", "testhost.example.com.", 80,
+		[]net.IP{net.IP([]byte{192, 168, 0, 42})}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	addr, stop := startUnicastServer(t, s)
+	defer stop()
+
+	c := &client{closedCh: make(chan struct{}), metrics: new(ClientMetrics)}
+	msgCh := make(chan *received, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c.wideAreaBrowse(ctx, "_foobar._tcp.example.com.", []string{addr}, msgCh)
+
+	select {
+	case r := <-msgCh:
+		e := messageToEntry(r.msg, make(map[string]*ServiceEntry))
+		if e == nil || !e.complete() {
+			t.Fatalf("incomplete entry from unicast DNS-SD: %+v", e)
+		}
+		if e.Name != "hostname._foobar._tcp.example.com." || e.Port != 80 || e.AddrV4 == nil {
+			t.Errorf("bad entry: %+v", e)
+		}
+	default:
+		t.Fatalf("no response from unicast DNS-SD")
+	}
+}
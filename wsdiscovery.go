@@ -0,0 +1,299 @@
+package mdns
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// wsdMaxAge is how long a WS-Discovery device is kept without being heard
+	// from.  Devices do not say how long they stay, so they must answer one
+	// of the repeated probes in this time.
+	wsdMaxAge = 5 * ssdpSearchInterval
+
+	wsdNamespace = "http://schemas.xmlsoap.org/ws/2005/04/discovery"
+)
+
+var (
+	// WS-Discovery group addresses, from section 2.4 of the WS-Discovery
+	// specification.
+	wsdAddrIPv4 = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 3702}
+	wsdAddrIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::c"), Port: 3702}
+)
+
+// wsdEnvelope is the part of a WS-Discovery SOAP envelope that discovery
+// needs: the Hello, Bye and ProbeMatches messages.
+type wsdEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Header  struct {
+		Action string `xml:"Action"`
+	} `xml:"Header"`
+	Body struct {
+		Hello        *wsdMatch   `xml:"Hello"`
+		Bye          *wsdMatch   `xml:"Bye"`
+		ProbeMatches []*wsdMatch `xml:"ProbeMatches>ProbeMatch"`
+	} `xml:"Body"`
+}
+
+// wsdMatch describes a target service in a Hello, Bye or ProbeMatch.
+type wsdMatch struct {
+	Address         string `xml:"EndpointReference>Address"`
+	Types           string `xml:"Types"`
+	Scopes          string `xml:"Scopes"`
+	XAddrs          string `xml:"XAddrs"`
+	MetadataVersion string `xml:"MetadataVersion"`
+}
+
+// wsdSearch holds the sockets used to discover devices with WS-Discovery: one
+// that probes are sent from, which receives the matches, and one joined to
+// the group, which receives Hello and Bye announcements.
+type wsdSearch struct {
+	conn   *net.UDPConn
+	notify *ipv4.PacketConn // nil if the group could not be joined
+}
+
+// newWSDSearch opens the sockets for a WS-Discovery search on iface, or on
+// the system's default interface if it is nil.
+func newWSDSearch(iface *net.Interface, logger *log.Logger) (*wsdSearch, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	if iface != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(iface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	s := &wsdSearch{conn: conn}
+
+	var ifaces []*net.Interface
+	if iface != nil {
+		ifaces = []*net.Interface{iface}
+	}
+	if p4, p6, err := listenMulticast(ifaces, true, wsdAddrIPv4, wsdAddrIPv6); err != nil {
+		msg := fmt.Sprintf("[WARN] mdns: Failed to listen for WS-Discovery announcements: %v", err)
+		if logger != nil {
+			logger.Print(msg)
+		} else {
+			log.Print(msg)
+		}
+	} else {
+		if p6 != nil {
+			p6.Close()
+		}
+		s.notify = p4
+	}
+	return s, nil
+}
+
+// Close closes the search's sockets.
+func (s *wsdSearch) Close() {
+	s.conn.Close()
+	if s.notify != nil {
+		s.notify.Close()
+	}
+}
+
+// probe sends a Probe for the given types to the WS-Discovery group.
+func (s *wsdSearch) probe(types []string) error {
+	msg, err := wsdProbe(types)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(msg, wsdAddrIPv4)
+	return err
+}
+
+// wsdProbe returns a Probe message for the given types, each a namespace and
+// local name separated by the last colon, such as
+// "http://www.onvif.org/ver10/network/wsdl:NetworkVideoTransmitter".  A probe
+// for no types matches every device.
+func wsdProbe(types []string) ([]byte, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	var xmlns, qnames []string
+	for i, t := range types {
+		j := strings.LastIndex(t, ":")
+		if j <= 0 || j == len(t)-1 {
+			return nil, fmt.Errorf("mdns: bad WS-Discovery type %q", t)
+		}
+		var ns strings.Builder
+		xml.EscapeText(&ns, []byte(t[:j]))
+		xmlns = append(xmlns, fmt.Sprintf(` xmlns:t%d="%s"`, i, ns.String()))
+		qnames = append(qnames, fmt.Sprintf("t%d:%s", i, t[j+1:]))
+	}
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" `+
+		`xmlns:d="%s"%s>`+
+		`<s:Header>`+
+		`<a:Action s:mustUnderstand="1">%s/Probe</a:Action>`+
+		`<a:MessageID>urn:uuid:%x-%x-%x-%x-%x</a:MessageID>`+
+		`<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`+
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>`+
+		`</s:Header>`+
+		`<s:Body><d:Probe><d:Types>%s</d:Types></d:Probe></s:Body>`+
+		`</s:Envelope>`,
+		wsdNamespace, strings.Join(xmlns, ""), wsdNamespace,
+		id[0:4], id[4:6], id[6:8], id[8:10], id[10:16], strings.Join(qnames, " "))), nil
+}
+
+// parseWSD parses a WS-Discovery message received from the given address.  It
+// returns the devices it describes, and whether they have left the network
+// (Bye).  Other messages, such as the probes of other clients, give an error.
+func parseWSD(buf []byte, from *net.UDPAddr, now time.Time) ([]*Device, bool, error) {
+	var env wsdEnvelope
+	if err := xml.Unmarshal(buf, &env); err != nil {
+		return nil, false, err
+	}
+	matches := env.Body.ProbeMatches
+	bye := false
+	switch {
+	case env.Body.Hello != nil:
+		matches = []*wsdMatch{env.Body.Hello}
+	case env.Body.Bye != nil:
+		matches = []*wsdMatch{env.Body.Bye}
+		bye = true
+	case len(matches) == 0:
+		return nil, false, fmt.Errorf("mdns: not a WS-Discovery announcement: %s", env.Header.Action)
+	}
+
+	var devices []*Device
+	for _, m := range matches {
+		m.Address = strings.TrimSpace(m.Address)
+		if m.Address == "" {
+			continue
+		}
+		d := &Device{
+			Protocol: ProtocolWSDiscovery,
+			ID:       m.Address,
+			Type:     strings.Join(strings.Fields(m.Types), " "),
+			Addrs:    []net.IP{from.IP},
+			Info:     make(map[string]string),
+			LastSeen: now,
+			expires:  now.Add(wsdMaxAge),
+		}
+		for k, v := range map[string]string{
+			"Types":           m.Types,
+			"Scopes":          m.Scopes,
+			"XAddrs":          m.XAddrs,
+			"MetadataVersion": m.MetadataVersion,
+		} {
+			if v = strings.Join(strings.Fields(v), " "); v != "" {
+				d.Info[k] = v
+			}
+		}
+		if xaddrs := strings.Fields(m.XAddrs); len(xaddrs) > 0 {
+			d.Location = xaddrs[0]
+			if u, err := url.Parse(d.Location); err == nil && u.Host != "" {
+				if ip := net.ParseIP(u.Hostname()); ip != nil {
+					d.Addrs = []net.IP{ip}
+				}
+				if port, err := strconv.Atoi(u.Port()); err == nil {
+					d.Port = port
+				} else if u.Scheme == "http" {
+					d.Port = 80
+				} else if u.Scheme == "https" {
+					d.Port = 443
+				}
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices, bye, nil
+}
+
+// wsdMatchesTypes returns true if a device's types include all the given
+// types, compared by local name, as the prefixes of the device's types are
+// not known.
+func wsdMatchesTypes(deviceTypes string, types []string) bool {
+	have := make(map[string]bool)
+	for _, qname := range strings.Fields(deviceTypes) {
+		have[qname[strings.LastIndex(qname, ":")+1:]] = true
+	}
+	for _, t := range types {
+		if !have[t[strings.LastIndex(t, ":")+1:]] {
+			return false
+		}
+	}
+	return true
+}
+
+// wsDiscovery probes for devices with WS-Discovery, and reports them until
+// ctx is done.  Devices are removed when they say goodbye, or when they stop
+// answering probes.
+func (d *Discovery) wsDiscovery(ctx context.Context, s *wsdSearch) {
+	defer d.wg.Done()
+	defer s.Close()
+
+	types := d.config.WSDiscoveryTypes
+	ch := make(chan *packet, 32)
+	done := ctx.Done()
+	go readPackets(s.conn.ReadFrom, ch, done)
+	if s.notify != nil {
+		go readPackets(func(b []byte) (int, net.Addr, error) {
+			n, _, from, err := s.notify.ReadFrom(b)
+			return n, from, err
+		}, ch, done)
+	}
+
+	known := make(map[string]*Device) // By endpoint address
+	interval := time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	sweep := time.NewTicker(time.Second)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := s.probe(types); err != nil {
+				d.logf("[ERR] mdns: Failed to send WS-Discovery probe: %v", err)
+			}
+			timer.Reset(interval)
+			if interval *= 2; interval > ssdpSearchInterval {
+				interval = ssdpSearchInterval
+			}
+		case p := <-ch:
+			devices, bye, err := parseWSD(p.buf, p.from, p.at)
+			if err != nil {
+				continue
+			}
+			for _, dev := range devices {
+				ok := true
+				switch {
+				case bye:
+					ok = d.gone(ctx, known, dev.ID)
+				case !wsdMatchesTypes(dev.Type, types):
+					// A Hello from something not probed for.
+				default:
+					ok = d.seen(ctx, known, dev)
+				}
+				if !ok {
+					return
+				}
+			}
+		case now := <-sweep.C:
+			if !d.expire(ctx, known, now) {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const wsdTestMatches = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<s:Header><a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</a:Action></s:Header>
+<s:Body><d:ProbeMatches><d:ProbeMatch>
+<a:EndpointReference><a:Address>urn:uuid:wsdtest-camera</a:Address></a:EndpointReference>
+<d:Types>dn:NetworkVideoTransmitter</d:Types>
+<d:Scopes>onvif://www.onvif.org/name/Camera onvif://www.onvif.org/hardware/X1</d:Scopes>
+<d:XAddrs>http://127.0.0.1:8080/onvif/device_service</d:XAddrs>
+<d:MetadataVersion>1</d:MetadataVersion>
+</d:ProbeMatch></d:ProbeMatches></s:Body>
+</s:Envelope>`
+
+const wsdTestBye = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<s:Header><a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/Bye</a:Action></s:Header>
+<s:Body><d:Bye><a:EndpointReference><a:Address>urn:uuid:wsdtest-camera</a:Address></a:EndpointReference></d:Bye></s:Body>
+</s:Envelope>`
+
+func TestParseWSD(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 9), Port: 3702}
+	devices, bye, err := parseWSD([]byte(wsdTestMatches), from, time.Now())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if bye || len(devices) != 1 {
+		t.Fatalf("bad devices: %v", devices)
+	}
+	d := devices[0]
+	if d.Protocol != ProtocolWSDiscovery || d.ID != "urn:uuid:wsdtest-camera" || d.Type != "dn:NetworkVideoTransmitter" ||
+		d.Location != "http://127.0.0.1:8080/onvif/device_service" || d.Port != 8080 ||
+		!d.Addrs[0].Equal(net.IPv4(127, 0, 0, 1)) ||
+		d.Info["Scopes"] != "onvif://www.onvif.org/name/Camera onvif://www.onvif.org/hardware/X1" {
+		t.Errorf("bad device: %+v", d)
+	}
+
+	devices, bye, err = parseWSD([]byte(wsdTestBye), from, time.Now())
+	if err != nil || !bye || len(devices) != 1 || devices[0].ID != "urn:uuid:wsdtest-camera" {
+		t.Errorf("bad bye: %v %v %v", devices, bye, err)
+	}
+
+	types := []string{"http://www.onvif.org/ver10/network/wsdl:NetworkVideoTransmitter"}
+	probe, err := wsdProbe(types)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, _, err := parseWSD(probe, from, time.Now()); err == nil {
+		t.Errorf("parsed a probe")
+	}
+	if !strings.Contains(string(probe), `xmlns:t0="http://www.onvif.org/ver10/network/wsdl"`) ||
+		!strings.Contains(string(probe), "<d:Types>t0:NetworkVideoTransmitter</d:Types>") {
+		t.Errorf("bad probe: %s", probe)
+	}
+	if _, err := wsdProbe([]string{"NetworkVideoTransmitter"}); err == nil {
+		t.Errorf("accepted a type without a namespace")
+	}
+
+	if !wsdMatchesTypes("dn:NetworkVideoTransmitter tds:Device", types) || wsdMatchesTypes("wsdp:Device", types) {
+		t.Errorf("bad type matching")
+	}
+}
+
+func TestDiscovery_WSDiscovery(t *testing.T) {
+	p4, p6, err := listenMulticast(nil, true, wsdAddrIPv4, wsdAddrIPv6)
+	if err != nil || p4 == nil {
+		t.Skipf("cannot join the WS-Discovery group: %v", err)
+	}
+	if p6 != nil {
+		p6.Close()
+	}
+	defer p4.Close()
+
+	// Answer probes like a camera.
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, from, err := p4.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if strings.Contains(string(buf[:n]), "NetworkVideoTransmitter</d:Types>") {
+				p4.WriteTo([]byte(wsdTestMatches), nil, from)
+			}
+		}
+	}()
+
+	d, err := NewDiscovery(context.Background(), &DiscoveryConfig{
+		WSDiscovery:      true,
+		WSDiscoveryTypes: []string{"http://www.onvif.org/ver10/network/wsdl:NetworkVideoTransmitter"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer d.Close()
+
+	next := func() *DiscoveryEvent {
+		for {
+			select {
+			case ev := <-d.Events():
+				if ev.Device.ID == "urn:uuid:wsdtest-camera" {
+					return ev
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("no event")
+			}
+		}
+	}
+	if ev := next(); ev.Type != ServiceAdded || ev.Device.Protocol != ProtocolWSDiscovery || ev.Device.Port != 8080 {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Device)
+	}
+
+	if _, err := p4.WriteTo([]byte(wsdTestBye), nil, wsdAddrIPv4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ev := next(); ev.Type != ServiceRemoved {
+		t.Fatalf("bad event: %v %+v", ev.Type, ev.Device)
+	}
+}
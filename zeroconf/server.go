@@ -0,0 +1,138 @@
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/micro/mdns"
+)
+
+// Server advertises a service instance until it is shut down.
+type Server struct {
+	server *mdns.Server
+	set    *mdns.ServiceSet
+	name   string // Instance name of the service
+
+	lock sync.Mutex // Serializes changes to the service
+}
+
+// Register advertises an instance of service, which may list subtypes after
+// the service type such as "_http._tcp,_printer", on port of this host.  If
+// ifaces are given, the service is advertised on them, and on all interfaces
+// otherwise, with the addresses of those interfaces.
+func Register(instance, service, domain string, port int, text []string, ifaces []net.Interface) (*Server, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("zeroconf: could not determine host: %v", err)
+	}
+	// Like zeroconf, advertise the addresses of the multicast interfaces.
+	addrIfaces := ifaces
+	if len(addrIfaces) == 0 {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+				addrIfaces = append(addrIfaces, iface)
+			}
+		}
+	}
+	var ips []net.IP
+	for i := range addrIfaces {
+		addrs, err := addrIfaces[i].Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	return register(instance, service, domain, port, host, ips, text, ifaces)
+}
+
+// RegisterProxy advertises an instance of service on behalf of another host,
+// with the given host name and IP addresses.
+func RegisterProxy(instance, service, domain string, port int, host string, ips []string, text []string, ifaces []net.Interface) (*Server, error) {
+	if host == "" {
+		return nil, fmt.Errorf("zeroconf: missing host name")
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("zeroconf: missing IP addresses")
+	}
+	var parsed []net.IP
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("zeroconf: invalid IP address %q", s)
+		}
+		parsed = append(parsed, ip)
+	}
+	return register(instance, service, domain, port, host, parsed, text, ifaces)
+}
+
+func register(instance, service, domain string, port int, host string, ips []net.IP, text []string, ifaces []net.Interface) (*Server, error) {
+	record := NewServiceRecord(instance, service, domain)
+	// The host is in the service's domain, as in zeroconf.
+	if !strings.HasSuffix(trimDot(host), trimDot(record.Domain)) {
+		host = trimDot(host) + "." + trimDot(record.Domain)
+	}
+	svc, err := mdns.NewMDNSService(instance, record.Service, record.Domain, trimDot(host)+".", port, ips, text)
+	if err != nil {
+		return nil, fmt.Errorf("zeroconf: %v", err)
+	}
+	svc.Subtypes = record.Subtypes
+
+	set, err := mdns.NewServiceSet(svc)
+	if err != nil {
+		return nil, err
+	}
+	config := &mdns.Config{Zone: set}
+	for i := range ifaces {
+		config.Interfaces = append(config.Interfaces, &ifaces[i])
+	}
+	server, err := mdns.NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{server: server, set: set, name: svc.InstanceName()}, nil
+}
+
+// Shutdown withdraws the service.
+func (s *Server) Shutdown() {
+	s.server.Shutdown()
+}
+
+// SetText replaces the service's TXT record and announces the change.
+func (s *Server) SetText(text []string) {
+	s.update(func(svc *mdns.MDNSService) { svc.TXT = text }, true)
+}
+
+// TTL sets the TTL, in seconds, of the service's records from now on.
+func (s *Server) TTL(ttl uint32) {
+	s.update(func(svc *mdns.MDNSService) { svc.TTL = ttl }, false)
+}
+
+// update replaces the service with a changed copy, as the server may be
+// reading the old one, and announces it if asked to.
+func (s *Server) update(change func(*mdns.MDNSService), announce bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old := s.set.Get(s.name)
+	if old == nil {
+		return
+	}
+	svc := *old
+	change(&svc)
+	if _, err := s.set.Replace(&svc); err != nil {
+		return
+	}
+	if announce {
+		s.server.Announce(&svc)
+	}
+}
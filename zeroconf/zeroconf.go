@@ -0,0 +1,272 @@
+// Package zeroconf is a compatibility layer with the API of
+// github.com/grandcat/zeroconf, built on this library, so that programs can
+// move off that unmaintained package by changing their imports:
+//
+//     server, err := zeroconf.Register("GoZeroconf", "_workstation._tcp", "local.", 42424, []string{"txtv=0"}, nil)
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     defer server.Shutdown()
+//
+//     resolver, err := zeroconf.NewResolver(nil)
+//     if err != nil {
+//         log.Fatal(err)
+//     }
+//     entries := make(chan *zeroconf.ServiceEntry)
+//     go func() {
+//         for entry := range entries {
+//             log.Println(entry.Instance, entry.AddrIPv4, entry.Port)
+//         }
+//     }()
+//     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//     defer cancel()
+//     if err := resolver.Browse(ctx, "_workstation._tcp", "local.", entries); err != nil {
+//         log.Fatal(err)
+//     }
+//     <-ctx.Done()
+//
+// Browse and Lookup return at once, send entries until the context is done,
+// and then close the channel.  As in later versions of zeroconf, an instance
+// that leaves is sent again with a TTL of zero.
+package zeroconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/micro/mdns"
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// IPType selects the address families a Resolver reports.
+type IPType uint8
+
+// Options for SelectIPTraffic.
+const (
+	IPv4        IPType = 0x01
+	IPv6        IPType = 0x02
+	IPv4AndIPv6        = IPv4 | IPv6
+)
+
+// ServiceRecord names a service instance.
+type ServiceRecord struct {
+	Instance string   `json:"name"`     // Instance name (e.g. "My web page")
+	Service  string   `json:"type"`     // Service name (e.g. _http._tcp.)
+	Subtypes []string `json:"subtypes"` // Service subtypes
+	Domain   string   `json:"domain"`   // If blank, assumes "local"
+}
+
+// NewServiceRecord returns the record of an instance, where service may
+// list subtypes after the service type, separated by commas, such as
+// "_http._tcp,_printer".
+func NewServiceRecord(instance, service, domain string) *ServiceRecord {
+	parts := strings.Split(service, ",")
+	r := &ServiceRecord{
+		Instance: instance,
+		Service:  parts[0],
+		Subtypes: parts[1:],
+		Domain:   domain,
+	}
+	if r.Domain == "" {
+		r.Domain = "local."
+	}
+	return r
+}
+
+// ServiceName returns the service's name, such as "_http._tcp.local.".
+func (r *ServiceRecord) ServiceName() string {
+	return fmt.Sprintf("%s.%s.", trimDot(r.Service), trimDot(r.Domain))
+}
+
+// ServiceInstanceName returns the instance's name, such as
+// "My web page._http._tcp.local.", or "" if it has no instance.
+func (r *ServiceRecord) ServiceInstanceName() string {
+	if r.Instance == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s", r.Instance, r.ServiceName())
+}
+
+// ServiceTypeName returns the name the service type is enumerated as.
+func (r *ServiceRecord) ServiceTypeName() string {
+	return fmt.Sprintf("_services._dns-sd._udp.%s.", trimDot(r.Domain))
+}
+
+// ServiceEntry is an instance found by a Resolver.
+type ServiceEntry struct {
+	ServiceRecord
+	HostName string   `json:"hostname"` // Host machine DNS name
+	Port     int      `json:"port"`     // Service Port
+	Text     []string `json:"text"`     // Service info served as a TXT record
+	TTL      uint32   `json:"ttl"`      // TTL of the service record
+	AddrIPv4 []net.IP `json:"-"`        // Host machine IPv4 address
+	AddrIPv6 []net.IP `json:"-"`        // Host machine IPv6 address
+}
+
+// NewServiceEntry returns an entry for an instance.
+func NewServiceEntry(instance, service, domain string) *ServiceEntry {
+	return &ServiceEntry{ServiceRecord: *NewServiceRecord(instance, service, domain)}
+}
+
+func trimDot(s string) string {
+	return strings.Trim(s, ".")
+}
+
+// ClientOption configures a Resolver.
+type ClientOption func(*clientOpts)
+
+type clientOpts struct {
+	ipType IPType
+	ifaces []net.Interface
+}
+
+// SelectIPTraffic selects the address families to report, IPv4AndIPv6 by
+// default.
+func SelectIPTraffic(t IPType) ClientOption {
+	return func(o *clientOpts) {
+		o.ipType = t
+	}
+}
+
+// SelectIfaces selects the interfaces to query on, all by default.
+func SelectIfaces(ifaces []net.Interface) ClientOption {
+	return func(o *clientOpts) {
+		o.ifaces = ifaces
+	}
+}
+
+// Resolver browses for and looks up services.
+type Resolver struct {
+	opts clientOpts
+}
+
+// NewResolver returns a resolver with the given options.  The options are
+// those of zeroconf, whose NewResolver is also called with nil.
+func NewResolver(options ...ClientOption) (*Resolver, error) {
+	r := &Resolver{opts: clientOpts{ipType: IPv4AndIPv6}}
+	for _, o := range options {
+		if o != nil {
+			o(&r.opts)
+		}
+	}
+	if r.opts.ipType&IPv4AndIPv6 == 0 {
+		return nil, fmt.Errorf("zeroconf: no IP type selected")
+	}
+	return r, nil
+}
+
+// Browse sends the instances of service found in domain to entries until
+// ctx is done, and then closes entries.  service may name subtypes to
+// browse after the service type, such as "_http._tcp,_printer".
+func (r *Resolver) Browse(ctx context.Context, service, domain string, entries chan<- *ServiceEntry) error {
+	record := NewServiceRecord("", service, domain)
+	name := record.Service
+	if len(record.Subtypes) > 0 {
+		// Only one subtype can be browsed, as with zeroconf.
+		name = record.Subtypes[0] + "._sub." + record.Service
+	}
+	var browsers []*mdns.Browser
+	for _, opts := range r.queryOptions(record.Domain) {
+		b, err := mdns.NewBrowser(ctx, name, opts...)
+		if err != nil {
+			for _, b := range browsers {
+				b.Close()
+			}
+			return err
+		}
+		browsers = append(browsers, b)
+	}
+
+	events := make(chan *mdns.BrowseEvent)
+	done := make(chan struct{})
+	for _, b := range browsers {
+		go func(b *mdns.Browser) {
+			defer func() { done <- struct{}{} }()
+			for ev := range b.Events() {
+				events <- ev
+			}
+		}(b)
+	}
+	go func() {
+		defer close(entries)
+		for running := len(browsers); running > 0; {
+			select {
+			case ev := <-events:
+				e := r.entry(ev.Entry, record)
+				if ev.Type == mdns.ServiceRemoved {
+					e.TTL = 0
+				}
+				entries <- e
+			case <-done:
+				running--
+			}
+		}
+	}()
+	return nil
+}
+
+// Lookup sends the instance of service in domain with the given name to
+// entries once it is found, and closes entries when ctx is done.
+func (r *Resolver) Lookup(ctx context.Context, instance, service, domain string, entries chan<- *ServiceEntry) error {
+	record := NewServiceRecord(instance, service, domain)
+	name := dns.Fqdn(escapeLabel(instance) + "." + trimDot(record.Service) + "." + trimDot(record.Domain))
+	all := r.queryOptions(record.Domain)
+	found := make(chan *mdns.ServiceEntry, len(all))
+	for _, opts := range all {
+		go func(opts []mdns.QueryOption) {
+			if e, err := mdns.Resolve(ctx, name, opts...); err == nil {
+				found <- e
+			}
+		}(opts)
+	}
+	go func() {
+		defer close(entries)
+		select {
+		case e := <-found:
+			entries <- r.entry(e, record)
+		case <-ctx.Done():
+		}
+		<-ctx.Done()
+	}()
+	return nil
+}
+
+// queryOptions returns the query options for each interface selected.
+func (r *Resolver) queryOptions(domain string) [][]mdns.QueryOption {
+	base := []mdns.QueryOption{mdns.WithDomain(trimDot(domain)), mdns.WithTimeout(0)}
+	if len(r.opts.ifaces) == 0 {
+		return [][]mdns.QueryOption{base}
+	}
+	var all [][]mdns.QueryOption
+	for i := range r.opts.ifaces {
+		opts := append(append([]mdns.QueryOption(nil), base...), mdns.WithInterface(&r.opts.ifaces[i]))
+		all = append(all, opts)
+	}
+	return all
+}
+
+// entry converts an entry found for record, keeping the addresses of the
+// selected families.
+func (r *Resolver) entry(e *mdns.ServiceEntry, record *ServiceRecord) *ServiceEntry {
+	se := NewServiceEntry(e.Instance(), record.Service, record.Domain)
+	se.Subtypes = record.Subtypes
+	se.HostName = e.Host
+	se.Port = e.Port
+	se.Text = e.InfoFields
+	se.TTL = uint32(e.TTL)
+	if e.AddrV4 != nil && r.opts.ipType&IPv4 != 0 {
+		se.AddrIPv4 = []net.IP{e.AddrV4}
+	}
+	if e.AddrV6 != nil && r.opts.ipType&IPv6 != 0 {
+		se.AddrIPv6 = []net.IP{e.AddrV6}
+	}
+	return se
+}
+
+// escapeLabel escapes the dots and backslashes of an instance name, so that
+// it is a single label.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `.`, `\.`).Replace(s)
+}
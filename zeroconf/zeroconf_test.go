@@ -0,0 +1,83 @@
+package zeroconf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestNewServiceRecord(t *testing.T) {
+	r := NewServiceRecord("My Page", "_http._tcp,_printer", "")
+	if r.Service != "_http._tcp" || !reflect.DeepEqual(r.Subtypes, []string{"_printer"}) || r.Domain != "local." {
+		t.Fatalf("bad record: %+v", r)
+	}
+	if name := r.ServiceInstanceName(); name != "My Page._http._tcp.local." {
+		t.Errorf("bad instance name %q", name)
+	}
+	if name := r.ServiceTypeName(); name != "_services._dns-sd._udp.local." {
+		t.Errorf("bad type name %q", name)
+	}
+}
+
+func TestRegisterAndBrowse(t *testing.T) {
+	server, err := RegisterProxy("zc test", "_zctest._tcp", "local.", 4242, "zchost", []string{"127.0.0.1"}, []string{"v=1"}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer server.Shutdown()
+
+	resolver, err := NewResolver(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	entries := make(chan *ServiceEntry, 4)
+	if err := resolver.Browse(ctx, "_zctest._tcp", "local.", entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Instance != "zc test" || e.Service != "_zctest._tcp" || e.HostName != "zchost.local." || e.Port != 4242 {
+			t.Fatalf("bad entry: %+v", e)
+		}
+		if len(e.AddrIPv4) != 1 || !reflect.DeepEqual(e.Text, []string{"v=1"}) {
+			t.Fatalf("bad entry: %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatalf("instance not found")
+	}
+
+	// Let the copies of the first announcement received on other
+	// interfaces arrive before changing the record.
+	time.Sleep(200 * time.Millisecond)
+	server.SetText([]string{"v=2"})
+	for changed := false; !changed; {
+		select {
+		case e := <-entries:
+			changed = reflect.DeepEqual(e.Text, []string{"v=2"})
+		case <-ctx.Done():
+			t.Fatalf("TXT change not seen")
+		}
+	}
+
+	// The repeats of the first announcement do not bring the old TXT
+	// record back.
+	settle := time.After(2500 * time.Millisecond)
+	for settled := false; !settled; {
+		select {
+		case e := <-entries:
+			if !reflect.DeepEqual(e.Text, []string{"v=2"}) {
+				t.Fatalf("TXT record changed back: %+v", e)
+			}
+		case <-settle:
+			settled = true
+		}
+	}
+	cancel()
+	// The channel is closed once the context is done.
+	for range entries {
+	}
+}
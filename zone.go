@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -30,6 +31,7 @@ type MDNSService struct {
 	Port         int      // Service Port
 	IPs          []net.IP // IP addresses for the service's host
 	TXT          []string // Service TXT records
+	Subtypes     []string // Subtypes the instance is also browsable as (e.g. "_printer")
 	TTL          uint32
 	serviceAddr  string // Fully qualified service address
 	instanceAddr string // Fully qualified instance address
@@ -45,15 +47,44 @@ func validateFQDN(s string) error {
 	if s[len(s)-1] != '.' {
 		return fmt.Errorf("FQDN must end in period: %s", s)
 	}
+	if s == "." || strings.Contains(s, "..") {
+		return fmt.Errorf("FQDN must not have empty labels: %s", s)
+	}
 	// TODO(reddaly): Perform full validation.
 
 	return nil
 }
 
+// fqdn returns name with a single trailing period, and without leading ones.
+func fqdn(name string) string {
+	return trimDot(name) + "."
+}
+
+// hostLabel returns a host name label derived from name, as returned by
+// os.Hostname: its first label, with the characters not allowed in host
+// names (RFC 952) replaced by hyphens, and without leading or trailing
+// hyphens.  It returns "" if nothing is left.
+func hostLabel(name string) string {
+	label := []byte(shortHostName(name))
+	for i, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			label[i] = '-'
+		}
+	}
+	name = strings.Trim(string(label), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
 // NewMDNSService returns a new instance of MDNSService.
 //
 // If domain, hostName, or ips is set to the zero value, then a default value
-// will be inferred from the operating system.
+// will be inferred from the operating system: the host name is this host's,
+// with the characters not allowed in host names replaced, in domain.  The
+// periods around service, domain and hostName may be left out, and service
+// and domain are lower cased, as names are matched regardless of case.
 //
 // TODO(reddaly): This interface may need to change to account for "unique
 // record" conflict rules of the mDNS protocol.  Upon startup, the server should
@@ -72,41 +103,53 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 		return nil, fmt.Errorf("missing service port")
 	}
 
+	service = strings.ToLower(trimDot(service))
+
 	// Set default domain
 	if domain == "" {
 		domain = "local."
 	}
+	domain = strings.ToLower(fqdn(domain))
 	if err := validateFQDN(domain); err != nil {
 		return nil, fmt.Errorf("domain %q is not a fully-qualified domain name: %v", domain, err)
 	}
 
 	// Get host information if no host is specified.
+	var osHostName string
 	if hostName == "" {
 		var err error
-		hostName, err = os.Hostname()
+		osHostName, err = os.Hostname()
 		if err != nil {
 			return nil, fmt.Errorf("could not determine host: %v", err)
 		}
-		hostName = fmt.Sprintf("%s.", hostName)
+		label := hostLabel(osHostName)
+		if label == "" {
+			return nil, fmt.Errorf("could not derive a host name from %q", osHostName)
+		}
+		hostName = label + "." + domain
 	}
+	hostName = fqdn(hostName)
 	if err := validateFQDN(hostName); err != nil {
 		return nil, fmt.Errorf("hostName %q is not a fully-qualified domain name: %v", hostName, err)
 	}
 
 	if len(ips) == 0 {
+		// Try the host name, then with the host domain suffix appended
+		// (required for Linux-based hosts), and for a derived host name,
+		// the system's own name first.
+		names := []string{trimDot(hostName), trimDot(hostName) + "." + trimDot(domain)}
+		if osHostName != "" {
+			names = append([]string{osHostName}, names...)
+		}
 		var err error
-		ips, err = net.LookupIP(trimDot(hostName))
-		if err != nil {
-			// Try appending the host domain suffix and lookup again
-			// (required for Linux-based hosts)
-			tmpHostName := fmt.Sprintf("%s%s", hostName, domain)
-
-			ips, err = net.LookupIP(trimDot(tmpHostName))
-
-			if err != nil {
-				return nil, fmt.Errorf("could not determine host IP addresses for %s", hostName)
+		for _, name := range names {
+			if ips, err = net.LookupIP(name); err == nil {
+				break
 			}
 		}
+		if err != nil {
+			return nil, fmt.Errorf("could not determine host IP addresses for %s", hostName)
+		}
 	}
 	for _, ip := range ips {
 		if ip.To4() == nil && ip.To16() == nil {
@@ -129,6 +172,45 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 	}, nil
 }
 
+// InstanceName returns the fully qualified name of the service instance, such
+// as "web._http._tcp.local.".
+func (m *MDNSService) InstanceName() string {
+	return m.instanceAddr
+}
+
+// nameKey returns the form of a domain name that names are compared in:
+// with escapes, such as the "\195\169" of an "é" in a name read from the
+// network or the "\ " of a space, decoded, except those of dots and
+// backslashes within labels, and with every letter, including non-ASCII
+// ones, in lower case.  Names are equal regardless of case and escaping if
+// their keys are, so that an instance published as "Café" is found by a
+// query for "CAFÉ".
+func nameKey(name string) string {
+	if !strings.Contains(name, "\\") {
+		return strings.ToLower(name)
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '\\' || i+1 == len(name) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		c = name[i]
+		if i+2 < len(name) && c >= '0' && c <= '9' {
+			if n, err := strconv.Atoi(name[i : i+3]); err == nil && n < 256 {
+				c, i = byte(n), i+2
+			}
+		}
+		if c == '.' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return strings.ToLower(b.String())
+}
+
 // trimDot is used to trim the dots from the start or end of a string
 func trimDot(s string) string {
 	return strings.Trim(s, ".")
@@ -136,23 +218,63 @@ func trimDot(s string) string {
 
 // Records returns DNS records in response to a DNS question.
 func (m *MDNSService) Records(q dns.Question) []dns.RR {
-	switch q.Name {
-	case m.enumAddr:
+	// Names are matched regardless of case (RFC 6762 section 16).
+	switch name := nameKey(q.Name); {
+	case name == nameKey(m.enumAddr):
 		return m.serviceEnum(q)
-	case m.serviceAddr:
+	case name == nameKey(m.serviceAddr):
 		return m.serviceRecords(q)
-	case m.instanceAddr:
+	case name == nameKey(m.instanceAddr):
 		return m.instanceRecords(q)
-	case m.HostName:
+	case name == nameKey(m.HostName):
 		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
 			return m.instanceRecords(q)
 		}
 		fallthrough
 	default:
+		if m.isSubtypeAddr(q.Name) {
+			// Subtypes are answered like the service name.
+			return m.serviceRecords(q)
+		}
 		return nil
 	}
 }
 
+// isSubtypeAddr returns true if name is the browsing name of one of the
+// service's subtypes, such as "_printer._sub._http._tcp.local.", as described
+// in section 7.1 of RFC 6763.
+func (m *MDNSService) isSubtypeAddr(name string) bool {
+	for _, sub := range m.Subtypes {
+		if nameKey(name) == nameKey(m.subtypeAddr(sub)) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtypeAddr returns the browsing name of a subtype.
+func (m *MDNSService) subtypeAddr(sub string) string {
+	return trimDot(sub) + "._sub." + m.serviceAddr
+}
+
+// subtypePTRs returns the PTR records of the service's subtypes, which are
+// announced and withdrawn along with the service.
+func (m *MDNSService) subtypePTRs() []dns.RR {
+	var recs []dns.RR
+	for _, sub := range m.Subtypes {
+		recs = append(recs, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   m.subtypeAddr(sub),
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    m.TTL,
+			},
+			Ptr: m.instanceAddr,
+		})
+	}
+	return recs
+}
+
 func (m *MDNSService) serviceEnum(q dns.Question) []dns.RR {
 	switch q.Qtype {
 	case dns.TypeANY:
@@ -38,13 +38,13 @@ func TestNewMDNSService_BadParams(t *testing.T) {
 	}{
 		{
 			"NewMDNSService should fail when passed hostName that is not a legal fully-qualified domain name",
-			"hostname", // not legal FQDN - should be "hostname." or "hostname.local.", etc.
-			"local.",   // legal
+			"hostname..local.", // empty label
+			"local.",           // legal
 		},
 		{
 			"NewMDNSService should fail when passed domain that is not a legal fully-qualified domain name",
 			"hostname.", // legal
-			"local",     // should be "local."
+			".",         // the root
 		},
 	} {
 		_, err := NewMDNSService(
@@ -61,6 +61,43 @@ func TestNewMDNSService_BadParams(t *testing.T) {
 	}
 }
 
+func TestNewMDNSService_Normalize(t *testing.T) {
+	m, err := NewMDNSService("My Printer", "._IPP._tcp.", "Local", "printer.local", 631,
+		[]net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if m.Service != "_ipp._tcp" || m.Domain != "local." || m.HostName != "printer.local." {
+		t.Errorf("bad names: %q %q %q", m.Service, m.Domain, m.HostName)
+	}
+	if got := m.InstanceName(); got != "My Printer._ipp._tcp.local." {
+		t.Errorf("bad instance name: %q", got)
+	}
+	for _, q := range []dns.Question{
+		{Name: "_IPP._TCP.local.", Qtype: dns.TypePTR},
+		{Name: "my printer._ipp._tcp.LOCAL.", Qtype: dns.TypeANY},
+		{Name: "PRINTER.local.", Qtype: dns.TypeA},
+	} {
+		if recs := m.Records(q); len(recs) == 0 {
+			t.Errorf("no records for %q", q.Name)
+		}
+	}
+}
+
+func TestHostLabel(t *testing.T) {
+	for _, test := range []struct{ name, want string }{
+		{"myhost", "myhost"},
+		{"MyHost.corp.example.com", "MyHost"},
+		{"John's MacBook Pro", "John-s-MacBook-Pro"},
+		{"_build_box_", "build-box"},
+		{"...", ""},
+	} {
+		if got := hostLabel(test.name); got != test.want {
+			t.Errorf("hostLabel(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
 func TestMDNSService_BadAddr(t *testing.T) {
 	s := makeService(t)
 	q := dns.Question{
@@ -109,6 +146,24 @@ func TestMDNSService_ServiceAddr(t *testing.T) {
 	}
 }
 
+func TestMDNSService_SubtypeAddr(t *testing.T) {
+	s := makeService(t)
+	s.Subtypes = []string{"_printer"}
+	recs := s.Records(dns.Question{Name: "_Printer._sub._http._tcp.local.", Qtype: dns.TypePTR})
+	if got, want := len(recs), 5; got != want {
+		t.Fatalf("got %d records, want %d: %v", got, want, recs)
+	}
+	if ptr, ok := recs[0].(*dns.PTR); !ok || ptr.Ptr != "hostname._http._tcp.local." {
+		t.Fatalf("bad PTR record: %v", recs[0])
+	}
+	if recs := s.Records(dns.Question{Name: "_scanner._sub._http._tcp.local.", Qtype: dns.TypePTR}); len(recs) != 0 {
+		t.Fatalf("unexpected records for another subtype: %v", recs)
+	}
+	if ptrs := s.subtypePTRs(); len(ptrs) != 1 || ptrs[0].Header().Name != "_printer._sub._http._tcp.local." {
+		t.Fatalf("bad subtype PTRs: %v", ptrs)
+	}
+}
+
 func TestMDNSService_InstanceAddr_ANY(t *testing.T) {
 	s := makeService(t)
 	q := dns.Question{
@@ -257,6 +312,45 @@ func TestMDNSService_HostNameQuery(t *testing.T) {
 	}
 }
 
+func TestNameKey(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"Web._HTTP._tcp.local.", "web._http._tcp.local.", true},
+		{"Café._http._tcp.local.", "CAFÉ._http._tcp.local.", true},
+		{"Caf\\195\\169._http._tcp.local.", "café._http._tcp.local.", true},
+		{"My\\ Printer._ipp._tcp.local.", "my printer._ipp._tcp.local.", true},
+		{"a\\.b.local.", "a\\046b.local.", true},
+		{"a\\.b.local.", "a.b.local.", false},
+		{"café.local.", "cafe.local.", false},
+	} {
+		if got := nameKey(test.a) == nameKey(test.b); got != test.equal {
+			t.Errorf("nameKey(%q) == nameKey(%q) is %v, want %v", test.a, test.b, got, test.equal)
+		}
+	}
+}
+
+func TestMDNSService_UnicodeInstance(t *testing.T) {
+	s, err := NewMDNSService("Café", "_http._tcp", "local.", "testhost.", 80, []net.IP{net.IPv4(192, 168, 0, 42)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The name as read from the wire, in another case.
+	q := new(dns.Msg)
+	q.SetQuestion("CAFÉ._http._tcp.local.", dns.TypeSRV)
+	buf, err := q.Pack()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := q.Unpack(buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if recs := s.Records(q.Question[0]); len(recs) == 0 {
+		t.Errorf("no records for %s", q.Question[0].Name)
+	}
+}
+
 func TestMDNSService_serviceEnum_PTR(t *testing.T) {
 	s := makeService(t)
 	q := dns.Question{
@@ -0,0 +1,89 @@
+package mdns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// WriteZone writes the unexpired records of the cache that are in the domain
+// origin, default "local.", to w as an RFC 1035 master file, so that what the
+// link advertises can be audited or loaded into another DNS server.
+//
+// The file starts with a SOA record for origin, whose serial number is the
+// current time, followed by the records sorted by name and type.  Each
+// record's TTL is its remaining lifetime in the cache.
+func (c *Cache) WriteZone(w io.Writer, origin string) error {
+	if origin == "" {
+		origin = "local."
+	}
+	origin = strings.ToLower(dns.Fqdn(origin))
+
+	var recs []dns.RR
+	for _, rr := range c.all() {
+		if dns.IsSubDomain(origin, strings.ToLower(rr.Header().Name)) {
+			// The cache-flush bit means nothing outside of mDNS.
+			rr.Header().Class &^= cacheFlushBit
+			recs = append(recs, rr)
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		a, b := recs[i].Header(), recs[j].Header()
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+		if a.Rrtype != b.Rrtype {
+			return a.Rrtype < b.Rrtype
+		}
+		return recs[i].String() < recs[j].String()
+	})
+
+	now := c.now()
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:      "ns." + origin,
+		Mbox:    "hostmaster." + origin,
+		Serial:  uint32(now.Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  defaultTTL,
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; Records learned with mDNS, as of %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(bw, "$ORIGIN %s\n", origin)
+	fmt.Fprintln(bw, soa.String())
+	for _, rr := range recs {
+		fmt.Fprintln(bw, rr.String())
+	}
+	return bw.Flush()
+}
+
+// WriteZoneFile writes the zone as by WriteZone to the named file.  The file
+// is replaced atomically, so a DNS server reloading it never sees a partially
+// written zone.
+func (c *Cache) WriteZoneFile(path, origin string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := c.WriteZone(f, origin); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
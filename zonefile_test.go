@@ -0,0 +1,57 @@
+package mdns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCache_WriteZone(t *testing.T) {
+	c, clock := makeTestCache()
+	for _, rr := range cacheTestRecords(120) {
+		c.Add(rr)
+	}
+	c.Add(&dns.A{
+		Hdr: dns.RR_Header{Name: "other.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+		A:   []byte{10, 0, 0, 1},
+	})
+	clock.advance(20 * time.Second)
+
+	var buf bytes.Buffer
+	if err := c.WriteZone(&buf, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var recs []dns.RR
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "$") {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("bad record %q: %v", line, err)
+		}
+		recs = append(recs, rr)
+	}
+	if len(recs) != 5 {
+		t.Fatalf("got %d records, want a SOA and 4 others:\n%s", len(recs), buf.String())
+	}
+	if _, ok := recs[0].(*dns.SOA); !ok || recs[0].Header().Name != "local." {
+		t.Errorf("zone does not start with the SOA of local.: %v", recs[0])
+	}
+	for _, rr := range recs[1:] {
+		h := rr.Header()
+		if h.Class != dns.ClassINET {
+			t.Errorf("record has class %d: %v", h.Class, rr)
+		}
+		if h.Ttl != 100 {
+			t.Errorf("record has TTL %d, want the remaining 100: %v", h.Ttl, rr)
+		}
+	}
+	if recs[1].Header().Name != "_foobar._tcp.local." || recs[4].Header().Name != "testhost.local." {
+		t.Errorf("records are not sorted by name:\n%s", buf.String())
+	}
+}